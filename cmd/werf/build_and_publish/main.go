@@ -92,6 +92,13 @@ If one or more IMAGE_NAME parameters specified, werf will build images stages an
 
 	common.SetupIntrospectStage(&CommonCmdData, cmd)
 
+	common.SetupAffectedSince(&CommonCmdData, cmd)
+	common.SetupParallelOptions(&CommonCmdData, cmd)
+	common.SetupReportOptions(&CommonCmdData, cmd)
+	common.SetupSignKey(&CommonCmdData, cmd)
+	common.SetupSBOMOptions(&CommonCmdData, cmd)
+	common.SetupSBOMAttach(&CommonCmdData, cmd)
+
 	cmd.Flags().BoolVarP(&CmdData.IntrospectAfterError, "introspect-error", "", false, "Introspect failed stage in the state, right after running failed assembly instruction")
 	cmd.Flags().BoolVarP(&CmdData.IntrospectBeforeError, "introspect-before-error", "", false, "Introspect failed stage in the clean state, before running all assembly instructions of the stage")
 
@@ -137,6 +144,16 @@ func runBuildAndPublish(imagesToProcess []string) error {
 		}
 	}
 
+	imagesToProcess, nothingToProcess, err := common.GetAffectedImagesToProcess(&CommonCmdData, projectDir, werfConfig, imagesToProcess)
+	if err != nil {
+		return err
+	}
+
+	if nothingToProcess {
+		logboek.LogLn("No images affected, nothing to build and publish")
+		return nil
+	}
+
 	projectName := werfConfig.Meta.Project
 
 	projectTmpDir, err := tmp_manager.CreateProjectDir()
@@ -160,7 +177,7 @@ func runBuildAndPublish(imagesToProcess []string) error {
 		return err
 	}
 
-	stagesRepo, err := common.GetStagesRepo(&CommonCmdData)
+	stagesStorage, err := common.GetStagesStorage(&CommonCmdData)
 	if err != nil {
 		return err
 	}
@@ -185,6 +202,26 @@ func runBuildAndPublish(imagesToProcess []string) error {
 		return err
 	}
 
+	parallelOptions, err := common.GetParallelOptions(&CommonCmdData)
+	if err != nil {
+		return err
+	}
+
+	reportOptions, err := common.GetReportOptions(&CommonCmdData)
+	if err != nil {
+		return err
+	}
+
+	imageSigner, err := common.GetImageSigner(&CommonCmdData, projectDir)
+	if err != nil {
+		return err
+	}
+
+	sbomOptions, err := common.GetSBOMOptions(&CommonCmdData)
+	if err != nil {
+		return err
+	}
+
 	opts := build.BuildAndPublishOptions{
 		BuildStagesOptions: build.BuildStagesOptions{
 			ImageBuildOptions: image.BuildOptions{
@@ -192,16 +229,24 @@ func runBuildAndPublish(imagesToProcess []string) error {
 				IntrospectBeforeError: CmdData.IntrospectBeforeError,
 			},
 			IntrospectOptions: introspectOptions,
+			ParallelOptions:   parallelOptions,
 		},
 		PublishImagesOptions: build.PublishImagesOptions{
-			TagOptions: tagOpts,
+			TagOptions:  tagOpts,
+			ImageSigner: imageSigner,
+			SBOMOptions: sbomOptions,
+			WithReport:  reportOptions.ReportPath != "",
 		},
 	}
 
-	c := build.NewConveyor(werfConfig, imagesToProcess, projectDir, projectTmpDir, ssh_agent.SSHAuthSock)
+	c := build.NewConveyor(werfConfig, imagesToProcess, projectDir, projectTmpDir, ssh_agent.SSHAuthSock, stagesStorage)
 	defer c.Terminate()
 
-	if err = c.BuildAndPublish(stagesRepo, imagesRepoManager, opts); err != nil {
+	if err = c.BuildAndPublish(imagesRepoManager, opts); err != nil {
+		return err
+	}
+
+	if err := c.WriteReport(reportOptions); err != nil {
 		return err
 	}
 
@@ -0,0 +1,223 @@
+package apply
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flant/kubedog/pkg/kube"
+	"github.com/flant/shluz"
+
+	"github.com/flant/werf/cmd/werf/common"
+	"github.com/flant/werf/pkg/bundle"
+	"github.com/flant/werf/pkg/deploy"
+	"github.com/flant/werf/pkg/deploy/helm"
+	"github.com/flant/werf/pkg/docker"
+	"github.com/flant/werf/pkg/docker_registry"
+	"github.com/flant/werf/pkg/slug"
+	"github.com/flant/werf/pkg/util"
+	"github.com/flant/werf/pkg/werf"
+)
+
+var CmdData struct {
+	Timeout int
+}
+
+var CommonCmdData common.CmdData
+
+func NewCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apply BUNDLE",
+		Short: "Deploy application from deployment bundle into Kubernetes",
+		Long: common.GetLongCommandDescription(`Deploy application from deployment bundle into Kubernetes.
+
+The bundle is exported by werf bundle export command. Bundled images are pushed into the specified images repo, service values of images are rewritten accordingly and bundled Helm chart is deployed the same way as werf deploy command does: command will create Helm Release and wait until all resources of the release are become ready.
+
+Helm Release name, Kubernetes Namespace and environment of the export are used by default. Values, secret values and set values of the export are passed before the specified ones.
+
+Secret key is required to decrypt bundled secrets: either $WERF_SECRET_KEY or ~/.werf/global_secret_key should be specified.`),
+		Example: `  # Deploy bundle using images repo registry.local/myproject in the isolated cluster
+  $ werf bundle apply --images-repo registry.local/myproject bundle.tar
+
+  # Deploy bundle using specified helm release name and namespace
+  $ werf bundle apply --images-repo registry.local/myproject --release myrelease --namespace myns bundle.tar`,
+		DisableFlagsInUseLine: true,
+		Annotations: map[string]string{
+			common.CmdEnvAnno: common.EnvsDescription(common.WerfSecretKey),
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.ProcessLogOptions(&CommonCmdData); err != nil {
+				common.PrintHelp(cmd)
+				return err
+			}
+
+			if len(args) != 1 {
+				common.PrintHelp(cmd)
+				return fmt.Errorf("accepts 1 position argument, received %d", len(args))
+			}
+
+			common.LogVersion()
+
+			return common.LogRunningTime(func() error {
+				return runApply(args[0])
+			})
+		},
+	}
+
+	common.SetupTmpDir(&CommonCmdData, cmd)
+	common.SetupHomeDir(&CommonCmdData, cmd)
+
+	common.SetupEnvironment(&CommonCmdData, cmd)
+	common.SetupRelease(&CommonCmdData, cmd)
+	common.SetupNamespace(&CommonCmdData, cmd)
+	common.SetupAddAnnotations(&CommonCmdData, cmd)
+	common.SetupAddLabels(&CommonCmdData, cmd)
+
+	common.SetupKubeConfig(&CommonCmdData, cmd)
+	common.SetupKubeContext(&CommonCmdData, cmd)
+	common.SetupHelmReleaseStorageNamespace(&CommonCmdData, cmd)
+	common.SetupHelmReleaseStorageType(&CommonCmdData, cmd)
+	common.SetupStatusProgressPeriod(&CommonCmdData, cmd)
+	common.SetupHooksStatusProgressPeriod(&CommonCmdData, cmd)
+	common.SetupReleasesHistoryMax(&CommonCmdData, cmd)
+
+	common.SetupImagesRepo(&CommonCmdData, cmd)
+	common.SetupImagesRepoMode(&CommonCmdData, cmd)
+	common.SetupDockerConfig(&CommonCmdData, cmd, "Command needs granted permissions to push images into the specified images repo")
+	common.SetupInsecureRegistry(&CommonCmdData, cmd)
+	common.SetupSkipTlsVerifyRegistry(&CommonCmdData, cmd)
+
+	common.SetupLogOptions(&CommonCmdData, cmd)
+
+	common.SetupSet(&CommonCmdData, cmd)
+	common.SetupSetString(&CommonCmdData, cmd)
+	common.SetupValues(&CommonCmdData, cmd)
+	common.SetupSecretValues(&CommonCmdData, cmd)
+	common.SetupIgnoreSecretKey(&CommonCmdData, cmd)
+
+	common.SetupThreeWayMergeMode(&CommonCmdData, cmd)
+
+	cmd.Flags().IntVarP(&CmdData.Timeout, "timeout", "t", 0, "Resources tracking timeout in seconds")
+
+	return cmd
+}
+
+func runApply(bundlePath string) error {
+	bundlePath = util.ExpandPath(bundlePath)
+
+	if err := werf.Init(*CommonCmdData.TmpDir, *CommonCmdData.HomeDir); err != nil {
+		return fmt.Errorf("initialization error: %s", err)
+	}
+
+	if err := shluz.Init(filepath.Join(werf.GetServiceDir(), "locks")); err != nil {
+		return err
+	}
+
+	helmReleaseStorageType, err := common.GetHelmReleaseStorageType(*CommonCmdData.HelmReleaseStorageType)
+	if err != nil {
+		return err
+	}
+
+	threeWayMergeMode, err := common.GetThreeWayMergeMode(*CommonCmdData.ThreeWayMergeMode)
+	if err != nil {
+		return err
+	}
+
+	deployInitOptions := deploy.InitOptions{
+		HelmInitOptions: helm.InitOptions{
+			KubeConfig:                  *CommonCmdData.KubeConfig,
+			KubeContext:                 *CommonCmdData.KubeContext,
+			HelmReleaseStorageNamespace: *CommonCmdData.HelmReleaseStorageNamespace,
+			HelmReleaseStorageType:      helmReleaseStorageType,
+			StatusProgressPeriod:        common.GetStatusProgressPeriod(&CommonCmdData),
+			HooksStatusProgressPeriod:   common.GetHooksStatusProgressPeriod(&CommonCmdData),
+			ReleasesMaxHistory:          *CommonCmdData.ReleasesHistoryMax,
+			InitNamespace:               true,
+		},
+	}
+	if err := deploy.Init(deployInitOptions); err != nil {
+		return err
+	}
+
+	if err := docker_registry.Init(docker_registry.Options{InsecureRegistry: *CommonCmdData.InsecureRegistry, SkipTlsVerifyRegistry: *CommonCmdData.SkipTlsVerifyRegistry}); err != nil {
+		return err
+	}
+
+	if err := docker.Init(*CommonCmdData.DockerConfig); err != nil {
+		return err
+	}
+
+	if err := kube.Init(kube.InitOptions{KubeContext: *CommonCmdData.KubeContext, KubeConfig: *CommonCmdData.KubeConfig}); err != nil {
+		return fmt.Errorf("cannot initialize kube: %s", err)
+	}
+
+	if err := common.InitKubedog(); err != nil {
+		return fmt.Errorf("cannot init kubedog: %s", err)
+	}
+
+	manifest, err := bundle.ReadManifest(bundlePath)
+	if err != nil {
+		return err
+	}
+
+	imagesRepoManager := &common.ImagesRepoManager{}
+	if len(manifest.Images) != 0 {
+		imagesRepo, err := common.GetImagesRepo(manifest.Project, &CommonCmdData)
+		if err != nil {
+			return err
+		}
+
+		imagesRepoMode, err := common.GetImagesRepoMode(&CommonCmdData)
+		if err != nil {
+			return err
+		}
+
+		imagesRepoManager, err = common.GetImagesRepoManager(imagesRepo, imagesRepoMode)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *CommonCmdData.Release != "" {
+		if err := slug.ValidateHelmRelease(*CommonCmdData.Release); err != nil {
+			return fmt.Errorf("bad Helm release specified '%s': %s", *CommonCmdData.Release, err)
+		}
+	}
+
+	if *CommonCmdData.Namespace != "" {
+		if err := slug.ValidateKubernetesNamespace(*CommonCmdData.Namespace); err != nil {
+			return fmt.Errorf("bad Kubernetes namespace specified '%s': %s", *CommonCmdData.Namespace, err)
+		}
+	}
+
+	userExtraAnnotations, err := common.GetUserExtraAnnotations(&CommonCmdData)
+	if err != nil {
+		return err
+	}
+
+	userExtraLabels, err := common.GetUserExtraLabels(&CommonCmdData)
+	if err != nil {
+		return err
+	}
+
+	return bundle.Apply(bundlePath, imagesRepoManager, bundle.ApplyOptions{
+		Release:                     *CommonCmdData.Release,
+		Namespace:                   *CommonCmdData.Namespace,
+		HelmReleaseStorageNamespace: *CommonCmdData.HelmReleaseStorageNamespace,
+		HelmReleaseStorageType:      helmReleaseStorageType,
+		DeployOptions: deploy.DeployOptions{
+			Set:                  *CommonCmdData.Set,
+			SetString:            *CommonCmdData.SetString,
+			Values:               *CommonCmdData.Values,
+			SecretValues:         *CommonCmdData.SecretValues,
+			Timeout:              time.Duration(CmdData.Timeout) * time.Second,
+			Env:                  *CommonCmdData.Environment,
+			UserExtraAnnotations: userExtraAnnotations,
+			UserExtraLabels:      userExtraLabels,
+			IgnoreSecretKey:      *CommonCmdData.IgnoreSecretKey,
+			ThreeWayMergeMode:    threeWayMergeMode,
+		},
+	})
+}
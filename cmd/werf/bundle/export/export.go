@@ -0,0 +1,157 @@
+package export
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flant/shluz"
+
+	"github.com/flant/werf/cmd/werf/common"
+	"github.com/flant/werf/pkg/bundle"
+	"github.com/flant/werf/pkg/docker"
+	"github.com/flant/werf/pkg/docker_registry"
+	"github.com/flant/werf/pkg/tag_strategy"
+	"github.com/flant/werf/pkg/util"
+	"github.com/flant/werf/pkg/werf"
+)
+
+var CmdData struct {
+	Output string
+}
+
+var CommonCmdData common.CmdData
+
+func NewCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export deployment bundle",
+		Long: common.GetLongCommandDescription(`Export deployment bundle into one tarball.
+
+The bundle contains published images pulled by digest, Helm chart .helm rendered the same way as for deploy (.helmignore is applied, subcharts are packed, secrets are kept encrypted), values, secret values and service values fixed to the bundled images. The bundle can be deployed with werf bundle apply command without access to the images repo and git, e.g. into air-gapped cluster.
+
+Export needs the same parameters as deploy to construct image names: repo and tags. So images should be published prior running export. Helm Release name and Kubernetes Namespace are saved into the bundle and used by werf bundle apply by default.
+
+Secret key is not saved into the bundle: secrets are decrypted by werf bundle apply.`),
+		Example: `  # Export bundle of project images from registry.mydomain.com/myproject tagged as mytag with git-tag tagging strategy for 'production' environment
+  $ werf bundle export --env production --images-repo registry.mydomain.com/myproject --tag-git-tag mytag --output bundle.tar`,
+		DisableFlagsInUseLine: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.ProcessLogOptions(&CommonCmdData); err != nil {
+				common.PrintHelp(cmd)
+				return err
+			}
+			common.LogVersion()
+
+			return common.LogRunningTime(func() error {
+				return runExport()
+			})
+		},
+	}
+
+	common.SetupDir(&CommonCmdData, cmd)
+	common.SetupTmpDir(&CommonCmdData, cmd)
+	common.SetupHomeDir(&CommonCmdData, cmd)
+
+	common.SetupTag(&CommonCmdData, cmd)
+	common.SetupEnvironment(&CommonCmdData, cmd)
+	common.SetupRelease(&CommonCmdData, cmd)
+	common.SetupNamespace(&CommonCmdData, cmd)
+
+	common.SetupImagesRepo(&CommonCmdData, cmd)
+	common.SetupImagesRepoMode(&CommonCmdData, cmd)
+	common.SetupDockerConfig(&CommonCmdData, cmd, "Command needs granted permissions to read and pull images from the specified images repo")
+	common.SetupInsecureRegistry(&CommonCmdData, cmd)
+	common.SetupSkipTlsVerifyRegistry(&CommonCmdData, cmd)
+
+	common.SetupLogOptions(&CommonCmdData, cmd)
+	common.SetupLogProjectDir(&CommonCmdData, cmd)
+
+	common.SetupSet(&CommonCmdData, cmd)
+	common.SetupSetString(&CommonCmdData, cmd)
+	common.SetupValues(&CommonCmdData, cmd)
+	common.SetupSecretValues(&CommonCmdData, cmd)
+
+	cmd.Flags().StringVarP(&CmdData.Output, "output", "o", "", "Path to the bundle tarball (required)")
+
+	return cmd
+}
+
+func runExport() error {
+	if CmdData.Output == "" {
+		return fmt.Errorf("--output PATH param required")
+	}
+
+	if err := werf.Init(*CommonCmdData.TmpDir, *CommonCmdData.HomeDir); err != nil {
+		return fmt.Errorf("initialization error: %s", err)
+	}
+
+	if err := shluz.Init(filepath.Join(werf.GetServiceDir(), "locks")); err != nil {
+		return err
+	}
+
+	if err := docker_registry.Init(docker_registry.Options{InsecureRegistry: *CommonCmdData.InsecureRegistry, SkipTlsVerifyRegistry: *CommonCmdData.SkipTlsVerifyRegistry}); err != nil {
+		return err
+	}
+
+	if err := docker.Init(*CommonCmdData.DockerConfig); err != nil {
+		return err
+	}
+
+	projectDir, err := common.GetProjectDir(&CommonCmdData)
+	if err != nil {
+		return fmt.Errorf("getting project dir failed: %s", err)
+	}
+
+	common.ProcessLogProjectDir(&CommonCmdData, projectDir)
+
+	werfConfig, err := common.GetWerfConfig(projectDir)
+	if err != nil {
+		return fmt.Errorf("bad config: %s", err)
+	}
+
+	imagesRepoManager := &common.ImagesRepoManager{}
+	var tag string
+	var tagStrategy tag_strategy.TagStrategy
+	if len(werfConfig.StapelImages) != 0 || len(werfConfig.ImagesFromDockerfile) != 0 {
+		imagesRepo, err := common.GetImagesRepo(werfConfig.Meta.Project, &CommonCmdData)
+		if err != nil {
+			return err
+		}
+
+		imagesRepoMode, err := common.GetImagesRepoMode(&CommonCmdData)
+		if err != nil {
+			return err
+		}
+
+		imagesRepoManager, err = common.GetImagesRepoManager(imagesRepo, imagesRepoMode)
+		if err != nil {
+			return err
+		}
+
+		tag, tagStrategy, err = common.GetDeployTag(&CommonCmdData, common.TagOptionsGetterOptions{})
+		if err != nil {
+			return err
+		}
+	}
+
+	release, err := common.GetHelmRelease(*CommonCmdData.Release, *CommonCmdData.Environment, werfConfig)
+	if err != nil {
+		return err
+	}
+
+	namespace, err := common.GetKubernetesNamespace(*CommonCmdData.Namespace, *CommonCmdData.Environment, werfConfig)
+	if err != nil {
+		return err
+	}
+
+	return bundle.Export(projectDir, werfConfig, imagesRepoManager, release, namespace, tag, tagStrategy, bundle.ExportOptions{
+		OutputPath:   util.ExpandPath(CmdData.Output),
+		Env:          *CommonCmdData.Environment,
+		Values:       *CommonCmdData.Values,
+		SecretValues: *CommonCmdData.SecretValues,
+		Set:          *CommonCmdData.Set,
+		SetString:    *CommonCmdData.SetString,
+	})
+}
@@ -56,6 +56,8 @@ It is safe to run this command periodically (daily is enough) by automated clean
 	common.SetupDockerConfig(&CommonCmdData, cmd, "Command needs granted permissions to read, pull and delete images from the specified stages storage and images repo")
 	common.SetupInsecureRegistry(&CommonCmdData, cmd)
 	common.SetupSkipTlsVerifyRegistry(&CommonCmdData, cmd)
+	common.SetupRepoImplementation(&CommonCmdData, cmd)
+	common.SetupPlatform(&CommonCmdData, cmd)
 	common.SetupImagesCleanupPolicies(&CommonCmdData, cmd)
 
 	common.SetupKubeConfig(&CommonCmdData, cmd)
@@ -80,7 +82,7 @@ func runCleanup() error {
 		return err
 	}
 
-	if err := docker_registry.Init(docker_registry.Options{InsecureRegistry: *CommonCmdData.InsecureRegistry, SkipTlsVerifyRegistry: *CommonCmdData.SkipTlsVerifyRegistry}); err != nil {
+	if err := docker_registry.Init(docker_registry.Options{InsecureRegistry: *CommonCmdData.InsecureRegistry, SkipTlsVerifyRegistry: *CommonCmdData.SkipTlsVerifyRegistry, RepoImplementation: *CommonCmdData.RepoImplementation, Platform: *CommonCmdData.Platform}); err != nil {
 		return err
 	}
 
@@ -131,7 +133,7 @@ func runCleanup() error {
 		return err
 	}
 
-	stagesRepo, err := common.GetStagesRepo(&CommonCmdData)
+	stagesStorage, err := common.GetStagesStorage(&CommonCmdData)
 	if err != nil {
 		return err
 	}
@@ -181,7 +183,7 @@ func runCleanup() error {
 	stagesCleanupOptions := cleaning.StagesCleanupOptions{
 		ProjectName:       projectName,
 		ImagesRepoManager: imagesRepoManager,
-		StagesStorage:     stagesRepo,
+		StagesStorage:     stagesStorage,
 		ImagesNames:       imagesNames,
 		DryRun:            *CommonCmdData.DryRun,
 	}
@@ -15,12 +15,17 @@ import (
 	"github.com/flant/kubedog/pkg/kube"
 	"github.com/flant/logboek"
 
+	"github.com/flant/werf/pkg/affected"
 	"github.com/flant/werf/pkg/build"
 	"github.com/flant/werf/pkg/build/stage"
 	cleanup "github.com/flant/werf/pkg/cleaning"
+	"github.com/flant/werf/pkg/compose"
 	"github.com/flant/werf/pkg/config"
 	"github.com/flant/werf/pkg/deploy/helm"
+	"github.com/flant/werf/pkg/docker_registry"
 	"github.com/flant/werf/pkg/logging"
+	"github.com/flant/werf/pkg/stages_storage"
+	"github.com/flant/werf/pkg/true_git"
 	"github.com/flant/werf/pkg/util"
 	"github.com/flant/werf/pkg/werf"
 )
@@ -62,6 +67,8 @@ type CmdData struct {
 	DockerConfig          *string
 	InsecureRegistry      *bool
 	SkipTlsVerifyRegistry *bool
+	RepoImplementation    *string
+	Platform              *string
 	DryRun                *bool
 
 	GitTagStrategyLimit         *int64
@@ -73,6 +80,26 @@ type CmdData struct {
 
 	StagesToIntrospect *[]string
 
+	Parallel           *bool
+	ParallelTasksLimit *int64
+
+	Dev                 *bool
+	DevIncludeUntracked *bool
+
+	AffectedSince *string
+
+	ReportPath   *string
+	ReportFormat *string
+
+	SignKey    *string
+	VerifyKeys *[]string
+
+	SBOM       *string
+	SBOMDir    *string
+	SBOMAttach *bool
+
+	ComposeFile *string
+
 	LogPretty        *bool
 	LogColorMode     *string
 	LogProjectDir    *bool
@@ -218,7 +245,71 @@ func SetupHelmReleaseStorageType(cmdData *CmdData, cmd *cobra.Command) {
 
 func SetupStagesStorage(cmdData *CmdData, cmd *cobra.Command) {
 	cmdData.StagesStorage = new(string)
-	cmd.Flags().StringVarP(cmdData.StagesStorage, "stages-storage", "s", os.Getenv("WERF_STAGES_STORAGE"), "Docker Repo to store stages or :local for non-distributed build (only :local is supported for now; default $WERF_STAGES_STORAGE environment).\nMore info about stages: https://werf.io/documentation/reference/stages_and_images.html")
+	cmd.Flags().StringVarP(cmdData.StagesStorage, "stages-storage", "s", os.Getenv("WERF_STAGES_STORAGE"), "Docker Repo to store stages, archive:PATH to store stages as docker save archives in the directory PATH or :local for non-distributed build (default $WERF_STAGES_STORAGE environment).\nMore info about stages: https://werf.io/documentation/reference/stages_and_images.html")
+}
+
+func SetupParallelOptions(cmdData *CmdData, cmd *cobra.Command) {
+	SetupParallel(cmdData, cmd)
+	SetupParallelTasksLimit(cmdData, cmd)
+}
+
+func SetupParallel(cmdData *CmdData, cmd *cobra.Command) {
+	cmdData.Parallel = new(bool)
+	cmd.Flags().BoolVarP(cmdData.Parallel, "parallel", "", GetBoolEnvironment("WERF_PARALLEL"), "Build independent images and artifacts in parallel (default $WERF_PARALLEL)")
+}
+
+func SetupParallelTasksLimit(cmdData *CmdData, cmd *cobra.Command) {
+	cmdData.ParallelTasksLimit = new(int64)
+
+	defaultValueP, err := getInt64EnvVar("WERF_PARALLEL_TASKS_LIMIT")
+	if err != nil {
+		TerminateWithError(fmt.Sprintf("bad WERF_PARALLEL_TASKS_LIMIT value: %s", err), 1)
+	}
+
+	var defaultValue int64
+	if defaultValueP != nil {
+		defaultValue = *defaultValueP
+	}
+
+	cmd.Flags().Int64VarP(cmdData.ParallelTasksLimit, "parallel-tasks-limit", "", defaultValue, "Parallel tasks limit (default $WERF_PARALLEL_TASKS_LIMIT or the number of CPUs)")
+}
+
+func SetupDevOptions(cmdData *CmdData, cmd *cobra.Command) {
+	SetupDev(cmdData, cmd)
+	SetupDevIncludeUntracked(cmdData, cmd)
+}
+
+func SetupDev(cmdData *CmdData, cmd *cobra.Command) {
+	cmdData.Dev = new(bool)
+	cmd.Flags().BoolVarP(cmdData.Dev, "dev", "", GetBoolEnvironment("WERF_DEV"), "Enable developer mode: local git mappings include staged and unstaged changes of tracked files, images with uncommitted changes cannot be published (default $WERF_DEV)")
+}
+
+func SetupDevIncludeUntracked(cmdData *CmdData, cmd *cobra.Command) {
+	cmdData.DevIncludeUntracked = new(bool)
+	cmd.Flags().BoolVarP(cmdData.DevIncludeUntracked, "dev-include-untracked", "", GetBoolEnvironment("WERF_DEV_INCLUDE_UNTRACKED"), "Include untracked files not ignored by .gitignore into local git mappings in developer mode (default $WERF_DEV_INCLUDE_UNTRACKED)")
+}
+
+func SetupAffectedSince(cmdData *CmdData, cmd *cobra.Command) {
+	cmdData.AffectedSince = new(string)
+	cmd.Flags().StringVarP(cmdData.AffectedSince, "affected-since", "", os.Getenv("WERF_AFFECTED_SINCE"), "Process only images affected by git changes since the merge base of HEAD and the specified git ref (branch, tag or commit): images with changed files in local git mappings or dockerfile context and images depending on them (default $WERF_AFFECTED_SINCE)")
+}
+
+func SetupReportOptions(cmdData *CmdData, cmd *cobra.Command) {
+	cmdData.ReportPath = new(string)
+	cmd.Flags().StringVarP(cmdData.ReportPath, "report-path", "", os.Getenv("WERF_REPORT_PATH"), "Write the build report with images, stages and published tags to the specified file (default $WERF_REPORT_PATH)")
+
+	defaultReportFormat := os.Getenv("WERF_REPORT_FORMAT")
+	if defaultReportFormat == "" {
+		defaultReportFormat = string(build.ReportJSON)
+	}
+
+	cmdData.ReportFormat = new(string)
+	cmd.Flags().StringVarP(cmdData.ReportFormat, "report-format", "", defaultReportFormat, "Report format: json (default $WERF_REPORT_FORMAT or json)")
+}
+
+func SetupComposeFile(cmdData *CmdData, cmd *cobra.Command) {
+	cmdData.ComposeFile = new(string)
+	cmd.Flags().StringVarP(cmdData.ComposeFile, "compose-file", "", os.Getenv("WERF_COMPOSE_FILE"), fmt.Sprintf("Path to the compose file relative to the project directory (default $WERF_COMPOSE_FILE or %s)", compose.DefaultConfigFileName))
 }
 
 func SetupStatusProgressPeriod(cmdData *CmdData, cmd *cobra.Command) {
@@ -316,6 +407,16 @@ func SetupInsecureRegistry(cmdData *CmdData, cmd *cobra.Command) {
 	cmd.Flags().BoolVarP(cmdData.InsecureRegistry, "insecure-registry", "", GetBoolEnvironment("WERF_INSECURE_REGISTRY"), "Use plain HTTP requests when accessing a registry (default $WERF_INSECURE_REGISTRY)")
 }
 
+func SetupRepoImplementation(cmdData *CmdData, cmd *cobra.Command) {
+	cmdData.RepoImplementation = new(string)
+	cmd.Flags().StringVarP(cmdData.RepoImplementation, "repo-implementation", "", os.Getenv("WERF_REPO_IMPLEMENTATION"), fmt.Sprintf("Use the registry vendor API to list and delete images: %s (default $WERF_REPO_IMPLEMENTATION or detected by the registry hostname)", strings.Join(docker_registry.ImplementationNames, ", ")))
+}
+
+func SetupPlatform(cmdData *CmdData, cmd *cobra.Command) {
+	cmdData.Platform = new(string)
+	cmd.Flags().StringVarP(cmdData.Platform, "platform", "", os.Getenv("WERF_PLATFORM"), "Select the image of the index (manifest list) in the registry by OS/ARCH[/VARIANT] (default $WERF_PLATFORM or linux/amd64)")
+}
+
 func SetupSkipTlsVerifyRegistry(cmdData *CmdData, cmd *cobra.Command) {
 	cmdData.SkipTlsVerifyRegistry = new(bool)
 	cmd.Flags().BoolVarP(cmdData.SkipTlsVerifyRegistry, "skip-tls-verify-registry", "", GetBoolEnvironment("WERF_SKIP_TLS_VERIFY_REGISTRY"), "Skip TLS certificate validation when accessing a registry (default $WERF_SKIP_TLS_VERIFY_REGISTRY)")
@@ -614,13 +715,19 @@ func GetImagesCleanupPolicies(cmdData *CmdData) (cleanup.ImagesCleanupPolicies,
 	return res, nil
 }
 
-func GetStagesRepo(cmdData *CmdData) (string, error) {
-	if *cmdData.StagesStorage == "" {
-		return "", fmt.Errorf("--stages-storage :local param required")
-	} else if *cmdData.StagesStorage != ":local" {
-		return "", fmt.Errorf("only --stages-storage :local is supported for now, got '%s'", *cmdData.StagesStorage)
+func GetStagesStorage(cmdData *CmdData) (stages_storage.StagesStorage, error) {
+	stagesStorageOption := *cmdData.StagesStorage
+
+	if stagesStorageOption == "" {
+		return nil, fmt.Errorf("--stages-storage :local, --stages-storage REPO or --stages-storage %sPATH param required", stages_storage.ArchiveStagesStorageAddress)
 	}
-	return *cmdData.StagesStorage, nil
+
+	stagesStorage, err := stages_storage.NewStagesStorage(stagesStorageOption)
+	if err != nil {
+		return nil, fmt.Errorf("bad --stages-storage '%s': %s", stagesStorageOption, err)
+	}
+
+	return stagesStorage, nil
 }
 
 func GetImagesRepo(projectName string, cmdData *CmdData) (string, error) {
@@ -678,6 +785,19 @@ func GetWerfConfigPath(projectDir string) (string, error) {
 	return "", errors.New("werf.yaml not found")
 }
 
+func GetComposeConfig(projectDir string, cmdData *CmdData) (*compose.Config, error) {
+	composeFilePath := *cmdData.ComposeFile
+	if composeFilePath == "" {
+		composeFilePath = compose.DefaultConfigFileName
+	}
+
+	if !filepath.IsAbs(composeFilePath) {
+		composeFilePath = filepath.Join(projectDir, composeFilePath)
+	}
+
+	return compose.ReadConfig(composeFilePath)
+}
+
 func GetProjectDir(cmdData *CmdData) (string, error) {
 	currentDir, err := os.Getwd()
 	if err != nil {
@@ -744,6 +864,100 @@ func GetIntrospectOptions(cmdData *CmdData, werfConfig *config.WerfConfig) (buil
 	return introspectOptions, nil
 }
 
+func GetDevOptions(cmdData *CmdData) (build.DevOptions, error) {
+	if *cmdData.DevIncludeUntracked && !*cmdData.Dev {
+		return build.DevOptions{}, fmt.Errorf("--dev-include-untracked can be used only with --dev")
+	}
+
+	return build.DevOptions{
+		Dev:              *cmdData.Dev,
+		IncludeUntracked: *cmdData.DevIncludeUntracked,
+	}, nil
+}
+
+// GetAffectedImagesToProcess narrows images to process down to the images affected by git changes since --affected-since ref.
+// Specified images are returned as is without --affected-since, nothingToProcess is true when no image is affected
+func GetAffectedImagesToProcess(cmdData *CmdData, projectDir string, werfConfig *config.WerfConfig, imagesToProcess []string) ([]string, bool, error) {
+	if *cmdData.AffectedSince == "" {
+		return imagesToProcess, false, nil
+	}
+
+	changedFiles, err := true_git.ChangedFilesSince(projectDir, *cmdData.AffectedSince)
+	if err != nil {
+		return nil, false, err
+	}
+
+	affectedImages := affected.Images(werfConfig, changedFiles)
+
+	var res []string
+	err = logboek.LogProcess(fmt.Sprintf("Selecting images affected since %s", *cmdData.AffectedSince), logboek.LogProcessOptions{}, func() error {
+		logboek.LogF("Changed files: %d\n", len(changedFiles))
+
+		isSelected := map[string]bool{}
+		for _, image := range affectedImages {
+			if len(imagesToProcess) != 0 && !util.IsStringsContainValue(imagesToProcess, image.Name) {
+				continue
+			}
+
+			isSelected[image.Name] = true
+			res = append(res, image.Name)
+
+			logboek.LogLn()
+			logboek.LogF("%s selected:\n", logging.ImageLogProcessName(image.Name, false))
+			for _, reason := range image.Reasons {
+				logboek.LogF("  - %s\n", reason)
+			}
+		}
+
+		var skippedImages []string
+		for _, image := range werfConfig.GetAllImages() {
+			if len(imagesToProcess) != 0 && !util.IsStringsContainValue(imagesToProcess, image.GetName()) {
+				continue
+			}
+
+			if !isSelected[image.GetName()] {
+				skippedImages = append(skippedImages, logging.ImageLogName(image.GetName(), false))
+			}
+		}
+
+		if len(skippedImages) != 0 {
+			logboek.LogLn()
+			logboek.LogF("Skipped as not affected: %s\n", strings.Join(skippedImages, ", "))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return res, len(res) == 0, nil
+}
+
+func GetParallelOptions(cmdData *CmdData) (build.ParallelOptions, error) {
+	if *cmdData.ParallelTasksLimit < 0 {
+		return build.ParallelOptions{}, fmt.Errorf("bad --parallel-tasks-limit value %d: should be greater than or equal to 0", *cmdData.ParallelTasksLimit)
+	}
+
+	return build.ParallelOptions{
+		Parallel:           *cmdData.Parallel,
+		ParallelTasksLimit: int(*cmdData.ParallelTasksLimit),
+	}, nil
+}
+
+func GetReportOptions(cmdData *CmdData) (build.ReportOptions, error) {
+	switch build.ReportFormat(*cmdData.ReportFormat) {
+	case build.ReportJSON:
+	default:
+		return build.ReportOptions{}, fmt.Errorf("bad --report-format value '%s': only json format is supported", *cmdData.ReportFormat)
+	}
+
+	return build.ReportOptions{
+		ReportPath:   *cmdData.ReportPath,
+		ReportFormat: build.ReportFormat(*cmdData.ReportFormat),
+	}, nil
+}
+
 func LogKubeContext(kubeContext string) {
 	if kubeContext != "" {
 		logboek.LogF("Using kube context: %s\n", kubeContext)
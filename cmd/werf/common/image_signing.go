@@ -0,0 +1,91 @@
+package common
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flant/werf/pkg/deploy/secret"
+	"github.com/flant/werf/pkg/image_signing"
+)
+
+func SetupSignKey(cmdData *CmdData, cmd *cobra.Command) {
+	cmdData.SignKey = new(string)
+	cmd.Flags().StringVarP(cmdData.SignKey, "sign-key", "", os.Getenv("WERF_SIGN_KEY"), `Sign published images with the ECDSA private key in PEM format (default $WERF_SIGN_KEY).
+The key file can be encrypted with the werf secret key (werf helm secret file encrypt).
+Signatures are stored in the images repo by the cosign convention`)
+}
+
+func SetupVerifyKey(cmdData *CmdData, cmd *cobra.Command) {
+	var verifyKeys []string
+	for _, keyValue := range os.Environ() {
+		parts := strings.SplitN(keyValue, "=", 2)
+		if strings.HasPrefix(parts[0], "WERF_VERIFY_KEY") {
+			verifyKeys = append(verifyKeys, parts[1])
+		}
+	}
+
+	cmdData.VerifyKeys = &verifyKeys
+	cmd.Flags().StringArrayVarP(cmdData.VerifyKeys, "verify-key", "", verifyKeys, `Refuse to deploy images which are not signed with one of the specified ECDSA public keys in PEM format (e.g. cosign.pub).
+Option can be specified multiple times to use multiple keys.
+Also can be specified in $WERF_VERIFY_KEY* (e.g. $WERF_VERIFY_KEY_CI=ci.pub, $WERF_VERIFY_KEY_RELEASE=release.pub)`)
+}
+
+// GetImageSigner returns nil if the sign key is not specified
+func GetImageSigner(cmdData *CmdData, projectDir string) (*image_signing.Signer, error) {
+	if *cmdData.SignKey == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(*cmdData.SignKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read sign key: %s", err)
+	}
+
+	if !image_signing.IsPemData(data) {
+		m, err := secret.GetManager(projectDir)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err = m.Decrypt([]byte(strings.TrimSpace(string(data))))
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt sign key %s: %s", *cmdData.SignKey, err)
+		}
+	}
+
+	key, err := image_signing.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("bad sign key %s: %s", *cmdData.SignKey, err)
+	}
+
+	return image_signing.NewSigner(key), nil
+}
+
+// GetImageVerifier returns nil if the verify keys are not specified
+func GetImageVerifier(cmdData *CmdData) (*image_signing.Verifier, error) {
+	if len(*cmdData.VerifyKeys) == 0 {
+		return nil, nil
+	}
+
+	var keys []*ecdsa.PublicKey
+	for _, path := range *cmdData.VerifyKeys {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read verify key: %s", err)
+		}
+
+		key, err := image_signing.ParsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("bad verify key %s: %s", path, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return image_signing.NewVerifier(keys), nil
+}
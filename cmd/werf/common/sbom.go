@@ -0,0 +1,47 @@
+package common
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flant/werf/pkg/build"
+	"github.com/flant/werf/pkg/sbom"
+)
+
+func SetupSBOMOptions(cmdData *CmdData, cmd *cobra.Command) {
+	cmdData.SBOM = new(string)
+	cmd.Flags().StringVarP(cmdData.SBOM, "sbom", "", os.Getenv("WERF_SBOM"), fmt.Sprintf(`Generate the software bill of materials of the images in the specified format: %s or %s (default $WERF_SBOM).
+SBOM contains the base image, the commits of git mappings, the imports and the packages of the image filesystem (dpkg, apk and rpm databases, language lockfiles)`, sbom.SPDX, sbom.CycloneDX))
+
+	cmdData.SBOMDir = new(string)
+	cmd.Flags().StringVarP(cmdData.SBOMDir, "sbom-dir", "", os.Getenv("WERF_SBOM_DIR"), "Write SBOM files to the specified directory (default $WERF_SBOM_DIR or current directory)")
+}
+
+func SetupSBOMAttach(cmdData *CmdData, cmd *cobra.Command) {
+	cmdData.SBOMAttach = new(bool)
+	cmd.Flags().BoolVarP(cmdData.SBOMAttach, "sbom-attach", "", GetBoolEnvironment("WERF_SBOM_ATTACH"), "Attach SBOM to the published images in the images repo by the cosign convention (default $WERF_SBOM_ATTACH)")
+}
+
+func GetSBOMOptions(cmdData *CmdData) (build.SBOMOptions, error) {
+	if *cmdData.SBOM == "" {
+		if cmdData.SBOMAttach != nil && *cmdData.SBOMAttach {
+			return build.SBOMOptions{}, fmt.Errorf("--sbom-attach requires --sbom format")
+		}
+
+		return build.SBOMOptions{}, nil
+	}
+
+	format, err := sbom.ParseFormat(*cmdData.SBOM)
+	if err != nil {
+		return build.SBOMOptions{}, fmt.Errorf("bad --sbom value: %s", err)
+	}
+
+	opts := build.SBOMOptions{Format: format, Dir: *cmdData.SBOMDir}
+	if cmdData.SBOMAttach != nil {
+		opts.Attach = *cmdData.SBOMAttach
+	}
+
+	return opts, nil
+}
@@ -1,22 +1,26 @@
 package common
 
 import (
+	"context"
 	"os"
 	"os/signal"
 	"syscall"
+
+	"github.com/flant/logboek"
 )
 
 var (
 	terminationSignalsTrapEnabled     bool
 	terminationSignalsChan            chan os.Signal
 	disableTerminationSignalsTrapChan chan struct{}
+
+	terminationSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT}
 )
 
 func EnableTerminationSignalsTrap() {
 	disableTerminationSignalsTrapChan = make(chan struct{}, 1)
 	terminationSignalsChan = make(chan os.Signal, 1)
-	signals := []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT}
-	signal.Notify(terminationSignalsChan, signals...)
+	signal.Notify(terminationSignalsChan, terminationSignals...)
 
 	go func() {
 		select {
@@ -52,6 +56,43 @@ func WithoutTerminationSignalsTrap(f func() error) error {
 	return f()
 }
 
+// WithTerminationSignalsContext replaces the default trap while f is running: the first termination signal cancels
+// the context of f, so that f can stop the long-running command, clean up and return. The next signal terminates werf immediately
+func WithTerminationSignalsContext(f func(ctx context.Context) error) error {
+	return WithoutTerminationSignalsTrap(func() error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		signalsChan := make(chan os.Signal, 1)
+		doneChan := make(chan struct{})
+		signal.Notify(signalsChan, terminationSignals...)
+
+		defer func() {
+			signal.Stop(signalsChan)
+			close(doneChan)
+		}()
+
+		go func() {
+			select {
+			case <-signalsChan:
+				logboek.LogErrorF("Interrupted, stopping (press Ctrl+C again to terminate immediately)\n")
+				cancel()
+			case <-doneChan:
+				return
+			}
+
+			select {
+			case <-signalsChan:
+				TerminateWithError("interrupted", 17)
+			case <-doneChan:
+				return
+			}
+		}()
+
+		return f(ctx)
+	})
+}
+
 func WithTerminationSignalsTrap(f func() error) error {
 	savedTrapEnabled := terminationSignalsTrapEnabled
 
@@ -0,0 +1,29 @@
+package common
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWithTerminationSignalsContext(t *testing.T) {
+	err := WithTerminationSignalsContext(func(ctx context.Context) error {
+		if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(10 * time.Second):
+			t.Error("context is not cancelled by termination signal")
+			return nil
+		}
+	})
+
+	if err != nil {
+		t.Error(err)
+	}
+}
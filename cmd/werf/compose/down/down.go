@@ -0,0 +1,81 @@
+package down
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flant/werf/cmd/werf/common"
+	"github.com/flant/werf/pkg/compose"
+	"github.com/flant/werf/pkg/docker"
+	"github.com/flant/werf/pkg/werf"
+)
+
+var CmdData struct {
+	Volumes bool
+}
+
+var CommonCmdData common.CmdData
+
+func NewCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "down",
+		Short: "Stop and remove containers and network of the compose file services",
+		Long: common.GetLongCommandDescription(`Stop and remove containers and network of the compose file services.
+
+Named volumes are kept by default to preserve services data between runs, use --volumes option to remove them.`),
+		Example: `  # Stop and remove all containers and network of the project services
+  $ werf compose down
+
+  # Also remove named volumes of the project services
+  $ werf compose down --volumes`,
+		DisableFlagsInUseLine: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.ProcessLogOptions(&CommonCmdData); err != nil {
+				common.PrintHelp(cmd)
+				return err
+			}
+
+			return runDown()
+		},
+	}
+
+	common.SetupDir(&CommonCmdData, cmd)
+	common.SetupTmpDir(&CommonCmdData, cmd)
+	common.SetupHomeDir(&CommonCmdData, cmd)
+
+	common.SetupDockerConfig(&CommonCmdData, cmd, "")
+
+	common.SetupLogOptions(&CommonCmdData, cmd)
+	common.SetupLogProjectDir(&CommonCmdData, cmd)
+
+	cmd.Flags().BoolVarP(&CmdData.Volumes, "volumes", "", false, "Remove named volumes of the project services")
+
+	return cmd
+}
+
+func runDown() error {
+	if err := werf.Init(*CommonCmdData.TmpDir, *CommonCmdData.HomeDir); err != nil {
+		return fmt.Errorf("initialization error: %s", err)
+	}
+
+	if err := docker.Init(*CommonCmdData.DockerConfig); err != nil {
+		return err
+	}
+
+	projectDir, err := common.GetProjectDir(&CommonCmdData)
+	if err != nil {
+		return fmt.Errorf("getting project dir failed: %s", err)
+	}
+
+	common.ProcessLogProjectDir(&CommonCmdData, projectDir)
+
+	werfConfig, err := common.GetWerfConfig(projectDir)
+	if err != nil {
+		return fmt.Errorf("bad config: %s", err)
+	}
+
+	project := &compose.Project{Name: werfConfig.Meta.Project, ProjectDir: projectDir}
+
+	return project.Down(compose.DownOptions{RemoveVolumes: CmdData.Volumes})
+}
@@ -0,0 +1,102 @@
+package logs
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flant/logboek"
+
+	"github.com/flant/werf/cmd/werf/common"
+	"github.com/flant/werf/pkg/compose"
+	"github.com/flant/werf/pkg/docker"
+	"github.com/flant/werf/pkg/werf"
+)
+
+var CmdData struct {
+	Follow bool
+	Tail   string
+}
+
+var CommonCmdData common.CmdData
+
+func NewCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logs [SERVICE...]",
+		Short: "Print logs of the compose file services containers",
+		Long: common.GetLongCommandDescription(`Print logs of the compose file services containers.
+
+Each line is prefixed with the service name. If one or more SERVICE parameters specified, werf will print logs only of these services.`),
+		Example: `  # Print logs of all services
+  $ werf compose logs
+
+  # Follow logs of service 'backend' starting from the last 10 lines
+  $ werf compose logs --follow --tail 10 backend`,
+		DisableFlagsInUseLine: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.ProcessLogOptions(&CommonCmdData); err != nil {
+				common.PrintHelp(cmd)
+				return err
+			}
+
+			return runLogs(args)
+		},
+	}
+
+	common.SetupDir(&CommonCmdData, cmd)
+	common.SetupTmpDir(&CommonCmdData, cmd)
+	common.SetupHomeDir(&CommonCmdData, cmd)
+
+	common.SetupComposeFile(&CommonCmdData, cmd)
+
+	common.SetupDockerConfig(&CommonCmdData, cmd, "")
+
+	common.SetupLogOptions(&CommonCmdData, cmd)
+	common.SetupLogProjectDir(&CommonCmdData, cmd)
+
+	cmd.Flags().BoolVarP(&CmdData.Follow, "follow", "", false, "Follow log output")
+	cmd.Flags().StringVarP(&CmdData.Tail, "tail", "", "all", "Number of lines to show from the end of the logs for each container")
+
+	return cmd
+}
+
+func runLogs(services []string) error {
+	if err := werf.Init(*CommonCmdData.TmpDir, *CommonCmdData.HomeDir); err != nil {
+		return fmt.Errorf("initialization error: %s", err)
+	}
+
+	if err := docker.Init(*CommonCmdData.DockerConfig); err != nil {
+		return err
+	}
+
+	projectDir, err := common.GetProjectDir(&CommonCmdData)
+	if err != nil {
+		return fmt.Errorf("getting project dir failed: %s", err)
+	}
+
+	common.ProcessLogProjectDir(&CommonCmdData, projectDir)
+
+	werfConfig, err := common.GetWerfConfig(projectDir)
+	if err != nil {
+		return fmt.Errorf("bad config: %s", err)
+	}
+
+	composeConfig, err := common.GetComposeConfig(projectDir, &CommonCmdData)
+	if err != nil {
+		return err
+	}
+
+	project := &compose.Project{
+		Name:       werfConfig.Meta.Project,
+		ProjectDir: projectDir,
+		Config:     composeConfig,
+	}
+
+	return logboek.WithRawStreamsOutputModeOn(func() error {
+		return project.Logs(logboek.GetOutStream(), logboek.GetErrStream(), compose.LogsOptions{
+			Services: services,
+			Follow:   CmdData.Follow,
+			Tail:     CmdData.Tail,
+		})
+	})
+}
@@ -0,0 +1,172 @@
+package up
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flant/logboek"
+	"github.com/flant/shluz"
+
+	"github.com/flant/werf/cmd/werf/common"
+	"github.com/flant/werf/pkg/build"
+	"github.com/flant/werf/pkg/compose"
+	"github.com/flant/werf/pkg/docker"
+	"github.com/flant/werf/pkg/docker_registry"
+	"github.com/flant/werf/pkg/logging"
+	"github.com/flant/werf/pkg/ssh_agent"
+	"github.com/flant/werf/pkg/tmp_manager"
+	"github.com/flant/werf/pkg/true_git"
+	"github.com/flant/werf/pkg/werf"
+)
+
+var CommonCmdData common.CmdData
+
+func NewCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "up [SERVICE...]",
+		Short: "Create and start containers of the compose file services",
+		Long: common.GetLongCommandDescription(`Create and start containers of the compose file services.
+
+The compose file describes services in docker-compose-like format. Service is either an external image (image) or an image from werf.yaml (werf_image), which is resolved to the last stage image from the stages storage. So images from werf.yaml should be built prior running up.
+
+All containers are started in the background in the dedicated docker network, service name is the container hostname in the network. Containers are recreated only if the service configuration or image is changed or one of the service dependencies is recreated. Containers of the services, which are not defined in the compose file anymore, are removed.
+
+If one or more SERVICE parameters specified, werf will start only these services and their dependencies.
+
+Read more about the compose file: https://werf.io/documentation/reference/development_and_debug/compose.html`),
+		Example: `  # Start all services of werf-compose.yaml
+  $ werf compose up --stages-storage :local
+
+  # Start service 'backend' and services it depends on
+  $ werf compose up --stages-storage :local backend`,
+		DisableFlagsInUseLine: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.ProcessLogOptions(&CommonCmdData); err != nil {
+				common.PrintHelp(cmd)
+				return err
+			}
+
+			return runUp(args)
+		},
+	}
+
+	common.SetupDir(&CommonCmdData, cmd)
+	common.SetupTmpDir(&CommonCmdData, cmd)
+	common.SetupHomeDir(&CommonCmdData, cmd)
+	common.SetupSSHKey(&CommonCmdData, cmd)
+
+	common.SetupComposeFile(&CommonCmdData, cmd)
+
+	common.SetupStagesStorage(&CommonCmdData, cmd)
+	common.SetupDockerConfig(&CommonCmdData, cmd, "Command needs granted permissions to read and pull images from the specified stages storage and external images of services")
+	common.SetupInsecureRegistry(&CommonCmdData, cmd)
+	common.SetupSkipTlsVerifyRegistry(&CommonCmdData, cmd)
+
+	common.SetupLogOptions(&CommonCmdData, cmd)
+	common.SetupLogProjectDir(&CommonCmdData, cmd)
+
+	return cmd
+}
+
+func runUp(services []string) error {
+	if err := werf.Init(*CommonCmdData.TmpDir, *CommonCmdData.HomeDir); err != nil {
+		return fmt.Errorf("initialization error: %s", err)
+	}
+
+	if err := shluz.Init(filepath.Join(werf.GetServiceDir(), "locks")); err != nil {
+		return err
+	}
+
+	if err := true_git.Init(true_git.Options{Out: logboek.GetOutStream(), Err: logboek.GetErrStream()}); err != nil {
+		return err
+	}
+
+	if err := docker_registry.Init(docker_registry.Options{InsecureRegistry: *CommonCmdData.InsecureRegistry, SkipTlsVerifyRegistry: *CommonCmdData.SkipTlsVerifyRegistry}); err != nil {
+		return err
+	}
+
+	if err := docker.Init(*CommonCmdData.DockerConfig); err != nil {
+		return err
+	}
+
+	projectDir, err := common.GetProjectDir(&CommonCmdData)
+	if err != nil {
+		return fmt.Errorf("getting project dir failed: %s", err)
+	}
+
+	common.ProcessLogProjectDir(&CommonCmdData, projectDir)
+
+	werfConfig, err := common.GetWerfConfig(projectDir)
+	if err != nil {
+		return fmt.Errorf("bad config: %s", err)
+	}
+
+	composeConfig, err := common.GetComposeConfig(projectDir, &CommonCmdData)
+	if err != nil {
+		return err
+	}
+
+	servicesToUp, err := composeConfig.ServicesInOrder(services)
+	if err != nil {
+		return err
+	}
+
+	var imagesToProcess []string
+	for _, serviceName := range servicesToUp {
+		service := composeConfig.Services[serviceName]
+		if service.WerfImage == nil {
+			continue
+		}
+
+		if !werfConfig.HasImage(*service.WerfImage) {
+			return fmt.Errorf("service %s: image '%s' is not defined in werf.yaml", serviceName, logging.ImageLogName(*service.WerfImage, false))
+		}
+
+		imagesToProcess = append(imagesToProcess, *service.WerfImage)
+	}
+
+	werfImages := map[string]string{}
+	if len(imagesToProcess) != 0 {
+		projectTmpDir, err := tmp_manager.CreateProjectDir()
+		if err != nil {
+			return fmt.Errorf("getting project tmp dir failed: %s", err)
+		}
+		defer tmp_manager.ReleaseProjectDir(projectTmpDir)
+
+		stagesStorage, err := common.GetStagesStorage(&CommonCmdData)
+		if err != nil {
+			return err
+		}
+
+		if err := ssh_agent.Init(*CommonCmdData.SSHKeys); err != nil {
+			return fmt.Errorf("cannot initialize ssh agent: %s", err)
+		}
+		defer func() {
+			err := ssh_agent.Terminate()
+			if err != nil {
+				logboek.LogErrorF("WARNING: ssh agent termination failed: %s\n", err)
+			}
+		}()
+
+		c := build.NewConveyor(werfConfig, imagesToProcess, projectDir, projectTmpDir, ssh_agent.SSHAuthSock, stagesStorage)
+		defer c.Terminate()
+
+		if err = c.ShouldBeBuilt(); err != nil {
+			return err
+		}
+
+		for _, imageName := range imagesToProcess {
+			werfImages[imageName] = c.GetImageLatestStageImageName(imageName)
+		}
+	}
+
+	project := &compose.Project{
+		Name:       werfConfig.Meta.Project,
+		ProjectDir: projectDir,
+		Config:     composeConfig,
+	}
+
+	return project.Up(compose.UpOptions{Services: services, WerfImages: werfImages})
+}
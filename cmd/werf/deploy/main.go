@@ -18,6 +18,7 @@ import (
 	"github.com/flant/werf/pkg/docker"
 	"github.com/flant/werf/pkg/docker_registry"
 	"github.com/flant/werf/pkg/ssh_agent"
+	"github.com/flant/werf/pkg/stages_storage"
 	"github.com/flant/werf/pkg/tag_strategy"
 	"github.com/flant/werf/pkg/tmp_manager"
 	"github.com/flant/werf/pkg/true_git"
@@ -104,6 +105,7 @@ Read more info about Helm chart structure, Helm Release name, Kubernetes Namespa
 	common.SetupIgnoreSecretKey(&CommonCmdData, cmd)
 
 	common.SetupThreeWayMergeMode(&CommonCmdData, cmd)
+	common.SetupVerifyKey(&CommonCmdData, cmd)
 
 	cmd.Flags().IntVarP(&CmdData.Timeout, "timeout", "t", 0, "Resources tracking timeout in seconds")
 
@@ -187,8 +189,9 @@ func runDeploy() error {
 	var tag string
 	var tagStrategy tag_strategy.TagStrategy
 	if len(werfConfig.StapelImages) != 0 || len(werfConfig.ImagesFromDockerfile) != 0 {
+		var stagesStorage stages_storage.StagesStorage = stages_storage.NewLocalStagesStorage()
 		if len(werfConfig.StapelImages) != 0 {
-			_, err = common.GetStagesRepo(&CommonCmdData)
+			stagesStorage, err = common.GetStagesStorage(&CommonCmdData)
 			if err != nil {
 				return err
 			}
@@ -224,7 +227,7 @@ func runDeploy() error {
 			}
 		}()
 
-		c := build.NewConveyor(werfConfig, []string{}, projectDir, projectTmpDir, ssh_agent.SSHAuthSock)
+		c := build.NewConveyor(werfConfig, []string{}, projectDir, projectTmpDir, ssh_agent.SSHAuthSock, stagesStorage)
 		defer c.Terminate()
 
 		if err = c.ShouldBeBuilt(); err != nil {
@@ -256,6 +259,11 @@ func runDeploy() error {
 		return err
 	}
 
+	imageVerifier, err := common.GetImageVerifier(&CommonCmdData)
+	if err != nil {
+		return err
+	}
+
 	return deploy.Deploy(projectDir, imagesRepoManager, release, namespace, tag, tagStrategy, werfConfig, *CommonCmdData.HelmReleaseStorageNamespace, helmReleaseStorageType, deploy.DeployOptions{
 		Set:                  *CommonCmdData.Set,
 		SetString:            *CommonCmdData.SetString,
@@ -267,5 +275,6 @@ func runDeploy() error {
 		UserExtraLabels:      userExtraLabels,
 		IgnoreSecretKey:      *CommonCmdData.IgnoreSecretKey,
 		ThreeWayMergeMode:    threeWayMergeMode,
+		ImageVerifier:        imageVerifier,
 	})
 }
@@ -0,0 +1,592 @@
+package dev
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flant/kubedog/pkg/kube"
+	"github.com/flant/logboek"
+	"github.com/flant/shluz"
+
+	"github.com/flant/werf/cmd/werf/common"
+	"github.com/flant/werf/pkg/build"
+	"github.com/flant/werf/pkg/config"
+	"github.com/flant/werf/pkg/deploy"
+	"github.com/flant/werf/pkg/deploy/helm"
+	"github.com/flant/werf/pkg/deploy/werf_chart"
+	"github.com/flant/werf/pkg/dev"
+	"github.com/flant/werf/pkg/docker"
+	"github.com/flant/werf/pkg/docker_registry"
+	"github.com/flant/werf/pkg/logging"
+	"github.com/flant/werf/pkg/slug"
+	"github.com/flant/werf/pkg/ssh_agent"
+	"github.com/flant/werf/pkg/tmp_manager"
+	"github.com/flant/werf/pkg/true_git"
+	"github.com/flant/werf/pkg/werf"
+)
+
+const (
+	defaultDevEnvironment = "dev"
+	devDockerTag          = "dev"
+)
+
+type CmdDataType struct {
+	Deploy           bool
+	RawDockerOptions string
+	WatchInterval    int
+	Debounce         int
+	Timeout          int
+
+	DockerOptions []string
+	DockerCommand []string
+	ImageName     string
+}
+
+var CmdData CmdDataType
+var CommonCmdData common.CmdData
+
+func NewCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dev [options] [IMAGE_NAME] [-- COMMAND ARG...]",
+		Short: "Rebuild and rerun or redeploy project images on changes",
+		Long: common.GetLongCommandDescription(`Rebuild and rerun or redeploy project images on changes.
+
+Command watches the project directory and werf.yaml. Files ignored by .gitignore are not watched. On changes werf rebuilds invalidated stages of images in developer mode, so local git mappings include uncommitted changes of the working tree (read more about --dev option of the build command), and then:
+* restarts the container of the specified image as werf run does, the container is running in the background and is restarted only if the image is changed;
+* or deploys the project into Kubernetes with built stages images when --deploy option is specified.
+
+Images built in developer mode are not pushed anywhere, so the Kubernetes cluster should use the same docker daemon as werf (e.g. minikube with minikube docker-env or Docker Desktop).
+
+Changes are debounced: rebuild starts when project files are not changed during --debounce period. Command prints a status line for each cycle and runs until interrupted, the running container is removed on exit.`),
+		DisableFlagsInUseLine: true,
+		Example: `  # Rebuild and restart container of image 'backend' publishing port 5000 on changes
+  $ werf dev --stages-storage :local --docker-options="-p 5000:5000" backend
+
+  # Rebuild and restart container with specified command on changes
+  $ werf dev --stages-storage :local backend -- /app/run.sh --debug
+
+  # Rebuild images and deploy project into 'dev' environment on changes
+  $ werf dev --stages-storage :local --deploy`,
+		Annotations: map[string]string{
+			common.DisableOptionsInUseLineAnno: "1",
+			common.CmdEnvAnno:                  common.EnvsDescription(common.WerfSecretKey),
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.ProcessLogOptions(&CommonCmdData); err != nil {
+				common.PrintHelp(cmd)
+				return err
+			}
+
+			if err := processArgs(cmd, args); err != nil {
+				common.PrintHelp(cmd)
+				return err
+			}
+
+			if CmdData.RawDockerOptions != "" {
+				CmdData.DockerOptions = strings.Split(CmdData.RawDockerOptions, " ")
+			}
+
+			if CmdData.Deploy && (len(CmdData.DockerOptions) != 0 || len(CmdData.DockerCommand) != 0 || CmdData.ImageName != "") {
+				common.PrintHelp(cmd)
+				return fmt.Errorf("--deploy option cannot be used with IMAGE_NAME, docker run options and command")
+			}
+
+			if CmdData.WatchInterval <= 0 {
+				return fmt.Errorf("bad --watch-interval value %d: should be greater than 0", CmdData.WatchInterval)
+			}
+
+			if CmdData.Debounce < 0 {
+				return fmt.Errorf("bad --debounce value %d: should be greater than or equal to 0", CmdData.Debounce)
+			}
+
+			common.LogVersion()
+
+			return runDev()
+		},
+	}
+
+	common.SetupDir(&CommonCmdData, cmd)
+	common.SetupTmpDir(&CommonCmdData, cmd)
+	common.SetupHomeDir(&CommonCmdData, cmd)
+	common.SetupSSHKey(&CommonCmdData, cmd)
+
+	common.SetupEnvironment(&CommonCmdData, cmd)
+	common.SetupRelease(&CommonCmdData, cmd)
+	common.SetupNamespace(&CommonCmdData, cmd)
+	common.SetupAddAnnotations(&CommonCmdData, cmd)
+	common.SetupAddLabels(&CommonCmdData, cmd)
+
+	common.SetupKubeConfig(&CommonCmdData, cmd)
+	common.SetupKubeContext(&CommonCmdData, cmd)
+	common.SetupHelmReleaseStorageNamespace(&CommonCmdData, cmd)
+	common.SetupHelmReleaseStorageType(&CommonCmdData, cmd)
+	common.SetupStatusProgressPeriod(&CommonCmdData, cmd)
+	common.SetupHooksStatusProgressPeriod(&CommonCmdData, cmd)
+	common.SetupReleasesHistoryMax(&CommonCmdData, cmd)
+
+	common.SetupStagesStorage(&CommonCmdData, cmd)
+	common.SetupDockerConfig(&CommonCmdData, cmd, "Command needs granted permissions to read, pull and push images into the specified stages storage, to pull base images")
+	common.SetupInsecureRegistry(&CommonCmdData, cmd)
+	common.SetupSkipTlsVerifyRegistry(&CommonCmdData, cmd)
+
+	common.SetupParallelOptions(&CommonCmdData, cmd)
+	common.SetupDevIncludeUntracked(&CommonCmdData, cmd)
+
+	common.SetupLogOptions(&CommonCmdData, cmd)
+	common.SetupLogProjectDir(&CommonCmdData, cmd)
+
+	common.SetupSet(&CommonCmdData, cmd)
+	common.SetupSetString(&CommonCmdData, cmd)
+	common.SetupValues(&CommonCmdData, cmd)
+	common.SetupSecretValues(&CommonCmdData, cmd)
+	common.SetupIgnoreSecretKey(&CommonCmdData, cmd)
+
+	common.SetupThreeWayMergeMode(&CommonCmdData, cmd)
+
+	cmd.Flags().BoolVarP(&CmdData.Deploy, "deploy", "", false, "Deploy project into Kubernetes on changes instead of restarting the image container")
+	cmd.Flags().StringVarP(&CmdData.RawDockerOptions, "docker-options", "", "", "Define docker run options of the image container")
+	cmd.Flags().IntVarP(&CmdData.WatchInterval, "watch-interval", "", 1000, "Project files check period in milliseconds")
+	cmd.Flags().IntVarP(&CmdData.Debounce, "debounce", "", 500, "Period in milliseconds without new changes of project files to start rebuild")
+	cmd.Flags().IntVarP(&CmdData.Timeout, "timeout", "t", 0, "Resources tracking timeout in seconds")
+
+	return cmd
+}
+
+func processArgs(cmd *cobra.Command, args []string) error {
+	doubleDashInd := cmd.ArgsLenAtDash()
+	doubleDashExist := cmd.ArgsLenAtDash() != -1
+
+	if doubleDashExist {
+		if doubleDashInd == len(args) {
+			return fmt.Errorf("unsupported position args format")
+		}
+
+		switch doubleDashInd {
+		case 0:
+			CmdData.DockerCommand = args[doubleDashInd:]
+		case 1:
+			CmdData.ImageName = args[0]
+			CmdData.DockerCommand = args[doubleDashInd:]
+		default:
+			return fmt.Errorf("unsupported position args format")
+		}
+	} else {
+		switch len(args) {
+		case 0:
+		case 1:
+			CmdData.ImageName = args[0]
+		default:
+			return fmt.Errorf("unsupported position args format")
+		}
+	}
+
+	return nil
+}
+
+func runDev() error {
+	if err := werf.Init(*CommonCmdData.TmpDir, *CommonCmdData.HomeDir); err != nil {
+		return fmt.Errorf("initialization error: %s", err)
+	}
+
+	if err := shluz.Init(filepath.Join(werf.GetServiceDir(), "locks")); err != nil {
+		return err
+	}
+
+	if err := true_git.Init(true_git.Options{Out: logboek.GetOutStream(), Err: logboek.GetErrStream()}); err != nil {
+		return err
+	}
+
+	if err := docker_registry.Init(docker_registry.Options{InsecureRegistry: *CommonCmdData.InsecureRegistry, SkipTlsVerifyRegistry: *CommonCmdData.SkipTlsVerifyRegistry}); err != nil {
+		return err
+	}
+
+	if err := docker.Init(*CommonCmdData.DockerConfig); err != nil {
+		return err
+	}
+
+	projectDir, err := common.GetProjectDir(&CommonCmdData)
+	if err != nil {
+		return fmt.Errorf("getting project dir failed: %s", err)
+	}
+
+	common.ProcessLogProjectDir(&CommonCmdData, projectDir)
+
+	projectTmpDir, err := tmp_manager.CreateProjectDir()
+	if err != nil {
+		return fmt.Errorf("getting project tmp dir failed: %s", err)
+	}
+	defer tmp_manager.ReleaseProjectDir(projectTmpDir)
+
+	parallelOptions, err := common.GetParallelOptions(&CommonCmdData)
+	if err != nil {
+		return err
+	}
+
+	if err := ssh_agent.Init(*CommonCmdData.SSHKeys); err != nil {
+		return fmt.Errorf("cannot initialize ssh agent: %s", err)
+	}
+	defer func() {
+		err := ssh_agent.Terminate()
+		if err != nil {
+			logboek.LogErrorF("WARNING: ssh agent termination failed: %s\n", err)
+		}
+	}()
+
+	s := &session{
+		projectDir:      projectDir,
+		projectTmpDir:   projectTmpDir,
+		parallelOptions: parallelOptions,
+	}
+
+	if CmdData.Deploy {
+		if err := s.initDeploy(); err != nil {
+			return err
+		}
+	}
+
+	watcher := dev.NewWatcher(projectDir, dev.WatcherOptions{
+		Interval: time.Duration(CmdData.WatchInterval) * time.Millisecond,
+		Debounce: time.Duration(CmdData.Debounce) * time.Millisecond,
+	})
+
+	if err := watcher.Init(); err != nil {
+		return err
+	}
+
+	// the current cycle is completed on interruption, so that the conveyor is terminated,
+	// the container is removed and the tmp dir is released by the main goroutine
+	return common.WithTerminationSignalsContext(func(ctx context.Context) error {
+		defer s.cleanup()
+
+		var changedPaths []string
+		for cycle := 1; ; cycle++ {
+			s.runCycle(ctx, cycle, changedPaths)
+
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			logboek.LogInfoF("Waiting for changes in %s ...\n", projectDir)
+
+			changedPaths, err = watcher.WaitForChanges(ctx)
+			if err == context.Canceled {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	})
+}
+
+type session struct {
+	projectDir      string
+	projectTmpDir   string
+	parallelOptions build.ParallelOptions
+
+	helmReleaseStorageType string
+	threeWayMergeMode      helm.ThreeWayMergeModeType
+
+	containerName      string
+	containerImageName string
+}
+
+func (s *session) initDeploy() error {
+	if *CommonCmdData.Environment == "" {
+		*CommonCmdData.Environment = defaultDevEnvironment
+	}
+
+	helmReleaseStorageType, err := common.GetHelmReleaseStorageType(*CommonCmdData.HelmReleaseStorageType)
+	if err != nil {
+		return err
+	}
+	s.helmReleaseStorageType = helmReleaseStorageType
+
+	threeWayMergeMode, err := common.GetThreeWayMergeMode(*CommonCmdData.ThreeWayMergeMode)
+	if err != nil {
+		return err
+	}
+	s.threeWayMergeMode = threeWayMergeMode
+
+	deployInitOptions := deploy.InitOptions{
+		HelmInitOptions: helm.InitOptions{
+			KubeConfig:                  *CommonCmdData.KubeConfig,
+			KubeContext:                 *CommonCmdData.KubeContext,
+			HelmReleaseStorageNamespace: *CommonCmdData.HelmReleaseStorageNamespace,
+			HelmReleaseStorageType:      helmReleaseStorageType,
+			StatusProgressPeriod:        common.GetStatusProgressPeriod(&CommonCmdData),
+			HooksStatusProgressPeriod:   common.GetHooksStatusProgressPeriod(&CommonCmdData),
+			ReleasesMaxHistory:          *CommonCmdData.ReleasesHistoryMax,
+			InitNamespace:               true,
+		},
+	}
+	if err := deploy.Init(deployInitOptions); err != nil {
+		return err
+	}
+
+	if err := kube.Init(kube.InitOptions{KubeContext: *CommonCmdData.KubeContext, KubeConfig: *CommonCmdData.KubeConfig}); err != nil {
+		return fmt.Errorf("cannot initialize kube: %s", err)
+	}
+
+	if err := common.InitKubedog(); err != nil {
+		return fmt.Errorf("cannot init kubedog: %s", err)
+	}
+
+	return nil
+}
+
+// runCycle rebuilds images and reruns or redeploys them, errors are reported and do not stop the watching
+func (s *session) runCycle(ctx context.Context, cycle int, changedPaths []string) {
+	startTime := time.Now()
+
+	var status string
+	var err error
+	_ = logboek.LogProcess(fmt.Sprintf("Dev cycle #%d", cycle), logboek.LogProcessOptions{}, func() error {
+		status, err = s.rebuild(ctx)
+		return nil
+	})
+
+	var changes string
+	if cycle == 1 {
+		changes = "initial build"
+	} else if len(changedPaths) == 1 {
+		changes = fmt.Sprintf("changed %s", changedPaths[0])
+	} else {
+		changes = fmt.Sprintf("changed %d files", len(changedPaths))
+	}
+
+	duration := time.Since(startTime).Seconds()
+	if err != nil {
+		logboek.LogErrorF("[dev #%d] %s: FAILED (%0.2f seconds): %s\n", cycle, changes, duration, err)
+	} else {
+		logboek.LogHighlightF("[dev #%d] %s: %s (%0.2f seconds)\n", cycle, changes, status, duration)
+	}
+	logboek.LogOptionalLn()
+}
+
+func (s *session) rebuild(ctx context.Context) (string, error) {
+	werfConfig, err := common.GetWerfConfig(s.projectDir)
+	if err != nil {
+		return "", fmt.Errorf("bad config: %s", err)
+	}
+
+	var imagesToProcess []string
+	if !CmdData.Deploy {
+		imageName := CmdData.ImageName
+		if imageName == "" && len(werfConfig.GetAllImages()) == 1 {
+			imageName = werfConfig.GetAllImages()[0].GetName()
+		}
+
+		if !werfConfig.HasImage(imageName) {
+			return "", fmt.Errorf("image '%s' is not defined in werf.yaml", logging.ImageLogName(imageName, false))
+		}
+
+		imagesToProcess = []string{imageName}
+	}
+
+	stagesStorage, err := common.GetStagesStorage(&CommonCmdData)
+	if err != nil {
+		return "", err
+	}
+
+	c := build.NewConveyor(werfConfig, imagesToProcess, s.projectDir, s.projectTmpDir, ssh_agent.SSHAuthSock, stagesStorage)
+	c.SetDevOptions(build.DevOptions{Dev: true, IncludeUntracked: *CommonCmdData.DevIncludeUntracked})
+	defer c.Terminate()
+
+	if err := c.BuildStages(build.BuildStagesOptions{ParallelOptions: s.parallelOptions}); err != nil {
+		return "", err
+	}
+
+	// built images are not deployed or run after interruption
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("interrupted")
+	}
+
+	builtStages := 0
+	for _, imageReport := range c.GetReport().Images {
+		for _, stageReport := range imageReport.Stages {
+			if !stageReport.IsCached {
+				builtStages++
+			}
+		}
+	}
+	status := fmt.Sprintf("%d stages built", builtStages)
+
+	if CmdData.Deploy {
+		if err := s.deploy(c, werfConfig); err != nil {
+			return "", err
+		}
+
+		return status + ", deployed", nil
+	}
+
+	restarted, err := s.restartContainer(werfConfig, imagesToProcess[0], c.GetImageLatestStageImageName(imagesToProcess[0]))
+	if err != nil {
+		return "", err
+	}
+
+	if restarted {
+		return status + fmt.Sprintf(", container %s restarted", s.containerName), nil
+	}
+
+	return status + ", container is up to date", nil
+}
+
+func (s *session) restartContainer(werfConfig *config.WerfConfig, imageName, dockerImageName string) (bool, error) {
+	containerName := getContainerName(werfConfig.Meta.Project, imageName)
+	if containerName == s.containerName && dockerImageName == s.containerImageName {
+		exist, err := docker.ContainerExist(containerName)
+		if err != nil {
+			return false, err
+		}
+
+		if exist {
+			return false, nil
+		}
+	}
+
+	s.cleanup()
+
+	var dockerRunArgs []string
+	dockerRunArgs = append(dockerRunArgs, "-d", "--name", containerName)
+	dockerRunArgs = append(dockerRunArgs, CmdData.DockerOptions...)
+	dockerRunArgs = append(dockerRunArgs, dockerImageName)
+	dockerRunArgs = append(dockerRunArgs, CmdData.DockerCommand...)
+
+	if err := removeContainerIfExists(containerName); err != nil {
+		return false, err
+	}
+
+	if err := logboek.WithRawStreamsOutputModeOn(func() error {
+		return docker.CliRun(dockerRunArgs...)
+	}); err != nil {
+		return false, fmt.Errorf("docker run %s failed: %s", strings.Join(dockerRunArgs, " "), err)
+	}
+
+	s.containerName = containerName
+	s.containerImageName = dockerImageName
+
+	return true, nil
+}
+
+// cleanup removes the image container started by the session
+func (s *session) cleanup() {
+	if s.containerName == "" {
+		return
+	}
+
+	if err := removeContainerIfExists(s.containerName); err != nil {
+		logboek.LogErrorF("WARNING: container %s removal failed: %s\n", s.containerName, err)
+	}
+
+	s.containerName = ""
+	s.containerImageName = ""
+}
+
+func removeContainerIfExists(containerName string) error {
+	exist, err := docker.ContainerExist(containerName)
+	if err != nil {
+		return err
+	}
+
+	if !exist {
+		return nil
+	}
+
+	return logboek.WithRawStreamsOutputModeOn(func() error {
+		return docker.CliRm("--force", containerName)
+	})
+}
+
+func getContainerName(projectName, imageName string) string {
+	if imageName == "" {
+		return slug.DockerTag(fmt.Sprintf("werf-dev-%s", projectName))
+	}
+
+	return slug.DockerTag(fmt.Sprintf("werf-dev-%s-%s", projectName, imageName))
+}
+
+func (s *session) deploy(c *build.Conveyor, werfConfig *config.WerfConfig) error {
+	release, err := common.GetHelmRelease(*CommonCmdData.Release, *CommonCmdData.Environment, werfConfig)
+	if err != nil {
+		return err
+	}
+
+	namespace, err := common.GetKubernetesNamespace(*CommonCmdData.Namespace, *CommonCmdData.Environment, werfConfig)
+	if err != nil {
+		return err
+	}
+
+	userExtraAnnotations, err := common.GetUserExtraAnnotations(&CommonCmdData)
+	if err != nil {
+		return err
+	}
+
+	userExtraLabels, err := common.GetUserExtraLabels(&CommonCmdData)
+	if err != nil {
+		return err
+	}
+
+	var images []deploy.ImageInfoGetter
+	for _, image := range werfConfig.GetAllImages() {
+		images = append(images, &imageInfo{
+			name:      image.GetName(),
+			imageName: c.GetImageLatestStageImageName(image.GetName()),
+		})
+	}
+
+	m, err := deploy.GetSafeSecretManager(s.projectDir, *CommonCmdData.SecretValues, *CommonCmdData.IgnoreSecretKey)
+	if err != nil {
+		return err
+	}
+
+	// stages images are local and their names are changed with the content, so the tag strategy is not set
+	// to avoid imagePullPolicy Always in werf_container_image template
+	serviceValues, err := deploy.GetServiceValues(werfConfig.Meta.Project, &common.ImagesRepoManager{}, namespace, devDockerTag, "", images, deploy.ServiceValuesOptions{Env: *CommonCmdData.Environment})
+	if err != nil {
+		return fmt.Errorf("error creating service values: %s", err)
+	}
+
+	return deploy.DeployChart(werfConfig.Meta.Project, filepath.Join(s.projectDir, werf_chart.ProjectHelmChartDirName), m, serviceValues, release, namespace, *CommonCmdData.HelmReleaseStorageNamespace, s.helmReleaseStorageType, deploy.DeployOptions{
+		Set:                  *CommonCmdData.Set,
+		SetString:            *CommonCmdData.SetString,
+		Values:               *CommonCmdData.Values,
+		SecretValues:         *CommonCmdData.SecretValues,
+		Timeout:              time.Duration(CmdData.Timeout) * time.Second,
+		Env:                  *CommonCmdData.Environment,
+		UserExtraAnnotations: userExtraAnnotations,
+		UserExtraLabels:      userExtraLabels,
+		IgnoreSecretKey:      *CommonCmdData.IgnoreSecretKey,
+		ThreeWayMergeMode:    s.threeWayMergeMode,
+	})
+}
+
+// imageInfo describes the local stage image of the project image, which is used in the chart instead of the published one
+type imageInfo struct {
+	name      string
+	imageName string
+}
+
+func (i *imageInfo) IsNameless() bool {
+	return i.name == ""
+}
+
+func (i *imageInfo) GetName() string {
+	return i.name
+}
+
+func (i *imageInfo) GetImageName() string {
+	return i.imageName
+}
+
+func (i *imageInfo) GetImageId() (string, error) {
+	return "", nil
+}
+
+func (i *imageInfo) GetImageDigest() (string, error) {
+	return "", nil
+}
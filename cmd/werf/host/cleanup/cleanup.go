@@ -3,7 +3,9 @@ package cleanup
 import (
 	"fmt"
 	"path/filepath"
+	"time"
 
+	"github.com/docker/go-units"
 	"github.com/flant/shluz"
 
 	"github.com/spf13/cobra"
@@ -17,7 +19,12 @@ import (
 	"github.com/flant/werf/pkg/werf"
 )
 
-var CmdData struct{}
+var CmdData struct {
+	CacheMountsMaxAgeDays  int64
+	CacheMountsMaxSize     string
+	GitDataCacheMaxAgeDays int64
+	GitDataCacheMaxSize    string
+}
 
 var CommonCmdData common.CmdData
 
@@ -33,6 +40,10 @@ The data include:
 * Local cache:
   * Remote git clones cache.
   * Git worktree cache.
+  * Git data cache (archives, patches and checksums of git mappings) which has not been used for the specified number of days or exceeds the specified total size (least recently used data is removed first).
+  * Images configs cache of docker registries scanning which has not been used for 14 days.
+  * Files checksums cache of Dockerfile contexts which has not been used for 14 days.
+* Cache mounts (mount directive with from: cache) which have not been used for the specified number of days or exceed the specified total size (least recently used cache mounts are removed first).
 
 It is safe to run this command periodically by automated cleanup job in parallel with other werf commands such as build, deploy, stages and images cleanup.`),
 		DisableFlagsInUseLine: true,
@@ -59,6 +70,11 @@ It is safe to run this command periodically by automated cleanup job in parallel
 
 	common.SetupDryRun(&CommonCmdData, cmd)
 
+	cmd.Flags().Int64VarP(&CmdData.CacheMountsMaxAgeDays, "cache-mounts-max-age-days", "", 14, "Remove cache mounts which have not been used for the specified number of days (0 — do not remove cache mounts by age)")
+	cmd.Flags().StringVarP(&CmdData.CacheMountsMaxSize, "cache-mounts-max-size", "", "", "Remove least recently used cache mounts until the total size of cache mounts fits the limit (e.g. 10GiB, no limit by default)")
+	cmd.Flags().Int64VarP(&CmdData.GitDataCacheMaxAgeDays, "git-data-cache-max-age-days", "", 14, "Remove git data cache which has not been used for the specified number of days (0 — do not remove git data cache by age)")
+	cmd.Flags().StringVarP(&CmdData.GitDataCacheMaxSize, "git-data-cache-max-size", "", "5GiB", "Remove least recently used git data cache until the total size of git data cache fits the limit (0 — no limit)")
+
 	return cmd
 }
 
@@ -83,8 +99,28 @@ func runGC() error {
 		return err
 	}
 
+	var cacheMountsMaxSize int64
+	if CmdData.CacheMountsMaxSize != "" {
+		size, err := units.RAMInBytes(CmdData.CacheMountsMaxSize)
+		if err != nil {
+			return fmt.Errorf("bad --cache-mounts-max-size value %q: %s", CmdData.CacheMountsMaxSize, err)
+		}
+		cacheMountsMaxSize = size
+	}
+
+	gitDataCacheMaxSize, err := units.RAMInBytes(CmdData.GitDataCacheMaxSize)
+	if err != nil {
+		return fmt.Errorf("bad --git-data-cache-max-size value %q: %s", CmdData.GitDataCacheMaxSize, err)
+	}
+
 	logboek.LogOptionalLn()
-	hostCleanupOptions := cleaning.HostCleanupOptions{DryRun: *CommonCmdData.DryRun}
+	hostCleanupOptions := cleaning.HostCleanupOptions{
+		CacheMountsMaxAge:   time.Duration(CmdData.CacheMountsMaxAgeDays) * 24 * time.Hour,
+		CacheMountsMaxSize:  cacheMountsMaxSize,
+		GitDataCacheMaxAge:  time.Duration(CmdData.GitDataCacheMaxAgeDays) * 24 * time.Hour,
+		GitDataCacheMaxSize: gitDataCacheMaxSize,
+		DryRun:              *CommonCmdData.DryRun,
+	}
 	if err := cleaning.HostCleanup(hostCleanupOptions); err != nil {
 		return err
 	}
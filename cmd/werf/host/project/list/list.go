@@ -14,6 +14,7 @@ import (
 	"github.com/flant/logboek"
 
 	"github.com/flant/werf/cmd/werf/common"
+	"github.com/flant/werf/pkg/cache_mount"
 	"github.com/flant/werf/pkg/docker"
 	imagePkg "github.com/flant/werf/pkg/image"
 	"github.com/flant/werf/pkg/werf"
@@ -30,6 +31,7 @@ func NewCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:                   "list",
 		Short:                 "List project names based on local stages storage",
+		Long:                  common.GetLongCommandDescription("List project names based on local stages storage.\n\nCache mounts (mount directive with from: cache) used by the project are listed with the total size and the last usage time."),
 		DisableFlagsInUseLine: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return run()
@@ -70,14 +72,21 @@ func run() error {
 		return err
 	}
 
+	if !CmdData.Quiet {
+		if err := setProjectsCacheMounts(projects); err != nil {
+			return err
+		}
+	}
+
 	printProjects(projects)
 
 	return nil
 }
 
 type projectFields struct {
-	Created  int64
-	Modified int64
+	Created     int64
+	Modified    int64
+	CacheMounts []*cache_mount.Cache
 }
 
 func getProjects() (map[string]*projectFields, error) {
@@ -134,6 +143,23 @@ func filterProjects(projects map[string]*projectFields) (map[string]*projectFiel
 	return newProjects, nil
 }
 
+func setProjectsCacheMounts(projects map[string]*projectFields) error {
+	caches, err := cache_mount.List()
+	if err != nil {
+		return err
+	}
+
+	for _, cache := range caches {
+		for _, projectName := range cache.Projects {
+			if project, hasKey := projects[projectName]; hasKey {
+				project.CacheMounts = append(project.CacheMounts, cache)
+			}
+		}
+	}
+
+	return nil
+}
+
 func printProjects(projects map[string]*projectFields) {
 	if CmdData.Quiet {
 		for projectName := range projects {
@@ -142,12 +168,18 @@ func printProjects(projects map[string]*projectFields) {
 	} else {
 		t := uitable.New()
 		t.MaxColWidth = uint(logboek.ContentWidth())
-		t.AddRow("NAME", "CREATED", "MODIFIED")
+		t.AddRow("NAME", "CREATED", "MODIFIED", "CACHE MOUNTS")
 		for projectName, project := range projects {
 			now := time.Now().UTC()
 			created := units.HumanDuration(now.Sub(time.Unix(project.Created, 0))) + " ago"
 			modified := units.HumanDuration(now.Sub(time.Unix(project.Modified, 0))) + " ago"
-			t.AddRow(projectName, created, modified)
+
+			var cacheMounts []string
+			for _, cache := range project.CacheMounts {
+				cacheMounts = append(cacheMounts, fmt.Sprintf("%s (%s, used %s ago)", cache.Id, units.BytesSize(float64(cache.Size())), units.HumanDuration(now.Sub(cache.LastUsed()))))
+			}
+
+			t.AddRow(projectName, created, modified, strings.Join(cacheMounts, ", "))
 		}
 		fmt.Println(t.String())
 	}
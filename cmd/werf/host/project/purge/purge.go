@@ -13,6 +13,7 @@ import (
 	"github.com/flant/werf/pkg/cleaning"
 	"github.com/flant/werf/pkg/docker"
 	"github.com/flant/werf/pkg/docker_registry"
+	"github.com/flant/werf/pkg/stages_storage"
 	"github.com/flant/werf/pkg/werf"
 )
 
@@ -84,6 +85,7 @@ func run(projectNames ...string) error {
 		if err := logboek.LogProcess("Project "+projectName, logProcessOptions, func() error {
 			stagesPurgeOptions := cleaning.StagesPurgeOptions{
 				ProjectName:                   projectName,
+				StagesStorage:                 stages_storage.NewLocalStagesStorage(),
 				RmContainersThatUseWerfImages: CmdData.Force,
 				DryRun:                        *CommonCmdData.DryRun,
 			}
@@ -63,6 +63,12 @@ If one or more IMAGE_NAME parameters specified, werf will publish only these ima
 	common.SetupLogOptions(commonCmdData, cmd)
 	common.SetupLogProjectDir(commonCmdData, cmd)
 
+	common.SetupAffectedSince(commonCmdData, cmd)
+	common.SetupReportOptions(commonCmdData, cmd)
+	common.SetupSignKey(commonCmdData, cmd)
+	common.SetupSBOMOptions(commonCmdData, cmd)
+	common.SetupSBOMAttach(commonCmdData, cmd)
+
 	return cmd
 }
 
@@ -105,6 +111,16 @@ func runImagesPublish(commonCmdData *common.CmdData, imagesToProcess []string) e
 		}
 	}
 
+	imagesToProcess, nothingToProcess, err := common.GetAffectedImagesToProcess(commonCmdData, projectDir, werfConfig, imagesToProcess)
+	if err != nil {
+		return err
+	}
+
+	if nothingToProcess {
+		logboek.LogLn("No images affected, nothing to publish")
+		return nil
+	}
+
 	projectName := werfConfig.Meta.Project
 
 	projectTmpDir, err := tmp_manager.CreateProjectDir()
@@ -113,7 +129,7 @@ func runImagesPublish(commonCmdData *common.CmdData, imagesToProcess []string) e
 	}
 	defer tmp_manager.ReleaseProjectDir(projectTmpDir)
 
-	_, err = common.GetStagesRepo(commonCmdData)
+	stagesStorage, err := common.GetStagesStorage(commonCmdData)
 	if err != nil {
 		return err
 	}
@@ -138,6 +154,16 @@ func runImagesPublish(commonCmdData *common.CmdData, imagesToProcess []string) e
 		return err
 	}
 
+	reportOptions, err := common.GetReportOptions(commonCmdData)
+	if err != nil {
+		return err
+	}
+
+	sbomOptions, err := common.GetSBOMOptions(commonCmdData)
+	if err != nil {
+		return err
+	}
+
 	if err := ssh_agent.Init(*commonCmdData.SSHKeys); err != nil {
 		return fmt.Errorf("cannot initialize ssh agent: %s", err)
 	}
@@ -148,14 +174,23 @@ func runImagesPublish(commonCmdData *common.CmdData, imagesToProcess []string) e
 		}
 	}()
 
-	opts := build.PublishImagesOptions{TagOptions: tagOpts}
+	imageSigner, err := common.GetImageSigner(commonCmdData, projectDir)
+	if err != nil {
+		return err
+	}
+
+	opts := build.PublishImagesOptions{TagOptions: tagOpts, ImageSigner: imageSigner, SBOMOptions: sbomOptions, WithReport: reportOptions.ReportPath != ""}
 
-	c := build.NewConveyor(werfConfig, imagesToProcess, projectDir, projectTmpDir, ssh_agent.SSHAuthSock)
+	c := build.NewConveyor(werfConfig, imagesToProcess, projectDir, projectTmpDir, ssh_agent.SSHAuthSock, stagesStorage)
 	defer c.Terminate()
 
 	if err = c.PublishImages(imagesRepoManager, opts); err != nil {
 		return err
 	}
 
+	if err := c.WriteReport(reportOptions); err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -0,0 +1,172 @@
+package sbom
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flant/logboek"
+	"github.com/flant/shluz"
+
+	"github.com/flant/werf/cmd/werf/common"
+	"github.com/flant/werf/pkg/build"
+	"github.com/flant/werf/pkg/docker"
+	"github.com/flant/werf/pkg/docker_registry"
+	"github.com/flant/werf/pkg/logging"
+	"github.com/flant/werf/pkg/sbom"
+	"github.com/flant/werf/pkg/ssh_agent"
+	"github.com/flant/werf/pkg/tmp_manager"
+	"github.com/flant/werf/pkg/true_git"
+	"github.com/flant/werf/pkg/werf"
+)
+
+var CmdData struct {
+	Format string
+}
+
+var CommonCmdData common.CmdData
+
+func NewCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sbom [options] [IMAGE_NAME]",
+		Short: "Print SBOM of the built image",
+		Example: `  # Print SPDX SBOM of image 'backend' from werf.yaml
+  $ werf images sbom --stages-storage :local backend
+
+  # Save CycloneDX SBOM of the single image from werf.yaml
+  $ werf images sbom --stages-storage :local --format cyclonedx > sbom.json`,
+		Long: common.GetLongCommandDescription(`Print the software bill of materials of the built image in SPDX or CycloneDX JSON format.
+
+SBOM contains the base image, the commits of git mappings, the imports and the packages of the image filesystem (dpkg, apk and rpm databases, language lockfiles). Image stages should be built in the stages storage, IMAGE_NAME can be omitted if werf.yaml contains single image`),
+		DisableFlagsInUseLine: true,
+		Annotations: map[string]string{
+			common.DisableOptionsInUseLineAnno: "1",
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logging.Mute()
+
+			var imageName string
+			if len(args) > 1 {
+				common.PrintHelp(cmd)
+				return fmt.Errorf("%d position argument can be specified, received %d", 1, len(args))
+			} else if len(args) == 1 {
+				imageName = args[0]
+			}
+
+			return run(imageName)
+		},
+	}
+
+	common.SetupDir(&CommonCmdData, cmd)
+	common.SetupTmpDir(&CommonCmdData, cmd)
+	common.SetupHomeDir(&CommonCmdData, cmd)
+	common.SetupSSHKey(&CommonCmdData, cmd)
+
+	common.SetupStagesStorage(&CommonCmdData, cmd)
+	common.SetupDockerConfig(&CommonCmdData, cmd, "Command needs granted permissions to read and pull images from the specified stages storage")
+	common.SetupInsecureRegistry(&CommonCmdData, cmd)
+	common.SetupSkipTlsVerifyRegistry(&CommonCmdData, cmd)
+
+	common.SetupLogProjectDir(&CommonCmdData, cmd)
+
+	defaultFormat := os.Getenv("WERF_SBOM")
+	if defaultFormat == "" {
+		defaultFormat = string(sbom.SPDX)
+	}
+	cmd.Flags().StringVarP(&CmdData.Format, "format", "", defaultFormat, fmt.Sprintf("SBOM format: %s or %s (default $WERF_SBOM or %s)", sbom.SPDX, sbom.CycloneDX, sbom.SPDX))
+
+	return cmd
+}
+
+func run(imageName string) error {
+	format, err := sbom.ParseFormat(CmdData.Format)
+	if err != nil {
+		return fmt.Errorf("bad --format value: %s", err)
+	}
+
+	if err := werf.Init(*CommonCmdData.TmpDir, *CommonCmdData.HomeDir); err != nil {
+		return fmt.Errorf("initialization error: %s", err)
+	}
+
+	tmp_manager.AutoGCEnabled = false
+
+	if err := shluz.Init(filepath.Join(werf.GetServiceDir(), "locks")); err != nil {
+		return err
+	}
+
+	if err := true_git.Init(true_git.Options{Out: logboek.GetOutStream(), Err: logboek.GetErrStream()}); err != nil {
+		return err
+	}
+
+	if err := docker_registry.Init(docker_registry.Options{InsecureRegistry: *CommonCmdData.InsecureRegistry, SkipTlsVerifyRegistry: *CommonCmdData.SkipTlsVerifyRegistry}); err != nil {
+		return err
+	}
+
+	if err := docker.Init(*CommonCmdData.DockerConfig); err != nil {
+		return err
+	}
+
+	projectDir, err := common.GetProjectDir(&CommonCmdData)
+	if err != nil {
+		return fmt.Errorf("getting project dir failed: %s", err)
+	}
+
+	common.ProcessLogProjectDir(&CommonCmdData, projectDir)
+
+	werfConfig, err := common.GetWerfConfig(projectDir)
+	if err != nil {
+		return fmt.Errorf("bad config: %s", err)
+	}
+
+	projectTmpDir, err := tmp_manager.CreateProjectDir()
+	if err != nil {
+		return fmt.Errorf("getting project tmp dir failed: %s", err)
+	}
+	defer tmp_manager.ReleaseProjectDir(projectTmpDir)
+
+	stagesStorage, err := common.GetStagesStorage(&CommonCmdData)
+	if err != nil {
+		return err
+	}
+
+	if err := ssh_agent.Init(*CommonCmdData.SSHKeys); err != nil {
+		return fmt.Errorf("cannot initialize ssh agent: %s", err)
+	}
+	defer func() {
+		err := ssh_agent.Terminate()
+		if err != nil {
+			logboek.LogErrorF("WARNING: ssh agent termination failed: %s\n", err)
+		}
+	}()
+
+	if imageName == "" && len(werfConfig.StapelImages) == 1 {
+		imageName = werfConfig.StapelImages[0].Name
+	}
+
+	if !werfConfig.HasImage(imageName) {
+		return fmt.Errorf("image '%s' is not defined in werf.yaml", logging.ImageLogName(imageName, false))
+	}
+
+	c := build.NewConveyor(werfConfig, []string{imageName}, projectDir, projectTmpDir, ssh_agent.SSHAuthSock, stagesStorage)
+	defer c.Terminate()
+
+	if err = c.ShouldBeBuilt(); err != nil {
+		return err
+	}
+
+	document, err := c.GetImageSBOM(imageName)
+	if err != nil {
+		return fmt.Errorf("unable to generate image %s SBOM: %s", logging.ImageLogName(imageName, false), err)
+	}
+
+	data, err := sbom.Marshal(document, format)
+	if err != nil {
+		return fmt.Errorf("unable to marshal SBOM: %s", err)
+	}
+
+	fmt.Println(string(data))
+
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/flant/werf/cmd/werf/build_and_publish"
 	"github.com/flant/werf/cmd/werf/cleanup"
 	"github.com/flant/werf/cmd/werf/deploy"
+	"github.com/flant/werf/cmd/werf/dev"
 	"github.com/flant/werf/cmd/werf/dismiss"
 	"github.com/flant/werf/cmd/werf/publish"
 	"github.com/flant/werf/cmd/werf/purge"
@@ -31,9 +32,20 @@ import (
 	images_cleanup "github.com/flant/werf/cmd/werf/images/cleanup"
 	images_publish "github.com/flant/werf/cmd/werf/images/publish"
 	images_purge "github.com/flant/werf/cmd/werf/images/purge"
+	images_sbom "github.com/flant/werf/cmd/werf/images/sbom"
+
+	compose_down "github.com/flant/werf/cmd/werf/compose/down"
+	compose_logs "github.com/flant/werf/cmd/werf/compose/logs"
+	compose_up "github.com/flant/werf/cmd/werf/compose/up"
+
+	bundle_apply "github.com/flant/werf/cmd/werf/bundle/apply"
+	bundle_export "github.com/flant/werf/cmd/werf/bundle/export"
 
 	stages_build "github.com/flant/werf/cmd/werf/stages/build"
 	stages_cleanup "github.com/flant/werf/cmd/werf/stages/cleanup"
+	stages_export "github.com/flant/werf/cmd/werf/stages/export"
+	stages_import "github.com/flant/werf/cmd/werf/stages/import"
+	stages_plan "github.com/flant/werf/cmd/werf/stages/plan"
 	stages_purge "github.com/flant/werf/cmd/werf/stages/purge"
 
 	stage_image "github.com/flant/werf/cmd/werf/stage/image"
@@ -100,6 +112,8 @@ Find more information at https://werf.io`),
 				build_and_publish.NewCmd(),
 				run.NewCmd(),
 				deploy.NewCmd(),
+				dev.NewCmd(),
+				composeCmd(),
 				dismiss.NewCmd(),
 				cleanup.NewCmd(),
 				purge.NewCmd(),
@@ -119,6 +133,7 @@ Find more information at https://werf.io`),
 				stagesCmd(),
 				imagesCmd(),
 				helmCmd(),
+				bundleCmd(),
 				hostCmd(),
 			},
 		},
@@ -161,6 +176,7 @@ func imagesCmd() *cobra.Command {
 		images_publish.NewCmd(),
 		images_cleanup.NewCmd(),
 		images_purge.NewCmd(),
+		images_sbom.NewCmd(),
 	)
 
 	return cmd
@@ -175,6 +191,36 @@ func stagesCmd() *cobra.Command {
 		stages_build.NewCmd(),
 		stages_cleanup.NewCmd(),
 		stages_purge.NewCmd(),
+		stages_plan.NewCmd(),
+		stages_export.NewCmd(),
+		stages_import.NewCmd(),
+	)
+
+	return cmd
+}
+
+func composeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "compose",
+		Short: "Run several project images and external images together locally",
+	}
+	cmd.AddCommand(
+		compose_up.NewCmd(),
+		compose_down.NewCmd(),
+		compose_logs.NewCmd(),
+	)
+
+	return cmd
+}
+
+func bundleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bundle",
+		Short: "Work with deployment bundles",
+	}
+	cmd.AddCommand(
+		bundle_export.NewCmd(),
+		bundle_apply.NewCmd(),
 	)
 
 	return cmd
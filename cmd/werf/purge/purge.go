@@ -55,6 +55,8 @@ WARNING: Do not run this command during any other werf command is working on the
 	common.SetupDockerConfig(&CommonCmdData, cmd, "Command needs granted permissions to delete images from the specified stages storage and images repo")
 	common.SetupInsecureRegistry(&CommonCmdData, cmd)
 	common.SetupSkipTlsVerifyRegistry(&CommonCmdData, cmd)
+	common.SetupRepoImplementation(&CommonCmdData, cmd)
+	common.SetupPlatform(&CommonCmdData, cmd)
 
 	common.SetupLogOptions(&CommonCmdData, cmd)
 	common.SetupLogProjectDir(&CommonCmdData, cmd)
@@ -81,12 +83,12 @@ func runPurge() error {
 
 	common.ProcessLogProjectDir(&CommonCmdData, projectDir)
 
-	_, err = common.GetStagesRepo(&CommonCmdData)
+	stagesStorage, err := common.GetStagesStorage(&CommonCmdData)
 	if err != nil {
 		return err
 	}
 
-	if err := docker_registry.Init(docker_registry.Options{InsecureRegistry: *CommonCmdData.InsecureRegistry, SkipTlsVerifyRegistry: *CommonCmdData.SkipTlsVerifyRegistry}); err != nil {
+	if err := docker_registry.Init(docker_registry.Options{InsecureRegistry: *CommonCmdData.InsecureRegistry, SkipTlsVerifyRegistry: *CommonCmdData.SkipTlsVerifyRegistry, RepoImplementation: *CommonCmdData.RepoImplementation, Platform: *CommonCmdData.Platform}); err != nil {
 		return err
 	}
 
@@ -133,6 +135,7 @@ func runPurge() error {
 
 	stagesPurgeOptions := cleaning.StagesPurgeOptions{
 		ProjectName:                   projectName,
+		StagesStorage:                 stagesStorage,
 		RmContainersThatUseWerfImages: CmdData.Force,
 		DryRun:                        *CommonCmdData.DryRun,
 	}
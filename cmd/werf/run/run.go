@@ -107,6 +107,7 @@ func NewCmd() *cobra.Command {
 	common.SetupLogProjectDir(&CommonCmdData, cmd)
 
 	common.SetupDryRun(&CommonCmdData, cmd)
+	common.SetupDevOptions(&CommonCmdData, cmd)
 
 	cmd.Flags().BoolVarP(&CmdData.Shell, "shell", "", false, "Use predefined docker options and command for debug")
 	cmd.Flags().BoolVarP(&CmdData.Bash, "bash", "", false, "Use predefined docker options and command for debug")
@@ -185,7 +186,7 @@ func runRun() error {
 	}
 	defer tmp_manager.ReleaseProjectDir(projectTmpDir)
 
-	_, err = common.GetStagesRepo(&CommonCmdData)
+	stagesStorage, err := common.GetStagesStorage(&CommonCmdData)
 	if err != nil {
 		return err
 	}
@@ -209,7 +210,13 @@ func runRun() error {
 		return fmt.Errorf("image '%s' is not defined in werf.yaml", logging.ImageLogName(imageName, false))
 	}
 
-	c := build.NewConveyor(werfConfig, []string{imageName}, projectDir, projectTmpDir, ssh_agent.SSHAuthSock)
+	devOptions, err := common.GetDevOptions(&CommonCmdData)
+	if err != nil {
+		return err
+	}
+
+	c := build.NewConveyor(werfConfig, []string{imageName}, projectDir, projectTmpDir, ssh_agent.SSHAuthSock, stagesStorage)
+	c.SetDevOptions(devOptions)
 	defer c.Terminate()
 
 	if err = c.ShouldBeBuilt(); err != nil {
@@ -80,6 +80,12 @@ If one or more IMAGE_NAME parameters specified, werf will build only these image
 
 	common.SetupIntrospectStage(commonCmdData, cmd)
 
+	common.SetupAffectedSince(commonCmdData, cmd)
+	common.SetupParallelOptions(commonCmdData, cmd)
+	common.SetupDevOptions(commonCmdData, cmd)
+	common.SetupReportOptions(commonCmdData, cmd)
+	common.SetupSBOMOptions(commonCmdData, cmd)
+
 	common.SetupLogOptions(commonCmdData, cmd)
 	common.SetupLogProjectDir(commonCmdData, cmd)
 
@@ -128,13 +134,23 @@ func runStagesBuild(cmdData *CmdData, commonCmdData *common.CmdData, imagesToPro
 		}
 	}
 
+	imagesToProcess, nothingToProcess, err := common.GetAffectedImagesToProcess(commonCmdData, projectDir, werfConfig, imagesToProcess)
+	if err != nil {
+		return err
+	}
+
+	if nothingToProcess {
+		logboek.LogLn("No images affected, nothing to build")
+		return nil
+	}
+
 	projectTmpDir, err := tmp_manager.CreateProjectDir()
 	if err != nil {
 		return fmt.Errorf("getting project tmp dir failed: %s", err)
 	}
 	defer tmp_manager.ReleaseProjectDir(projectTmpDir)
 
-	stagesRepo, err := common.GetStagesRepo(commonCmdData)
+	stagesStorage, err := common.GetStagesStorage(commonCmdData)
 	if err != nil {
 		return err
 	}
@@ -154,18 +170,45 @@ func runStagesBuild(cmdData *CmdData, commonCmdData *common.CmdData, imagesToPro
 		return err
 	}
 
+	parallelOptions, err := common.GetParallelOptions(commonCmdData)
+	if err != nil {
+		return err
+	}
+
+	reportOptions, err := common.GetReportOptions(commonCmdData)
+	if err != nil {
+		return err
+	}
+
+	devOptions, err := common.GetDevOptions(commonCmdData)
+	if err != nil {
+		return err
+	}
+
+	sbomOptions, err := common.GetSBOMOptions(commonCmdData)
+	if err != nil {
+		return err
+	}
+
 	opts := build.BuildStagesOptions{
 		ImageBuildOptions: image.BuildOptions{
 			IntrospectAfterError:  cmdData.IntrospectAfterError,
 			IntrospectBeforeError: cmdData.IntrospectBeforeError,
 		},
 		IntrospectOptions: introspectOptions,
+		ParallelOptions:   parallelOptions,
+		SBOMOptions:       sbomOptions,
 	}
 
-	c := build.NewConveyor(werfConfig, imagesToProcess, projectDir, projectTmpDir, ssh_agent.SSHAuthSock)
+	c := build.NewConveyor(werfConfig, imagesToProcess, projectDir, projectTmpDir, ssh_agent.SSHAuthSock, stagesStorage)
+	c.SetDevOptions(devOptions)
 	defer c.Terminate()
 
-	if err = c.BuildStages(stagesRepo, opts); err != nil {
+	if err = c.BuildStages(opts); err != nil {
+		return err
+	}
+
+	if err := c.WriteReport(reportOptions); err != nil {
 		return err
 	}
 
@@ -0,0 +1,156 @@
+package export
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flant/logboek"
+	"github.com/flant/shluz"
+
+	"github.com/flant/werf/cmd/werf/common"
+	"github.com/flant/werf/pkg/build"
+	"github.com/flant/werf/pkg/docker"
+	"github.com/flant/werf/pkg/docker_registry"
+	"github.com/flant/werf/pkg/logging"
+	"github.com/flant/werf/pkg/ssh_agent"
+	"github.com/flant/werf/pkg/stages_archive"
+	"github.com/flant/werf/pkg/tmp_manager"
+	"github.com/flant/werf/pkg/true_git"
+	"github.com/flant/werf/pkg/util"
+	"github.com/flant/werf/pkg/werf"
+)
+
+var CmdData struct {
+	Output string
+	Format string
+}
+
+var CommonCmdData common.CmdData
+
+func NewCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export [IMAGE_NAME...]",
+		Short: "Export stages of images into one tarball",
+		Example: `  # Export stages of all images from werf.yaml
+  $ werf stages export --stages-storage :local --output stages.tar
+
+  # Export stages of image 'backend' from werf.yaml into OCI image layout tarball
+  $ werf stages export --stages-storage :local --output stages.tar --format oci backend`,
+		Long: common.GetLongCommandDescription(`Export stages of images into one tarball.
+
+The command writes all stages of images with werf labels and signatures into docker save tarball or OCI image layout tarball. Layers shared by several stages are written once. Stages should be built, the tarball can be loaded into stages storage with werf stages import command, e.g. on air-gapped host or from CI cache.
+
+If one or more IMAGE_NAME parameters specified, werf will export only these images stages from werf.yaml`),
+		DisableFlagsInUseLine: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.ProcessLogOptions(&CommonCmdData); err != nil {
+				common.PrintHelp(cmd)
+				return err
+			}
+			common.LogVersion()
+
+			return common.LogRunningTime(func() error {
+				return runExport(args)
+			})
+		},
+	}
+
+	common.SetupDir(&CommonCmdData, cmd)
+	common.SetupTmpDir(&CommonCmdData, cmd)
+	common.SetupHomeDir(&CommonCmdData, cmd)
+	common.SetupSSHKey(&CommonCmdData, cmd)
+
+	common.SetupStagesStorage(&CommonCmdData, cmd)
+	common.SetupDockerConfig(&CommonCmdData, cmd, "Command needs granted permissions to read and pull images from the specified stages storage")
+	common.SetupInsecureRegistry(&CommonCmdData, cmd)
+	common.SetupSkipTlsVerifyRegistry(&CommonCmdData, cmd)
+
+	common.SetupLogOptions(&CommonCmdData, cmd)
+	common.SetupLogProjectDir(&CommonCmdData, cmd)
+
+	cmd.Flags().StringVarP(&CmdData.Output, "output", "o", "", "Path to the tarball (required)")
+	cmd.Flags().StringVarP(&CmdData.Format, "format", "", stages_archive.DockerFormat, fmt.Sprintf("Tarball format: %s (docker save tarball) or %s (OCI image layout tarball)", stages_archive.DockerFormat, stages_archive.OCIFormat))
+
+	return cmd
+}
+
+func runExport(imagesToProcess []string) error {
+	if CmdData.Output == "" {
+		return fmt.Errorf("--output PATH param required")
+	}
+
+	if CmdData.Format != stages_archive.DockerFormat && CmdData.Format != stages_archive.OCIFormat {
+		return fmt.Errorf("bad --format '%s': expected %s or %s", CmdData.Format, stages_archive.DockerFormat, stages_archive.OCIFormat)
+	}
+
+	if err := werf.Init(*CommonCmdData.TmpDir, *CommonCmdData.HomeDir); err != nil {
+		return fmt.Errorf("initialization error: %s", err)
+	}
+
+	if err := shluz.Init(filepath.Join(werf.GetServiceDir(), "locks")); err != nil {
+		return err
+	}
+
+	if err := true_git.Init(true_git.Options{Out: logboek.GetOutStream(), Err: logboek.GetErrStream()}); err != nil {
+		return err
+	}
+
+	if err := docker_registry.Init(docker_registry.Options{InsecureRegistry: *CommonCmdData.InsecureRegistry, SkipTlsVerifyRegistry: *CommonCmdData.SkipTlsVerifyRegistry}); err != nil {
+		return err
+	}
+
+	if err := docker.Init(*CommonCmdData.DockerConfig); err != nil {
+		return err
+	}
+
+	projectDir, err := common.GetProjectDir(&CommonCmdData)
+	if err != nil {
+		return fmt.Errorf("getting project dir failed: %s", err)
+	}
+
+	common.ProcessLogProjectDir(&CommonCmdData, projectDir)
+
+	werfConfig, err := common.GetWerfConfig(projectDir)
+	if err != nil {
+		return fmt.Errorf("bad config: %s", err)
+	}
+
+	for _, imageToProcess := range imagesToProcess {
+		if !werfConfig.HasImage(imageToProcess) {
+			return fmt.Errorf("specified image %s is not defined in werf.yaml", logging.ImageLogName(imageToProcess, false))
+		}
+	}
+
+	projectTmpDir, err := tmp_manager.CreateProjectDir()
+	if err != nil {
+		return fmt.Errorf("getting project tmp dir failed: %s", err)
+	}
+	defer tmp_manager.ReleaseProjectDir(projectTmpDir)
+
+	stagesStorage, err := common.GetStagesStorage(&CommonCmdData)
+	if err != nil {
+		return err
+	}
+
+	if err := ssh_agent.Init(*CommonCmdData.SSHKeys); err != nil {
+		return fmt.Errorf("cannot initialize ssh agent: %s", err)
+	}
+	defer func() {
+		err := ssh_agent.Terminate()
+		if err != nil {
+			logboek.LogErrorF("WARNING: ssh agent termination failed: %s\n", err)
+		}
+	}()
+
+	c := build.NewConveyor(werfConfig, imagesToProcess, projectDir, projectTmpDir, ssh_agent.SSHAuthSock, stagesStorage)
+	defer c.Terminate()
+
+	exportStagesOptions := build.ExportStagesOptions{
+		OutputPath: util.ExpandPath(CmdData.Output),
+		Format:     CmdData.Format,
+	}
+
+	return c.ExportStages(exportStagesOptions)
+}
@@ -0,0 +1,86 @@
+package stages_import
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/flant/shluz"
+
+	"github.com/flant/werf/cmd/werf/common"
+	"github.com/flant/werf/pkg/docker"
+	"github.com/flant/werf/pkg/docker_registry"
+	"github.com/flant/werf/pkg/stages_archive"
+	"github.com/flant/werf/pkg/util"
+	"github.com/flant/werf/pkg/werf"
+)
+
+var CommonCmdData common.CmdData
+
+func NewCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import ARCHIVE",
+		Short: "Import stages from the tarball into stages storage",
+		Example: `  # Import stages exported by werf stages export
+  $ werf stages import --stages-storage :local stages.tar`,
+		Long: common.GetLongCommandDescription(`Import stages from the tarball into stages storage.
+
+The command loads stages from docker save tarball or OCI image layout tarball created by werf stages export command. After import werf build uses imported stages as the cache, e.g. on air-gapped host.`),
+		DisableFlagsInUseLine: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.ProcessLogOptions(&CommonCmdData); err != nil {
+				common.PrintHelp(cmd)
+				return err
+			}
+			common.LogVersion()
+
+			return common.LogRunningTime(func() error {
+				return runImport(args)
+			})
+		},
+	}
+
+	common.SetupTmpDir(&CommonCmdData, cmd)
+	common.SetupHomeDir(&CommonCmdData, cmd)
+
+	common.SetupStagesStorage(&CommonCmdData, cmd)
+	common.SetupDockerConfig(&CommonCmdData, cmd, "Command needs granted permissions to push images into the specified stages storage")
+	common.SetupInsecureRegistry(&CommonCmdData, cmd)
+	common.SetupSkipTlsVerifyRegistry(&CommonCmdData, cmd)
+
+	common.SetupLogOptions(&CommonCmdData, cmd)
+
+	return cmd
+}
+
+func runImport(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("accepts 1 position argument, received %d", len(args))
+	}
+
+	archivePath := util.ExpandPath(args[0])
+
+	if err := werf.Init(*CommonCmdData.TmpDir, *CommonCmdData.HomeDir); err != nil {
+		return fmt.Errorf("initialization error: %s", err)
+	}
+
+	if err := shluz.Init(filepath.Join(werf.GetServiceDir(), "locks")); err != nil {
+		return err
+	}
+
+	if err := docker_registry.Init(docker_registry.Options{InsecureRegistry: *CommonCmdData.InsecureRegistry, SkipTlsVerifyRegistry: *CommonCmdData.SkipTlsVerifyRegistry}); err != nil {
+		return err
+	}
+
+	if err := docker.Init(*CommonCmdData.DockerConfig); err != nil {
+		return err
+	}
+
+	stagesStorage, err := common.GetStagesStorage(&CommonCmdData)
+	if err != nil {
+		return err
+	}
+
+	return stages_archive.Import(archivePath, stagesStorage)
+}
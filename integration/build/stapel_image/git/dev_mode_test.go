@@ -0,0 +1,57 @@
+package git_test
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/alessio/shellescape"
+
+	. "github.com/onsi/ginkgo"
+
+	"github.com/flant/werf/pkg/testing/utils"
+	"github.com/flant/werf/pkg/testing/utils/docker"
+)
+
+var _ = Describe("dev mode", func() {
+	buildAndCheckFile := func(containerPath, data string) {
+		utils.RunSucceedCommand(
+			testDirPath,
+			werfBinPath,
+			"build",
+		)
+
+		docker.RunSucceedContainerCommandWithStapel(
+			werfBinPath,
+			testDirPath,
+			[]string{},
+			[]string{
+				fmt.Sprintf("diff <(echo -n %s) %s", shellescape.Quote(data), shellescape.Quote(containerPath)),
+			},
+		)
+	}
+
+	BeforeEach(func() {
+		commonBeforeEach(testDirPath, utils.FixturePath("dev_mode"))
+
+		utils.CreateFile(filepath.Join(testDirPath, "file"), []byte("committed"))
+		addAndCommitFile(testDirPath, "file", "Add file")
+	})
+
+	It("should add working tree changes of tracked files", func() {
+		utils.CreateFile(filepath.Join(testDirPath, "file"), []byte("changed"))
+
+		stubs.SetEnv("WERF_DEV", "1")
+		buildAndCheckFile("/app/file", "changed")
+
+		stubs.SetEnv("WERF_DEV", "")
+		buildAndCheckFile("/app/file", "committed")
+	})
+
+	It("should add untracked files with include untracked option", func() {
+		utils.CreateFile(filepath.Join(testDirPath, "untracked"), []byte("untracked"))
+
+		stubs.SetEnv("WERF_DEV", "1")
+		stubs.SetEnv("WERF_DEV_INCLUDE_UNTRACKED", "1")
+		buildAndCheckFile("/app/untracked", "untracked")
+	})
+})
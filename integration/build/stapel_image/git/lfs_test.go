@@ -0,0 +1,131 @@
+package git_test
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/alessio/shellescape"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/werf/pkg/testing/utils"
+	"github.com/flant/werf/pkg/testing/utils/docker"
+)
+
+var _ = Describe("git lfs", func() {
+	gitToPath := "/app"
+
+	var lfsServer *httptest.Server
+	var lfsObjects map[string][]byte
+
+	addAndCommitLFSFile := func(relPath string, data []byte) {
+		oid := fmt.Sprintf("%x", sha256.Sum256(data))
+		lfsObjects[oid] = data
+
+		pointer := fmt.Sprintf("version https://git-lfs.github.com/spec/v1\noid sha256:%s\nsize %d\n", oid, len(data))
+		utils.CreateFile(filepath.Join(testDirPath, relPath), []byte(pointer))
+		addAndCommitFile(testDirPath, relPath, "Add/Modify LFS file "+relPath)
+	}
+
+	buildAndCheckFile := func(relPath string, data []byte) {
+		utils.RunSucceedCommand(
+			testDirPath,
+			werfBinPath,
+			"build",
+		)
+
+		docker.RunSucceedContainerCommandWithStapel(
+			werfBinPath,
+			testDirPath,
+			[]string{},
+			[]string{fmt.Sprintf("diff <(echo -n %s) %s", shellescape.Quote(string(data)), shellescape.Quote(path.Join(gitToPath, relPath)))},
+		)
+	}
+
+	BeforeEach(func() {
+		lfsObjects = map[string][]byte{}
+		lfsServer = newLFSServerStandIn(lfsObjects)
+
+		commonBeforeEach(testDirPath, utils.FixturePath("lfs"))
+
+		utils.CreateFile(filepath.Join(testDirPath, ".gitattributes"), []byte("*.bin filter=lfs diff=lfs merge=lfs -text\n"))
+		addAndCommitFile(testDirPath, ".gitattributes", "Track *.bin files with LFS")
+
+		utils.CreateFile(filepath.Join(testDirPath, ".lfsconfig"), []byte(fmt.Sprintf("[lfs]\n\turl = %s/info/lfs\n", lfsServer.URL)))
+		addAndCommitFile(testDirPath, ".lfsconfig", "Add LFS server url")
+	})
+
+	AfterEach(func() {
+		lfsServer.Close()
+	})
+
+	It("should add LFS object content instead of the pointer and follow LFS object changes", func() {
+		relPath := "dir/file.bin"
+
+		data := []byte(utils.GetRandomString(2048))
+		addAndCommitLFSFile(relPath, data)
+		buildAndCheckFile(relPath, data)
+
+		data = []byte(utils.GetRandomString(2048))
+		addAndCommitLFSFile(relPath, data)
+		buildAndCheckFile(relPath, data)
+	})
+})
+
+// newLFSServerStandIn serves LFS batch API download operation and objects with basic transfer
+func newLFSServerStandIn(objects map[string][]byte) *httptest.Server {
+	var server *httptest.Server
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/info/lfs/objects/batch", func(w http.ResponseWriter, r *http.Request) {
+		var batchRequest struct {
+			Operation string `json:"operation"`
+			Objects   []struct {
+				Oid  string `json:"oid"`
+				Size int64  `json:"size"`
+			} `json:"objects"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&batchRequest); err != nil || batchRequest.Operation != "download" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+
+		var responseObjects []map[string]interface{}
+		for _, obj := range batchRequest.Objects {
+			responseObject := map[string]interface{}{"oid": obj.Oid, "size": obj.Size}
+			if _, hasKey := objects[obj.Oid]; hasKey {
+				responseObject["actions"] = map[string]interface{}{
+					"download": map[string]interface{}{"href": fmt.Sprintf("%s/objects/%s", server.URL, obj.Oid)},
+				}
+			} else {
+				responseObject["error"] = map[string]interface{}{"code": 404, "message": "Object does not exist"}
+			}
+
+			responseObjects = append(responseObjects, responseObject)
+		}
+
+		w.Header().Set("Content-Type", "application/vnd.git-lfs+json")
+		Ω(json.NewEncoder(w).Encode(map[string]interface{}{"transfer": "basic", "objects": responseObjects})).Should(Succeed())
+	})
+	mux.HandleFunc("/objects/", func(w http.ResponseWriter, r *http.Request) {
+		data, hasKey := objects[strings.TrimPrefix(r.URL.Path, "/objects/")]
+		if !hasKey {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		_, _ = w.Write(data)
+	})
+
+	server = httptest.NewServer(mux)
+
+	return server
+}
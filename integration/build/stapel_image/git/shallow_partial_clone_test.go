@@ -0,0 +1,77 @@
+package git_test
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/alessio/shellescape"
+
+	. "github.com/onsi/ginkgo"
+
+	"github.com/flant/werf/pkg/testing/utils"
+	"github.com/flant/werf/pkg/testing/utils/docker"
+)
+
+var _ = Describe("shallow and partial clone of remote repository", func() {
+	var remoteRepoPath string
+
+	commitRemoteFile := func(relPath, data string) {
+		utils.CreateFile(filepath.Join(remoteRepoPath, relPath), []byte(data))
+		addAndCommitFile(remoteRepoPath, relPath, "Add/Modify file "+relPath)
+	}
+
+	buildAndCheckFile := func(containerPath, data string) {
+		utils.RunSucceedCommand(
+			testDirPath,
+			werfBinPath,
+			"build",
+		)
+
+		docker.RunSucceedContainerCommandWithStapel(
+			werfBinPath,
+			testDirPath,
+			[]string{},
+			[]string{
+				fmt.Sprintf("diff <(echo -n %s) %s", shellescape.Quote(data), shellescape.Quote(containerPath)),
+			},
+		)
+	}
+
+	BeforeEach(func() {
+		remoteRepoPath = filepath.Join(tmpDir, "remote")
+		testDirPath = filepath.Join(tmpDir, "project")
+
+		utils.RunSucceedCommand(
+			tmpDir,
+			"git",
+			"init", remoteRepoPath,
+		)
+
+		for _, args := range [][]string{
+			{"config", "uploadpack.allowFilter", "true"},
+			{"config", "uploadpack.allowAnySHA1InWant", "true"},
+		} {
+			utils.RunSucceedCommand(
+				remoteRepoPath,
+				"git",
+				args...,
+			)
+		}
+
+		commitRemoteFile("app/file", "test")
+		commitRemoteFile("other/file", "other")
+
+		stubs.SetEnv("REMOTE_REPO_URL", "file://"+filepath.ToSlash(remoteRepoPath))
+
+		commonBeforeEach(testDirPath, utils.FixturePath("shallow_partial_clone"))
+	})
+
+	It("should add files of the latest commit and follow changes", func() {
+		buildAndCheckFile("/app/file", "test")
+
+		commitRemoteFile("app/file", "test2")
+		commitRemoteFile("other/file", "other2")
+
+		buildAndCheckFile("/app/file", "test2")
+	})
+})
@@ -0,0 +1,335 @@
+package affected
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/flant/werf/pkg/config"
+	"github.com/flant/werf/pkg/true_git"
+	"github.com/flant/werf/pkg/util"
+)
+
+// maxFilesInReason limits the number of changed files listed in the reason
+const maxFilesInReason = 3
+
+var werfConfigFiles = []string{"werf.yaml", "werf.yml"}
+
+const werfConfigTemplatesDir = ".werf"
+
+// Image is the image of werf.yaml, which can be changed by the changed files
+type Image struct {
+	Name    string
+	Reasons []string
+}
+
+// Images returns images of werf.yaml (not artifacts), which can be changed by the changed files, in the werf.yaml order.
+// Changed files paths should be relative to the project dir.
+//
+// The image is affected if the changed files match one of its local git mappings (add, includePaths and excludePaths),
+// its dockerfile or context, or if the image depends on the affected image or artifact (fromImage, fromImageArtifact
+// or import). Changes of werf.yaml, .werf templates and files read by .Files.Get affect all images.
+// Changes of remote git repositories and base images cannot be detected, so images with remote git mappings
+// (not pinned to the commit) and images with fromLatest are always affected
+func Images(werfConfig *config.WerfConfig, changedFiles []string) []*Image {
+	var configFiles, templateFiles []string
+	for _, file := range changedFiles {
+		if isWerfConfigFile(file) {
+			configFiles = append(configFiles, file)
+		} else if util.IsStringsContainValue(werfConfig.TemplateFiles, file) {
+			templateFiles = append(templateFiles, file)
+		}
+	}
+
+	reasons := map[string][]string{}
+	if len(configFiles) != 0 || len(templateFiles) != 0 {
+		var configReasons []string
+		if len(configFiles) != 0 {
+			configReasons = append(configReasons, fmt.Sprintf("werf config changed: %s", filesList(configFiles)))
+		}
+
+		if len(templateFiles) != 0 {
+			configReasons = append(configReasons, fmt.Sprintf("files read by .Files.Get in werf config changed: %s", filesList(templateFiles)))
+		}
+
+		for _, image := range werfConfig.GetAllImages() {
+			reasons[image.GetName()] = configReasons
+		}
+
+		return imagesInOrder(werfConfig, reasons)
+	}
+
+	for _, image := range werfConfig.StapelImages {
+		if imageReasons := stapelImageReasons(image.StapelImageBase, changedFiles); len(imageReasons) != 0 {
+			reasons[imageKey(image.Name, false)] = imageReasons
+		}
+	}
+
+	for _, artifact := range werfConfig.Artifacts {
+		if artifactReasons := stapelImageReasons(artifact.StapelImageBase, changedFiles); len(artifactReasons) != 0 {
+			reasons[imageKey(artifact.Name, true)] = artifactReasons
+		}
+	}
+
+	for _, image := range werfConfig.ImagesFromDockerfile {
+		if imageReasons := dockerfileImageReasons(image, changedFiles); len(imageReasons) != 0 {
+			reasons[imageKey(image.Name, false)] = imageReasons
+		}
+	}
+
+	propagateDependencies(werfConfig, reasons)
+
+	imagesReasons := map[string][]string{}
+	for _, image := range werfConfig.GetAllImages() {
+		if imageReasons, ok := reasons[imageKey(image.GetName(), false)]; ok {
+			imagesReasons[image.GetName()] = imageReasons
+		}
+	}
+
+	return imagesInOrder(werfConfig, imagesReasons)
+}
+
+func stapelImageReasons(imageBaseConfig *config.StapelImageBase, changedFiles []string) []string {
+	var reasons []string
+	if imageBaseConfig.FromLatest {
+		reasons = append(reasons, fmt.Sprintf("base image %s may change (fromLatest)", imageBaseConfig.From))
+	}
+
+	if imageBaseConfig.Git == nil {
+		return reasons
+	}
+
+	for _, local := range imageBaseConfig.Git.Local {
+		filter := &true_git.PathFilter{
+			BasePath:     local.GitMappingAdd(),
+			IncludePaths: local.GitMappingIncludePaths(),
+			ExcludePaths: local.GitMappingExcludePath(),
+		}
+
+		matchedFiles := matchFiles(filter, changedFiles)
+		if len(matchedFiles) == 0 {
+			continue
+		}
+
+		reason := fmt.Sprintf("git mapping add %s changed: %s", local.Add, filesList(matchedFiles))
+
+		if local.StageDependencies != nil {
+			stageDependencies := local.GitMappingStageDependencies()
+
+			var stages []string
+			for _, stageAndPaths := range []struct {
+				stage string
+				paths []string
+			}{
+				{"install", stageDependencies.Install},
+				{"beforeSetup", stageDependencies.BeforeSetup},
+				{"setup", stageDependencies.Setup},
+			} {
+				if len(stageAndPaths.paths) == 0 {
+					continue
+				}
+
+				stageFilter := &true_git.PathFilter{BasePath: filter.BasePath, IncludePaths: stageAndPaths.paths}
+				if len(matchFiles(stageFilter, matchedFiles)) != 0 {
+					stages = append(stages, stageAndPaths.stage)
+				}
+			}
+
+			if len(stages) != 0 {
+				reason += fmt.Sprintf(" (stageDependencies of %s)", strings.Join(stages, ", "))
+			}
+		}
+
+		reasons = append(reasons, reason)
+	}
+
+	for _, remote := range imageBaseConfig.Git.Remote {
+		// the pinned commit can be changed only in werf.yaml
+		if remote.Commit != "" {
+			continue
+		}
+
+		reasons = append(reasons, fmt.Sprintf("git mapping add %s of remote repository %s may change (%s)", remote.Add, remote.Url, remoteRefName(remote)))
+	}
+
+	return reasons
+}
+
+func remoteRefName(remote *config.GitRemote) string {
+	switch {
+	case remote.Branch != "":
+		return fmt.Sprintf("branch %s", remote.Branch)
+	case remote.Tag != "":
+		return fmt.Sprintf("tag %s", remote.Tag)
+	default:
+		return "default branch"
+	}
+}
+
+func dockerfileImageReasons(image *config.ImageFromDockerfile, changedFiles []string) []string {
+	var reasons []string
+
+	dockerfilePath := filepath.Clean(image.Dockerfile)
+	for _, file := range changedFiles {
+		if file == dockerfilePath {
+			reasons = append(reasons, fmt.Sprintf("dockerfile changed: %s", file))
+			break
+		}
+	}
+
+	contextPath := filepath.Clean(image.Context)
+	if contextPath == "." {
+		contextPath = ""
+	}
+
+	filter := &true_git.PathFilter{BasePath: contextPath}
+	if matchedFiles := matchFiles(filter, changedFiles); len(matchedFiles) != 0 {
+		context := image.Context
+		if context == "" {
+			context = "."
+		}
+
+		reasons = append(reasons, fmt.Sprintf("dockerfile context %s changed: %s", context, filesList(matchedFiles)))
+	}
+
+	return reasons
+}
+
+type dependency struct {
+	name       string
+	isArtifact bool
+	directive  string
+}
+
+func stapelImageDependencies(imageBaseConfig *config.StapelImageBase) []dependency {
+	var dependencies []dependency
+
+	if imageBaseConfig.FromImageName != "" {
+		dependencies = append(dependencies, dependency{imageBaseConfig.FromImageName, false, "fromImage"})
+	}
+
+	if imageBaseConfig.FromImageArtifactName != "" {
+		dependencies = append(dependencies, dependency{imageBaseConfig.FromImageArtifactName, true, "fromImageArtifact"})
+	}
+
+	for _, imp := range imageBaseConfig.Import {
+		if imp.ImageName != "" {
+			dependencies = append(dependencies, dependency{imp.ImageName, false, "import"})
+		} else if imp.ArtifactName != "" {
+			dependencies = append(dependencies, dependency{imp.ArtifactName, true, "import"})
+		}
+	}
+
+	return dependencies
+}
+
+// propagateDependencies marks images and artifacts, which depend on the affected ones, until nothing changes
+func propagateDependencies(werfConfig *config.WerfConfig, reasons map[string][]string) {
+	type stapelImage struct {
+		key             string
+		imageBaseConfig *config.StapelImageBase
+	}
+
+	var stapelImages []stapelImage
+	for _, image := range werfConfig.StapelImages {
+		stapelImages = append(stapelImages, stapelImage{imageKey(image.Name, false), image.StapelImageBase})
+	}
+	for _, artifact := range werfConfig.Artifacts {
+		stapelImages = append(stapelImages, stapelImage{imageKey(artifact.Name, true), artifact.StapelImageBase})
+	}
+
+	for changed := true; changed; {
+		changed = false
+
+		for _, image := range stapelImages {
+			for _, dep := range stapelImageDependencies(image.imageBaseConfig) {
+				if _, ok := reasons[imageKey(dep.name, dep.isArtifact)]; !ok {
+					continue
+				}
+
+				reason := fmt.Sprintf("depends on affected %s (%s)", dependencyLogName(dep), dep.directive)
+				if hasReason(reasons[image.key], reason) {
+					continue
+				}
+
+				reasons[image.key] = append(reasons[image.key], reason)
+				changed = true
+			}
+		}
+	}
+}
+
+func imagesInOrder(werfConfig *config.WerfConfig, reasons map[string][]string) []*Image {
+	var images []*Image
+	for _, image := range werfConfig.GetAllImages() {
+		if imageReasons, ok := reasons[image.GetName()]; ok {
+			images = append(images, &Image{Name: image.GetName(), Reasons: imageReasons})
+		}
+	}
+
+	return images
+}
+
+func matchFiles(filter *true_git.PathFilter, files []string) []string {
+	var matchedFiles []string
+	for _, file := range files {
+		if filter.IsFilePathValid(file) {
+			matchedFiles = append(matchedFiles, file)
+		}
+	}
+
+	return matchedFiles
+}
+
+func isWerfConfigFile(file string) bool {
+	for _, configFile := range werfConfigFiles {
+		if file == configFile {
+			return true
+		}
+	}
+
+	return strings.HasPrefix(file, werfConfigTemplatesDir+string(filepath.Separator))
+}
+
+func filesList(files []string) string {
+	sortedFiles := append([]string{}, files...)
+	sort.Strings(sortedFiles)
+
+	if len(sortedFiles) <= maxFilesInReason {
+		return strings.Join(sortedFiles, ", ")
+	}
+
+	return fmt.Sprintf("%s and %d more", strings.Join(sortedFiles[:maxFilesInReason], ", "), len(sortedFiles)-maxFilesInReason)
+}
+
+func dependencyLogName(dep dependency) string {
+	if dep.isArtifact {
+		return fmt.Sprintf("artifact %s", dep.name)
+	}
+
+	if dep.name == "" {
+		return "image ~"
+	}
+
+	return fmt.Sprintf("image %s", dep.name)
+}
+
+// imageKey distinguishes images and artifacts with the same name
+func imageKey(name string, isArtifact bool) string {
+	if isArtifact {
+		return "artifact/" + name
+	}
+
+	return "image/" + name
+}
+
+func hasReason(reasons []string, reason string) bool {
+	for _, r := range reasons {
+		if r == reason {
+			return true
+		}
+	}
+
+	return false
+}
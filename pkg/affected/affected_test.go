@@ -0,0 +1,151 @@
+package affected
+
+import (
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/werf/pkg/config"
+)
+
+func gitLocal(add string, includePaths, excludePaths []string, stageDependencies *config.StageDependencies) *config.GitLocal {
+	return &config.GitLocal{
+		GitLocalExport: &config.GitLocalExport{
+			GitExportBase: &config.GitExportBase{
+				GitExport: &config.GitExport{
+					ExportBase: &config.ExportBase{Add: add, IncludePaths: includePaths, ExcludePaths: excludePaths},
+				},
+				StageDependencies: stageDependencies,
+			},
+		},
+	}
+}
+
+func stapelImageBase(name string, locals ...*config.GitLocal) *config.StapelImageBase {
+	return &config.StapelImageBase{Name: name, Git: &config.GitManager{Local: locals}}
+}
+
+// werfConfig has artifact builder (git /libs), image backend (git /backend excluding docs, stageDependencies.install
+// go.sum, imports artifact builder), image frontend (git /frontend), image backend-debug (fromImage backend)
+// and dockerfile image proxy (context proxy)
+func werfConfig() *config.WerfConfig {
+	backend := stapelImageBase("backend", gitLocal("/backend", nil, []string{"docs/**"}, &config.StageDependencies{Install: []string{"go.sum"}}))
+	backend.Import = []*config.Import{{ArtifactName: "builder"}}
+
+	backendDebug := stapelImageBase("backend-debug")
+	backendDebug.FromImageName = "backend"
+
+	return &config.WerfConfig{
+		Artifacts: []*config.StapelImageArtifact{
+			{StapelImageBase: stapelImageBase("builder", gitLocal("/libs", nil, nil, nil))},
+		},
+		StapelImages: []*config.StapelImage{
+			{StapelImageBase: backend},
+			{StapelImageBase: stapelImageBase("frontend", gitLocal("/frontend", nil, nil, nil))},
+			{StapelImageBase: backendDebug},
+		},
+		ImagesFromDockerfile: []*config.ImageFromDockerfile{
+			{Name: "proxy", Context: "proxy", Dockerfile: "proxy/Dockerfile"},
+		},
+	}
+}
+
+var _ = DescribeTable("affected images", func(changedFiles []string, expectedImages map[string][]string) {
+	images := Images(werfConfig(), changedFiles)
+
+	res := map[string][]string{}
+	for _, image := range images {
+		res[image.Name] = image.Reasons
+	}
+
+	Ω(res).Should(Equal(expectedImages))
+},
+	Entry("nothing changed", nil, map[string][]string{}),
+	Entry("unrelated files", []string{"README.md", "backend/docs/index.md"}, map[string][]string{}),
+	Entry("werf config", []string{"README.md", ".werf/_helpers.tmpl"}, map[string][]string{
+		"backend":       {"werf config changed: .werf/_helpers.tmpl"},
+		"frontend":      {"werf config changed: .werf/_helpers.tmpl"},
+		"backend-debug": {"werf config changed: .werf/_helpers.tmpl"},
+		"proxy":         {"werf config changed: .werf/_helpers.tmpl"},
+	}),
+	Entry("git mapping with stage dependencies", []string{"backend/go.sum"}, map[string][]string{
+		"backend":       {"git mapping add /backend changed: backend/go.sum (stageDependencies of install)"},
+		"backend-debug": {"depends on affected image backend (fromImage)"},
+	}),
+	Entry("imported artifact", []string{"libs/a", "libs/b", "libs/c", "libs/d"}, map[string][]string{
+		"backend":       {"depends on affected artifact builder (import)"},
+		"backend-debug": {"depends on affected image backend (fromImage)"},
+	}),
+	Entry("independent image", []string{"frontend/src/a", "frontend/src/b", "frontend/src/c", "frontend/src/d"}, map[string][]string{
+		"frontend": {"git mapping add /frontend changed: frontend/src/a, frontend/src/b, frontend/src/c and 1 more"},
+	}),
+	Entry("dockerfile", []string{"proxy/Dockerfile"}, map[string][]string{
+		"proxy": {"dockerfile changed: proxy/Dockerfile", "dockerfile context proxy changed: proxy/Dockerfile"},
+	}),
+)
+
+func gitRemote(url, add, branch, commit string) *config.GitRemote {
+	return &config.GitRemote{
+		GitRemoteExport: &config.GitRemoteExport{GitLocalExport: gitLocal(add, nil, nil, nil).GitLocalExport, Branch: branch, Commit: commit},
+		Url:             url,
+	}
+}
+
+// externalWerfConfig has image app (git /app and remote git /lib of branch master), image pinned (remote git /lib of the commit),
+// artifact tools (fromLatest alpine:latest), image runner (imports artifact tools) and image static (git /static).
+// File config/versions.yaml is read by .Files.Get
+func externalWerfConfig() *config.WerfConfig {
+	app := stapelImageBase("app", gitLocal("/app", nil, nil, nil))
+	app.Git.Remote = []*config.GitRemote{gitRemote("https://github.com/flant/lib.git", "/lib", "master", "")}
+
+	pinned := stapelImageBase("pinned")
+	pinned.Git.Remote = []*config.GitRemote{gitRemote("https://github.com/flant/lib.git", "/lib", "", "8a1b2c3d")}
+
+	tools := stapelImageBase("tools")
+	tools.From = "alpine:latest"
+	tools.FromLatest = true
+
+	runner := stapelImageBase("runner")
+	runner.Import = []*config.Import{{ArtifactName: "tools"}}
+
+	return &config.WerfConfig{
+		Artifacts: []*config.StapelImageArtifact{{StapelImageBase: tools}},
+		StapelImages: []*config.StapelImage{
+			{StapelImageBase: app},
+			{StapelImageBase: pinned},
+			{StapelImageBase: runner},
+			{StapelImageBase: stapelImageBase("static", gitLocal("/static", nil, nil, nil))},
+		},
+		TemplateFiles: []string{"config/versions.yaml"},
+	}
+}
+
+var _ = DescribeTable("affected images with external dependencies", func(changedFiles []string, expectedImages map[string][]string) {
+	images := Images(externalWerfConfig(), changedFiles)
+
+	res := map[string][]string{}
+	for _, image := range images {
+		res[image.Name] = image.Reasons
+	}
+
+	Ω(res).Should(Equal(expectedImages))
+},
+	Entry("remote git mappings and base images", []string{"app/main.go"}, map[string][]string{
+		"app": {
+			"git mapping add /app changed: app/main.go",
+			"git mapping add /lib of remote repository https://github.com/flant/lib.git may change (branch master)",
+		},
+		"runner": {"depends on affected artifact tools (import)"},
+	}),
+	Entry("files read by .Files.Get", []string{"config/versions.yaml"}, map[string][]string{
+		"app":    {"files read by .Files.Get in werf config changed: config/versions.yaml"},
+		"pinned": {"files read by .Files.Get in werf config changed: config/versions.yaml"},
+		"runner": {"files read by .Files.Get in werf config changed: config/versions.yaml"},
+		"static": {"files read by .Files.Get in werf config changed: config/versions.yaml"},
+	}),
+	Entry("werf config and files read by .Files.Get", []string{"werf.yaml", "config/versions.yaml"}, map[string][]string{
+		"app":    {"werf config changed: werf.yaml", "files read by .Files.Get in werf config changed: config/versions.yaml"},
+		"pinned": {"werf config changed: werf.yaml", "files read by .Files.Get in werf config changed: config/versions.yaml"},
+		"runner": {"werf config changed: werf.yaml", "files read by .Files.Get in werf config changed: config/versions.yaml"},
+		"static": {"werf config changed: werf.yaml", "files read by .Files.Get in werf config changed: config/versions.yaml"},
+	}),
+)
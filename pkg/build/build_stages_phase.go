@@ -3,6 +3,7 @@ package build
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/docker/docker/pkg/stringid"
 
@@ -14,13 +15,29 @@ import (
 	"github.com/flant/werf/pkg/werf"
 )
 
-func NewBuildStagesPhase(stagesRepo string, opts BuildStagesOptions) *BuildStagesPhase {
-	return &BuildStagesPhase{StagesRepo: stagesRepo, BuildStagesOptions: opts}
+func NewBuildStagesPhase(opts BuildStagesOptions) *BuildStagesPhase {
+	return &BuildStagesPhase{BuildStagesOptions: opts}
 }
 
 type BuildStagesOptions struct {
 	ImageBuildOptions imagePkg.BuildOptions
 	IntrospectOptions
+	ParallelOptions
+
+	SBOMOptions SBOMOptions // SBOM of the built images is written by the SBOMPhase
+}
+
+// validateParallelOptions disables the parallel mode if interactive introspection is requested
+func (opts *BuildStagesOptions) validateParallelOptions() {
+	if !opts.Parallel {
+		return
+	}
+
+	if len(opts.IntrospectOptions.Targets) != 0 || opts.ImageBuildOptions.IntrospectBeforeError || opts.ImageBuildOptions.IntrospectAfterError {
+		logboek.LogErrorF("WARNING: parallel mode is disabled: introspection cannot be used with the parallel build\n")
+		logboek.LogOptionalLn()
+		opts.Parallel = false
+	}
 }
 
 type IntrospectOptions struct {
@@ -43,7 +60,6 @@ func (opts *IntrospectOptions) ImageStageShouldBeIntrospected(imageName, stageNa
 }
 
 type BuildStagesPhase struct {
-	StagesRepo string
 	BuildStagesOptions
 }
 
@@ -55,50 +71,101 @@ func (p *BuildStagesPhase) Run(c *Conveyor) (err error) {
 }
 
 func (p *BuildStagesPhase) run(c *Conveyor) error {
-	/*
-	 * TODO: Build stages phase should push result into stagesRepo if non :local repo has been used
-	 */
-
-	images := c.imagesInOrder
-	for _, image := range images {
-		if err := logboek.LogProcess(image.LogDetailedName(), logboek.LogProcessOptions{ColorizeMsgFunc: image.LogProcessColorizeFunc()}, func() error {
-			return p.runImage(image, c)
-		}); err != nil {
+	return c.runImagesTasks(p.ParallelOptions, func(image *Image, log *imageLog) error {
+		return p.runImage(image, log, c)
+	})
+}
+
+func (p *BuildStagesPhase) runImage(image *Image, log *imageLog, c *Conveyor) error {
+	var prevStageImageSize int64
+
+	for _, s := range image.GetStages() {
+		if err := p.runImageStage(image, s, &prevStageImageSize, log, c); err != nil {
 			return err
 		}
+
+		if p.IntrospectOptions.ImageStageShouldBeIntrospected(image.GetName(), string(s.Name())) {
+			if err := introspectStage(s); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
-func (p *BuildStagesPhase) runImage(image *Image, c *Conveyor) error {
-	stages := image.GetStages()
-
-	var prevStageImageSize int64
+// runImageStage builds the stage image or uses the cache.
+// The stage image can be shared by several images, so the stage image is built only once by the first image
+func (p *BuildStagesPhase) runImageStage(image *Image, s stage.Interface, prevStageImageSize *int64, log *imageLog, c *Conveyor) error {
+	img := s.GetImage()
 
-	for _, s := range stages {
-		img := s.GetImage()
+	stageImageMutex := c.GetStageImageMutex(img.Name())
+	stageImageMutex.Lock()
+	defer stageImageMutex.Unlock()
 
-		isUsingCache := img.IsExists()
+	isUsingCache := img.IsExists()
+	prevSize := *prevStageImageSize
 
-		if isUsingCache {
+	if isUsingCache {
+		log.Add(func() {
 			logboek.LogHighlightF("Use cache image for %s\n", s.LogDetailedName())
+			logImageInfo(img, prevSize, isUsingCache)
+			logboek.LogOptionalLn()
+		})
 
-			logImageInfo(img, prevStageImageSize, isUsingCache)
+		if err := c.storeStageImage(s.GetSignature(), c.GetStageImage(img.Name()), log); err != nil {
+			return err
+		}
 
-			logboek.LogOptionalLn()
+		c.report.addStage(image, newStageReport(s, img, prevSize, isUsingCache, 0))
 
-			prevStageImageSize = img.Inspect().Size
+		*prevStageImageSize = img.Inspect().Size
 
-			if p.IntrospectOptions.ImageStageShouldBeIntrospected(image.GetName(), string(s.Name())) {
-				if err := introspectStage(s); err != nil {
-					return err
-				}
+		return nil
+	}
+
+	buildFunc := func() (err error) {
+		defer func() {
+			if postRunHookErr := s.PostRunHook(c); postRunHookErr != nil && err == nil {
+				err = fmt.Errorf("%s postRunHook failed: %s", s.LogDetailedName(), postRunHookErr)
 			}
+		}()
+
+		if err := s.PreRunHook(c); err != nil {
+			return fmt.Errorf("%s preRunHook failed: %s", s.LogDetailedName(), err)
+		}
+
+		// log tag is global and cannot be used by concurrently built images
+		if log.isBuffered {
+			return p.buildStage(image, s, img, c)
+		}
+
+		return logboek.WithTag(fmt.Sprintf("%s/%s", image.LogName(), s.Name()), image.LogTagColorizeFunc(), func() error {
+			return p.buildStage(image, s, img, c)
+		})
+	}
+
+	buildStartTime := time.Now()
+
+	if log.isBuffered {
+		if err := buildFunc(); err != nil {
+			log.Add(func() {
+				logboek.LogErrorF("Building %s failed\n", s.LogDetailedName())
+				_ = logboek.WithIndent(func() error {
+					logImageCommands(img)
+					return nil
+				})
+			})
 
-			continue
+			return err
 		}
 
+		log.Add(func() {
+			logboek.LogHighlightF("Built %s\n", s.LogDetailedName())
+			logImageInfo(img, prevSize, isUsingCache)
+			logboek.LogOptionalLn()
+		})
+	} else {
 		infoSectionFunc := func(err error) {
 			if err != nil {
 				_ = logboek.WithIndent(func() error {
@@ -109,80 +176,134 @@ func (p *BuildStagesPhase) runImage(image *Image, c *Conveyor) error {
 				return
 			}
 
-			logImageInfo(img, prevStageImageSize, isUsingCache)
+			logImageInfo(img, prevSize, isUsingCache)
 		}
 
 		logProcessOptions := logboek.LogProcessOptions{InfoSectionFunc: infoSectionFunc, ColorizeMsgFunc: logboek.ColorizeHighlight}
-		err := logboek.LogProcess(fmt.Sprintf("Building %s", s.LogDetailedName()), logProcessOptions, func() (err error) {
-			if err := s.PreRunHook(c); err != nil {
-				return fmt.Errorf("%s preRunHook failed: %s", s.LogDetailedName(), err)
-			}
+		if err := logboek.LogProcess(fmt.Sprintf("Building %s", s.LogDetailedName()), logProcessOptions, buildFunc); err != nil {
+			return err
+		}
+	}
 
-			if err := logboek.WithTag(fmt.Sprintf("%s/%s", image.LogName(), s.Name()), image.LogTagColorizeFunc(), func() error {
-				// TODO: isolate stapel and dockerfile builders logic
-				switch certainStage := s.(type) {
-				case *stage.DockerfileStage:
-					var buildArgs []string
-
-					for key, value := range map[string]string{
-						imagePkg.WerfDockerImageName:   img.Name(),
-						imagePkg.WerfLabel:             c.projectName(),
-						imagePkg.WerfVersionLabel:      werf.Version,
-						imagePkg.WerfCacheVersionLabel: imagePkg.BuildCacheVersion,
-						imagePkg.WerfImageLabel:        "false",
-					} {
-						buildArgs = append(buildArgs, fmt.Sprintf("--label=%s=%s", key, value))
-					}
-
-					buildArgs = append(buildArgs, fmt.Sprintf("--tag=%s", img.Name()))
-					buildArgs = append(buildArgs, certainStage.DockerBuildArgs()...)
-
-					if err := docker.CliBuild(buildArgs...); err != nil {
-						return fmt.Errorf("failed to build %s: %s", img.Name(), err)
-					}
-
-					if err := img.SyncDockerState(); err != nil {
-						return fmt.Errorf("failed to sync %s: %s", img.Name(), err)
-					}
-				default:
-					if err := img.Build(p.ImageBuildOptions); err != nil {
-						return fmt.Errorf("failed to build %s: %s", img.Name(), err)
-					}
-
-					if err := img.SaveInCache(); err != nil {
-						return fmt.Errorf("failed to save in cache image %s: %s", img.Name(), err)
-					}
-				}
-
-				return nil
-			}); err != nil {
-				return err
-			}
+	buildTime := time.Since(buildStartTime)
 
-			return nil
-		})
+	if err := c.storeStageImage(s.GetSignature(), c.GetStageImage(img.Name()), log); err != nil {
+		return err
+	}
+
+	c.report.addStage(image, newStageReport(s, img, prevSize, isUsingCache, buildTime))
+
+	imageLockName := imagePkg.ImageLockName(img.Name())
+	if err := c.ReleaseGlobalLock(imageLockName); err != nil {
+		return fmt.Errorf("failed to unlock %s: %s", imageLockName, err)
+	}
+
+	*prevStageImageSize = img.Inspect().Size
+
+	return nil
+}
+
+func (p *BuildStagesPhase) buildStage(image *Image, s stage.Interface, img imagePkg.ImageInterface, c *Conveyor) error {
+	// TODO: isolate stapel and dockerfile builders logic
+	switch certainStage := s.(type) {
+	case *stage.DockerfileStage:
+		return p.buildDockerfileStage(image, certainStage, img, c)
+	case *stage.DockerfilePartStage:
+		if err := certainStage.PrepareBuildFiles(); err != nil {
+			return err
+		}
+
+		return p.buildDockerfileStage(image, certainStage, img, c)
+	default:
+		if err := img.Build(p.ImageBuildOptions); err != nil {
+			return fmt.Errorf("failed to build %s: %s", img.Name(), err)
+		}
+
+		if err := img.SaveInCache(); err != nil {
+			return fmt.Errorf("failed to save in cache image %s: %s", img.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+type dockerfileBuildStage interface {
+	stage.Interface
+
+	IsBuildKit() bool
+	BuildKitBuildArgs(sshAuthSock string, cacheFromImages []string) ([]string, error)
+	DockerBuildArgs() []string
+}
+
+func (p *BuildStagesPhase) buildDockerfileStage(image *Image, s dockerfileBuildStage, img imagePkg.ImageInterface, c *Conveyor) error {
+	var buildArgs []string
+
+	labels, err := stageDependenciesLabels(image, s)
+	if err != nil {
+		return err
+	}
+
+	labels[imagePkg.WerfDockerImageName] = img.Name()
+	labels[imagePkg.WerfLabel] = c.projectName()
+	labels[imagePkg.WerfVersionLabel] = werf.Version
+	labels[imagePkg.WerfCacheVersionLabel] = imagePkg.BuildCacheVersion
+	labels[imagePkg.WerfImageLabel] = "false"
+
+	for key, value := range labels {
+		buildArgs = append(buildArgs, fmt.Sprintf("--label=%s=%s", key, value))
+	}
+
+	buildArgs = append(buildArgs, fmt.Sprintf("--tag=%s", img.Name()))
 
+	if s.IsBuildKit() {
+		cacheFromImages, err := c.dockerfileStageCacheFromImages(image, s)
 		if err != nil {
 			return err
 		}
 
-		imageLockName := imagePkg.ImageLockName(img.Name())
-		if err := c.ReleaseGlobalLock(imageLockName); err != nil {
-			return fmt.Errorf("failed to unlock %s: %s", imageLockName, err)
+		buildKitBuildArgs, err := s.BuildKitBuildArgs(c.sshAuthSock, cacheFromImages)
+		if err != nil {
+			return err
 		}
 
-		prevStageImageSize = img.Inspect().Size
+		buildArgs = append(buildArgs, buildKitBuildArgs...)
 
-		if p.IntrospectOptions.ImageStageShouldBeIntrospected(image.GetName(), string(s.Name())) {
-			if err := introspectStage(s); err != nil {
-				return err
-			}
+		if err := docker.CliBuildWithBuildKit(buildArgs...); err != nil {
+			return fmt.Errorf("failed to build %s: %s", img.Name(), err)
+		}
+	} else {
+		buildArgs = append(buildArgs, s.DockerBuildArgs()...)
+
+		cliBuild := docker.CliBuild
+		if imageConfig := c.werfConfig.GetDockerfileImage(image.name); imageConfig != nil && imageConfig.IsDockerBuilder() {
+			cliBuild = docker.CliBuildWithDockerBuilder
+		}
+
+		if err := cliBuild(buildArgs...); err != nil {
+			return fmt.Errorf("failed to build %s: %s", img.Name(), err)
 		}
 	}
 
+	if err := img.SyncDockerState(); err != nil {
+		return fmt.Errorf("failed to sync %s: %s", img.Name(), err)
+	}
+
 	return nil
 }
 
+func newStageReport(s stage.Interface, img imagePkg.ImageInterface, prevStageImageSize int64, isUsingCache bool, buildTime time.Duration) *StageReport {
+	return &StageReport{
+		Name:             string(s.Name()),
+		Signature:        s.GetSignature(),
+		ImageName:        img.Name(),
+		ImageId:          img.ID(),
+		IsCached:         isUsingCache,
+		Size:             img.Inspect().Size,
+		SizeDiff:         img.Inspect().Size - prevStageImageSize,
+		BuildTimeSeconds: buildTime.Seconds(),
+	}
+}
+
 func introspectStage(s stage.Interface) error {
 	logProcessMessage := fmt.Sprintf("Introspecting stage %s", s.Name())
 	logProcessOptions := logboek.LogProcessOptions{ColorizeMsgFunc: logboek.ColorizeHighlight}
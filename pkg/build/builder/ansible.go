@@ -49,6 +49,22 @@ func (b *Ansible) InstallChecksum() string       { return b.stageChecksum("Insta
 func (b *Ansible) BeforeSetupChecksum() string   { return b.stageChecksum("BeforeSetup") }
 func (b *Ansible) SetupChecksum() string         { return b.stageChecksum("Setup") }
 
+func (b *Ansible) BeforeInstallChecksumInputs() ChecksumInputs {
+	return b.stageChecksumInputs("BeforeInstall")
+}
+
+func (b *Ansible) InstallChecksumInputs() ChecksumInputs {
+	return b.stageChecksumInputs("Install")
+}
+
+func (b *Ansible) BeforeSetupChecksumInputs() ChecksumInputs {
+	return b.stageChecksumInputs("BeforeSetup")
+}
+
+func (b *Ansible) SetupChecksumInputs() ChecksumInputs {
+	return b.stageChecksumInputs("Setup")
+}
+
 func (b *Ansible) isEmptyStage(userStageName string) bool {
 	return b.stageChecksum(userStageName) == ""
 }
@@ -113,20 +129,7 @@ func (b *Ansible) stage(userStageName string, container Container) error {
 }
 
 func (b *Ansible) stageChecksum(userStageName string) string {
-	var checksumArgs []string
-
-	for _, task := range b.stageTasks(userStageName) {
-		output, err := yaml.Marshal(task.Config)
-		if err != nil {
-			panic(fmt.Sprintf("runtime err: %s", err))
-		}
-
-		jsonOutput, err := ghodssYaml.YAMLToJSON(output)
-		if err != nil {
-			panic(fmt.Sprintf("runtime err: %s", err))
-		}
-		checksumArgs = append(checksumArgs, string(jsonOutput))
-	}
+	checksumArgs := b.stageTasksConfigs(userStageName)
 
 	if debugUserStageChecksum() {
 		logboek.LogHighlightF("DEBUG: %s stage tasks checksum dependencies %v\n", userStageName, checksumArgs)
@@ -147,35 +150,63 @@ func (b *Ansible) stageChecksum(userStageName string) string {
 	}
 }
 
+// stageTasksConfigs returns the stage tasks configs in JSON format
+func (b *Ansible) stageTasksConfigs(userStageName string) []string {
+	var configs []string
+
+	for _, task := range b.stageTasks(userStageName) {
+		output, err := yaml.Marshal(task.Config)
+		if err != nil {
+			panic(fmt.Sprintf("runtime err: %s", err))
+		}
+
+		jsonOutput, err := ghodssYaml.YAMLToJSON(output)
+		if err != nil {
+			panic(fmt.Sprintf("runtime err: %s", err))
+		}
+		configs = append(configs, string(jsonOutput))
+	}
+
+	return configs
+}
+
+func (b *Ansible) stageChecksumInputs(userStageName string) ChecksumInputs {
+	return ChecksumInputs{Commands: b.stageTasksConfigs(userStageName), CacheVersions: b.stageCacheVersions(userStageName)}
+}
+
 func (b *Ansible) stageVersionChecksum(userStageName string) string {
-	var stageVersionChecksumArgs []string
+	if cacheVersions := b.stageCacheVersions(userStageName); len(cacheVersions) != 0 {
+		return util.Sha256Hash(cacheVersions...)
+	} else {
+		return ""
+	}
+}
+
+func (b *Ansible) stageCacheVersions(userStageName string) []string {
+	var cacheVersions []string
 
 	cacheVersionFieldName := "CacheVersion"
 	stageCacheVersionFieldName := strings.Join([]string{userStageName, cacheVersionFieldName}, "")
 
-	stageChecksum, ok := b.configFieldValue(stageCacheVersionFieldName).(string)
+	stageCacheVersion, ok := b.configFieldValue(stageCacheVersionFieldName).(string)
 	if !ok {
-		panic(fmt.Sprintf("runtime error: %#v", stageChecksum))
+		panic(fmt.Sprintf("runtime error: %#v", stageCacheVersion))
 	}
 
-	if stageChecksum != "" {
-		stageVersionChecksumArgs = append(stageVersionChecksumArgs, stageChecksum)
+	if stageCacheVersion != "" {
+		cacheVersions = append(cacheVersions, stageCacheVersion)
 	}
 
-	checksum, ok := b.configFieldValue(cacheVersionFieldName).(string)
+	cacheVersion, ok := b.configFieldValue(cacheVersionFieldName).(string)
 	if !ok {
-		panic(fmt.Sprintf("runtime error: %#v", checksum))
+		panic(fmt.Sprintf("runtime error: %#v", cacheVersion))
 	}
 
-	if checksum != "" {
-		stageVersionChecksumArgs = append(stageVersionChecksumArgs, checksum)
+	if cacheVersion != "" {
+		cacheVersions = append(cacheVersions, cacheVersion)
 	}
 
-	if len(stageVersionChecksumArgs) != 0 {
-		return util.Sha256Hash(stageVersionChecksumArgs...)
-	} else {
-		return ""
-	}
+	return cacheVersions
 }
 
 func (b *Ansible) stageTasks(userStageName string) []*config.AnsibleTask {
@@ -15,6 +15,17 @@ type Builder interface {
 	InstallChecksum() string
 	BeforeSetupChecksum() string
 	SetupChecksum() string
+	BeforeInstallChecksumInputs() ChecksumInputs
+	InstallChecksumInputs() ChecksumInputs
+	BeforeSetupChecksumInputs() ChecksumInputs
+	SetupChecksumInputs() ChecksumInputs
+}
+
+// ChecksumInputs are the user stage commands (shell commands or ansible tasks) and cache versions,
+// which are used to explain the changes of the stage checksum
+type ChecksumInputs struct {
+	Commands      []string
+	CacheVersions []string
 }
 
 type Container interface {
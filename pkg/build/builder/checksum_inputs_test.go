@@ -0,0 +1,41 @@
+package builder
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/werf/pkg/config"
+	"github.com/flant/werf/pkg/util"
+)
+
+var _ = Describe("checksum inputs", func() {
+	It("shell builder returns stage commands and cache versions", func() {
+		b := NewShellBuilder(&config.Shell{
+			Install:             []string{"apt-get update", "apt-get install -y curl"},
+			CacheVersion:        "1",
+			InstallCacheVersion: "2",
+		}, &Extra{})
+
+		Ω(b.InstallChecksumInputs()).Should(Equal(ChecksumInputs{
+			Commands:      []string{"apt-get update", "apt-get install -y curl"},
+			CacheVersions: []string{"2", "1"},
+		}))
+		Ω(b.SetupChecksumInputs()).Should(Equal(ChecksumInputs{CacheVersions: []string{"1"}}))
+	})
+
+	It("shell builder checksum is not changed", func() {
+		b := NewShellBuilder(&config.Shell{Install: []string{"make"}, InstallCacheVersion: "2"}, &Extra{})
+
+		Ω(b.InstallChecksum()).Should(Equal(util.Sha256Hash("make", util.Sha256Hash("2"))))
+		Ω(b.SetupChecksum()).Should(BeEmpty())
+	})
+
+	It("ansible builder returns stage tasks configs", func() {
+		b := NewAnsibleBuilder(&config.Ansible{
+			BeforeInstall: []*config.AnsibleTask{{Config: map[string]interface{}{"apt": map[string]interface{}{"name": "curl"}}}},
+		}, &Extra{})
+
+		Ω(b.BeforeInstallChecksumInputs()).Should(Equal(ChecksumInputs{Commands: []string{`{"apt":{"name":"curl"}}`}}))
+		Ω(b.BeforeInstallChecksum()).Should(Equal(util.Sha256Hash(`{"apt":{"name":"curl"}}`)))
+	})
+})
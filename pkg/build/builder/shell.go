@@ -41,6 +41,22 @@ func (b *Shell) InstallChecksum() string       { return b.stageChecksum("Install
 func (b *Shell) BeforeSetupChecksum() string   { return b.stageChecksum("BeforeSetup") }
 func (b *Shell) SetupChecksum() string         { return b.stageChecksum("Setup") }
 
+func (b *Shell) BeforeInstallChecksumInputs() ChecksumInputs {
+	return b.stageChecksumInputs("BeforeInstall")
+}
+
+func (b *Shell) InstallChecksumInputs() ChecksumInputs {
+	return b.stageChecksumInputs("Install")
+}
+
+func (b *Shell) BeforeSetupChecksumInputs() ChecksumInputs {
+	return b.stageChecksumInputs("BeforeSetup")
+}
+
+func (b *Shell) SetupChecksumInputs() ChecksumInputs {
+	return b.stageChecksumInputs("Setup")
+}
+
 func (b *Shell) isEmptyStage(userStageName string) bool {
 	return b.stageChecksum(userStageName) == ""
 }
@@ -89,35 +105,43 @@ func (b *Shell) stageChecksum(userStageName string) string {
 	}
 }
 
+func (b *Shell) stageChecksumInputs(userStageName string) ChecksumInputs {
+	return ChecksumInputs{Commands: b.stageCommands(userStageName), CacheVersions: b.stageCacheVersions(userStageName)}
+}
+
 func (b *Shell) stageVersionChecksum(userStageName string) string {
-	var stageVersionChecksumArgs []string
+	if cacheVersions := b.stageCacheVersions(userStageName); len(cacheVersions) != 0 {
+		return util.Sha256Hash(cacheVersions...)
+	} else {
+		return ""
+	}
+}
+
+func (b *Shell) stageCacheVersions(userStageName string) []string {
+	var cacheVersions []string
 
 	cacheVersionFieldName := "CacheVersion"
 	stageCacheVersionFieldName := strings.Join([]string{userStageName, cacheVersionFieldName}, "")
 
-	stageChecksum, ok := b.configFieldValue(stageCacheVersionFieldName).(string)
+	stageCacheVersion, ok := b.configFieldValue(stageCacheVersionFieldName).(string)
 	if !ok {
-		panic(fmt.Sprintf("runtime error: %#v", stageChecksum))
+		panic(fmt.Sprintf("runtime error: %#v", stageCacheVersion))
 	}
 
-	if stageChecksum != "" {
-		stageVersionChecksumArgs = append(stageVersionChecksumArgs, stageChecksum)
+	if stageCacheVersion != "" {
+		cacheVersions = append(cacheVersions, stageCacheVersion)
 	}
 
-	checksum, ok := b.configFieldValue(cacheVersionFieldName).(string)
+	cacheVersion, ok := b.configFieldValue(cacheVersionFieldName).(string)
 	if !ok {
-		panic(fmt.Sprintf("runtime error: %#v", checksum))
+		panic(fmt.Sprintf("runtime error: %#v", cacheVersion))
 	}
 
-	if checksum != "" {
-		stageVersionChecksumArgs = append(stageVersionChecksumArgs, checksum)
+	if cacheVersion != "" {
+		cacheVersions = append(cacheVersions, cacheVersion)
 	}
 
-	if len(stageVersionChecksumArgs) != 0 {
-		return util.Sha256Hash(stageVersionChecksumArgs...)
-	} else {
-		return ""
-	}
+	return cacheVersions
 }
 
 func (b *Shell) stageCommands(userStageName string) []string {
@@ -3,14 +3,20 @@ package build
 import (
 	"fmt"
 	"path/filepath"
+	"sync"
 
 	"github.com/flant/logboek"
 	"github.com/flant/shluz"
 
 	"github.com/flant/werf/pkg/build/stage"
 	"github.com/flant/werf/pkg/config"
+	"github.com/flant/werf/pkg/deploy/secret"
+	"github.com/flant/werf/pkg/files_checksum_cache"
 	"github.com/flant/werf/pkg/git_repo"
 	"github.com/flant/werf/pkg/image"
+	"github.com/flant/werf/pkg/image_signing"
+	"github.com/flant/werf/pkg/sbom"
+	"github.com/flant/werf/pkg/stages_storage"
 	"github.com/flant/werf/pkg/util"
 )
 
@@ -24,9 +30,22 @@ type Conveyor struct {
 	localGitRepo                    *git_repo.Local
 	remoteGitRepos                  map[string]*git_repo.Remote
 	imagesBySignature               map[string]image.ImageInterface
+	stageImagesMutexes              map[string]*sync.Mutex
 	globalLocks                     []string
+	report                          *Report
+	sbomScanResults                 map[string]*sbom.ScanResult
+
+	// stages list of the stages storage is fetched once per build and is supplemented by the stored stages
+	stagesList          []*stages_storage.StageDescription
+	stagesListErr       error
+	isStagesListFetched bool
+	stagesListMutex     sync.Mutex
 
 	tmpDir string
+
+	// mutex guards runtime fields, which are used by concurrently processed images in the parallel mode
+	mutex            sync.Mutex
+	globalLocksMutex sync.Mutex
 }
 
 type conveyorPermanentFields struct {
@@ -36,16 +55,29 @@ type conveyorPermanentFields struct {
 	projectDir       string
 	containerWerfDir string
 	baseTmpDir       string
+	stagesStorage    stages_storage.StagesStorage
 
 	baseImagesRepoIdsCache map[string]string
 	baseImagesRepoErrCache map[string]error
 
 	sshAuthSock string
 
-	gitReposCaches map[string]*stage.GitRepoCache
+	gitReposCaches      map[string]*stage.GitRepoCache
+	filesChecksumCaches map[string]*files_checksum_cache.Cache
+
+	secretManager secret.Manager
+
+	devOptions DevOptions
+}
+
+// DevOptions enables building of the working tree of the local git repo instead of the head commit
+type DevOptions struct {
+	Dev bool
+	// IncludeUntracked adds untracked files not ignored by .gitignore
+	IncludeUntracked bool
 }
 
-func NewConveyor(werfConfig *config.WerfConfig, imageNamesToProcess []string, projectDir, baseTmpDir, sshAuthSock string) *Conveyor {
+func NewConveyor(werfConfig *config.WerfConfig, imageNamesToProcess []string, projectDir, baseTmpDir, sshAuthSock string, stagesStorage stages_storage.StagesStorage) *Conveyor {
 	c := &Conveyor{
 		conveyorPermanentFields: &conveyorPermanentFields{
 			werfConfig:          werfConfig,
@@ -54,10 +86,12 @@ func NewConveyor(werfConfig *config.WerfConfig, imageNamesToProcess []string, pr
 			projectDir:       projectDir,
 			containerWerfDir: "/.werf",
 			baseTmpDir:       baseTmpDir,
+			stagesStorage:    stagesStorage,
 
 			sshAuthSock: sshAuthSock,
 
-			gitReposCaches: make(map[string]*stage.GitRepoCache),
+			gitReposCaches:      make(map[string]*stage.GitRepoCache),
+			filesChecksumCaches: make(map[string]*files_checksum_cache.Cache),
 
 			baseImagesRepoIdsCache: make(map[string]string),
 			baseImagesRepoErrCache: make(map[string]error),
@@ -68,6 +102,10 @@ func NewConveyor(werfConfig *config.WerfConfig, imageNamesToProcess []string, pr
 	return c
 }
 
+func (c *Conveyor) SetDevOptions(opts DevOptions) {
+	c.devOptions = opts
+}
+
 func (c *Conveyor) Terminate() error {
 	for gitRepoName, gitRepoCache := range c.gitReposCaches {
 		if err := gitRepoCache.Terminate(); err != nil {
@@ -75,9 +113,22 @@ func (c *Conveyor) Terminate() error {
 		}
 	}
 
+	for dir, filesChecksumCache := range c.filesChecksumCaches {
+		if err := filesChecksumCache.Save(); err != nil {
+			return fmt.Errorf("unable to save files checksum cache of dir '%s': %s", dir, err)
+		}
+	}
+
 	return nil
 }
 
+func (c *Conveyor) GetFilesChecksumCache(dir string) *files_checksum_cache.Cache {
+	if _, hasKey := c.filesChecksumCaches[dir]; !hasKey {
+		c.filesChecksumCaches[dir] = files_checksum_cache.NewCache(dir)
+	}
+	return c.filesChecksumCaches[dir]
+}
+
 func (c *Conveyor) GetGitRepoCache(gitRepoName string) *stage.GitRepoCache {
 	if _, hasKey := c.gitReposCaches[gitRepoName]; !hasKey {
 		c.gitReposCaches[gitRepoName] = &stage.GitRepoCache{
@@ -98,31 +149,71 @@ func (c *Conveyor) ReInitRuntimeFields() {
 
 	c.buildingGitStageNameByImageName = make(map[string]stage.StageName)
 
+	c.stageImagesMutexes = make(map[string]*sync.Mutex)
+
 	c.localGitRepo = nil
 	c.remoteGitRepos = make(map[string]*git_repo.Remote)
 
 	c.tmpDir = filepath.Join(c.baseTmpDir, string(util.GenerateConsistentRandomString(10)))
 
 	c.globalLocks = nil
+
+	c.report = newReport()
+
+	c.sbomScanResults = make(map[string]*sbom.ScanResult)
+
+	c.stagesList = nil
+	c.stagesListErr = nil
+	c.isStagesListFetched = false
+}
+
+func (c *Conveyor) GetReport() *Report {
+	return c.report
 }
 
+func (c *Conveyor) WriteReport(opts ReportOptions) error {
+	if opts.ReportPath == "" {
+		return nil
+	}
+
+	return c.report.WriteToFile(opts.ReportPath, opts.ReportFormat)
+}
+
+// AcquireGlobalLock takes the shluz lock once per conveyor run, the mutex guards only the list of acquired locks,
+// so images waiting for different locks do not block each other
 func (c *Conveyor) AcquireGlobalLock(name string, opts shluz.LockOptions) error {
-	for _, lockName := range c.globalLocks {
-		if lockName == name {
-			return nil
-		}
+	if c.isGlobalLockAcquired(name) {
+		return nil
 	}
 
 	if err := shluz.Lock(name, opts); err != nil {
 		return err
 	}
 
+	c.globalLocksMutex.Lock()
+	defer c.globalLocksMutex.Unlock()
+
+	if util.IsStringsContainValue(c.globalLocks, name) {
+		// the lock has been acquired concurrently, shluz locks are reentrant
+		return shluz.Unlock(name)
+	}
+
 	c.globalLocks = append(c.globalLocks, name)
 
 	return nil
 }
 
+func (c *Conveyor) isGlobalLockAcquired(name string) bool {
+	c.globalLocksMutex.Lock()
+	defer c.globalLocksMutex.Unlock()
+
+	return util.IsStringsContainValue(c.globalLocks, name)
+}
+
 func (c *Conveyor) ReleaseGlobalLock(name string) error {
+	c.globalLocksMutex.Lock()
+	defer c.globalLocksMutex.Unlock()
+
 	ind := -1
 	for i, lockName := range c.globalLocks {
 		if lockName == name {
@@ -142,6 +233,9 @@ func (c *Conveyor) ReleaseGlobalLock(name string) error {
 }
 
 func (c *Conveyor) ReleaseAllGlobalLocks() error {
+	c.globalLocksMutex.Lock()
+	defer c.globalLocksMutex.Unlock()
+
 	for len(c.globalLocks) > 0 {
 		var lockName string
 		lockName, c.globalLocks = c.globalLocks[0], c.globalLocks[1:]
@@ -157,9 +251,11 @@ type Phase interface {
 	Run(*Conveyor) error
 }
 
-func (c *Conveyor) BuildStages(stageRepo string, opts BuildStagesOptions) error {
+func (c *Conveyor) BuildStages(opts BuildStagesOptions) error {
+	opts.validateParallelOptions()
+
 restart:
-	if err := c.buildStages(stageRepo, opts); err != nil {
+	if err := c.buildStages(opts); err != nil {
 		if isConveyorShouldBeResetError(err) {
 			c.ReleaseAllGlobalLocks()
 			c.ReInitRuntimeFields()
@@ -172,15 +268,18 @@ restart:
 	return nil
 }
 
-func (c *Conveyor) buildStages(stageRepo string, opts BuildStagesOptions) error {
+func (c *Conveyor) buildStages(opts BuildStagesOptions) error {
 	var err error
 
 	var phases []Phase
 	phases = append(phases, NewInitializationPhase())
-	phases = append(phases, NewSignaturesPhase(true))
+	phases = append(phases, NewSignaturesPhase(true, opts.ParallelOptions))
 	phases = append(phases, NewRenewPhase())
 	phases = append(phases, NewPrepareStagesPhase())
-	phases = append(phases, NewBuildStagesPhase(stageRepo, opts))
+	phases = append(phases, NewBuildStagesPhase(opts))
+	if opts.SBOMOptions.enabled() {
+		phases = append(phases, NewSBOMPhase(opts.SBOMOptions))
+	}
 
 	lockName, err := c.lockAllImagesReadOnly()
 	if err != nil {
@@ -207,13 +306,45 @@ type ImagesRepoManager interface {
 
 type PublishImagesOptions struct {
 	TagOptions
+
+	ImageSigner *image_signing.Signer // published images are not signed if nil
+	SBOMOptions SBOMOptions           // SBOM is written (and attached) per published tag
+	WithReport  bool                  // digests of published tags are fetched only for the report, signing or SBOM
 }
 
 func (c *Conveyor) ShouldBeBuilt() error {
 	var phases []Phase
 	phases = append(phases, NewInitializationPhase())
-	phases = append(phases, NewSignaturesPhase(false))
+	phases = append(phases, NewSignaturesPhase(false, ParallelOptions{}))
+	phases = append(phases, NewShouldBeBuiltPhase())
+
+	return c.runPhases(phases)
+}
+
+func (c *Conveyor) Plan() error {
+	var phases []Phase
+	phases = append(phases, NewInitializationPhase())
+	// plan is read-only, the stages are not fetched from the stages storage
+	signaturesPhase := NewSignaturesPhase(false, ParallelOptions{})
+	signaturesPhase.SkipFetchStages = true
+	phases = append(phases, signaturesPhase)
+	phases = append(phases, NewPlanPhase())
+
+	return c.runPhases(phases)
+}
+
+func (c *Conveyor) ExportStages(opts ExportStagesOptions) error {
+	var phases []Phase
+	phases = append(phases, NewInitializationPhase())
+	phases = append(phases, NewSignaturesPhase(false, ParallelOptions{}))
 	phases = append(phases, NewShouldBeBuiltPhase())
+	phases = append(phases, NewExportStagesPhase(opts))
+
+	lockName, err := c.lockAllImagesReadOnly()
+	if err != nil {
+		return err
+	}
+	defer shluz.Unlock(lockName)
 
 	return c.runPhases(phases)
 }
@@ -223,7 +354,7 @@ func (c *Conveyor) PublishImages(imagesRepoManager ImagesRepoManager, opts Publi
 
 	var phases []Phase
 	phases = append(phases, NewInitializationPhase())
-	phases = append(phases, NewSignaturesPhase(false))
+	phases = append(phases, NewSignaturesPhase(false, ParallelOptions{}))
 	phases = append(phases, NewShouldBeBuiltPhase())
 	phases = append(phases, NewPublishImagesPhase(imagesRepoManager, opts))
 
@@ -241,9 +372,11 @@ type BuildAndPublishOptions struct {
 	PublishImagesOptions
 }
 
-func (c *Conveyor) BuildAndPublish(stagesRepo string, imagesRepoManager ImagesRepoManager, opts BuildAndPublishOptions) error {
+func (c *Conveyor) BuildAndPublish(imagesRepoManager ImagesRepoManager, opts BuildAndPublishOptions) error {
+	opts.BuildStagesOptions.validateParallelOptions()
+
 restart:
-	if err := c.buildAndPublish(stagesRepo, imagesRepoManager, opts); err != nil {
+	if err := c.buildAndPublish(imagesRepoManager, opts); err != nil {
 		if isConveyorShouldBeResetError(err) {
 			c.ReInitRuntimeFields()
 			goto restart
@@ -255,15 +388,15 @@ restart:
 	return nil
 }
 
-func (c *Conveyor) buildAndPublish(stagesRepo string, imagesRepoManager ImagesRepoManager, opts BuildAndPublishOptions) error {
+func (c *Conveyor) buildAndPublish(imagesRepoManager ImagesRepoManager, opts BuildAndPublishOptions) error {
 	var err error
 
 	var phases []Phase
 	phases = append(phases, NewInitializationPhase())
-	phases = append(phases, NewSignaturesPhase(true))
+	phases = append(phases, NewSignaturesPhase(true, opts.ParallelOptions))
 	phases = append(phases, NewRenewPhase())
 	phases = append(phases, NewPrepareStagesPhase())
-	phases = append(phases, NewBuildStagesPhase(stagesRepo, opts.BuildStagesOptions))
+	phases = append(phases, NewBuildStagesPhase(opts.BuildStagesOptions))
 	phases = append(phases, NewPublishImagesPhase(imagesRepoManager, opts.PublishImagesOptions))
 
 	lockName, err := c.lockAllImagesReadOnly()
@@ -304,10 +437,28 @@ func (c *Conveyor) lockAllImagesReadOnly() (string, error) {
 }
 
 func (c *Conveyor) GetStageImage(name string) *image.StageImage {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	return c.stageImages[name]
 }
 
+// GetStageImageMutex returns the mutex to prevent concurrent building of the same stage image by several images
+func (c *Conveyor) GetStageImageMutex(name string) *sync.Mutex {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.stageImagesMutexes[name]; !ok {
+		c.stageImagesMutexes[name] = &sync.Mutex{}
+	}
+
+	return c.stageImagesMutexes[name]
+}
+
 func (c *Conveyor) GetOrCreateImage(fromImage *image.StageImage, name string) *image.StageImage {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	if img, ok := c.stageImages[name]; ok {
 		return img
 	}
@@ -318,10 +469,16 @@ func (c *Conveyor) GetOrCreateImage(fromImage *image.StageImage, name string) *i
 }
 
 func (c *Conveyor) GetImageBySignature(signature string) image.ImageInterface {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	return c.imagesBySignature[signature]
 }
 
 func (c *Conveyor) SetImageBySignature(signature string, img image.ImageInterface) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	c.imagesBySignature[signature] = img
 }
 
@@ -335,6 +492,27 @@ func (c *Conveyor) GetImage(name string) *Image {
 	panic(fmt.Sprintf("Image '%s' not found!", name))
 }
 
+func (c *Conveyor) GetProjectDir() string {
+	return c.projectDir
+}
+
+// GetSecretManager returns the secret manager to decrypt build secrets, the secret key is required only if secrets are used
+func (c *Conveyor) GetSecretManager() (secret.Manager, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.secretManager == nil {
+		m, err := secret.GetManager(c.projectDir)
+		if err != nil {
+			return nil, err
+		}
+
+		c.secretManager = m
+	}
+
+	return c.secretManager, nil
+}
+
 func (c *Conveyor) GetImageLatestStageSignature(imageName string) string {
 	return c.GetImage(imageName).LatestStage().GetSignature()
 }
@@ -344,10 +522,16 @@ func (c *Conveyor) GetImageLatestStageImageName(imageName string) string {
 }
 
 func (c *Conveyor) SetBuildingGitStage(imageName string, stageName stage.StageName) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	c.buildingGitStageNameByImageName[imageName] = stageName
 }
 
 func (c *Conveyor) GetBuildingGitStage(imageName string) stage.StageName {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	stageName, ok := c.buildingGitStageNameByImageName[imageName]
 	if !ok {
 		return ""
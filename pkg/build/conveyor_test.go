@@ -0,0 +1,96 @@
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/flant/shluz"
+	"github.com/gofrs/flock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	imagePkg "github.com/flant/werf/pkg/image"
+)
+
+var _ = Describe("global locks", func() {
+	var locksDir string
+
+	// sharedStageLockName is the lock of the stage image, which is the common stage of two images
+	sharedStageLockName := imagePkg.ImageLockName("project:shared-stage")
+	otherStageLockName := imagePkg.ImageLockName("project:other-stage")
+
+	BeforeEach(func() {
+		var err error
+		locksDir, err = ioutil.TempDir("", "werf-conveyor-locks-test")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(shluz.Init(locksDir)).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		Ω(os.RemoveAll(locksDir)).Should(Succeed())
+	})
+
+	isLocked := func(name string) bool {
+		f := flock.New(shluz.NewFileLock(name, locksDir).(*shluz.FileLock).LockFilePath())
+		locked, err := f.TryLock()
+		Ω(err).ShouldNot(HaveOccurred())
+		if locked {
+			Ω(f.Unlock()).Should(Succeed())
+		}
+
+		return !locked
+	}
+
+	It("takes the lock of the stage shared by two images once", func() {
+		c := &Conveyor{}
+
+		// both images lock the shared stage
+		Ω(c.AcquireGlobalLock(sharedStageLockName, shluz.LockOptions{})).Should(Succeed())
+		Ω(c.AcquireGlobalLock(sharedStageLockName, shluz.LockOptions{})).Should(Succeed())
+		Ω(c.globalLocks).Should(Equal([]string{sharedStageLockName}))
+		Ω(isLocked(sharedStageLockName)).Should(BeTrue())
+
+		// the stage is built by the first image and released, the second image does not release it again
+		Ω(c.ReleaseGlobalLock(sharedStageLockName)).Should(Succeed())
+		Ω(isLocked(sharedStageLockName)).Should(BeFalse())
+		Ω(c.ReleaseGlobalLock(sharedStageLockName)).Should(Succeed())
+		Ω(c.globalLocks).Should(BeEmpty())
+	})
+
+	It("does not block other locks while waiting for the lock held by another process", func() {
+		c := &Conveyor{}
+
+		otherProcessLock := flock.New(shluz.NewFileLock(sharedStageLockName, locksDir).(*shluz.FileLock).LockFilePath())
+		Ω(otherProcessLock.Lock()).Should(Succeed())
+
+		acquired := make(chan error)
+		go func() {
+			acquired <- c.AcquireGlobalLock(sharedStageLockName, shluz.LockOptions{})
+		}()
+
+		Consistently(acquired, 100*time.Millisecond).ShouldNot(Receive())
+
+		done := make(chan error)
+		go func() {
+			done <- c.AcquireGlobalLock(otherStageLockName, shluz.LockOptions{})
+		}()
+		Eventually(done, time.Second).Should(Receive(BeNil()))
+
+		Ω(otherProcessLock.Unlock()).Should(Succeed())
+		Eventually(acquired, 2*time.Second).Should(Receive(BeNil()))
+
+		Ω(c.globalLocks).Should(ConsistOf(sharedStageLockName, otherStageLockName))
+		Ω(c.ReleaseAllGlobalLocks()).Should(Succeed())
+	})
+
+	DescribeTable("processes images sequentially when stages images are locked",
+		func(lockImages bool, expected ParallelOptions) {
+			p := NewSignaturesPhase(lockImages, ParallelOptions{Parallel: true, ParallelTasksLimit: 4})
+			Ω(p.parallelOptions()).Should(Equal(expected))
+		},
+		Entry("build", true, ParallelOptions{}),
+		Entry("without locks", false, ParallelOptions{Parallel: true, ParallelTasksLimit: 4}),
+	)
+})
@@ -0,0 +1,39 @@
+package build
+
+import (
+	"fmt"
+
+	"github.com/flant/logboek"
+
+	"github.com/flant/werf/pkg/stages_archive"
+	"github.com/flant/werf/pkg/util"
+)
+
+type ExportStagesOptions struct {
+	OutputPath string
+	Format     string
+}
+
+func NewExportStagesPhase(opts ExportStagesOptions) *ExportStagesPhase {
+	return &ExportStagesPhase{ExportStagesOptions: opts}
+}
+
+// ExportStagesPhase saves all stages of the images into one tarball, which can be loaded by werf stages import
+type ExportStagesPhase struct {
+	ExportStagesOptions
+}
+
+func (p *ExportStagesPhase) Run(c *Conveyor) error {
+	var stagesImagesNames []string
+	for _, image := range c.imagesInOrder {
+		for _, s := range image.GetStages() {
+			stagesImagesNames = util.UniqAppendString(stagesImagesNames, s.GetImage().Name())
+		}
+	}
+
+	logProcessMsg := fmt.Sprintf("Exporting %d stages into %s", len(stagesImagesNames), p.OutputPath)
+	logProcessOptions := logboek.LogProcessOptions{ColorizeMsgFunc: logboek.ColorizeHighlight}
+	return logboek.LogProcess(logProcessMsg, logProcessOptions, func() error {
+		return stages_archive.Save(stagesImagesNames, p.OutputPath, p.Format)
+	})
+}
@@ -23,6 +23,7 @@ type Image struct {
 	baseImage         *image.StageImage
 	isArtifact        bool
 	isDockerfileImage bool
+	hasDevChanges     bool
 }
 
 func (i *Image) LogName() string {
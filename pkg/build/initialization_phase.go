@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path"
 	"path/filepath"
 	"reflect"
@@ -270,9 +271,11 @@ func generateGitMappings(imageBaseConfig *config.StapelImageBase, c *Conveyor) (
 	if len(imageBaseConfig.Git.Local) != 0 {
 		if c.localGitRepo == nil {
 			c.localGitRepo = &git_repo.Local{
-				Base:   git_repo.Base{Name: "own"},
-				Path:   c.projectDir,
-				GitDir: filepath.Join(c.projectDir, ".git"),
+				Base:                git_repo.Base{Name: "own"},
+				Path:                c.projectDir,
+				GitDir:              filepath.Join(c.projectDir, ".git"),
+				Dev:                 c.devOptions.Dev,
+				DevIncludeUntracked: c.devOptions.IncludeUntracked,
 			}
 		}
 
@@ -284,11 +287,19 @@ func generateGitMappings(imageBaseConfig *config.StapelImageBase, c *Conveyor) (
 	}
 
 	for _, remoteGitMappingConfig := range imageBaseConfig.Git.Remote {
+		remoteAuth, err := getRemoteAuth(remoteGitMappingConfig.Auth, c.projectDir)
+		if err != nil {
+			return nil, fmt.Errorf("bad auth of %s repository: %s", remoteGitMappingConfig.Name, err)
+		}
+
 		remoteGitRepo, exist := c.remoteGitRepos[remoteGitMappingConfig.Name]
 		if !exist {
 			remoteGitRepo = &git_repo.Remote{
-				Base: git_repo.Base{Name: remoteGitMappingConfig.Name},
-				Url:  remoteGitMappingConfig.Url,
+				Base:         git_repo.Base{Name: remoteGitMappingConfig.Name},
+				Url:          remoteGitMappingConfig.Url,
+				Shallow:      remoteGitMappingConfig.Shallow,
+				PartialClone: remoteGitMappingConfig.PartialClone,
+				Auth:         remoteAuth,
 			}
 
 			if err := logboek.LogProcess(fmt.Sprintf("Refreshing %s repository", remoteGitMappingConfig.Name), logboek.LogProcessOptions{}, func() error {
@@ -298,9 +309,22 @@ func generateGitMappings(imageBaseConfig *config.StapelImageBase, c *Conveyor) (
 			}
 
 			c.remoteGitRepos[remoteGitMappingConfig.Name] = remoteGitRepo
+		} else if remoteGitRepo.Shallow != remoteGitMappingConfig.Shallow || remoteGitRepo.PartialClone != remoteGitMappingConfig.PartialClone {
+			return nil, fmt.Errorf("all git mappings of %s repository should have the same `shallow` and `partialClone` directives", remoteGitMappingConfig.Name)
+		} else if !reflect.DeepEqual(remoteGitRepo.Auth, remoteAuth) {
+			return nil, fmt.Errorf("all git mappings of %s repository should have the same `auth` directive", remoteGitMappingConfig.Name)
 		}
 
-		gitMappings = append(gitMappings, gitRemoteArtifactInit(remoteGitMappingConfig, remoteGitRepo, imageBaseConfig.Name, c))
+		if err := remoteGitRepo.ShallowFetch(remoteGitMappingConfig.Branch, remoteGitMappingConfig.Tag, remoteGitMappingConfig.Commit); err != nil {
+			return nil, err
+		}
+
+		gitMapping := gitRemoteArtifactInit(remoteGitMappingConfig, remoteGitRepo, imageBaseConfig.Name, c)
+		if remoteGitRepo.PartialClone {
+			remoteGitRepo.AddSparseCheckoutPaths(gitMapping.RepoPath, gitMapping.IncludePaths)
+		}
+
+		gitMappings = append(gitMappings, gitMapping)
 	}
 
 	var res []*stage.GitMapping
@@ -404,6 +428,45 @@ func getNonEmptyGitMappings(gitMappings []*stage.GitMapping) ([]*stage.GitMappin
 	return nonEmptyGitMappings, nil
 }
 
+// getRemoteAuth reads credentials from the environment, so that werf config contains env var names only
+func getRemoteAuth(authConfig *config.GitAuth, projectDir string) (*git_repo.RemoteAuth, error) {
+	if authConfig == nil {
+		return nil, nil
+	}
+
+	auth := &git_repo.RemoteAuth{
+		Username:         authConfig.Username,
+		CredentialHelper: authConfig.CredentialHelper,
+	}
+
+	switch {
+	case authConfig.TokenEnv != "":
+		auth.Password = os.Getenv(authConfig.TokenEnv)
+		if auth.Password == "" {
+			return nil, fmt.Errorf("token env var %s is not set", authConfig.TokenEnv)
+		}
+
+		if auth.Username == "" {
+			auth.Username = "oauth2"
+		}
+	case authConfig.PasswordEnv != "":
+		auth.Password = os.Getenv(authConfig.PasswordEnv)
+		if auth.Password == "" {
+			return nil, fmt.Errorf("password env var %s is not set", authConfig.PasswordEnv)
+		}
+	}
+
+	if authConfig.CaFile != "" {
+		if filepath.IsAbs(authConfig.CaFile) || strings.HasPrefix(authConfig.CaFile, "~") {
+			auth.CAFile = util.ExpandPath(authConfig.CaFile)
+		} else {
+			auth.CAFile = filepath.Join(projectDir, authConfig.CaFile)
+		}
+	}
+
+	return auth, nil
+}
+
 func gitRemoteArtifactInit(remoteGitMappingConfig *config.GitRemote, remoteGitRepo *git_repo.Remote, imageName string, c *Conveyor) *stage.GitMapping {
 	gitMapping := baseGitMappingInit(remoteGitMappingConfig.GitLocalExport, imageName, c)
 
@@ -623,8 +686,14 @@ func prepareImageBasedOnImageFromDockerfile(imageFromDockerfileConfig *config.Im
 		return nil, err
 	}
 
+	buildKitOptions, err := getDockerfileBuildKitOptions(imageFromDockerfileConfig, c)
+	if err != nil {
+		return nil, err
+	}
+
 	baseStageOptions := &stage.NewBaseStageOptions{
 		ImageName:   imageFromDockerfileConfig.Name,
+		ImageTmpDir: c.GetImageTmpDir(imageFromDockerfileConfig.Name),
 		ProjectName: c.werfConfig.Meta.Project,
 	}
 
@@ -633,13 +702,30 @@ func prepareImageBasedOnImageFromDockerfile(imageFromDockerfileConfig *config.Im
 		imageFromDockerfileConfig.Target,
 		contextDir,
 		dockerignorePatternMatcher,
+		c.GetFilesChecksumCache(contextDir),
 		imageFromDockerfileConfig.Args,
 		imageFromDockerfileConfig.AddHost,
+		buildKitOptions,
 		dockerStages,
 		dockerArgsHash,
 		dockerTargetIndex,
 		baseStageOptions)
 
+	if imageFromDockerfileConfig.SplitStages != "" {
+		partStages, err := stage.GenerateDockerfilePartStages(dockerfileStage, imageFromDockerfileConfig.SplitStages, baseStageOptions)
+		if err != nil {
+			return nil, fmt.Errorf("unable to split dockerfile %s: %s", dockerfilePath, err)
+		}
+
+		for _, partStage := range partStages {
+			image.stages = append(image.stages, partStage)
+
+			logboek.LogInfoF("Using stage %s\n", partStage.Name())
+		}
+
+		return image, nil
+	}
+
 	image.stages = append(image.stages, dockerfileStage)
 
 	logboek.LogInfoF("Using stage %s\n", dockerfileStage.Name())
@@ -647,6 +733,37 @@ func prepareImageBasedOnImageFromDockerfile(imageFromDockerfileConfig *config.Im
 	return image, nil
 }
 
+func getDockerfileBuildKitOptions(imageFromDockerfileConfig *config.ImageFromDockerfile, c *Conveyor) (*stage.DockerfileBuildKitOptions, error) {
+	if !imageFromDockerfileConfig.IsBuildKit() {
+		return nil, nil
+	}
+
+	options := &stage.DockerfileBuildKitOptions{
+		Secrets: map[string]string{},
+		SSH:     imageFromDockerfileConfig.SSH,
+	}
+
+	for _, secret := range imageFromDockerfileConfig.Secrets {
+		var secretPath string
+		if strings.HasPrefix(secret.Src, "~") || filepath.IsAbs(secret.Src) {
+			secretPath = util.ExpandPath(secret.Src)
+		} else {
+			secretPath = filepath.Join(c.projectDir, secret.Src)
+		}
+
+		exist, err := util.FileExists(secretPath)
+		if err != nil {
+			return nil, err
+		} else if !exist {
+			return nil, fmt.Errorf("secret %s file %s is not found", secret.Id, secretPath)
+		}
+
+		options.Secrets[secret.Id] = secretPath
+	}
+
+	return options, nil
+}
+
 func resolveDockerStagesFromValue(stages []instructions.Stage) {
 	nameToIndex := make(map[string]string)
 	for i, s := range stages {
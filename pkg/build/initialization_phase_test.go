@@ -0,0 +1,65 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/werf/pkg/config"
+	"github.com/flant/werf/pkg/git_repo"
+)
+
+var _ = Describe("remote git auth", func() {
+	BeforeEach(func() {
+		Ω(os.Setenv("WERF_TEST_GIT_TOKEN", "token")).Should(Succeed())
+		Ω(os.Unsetenv("WERF_TEST_GIT_UNSET")).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		Ω(os.Unsetenv("WERF_TEST_GIT_TOKEN")).Should(Succeed())
+	})
+
+	DescribeTable("reads credentials from env",
+		func(authConfig *config.GitAuth, expectedAuth *git_repo.RemoteAuth, expectedError string) {
+			auth, err := getRemoteAuth(authConfig, "/project")
+			if expectedError != "" {
+				Ω(err).Should(HaveOccurred())
+				Ω(err.Error()).Should(ContainSubstring(expectedError))
+				return
+			}
+
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(auth).Should(Equal(expectedAuth))
+		},
+		Entry("no auth", nil, nil, ""),
+		Entry("token with default username",
+			&config.GitAuth{TokenEnv: "WERF_TEST_GIT_TOKEN"},
+			&git_repo.RemoteAuth{Username: "oauth2", Password: "token"}, "",
+		),
+		Entry("token with username",
+			&config.GitAuth{Username: "user", TokenEnv: "WERF_TEST_GIT_TOKEN"},
+			&git_repo.RemoteAuth{Username: "user", Password: "token"}, "",
+		),
+		Entry("password",
+			&config.GitAuth{Username: "user", PasswordEnv: "WERF_TEST_GIT_TOKEN"},
+			&git_repo.RemoteAuth{Username: "user", Password: "token"}, "",
+		),
+		Entry("credential helper",
+			&config.GitAuth{CredentialHelper: "store"},
+			&git_repo.RemoteAuth{CredentialHelper: "store"}, "",
+		),
+		Entry("relative CA file",
+			&config.GitAuth{CaFile: "certs/ca.pem"},
+			&git_repo.RemoteAuth{CAFile: filepath.Join("/project", "certs/ca.pem")}, "",
+		),
+		Entry("absolute CA file",
+			&config.GitAuth{CaFile: "/etc/ssl/ca.pem"},
+			&git_repo.RemoteAuth{CAFile: "/etc/ssl/ca.pem"}, "",
+		),
+		Entry("unset token env", &config.GitAuth{TokenEnv: "WERF_TEST_GIT_UNSET"}, nil, "token env var WERF_TEST_GIT_UNSET is not set"),
+		Entry("unset password env", &config.GitAuth{Username: "user", PasswordEnv: "WERF_TEST_GIT_UNSET"}, nil, "password env var WERF_TEST_GIT_UNSET is not set"),
+	)
+})
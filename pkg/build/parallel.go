@@ -0,0 +1,206 @@
+package build
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/flant/logboek"
+
+	"github.com/flant/werf/pkg/config"
+	"github.com/flant/werf/pkg/util"
+)
+
+type ParallelOptions struct {
+	Parallel           bool
+	ParallelTasksLimit int
+}
+
+func (opts ParallelOptions) tasksLimit() int {
+	if opts.ParallelTasksLimit > 0 {
+		return opts.ParallelTasksLimit
+	}
+
+	return runtime.NumCPU()
+}
+
+// imageDependencies returns names of images and artifacts which should be processed before the image:
+// fromImage, fromImageArtifact and imports
+func (c *Conveyor) imageDependencies(img *Image) []string {
+	var dependencies []string
+
+	if img.baseImageImageName != "" {
+		dependencies = append(dependencies, img.baseImageImageName)
+	}
+
+	var imageBaseConfig *config.StapelImageBase
+	if imageConfig := c.werfConfig.GetStapelImage(img.name); imageConfig != nil {
+		imageBaseConfig = imageConfig.ImageBaseConfig()
+	} else if artifactConfig := c.werfConfig.GetArtifact(img.name); artifactConfig != nil {
+		imageBaseConfig = artifactConfig.ImageBaseConfig()
+	}
+
+	if imageBaseConfig != nil {
+		for _, importConfig := range imageBaseConfig.Import {
+			importImageName := importConfig.ImageName
+			if importImageName == "" {
+				importImageName = importConfig.ArtifactName
+			}
+
+			if !util.IsStringsContainValue(dependencies, importImageName) {
+				dependencies = append(dependencies, importImageName)
+			}
+		}
+	}
+
+	return dependencies
+}
+
+type imageTaskResult struct {
+	image *Image
+	err   error
+}
+
+// runImagesTasks runs taskFunc for each image of the conveyor inside the image log process.
+// In the parallel mode independent images are processed concurrently (not more than ParallelTasksLimit at once),
+// the image is started only when all images it depends on are successfully processed.
+// The image log is accumulated by the task and is written at once, when the task is done.
+func (c *Conveyor) runImagesTasks(opts ParallelOptions, taskFunc func(img *Image, log *imageLog) error) error {
+	if !opts.Parallel || len(c.imagesInOrder) < 2 {
+		for _, img := range c.imagesInOrder {
+			if err := logboek.LogProcess(img.LogDetailedName(), logboek.LogProcessOptions{ColorizeMsgFunc: img.LogProcessColorizeFunc()}, func() error {
+				return taskFunc(img, newImageLog(false))
+			}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	logs := map[*Image]*imageLog{}
+	pending := map[*Image]bool{}
+	done := map[string]bool{}
+	for _, img := range c.imagesInOrder {
+		logs[img] = newImageLog(true)
+		pending[img] = true
+	}
+
+	isReady := func(img *Image) bool {
+		for _, dependency := range c.imageDependencies(img) {
+			if !done[dependency] {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	results := make(chan imageTaskResult)
+	limit := opts.tasksLimit()
+	running := 0
+
+	var resErr error
+	for {
+		if resErr == nil {
+			for _, img := range c.imagesInOrder {
+				if running >= limit {
+					break
+				}
+
+				if !pending[img] || !isReady(img) {
+					continue
+				}
+
+				delete(pending, img)
+				running++
+
+				go func(img *Image) {
+					results <- imageTaskResult{image: img, err: taskFunc(img, logs[img])}
+				}(img)
+			}
+		}
+
+		if running == 0 {
+			break
+		}
+
+		res := <-results
+		running--
+
+		if err := logs[res.image].WithGlobalLog(func() error {
+			return logboek.LogProcess(res.image.LogDetailedName(), logboek.LogProcessOptions{ColorizeMsgFunc: res.image.LogProcessColorizeFunc()}, func() error {
+				logs[res.image].Flush()
+				return res.err
+			})
+		}); err != nil {
+			if resErr == nil {
+				resErr = err
+			}
+
+			continue
+		}
+
+		done[res.image.name] = true
+	}
+
+	if resErr == nil && len(pending) != 0 {
+		return fmt.Errorf("unable to resolve images dependencies: %d images left unprocessed", len(pending))
+	}
+
+	return resErr
+}
+
+// globalLogMutex serializes usage of the global logger (log processes, indents) by concurrently processed images
+var globalLogMutex sync.Mutex
+
+// imageLog writes log records immediately or accumulates them to write at once by Flush
+// (when the image is processed concurrently with other images)
+type imageLog struct {
+	isBuffered bool
+	records    []func()
+	mutex      sync.Mutex
+}
+
+func newImageLog(isBuffered bool) *imageLog {
+	return &imageLog{isBuffered: isBuffered}
+}
+
+func (l *imageLog) Add(record func()) {
+	if !l.isBuffered {
+		record()
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.records = append(l.records, record)
+}
+
+// WithGlobalLog runs f, which writes to the global logger directly, exclusively with other concurrently processed images
+func (l *imageLog) WithGlobalLog(f func() error) error {
+	if !l.isBuffered {
+		return f()
+	}
+
+	globalLogMutex.Lock()
+	defer globalLogMutex.Unlock()
+
+	return f()
+}
+
+func (l *imageLog) LogInfoF(format string, a ...interface{}) {
+	l.Add(func() { logboek.LogInfoF(format, a...) })
+}
+
+func (l *imageLog) Flush() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, record := range l.records {
+		record()
+	}
+
+	l.records = nil
+}
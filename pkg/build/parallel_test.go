@@ -0,0 +1,189 @@
+package build
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/werf/pkg/config"
+)
+
+// tasksRecorder records the order of started and finished images and the max number of concurrently running tasks
+type tasksRecorder struct {
+	mutex      sync.Mutex
+	started    []string
+	finished   []string
+	running    int
+	maxRunning int
+}
+
+func (r *tasksRecorder) task(failedImageName string) func(img *Image, log *imageLog) error {
+	return func(img *Image, log *imageLog) error {
+		r.mutex.Lock()
+		r.started = append(r.started, img.name)
+		r.running++
+		if r.running > r.maxRunning {
+			r.maxRunning = r.running
+		}
+		r.mutex.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		r.mutex.Lock()
+		r.finished = append(r.finished, img.name)
+		r.running--
+		r.mutex.Unlock()
+
+		if img.name == failedImageName {
+			return errors.New("task failed")
+		}
+
+		return nil
+	}
+}
+
+func (r *tasksRecorder) index(list []string, name string) int {
+	for ind, value := range list {
+		if value == name {
+			return ind
+		}
+	}
+
+	return -1
+}
+
+func stapelImageConfig(name, fromImageName string, importImageNames ...string) *config.StapelImage {
+	imageBaseConfig := &config.StapelImageBase{Name: name, FromImageName: fromImageName}
+	for _, importImageName := range importImageNames {
+		imageBaseConfig.Import = append(imageBaseConfig.Import, &config.Import{ImageName: importImageName})
+	}
+
+	return &config.StapelImage{StapelImageBase: imageBaseConfig}
+}
+
+// newTasksConveyor returns the conveyor with images: a and b are independent, c imports a, d is based on c and imports a and b
+func newTasksConveyor() *Conveyor {
+	werfConfig := &config.WerfConfig{
+		StapelImages: []*config.StapelImage{
+			stapelImageConfig("a", ""),
+			stapelImageConfig("b", ""),
+			stapelImageConfig("c", "", "a"),
+			stapelImageConfig("d", "c", "a", "b", "a"),
+		},
+	}
+
+	c := &Conveyor{conveyorPermanentFields: &conveyorPermanentFields{werfConfig: werfConfig}}
+	for _, imageConfig := range werfConfig.StapelImages {
+		c.imagesInOrder = append(c.imagesInOrder, &Image{name: imageConfig.Name, baseImageImageName: imageConfig.FromImageName})
+	}
+
+	return c
+}
+
+var _ = Describe("images tasks", func() {
+	It("returns fromImage and imports as image dependencies", func() {
+		c := newTasksConveyor()
+		Ω(c.imageDependencies(c.GetImage("a"))).Should(BeEmpty())
+		Ω(c.imageDependencies(c.GetImage("c"))).Should(Equal([]string{"a"}))
+		Ω(c.imageDependencies(c.GetImage("d"))).Should(Equal([]string{"c", "a", "b"}))
+	})
+
+	It("processes images in order in the sequential mode", func() {
+		recorder := &tasksRecorder{}
+		Ω(newTasksConveyor().runImagesTasks(ParallelOptions{}, recorder.task(""))).Should(Succeed())
+		Ω(recorder.started).Should(Equal([]string{"a", "b", "c", "d"}))
+		Ω(recorder.maxRunning).Should(Equal(1))
+	})
+
+	It("starts the image only when its dependencies are processed", func() {
+		recorder := &tasksRecorder{}
+		Ω(newTasksConveyor().runImagesTasks(ParallelOptions{Parallel: true, ParallelTasksLimit: 4}, recorder.task(""))).Should(Succeed())
+		Ω(recorder.finished).Should(ConsistOf("a", "b", "c", "d"))
+
+		c := newTasksConveyor()
+		for _, img := range c.imagesInOrder {
+			for _, dependency := range c.imageDependencies(img) {
+				Ω(recorder.index(recorder.finished, dependency)).Should(BeNumerically("<", recorder.index(recorder.started, img.name)), "%s started before %s is processed", img.name, dependency)
+			}
+		}
+
+		Ω(recorder.started[:2]).Should(ConsistOf("a", "b"))
+		Ω(recorder.maxRunning).Should(Equal(2))
+	})
+
+	It("does not run more tasks than the limit", func() {
+		recorder := &tasksRecorder{}
+		Ω(newTasksConveyor().runImagesTasks(ParallelOptions{Parallel: true, ParallelTasksLimit: 1}, recorder.task(""))).Should(Succeed())
+		Ω(recorder.started).Should(Equal([]string{"a", "b", "c", "d"}))
+		Ω(recorder.maxRunning).Should(Equal(1))
+	})
+
+	It("does not start new images after the failed one", func() {
+		recorder := &tasksRecorder{}
+		err := newTasksConveyor().runImagesTasks(ParallelOptions{Parallel: true, ParallelTasksLimit: 4}, recorder.task("a"))
+		Ω(err).Should(HaveOccurred())
+		Ω(err.Error()).Should(ContainSubstring("task failed"))
+		Ω(recorder.started).Should(ConsistOf("a", "b"))
+		Ω(recorder.finished).Should(ConsistOf("a", "b"))
+	})
+})
+
+var _ = Describe("image log", func() {
+	It("writes records immediately when it is not buffered", func() {
+		var records []string
+		l := newImageLog(false)
+		l.Add(func() { records = append(records, "first") })
+		Ω(records).Should(Equal([]string{"first"}))
+	})
+
+	It("accumulates records until flush when it is buffered", func() {
+		var records []string
+		l := newImageLog(true)
+		l.Add(func() { records = append(records, "first") })
+		l.Add(func() { records = append(records, "second") })
+		Ω(records).Should(BeEmpty())
+
+		l.Flush()
+		Ω(records).Should(Equal([]string{"first", "second"}))
+
+		l.Flush()
+		Ω(records).Should(Equal([]string{"first", "second"}))
+	})
+
+	It("runs functions, which use the global logger, exclusively in the buffered mode", func() {
+		var running, maxRunning int
+		var mutex sync.Mutex
+		var wg sync.WaitGroup
+
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				Ω(newImageLog(true).WithGlobalLog(func() error {
+					mutex.Lock()
+					running++
+					if running > maxRunning {
+						maxRunning = running
+					}
+					mutex.Unlock()
+
+					time.Sleep(10 * time.Millisecond)
+
+					mutex.Lock()
+					running--
+					mutex.Unlock()
+
+					return nil
+				})).Should(Succeed())
+			}()
+		}
+
+		wg.Wait()
+		Ω(maxRunning).Should(Equal(1))
+	})
+})
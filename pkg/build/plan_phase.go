@@ -0,0 +1,178 @@
+package build
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flant/logboek"
+
+	"github.com/flant/werf/pkg/build/stage"
+	imagePkg "github.com/flant/werf/pkg/image"
+	"github.com/flant/werf/pkg/stages_storage"
+	"github.com/flant/werf/pkg/util"
+)
+
+func NewPlanPhase() *PlanPhase {
+	return &PlanPhase{}
+}
+
+// PlanPhase explains which stages will be built and why: prints stages dependency inputs
+// and the difference with the inputs of the last built stage of the same image
+type PlanPhase struct {
+	stages []*stages_storage.StageDescription
+}
+
+func (p *PlanPhase) Run(c *Conveyor) error {
+	if err := logboek.LogProcessInline("Getting stages list", logboek.LogProcessInlineOptions{}, func() error {
+		stages, err := c.stagesStorage.GetStagesList(c.projectName())
+		if err != nil {
+			return fmt.Errorf("unable to get stages list from stages storage %s: %s", c.stagesStorage.String(), err)
+		}
+
+		p.stages = stages
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	logboek.LogOptionalLn()
+
+	logProcessOptions := logboek.LogProcessOptions{ColorizeMsgFunc: logboek.ColorizeHighlight}
+	return logboek.LogProcess("Planning stages", logProcessOptions, func() error {
+		return logboek.WithoutIndent(func() error { return p.run(c) })
+	})
+}
+
+func (p *PlanPhase) run(c *Conveyor) error {
+	for _, image := range c.imagesInOrder {
+		if err := logboek.LogProcess(image.LogDetailedName(), logboek.LogProcessOptions{ColorizeMsgFunc: image.LogProcessColorizeFunc()}, func() error {
+			return p.runImage(image)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *PlanPhase) runImage(image *Image) error {
+	for _, s := range image.GetStages() {
+		status := "will be built"
+		if s.GetImage().IsExists() {
+			status = "cached"
+		}
+
+		logboek.LogHighlightF("%s: %s (%s)\n", s.Name(), s.GetSignature(), status)
+
+		if err := logboek.WithIndent(func() error {
+			return p.logStageInputs(image, s)
+		}); err != nil {
+			return err
+		}
+
+		logboek.LogOptionalLn()
+	}
+
+	return nil
+}
+
+func (p *PlanPhase) logStageInputs(image *Image, s stage.Interface) error {
+	inputs := s.GetDependencyInputs()
+
+	logboek.LogInfoLn("inputs:")
+	for _, input := range inputs {
+		logboek.LogInfoF("  %s: %s\n", input.Name, input.String())
+	}
+
+	if s.GetImage().IsExists() {
+		return nil
+	}
+
+	lastStage := p.lastBuiltStage(image.GetName(), string(s.Name()), s.GetSignature())
+	if lastStage == nil {
+		logboek.LogInfoLn("no previous builds of the stage found")
+		return nil
+	}
+
+	lastInputs, err := stage.UnmarshalDependencyInputs(lastStage.Labels[imagePkg.WerfStageDependenciesLabel])
+	if err != nil {
+		return fmt.Errorf("unable to unmarshal stage %s dependencies: %s", lastStage.ImageName, err)
+	}
+
+	logboek.LogInfoF("changes since the last built stage %s (%s):\n", lastStage.Signature, lastStage.Created.Format("2006-01-02T15:04:05Z07:00"))
+	for _, line := range diffDependencyInputs(lastInputs, inputs) {
+		logboek.LogInfoF("  %s\n", line)
+	}
+
+	return nil
+}
+
+func (p *PlanPhase) lastBuiltStage(imageName, stageName, signature string) *stages_storage.StageDescription {
+	var res *stages_storage.StageDescription
+	for _, stageDesc := range p.stages {
+		if stageDesc.Signature == signature {
+			continue
+		}
+
+		if stageDesc.Labels[imagePkg.WerfStageImageNameLabel] != imageName || stageDesc.Labels[imagePkg.WerfStageNameLabel] != stageName {
+			continue
+		}
+
+		if _, hasKey := stageDesc.Labels[imagePkg.WerfStageDependenciesLabel]; !hasKey {
+			continue
+		}
+
+		if res == nil || stageDesc.Created.After(res.Created) {
+			res = stageDesc
+		}
+	}
+
+	return res
+}
+
+// diffDependencyInputs returns changed inputs in the unified diff manner: removed inputs with "-" and added with "+"
+func diffDependencyInputs(oldInputs, newInputs []stage.DependencyInput) []string {
+	oldValues := dependencyInputsValues(oldInputs)
+	newValues := dependencyInputsValues(newInputs)
+
+	var names []string
+	for _, inputs := range [][]stage.DependencyInput{oldInputs, newInputs} {
+		for _, input := range inputs {
+			if !util.IsStringsContainValue(names, input.Name) {
+				names = append(names, input.Name)
+			}
+		}
+	}
+
+	var lines []string
+	for _, name := range names {
+		oldValue, newValue := strings.Join(oldValues[name], "\n"), strings.Join(newValues[name], "\n")
+		if oldValue == newValue {
+			continue
+		}
+
+		for _, value := range oldValues[name] {
+			lines = append(lines, fmt.Sprintf("- %s: %s", name, value))
+		}
+
+		for _, value := range newValues[name] {
+			lines = append(lines, fmt.Sprintf("+ %s: %s", name, value))
+		}
+	}
+
+	if len(lines) == 0 {
+		lines = append(lines, "no changes in dependency inputs")
+	}
+
+	return lines
+}
+
+func dependencyInputsValues(inputs []stage.DependencyInput) map[string][]string {
+	res := map[string][]string{}
+	for _, input := range inputs {
+		res[input.Name] = append(res[input.Name], input.String())
+	}
+
+	return res
+}
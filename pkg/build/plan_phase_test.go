@@ -0,0 +1,83 @@
+package build
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/werf/pkg/build/stage"
+	imagePkg "github.com/flant/werf/pkg/image"
+	"github.com/flant/werf/pkg/stages_storage"
+)
+
+func stageDescription(signature, imageName, stageName string, created time.Time) *stages_storage.StageDescription {
+	return &stages_storage.StageDescription{
+		Signature: signature,
+		ImageName: "project:" + signature,
+		ImageId:   "sha256:" + signature,
+		Labels: map[string]string{
+			imagePkg.WerfStageImageNameLabel:    imageName,
+			imagePkg.WerfStageNameLabel:         stageName,
+			imagePkg.WerfStageDependenciesLabel: "[]",
+		},
+		Created: created,
+	}
+}
+
+var _ = Describe("plan phase", func() {
+	It("shows changed stage commands", func() {
+		oldInputs := []stage.DependencyInput{
+			{Name: "command", Values: []string{"apt-get update"}},
+			{Name: "command", Values: []string{"apt-get install -y curl"}},
+			{Name: "cacheVersions", Values: []string{"1"}},
+			{Name: "prevStageSignature", Values: []string{"a"}},
+		}
+		newInputs := []stage.DependencyInput{
+			{Name: "command", Values: []string{"apt-get update"}},
+			{Name: "command", Values: []string{"apt-get install -y curl git"}},
+			{Name: "prevStageSignature", Values: []string{"a"}},
+			{Name: "gitMapping / stageDependenciesChecksum", Values: []string{"b"}},
+		}
+
+		Ω(diffDependencyInputs(oldInputs, newInputs)).Should(Equal([]string{
+			"- command: apt-get update",
+			"- command: apt-get install -y curl",
+			"+ command: apt-get update",
+			"+ command: apt-get install -y curl git",
+			"- cacheVersions: 1",
+			"+ gitMapping / stageDependenciesChecksum: b",
+		}))
+	})
+
+	It("shows no changes", func() {
+		inputs := []stage.DependencyInput{{Name: "command", Values: []string{"make"}}}
+		Ω(diffDependencyInputs(inputs, inputs)).Should(Equal([]string{"no changes in dependency inputs"}))
+	})
+
+	It("finds the last built stage of the image", func() {
+		now := time.Now()
+		p := &PlanPhase{stages: []*stages_storage.StageDescription{
+			stageDescription("1", "backend", "install", now.Add(-2*time.Hour)),
+			stageDescription("2", "backend", "install", now.Add(-time.Hour)),
+			stageDescription("3", "backend", "install", now),
+			stageDescription("4", "frontend", "install", now.Add(time.Hour)),
+			stageDescription("5", "backend", "setup", now.Add(time.Hour)),
+		}}
+
+		Ω(p.lastBuiltStage("backend", "install", "3").Signature).Should(Equal("2"))
+		Ω(p.lastBuiltStage("backend", "install", "6").Signature).Should(Equal("3"))
+		Ω(p.lastBuiltStage("backend", "beforeSetup", "6")).Should(BeNil())
+	})
+
+	It("describes the stage image by the stages storage without fetching", func() {
+		desc := stageDescription("1", "backend", "install", time.Now())
+
+		img := imagePkg.NewStageImage(nil, desc.ImageName)
+		img.SetInspect(stageDescriptionInspect(desc))
+
+		Ω(img.IsExists()).Should(BeTrue())
+		Ω(img.ID()).Should(Equal("sha256:1"))
+		Ω(img.Labels()).Should(Equal(desc.Labels))
+	})
+})
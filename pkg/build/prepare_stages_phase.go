@@ -4,6 +4,8 @@ import (
 	"fmt"
 
 	"github.com/flant/logboek"
+
+	"github.com/flant/werf/pkg/build/stage"
 	imagePkg "github.com/flant/werf/pkg/image"
 	"github.com/flant/werf/pkg/werf"
 )
@@ -48,6 +50,12 @@ func (p *PrepareStagesPhase) runImage(image *Image, c *Conveyor) (err error) {
 			prevBuiltImage = prevImage
 		}
 
+		if !image.hasDevChanges {
+			if image.hasDevChanges, err = isDevStage(s, c); err != nil {
+				return err
+			}
+		}
+
 		stageImage := s.GetImage()
 
 		if c.GetImageBySignature(s.GetSignature()) != nil || stageImage.IsExists() {
@@ -55,6 +63,11 @@ func (p *PrepareStagesPhase) runImage(image *Image, c *Conveyor) (err error) {
 			continue
 		}
 
+		stageLabels, err := stageDependenciesLabels(image, s)
+		if err != nil {
+			return err
+		}
+
 		imageServiceCommitChangeOptions := stageImage.Container().ServiceCommitChangeOptions()
 		imageServiceCommitChangeOptions.AddLabel(map[string]string{
 			imagePkg.WerfDockerImageName:   stageImage.Name(),
@@ -63,6 +76,11 @@ func (p *PrepareStagesPhase) runImage(image *Image, c *Conveyor) (err error) {
 			imagePkg.WerfCacheVersionLabel: imagePkg.BuildCacheVersion,
 			imagePkg.WerfImageLabel:        "false",
 		})
+		imageServiceCommitChangeOptions.AddLabel(stageLabels)
+
+		if image.hasDevChanges {
+			imageServiceCommitChangeOptions.AddLabel(map[string]string{imagePkg.WerfDevModeLabel: "true"})
+		}
 
 		if c.sshAuthSock != "" {
 			imageRunOptions := stageImage.Container().RunOptions()
@@ -70,7 +88,7 @@ func (p *PrepareStagesPhase) runImage(image *Image, c *Conveyor) (err error) {
 			imageRunOptions.AddEnv(map[string]string{"SSH_AUTH_SOCK": "/.werf/tmp/ssh-auth-sock"})
 		}
 
-		err := s.PrepareImage(c, prevBuiltImage, stageImage)
+		err = s.PrepareImage(c, prevBuiltImage, stageImage)
 		if err != nil {
 			return fmt.Errorf("error preparing stage %s: %s", s.Name(), err)
 		}
@@ -82,3 +100,34 @@ func (p *PrepareStagesPhase) runImage(image *Image, c *Conveyor) (err error) {
 
 	return
 }
+
+// isDevStage returns true if the stage adds working tree changes of the dev mode into the image,
+// next stages of the image include these changes as well
+func isDevStage(s stage.Interface, c *Conveyor) (bool, error) {
+	switch typedStage := s.(type) {
+	case *stage.GitArchiveStage:
+		return typedStage.HasDevChanges()
+	case interface{ ImportedImagesNames() []string }:
+		for _, imageName := range typedStage.ImportedImagesNames() {
+			if c.GetImage(imageName).hasDevChanges {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// stageDependenciesLabels returns labels to find the previous builds of the stage and to explain signature changes
+func stageDependenciesLabels(image *Image, s stage.Interface) (map[string]string, error) {
+	dependencies, err := stage.MarshalDependencyInputs(s.GetDependencyInputs())
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal stage %s dependencies: %s", s.Name(), err)
+	}
+
+	return map[string]string{
+		imagePkg.WerfStageImageNameLabel:    image.GetName(),
+		imagePkg.WerfStageNameLabel:         string(s.Name()),
+		imagePkg.WerfStageDependenciesLabel: dependencies,
+	}, nil
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/flant/werf/pkg/docker_registry"
 	imagePkg "github.com/flant/werf/pkg/image"
+	"github.com/flant/werf/pkg/image_signing"
 	"github.com/flant/werf/pkg/tag_strategy"
 	"github.com/flant/werf/pkg/util"
 )
@@ -19,13 +20,15 @@ func NewPublishImagesPhase(imagesRepoManager ImagesRepoManager, opts PublishImag
 		tag_strategy.GitTag:    opts.TagsByGitTag,
 		tag_strategy.GitCommit: opts.TagsByGitCommit,
 	}
-	return &PublishImagesPhase{TagsByScheme: tagsByScheme, ImageRepoManager: imagesRepoManager}
+	return &PublishImagesPhase{TagsByScheme: tagsByScheme, ImageRepoManager: imagesRepoManager, ImageSigner: opts.ImageSigner, SBOMOptions: opts.SBOMOptions, WithReport: opts.WithReport}
 }
 
 type PublishImagesPhase struct {
-	WithStages       bool
 	TagsByScheme     map[tag_strategy.TagStrategy][]string
 	ImageRepoManager ImagesRepoManager
+	ImageSigner      *image_signing.Signer
+	SBOMOptions      SBOMOptions
+	WithReport       bool
 }
 
 func (p *PublishImagesPhase) Run(c *Conveyor) error {
@@ -36,8 +39,6 @@ func (p *PublishImagesPhase) Run(c *Conveyor) error {
 }
 
 func (p *PublishImagesPhase) run(c *Conveyor) error {
-	// TODO: Push stages should occur on the BuildStagesPhase
-
 	var imagesToPublish []*Image
 	if len(c.imageNamesToProcess) == 0 {
 		imagesToPublish = c.imagesInOrder
@@ -48,29 +49,14 @@ func (p *PublishImagesPhase) run(c *Conveyor) error {
 	}
 
 	for _, image := range imagesToPublish {
-		if image.isArtifact { // FIXME: distributed stages
+		// artifacts are not published, artifacts stages are stored in the stages storage by the BuildStagesPhase
+		if image.isArtifact {
 			continue
 		}
 
 		if err := logboek.LogProcess(image.LogDetailedName(), logboek.LogProcessOptions{ColorizeMsgFunc: image.LogProcessColorizeFunc()}, func() error {
-			//if p.WithStages {
-			//	err := logboek.LogProcess("Pushing stages cache", logboek.LogProcessOptions{}, func() error {
-			//		if err := p.pushImageStages(c, image); err != nil {
-			//			return fmt.Errorf("unable to push image %s stages: %s", image.GetName(), err)
-			//		}
-			//
-			//		return nil
-			//	})
-			//
-			//	if err != nil {
-			//		return err
-			//	}
-			//}
-
-			if !image.isArtifact {
-				if err := p.pushImage(c, image); err != nil {
-					return fmt.Errorf("unable to push image %s: %s", image.LogName(), err)
-				}
+			if err := p.pushImage(c, image); err != nil {
+				return fmt.Errorf("unable to push image %s: %s", image.LogName(), err)
 			}
 
 			return nil
@@ -82,65 +68,63 @@ func (p *PublishImagesPhase) run(c *Conveyor) error {
 	return nil
 }
 
-//func (p *PublishImagesPhase) pushImageStages(c *Conveyor, image *Image) error {
-//	stages := image.GetStages()
-//
-//	existingTags, err := docker_registry.Tags(p.ImagesRepo)
-//	if err != nil {
-//		return fmt.Errorf("error fetching existing stages cache list %s: %s", p.ImagesRepo, err)
-//	}
-//
-//	for _, stage := range stages {
-//		stageTagName := fmt.Sprintf(RepoImageStageTagFormat, stage.GetSignature())
-//		stageImageName := fmt.Sprintf("%s:%s", p.ImagesRepo, stageTagName)
-//
-//		if util.IsStringsContainValue(existingTags, stageTagName) {
-//			logboek.LogHighlightLn(stage.Name())
-//
-//			logboek.LogInfoF("stages-repo: %s\n", p.ImagesRepo)
-//			logboek.LogInfoF("      image: %s\n", stageImageName)
-//
-//			logboek.LogOptionalLn()
-//
-//			continue
-//		}
-//
-//		err := func() error {
-//			imageLockName := imagePkg.ImageLockName(stageImageName)
-//			if err := shluz.Lock(imageLockName, shluz.LockOptions{}); err != nil {
-//				return fmt.Errorf("failed to lock %s: %s", imageLockName, err)
-//			}
-//
-//			defer shluz.Unlock(imageLockName)
-//
-//			stageImage := c.GetStageImage(stage.GetImage().Name())
-//
-//			successInfoSectionFunc := func() {
-//				_ = logboek.WithIndent(func() error {
-//					logboek.LogInfoF("stages-repo: %s\n", p.ImagesRepo)
-//					logboek.LogInfoF("      image: %s\n", stageImageName)
-//
-//					return nil
-//				})
-//			}
-//
-//			logProcessOptions := logboek.LogProcessOptions{SuccessInfoSectionFunc: successInfoSectionFunc, ColorizeMsgFunc: logboek.ColorizeHighlight}
-//			return logboek.LogProcess(fmt.Sprintf("Publishing %s", stage.Name()), logProcessOptions, func() error {
-//				if err := stageImage.Export(stageImageName); err != nil {
-//					return fmt.Errorf("error pushing %s: %s", stageImageName, err)
-//				}
-//
-//				return nil
-//			})
-//		}()
-//
-//		if err != nil {
-//			return err
-//		}
-//	}
-//
-//	return nil
-//}
+// needDigest returns true if the digest of the published tag is used: the registry request per tag is not made otherwise
+func (p *PublishImagesPhase) needDigest() bool {
+	return p.WithReport || p.ImageSigner != nil || p.SBOMOptions.enabled()
+}
+
+func (p *PublishImagesPhase) addPublishedToReport(c *Conveyor, image *Image, strategy tag_strategy.TagStrategy, imageTag, imageName string, isUpToDate bool) error {
+	var digest string
+	if p.needDigest() {
+		var err error
+		digest, err = docker_registry.ImageDigest(imageName)
+		if err != nil {
+			return fmt.Errorf("unable to get image %s digest: %s", imageName, err)
+		}
+	}
+
+	if p.ImageSigner != nil {
+		if err := p.signImage(image, digest); err != nil {
+			return err
+		}
+	}
+
+	if p.SBOMOptions.enabled() {
+		if err := p.publishSBOM(c, image, imageTag, imageName, digest); err != nil {
+			return err
+		}
+	}
+
+	c.report.addPublished(image, &PublishedReport{
+		TagStrategy: string(strategy),
+		Tag:         imageTag,
+		ImageName:   imageName,
+		Digest:      digest,
+		IsUpToDate:  isUpToDate,
+	})
+
+	return nil
+}
+
+func (p *PublishImagesPhase) signImage(image *Image, digest string) error {
+	imageRepository := p.ImageRepoManager.ImageRepo(image.GetName())
+
+	var isSigned bool
+	logProcessMsg := fmt.Sprintf("Signing %s@%s", imageRepository, digest)
+	if err := logboek.LogProcessInline(logProcessMsg, logboek.LogProcessInlineOptions{}, func() error {
+		var err error
+		isSigned, err = p.ImageSigner.Sign(imageRepository, digest)
+		return err
+	}); err != nil {
+		return fmt.Errorf("unable to sign image %s@%s: %s", imageRepository, digest, err)
+	}
+
+	if !isSigned {
+		logboek.LogInfoF("Digest %s is already signed with the key\n", digest)
+	}
+
+	return nil
+}
 
 func (p *PublishImagesPhase) pushImage(c *Conveyor, image *Image) error {
 	imageRepository := p.ImageRepoManager.ImageRepo(image.GetName())
@@ -166,6 +150,10 @@ func (p *PublishImagesPhase) pushImage(c *Conveyor, image *Image) error {
 	stages := image.GetStages()
 	lastStageImage := stages[len(stages)-1].GetImage()
 
+	if image.hasDevChanges || lastStageImage.Labels()[imagePkg.WerfDevModeLabel] == "true" {
+		return fmt.Errorf("image built in dev mode from uncommitted changes cannot be published: commit changes and build without --dev")
+	}
+
 	var nonEmptySchemeInOrder []tag_strategy.TagStrategy
 	for strategy, tags := range p.TagsByScheme {
 		if len(tags) == 0 {
@@ -221,6 +209,10 @@ func (p *PublishImagesPhase) pushImage(c *Conveyor, image *Image) error {
 
 						logboek.LogOptionalLn()
 
+						if err := p.addPublishedToReport(c, image, strategy, imageTag, imageName, true); err != nil {
+							return err
+						}
+
 						continue ProcessingTags
 					}
 				}
@@ -266,7 +258,7 @@ func (p *PublishImagesPhase) pushImage(c *Conveyor, image *Image) error {
 							return fmt.Errorf("error pushing %s: %s", imageName, err)
 						}
 
-						return nil
+						return p.addPublishedToReport(c, image, strategy, imageTag, imageName, false)
 					})
 				}()
 
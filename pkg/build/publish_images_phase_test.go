@@ -0,0 +1,19 @@
+package build
+
+import (
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/werf/pkg/image_signing"
+	"github.com/flant/werf/pkg/sbom"
+)
+
+var _ = DescribeTable("publish images phase fetches digests of published tags",
+	func(opts PublishImagesOptions, expected bool) {
+		Ω(NewPublishImagesPhase(nil, opts).needDigest()).Should(Equal(expected))
+	},
+	Entry("by default", PublishImagesOptions{}, false),
+	Entry("for the report", PublishImagesOptions{WithReport: true}, true),
+	Entry("for signing", PublishImagesOptions{ImageSigner: &image_signing.Signer{}}, true),
+	Entry("for SBOM", PublishImagesOptions{SBOMOptions: SBOMOptions{Format: sbom.SPDX}}, true),
+)
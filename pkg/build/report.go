@@ -0,0 +1,122 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+type ReportFormat string
+
+const (
+	ReportJSON ReportFormat = "json"
+)
+
+type ReportOptions struct {
+	ReportPath   string
+	ReportFormat ReportFormat
+}
+
+// Report is the machine-readable result of build and publish phases
+type Report struct {
+	Images []*ImageReport `json:"images"`
+
+	mutex sync.Mutex
+}
+
+type ImageReport struct {
+	Name               string             `json:"name"`
+	IsArtifact         bool               `json:"isArtifact"`
+	LastStageSignature string             `json:"lastStageSignature"`
+	LastStageImageName string             `json:"lastStageImageName"`
+	LastStageImageId   string             `json:"lastStageImageId"`
+	Stages             []*StageReport     `json:"stages,omitempty"`
+	Published          []*PublishedReport `json:"published,omitempty"`
+}
+
+type StageReport struct {
+	Name             string  `json:"name"`
+	Signature        string  `json:"signature"`
+	ImageName        string  `json:"imageName"`
+	ImageId          string  `json:"imageId"`
+	IsCached         bool    `json:"isCached"`
+	Size             int64   `json:"size"`
+	SizeDiff         int64   `json:"sizeDiff"`
+	BuildTimeSeconds float64 `json:"buildTimeSeconds"`
+}
+
+type PublishedReport struct {
+	TagStrategy string `json:"tagStrategy"`
+	Tag         string `json:"tag"`
+	ImageName   string `json:"imageName"`
+	Digest      string `json:"digest,omitempty"`
+	IsUpToDate  bool   `json:"isUpToDate"`
+}
+
+func newReport() *Report {
+	return &Report{}
+}
+
+func (r *Report) addStage(image *Image, stageReport *StageReport) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	imageReport := r.getOrCreateImageReport(image)
+	imageReport.Stages = append(imageReport.Stages, stageReport)
+	imageReport.setLastStage(image)
+}
+
+func (r *Report) addPublished(image *Image, publishedReport *PublishedReport) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	imageReport := r.getOrCreateImageReport(image)
+	imageReport.Published = append(imageReport.Published, publishedReport)
+	imageReport.setLastStage(image)
+}
+
+func (r *Report) getOrCreateImageReport(image *Image) *ImageReport {
+	for _, imageReport := range r.Images {
+		if imageReport.Name == image.GetName() {
+			return imageReport
+		}
+	}
+
+	imageReport := &ImageReport{Name: image.GetName(), IsArtifact: image.isArtifact}
+	r.Images = append(r.Images, imageReport)
+
+	return imageReport
+}
+
+func (imageReport *ImageReport) setLastStage(image *Image) {
+	lastStage := image.LatestStage()
+	imageReport.LastStageSignature = lastStage.GetSignature()
+	imageReport.LastStageImageName = lastStage.GetImage().Name()
+	imageReport.LastStageImageId = lastStage.GetImage().ID()
+}
+
+func (r *Report) WriteToFile(path string, format ReportFormat) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var data []byte
+	var err error
+
+	switch format {
+	case ReportJSON:
+		data, err = json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return fmt.Errorf("unable to marshal report: %s", err)
+		}
+		data = append(data, '\n')
+	default:
+		return fmt.Errorf("unsupported report format %q", format)
+	}
+
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("unable to write report %s: %s", path, err)
+	}
+
+	return nil
+}
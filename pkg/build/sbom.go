@@ -0,0 +1,318 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	containerTypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/flant/logboek"
+
+	"github.com/flant/werf/pkg/config"
+	"github.com/flant/werf/pkg/docker"
+	"github.com/flant/werf/pkg/docker_registry"
+	"github.com/flant/werf/pkg/git_repo"
+	"github.com/flant/werf/pkg/sbom"
+	"github.com/flant/werf/pkg/util"
+	"github.com/flant/werf/pkg/werf"
+)
+
+const sbomContainerNamePrefix = "werf.sbom."
+
+// SBOMOptions enables generation of the software bill of materials of the images
+type SBOMOptions struct {
+	Format sbom.Format // SBOM is not generated if empty
+	Dir    string
+	// Attach pushes the SBOM of the published image to the images repo by the cosign convention (see docker_registry.SBOMTag)
+	Attach bool
+}
+
+func (opts SBOMOptions) enabled() bool {
+	return opts.Format != ""
+}
+
+func NewSBOMPhase(opts SBOMOptions) *SBOMPhase {
+	return &SBOMPhase{SBOMOptions: opts}
+}
+
+// SBOMPhase writes SBOM of the built images to the SBOM directory
+type SBOMPhase struct {
+	SBOMOptions SBOMOptions
+}
+
+func (p *SBOMPhase) Run(c *Conveyor) error {
+	logProcessOptions := logboek.LogProcessOptions{ColorizeMsgFunc: logboek.ColorizeHighlight}
+	return logboek.LogProcess("Generating SBOM", logProcessOptions, func() error {
+		var images []*Image
+		if len(c.imageNamesToProcess) == 0 {
+			images = c.imagesInOrder
+		} else {
+			for _, imageName := range c.imageNamesToProcess {
+				images = append(images, c.GetImage(imageName))
+			}
+		}
+
+		for _, image := range images {
+			// artifacts are not published, so SBOM is generated for images only
+			if image.isArtifact {
+				continue
+			}
+
+			document, err := c.newImageSBOM(image)
+			if err != nil {
+				return fmt.Errorf("unable to generate image %s SBOM: %s", image.LogName(), err)
+			}
+
+			if _, err := writeSBOM(document, p.SBOMOptions, sbomFileName(image.GetName(), "", p.SBOMOptions.Format)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetImageSBOM returns SBOM of the image latest stage, the stage image should be built
+func (c *Conveyor) GetImageSBOM(imageName string) (*sbom.Document, error) {
+	return c.newImageSBOM(c.GetImage(imageName))
+}
+
+func (c *Conveyor) newImageSBOM(image *Image) (*sbom.Document, error) {
+	lastStageImage := image.LatestStage().GetImage()
+
+	scanResult, err := c.getImageFilesystemInventory(lastStageImage.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	return &sbom.Document{
+		ImageName:       image.GetName(),
+		Reference:       lastStageImage.Name(),
+		ImageID:         lastStageImage.ID(),
+		Created:         time.Now(),
+		ToolVersion:     werf.Version,
+		OperatingSystem: scanResult.OperatingSystem,
+		Provenance:      c.getImageProvenance(image),
+		Packages:        scanResult.Packages,
+	}, nil
+}
+
+func (c *Conveyor) getImageProvenance(image *Image) sbom.Provenance {
+	provenance := sbom.Provenance{}
+
+	if image.baseImageImageName != "" {
+		provenance.BaseImage = image.baseImageImageName
+		provenance.BaseImageID = c.GetImage(image.baseImageImageName).LatestStage().GetImage().ID()
+	} else if image.baseImageName != "" {
+		provenance.BaseImage = image.baseImageName
+		if image.baseImageRepoId != "" {
+			provenance.BaseImageID = image.baseImageRepoId
+		} else if image.baseImage != nil {
+			provenance.BaseImageID = image.baseImage.ID()
+		}
+	}
+
+	lastStage := image.LatestStage()
+	for _, gitMapping := range lastStage.GetGitMappings() {
+		source := &sbom.GitMappingSource{
+			Name:   gitMapping.GitRepo().GetName(),
+			Commit: gitMapping.GetGitCommitFromImageLabels(lastStage.GetImage()),
+			Add:    gitMapping.Cwd,
+			To:     gitMapping.To,
+		}
+
+		if remoteGitRepo, ok := gitMapping.GitRepo().(*git_repo.Remote); ok {
+			source.URL = remoteGitRepo.Url
+		}
+
+		provenance.GitMappings = append(provenance.GitMappings, source)
+	}
+
+	var imports []*config.Import
+	if imageConfig := c.werfConfig.GetStapelImage(image.GetName()); imageConfig != nil {
+		imports = imageConfig.Import
+	} else if artifactConfig := c.werfConfig.GetArtifact(image.GetName()); artifactConfig != nil {
+		imports = artifactConfig.Import
+	}
+
+	for _, i := range imports {
+		source := &sbom.ImportSource{ImageName: i.ImageName, Add: i.Add, To: i.To}
+		if i.ArtifactName != "" {
+			source.ImageName = i.ArtifactName
+			source.IsArtifact = true
+		}
+		source.Signature = c.GetImageLatestStageSignature(source.ImageName)
+
+		provenance.Imports = append(provenance.Imports, source)
+	}
+
+	return provenance
+}
+
+// getImageFilesystemInventory scans the image filesystem once per conveyor run
+func (c *Conveyor) getImageFilesystemInventory(imageName string) (*sbom.ScanResult, error) {
+	if scanResult, hasScanResult := c.sbomScanResults[imageName]; hasScanResult {
+		return scanResult, nil
+	}
+
+	var scanResult *sbom.ScanResult
+	if err := logboek.LogProcessInline(fmt.Sprintf("Scanning %s filesystem", imageName), logboek.LogProcessInlineOptions{}, func() error {
+		var err error
+		scanResult, err = scanImageFilesystem(imageName)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	c.sbomScanResults[imageName] = scanResult
+
+	return scanResult, nil
+}
+
+func scanImageFilesystem(imageName string) (*sbom.ScanResult, error) {
+	containerName := sbomContainerNamePrefix + util.GenerateConsistentRandomString(10)
+
+	// the container is not started, the command is required only for the images without cmd and entrypoint
+	if _, err := docker.ContainerCreate(containerName, &containerTypes.Config{Image: imageName, Cmd: []string{"true"}}, nil, nil); err != nil {
+		return nil, fmt.Errorf("unable to create container %s: %s", containerName, err)
+	}
+	defer removeSBOMContainer(containerName)
+
+	rc, err := docker.ContainerExport(containerName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to export container %s filesystem: %s", containerName, err)
+	}
+	defer rc.Close()
+
+	scanResult, err := sbom.ScanFilesystem(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	if scanResult.HasRpmDatabase {
+		output, err := queryRpmPackages(imageName)
+		if err != nil {
+			logboek.LogErrorF("WARNING: Unable to query rpm packages of %s: %s\n", imageName, err)
+		} else {
+			scanResult.AddRpmQueryOutput(output)
+		}
+	}
+
+	return scanResult, nil
+}
+
+// queryRpmPackages runs the rpm of the image, the rpm database format depends on the rpm version of the image
+func queryRpmPackages(imageName string) ([]byte, error) {
+	containerName := sbomContainerNamePrefix + util.GenerateConsistentRandomString(10)
+
+	containerConfig := &containerTypes.Config{
+		Image:      imageName,
+		Entrypoint: sbom.RpmQueryCommand[:1],
+		Cmd:        sbom.RpmQueryCommand[1:],
+		User:       "0:0",
+	}
+	if _, err := docker.ContainerCreate(containerName, containerConfig, &containerTypes.HostConfig{NetworkMode: "none"}, nil); err != nil {
+		return nil, fmt.Errorf("unable to create container %s: %s", containerName, err)
+	}
+	defer removeSBOMContainer(containerName)
+
+	if err := docker.ContainerStart(containerName); err != nil {
+		return nil, fmt.Errorf("unable to start container %s: %s", containerName, err)
+	}
+
+	exitCode, err := docker.ContainerWait(containerName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to wait container %s: %s", containerName, err)
+	}
+
+	rc, err := docker.ContainerLogs(containerName, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get container %s logs: %s", containerName, err)
+	}
+	defer rc.Close()
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if _, err := stdcopy.StdCopy(stdout, stderr, rc); err != nil {
+		return nil, fmt.Errorf("unable to read container %s logs: %s", containerName, err)
+	}
+
+	if exitCode != 0 {
+		return nil, fmt.Errorf("rpm exited with code %d: %s", exitCode, stderr.String())
+	}
+
+	return stdout.Bytes(), nil
+}
+
+func removeSBOMContainer(containerName string) {
+	if err := docker.ContainerRemove(containerName, types.ContainerRemoveOptions{Force: true}); err != nil {
+		logboek.LogErrorF("WARNING: Unable to remove container %s: %s\n", containerName, err)
+	}
+}
+
+func sbomFileName(imageName, tag string, format sbom.Format) string {
+	name := imageName
+	if name == "" {
+		name = "werf-image"
+	}
+
+	if tag != "" {
+		name = fmt.Sprintf("%s.%s", name, tag)
+	}
+
+	return fmt.Sprintf("%s.%s.json", name, format)
+}
+
+// writeSBOM writes SBOM document to the SBOM dir and returns the written data
+func writeSBOM(document *sbom.Document, opts SBOMOptions, fileName string) ([]byte, error) {
+	data, err := sbom.Marshal(document, opts.Format)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal SBOM: %s", err)
+	}
+	data = append(data, '\n')
+
+	if opts.Dir != "" {
+		if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+			return nil, fmt.Errorf("unable to create SBOM dir %s: %s", opts.Dir, err)
+		}
+	}
+
+	path := filepath.Join(opts.Dir, fileName)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return nil, fmt.Errorf("unable to write SBOM %s: %s", path, err)
+	}
+
+	logboek.LogInfoF("SBOM: %s\n", path)
+
+	return data, nil
+}
+
+// publishSBOM writes and attaches SBOM of the published image digest
+func (p *PublishImagesPhase) publishSBOM(c *Conveyor, image *Image, imageTag, imageName, digest string) error {
+	document, err := c.newImageSBOM(image)
+	if err != nil {
+		return fmt.Errorf("unable to generate image %s SBOM: %s", imageName, err)
+	}
+
+	imageRepository := p.ImageRepoManager.ImageRepo(image.GetName())
+	document.Reference = imageName
+	document.Digest = digest
+
+	data, err := writeSBOM(document, p.SBOMOptions, sbomFileName(image.GetName(), imageTag, p.SBOMOptions.Format))
+	if err != nil {
+		return err
+	}
+
+	if !p.SBOMOptions.Attach {
+		return nil
+	}
+
+	logProcessMsg := fmt.Sprintf("Attaching SBOM to %s@%s", imageRepository, digest)
+	return logboek.LogProcessInline(logProcessMsg, logboek.LogProcessInlineOptions{}, func() error {
+		return docker_registry.PushImageSBOM(imageRepository, digest, p.SBOMOptions.Format.MediaType(), data)
+	})
+}
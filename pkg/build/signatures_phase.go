@@ -12,12 +12,15 @@ import (
 	"github.com/flant/werf/pkg/util"
 )
 
-func NewSignaturesPhase(lockImages bool) *SignaturesPhase {
-	return &SignaturesPhase{LockImages: lockImages}
+func NewSignaturesPhase(lockImages bool, parallelOptions ParallelOptions) *SignaturesPhase {
+	return &SignaturesPhase{LockImages: lockImages, ParallelOptions: parallelOptions}
 }
 
 type SignaturesPhase struct {
 	LockImages bool
+	// SkipFetchStages describes stages by the stages storage instead of fetching, the stages cannot be used to build
+	SkipFetchStages bool
+	ParallelOptions
 }
 
 func (p *SignaturesPhase) Run(c *Conveyor) error {
@@ -28,26 +31,29 @@ func (p *SignaturesPhase) Run(c *Conveyor) error {
 }
 
 func (p *SignaturesPhase) run(c *Conveyor) error {
-	for _, image := range c.imagesInOrder {
-		if err := logboek.LogProcess(image.LogDetailedName(), logboek.LogProcessOptions{ColorizeMsgFunc: image.LogProcessColorizeFunc()}, func() error {
-			return p.calculateImageSignatures(c, image)
-		}); err != nil {
-			return err
-		}
+	return c.runImagesTasks(p.parallelOptions(), func(image *Image, log *imageLog) error {
+		return p.calculateImageSignatures(c, image, log)
+	})
+}
+
+// parallelOptions disables the parallel mode when stages images are locked:
+// images locks are held until stages are built, so images are locked in the same order by all werf processes to avoid deadlocks
+func (p *SignaturesPhase) parallelOptions() ParallelOptions {
+	if p.LockImages {
+		return ParallelOptions{}
 	}
 
-	return nil
+	return p.ParallelOptions
 }
 
-func (p *SignaturesPhase) calculateImageSignatures(c *Conveyor, image *Image) error {
+func (p *SignaturesPhase) calculateImageSignatures(c *Conveyor, image *Image, log *imageLog) error {
 	var prevStage stage.Interface
 
 	image.SetupBaseImage(c)
 
 	var prevBuiltImage imagePkg.ImageInterface
 	prevImage := image.GetBaseImage()
-	err := prevImage.SyncDockerState()
-	if err != nil {
+	if err := c.syncBaseImage(prevImage); err != nil {
 		return err
 	}
 
@@ -65,7 +71,7 @@ func (p *SignaturesPhase) calculateImageSignatures(c *Conveyor, image *Image) er
 			return fmt.Errorf("error checking stage %s is empty: %s", s.Name(), err)
 		}
 		if isEmpty {
-			logboek.LogInfoF("%s:%s <empty>\n", s.Name(), strings.Repeat(" ", maxStageNameLength-len(s.Name())))
+			log.LogInfoF("%s:%s <empty>\n", s.Name(), strings.Repeat(" ", maxStageNameLength-len(s.Name())))
 			continue
 		}
 
@@ -82,16 +88,26 @@ func (p *SignaturesPhase) calculateImageSignatures(c *Conveyor, image *Image) er
 
 		stageSig := util.Sha256Hash(checksumArgs...)
 
+		s.AddDependencyInput("dependenciesChecksum", stageDependencies)
+		s.AddDependencyInput("buildCacheVersion", imagePkg.BuildCacheVersion)
+		if prevStage != nil {
+			s.AddDependencyInput("prevStageSignature", prevStage.GetSignature())
+		}
+
 		s.SetSignature(stageSig)
 
-		logboek.LogInfoF("%s:%s %s\n", s.Name(), strings.Repeat(" ", maxStageNameLength-len(s.Name())), stageSig)
+		log.LogInfoF("%s:%s %s\n", s.Name(), strings.Repeat(" ", maxStageNameLength-len(s.Name())), stageSig)
 
-		imageName := fmt.Sprintf(imagePkg.LocalImageStageImageFormat, c.projectName(), stageSig)
+		imageName := c.stagesStorage.ConstructStageImageName(c.projectName(), stageSig)
 
 		i := c.GetOrCreateImage(prevImage, imageName)
 		s.SetImage(i)
 
-		if err = i.SyncDockerState(); err != nil {
+		if p.SkipFetchStages {
+			if err = c.lookupStageImage(stageSig, i); err != nil {
+				return fmt.Errorf("error synchronizing docker state of stage %s: %s", s.Name(), err)
+			}
+		} else if err = c.syncStageImage(stageSig, i, log); err != nil {
 			return fmt.Errorf("error synchronizing docker state of stage %s: %s", s.Name(), err)
 		}
 
@@ -101,7 +117,7 @@ func (p *SignaturesPhase) calculateImageSignatures(c *Conveyor, image *Image) er
 				return fmt.Errorf("failed to lock %s: %s", imageLockName, err)
 			}
 
-			if err = i.SyncDockerState(); err != nil {
+			if err = c.syncStageImage(stageSig, i, log); err != nil {
 				return fmt.Errorf("error synchronizing docker state of stage %s: %s", s.Name(), err)
 			}
 		}
@@ -118,8 +134,8 @@ func (p *SignaturesPhase) calculateImageSignatures(c *Conveyor, image *Image) er
 
 	stageName := c.GetBuildingGitStage(image.name)
 	if stageName != "" {
-		logboek.LogLn()
-		logboek.LogInfoF("Git files will be actualized on stage %s\n", stageName)
+		log.Add(func() { logboek.LogLn() })
+		log.LogInfoF("Git files will be actualized on stage %s\n", stageName)
 	}
 
 	image.SetStages(newStagesList)
@@ -1,12 +1,16 @@
 package stage
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 
+	"github.com/flant/werf/pkg/cache_mount"
 	"github.com/flant/werf/pkg/config"
 	imagePkg "github.com/flant/werf/pkg/image"
 	"github.com/flant/werf/pkg/slug"
@@ -83,6 +87,15 @@ type BaseStage struct {
 	containerWerfDir string
 	configMounts     []*config.Mount
 	projectName      string
+	dependencyInputs []DependencyInput
+	cacheMounts      []*cache_mount.Mount
+	secretMounts     []*secretMount
+}
+
+// secretMount is the host file of the secret, which exists only while the assembly container is running
+type secretMount struct {
+	config *config.Mount
+	path   string
 }
 
 func (s *BaseStage) LogDetailedName() string {
@@ -101,6 +114,14 @@ func (s *BaseStage) GetDependencies(_ Conveyor, _, _ imagePkg.ImageInterface) (s
 	panic("method must be implemented!")
 }
 
+func (s *BaseStage) AddDependencyInput(name string, values ...string) {
+	s.dependencyInputs = append(s.dependencyInputs, DependencyInput{Name: name, Values: values})
+}
+
+func (s *BaseStage) GetDependencyInputs() []DependencyInput {
+	return s.dependencyInputs
+}
+
 func (s *BaseStage) IsEmpty(_ Conveyor, _ imagePkg.ImageInterface) (bool, error) {
 	return false, nil
 }
@@ -110,7 +131,7 @@ func (s *BaseStage) ShouldBeReset(builtImage imagePkg.ImageInterface) (bool, err
 		commit := gitMapping.GetGitCommitFromImageLabels(builtImage)
 		if commit == "" {
 			return false, nil
-		} else if exist, err := gitMapping.GitRepo().IsCommitExists(commit); err != nil {
+		} else if exist, err := gitMapping.IsCommitExists(commit); err != nil {
 			return false, err
 		} else if !exist {
 			return true, nil
@@ -120,7 +141,7 @@ func (s *BaseStage) ShouldBeReset(builtImage imagePkg.ImageInterface) (bool, err
 	return false, nil
 }
 
-func (s *BaseStage) PrepareImage(_ Conveyor, prevBuiltImage, image imagePkg.ImageInterface) error {
+func (s *BaseStage) PrepareImage(c Conveyor, prevBuiltImage, image imagePkg.ImageInterface) error {
 	/*
 	 * NOTE: BaseStage.PrepareImage does not called in From.PrepareImage.
 	 * NOTE: Take into account when adding new base PrepareImage steps.
@@ -138,6 +159,8 @@ func (s *BaseStage) PrepareImage(_ Conveyor, prevBuiltImage, image imagePkg.Imag
 		return fmt.Errorf("error adding mounts volumes: %s", err)
 	}
 
+	s.addSecretMountVolumes(image)
+
 	return nil
 }
 
@@ -145,7 +168,28 @@ func (s *BaseStage) AfterImageSyncDockerStateHook(_ Conveyor) error {
 	return nil
 }
 
-func (s *BaseStage) PreRunHook(_ Conveyor) error {
+func (s *BaseStage) PreRunHook(c Conveyor) error {
+	if err := s.acquireCacheMounts(); err != nil {
+		return err
+	}
+
+	return s.writeSecretMounts(c)
+}
+
+func (s *BaseStage) PostRunHook(_ Conveyor) error {
+	var errMsgs []string
+	if err := s.releaseCacheMounts(); err != nil {
+		errMsgs = append(errMsgs, err.Error())
+	}
+
+	if err := s.removeSecretMounts(); err != nil {
+		errMsgs = append(errMsgs, err.Error())
+	}
+
+	if len(errMsgs) != 0 {
+		return errors.New(strings.Join(errMsgs, "\n"))
+	}
+
 	return nil
 }
 
@@ -312,6 +356,146 @@ func (s *BaseStage) addCustomMountLabels(mountpointsByFrom map[string][]string,
 	}
 }
 
+// getSecretMountsConfigs returns secret mounts declared for the stage
+func (s *BaseStage) getSecretMountsConfigs() []*config.Mount {
+	var res []*config.Mount
+	for _, mountCfg := range s.configMounts {
+		if mountCfg.Type == "secret" && mountCfg.Stage == string(s.name) {
+			res = append(res, mountCfg)
+		}
+	}
+
+	return res
+}
+
+// addSecretMountVolumes mounts secrets declared for the stage into the assembly container read-only.
+// Secret mounts are not saved in labels, so secrets are not inherited by images based on the stage image,
+// and the mounted content is never committed into the stage image.
+// Secret files are written right before the assembly container run and removed when the container finishes (see PreRunHook and PostRunHook)
+func (s *BaseStage) addSecretMountVolumes(image imagePkg.ImageInterface) {
+	s.secretMounts = nil
+
+	for ind, mountCfg := range s.getSecretMountsConfigs() {
+		// the mount index makes the file name unique for ids with the same slug
+		secretPath := filepath.Join(s.secretMountsDir(), fmt.Sprintf("%d-%s", ind, slug.Slug(mountCfg.Id)))
+		s.secretMounts = append(s.secretMounts, &secretMount{config: mountCfg, path: secretPath})
+
+		absoluteMountpoint := path.Join("/", mountCfg.To)
+		image.BuilderContainer().AddVolume(fmt.Sprintf("%s:%s:ro", secretPath, absoluteMountpoint))
+	}
+}
+
+func (s *BaseStage) secretMountsDir() string {
+	return filepath.Join(s.imageTmpDir, "secret", string(s.Name()))
+}
+
+func (s *BaseStage) writeSecretMounts(c Conveyor) error {
+	if len(s.secretMounts) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(s.secretMountsDir(), 0700); err != nil {
+		return fmt.Errorf("error creating %s: %s", s.secretMountsDir(), err)
+	}
+
+	for _, m := range s.secretMounts {
+		data, err := s.getSecretMountData(c, m.config)
+		if err != nil {
+			return err
+		}
+
+		// the secrets dir is accessible by the host user only, the file is readable by any user of the assembly container
+		if err := ioutil.WriteFile(m.path, data, 0444); err != nil {
+			return fmt.Errorf("error writing secret %s: %s", m.config.Id, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *BaseStage) removeSecretMounts() error {
+	if len(s.secretMounts) == 0 {
+		return nil
+	}
+
+	if err := os.RemoveAll(s.secretMountsDir()); err != nil {
+		return fmt.Errorf("error removing secrets %s: %s", s.secretMountsDir(), err)
+	}
+
+	return nil
+}
+
+func (s *BaseStage) getSecretMountData(c Conveyor, mountCfg *config.Mount) ([]byte, error) {
+	if mountCfg.Env != "" {
+		value, isSet := os.LookupEnv(mountCfg.Env)
+		if !isSet {
+			return nil, fmt.Errorf("environment variable %s for secret %s is not set", mountCfg.Env, mountCfg.Id)
+		}
+
+		return []byte(value), nil
+	}
+
+	secretFilePath := mountCfg.From
+	if !filepath.IsAbs(secretFilePath) {
+		secretFilePath = filepath.Join(c.GetProjectDir(), secretFilePath)
+	}
+
+	encodedData, err := ioutil.ReadFile(secretFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading secret %s file %s: %s", mountCfg.Id, secretFilePath, err)
+	}
+
+	m, err := c.GetSecretManager()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := m.Decrypt(bytes.TrimSpace(encodedData))
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting secret %s file %s: %s", mountCfg.Id, secretFilePath, err)
+	}
+
+	return data, nil
+}
+
+// acquireCacheMounts locks cache mounts instances for the assembly container run and mounts them.
+// Cache mounts are not saved in labels, so cache mounts are not inherited by images based on the stage image
+func (s *BaseStage) acquireCacheMounts() error {
+	for _, mountCfg := range s.configMounts {
+		if mountCfg.Type != "cache" {
+			continue
+		}
+
+		m, err := cache_mount.Acquire(mountCfg.Id, mountCfg.Sharing, s.projectName)
+		if err != nil {
+			_ = s.releaseCacheMounts()
+			return fmt.Errorf("error acquiring cache mount %s: %s", mountCfg.Id, err)
+		}
+		s.cacheMounts = append(s.cacheMounts, m)
+
+		absoluteMountpoint := path.Join("/", mountCfg.To)
+		s.GetImage().BuilderContainer().AddVolume(fmt.Sprintf("%s:%s", m.DataDir, absoluteMountpoint))
+	}
+
+	return nil
+}
+
+func (s *BaseStage) releaseCacheMounts() error {
+	var errMsgs []string
+	for _, m := range s.cacheMounts {
+		if err := m.Release(); err != nil {
+			errMsgs = append(errMsgs, fmt.Sprintf("error releasing cache mount %s: %s", m.Id, err))
+		}
+	}
+	s.cacheMounts = nil
+
+	if len(errMsgs) != 0 {
+		return errors.New(strings.Join(errMsgs, "\n"))
+	}
+
+	return nil
+}
+
 func (s *BaseStage) SetSignature(signature string) {
 	s.signature = signature
 }
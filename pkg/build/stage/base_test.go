@@ -0,0 +1,99 @@
+package stage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/werf/pkg/build/builder"
+	"github.com/flant/werf/pkg/config"
+	"github.com/flant/werf/pkg/image"
+)
+
+var _ = Describe("secret mounts", func() {
+	var tmpDir string
+
+	shellConfig := &config.Shell{Install: []string{"npm ci"}, Setup: []string{"pip install -r requirements.txt"}}
+
+	secretMount := func(id, env, stageName string) *config.Mount {
+		return &config.Mount{Type: "secret", Id: id, Env: env, Stage: stageName, To: "/run/secrets/" + id}
+	}
+
+	newStages := func(mounts ...*config.Mount) (*InstallStage, *SetupStage) {
+		b := builder.NewShellBuilder(shellConfig, &builder.Extra{})
+		baseStageOptions := &NewBaseStageOptions{ConfigMounts: mounts, ImageTmpDir: tmpDir}
+		return newInstallStage(b, &NewGitPatchStageOptions{}, baseStageOptions), newSetupStage(b, &NewGitPatchStageOptions{}, baseStageOptions)
+	}
+
+	dependencies := func(s Interface) string {
+		dependencies, err := s.GetDependencies(nil, nil, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		return dependencies
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "werf-secret-mounts-test")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Ω(os.RemoveAll(tmpDir)).Should(Succeed())
+		Ω(os.Unsetenv("WERF_TEST_SECRET")).Should(Succeed())
+	})
+
+	It("changes the signature of the declaring stage only", func() {
+		install, setup := newStages()
+		installWithSecret, setupWithSecret := newStages(secretMount("npmrc", "WERF_TEST_SECRET", "install"))
+
+		Ω(dependencies(installWithSecret)).ShouldNot(Equal(dependencies(install)))
+		Ω(dependencies(setupWithSecret)).Should(Equal(dependencies(setup)))
+	})
+
+	It("does not change the signature when the secret content changes", func() {
+		installWithSecret, _ := newStages(secretMount("npmrc", "WERF_TEST_SECRET", "install"))
+
+		Ω(os.Setenv("WERF_TEST_SECRET", "first")).Should(Succeed())
+		firstDependencies := dependencies(installWithSecret)
+
+		Ω(os.Setenv("WERF_TEST_SECRET", "second")).Should(Succeed())
+		Ω(dependencies(installWithSecret)).Should(Equal(firstDependencies))
+
+		otherInstallWithSecret, _ := newStages(secretMount("pip-token", "WERF_TEST_SECRET", "install"))
+		Ω(dependencies(otherInstallWithSecret)).ShouldNot(Equal(firstDependencies))
+	})
+
+	It("mounts the secret into the declaring stage assembly container only", func() {
+		install, setup := newStages(secretMount("npmrc", "WERF_TEST_SECRET", "install"))
+		Ω(os.Setenv("WERF_TEST_SECRET", "token")).Should(Succeed())
+
+		installImage := image.NewStageImage(nil, "project:install")
+		install.addSecretMountVolumes(installImage)
+		Ω(install.writeSecretMounts(nil)).Should(Succeed())
+
+		setupImage := image.NewStageImage(nil, "project:setup")
+		setup.addSecretMountVolumes(setupImage)
+		Ω(setupImage.Container().RunOptions().(*image.StageImageContainerOptions).Volume).Should(BeEmpty())
+
+		volumes := installImage.Container().RunOptions().(*image.StageImageContainerOptions).Volume
+		Ω(volumes).Should(HaveLen(1))
+
+		secretPath := install.secretMounts[0].path
+		Ω(volumes[0]).Should(Equal(secretPath + ":/run/secrets/npmrc:ro"))
+		Ω(ioutil.ReadFile(secretPath)).Should(Equal([]byte("token")))
+
+		secretInfo, err := os.Stat(secretPath)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(secretInfo.Mode().Perm()).Should(Equal(os.FileMode(0444)))
+
+		dirInfo, err := os.Stat(filepath.Dir(secretPath))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(dirInfo.Mode().Perm()).Should(Equal(os.FileMode(0700)))
+
+		Ω(install.removeSecretMounts()).Should(Succeed())
+		Ω(secretPath).ShouldNot(BeAnExistingFile())
+	})
+})
@@ -26,7 +26,9 @@ type BeforeInstallStage struct {
 }
 
 func (s *BeforeInstallStage) GetDependencies(_ Conveyor, _, _ image.ImageInterface) (string, error) {
-	return s.builder.BeforeInstallChecksum(), nil
+	s.addChecksumDependencyInputs(s.builder.BeforeInstallChecksumInputs())
+
+	return s.withSecretMountsChecksum(s.builder.BeforeInstallChecksum()), nil
 }
 
 func (s *BeforeInstallStage) PrepareImage(c Conveyor, prevBuiltImage, image image.ImageInterface) error {
@@ -32,7 +32,9 @@ func (s *BeforeSetupStage) GetDependencies(_ Conveyor, _, _ image.ImageInterface
 		return "", err
 	}
 
-	return util.Sha256Hash(s.builder.BeforeSetupChecksum(), stageDependenciesChecksum), nil
+	s.addChecksumDependencyInputs(s.builder.BeforeSetupChecksumInputs())
+
+	return s.withSecretMountsChecksum(util.Sha256Hash(s.builder.BeforeSetupChecksum(), stageDependenciesChecksum)), nil
 }
 
 func (s *BeforeSetupStage) PrepareImage(c Conveyor, prevBuiltImage, image image.ImageInterface) error {
@@ -1,6 +1,11 @@
 package stage
 
+import "github.com/flant/werf/pkg/deploy/secret"
+
 type Conveyor interface {
+	GetProjectDir() string
+	GetSecretManager() (secret.Manager, error)
+
 	GetImageLatestStageSignature(imageName string) string
 	GetImageLatestStageImageName(imageName string) string
 	SetBuildingGitStage(imageName string, stageName StageName)
@@ -0,0 +1,35 @@
+package stage
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// DependencyInput is the named input of the stage signature.
+// The inputs are used to explain why the stage signature has changed
+type DependencyInput struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+func (input DependencyInput) String() string {
+	return strings.Join(input.Values, " ")
+}
+
+func MarshalDependencyInputs(inputs []DependencyInput) (string, error) {
+	data, err := json.Marshal(inputs)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+func UnmarshalDependencyInputs(data string) ([]DependencyInput, error) {
+	var inputs []DependencyInput
+	if err := json.Unmarshal([]byte(data), &inputs); err != nil {
+		return nil, err
+	}
+
+	return inputs, nil
+}
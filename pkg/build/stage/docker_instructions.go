@@ -43,6 +43,16 @@ func (s *DockerInstructionsStage) GetDependencies(_ Conveyor, _, _ image.ImageIn
 	args = append(args, "") // legacy StopSignal
 	args = append(args, s.instructions.HealthCheck)
 
+	s.AddDependencyInput("volume", s.instructions.Volume...)
+	s.AddDependencyInput("expose", s.instructions.Expose...)
+	s.AddDependencyInput("env", mapToSortedArgs(s.instructions.Env)...)
+	s.AddDependencyInput("label", mapToSortedArgs(s.instructions.Label)...)
+	s.AddDependencyInput("cmd", s.instructions.Cmd)
+	s.AddDependencyInput("entrypoint", s.instructions.Entrypoint)
+	s.AddDependencyInput("workdir", s.instructions.Workdir)
+	s.AddDependencyInput("user", s.instructions.User)
+	s.AddDependencyInput("healthCheck", s.instructions.HealthCheck)
+
 	return util.Sha256Hash(args...), nil
 }
 
@@ -1,35 +1,40 @@
 package stage
 
 import (
+	"crypto/sha256"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/docker/docker/pkg/fileutils"
 	"github.com/moby/buildkit/frontend/dockerfile/instructions"
 	"github.com/moby/buildkit/frontend/dockerfile/parser"
 	"github.com/moby/buildkit/frontend/dockerfile/shell"
 
+	"github.com/flant/werf/pkg/files_checksum_cache"
 	"github.com/flant/werf/pkg/image"
 	"github.com/flant/werf/pkg/util"
 
 	"github.com/flant/logboek"
 )
 
-func GenerateDockerfileStage(dockerfilePath, target, context string, dockerignorePatternMatcher *fileutils.PatternMatcher, buildArgs map[string]interface{}, addHost []string, dockerStages []instructions.Stage, dockerArgsHash map[string]string, dockerTargetStageIndex int, baseStageOptions *NewBaseStageOptions) *DockerfileStage {
-	return newDockerfileStage(dockerfilePath, target, context, dockerignorePatternMatcher, buildArgs, addHost, dockerStages, dockerArgsHash, dockerTargetStageIndex, baseStageOptions)
+func GenerateDockerfileStage(dockerfilePath, target, context string, dockerignorePatternMatcher *fileutils.PatternMatcher, filesChecksumCache *files_checksum_cache.Cache, buildArgs map[string]interface{}, addHost []string, buildKitOptions *DockerfileBuildKitOptions, dockerStages []instructions.Stage, dockerArgsHash map[string]string, dockerTargetStageIndex int, baseStageOptions *NewBaseStageOptions) *DockerfileStage {
+	return newDockerfileStage(dockerfilePath, target, context, dockerignorePatternMatcher, filesChecksumCache, buildArgs, addHost, buildKitOptions, dockerStages, dockerArgsHash, dockerTargetStageIndex, baseStageOptions)
 }
 
-func newDockerfileStage(dockerfilePath, target, context string, dockerignorePatternMatcher *fileutils.PatternMatcher, buildArgs map[string]interface{}, addHost []string, dockerStages []instructions.Stage, dockerArgsHash map[string]string, dockerTargetStageIndex int, baseStageOptions *NewBaseStageOptions) *DockerfileStage {
+func newDockerfileStage(dockerfilePath, target, context string, dockerignorePatternMatcher *fileutils.PatternMatcher, filesChecksumCache *files_checksum_cache.Cache, buildArgs map[string]interface{}, addHost []string, buildKitOptions *DockerfileBuildKitOptions, dockerStages []instructions.Stage, dockerArgsHash map[string]string, dockerTargetStageIndex int, baseStageOptions *NewBaseStageOptions) *DockerfileStage {
 	s := &DockerfileStage{}
 	s.dockerfilePath = dockerfilePath
 	s.target = target
 	s.context = context
 	s.dockerignorePatternMatcher = dockerignorePatternMatcher
+	s.filesChecksumCache = filesChecksumCache
 	s.buildArgs = buildArgs
 	s.addHost = addHost
+	s.buildKitOptions = buildKitOptions
 
 	s.dockerStages = dockerStages
 	s.dockerArgsHash = dockerArgsHash
@@ -47,15 +52,26 @@ type DockerfileStage struct {
 	buildArgs      map[string]interface{}
 	addHost        []string
 
+	buildKitOptions *DockerfileBuildKitOptions
+
 	dockerStages           []instructions.Stage
 	dockerArgsHash         map[string]string
 	dockerTargetStageIndex int
 
 	dockerignorePatternMatcher *fileutils.PatternMatcher
+	filesChecksumCache         *files_checksum_cache.Cache
 
 	*BaseStage
 }
 
+// DockerfileBuildKitOptions enables BuildKit builder for the stage.
+// Secrets is the map of secret id and absolute path to the secret file, which can be used with RUN --mount=type=secret.
+// SSH forwards werf ssh agent socket into the build, which can be used with RUN --mount=type=ssh
+type DockerfileBuildKitOptions struct {
+	Secrets map[string]string
+	SSH     bool
+}
+
 type dockerfileInstructionInterface interface {
 	String() string
 	Name() string
@@ -83,34 +99,12 @@ func (s *DockerfileStage) GetDependencies(_ Conveyor, _, _ image.ImageInterface)
 		dependencies = append(dependencies, resolvedBaseName)
 
 		for _, cmd := range stage.Commands {
-			switch c := cmd.(type) {
-			case *instructions.ArgCommand:
-				dependencies = append(dependencies, c.String())
-				if argValue, exist := s.dockerArgsHash[c.Key]; exist {
-					dependencies = append(dependencies, argValue)
-				}
-			case *instructions.AddCommand:
-				dependencies = append(dependencies, c.String())
-
-				hashSum, err := s.calculateFilesHashsum(c.SourcesAndDest.Sources())
-				if err != nil {
-					return "", err
-				}
-				dependencies = append(dependencies, hashSum)
-			case *instructions.CopyCommand:
-				dependencies = append(dependencies, c.String())
-				if c.From == "" {
-					hashSum, err := s.calculateFilesHashsum(c.SourcesAndDest.Sources())
-					if err != nil {
-						return "", err
-					}
-					dependencies = append(dependencies, hashSum)
-				}
-			case dockerfileInstructionInterface:
-				dependencies = append(dependencies, c.String())
-			default:
-				panic("runtime error")
+			commandDependencies, err := s.commandDependencies(cmd)
+			if err != nil {
+				return "", err
 			}
+
+			dependencies = append(dependencies, commandDependencies...)
 		}
 
 		stagesDependencies = append(stagesDependencies, dependencies)
@@ -142,13 +136,97 @@ func (s *DockerfileStage) GetDependencies(_ Conveyor, _, _ image.ImageInterface)
 		}
 	}
 
+	for _, dependency := range stagesDependencies[s.dockerTargetStageIndex] {
+		s.AddDependencyInput("dockerfile", dependency)
+	}
+
 	return util.Sha256Hash(stagesDependencies[s.dockerTargetStageIndex]...), nil
 }
 
+func (s *DockerfileStage) commandDependencies(cmd instructions.Command) ([]string, error) {
+	var dependencies []string
+
+	switch c := cmd.(type) {
+	case *instructions.ArgCommand:
+		dependencies = append(dependencies, c.String())
+		if argValue, exist := s.dockerArgsHash[c.Key]; exist {
+			dependencies = append(dependencies, argValue)
+		}
+	case *instructions.AddCommand:
+		dependencies = append(dependencies, c.String())
+
+		hashSum, err := s.calculateFilesHashsum(c.SourcesAndDest.Sources())
+		if err != nil {
+			return nil, err
+		}
+		dependencies = append(dependencies, hashSum)
+	case *instructions.CopyCommand:
+		dependencies = append(dependencies, c.String())
+		if c.From == "" {
+			hashSum, err := s.calculateFilesHashsum(c.SourcesAndDest.Sources())
+			if err != nil {
+				return nil, err
+			}
+			dependencies = append(dependencies, hashSum)
+		}
+	case dockerfileInstructionInterface:
+		dependencies = append(dependencies, c.String())
+	default:
+		panic("runtime error")
+	}
+
+	return dependencies, nil
+}
+
 func (s *DockerfileStage) PrepareImage(c Conveyor, prevBuiltImage, image image.ImageInterface) error {
 	return nil
 }
 
+func (s *DockerfileStage) IsBuildKit() bool {
+	return s.buildKitOptions != nil
+}
+
+// BuildKitBuildArgs returns docker build args for BuildKit builder: secrets, ssh agent socket and images to import the build cache from.
+// The inline build cache is exported into the built image, so the image saved in the stages storage can be used as cache source later
+func (s *DockerfileStage) BuildKitBuildArgs(sshAuthSock string, cacheFromImages []string) ([]string, error) {
+	result, err := s.buildKitArgs(sshAuthSock, cacheFromImages)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(result, s.DockerBuildArgs()...), nil
+}
+
+func (s *DockerfileStage) buildKitArgs(sshAuthSock string, cacheFromImages []string) ([]string, error) {
+	var result []string
+
+	var secretsIds []string
+	for id := range s.buildKitOptions.Secrets {
+		secretsIds = append(secretsIds, id)
+	}
+	sort.Strings(secretsIds)
+
+	for _, id := range secretsIds {
+		result = append(result, fmt.Sprintf("--secret=id=%s,src=%s", id, s.buildKitOptions.Secrets[id]))
+	}
+
+	if s.buildKitOptions.SSH {
+		if sshAuthSock == "" {
+			return nil, fmt.Errorf("ssh agent is not available: ssh forwarding requires werf ssh agent or SSH_AUTH_SOCK")
+		}
+
+		result = append(result, fmt.Sprintf("--ssh=default=%s", sshAuthSock))
+	}
+
+	for _, cacheFromImage := range cacheFromImages {
+		result = append(result, fmt.Sprintf("--cache-from=%s", cacheFromImage))
+	}
+
+	result = append(result, "--build-arg=BUILDKIT_INLINE_CACHE=1")
+
+	return result, nil
+}
+
 func (s *DockerfileStage) DockerBuildArgs() []string {
 	var result []string
 
@@ -175,8 +253,10 @@ func (s *DockerfileStage) DockerBuildArgs() []string {
 	return result
 }
 
+// calculateFilesHashsum calculates checksum of context files matching wildcards: relative path, mode and content checksum of each file.
+// Files contents checksums are cached between werf runs
 func (s *DockerfileStage) calculateFilesHashsum(wildcards []string) (string, error) {
-	var dependencies []string
+	h := sha256.New()
 
 	for _, wildcard := range wildcards {
 		contextWildcard := filepath.Join(s.context, wildcard)
@@ -186,89 +266,108 @@ func (s *DockerfileStage) calculateFilesHashsum(wildcards []string) (string, err
 			return "", fmt.Errorf("glob %s failed: %s", contextWildcard, err)
 		}
 
-		var fileList []string
 		for _, match := range matches {
-			matchFileList, err := getAllFiles(match)
-			if err != nil {
+			if err := s.walkContextFiles(match, match, func(path, realPath string, info os.FileInfo) error {
+				checksum, err := s.filesChecksumCache.FileChecksum(realPath, info)
+				if err != nil {
+					return err
+				}
+
+				relPath, err := filepath.Rel(s.context, path)
+				if err != nil {
+					return err
+				}
+
+				_, err = fmt.Fprintf(h, "%s\x00%s\x00%s\n", filepath.ToSlash(relPath), info.Mode(), checksum)
+				return err
+			}); err != nil {
 				return "", fmt.Errorf("walk %s failed: %s", match, err)
 			}
+		}
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
 
-			fileList = append(fileList, matchFileList...)
+// walkContextFiles walks files of the target path skipping .dockerignore exclusions, symlinks are followed.
+// The fn gets the path inside the context, the real file path and the real file info
+func (s *DockerfileStage) walkContextFiles(target, realTarget string, fn func(path, realPath string, info os.FileInfo) error) error {
+	return filepath.Walk(realTarget, func(realPath string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
 		}
 
-		var finalFileList []string
-		for _, filePath := range fileList {
-			ignore, err := s.dockerignorePatternMatcher.Matches(filePath)
-			if err != nil {
-				return "", err
-			}
+		path := filepath.Join(target, strings.TrimPrefix(realPath, realTarget))
 
-			if !ignore {
-				finalFileList = append(finalFileList, filePath)
-			}
+		ignore, err := s.dockerignorePatternMatcher.Matches(path)
+		if err != nil {
+			return err
 		}
 
-		for _, file := range finalFileList {
-			data, err := ioutil.ReadFile(file)
-			if err != nil {
-				return "", fmt.Errorf("read file %s failed: %s", file, err)
+		if f.IsDir() {
+			if ignore && !s.isDockerignoreExclusionInside(path) {
+				return filepath.SkipDir
 			}
 
-			dependencies = append(dependencies, string(data))
+			return nil
 		}
-	}
-
-	return util.Sha256Hash(dependencies...), nil
-}
 
-func getAllFiles(target string) ([]string, error) {
-	var fileList []string
-	err := filepath.Walk(target, func(path string, f os.FileInfo, err error) error {
-		if f.IsDir() {
+		if ignore {
 			return nil
 		}
 
 		if f.Mode()&os.ModeSymlink != 0 {
-			linkTo, err := os.Readlink(path)
+			linkTo, err := os.Readlink(realPath)
 			if err != nil {
 				return err
 			}
 
-			linkFilePath := filepath.Join(filepath.Dir(path), linkTo)
-			exist, err := util.FileExists(linkFilePath)
+			linkFilePath := linkTo
+			if !filepath.IsAbs(linkFilePath) {
+				linkFilePath = filepath.Join(filepath.Dir(realPath), linkTo)
+			}
+
+			lfinfo, err := os.Stat(linkFilePath)
 			if err != nil {
-				return err
-			} else if !exist {
-				return nil
-			} else {
-				lfinfo, err := os.Stat(linkFilePath)
-				if err != nil {
-					return err
+				if os.IsNotExist(err) {
+					return nil
 				}
 
-				if lfinfo.IsDir() {
-					// infinite loop detector
-					if target == linkFilePath {
-						return nil
-					}
-
-					lfileList, err := getAllFiles(linkFilePath)
-					if err != nil {
-						return err
-					}
+				return err
+			}
 
-					fileList = append(fileList, lfileList...)
-				} else {
-					fileList = append(fileList, linkFilePath)
+			if lfinfo.IsDir() {
+				// infinite loop detector
+				if realTarget == linkFilePath || strings.HasPrefix(realPath, linkFilePath+string(filepath.Separator)) {
+					return nil
 				}
 
-				return nil
+				return s.walkContextFiles(path, linkFilePath, fn)
 			}
+
+			return fn(path, linkFilePath, lfinfo)
 		}
 
-		fileList = append(fileList, path)
-		return err
+		return fn(path, realPath, f)
 	})
+}
+
+// isDockerignoreExclusionInside checks whether the ignored directory can contain files re-included by !pattern
+func (s *DockerfileStage) isDockerignoreExclusionInside(dir string) bool {
+	if !s.dockerignorePatternMatcher.Exclusions() {
+		return false
+	}
+
+	dirWithSeparator := dir + string(filepath.Separator)
+	for _, pattern := range s.dockerignorePatternMatcher.Patterns() {
+		if !pattern.Exclusion() {
+			continue
+		}
+
+		if strings.HasPrefix(pattern.String()+string(filepath.Separator), dirWithSeparator) {
+			return true
+		}
+	}
 
-	return fileList, err
+	return false
 }
@@ -0,0 +1,343 @@
+package stage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"github.com/moby/buildkit/frontend/dockerfile/shell"
+
+	"github.com/flant/werf/pkg/config"
+	"github.com/flant/werf/pkg/image"
+	"github.com/flant/werf/pkg/util"
+)
+
+// GenerateDockerfilePartStages splits the Dockerfile into stages, which are built and stored in the stages storage separately:
+// one stage per Dockerfile instruction or one stage per Dockerfile stage (splitStages directive).
+// Only Dockerfile stages required to build the target Dockerfile stage are used, the target stage parts are the last ones
+func GenerateDockerfilePartStages(dockerfileStage *DockerfileStage, splitStages string, baseStageOptions *NewBaseStageOptions) ([]*DockerfilePartStage, error) {
+	var dockerMetaArgsString []string
+	for key, value := range dockerfileStage.dockerArgsHash {
+		dockerMetaArgsString = append(dockerMetaArgsString, fmt.Sprintf("%s=%s", key, value))
+	}
+
+	shlex := shell.NewLex(parser.DefaultEscapeToken)
+
+	resolvedBaseNames := map[int]string{}
+	baseDockerStageIndexes := map[int]int{}
+	for ind, dockerStage := range dockerfileStage.dockerStages {
+		resolvedBaseName, err := shlex.ProcessWord(dockerStage.BaseName, dockerMetaArgsString)
+		if err != nil {
+			return nil, err
+		}
+
+		resolvedBaseNames[ind] = resolvedBaseName
+
+		for relatedStageIndex := 0; relatedStageIndex < ind; relatedStageIndex++ {
+			if strings.EqualFold(dockerfileStage.dockerStages[relatedStageIndex].Name, resolvedBaseName) {
+				baseDockerStageIndexes[ind] = relatedStageIndex
+			}
+		}
+	}
+
+	requiredDockerStages := map[int]bool{dockerfileStage.dockerTargetStageIndex: true}
+	for ind := dockerfileStage.dockerTargetStageIndex; ind >= 0; ind-- {
+		if !requiredDockerStages[ind] {
+			continue
+		}
+
+		if baseIndex, hasKey := baseDockerStageIndexes[ind]; hasKey {
+			requiredDockerStages[baseIndex] = true
+		}
+
+		for _, cmd := range dockerfileStage.dockerStages[ind].Commands {
+			if c, ok := cmd.(*instructions.CopyCommand); ok && c.From != "" {
+				if relatedStageIndex, err := strconv.Atoi(c.From); err == nil && relatedStageIndex < ind {
+					requiredDockerStages[relatedStageIndex] = true
+				}
+			}
+		}
+	}
+
+	var stages []*DockerfilePartStage
+	lastDockerStageParts := map[int]*DockerfilePartStage{}
+
+	for ind, dockerStage := range dockerfileStage.dockerStages {
+		if !requiredDockerStages[ind] {
+			continue
+		}
+
+		copyFromStages := map[string]*DockerfilePartStage{}
+		for _, cmd := range dockerStage.Commands {
+			switch c := cmd.(type) {
+			case *instructions.CopyCommand:
+				if relatedStageIndex, err := strconv.Atoi(c.From); err == nil {
+					if part, hasKey := lastDockerStageParts[relatedStageIndex]; hasKey {
+						copyFromStages[c.From] = part
+					}
+				}
+			case *instructions.OnbuildCommand:
+				if splitStages == config.DockerfileSplitByInstruction {
+					return nil, fmt.Errorf("ONBUILD instruction cannot be used with `splitStages: %s`: the trigger would be executed by the next instruction stage", config.DockerfileSplitByInstruction)
+				}
+			}
+		}
+
+		newPart := func(name StageName, commands []instructions.Command, argCommands []*instructions.ArgCommand, baseStage *DockerfilePartStage) *DockerfilePartStage {
+			part := newDockerfilePartStage(name, dockerfileStage, ind, commands, argCommands, baseStage, copyFromStages, baseStageOptions)
+			if baseStage == nil {
+				part.resolvedBaseName = resolvedBaseNames[ind]
+			}
+
+			stages = append(stages, part)
+
+			return part
+		}
+
+		var baseStage *DockerfilePartStage
+		if baseIndex, hasKey := baseDockerStageIndexes[ind]; hasKey {
+			baseStage = lastDockerStageParts[baseIndex]
+		}
+
+		switch splitStages {
+		case config.DockerfileSplitByStage:
+			baseStage = newPart(StageName(fmt.Sprintf("%s-%d", Dockerfile, ind)), dockerStage.Commands, nil, baseStage)
+		case config.DockerfileSplitByInstruction:
+			baseStage = newPart(StageName(fmt.Sprintf("%s-%d-0", Dockerfile, ind)), nil, nil, baseStage)
+
+			var argCommands []*instructions.ArgCommand
+			for cmdInd, cmd := range dockerStage.Commands {
+				baseStage = newPart(StageName(fmt.Sprintf("%s-%d-%d", Dockerfile, ind, cmdInd+1)), []instructions.Command{cmd}, argCommands, baseStage)
+
+				if c, ok := cmd.(*instructions.ArgCommand); ok {
+					argCommands = append(argCommands, c)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unknown splitStages value %q", splitStages)
+		}
+
+		lastDockerStageParts[ind] = baseStage
+	}
+
+	return stages, nil
+}
+
+func newDockerfilePartStage(name StageName, dockerfileStage *DockerfileStage, dockerStageIndex int, commands []instructions.Command, argCommands []*instructions.ArgCommand, baseStage *DockerfilePartStage, copyFromStages map[string]*DockerfilePartStage, baseStageOptions *NewBaseStageOptions) *DockerfilePartStage {
+	s := &DockerfilePartStage{}
+	s.dockerfileStage = dockerfileStage
+	s.dockerStageIndex = dockerStageIndex
+	s.commands = commands
+	s.argCommands = argCommands
+	s.baseStage = baseStage
+	s.copyFromStages = copyFromStages
+
+	s.BaseStage = newBaseStage(name, baseStageOptions)
+
+	return s
+}
+
+// DockerfilePartStage is built from the generated Dockerfile: FROM the previous part image (or the Dockerfile stage base image),
+// ARG instructions of the Dockerfile stage preceding the part and the part instructions
+type DockerfilePartStage struct {
+	dockerfileStage *DockerfileStage
+
+	dockerStageIndex int
+	commands         []instructions.Command
+	argCommands      []*instructions.ArgCommand
+
+	resolvedBaseName string
+	baseStage        *DockerfilePartStage
+	copyFromStages   map[string]*DockerfilePartStage
+
+	dockerfilePath string
+	contextDir     string
+
+	*BaseStage
+}
+
+func (s *DockerfilePartStage) GetDependencies(_ Conveyor, _, _ image.ImageInterface) (string, error) {
+	var dependencies []string
+
+	dependencies = append(dependencies, s.dockerfileStage.addHost...)
+
+	if s.baseStage != nil {
+		dependencies = append(dependencies, s.baseStage.GetSignature())
+	} else {
+		dependencies = append(dependencies, s.resolvedBaseName)
+	}
+
+	for _, cmd := range s.argCommands {
+		commandDependencies, err := s.dockerfileStage.commandDependencies(cmd)
+		if err != nil {
+			return "", err
+		}
+
+		dependencies = append(dependencies, commandDependencies...)
+	}
+
+	for _, cmd := range s.commands {
+		commandDependencies, err := s.dockerfileStage.commandDependencies(cmd)
+		if err != nil {
+			return "", err
+		}
+
+		dependencies = append(dependencies, commandDependencies...)
+
+		if c, ok := cmd.(*instructions.CopyCommand); ok && c.From != "" {
+			if copyFromStage, hasKey := s.copyFromStages[c.From]; hasKey {
+				dependencies = append(dependencies, copyFromStage.GetSignature())
+			}
+		}
+	}
+
+	for _, dependency := range dependencies {
+		s.AddDependencyInput("dockerfile", dependency)
+	}
+
+	return util.Sha256Hash(dependencies...), nil
+}
+
+func (s *DockerfilePartStage) PrepareImage(_ Conveyor, _, _ image.ImageInterface) error {
+	return nil
+}
+
+func (s *DockerfilePartStage) IsBuildKit() bool {
+	return s.dockerfileStage.IsBuildKit()
+}
+
+func (s *DockerfilePartStage) BuildKitBuildArgs(sshAuthSock string, cacheFromImages []string) ([]string, error) {
+	result, err := s.dockerfileStage.buildKitArgs(sshAuthSock, cacheFromImages)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(result, s.DockerBuildArgs()...), nil
+}
+
+// DockerBuildArgs returns docker build args for the generated Dockerfile, PrepareBuildFiles should be called before
+func (s *DockerfilePartStage) DockerBuildArgs() []string {
+	var result []string
+
+	result = append(result, fmt.Sprintf("--file=%s", s.dockerfilePath))
+
+	// meta ARG values are passed explicitly, because meta ARG instructions are not the part of the generated Dockerfile
+	var argsKeys []string
+	for key := range s.dockerfileStage.dockerArgsHash {
+		argsKeys = append(argsKeys, key)
+	}
+	sort.Strings(argsKeys)
+
+	for _, key := range argsKeys {
+		result = append(result, fmt.Sprintf("--build-arg=%s=%s", key, s.dockerfileStage.dockerArgsHash[key]))
+	}
+
+	for _, addHost := range s.dockerfileStage.addHost {
+		result = append(result, fmt.Sprintf("--add-host=%s", addHost))
+	}
+
+	result = append(result, s.contextDir)
+
+	return result
+}
+
+// PrepareBuildFiles writes the generated Dockerfile and selects the build context directory.
+// The Dockerfile context is sent only if the part contains ADD or COPY instructions, otherwise the empty directory is used
+func (s *DockerfilePartStage) PrepareBuildFiles() error {
+	dockerfileContent, err := s.generateDockerfile()
+	if err != nil {
+		return err
+	}
+
+	buildDir := filepath.Join(s.imageTmpDir, "dockerfile", string(s.Name()))
+	emptyContextDir := filepath.Join(buildDir, "context")
+	if err := os.MkdirAll(emptyContextDir, os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create dir %s: %s", emptyContextDir, err)
+	}
+
+	s.dockerfilePath = filepath.Join(buildDir, "Dockerfile")
+	if err := ioutil.WriteFile(s.dockerfilePath, []byte(dockerfileContent), 0644); err != nil {
+		return fmt.Errorf("unable to write %s: %s", s.dockerfilePath, err)
+	}
+
+	if s.isContextRequired() {
+		s.contextDir = s.dockerfileStage.context
+	} else {
+		s.contextDir = emptyContextDir
+	}
+
+	return nil
+}
+
+func (s *DockerfilePartStage) isContextRequired() bool {
+	for _, cmd := range s.commands {
+		switch c := cmd.(type) {
+		case *instructions.AddCommand:
+			return true
+		case *instructions.CopyCommand:
+			if c.From == "" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (s *DockerfilePartStage) generateDockerfile() (string, error) {
+	var lines []string
+
+	if s.baseStage != nil {
+		lines = append(lines, fmt.Sprintf("FROM %s", s.baseStage.GetImage().Name()))
+	} else {
+		lines = append(lines, fmt.Sprintf("FROM %s", s.resolvedBaseName))
+	}
+
+	for _, cmd := range s.argCommands {
+		lines = append(lines, cmd.String())
+	}
+
+	for _, cmd := range s.commands {
+		line, err := s.commandLine(cmd)
+		if err != nil {
+			return "", err
+		}
+
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// commandLine returns the original instruction, COPY --from the Dockerfile stage is replaced with COPY --from the stage image
+func (s *DockerfilePartStage) commandLine(cmd instructions.Command) (string, error) {
+	if c, ok := cmd.(*instructions.CopyCommand); ok && c.From != "" {
+		if copyFromStage, hasKey := s.copyFromStages[c.From]; hasKey {
+			sourcesAndDest, err := json.Marshal([]string(c.SourcesAndDest))
+			if err != nil {
+				return "", err
+			}
+
+			line := fmt.Sprintf("COPY --from=%s", copyFromStage.GetImage().Name())
+			if c.Chown != "" {
+				line += fmt.Sprintf(" --chown=%s", c.Chown)
+			}
+
+			return fmt.Sprintf("%s %s", line, sourcesAndDest), nil
+		}
+	}
+
+	c, ok := cmd.(dockerfileInstructionInterface)
+	if !ok {
+		panic("runtime error")
+	}
+
+	return c.String(), nil
+}
@@ -0,0 +1,212 @@
+package stage
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/werf/pkg/config"
+	"github.com/flant/werf/pkg/image"
+)
+
+// newTestDockerfileStage parses the Dockerfile the same way as the initialization phase does:
+// stages names in COPY --from are replaced with stages indexes and build args override meta ARG values
+func newTestDockerfileStage(dockerfile, target string, buildArgs map[string]string) *DockerfileStage {
+	p, err := parser.Parse(bytes.NewReader([]byte(dockerfile)))
+	Ω(err).ShouldNot(HaveOccurred())
+
+	dockerStages, dockerMetaArgs, err := instructions.Parse(p.AST)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	nameToIndex := map[string]string{}
+	targetIndex := len(dockerStages) - 1
+	for ind, dockerStage := range dockerStages {
+		nameToIndex[dockerStage.Name] = strconv.Itoa(ind)
+		if target != "" && dockerStage.Name == target {
+			targetIndex = ind
+		}
+
+		for _, cmd := range dockerStage.Commands {
+			if c, ok := cmd.(*instructions.CopyCommand); ok {
+				if index, hasKey := nameToIndex[c.From]; hasKey {
+					c.From = index
+				}
+			}
+		}
+	}
+
+	dockerArgsHash := map[string]string{}
+	for _, arg := range dockerMetaArgs {
+		dockerArgsHash[arg.Key] = arg.ValueString()
+	}
+
+	for key, value := range buildArgs {
+		dockerArgsHash[key] = value
+	}
+
+	return newDockerfileStage("Dockerfile", target, "/context", nil, nil, nil, nil, nil, dockerStages, dockerArgsHash, targetIndex, &NewBaseStageOptions{ImageName: "app"})
+}
+
+// generatedDockerfiles returns the generated Dockerfile of each part stage prefixed with the stage name
+func generatedDockerfiles(stages []*DockerfilePartStage) []string {
+	for _, s := range stages {
+		s.SetImage(image.NewStageImage(nil, fmt.Sprintf("project:%s", s.Name())))
+	}
+
+	var res []string
+	for _, s := range stages {
+		dockerfile, err := s.generateDockerfile()
+		Ω(err).ShouldNot(HaveOccurred())
+
+		res = append(res, fmt.Sprintf("# %s\n%s", s.Name(), dockerfile))
+	}
+
+	return res
+}
+
+func dockerfileLines(lines ...string) string {
+	return strings.Join(lines, "\n") + "\n"
+}
+
+var _ = Describe("dockerfile part stages", func() {
+	DescribeTable("splits Dockerfile into stages",
+		func(dockerfile, target string, buildArgs map[string]string, splitStages string, expected []string) {
+			stages, err := GenerateDockerfilePartStages(newTestDockerfileStage(dockerfile, target, buildArgs), splitStages, &NewBaseStageOptions{ImageName: "app"})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(generatedDockerfiles(stages)).Should(Equal(expected))
+		},
+		Entry("one stage per instruction",
+			dockerfileLines("FROM alpine:3.10", "RUN apk add curl", "COPY . /app"), "", nil,
+			config.DockerfileSplitByInstruction,
+			[]string{
+				dockerfileLines("# dockerfile-0-0", "FROM alpine:3.10"),
+				dockerfileLines("# dockerfile-0-1", "FROM project:dockerfile-0-0", "RUN apk add curl"),
+				dockerfileLines("# dockerfile-0-2", "FROM project:dockerfile-0-1", "COPY . /app"),
+			},
+		),
+		Entry("one stage per Dockerfile stage",
+			dockerfileLines("FROM alpine:3.10", "RUN apk add curl", "COPY . /app"), "", nil,
+			config.DockerfileSplitByStage,
+			[]string{
+				dockerfileLines("# dockerfile-0", "FROM alpine:3.10", "RUN apk add curl", "COPY . /app"),
+			},
+		),
+		Entry("ARG before FROM",
+			dockerfileLines("ARG BASE=alpine:3.10", "FROM ${BASE}", "ARG VERSION=1", "RUN echo $VERSION"), "", nil,
+			config.DockerfileSplitByInstruction,
+			[]string{
+				dockerfileLines("# dockerfile-0-0", "FROM alpine:3.10"),
+				dockerfileLines("# dockerfile-0-1", "FROM project:dockerfile-0-0", "ARG VERSION=1"),
+				dockerfileLines("# dockerfile-0-2", "FROM project:dockerfile-0-1", "ARG VERSION=1", "RUN echo $VERSION"),
+			},
+		),
+		Entry("ARG before FROM overridden by build args",
+			dockerfileLines("ARG BASE=alpine:3.10", "FROM ${BASE}", "RUN true"), "", map[string]string{"BASE": "alpine:3.11"},
+			config.DockerfileSplitByStage,
+			[]string{
+				dockerfileLines("# dockerfile-0", "FROM alpine:3.11", "RUN true"),
+			},
+		),
+		Entry("multi-stage with COPY --from",
+			dockerfileLines(
+				"FROM golang:1.13 AS build", "RUN go build -o /app",
+				"FROM alpine:3.10", "COPY --from=build /app /usr/bin/app",
+			), "", nil,
+			config.DockerfileSplitByStage,
+			[]string{
+				dockerfileLines("# dockerfile-0", "FROM golang:1.13", "RUN go build -o /app"),
+				dockerfileLines("# dockerfile-1", "FROM alpine:3.10", `COPY --from=project:dockerfile-0 ["/app","/usr/bin/app"]`),
+			},
+		),
+		Entry("multi-stage with COPY --from the last instruction stage",
+			dockerfileLines(
+				"FROM golang:1.13 AS build", "RUN go build -o /app",
+				"FROM alpine:3.10", "COPY --from=build --chown=app /app /usr/bin/app",
+			), "", nil,
+			config.DockerfileSplitByInstruction,
+			[]string{
+				dockerfileLines("# dockerfile-0-0", "FROM golang:1.13"),
+				dockerfileLines("# dockerfile-0-1", "FROM project:dockerfile-0-0", "RUN go build -o /app"),
+				dockerfileLines("# dockerfile-1-0", "FROM alpine:3.10"),
+				dockerfileLines("# dockerfile-1-1", "FROM project:dockerfile-1-0", `COPY --from=project:dockerfile-0-1 --chown=app ["/app","/usr/bin/app"]`),
+			},
+		),
+		Entry("COPY --from the external image",
+			dockerfileLines("FROM alpine:3.10", "COPY --from=nginx:1.17 /etc/nginx /etc/nginx"), "", nil,
+			config.DockerfileSplitByStage,
+			[]string{
+				dockerfileLines("# dockerfile-0", "FROM alpine:3.10", "COPY --from=nginx:1.17 /etc/nginx /etc/nginx"),
+			},
+		),
+		Entry("FROM the previous stage",
+			dockerfileLines("FROM alpine:3.10 AS base", "RUN apk add curl", "FROM base", "RUN curl --version"), "", nil,
+			config.DockerfileSplitByStage,
+			[]string{
+				dockerfileLines("# dockerfile-0", "FROM alpine:3.10", "RUN apk add curl"),
+				dockerfileLines("# dockerfile-1", "FROM project:dockerfile-0", "RUN curl --version"),
+			},
+		),
+		Entry("stages not required for the target are skipped",
+			dockerfileLines(
+				"FROM golang:1.13 AS build", "RUN go build -o /app",
+				"FROM alpine:3.10 AS unused", "RUN true",
+				"FROM alpine:3.10", "COPY --from=build /app /usr/bin/app",
+			), "", nil,
+			config.DockerfileSplitByStage,
+			[]string{
+				dockerfileLines("# dockerfile-0", "FROM golang:1.13", "RUN go build -o /app"),
+				dockerfileLines("# dockerfile-2", "FROM alpine:3.10", `COPY --from=project:dockerfile-0 ["/app","/usr/bin/app"]`),
+			},
+		),
+		Entry("target stage",
+			dockerfileLines(
+				"FROM golang:1.13 AS build", "RUN go build -o /app",
+				"FROM alpine:3.10", "COPY --from=build /app /usr/bin/app",
+			), "build", nil,
+			config.DockerfileSplitByStage,
+			[]string{
+				dockerfileLines("# dockerfile-0", "FROM golang:1.13", "RUN go build -o /app"),
+			},
+		),
+	)
+
+	DescribeTable("fails",
+		func(dockerfile, splitStages, expectedError string) {
+			_, err := GenerateDockerfilePartStages(newTestDockerfileStage(dockerfile, "", nil), splitStages, &NewBaseStageOptions{ImageName: "app"})
+			Ω(err).Should(HaveOccurred())
+			Ω(err.Error()).Should(ContainSubstring(expectedError))
+		},
+		Entry("ONBUILD with one stage per instruction",
+			dockerfileLines("FROM alpine:3.10", "ONBUILD RUN true"), config.DockerfileSplitByInstruction,
+			"ONBUILD instruction cannot be used",
+		),
+		Entry("unknown splitStages value",
+			dockerfileLines("FROM alpine:3.10"), "unknown",
+			`unknown splitStages value "unknown"`,
+		),
+	)
+
+	It("sends the context only for parts with ADD or COPY from the context", func() {
+		stages, err := GenerateDockerfilePartStages(newTestDockerfileStage(dockerfileLines(
+			"FROM golang:1.13 AS build", "RUN go build -o /app",
+			"FROM alpine:3.10", "COPY --from=build /app /usr/bin/app", "ADD config.yaml /etc/app/",
+		), "", nil), config.DockerfileSplitByInstruction, &NewBaseStageOptions{ImageName: "app"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		var withContext []string
+		for _, s := range stages {
+			if s.isContextRequired() {
+				withContext = append(withContext, string(s.Name()))
+			}
+		}
+
+		Ω(withContext).Should(Equal([]string{"dockerfile-1-2"}))
+	})
+})
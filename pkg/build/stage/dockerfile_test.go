@@ -0,0 +1,47 @@
+package stage
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dockerfile stage BuildKit args", func() {
+	DescribeTable("args",
+		func(options *DockerfileBuildKitOptions, sshAuthSock string, cacheFromImages []string, expected []string) {
+			s := &DockerfileStage{buildKitOptions: options}
+			Ω(s.IsBuildKit()).Should(BeTrue())
+			Ω(s.buildKitArgs(sshAuthSock, cacheFromImages)).Should(Equal(expected))
+		},
+		Entry("inline cache only", &DockerfileBuildKitOptions{}, "", nil, []string{
+			"--build-arg=BUILDKIT_INLINE_CACHE=1",
+		}),
+		Entry("secrets sorted by id", &DockerfileBuildKitOptions{Secrets: map[string]string{"pip": "/project/pip.conf", "npmrc": "/home/user/.npmrc"}}, "", nil, []string{
+			"--secret=id=npmrc,src=/home/user/.npmrc",
+			"--secret=id=pip,src=/project/pip.conf",
+			"--build-arg=BUILDKIT_INLINE_CACHE=1",
+		}),
+		Entry("ssh agent", &DockerfileBuildKitOptions{SSH: true}, "/tmp/werf-agent.sock", nil, []string{
+			"--ssh=default=/tmp/werf-agent.sock",
+			"--build-arg=BUILDKIT_INLINE_CACHE=1",
+		}),
+		Entry("cache from images", &DockerfileBuildKitOptions{Secrets: map[string]string{"npmrc": "/project/.npmrc"}, SSH: true}, "/tmp/werf-agent.sock", []string{"project:first", "project:second"}, []string{
+			"--secret=id=npmrc,src=/project/.npmrc",
+			"--ssh=default=/tmp/werf-agent.sock",
+			"--cache-from=project:first",
+			"--cache-from=project:second",
+			"--build-arg=BUILDKIT_INLINE_CACHE=1",
+		}),
+	)
+
+	It("fails when ssh is enabled without ssh agent", func() {
+		s := &DockerfileStage{buildKitOptions: &DockerfileBuildKitOptions{SSH: true}}
+		_, err := s.buildKitArgs("", nil)
+		Ω(err).Should(HaveOccurred())
+		Ω(err.Error()).Should(ContainSubstring("ssh agent is not available"))
+	})
+
+	It("is not BuildKit stage without BuildKit options", func() {
+		Ω((&DockerfileStage{}).IsBuildKit()).Should(BeFalse())
+	})
+})
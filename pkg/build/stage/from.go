@@ -41,17 +41,31 @@ func (s *FromStage) GetDependencies(_ Conveyor, prevImage, _ image.ImageInterfac
 
 	if s.cacheVersion != "" {
 		args = append(args, s.cacheVersion)
+		s.AddDependencyInput("fromCacheVersion", s.cacheVersion)
 	}
 
 	if s.baseImageRepoIdOrNone != "" {
 		args = append(args, s.baseImageRepoIdOrNone)
+		s.AddDependencyInput("baseImageRepoId", s.baseImageRepoIdOrNone)
 	}
 
 	for _, mount := range s.configMounts {
-		args = append(args, filepath.ToSlash(filepath.Clean(mount.From)), path.Clean(mount.To), mount.Type)
+		// the secret mount is the dependency of the user stage declaring it
+		if mount.Type == "secret" {
+			continue
+		}
+
+		mountArgs := []string{filepath.ToSlash(filepath.Clean(mount.From)), path.Clean(mount.To), mount.Type}
+		if mount.Type == "cache" {
+			// signature depends on the cache id, not the cache content
+			mountArgs = append(mountArgs, mount.Id)
+		}
+		args = append(args, mountArgs...)
+		s.AddDependencyInput("mount", mountArgs...)
 	}
 
 	args = append(args, prevImage.Name())
+	s.AddDependencyInput("baseImage", prevImage.Name())
 
 	return util.Sha256Hash(args...), nil
 }
@@ -42,6 +42,7 @@ func (s *GitArchiveStage) GetDependencies(_ Conveyor, _, _ image.ImageInterface)
 	var args []string
 	for _, gitMapping := range s.gitMappings {
 		args = append(args, gitMapping.GetParamshash())
+		s.AddDependencyInput(fmt.Sprintf("gitMapping %s paramshash", gitMapping.GetFullName()), gitMapping.GetParamshash())
 
 		if os.Getenv("DISABLE_GIT_ARCHIVE_RESET_COMMIT") != "1" {
 			commit, err := gitMapping.GitRepo().FindCommitIdByMessage(GitArchiveResetCommitRegex)
@@ -50,6 +51,15 @@ func (s *GitArchiveStage) GetDependencies(_ Conveyor, _, _ image.ImageInterface)
 			}
 
 			args = append(args, commit)
+			s.AddDependencyInput(fmt.Sprintf("gitMapping %s archiveResetCommit", gitMapping.GetFullName()), commit)
+		}
+
+		// archive with working tree changes should not be reused by builds of committed content
+		if hasDevChanges, err := gitMapping.HasDevChanges(); err != nil {
+			return "", err
+		} else if hasDevChanges {
+			args = append(args, "devMode")
+			s.AddDependencyInput(fmt.Sprintf("gitMapping %s devMode", gitMapping.GetFullName()), "true")
 		}
 	}
 
@@ -58,6 +68,19 @@ func (s *GitArchiveStage) GetDependencies(_ Conveyor, _, _ image.ImageInterface)
 	return util.Sha256Hash(args...), nil
 }
 
+// HasDevChanges returns true if git mappings of the stage include working tree changes of the dev mode
+func (s *GitArchiveStage) HasDevChanges() (bool, error) {
+	for _, gitMapping := range s.gitMappings {
+		if hasDevChanges, err := gitMapping.HasDevChanges(); err != nil {
+			return false, err
+		} else if hasDevChanges {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
 func (s *GitArchiveStage) PrepareImage(c Conveyor, prevBuiltImage, image image.ImageInterface) error {
 	if err := s.GitStage.PrepareImage(c, prevBuiltImage, image); err != nil {
 		return err
@@ -42,6 +42,8 @@ func (s *GitCacheStage) GetDependencies(_ Conveyor, _, prevBuiltImage image.Imag
 		return "", err
 	}
 
+	s.AddDependencyInput("gitCachePatchSizeStep", fmt.Sprintf("%d", patchSize/patchSizeStep))
+
 	return util.Sha256Hash(fmt.Sprintf("%d", patchSize/patchSizeStep)), nil
 }
 
@@ -53,7 +55,7 @@ func (s *GitCacheStage) gitMappingsPatchSize(prevBuiltImage image.ImageInterface
 			return 0, fmt.Errorf("invalid stage image: can not find git commit in stage image labels: delete stage image %s manually and retry the build", prevBuiltImage.Name())
 		}
 
-		exist, err := gitMapping.GitRepo().IsCommitExists(commit)
+		exist, err := gitMapping.IsCommitExists(commit)
 		if err != nil {
 			return 0, err
 		}
@@ -1,6 +1,8 @@
 package stage
 
 import (
+	"fmt"
+
 	"github.com/flant/werf/pkg/image"
 	"github.com/flant/werf/pkg/util"
 )
@@ -25,7 +27,7 @@ func (s *GitLatestPatchStage) IsEmpty(c Conveyor, prevBuiltImage image.ImageInte
 	isEmpty := true
 	for _, gitMapping := range s.gitMappings {
 		commit := gitMapping.GetGitCommitFromImageLabels(prevBuiltImage)
-		if exist, err := gitMapping.GitRepo().IsCommitExists(commit); err != nil {
+		if exist, err := gitMapping.IsCommitExists(commit); err != nil {
 			return false, err
 		} else if !exist {
 			return true, nil
@@ -52,6 +54,7 @@ func (s *GitLatestPatchStage) GetDependencies(_ Conveyor, _, _ image.ImageInterf
 		}
 
 		args = append(args, commit)
+		s.AddDependencyInput(fmt.Sprintf("gitMapping %s latestCommit", gitMapping.GetFullName()), commit)
 	}
 
 	return util.Sha256Hash(args...), nil
@@ -10,6 +10,7 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/flant/logboek"
 
@@ -19,10 +20,14 @@ import (
 	"github.com/flant/werf/pkg/util"
 )
 
+// GitRepoCache keeps patches, archives and checksums of the git repo used by the conveyor,
+// git_repo shares them between conveyors and projects through the host git data cache
 type GitRepoCache struct {
 	Patches   map[string]git_repo.Patch
 	Checksums map[string]git_repo.Checksum
 	Archives  map[string]git_repo.Archive
+
+	mutex sync.Mutex
 }
 
 func objectToHashKey(obj interface{}) string {
@@ -102,7 +107,19 @@ func (gp *GitMapping) GitRepo() git_repo.GitRepo {
 	panic("GitRepo not initialized")
 }
 
+// IsCommitExists fetches the commit missing in the shallow clone of the remote repo and checks the commit existence
+func (gp *GitMapping) IsCommitExists(commit string) (bool, error) {
+	if err := gp.GitRepo().FetchCommit(commit); err != nil {
+		return false, err
+	}
+
+	return gp.GitRepo().IsCommitExists(commit)
+}
+
 func (gp *GitMapping) getOrCreateChecksum(opts git_repo.ChecksumOptions) (git_repo.Checksum, error) {
+	gp.GitRepoCache.mutex.Lock()
+	defer gp.GitRepoCache.mutex.Unlock()
+
 	if _, hasKey := gp.GitRepoCache.Checksums[objectToHashKey(opts)]; !hasKey {
 		checksum, err := gp.GitRepo().Checksum(opts)
 		if err != nil {
@@ -114,6 +131,9 @@ func (gp *GitMapping) getOrCreateChecksum(opts git_repo.ChecksumOptions) (git_re
 }
 
 func (gp *GitMapping) getOrCreateArchive(opts git_repo.ArchiveOptions) (git_repo.Archive, error) {
+	gp.GitRepoCache.mutex.Lock()
+	defer gp.GitRepoCache.mutex.Unlock()
+
 	if _, hasKey := gp.GitRepoCache.Archives[objectToHashKey(opts)]; !hasKey {
 		archive, err := gp.createArchive(opts)
 		if err != nil {
@@ -146,6 +166,9 @@ func (gp *GitMapping) createArchive(opts git_repo.ArchiveOptions) (git_repo.Arch
 }
 
 func (gp *GitMapping) getOrCreatePatch(opts git_repo.PatchOptions) (git_repo.Patch, error) {
+	gp.GitRepoCache.mutex.Lock()
+	defer gp.GitRepoCache.mutex.Unlock()
+
 	if _, hasKey := gp.GitRepoCache.Patches[objectToHashKey(opts)]; !hasKey {
 		patch, err := gp.createPatch(opts)
 		if err != nil {
@@ -194,6 +217,16 @@ func (gp *GitMapping) IsLocal() bool {
 	}
 }
 
+// HasDevChanges returns true if the local git mapping includes working tree changes of the dev mode
+func (gp *GitMapping) HasDevChanges() (bool, error) {
+	localGitRepo, ok := gp.GitRepo().(*git_repo.Local)
+	if !ok {
+		return false, nil
+	}
+
+	return localGitRepo.HasDevChanges()
+}
+
 func (gp *GitMapping) LatestCommit() (string, error) {
 	if gp.Commit != "" {
 		return gp.Commit, nil
@@ -52,21 +52,39 @@ func (s *ImportsStage) GetDependencies(c Conveyor, _, _ imagePkg.ImageInterface)
 	var args []string
 
 	for _, elm := range s.imports {
+		var importArgs []string
+
 		if elm.ImageName != "" {
-			args = append(args, c.GetImageLatestStageSignature(elm.ImageName))
+			importArgs = append(importArgs, c.GetImageLatestStageSignature(elm.ImageName))
 		} else {
-			args = append(args, c.GetImageLatestStageSignature(elm.ArtifactName))
+			importArgs = append(importArgs, c.GetImageLatestStageSignature(elm.ArtifactName))
 		}
 
-		args = append(args, elm.Add, elm.To)
-		args = append(args, elm.Group, elm.Owner)
-		args = append(args, elm.IncludePaths...)
-		args = append(args, elm.ExcludePaths...)
+		importArgs = append(importArgs, elm.Add, elm.To)
+		importArgs = append(importArgs, elm.Group, elm.Owner)
+		importArgs = append(importArgs, elm.IncludePaths...)
+		importArgs = append(importArgs, elm.ExcludePaths...)
+
+		args = append(args, importArgs...)
+		s.AddDependencyInput(fmt.Sprintf("import %s%s", elm.ImageName, elm.ArtifactName), importArgs...)
 	}
 
 	return util.Sha256Hash(args...), nil
 }
 
+func (s *ImportsStage) ImportedImagesNames() []string {
+	var names []string
+	for _, elm := range s.imports {
+		if elm.ImageName != "" {
+			names = append(names, elm.ImageName)
+		} else {
+			names = append(names, elm.ArtifactName)
+		}
+	}
+
+	return names
+}
+
 func (s *ImportsStage) PrepareImage(c Conveyor, _, image imagePkg.ImageInterface) error {
 	for _, elm := range s.imports {
 		importContainerTmpPath := s.importContainerTmpPath(elm)
@@ -99,6 +117,10 @@ func (s *ImportsStage) PrepareImage(c Conveyor, _, image imagePkg.ImageInterface
 }
 
 func (s *ImportsStage) PreRunHook(c Conveyor) error {
+	if err := s.BaseStage.PreRunHook(c); err != nil {
+		return err
+	}
+
 	for _, elm := range s.imports {
 		if err := s.prepareImportData(c, elm); err != nil {
 			return err
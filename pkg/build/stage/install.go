@@ -32,7 +32,9 @@ func (s *InstallStage) GetDependencies(_ Conveyor, _, _ image.ImageInterface) (s
 		return "", err
 	}
 
-	return util.Sha256Hash(s.builder.InstallChecksum(), stageDependenciesChecksum), nil
+	s.addChecksumDependencyInputs(s.builder.InstallChecksumInputs())
+
+	return s.withSecretMountsChecksum(util.Sha256Hash(s.builder.InstallChecksum(), stageDependenciesChecksum)), nil
 }
 
 func (s *InstallStage) PrepareImage(c Conveyor, prevBuiltImage, image image.ImageInterface) error {
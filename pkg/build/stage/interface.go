@@ -11,10 +11,14 @@ type Interface interface {
 
 	GetDependencies(c Conveyor, prevImage image.ImageInterface, prevBuiltImage image.ImageInterface) (string, error)
 
+	AddDependencyInput(name string, values ...string)
+	GetDependencyInputs() []DependencyInput
+
 	PrepareImage(c Conveyor, prevBuiltImage, image image.ImageInterface) error
 
 	AfterImageSyncDockerStateHook(Conveyor) error
 	PreRunHook(Conveyor) error
+	PostRunHook(Conveyor) error
 
 	SetSignature(signature string)
 	GetSignature() string
@@ -32,7 +32,9 @@ func (s *SetupStage) GetDependencies(_ Conveyor, _, _ image.ImageInterface) (str
 		return "", err
 	}
 
-	return util.Sha256Hash(s.builder.SetupChecksum(), stageDependenciesChecksum), nil
+	s.addChecksumDependencyInputs(s.builder.SetupChecksumInputs())
+
+	return s.withSecretMountsChecksum(util.Sha256Hash(s.builder.SetupChecksum(), stageDependenciesChecksum)), nil
 }
 
 func (s *SetupStage) PrepareImage(c Conveyor, prevBuiltImage, image image.ImageInterface) error {
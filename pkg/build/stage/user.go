@@ -1,7 +1,10 @@
 package stage
 
 import (
+	"fmt"
 	"os"
+	"path"
+	"path/filepath"
 
 	"github.com/flant/logboek"
 
@@ -48,11 +51,42 @@ func (s *UserStage) getStageDependenciesChecksum(name StageName) (string, error)
 		}
 
 		args = append(args, checksum)
+		s.AddDependencyInput(fmt.Sprintf("gitMapping %s stageDependenciesChecksum", gitMapping.GetFullName()), checksum)
 	}
 
 	return util.Sha256Hash(args...), nil
 }
 
+// addChecksumDependencyInputs adds the user stage commands instead of their checksum to explain the stage changes
+func (s *UserStage) addChecksumDependencyInputs(inputs builder.ChecksumInputs) {
+	for _, command := range inputs.Commands {
+		s.AddDependencyInput("command", command)
+	}
+
+	if len(inputs.CacheVersions) != 0 {
+		s.AddDependencyInput("cacheVersions", inputs.CacheVersions...)
+	}
+}
+
+// withSecretMountsChecksum adds secret mounts declared for the stage to the stage checksum:
+// the signature depends on the secret id, source and mountpoint, not the secret content.
+// The checksum of the stage without secret mounts is not changed
+func (s *UserStage) withSecretMountsChecksum(checksum string) string {
+	mounts := s.getSecretMountsConfigs()
+	if len(mounts) == 0 {
+		return checksum
+	}
+
+	args := []string{checksum}
+	for _, mount := range mounts {
+		mountArgs := []string{mount.Id, filepath.ToSlash(mount.From), mount.Env, path.Clean(mount.To)}
+		args = append(args, mountArgs...)
+		s.AddDependencyInput("secretMount", mountArgs...)
+	}
+
+	return util.Sha256Hash(args...)
+}
+
 func debugUserStageChecksum() bool {
 	return os.Getenv("WERF_DEBUG_USER_STAGE_CHECKSUM") == "1"
 }
@@ -0,0 +1,174 @@
+package build
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+
+	"github.com/flant/werf/pkg/build/stage"
+	imagePkg "github.com/flant/werf/pkg/image"
+	"github.com/flant/werf/pkg/stages_storage"
+)
+
+// syncStageImage synchronizes local docker state of the stage image and
+// fetches the stage image by signature from the stages storage if it is not exist locally
+func (c *Conveyor) syncStageImage(signature string, img *imagePkg.StageImage, log *imageLog) error {
+	stageImageMutex := c.GetStageImageMutex(img.Name())
+	stageImageMutex.Lock()
+	defer stageImageMutex.Unlock()
+
+	if err := img.SyncDockerState(); err != nil {
+		return err
+	}
+
+	if img.IsExists() || !c.stagesStorage.IsDistributed() {
+		return nil
+	}
+
+	if stage, err := c.stagesStorage.GetStageBySignature(c.projectName(), signature); err != nil {
+		return fmt.Errorf("unable to get stage %s from stages storage %s: %s", signature, c.stagesStorage.String(), err)
+	} else if stage == nil {
+		return nil
+	}
+
+	if err := log.WithGlobalLog(func() error {
+		return c.stagesStorage.FetchStage(c.projectName(), signature, img)
+	}); err != nil {
+		return err
+	}
+
+	return img.SyncDockerState()
+}
+
+// lookupStageImage synchronizes local docker state of the stage image and, if the image is not exist locally,
+// describes the image by the stage from the stages storage without fetching
+func (c *Conveyor) lookupStageImage(signature string, img *imagePkg.StageImage) error {
+	stageImageMutex := c.GetStageImageMutex(img.Name())
+	stageImageMutex.Lock()
+	defer stageImageMutex.Unlock()
+
+	if err := img.SyncDockerState(); err != nil {
+		return err
+	}
+
+	if img.IsExists() || !c.stagesStorage.IsDistributed() {
+		return nil
+	}
+
+	stageDesc, err := c.stagesStorage.GetStageBySignature(c.projectName(), signature)
+	if err != nil {
+		return fmt.Errorf("unable to get stage %s from stages storage %s: %s", signature, c.stagesStorage.String(), err)
+	} else if stageDesc != nil {
+		img.SetInspect(stageDescriptionInspect(stageDesc))
+	}
+
+	return nil
+}
+
+// stageDescriptionInspect returns the image inspect with the id and labels of the stage from the stages storage
+func stageDescriptionInspect(stageDesc *stages_storage.StageDescription) *types.ImageInspect {
+	return &types.ImageInspect{
+		ID:      stageDesc.ImageId,
+		Parent:  stageDesc.ParentId,
+		Created: stageDesc.Created.Format(time.RFC3339Nano),
+		Config:  &container.Config{Labels: stageDesc.Labels},
+	}
+}
+
+// syncBaseImage synchronizes local docker state of the base image, which can be shared by several images
+func (c *Conveyor) syncBaseImage(img *imagePkg.StageImage) error {
+	stageImageMutex := c.GetStageImageMutex(img.Name())
+	stageImageMutex.Lock()
+	defer stageImageMutex.Unlock()
+
+	return img.SyncDockerState()
+}
+
+// dockerfileStageCacheFromImages returns the last built stage image of the same werf image from the stages storage.
+// The stage image built by BuildKit contains inline build cache, which can be used for the current build
+func (c *Conveyor) dockerfileStageCacheFromImages(image *Image, s stage.Interface) ([]string, error) {
+	stages, err := c.getStagesList()
+	if err != nil {
+		return nil, err
+	}
+
+	var lastStage *stages_storage.StageDescription
+	for _, stageDesc := range stages {
+		if stageDesc.Labels[imagePkg.WerfStageImageNameLabel] != image.GetName() || stageDesc.Labels[imagePkg.WerfStageNameLabel] != string(s.Name()) {
+			continue
+		}
+
+		if lastStage == nil || stageDesc.Created.After(lastStage.Created) {
+			lastStage = stageDesc
+		}
+	}
+
+	if lastStage == nil {
+		return nil, nil
+	}
+
+	return []string{lastStage.ImageName}, nil
+}
+
+// getStagesList returns the stages list of the stages storage, the list is requested once per build
+// and includes the stages stored by the build
+func (c *Conveyor) getStagesList() ([]*stages_storage.StageDescription, error) {
+	c.stagesListMutex.Lock()
+	defer c.stagesListMutex.Unlock()
+
+	if !c.isStagesListFetched {
+		c.stagesList, c.stagesListErr = c.stagesStorage.GetStagesList(c.projectName())
+		if c.stagesListErr != nil {
+			c.stagesListErr = fmt.Errorf("unable to get stages list from stages storage %s: %s", c.stagesStorage.String(), c.stagesListErr)
+		}
+
+		c.isStagesListFetched = true
+	}
+
+	return append([]*stages_storage.StageDescription{}, c.stagesList...), c.stagesListErr
+}
+
+// storeStageImage stores the stage image into the stages storage, the stages storage logs the process to the global logger
+func (c *Conveyor) storeStageImage(signature string, img *imagePkg.StageImage, log *imageLog) error {
+	if err := log.WithGlobalLog(func() error {
+		return c.stagesStorage.StoreStage(c.projectName(), signature, img)
+	}); err != nil {
+		return err
+	}
+
+	return c.addStoredStageToStagesList(signature)
+}
+
+// addStoredStageToStagesList adds the stored stage to the fetched stages list,
+// so the stage is visible to the images processed later by the build
+func (c *Conveyor) addStoredStageToStagesList(signature string) error {
+	c.stagesListMutex.Lock()
+	isStagesListFetched := c.isStagesListFetched
+	c.stagesListMutex.Unlock()
+
+	if !isStagesListFetched {
+		return nil
+	}
+
+	stageDesc, err := c.stagesStorage.GetStageBySignature(c.projectName(), signature)
+	if err != nil {
+		return fmt.Errorf("unable to get stage %s from stages storage %s: %s", signature, c.stagesStorage.String(), err)
+	} else if stageDesc == nil {
+		return nil
+	}
+
+	c.stagesListMutex.Lock()
+	defer c.stagesListMutex.Unlock()
+
+	for _, desc := range c.stagesList {
+		if desc.Signature == stageDesc.Signature {
+			return nil
+		}
+	}
+
+	c.stagesList = append(c.stagesList, stageDesc)
+
+	return nil
+}
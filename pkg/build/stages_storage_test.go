@@ -0,0 +1,98 @@
+package build
+
+import (
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/werf/pkg/build/stage"
+	"github.com/flant/werf/pkg/config"
+	imagePkg "github.com/flant/werf/pkg/image"
+	"github.com/flant/werf/pkg/stages_storage"
+)
+
+// memoryStagesStorage keeps stages descriptions in memory
+type memoryStagesStorage struct {
+	stages             map[string]*stages_storage.StageDescription
+	getStagesListCalls int
+}
+
+func (s *memoryStagesStorage) String() string {
+	return "memory"
+}
+
+func (s *memoryStagesStorage) IsDistributed() bool {
+	return true
+}
+
+func (s *memoryStagesStorage) ConstructStageImageName(projectName, signature string) string {
+	return projectName + ":" + signature
+}
+
+func (s *memoryStagesStorage) GetStageBySignature(_, signature string) (*stages_storage.StageDescription, error) {
+	return s.stages[signature], nil
+}
+
+func (s *memoryStagesStorage) FetchStage(_, _ string, _ *imagePkg.StageImage) error {
+	return nil
+}
+
+func (s *memoryStagesStorage) StoreStage(_, signature string, img *imagePkg.StageImage) error {
+	s.stages[signature] = &stages_storage.StageDescription{
+		Signature: signature,
+		ImageName: img.Name(),
+		ImageId:   img.Inspect().ID,
+		Labels:    img.Inspect().Config.Labels,
+		Created:   time.Now(),
+	}
+
+	return nil
+}
+
+func (s *memoryStagesStorage) GetStagesList(_ string) ([]*stages_storage.StageDescription, error) {
+	s.getStagesListCalls++
+
+	var res []*stages_storage.StageDescription
+	for _, stageDesc := range s.stages {
+		res = append(res, stageDesc)
+	}
+
+	return res, nil
+}
+
+func (s *memoryStagesStorage) DeleteStages(_ stages_storage.DeleteStagesOptions, _ ...*stages_storage.StageDescription) error {
+	return nil
+}
+
+var _ = Describe("stages list", func() {
+	It("includes the stage stored after the list is fetched", func() {
+		stagesStorage := &memoryStagesStorage{stages: map[string]*stages_storage.StageDescription{}}
+		c := &Conveyor{conveyorPermanentFields: &conveyorPermanentFields{
+			werfConfig:    &config.WerfConfig{Meta: &config.Meta{Project: "project"}},
+			stagesStorage: stagesStorage,
+		}}
+
+		s := stage.GenerateDockerfileStage("Dockerfile", "", ".", nil, nil, nil, nil, nil, nil, nil, 0, &stage.NewBaseStageOptions{})
+
+		// the list is fetched by the first image
+		Ω(c.dockerfileStageCacheFromImages(&Image{name: "frontend"}, s)).Should(BeEmpty())
+
+		// the stage of the other image is built and stored
+		img := imagePkg.NewStageImage(nil, "project:sig")
+		img.SetInspect(&types.ImageInspect{ID: "sha256:1", Config: &container.Config{Labels: map[string]string{
+			imagePkg.WerfStageImageNameLabel: "backend",
+			imagePkg.WerfStageNameLabel:      string(s.Name()),
+		}}})
+		Ω(c.storeStageImage("sig", img, newImageLog(false))).Should(Succeed())
+
+		Ω(c.dockerfileStageCacheFromImages(&Image{name: "backend"}, s)).Should(Equal([]string{"project:sig"}))
+		Ω(stagesStorage.getStagesListCalls).Should(Equal(1))
+
+		// the stored stage is added once
+		Ω(c.storeStageImage("sig", img, newImageLog(false))).Should(Succeed())
+		Ω(c.getStagesList()).Should(HaveLen(1))
+	})
+})
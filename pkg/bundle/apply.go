@@ -0,0 +1,133 @@
+package bundle
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/flant/logboek"
+
+	"github.com/flant/werf/pkg/deploy"
+	"github.com/flant/werf/pkg/deploy/werf_chart"
+	"github.com/flant/werf/pkg/docker"
+	"github.com/flant/werf/pkg/docker_registry"
+	"github.com/flant/werf/pkg/werf"
+)
+
+type ApplyOptions struct {
+	Release                     string
+	Namespace                   string
+	HelmReleaseStorageNamespace string
+	HelmReleaseStorageType      string
+
+	DeployOptions deploy.DeployOptions
+}
+
+// Apply pushes bundled images into the images repo and deploys the bundled chart.
+// Release, namespace and env of the export are used by default
+func Apply(bundlePath string, imagesRepoManager deploy.ImagesRepoManager, opts ApplyOptions) error {
+	bundleDir, err := ioutil.TempDir(werf.GetTmpDir(), "werf-bundle-")
+	if err != nil {
+		return fmt.Errorf("unable to create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(bundleDir)
+
+	if err := logboek.LogProcess(fmt.Sprintf("Extracting bundle %s", bundlePath), logboek.LogProcessOptions{}, func() error {
+		return extractArchive(bundlePath, bundleDir)
+	}); err != nil {
+		return err
+	}
+
+	manifest, err := readManifest(bundleDir)
+	if err != nil {
+		return err
+	}
+
+	release := opts.Release
+	if release == "" {
+		release = manifest.Release
+	}
+
+	namespace := opts.Namespace
+	if namespace == "" {
+		namespace = manifest.Namespace
+	}
+
+	deployOptions := opts.DeployOptions
+	if deployOptions.Env == "" {
+		deployOptions.Env = manifest.Env
+	}
+
+	serviceValues, err := readServiceValues(bundleDir)
+	if err != nil {
+		return err
+	}
+
+	if len(manifest.Images) != 0 {
+		if err := logboek.LogProcess("Loading images", logboek.LogProcessOptions{}, func() error {
+			return docker.CliLoad("--quiet", "--input", filepath.Join(bundleDir, ImagesArchiveFileName))
+		}); err != nil {
+			return fmt.Errorf("unable to load images: %s", err)
+		}
+
+		for _, img := range manifest.Images {
+			imageName := imagesRepoManager.ImageRepoWithTag(img.Name, img.Tag)
+
+			if err := logboek.LogProcess(fmt.Sprintf("Pushing image %s", imageName), logboek.LogProcessOptions{}, func() error {
+				return pushImage(img.ImageName, imageName)
+			}); err != nil {
+				return err
+			}
+
+			// the digest depends on the target registry layers compression
+			digest, err := docker_registry.ImageDigest(imageName)
+			if err != nil {
+				return fmt.Errorf("unable to get image %s digest: %s", imageName, err)
+			}
+
+			setImageServiceValues(serviceValues, img.Name, imageName, img.ImageId, digest)
+		}
+
+		valuesMap(valuesMap(serviceValues, "global"), "werf")["repo"] = imagesRepoManager.ImagesRepo()
+	}
+
+	globalInfo := valuesMap(serviceValues, "global")
+	globalInfo["namespace"] = namespace
+	if deployOptions.Env != "" {
+		globalInfo["env"] = deployOptions.Env
+	}
+
+	var bundleSecretValues []string
+	for _, path := range manifest.SecretValues {
+		bundleSecretValues = append(bundleSecretValues, filepath.Join(bundleDir, filepath.FromSlash(path)))
+	}
+	deployOptions.SecretValues = append(bundleSecretValues, deployOptions.SecretValues...)
+
+	var bundleValues []string
+	for _, path := range manifest.Values {
+		bundleValues = append(bundleValues, filepath.Join(bundleDir, filepath.FromSlash(path)))
+	}
+	deployOptions.Values = append(bundleValues, deployOptions.Values...)
+
+	deployOptions.Set = append(append([]string{}, manifest.Set...), deployOptions.Set...)
+	deployOptions.SetString = append(append([]string{}, manifest.SetString...), deployOptions.SetString...)
+
+	m, err := deploy.GetSafeSecretManager(bundleDir, deployOptions.SecretValues, deployOptions.IgnoreSecretKey)
+	if err != nil {
+		return err
+	}
+
+	chartDir := filepath.Join(bundleDir, werf_chart.ProjectHelmChartDirName)
+	return deploy.DeployChart(manifest.Project, chartDir, m, serviceValues, release, namespace, opts.HelmReleaseStorageNamespace, opts.HelmReleaseStorageType, deployOptions)
+}
+
+func pushImage(bundleImageName, imageName string) error {
+	if bundleImageName != imageName {
+		if err := docker.CliTag(bundleImageName, imageName); err != nil {
+			return err
+		}
+	}
+
+	return docker.CliPushWithRetries(imageName)
+}
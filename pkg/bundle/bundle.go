@@ -0,0 +1,259 @@
+package bundle
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	ManifestVersion = "1"
+
+	ManifestFileName      = "manifest.json"
+	ImagesArchiveFileName = "images.tar"
+	ServiceValuesFileName = "service-values.yaml"
+	ValuesDirName         = "values"
+	SecretValuesDirName   = "secret-values"
+)
+
+// Manifest describes the bundle content, paths are relative to the bundle root
+type Manifest struct {
+	Version     string    `json:"version"`
+	WerfVersion string    `json:"werfVersion"`
+	Created     time.Time `json:"created"`
+
+	Project   string `json:"project"`
+	Env       string `json:"env,omitempty"`
+	Release   string `json:"release"`
+	Namespace string `json:"namespace"`
+
+	Images []*ImageManifest `json:"images"`
+
+	Values       []string `json:"values,omitempty"`
+	SecretValues []string `json:"secretValues,omitempty"`
+	Set          []string `json:"set,omitempty"`
+	SetString    []string `json:"setString,omitempty"`
+}
+
+// ImageManifest describes the bundled image: the image is exported by digest and saved with the original name
+type ImageManifest struct {
+	Name      string `json:"name"`
+	ImageName string `json:"imageName"`
+	Tag       string `json:"tag"`
+	Digest    string `json:"digest"`
+	ImageId   string `json:"imageId"`
+}
+
+// ReadManifest reads the manifest from the bundle tarball without extracting the bundle
+func ReadManifest(bundlePath string) (*Manifest, error) {
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %s", bundlePath, err)
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("bad bundle %s: %s not found", bundlePath, ManifestFileName)
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %s", bundlePath, err)
+		}
+
+		if path.Clean(header.Name) != ManifestFileName {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %s", bundlePath, err)
+		}
+
+		return parseManifest(data)
+	}
+}
+
+func readManifest(bundleDir string) (*Manifest, error) {
+	path := filepath.Join(bundleDir, ManifestFileName)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read bundle manifest %s: %s", path, err)
+	}
+
+	return parseManifest(data)
+}
+
+func parseManifest(data []byte) (*Manifest, error) {
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal bundle manifest: %s", err)
+	}
+
+	if manifest.Version != ManifestVersion {
+		return nil, fmt.Errorf("unsupported bundle manifest version %q: expected %q", manifest.Version, ManifestVersion)
+	}
+
+	return manifest, nil
+}
+
+func writeManifest(bundleDir string, manifest *Manifest) error {
+	path := filepath.Join(bundleDir, ManifestFileName)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("unable to write bundle manifest %s: %s", path, err)
+	}
+
+	return nil
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create dir %s: %s", filepath.Dir(dst), err)
+	}
+
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %s", src, err)
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %s", dst, err)
+	}
+	defer dstFile.Close()
+
+	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		return fmt.Errorf("unable to copy %s to %s: %s", src, dst, err)
+	}
+
+	return dstFile.Close()
+}
+
+// archiveDir writes the bundle dir into the tarball
+func archiveDir(dir, archivePath string) error {
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %s", archivePath, err)
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+
+	if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("error accessing file %s: %s", path, err)
+		}
+
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		if relPath == "." {
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(tw, file)
+		return err
+	}); err != nil {
+		return fmt.Errorf("unable to write %s: %s", archivePath, err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("unable to write %s: %s", archivePath, err)
+	}
+
+	return f.Close()
+}
+
+// extractArchive extracts the bundle tarball into the dir
+func extractArchive(archivePath, dir string) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %s", archivePath, err)
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("unable to read %s: %s", archivePath, err)
+		}
+
+		path := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if path == filepath.Clean(dir) && header.Typeflag == tar.TypeDir {
+			continue
+		} else if !strings.HasPrefix(path, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("bad bundle %s: illegal file path %s", archivePath, header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, os.ModePerm); err != nil {
+				return fmt.Errorf("unable to create dir %s: %s", path, err)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+				return fmt.Errorf("unable to create dir %s: %s", filepath.Dir(path), err)
+			}
+
+			if err := extractFile(tr, path, os.FileMode(header.Mode).Perm()); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("bad bundle %s: unsupported file %s", archivePath, header.Name)
+		}
+	}
+}
+
+func extractFile(r io.Reader, path string, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %s", path, err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("unable to write %s: %s", path, err)
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,188 @@
+package bundle
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func writeTestArchive(archivePath string, headers ...*tar.Header) {
+	f, err := os.Create(archivePath)
+	Ω(err).ShouldNot(HaveOccurred())
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	for _, header := range headers {
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(header.Name))
+		}
+
+		Ω(tw.WriteHeader(header)).Should(Succeed())
+
+		if header.Typeflag == tar.TypeReg {
+			_, err := tw.Write([]byte(header.Name))
+			Ω(err).ShouldNot(HaveOccurred())
+		}
+	}
+
+	Ω(tw.Close()).Should(Succeed())
+}
+
+var _ = Describe("bundle", func() {
+	var tmpDir string
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "werf-bundle-test")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Ω(os.RemoveAll(tmpDir)).Should(Succeed())
+	})
+
+	Context("manifest", func() {
+		It("parses the manifest of the supported version", func() {
+			manifest, err := parseManifest([]byte(`{"version": "1", "project": "app", "release": "app-production", "namespace": "app-production", "images": [{"name": "backend", "tag": "v1.0.0"}]}`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(manifest.Project).Should(Equal("app"))
+			Ω(manifest.Images).Should(HaveLen(1))
+			Ω(manifest.Images[0].Name).Should(Equal("backend"))
+		})
+
+		DescribeTable("fails on the bad manifest",
+			func(data, expectedError string) {
+				_, err := parseManifest([]byte(data))
+				Ω(err).Should(HaveOccurred())
+				Ω(err.Error()).Should(ContainSubstring(expectedError))
+			},
+			Entry("unsupported version", `{"version": "2"}`, `unsupported bundle manifest version "2"`),
+			Entry("no version", `{"project": "app"}`, `unsupported bundle manifest version ""`),
+			Entry("broken json", `{"version": `, "unable to unmarshal bundle manifest"),
+		)
+
+		It("reads the manifest written into the bundle", func() {
+			bundleDir := filepath.Join(tmpDir, "bundle")
+			Ω(os.MkdirAll(filepath.Join(bundleDir, "values"), os.ModePerm)).Should(Succeed())
+			Ω(ioutil.WriteFile(filepath.Join(bundleDir, "values", "0-values.yaml"), []byte("key: value\n"), 0644)).Should(Succeed())
+			Ω(writeManifest(bundleDir, &Manifest{Version: ManifestVersion, Project: "app", Values: []string{"values/0-values.yaml"}})).Should(Succeed())
+
+			bundlePath := filepath.Join(tmpDir, "bundle.tar")
+			Ω(archiveDir(bundleDir, bundlePath)).Should(Succeed())
+
+			manifest, err := ReadManifest(bundlePath)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(manifest.Project).Should(Equal("app"))
+
+			extractDir := filepath.Join(tmpDir, "extract")
+			Ω(extractArchive(bundlePath, extractDir)).Should(Succeed())
+			Ω(ioutil.ReadFile(filepath.Join(extractDir, "values", "0-values.yaml"))).Should(Equal([]byte("key: value\n")))
+			Ω(readManifest(extractDir)).Should(Equal(manifest))
+		})
+	})
+
+	Context("extractArchive", func() {
+		It("extracts dirs and files", func() {
+			archivePath := filepath.Join(tmpDir, "bundle.tar")
+			writeTestArchive(archivePath,
+				&tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0755},
+				&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755},
+				&tar.Header{Name: "dir/file", Typeflag: tar.TypeReg, Mode: 0644},
+				&tar.Header{Name: "other/file", Typeflag: tar.TypeReg, Mode: 0644},
+			)
+
+			dir := filepath.Join(tmpDir, "extract")
+			Ω(extractArchive(archivePath, dir)).Should(Succeed())
+			Ω(ioutil.ReadFile(filepath.Join(dir, "dir", "file"))).Should(Equal([]byte("dir/file")))
+			Ω(ioutil.ReadFile(filepath.Join(dir, "other", "file"))).Should(Equal([]byte("other/file")))
+		})
+
+		DescribeTable("does not write files outside the dir",
+			func(header *tar.Header, expectedError string) {
+				archivePath := filepath.Join(tmpDir, "bundle.tar")
+				writeTestArchive(archivePath, header)
+
+				dir := filepath.Join(tmpDir, "extract")
+				err := extractArchive(archivePath, dir)
+				Ω(err).Should(HaveOccurred())
+				Ω(err.Error()).Should(ContainSubstring(expectedError))
+
+				Ω(filepath.Join(tmpDir, "evil")).ShouldNot(BeAnExistingFile())
+			},
+			Entry("parent dir", &tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644}, "illegal file path ../evil"),
+			Entry("nested parent dir", &tar.Header{Name: "dir/../../evil", Typeflag: tar.TypeReg, Mode: 0644}, "illegal file path dir/../../evil"),
+			Entry("dir itself as file", &tar.Header{Name: ".", Typeflag: tar.TypeReg, Mode: 0644}, "illegal file path ."),
+			Entry("symlink", &tar.Header{Name: "evil", Linkname: "../evil", Typeflag: tar.TypeSymlink}, "unsupported file evil"),
+			Entry("hardlink", &tar.Header{Name: "evil", Linkname: "/etc/passwd", Typeflag: tar.TypeLink}, "unsupported file evil"),
+		)
+	})
+
+	Context("setImageServiceValues", func() {
+		It("sets values of the named image and keeps other values", func() {
+			serviceValues := map[string]interface{}{
+				"global": map[string]interface{}{
+					"env": "production",
+					"werf": map[string]interface{}{
+						"repo": "registry.example.com/app",
+						"image": map[string]interface{}{
+							"backend": map[string]interface{}{"docker_image": "registry.example.com/app/backend:v1.0.0", "docker_image_id": "sha256:old"},
+						},
+					},
+				},
+			}
+
+			setImageServiceValues(serviceValues, "backend", "registry.local/app/backend:v1.0.0", "sha256:id", "sha256:digest")
+			setImageServiceValues(serviceValues, "frontend", "registry.local/app/frontend:v1.0.0", "sha256:id2", "sha256:digest2")
+
+			Ω(serviceValues).Should(Equal(map[string]interface{}{
+				"global": map[string]interface{}{
+					"env": "production",
+					"werf": map[string]interface{}{
+						"repo": "registry.example.com/app",
+						"image": map[string]interface{}{
+							"backend":  map[string]interface{}{"docker_image": "registry.local/app/backend:v1.0.0", "docker_image_id": "sha256:id", "docker_image_digest": "sha256:digest"},
+							"frontend": map[string]interface{}{"docker_image": "registry.local/app/frontend:v1.0.0", "docker_image_id": "sha256:id2", "docker_image_digest": "sha256:digest2"},
+						},
+					},
+				},
+			}))
+		})
+
+		It("sets values of the nameless image", func() {
+			serviceValues := map[string]interface{}{}
+			setImageServiceValues(serviceValues, "", "registry.local/app:v1.0.0", "sha256:id", "sha256:digest")
+
+			Ω(serviceValues).Should(Equal(map[string]interface{}{
+				"global": map[string]interface{}{
+					"werf": map[string]interface{}{
+						"image": map[string]interface{}{"docker_image": "registry.local/app:v1.0.0", "docker_image_id": "sha256:id", "docker_image_digest": "sha256:digest"},
+					},
+				},
+			}))
+		})
+
+		It("keeps the values through the service values file", func() {
+			bundleDir := filepath.Join(tmpDir, "bundle")
+			Ω(os.MkdirAll(bundleDir, os.ModePerm)).Should(Succeed())
+
+			serviceValues := map[string]interface{}{}
+			setImageServiceValues(serviceValues, "backend", "registry.example.com/app/backend:v1.0.0", "sha256:id", "sha256:digest")
+			Ω(writeServiceValues(bundleDir, serviceValues)).Should(Succeed())
+
+			readValues, err := readServiceValues(bundleDir)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			setImageServiceValues(readValues, "backend", "registry.local/app/backend:v1.0.0", "sha256:id", "sha256:new-digest")
+			Ω(valuesMap(valuesMap(valuesMap(valuesMap(readValues, "global"), "werf"), "image"), "backend")).Should(Equal(map[string]interface{}{
+				"docker_image":        "registry.local/app/backend:v1.0.0",
+				"docker_image_id":     "sha256:id",
+				"docker_image_digest": "sha256:new-digest",
+			}))
+		})
+	})
+})
@@ -0,0 +1,51 @@
+package bundle
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+
+	"github.com/flant/werf/pkg/deploy/helm"
+)
+
+// renderChart loads the project chart the same way as werf deploy does and writes it into the bundle chart dir:
+// .helmignore rules are applied, symlinks are resolved and subcharts are packed,
+// so the bundled chart contains exactly the files, which are deployed, and does not depend on the project dir.
+// Chart.yaml is not written, werf generates chart metadata on apply
+func renderChart(projectName, chartDir, bundleChartDir string) error {
+	var c *chart.Chart
+	if err := chartutil.WithSkipChartYamlFileValidation(true, func() error {
+		var err error
+		c, err = chartutil.Load(chartDir)
+		return err
+	}); err != nil {
+		return fmt.Errorf("unable to load chart %s: %s", chartDir, err)
+	}
+
+	c.Metadata = &chart.Metadata{
+		Name:    projectName,
+		Version: "0.1.0",
+		Engine:  helm.WerfTemplateEngineName,
+	}
+
+	tmpDir, err := ioutil.TempDir(filepath.Dir(bundleChartDir), "chart-")
+	if err != nil {
+		return fmt.Errorf("unable to create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := chartutil.SaveDir(c, tmpDir); err != nil {
+		return fmt.Errorf("unable to write chart %s: %s", chartDir, err)
+	}
+
+	savedChartDir := filepath.Join(tmpDir, c.Metadata.Name)
+	if err := os.Remove(filepath.Join(savedChartDir, chartutil.ChartfileName)); err != nil {
+		return fmt.Errorf("unable to remove %s: %s", chartutil.ChartfileName, err)
+	}
+
+	return os.Rename(savedChartDir, bundleChartDir)
+}
@@ -0,0 +1,102 @@
+package bundle
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/helm/pkg/chartutil"
+)
+
+var _ = Describe("renderChart", func() {
+	var tmpDir, chartDir, bundleChartDir string
+
+	writeFile := func(path, content string) {
+		path = filepath.Join(chartDir, path)
+		Ω(os.MkdirAll(filepath.Dir(path), os.ModePerm)).Should(Succeed())
+		Ω(ioutil.WriteFile(path, []byte(content), 0644)).Should(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "werf-bundle-chart-test")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		chartDir = filepath.Join(tmpDir, "project", ".helm")
+		bundleChartDir = filepath.Join(tmpDir, "bundle", ".helm")
+		Ω(os.MkdirAll(filepath.Dir(bundleChartDir), os.ModePerm)).Should(Succeed())
+
+		writeFile("values.yaml", "replicas: 1\n")
+		writeFile("secret-values.yaml", "encrypted\n")
+		writeFile("secret/cert.pem", "encrypted\n")
+		writeFile("templates/deployment.yaml", "kind: Deployment\n")
+		writeFile("templates/_helpers.tpl", "{{- define \"app\" }}{{- end }}\n")
+		writeFile("charts/redis/Chart.yaml", "name: redis\nversion: 1.0.0\n")
+		writeFile("charts/redis/templates/statefulset.yaml", "kind: StatefulSet\n")
+		writeFile(".helmignore", "*.bak\n")
+		writeFile("templates/deployment.yaml.bak", "kind: Deployment\n")
+	})
+
+	AfterEach(func() {
+		Ω(os.RemoveAll(tmpDir)).Should(Succeed())
+	})
+
+	It("writes the chart files, which are deployed", func() {
+		shared := filepath.Join(tmpDir, "shared", "configmap.yaml")
+		Ω(os.MkdirAll(filepath.Dir(shared), os.ModePerm)).Should(Succeed())
+		Ω(ioutil.WriteFile(shared, []byte("kind: ConfigMap\n"), 0644)).Should(Succeed())
+		Ω(os.Symlink(shared, filepath.Join(chartDir, "templates", "configmap.yaml"))).Should(Succeed())
+
+		Ω(renderChart("app", chartDir, bundleChartDir)).Should(Succeed())
+
+		for path, content := range map[string]string{
+			"values.yaml":               "replicas: 1\n",
+			"secret-values.yaml":        "encrypted\n",
+			"secret/cert.pem":           "encrypted\n",
+			"templates/deployment.yaml": "kind: Deployment\n",
+			"templates/configmap.yaml":  "kind: ConfigMap\n",
+		} {
+			Ω(ioutil.ReadFile(filepath.Join(bundleChartDir, path))).Should(Equal([]byte(content)), path)
+		}
+
+		info, err := os.Lstat(filepath.Join(bundleChartDir, "templates", "configmap.yaml"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(info.Mode().IsRegular()).Should(BeTrue())
+
+		Ω(filepath.Join(bundleChartDir, "templates", "deployment.yaml.bak")).ShouldNot(BeAnExistingFile())
+		Ω(filepath.Join(bundleChartDir, "Chart.yaml")).ShouldNot(BeAnExistingFile())
+		Ω(filepath.Join(bundleChartDir, "charts", "redis-1.0.0.tgz")).Should(BeAnExistingFile())
+	})
+
+	It("writes the chart, which is loaded as the project chart", func() {
+		Ω(renderChart("app", chartDir, bundleChartDir)).Should(Succeed())
+		Ω(ioutil.ReadDir(filepath.Dir(bundleChartDir))).Should(HaveLen(1))
+
+		var templates []string
+		Ω(chartutil.WithSkipChartYamlFileValidation(true, func() error {
+			c, err := chartutil.Load(bundleChartDir)
+			if err != nil {
+				return err
+			}
+
+			for _, t := range c.Templates {
+				templates = append(templates, t.Name)
+			}
+
+			Ω(c.Dependencies).Should(HaveLen(1))
+			Ω(c.Dependencies[0].Metadata.Name).Should(Equal("redis"))
+
+			return nil
+		})).Should(Succeed())
+
+		Ω(templates).Should(ConsistOf("templates/deployment.yaml", "templates/_helpers.tpl"))
+	})
+
+	It("fails when the chart cannot be loaded", func() {
+		err := renderChart("app", filepath.Join(tmpDir, "missing"), bundleChartDir)
+		Ω(err).Should(HaveOccurred())
+		Ω(err.Error()).Should(ContainSubstring("unable to load chart"))
+	})
+})
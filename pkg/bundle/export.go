@@ -0,0 +1,247 @@
+package bundle
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/flant/logboek"
+
+	"github.com/flant/werf/pkg/config"
+	"github.com/flant/werf/pkg/deploy"
+	"github.com/flant/werf/pkg/deploy/werf_chart"
+	"github.com/flant/werf/pkg/docker"
+	"github.com/flant/werf/pkg/docker_registry"
+	"github.com/flant/werf/pkg/tag_strategy"
+	"github.com/flant/werf/pkg/util"
+	"github.com/flant/werf/pkg/werf"
+)
+
+type ExportOptions struct {
+	OutputPath string
+
+	Env          string
+	Values       []string
+	SecretValues []string
+	Set          []string
+	SetString    []string
+}
+
+// Export packs published images, the project chart and values into the bundle tarball.
+// Images are pulled by digest, so the bundle contains exactly the images which have been published by the tag
+func Export(projectDir string, werfConfig *config.WerfConfig, imagesRepoManager deploy.ImagesRepoManager, release, namespace, tag string, tagStrategy tag_strategy.TagStrategy, opts ExportOptions) error {
+	projectChartDir := filepath.Join(projectDir, werf_chart.ProjectHelmChartDirName)
+	if _, err := os.Stat(projectChartDir); err != nil {
+		return fmt.Errorf("unable to access chart dir %s: %s", projectChartDir, err)
+	}
+
+	bundleDir, err := ioutil.TempDir(werf.GetTmpDir(), "werf-bundle-")
+	if err != nil {
+		return fmt.Errorf("unable to create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(bundleDir)
+
+	manifest := &Manifest{
+		Version:     ManifestVersion,
+		WerfVersion: werf.Version,
+		Created:     time.Now().UTC(),
+		Project:     werfConfig.Meta.Project,
+		Env:         opts.Env,
+		Release:     release,
+		Namespace:   namespace,
+		Set:         opts.Set,
+		SetString:   opts.SetString,
+	}
+
+	var imagesNames []string
+	for _, name := range imagesNamesFromConfig(werfConfig) {
+		imageName := imagesRepoManager.ImageRepoWithTag(name, tag)
+
+		var img *ImageManifest
+		if err := logboek.LogProcess(fmt.Sprintf("Pulling image %s", imageName), logboek.LogProcessOptions{}, func() error {
+			var err error
+			img, err = pullImage(name, imageName, imagesRepoManager.ImageRepo(name), tag)
+			return err
+		}); err != nil {
+			return err
+		}
+
+		manifest.Images = append(manifest.Images, img)
+		imagesNames = append(imagesNames, imageName)
+	}
+
+	if len(imagesNames) != 0 {
+		if err := logboek.LogProcess("Saving images", logboek.LogProcessOptions{}, func() error {
+			return docker.CliSave(append([]string{"--output", filepath.Join(bundleDir, ImagesArchiveFileName)}, imagesNames...)...)
+		}); err != nil {
+			return fmt.Errorf("unable to save images: %s", err)
+		}
+	}
+
+	if err := logboek.LogProcess("Rendering chart", logboek.LogProcessOptions{}, func() error {
+		return renderChart(werfConfig.Meta.Project, projectChartDir, filepath.Join(bundleDir, werf_chart.ProjectHelmChartDirName))
+	}); err != nil {
+		return err
+	}
+
+	manifest.Values, err = copyValuesFiles(opts.Values, bundleDir, ValuesDirName)
+	if err != nil {
+		return err
+	}
+
+	manifest.SecretValues, err = copyValuesFiles(opts.SecretValues, bundleDir, SecretValuesDirName)
+	if err != nil {
+		return err
+	}
+
+	images := deploy.GetImagesInfoGetters(werfConfig.StapelImages, werfConfig.ImagesFromDockerfile, imagesRepoManager, tag, true)
+	serviceValues, err := deploy.GetServiceValues(werfConfig.Meta.Project, imagesRepoManager, namespace, tag, tagStrategy, images, deploy.ServiceValuesOptions{Env: opts.Env})
+	if err != nil {
+		return fmt.Errorf("error creating service values: %s", err)
+	}
+
+	for _, img := range manifest.Images {
+		setImageServiceValues(serviceValues, img.Name, img.ImageName, img.ImageId, img.Digest)
+	}
+
+	if err := writeServiceValues(bundleDir, serviceValues); err != nil {
+		return err
+	}
+
+	if err := writeManifest(bundleDir, manifest); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.OutputPath), os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create dir %s: %s", filepath.Dir(opts.OutputPath), err)
+	}
+
+	tmpOutputPath := fmt.Sprintf("%s.%s", opts.OutputPath, util.GenerateConsistentRandomString(5))
+	defer os.Remove(tmpOutputPath)
+
+	if err := logboek.LogProcess(fmt.Sprintf("Writing bundle %s", opts.OutputPath), logboek.LogProcessOptions{}, func() error {
+		return archiveDir(bundleDir, tmpOutputPath)
+	}); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpOutputPath, opts.OutputPath)
+}
+
+func imagesNamesFromConfig(werfConfig *config.WerfConfig) []string {
+	var names []string
+	for _, img := range werfConfig.StapelImages {
+		names = append(names, img.Name)
+	}
+
+	for _, img := range werfConfig.ImagesFromDockerfile {
+		names = append(names, img.Name)
+	}
+
+	return names
+}
+
+// pullImage pulls the image by the digest of the tag and tags it, so the tag cannot be moved during the export
+func pullImage(name, imageName, imageRepo, tag string) (*ImageManifest, error) {
+	digest, err := docker_registry.ImageDigest(imageName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get image %s digest: %s", imageName, err)
+	}
+
+	imageId, err := docker_registry.ImageId(imageName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get image %s id: %s", imageName, err)
+	}
+
+	imageNameWithDigest := fmt.Sprintf("%s@%s", imageRepo, digest)
+	if err := docker.CliPullWithRetries(imageNameWithDigest); err != nil {
+		return nil, err
+	}
+
+	if err := docker.CliTag(imageNameWithDigest, imageName); err != nil {
+		return nil, err
+	}
+
+	return &ImageManifest{Name: name, ImageName: imageName, Tag: tag, Digest: digest, ImageId: imageId}, nil
+}
+
+func copyValuesFiles(paths []string, bundleDir, dirName string) ([]string, error) {
+	var res []string
+	for ind, path := range paths {
+		relPath := filepath.Join(dirName, fmt.Sprintf("%d-%s", ind, filepath.Base(path)))
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to access values file %s: %s", path, err)
+		}
+
+		if err := copyFile(path, filepath.Join(bundleDir, relPath), info.Mode().Perm()); err != nil {
+			return nil, err
+		}
+
+		res = append(res, filepath.ToSlash(relPath))
+	}
+
+	return res, nil
+}
+
+func writeServiceValues(bundleDir string, serviceValues map[string]interface{}) error {
+	data, err := yaml.Marshal(serviceValues)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(bundleDir, ServiceValuesFileName)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("unable to write %s: %s", path, err)
+	}
+
+	return nil
+}
+
+func readServiceValues(bundleDir string) (map[string]interface{}, error) {
+	path := filepath.Join(bundleDir, ServiceValuesFileName)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %s", path, err)
+	}
+
+	serviceValues := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &serviceValues); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal %s: %s", path, err)
+	}
+
+	return serviceValues, nil
+}
+
+// setImageServiceValues fixes global.werf.image values of the image regardless of the tag strategy
+func setImageServiceValues(serviceValues map[string]interface{}, name, imageName, imageId, digest string) {
+	werfInfo := valuesMap(valuesMap(serviceValues, "global"), "werf")
+
+	var imageData map[string]interface{}
+	if name == "" {
+		imageData = valuesMap(werfInfo, "image")
+	} else {
+		imageData = valuesMap(valuesMap(werfInfo, "image"), name)
+	}
+
+	imageData["docker_image"] = imageName
+	imageData["docker_image_id"] = imageId
+	imageData["docker_image_digest"] = digest
+}
+
+func valuesMap(values map[string]interface{}, key string) map[string]interface{} {
+	if m, ok := values[key].(map[string]interface{}); ok {
+		return m
+	}
+
+	m := map[string]interface{}{}
+	values[key] = m
+
+	return m
+}
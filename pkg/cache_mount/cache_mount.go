@@ -0,0 +1,570 @@
+package cache_mount
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/go-units"
+
+	"github.com/flant/logboek"
+	"github.com/flant/shluz"
+
+	"github.com/flant/werf/pkg/slug"
+	"github.com/flant/werf/pkg/werf"
+)
+
+const (
+	CacheVersion = "1"
+
+	SharingShared  = "shared"
+	SharingLocked  = "locked"
+	SharingPrivate = "private"
+
+	sharedInstanceName    = "shared"
+	privateInstancePrefix = "private-"
+)
+
+// privateInstanceRetryPeriod is the period of checking for a free private instance
+var privateInstanceRetryPeriod = time.Second
+
+// MaxPrivateInstances limits the number of the private cache instances, the build waits for the release if all instances are used
+var MaxPrivateInstances = 8
+
+var (
+	// in-process state: shluz lock cannot be taken several times by one process
+	mutex          sync.Mutex
+	instancesState = map[string]*instanceState{}
+	usedInstances  = map[string]int{}
+	cachesMutexes  = map[string]*sync.Mutex{}
+)
+
+type instanceState struct {
+	rwMutex sync.RWMutex
+
+	sharedUsersMutex sync.Mutex
+	sharedUsers      int
+}
+
+func GetCachesDir() string {
+	return filepath.Join(werf.GetSharedContextDir(), "mounts", "caches", CacheVersion)
+}
+
+// Mount is the cache instance acquired for the assembly container run
+type Mount struct {
+	Id      string
+	Sharing string
+	DataDir string
+
+	instanceDir string
+	lockName    string
+}
+
+// Acquire locks the cache instance according to the sharing mode:
+// * shared — the instance is used concurrently by all builds;
+// * locked — the instance is used exclusively, other builds wait for the release;
+// * private — the free instance is used exclusively, a new instance is created if all instances are busy
+// (up to MaxPrivateInstances).
+func Acquire(id, sharing, projectName string) (*Mount, error) {
+	cacheDir := filepath.Join(GetCachesDir(), slug.Slug(id))
+
+	m := &Mount{Id: id, Sharing: sharing}
+
+	switch sharing {
+	case SharingShared, SharingLocked:
+		m.setInstance(cacheDir, sharedInstanceName)
+
+		state := getInstanceState(m.lockName)
+		if sharing == SharingLocked {
+			state.rwMutex.Lock()
+			if err := lockInstance(m, shluz.LockOptions{}); err != nil {
+				state.rwMutex.Unlock()
+				return nil, err
+			}
+		} else {
+			state.rwMutex.RLock()
+			if err := acquireSharedInstance(m, state); err != nil {
+				state.rwMutex.RUnlock()
+				return nil, err
+			}
+		}
+	case SharingPrivate:
+		if err := acquirePrivateInstance(m, cacheDir); err != nil {
+			return nil, err
+		}
+	default:
+		panic(fmt.Sprintf("unknown sharing mode %s", sharing))
+	}
+
+	if sharing != SharingPrivate {
+		markInstanceUsed(m.lockName, 1)
+	}
+
+	// the instance is locked and cannot be removed, but the cache dir can be removed by the cleanup of the empty caches
+	if err := withCacheLock(cacheDir, func() error {
+		if err := writeCacheMeta(cacheDir, id, projectName); err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(m.DataDir, os.ModePerm); err != nil {
+			return fmt.Errorf("unable to create dir %s: %s", m.DataDir, err)
+		}
+
+		return touch(filepath.Join(m.instanceDir, "last_used"))
+	}); err != nil {
+		_ = m.Release()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *Mount) setInstance(cacheDir, instanceName string) {
+	m.instanceDir = filepath.Join(cacheDir, "instances", instanceName)
+	m.DataDir = filepath.Join(m.instanceDir, "data")
+	m.lockName = instanceLockName(m.instanceDir)
+}
+
+func (m *Mount) Release() error {
+	if err := touch(filepath.Join(m.instanceDir, "last_used")); err != nil {
+		logboek.LogErrorF("WARNING: %s\n", err)
+	}
+
+	markInstanceUsed(m.lockName, -1)
+
+	switch m.Sharing {
+	case SharingLocked:
+		defer getInstanceState(m.lockName).rwMutex.Unlock()
+		return shluz.Unlock(m.lockName)
+	case SharingShared:
+		state := getInstanceState(m.lockName)
+		defer state.rwMutex.RUnlock()
+		return releaseSharedInstance(m, state)
+	case SharingPrivate:
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return shluz.Unlock(m.lockName)
+	default:
+		panic(fmt.Sprintf("unknown sharing mode %s", m.Sharing))
+	}
+}
+
+// markInstanceUsed counts in-process users of the instance: shluz locks are reentrant,
+// so the cleanup cannot detect the instance used by the same process by the lock
+func markInstanceUsed(lockName string, delta int) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	usedInstances[lockName] += delta
+	if usedInstances[lockName] <= 0 {
+		delete(usedInstances, lockName)
+	}
+}
+
+func isInstanceUsed(lockName string) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return usedInstances[lockName] > 0
+}
+
+func getInstanceState(lockName string) *instanceState {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if _, hasKey := instancesState[lockName]; !hasKey {
+		instancesState[lockName] = &instanceState{}
+	}
+
+	return instancesState[lockName]
+}
+
+// acquireSharedInstance takes the read-only shluz lock for the first in-process user of the instance
+func acquireSharedInstance(m *Mount, state *instanceState) error {
+	state.sharedUsersMutex.Lock()
+	defer state.sharedUsersMutex.Unlock()
+
+	if state.sharedUsers == 0 {
+		if err := lockInstance(m, shluz.LockOptions{ReadOnly: true}); err != nil {
+			return err
+		}
+	}
+
+	state.sharedUsers++
+
+	return nil
+}
+
+func releaseSharedInstance(m *Mount, state *instanceState) error {
+	state.sharedUsersMutex.Lock()
+	defer state.sharedUsersMutex.Unlock()
+
+	state.sharedUsers--
+	if state.sharedUsers == 0 {
+		return shluz.Unlock(m.lockName)
+	}
+
+	return nil
+}
+
+// acquirePrivateInstance takes the first free private instance and waits for the release if all instances are used
+func acquirePrivateInstance(m *Mount, cacheDir string) error {
+	for {
+		for ind := 0; ind < MaxPrivateInstances; ind++ {
+			m.setInstance(cacheDir, fmt.Sprintf("%s%d", privateInstancePrefix, ind))
+
+			isAcquired, err := tryAcquirePrivateInstance(m)
+			if err != nil {
+				return err
+			}
+
+			if isAcquired {
+				return nil
+			}
+		}
+
+		logboek.LogInfoF("Waiting for a free private instance of cache mount %s\n", m.Id)
+		time.Sleep(privateInstanceRetryPeriod)
+	}
+}
+
+func tryAcquirePrivateInstance(m *Mount) (bool, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if usedInstances[m.lockName] > 0 {
+		return false, nil
+	}
+
+	isLocked, err := shluz.TryLock(m.lockName, shluz.TryLockOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to lock %s: %s", m.lockName, err)
+	}
+
+	if isLocked {
+		usedInstances[m.lockName]++
+	}
+
+	return isLocked, nil
+}
+
+func lockInstance(m *Mount, opts shluz.LockOptions) error {
+	if err := shluz.Lock(m.lockName, opts); err != nil {
+		return fmt.Errorf("failed to lock %s: %s", m.lockName, err)
+	}
+
+	return nil
+}
+
+// withCacheLock serializes the changes of the cache dir by Acquire and Cleanup
+func withCacheLock(cacheDir string, f func() error) error {
+	cacheMutex := getCacheMutex(cacheDir)
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
+	lockName := cacheLockName(cacheDir)
+	if err := shluz.Lock(lockName, shluz.LockOptions{}); err != nil {
+		return fmt.Errorf("failed to lock %s: %s", lockName, err)
+	}
+	defer shluz.Unlock(lockName)
+
+	return f()
+}
+
+func getCacheMutex(cacheDir string) *sync.Mutex {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if _, hasKey := cachesMutexes[cacheDir]; !hasKey {
+		cachesMutexes[cacheDir] = &sync.Mutex{}
+	}
+
+	return cachesMutexes[cacheDir]
+}
+
+func cacheLockName(cacheDir string) string {
+	return fmt.Sprintf("cache_mount.%s", filepath.Base(cacheDir))
+}
+
+func instanceLockName(instanceDir string) string {
+	return fmt.Sprintf("cache_mount.%s.%s", filepath.Base(filepath.Dir(filepath.Dir(instanceDir))), filepath.Base(instanceDir))
+}
+
+// writeCacheMeta saves the original cache id and marks the cache as used by the project
+func writeCacheMeta(cacheDir, id, projectName string) error {
+	projectsDir := filepath.Join(cacheDir, "projects")
+	if err := os.MkdirAll(projectsDir, os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create dir %s: %s", projectsDir, err)
+	}
+
+	idPath := filepath.Join(cacheDir, "id")
+	if _, err := os.Stat(idPath); os.IsNotExist(err) {
+		if err := ioutil.WriteFile(idPath, []byte(id+"\n"), 0644); err != nil {
+			return fmt.Errorf("unable to write %s: %s", idPath, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("unable to access %s: %s", idPath, err)
+	}
+
+	if projectName != "" {
+		return touch(filepath.Join(projectsDir, projectName))
+	}
+
+	return nil
+}
+
+func touch(path string) error {
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); os.IsNotExist(err) {
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			return fmt.Errorf("unable to write %s: %s", path, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("unable to change times of %s: %s", path, err)
+	}
+
+	return nil
+}
+
+type Cache struct {
+	Id        string
+	Dir       string
+	Projects  []string
+	Instances []*Instance
+}
+
+type Instance struct {
+	Name     string
+	Dir      string
+	LastUsed time.Time
+	Size     int64
+}
+
+func (c *Cache) Size() int64 {
+	var size int64
+	for _, instance := range c.Instances {
+		size += instance.Size
+	}
+
+	return size
+}
+
+func (c *Cache) LastUsed() time.Time {
+	var lastUsed time.Time
+	for _, instance := range c.Instances {
+		if instance.LastUsed.After(lastUsed) {
+			lastUsed = instance.LastUsed
+		}
+	}
+
+	return lastUsed
+}
+
+// List returns caches from the host storage, instances sizes are calculated by data dirs walking
+func List() ([]*Cache, error) {
+	cachesDir := GetCachesDir()
+
+	cacheDirInfos, err := ioutil.ReadDir(cachesDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read dir %s: %s", cachesDir, err)
+	}
+
+	var caches []*Cache
+	for _, cacheDirInfo := range cacheDirInfos {
+		if !cacheDirInfo.IsDir() {
+			continue
+		}
+
+		cache, err := getCache(filepath.Join(cachesDir, cacheDirInfo.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		caches = append(caches, cache)
+	}
+
+	return caches, nil
+}
+
+func getCache(cacheDir string) (*Cache, error) {
+	cache := &Cache{Dir: cacheDir, Id: filepath.Base(cacheDir)}
+
+	if data, err := ioutil.ReadFile(filepath.Join(cacheDir, "id")); err == nil {
+		cache.Id = strings.TrimSpace(string(data))
+	}
+
+	projectInfos, err := ioutil.ReadDir(filepath.Join(cacheDir, "projects"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to read dir %s: %s", filepath.Join(cacheDir, "projects"), err)
+	}
+
+	for _, projectInfo := range projectInfos {
+		cache.Projects = append(cache.Projects, projectInfo.Name())
+	}
+
+	instancesDir := filepath.Join(cacheDir, "instances")
+	instanceInfos, err := ioutil.ReadDir(instancesDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to read dir %s: %s", instancesDir, err)
+	}
+
+	for _, instanceInfo := range instanceInfos {
+		instance := &Instance{Name: instanceInfo.Name(), Dir: filepath.Join(instancesDir, instanceInfo.Name())}
+
+		if info, err := os.Stat(filepath.Join(instance.Dir, "last_used")); err == nil {
+			instance.LastUsed = info.ModTime()
+		} else {
+			instance.LastUsed = instanceInfo.ModTime()
+		}
+
+		size, err := dirSize(filepath.Join(instance.Dir, "data"))
+		if err != nil {
+			return nil, err
+		}
+		instance.Size = size
+
+		cache.Instances = append(cache.Instances, instance)
+	}
+
+	return cache, nil
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to calculate size of %s: %s", dir, err)
+	}
+
+	return size, nil
+}
+
+type CleanupOptions struct {
+	MaxAge  time.Duration // 0 — no limit
+	MaxSize int64         // 0 — no limit
+	DryRun  bool
+}
+
+// Cleanup removes cache instances that have not been used longer than MaxAge,
+// then removes least recently used instances until the total size fits MaxSize.
+// Instances used by running builds are skipped
+func Cleanup(options CleanupOptions) error {
+	caches, err := List()
+	if err != nil {
+		return err
+	}
+
+	type cacheInstance struct {
+		cache    *Cache
+		instance *Instance
+	}
+
+	var instances []cacheInstance
+	var totalSize int64
+	for _, cache := range caches {
+		for _, instance := range cache.Instances {
+			instances = append(instances, cacheInstance{cache, instance})
+			totalSize += instance.Size
+		}
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].instance.LastUsed.Before(instances[j].instance.LastUsed)
+	})
+
+	now := time.Now()
+	for _, elm := range instances {
+		isExpired := options.MaxAge != 0 && now.Sub(elm.instance.LastUsed) > options.MaxAge
+		isOverflowed := options.MaxSize != 0 && totalSize > options.MaxSize
+		if !isExpired && !isOverflowed {
+			continue
+		}
+
+		isRemoved, err := removeInstance(elm.cache, elm.instance, options.DryRun)
+		if err != nil {
+			return err
+		}
+
+		if isRemoved {
+			totalSize -= elm.instance.Size
+		}
+	}
+
+	if options.DryRun {
+		return nil
+	}
+
+	for _, cache := range caches {
+		if err := withCacheLock(cache.Dir, func() error { return removeEmptyCache(cache) }); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func removeEmptyCache(cache *Cache) error {
+	instanceInfos, err := ioutil.ReadDir(filepath.Join(cache.Dir, "instances"))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to read dir %s: %s", filepath.Join(cache.Dir, "instances"), err)
+	}
+
+	if len(instanceInfos) == 0 {
+		if err := os.RemoveAll(cache.Dir); err != nil {
+			return fmt.Errorf("unable to remove %s: %s", cache.Dir, err)
+		}
+	}
+
+	return nil
+}
+
+func removeInstance(cache *Cache, instance *Instance, dryRun bool) (bool, error) {
+	lockName := instanceLockName(instance.Dir)
+	if isInstanceUsed(lockName) {
+		logboek.LogInfoF("Ignore cache mount %s instance %s used by the running build\n", cache.Id, instance.Name)
+		return false, nil
+	}
+
+	isLocked, err := shluz.TryLock(lockName, shluz.TryLockOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to lock %s: %s", lockName, err)
+	}
+
+	if !isLocked {
+		logboek.LogInfoF("Ignore cache mount %s instance %s used by another process\n", cache.Id, instance.Name)
+		return false, nil
+	}
+	defer shluz.Unlock(lockName)
+
+	logboek.LogInfoF("Removing cache mount %s instance %s (%s, last used %s ago)\n", cache.Id, instance.Name, units.BytesSize(float64(instance.Size)), time.Since(instance.LastUsed).Round(time.Second))
+
+	if dryRun {
+		return true, nil
+	}
+
+	if err := os.RemoveAll(instance.Dir); err != nil {
+		return false, fmt.Errorf("unable to remove %s: %s", instance.Dir, err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,154 @@
+package cache_mount
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/flant/shluz"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/werf/pkg/werf"
+)
+
+var _ = Describe("cache mounts", func() {
+	var homeDir string
+	var maxPrivateInstances int
+
+	acquire := func(id, sharing string) *Mount {
+		m, err := Acquire(id, sharing, "project")
+		Ω(err).ShouldNot(HaveOccurred())
+		return m
+	}
+
+	acquireAsync := func(id, sharing string) chan *Mount {
+		acquired := make(chan *Mount, 1)
+		go func() {
+			defer GinkgoRecover()
+			acquired <- acquire(id, sharing)
+		}()
+
+		return acquired
+	}
+
+	// writeInstance writes the data of the size to the instance and sets the last usage time
+	writeInstance := func(m *Mount, size int, lastUsed time.Time) {
+		Ω(ioutil.WriteFile(filepath.Join(m.DataDir, "data"), make([]byte, size), 0644)).Should(Succeed())
+		Ω(os.Chtimes(filepath.Join(m.instanceDir, "last_used"), lastUsed, lastUsed)).Should(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		homeDir, err = ioutil.TempDir("", "werf-cache-mount-test")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(werf.Init(homeDir, homeDir)).Should(Succeed())
+		Ω(shluz.Init(filepath.Join(homeDir, "locks"))).Should(Succeed())
+
+		maxPrivateInstances = MaxPrivateInstances
+		privateInstanceRetryPeriod = 10 * time.Millisecond
+	})
+
+	AfterEach(func() {
+		MaxPrivateInstances = maxPrivateInstances
+		Ω(os.RemoveAll(homeDir)).Should(Succeed())
+	})
+
+	It("reuses the shared instance by concurrent users", func() {
+		m1 := acquire("go-mod", SharingShared)
+		m2 := acquire("go-mod", SharingShared)
+		Ω(m2.DataDir).Should(Equal(m1.DataDir))
+		Ω(m1.DataDir).Should(BeADirectory())
+
+		Ω(m1.Release()).Should(Succeed())
+		Ω(m2.Release()).Should(Succeed())
+
+		m3 := acquire("go-mod", SharingShared)
+		Ω(m3.DataDir).Should(Equal(m1.DataDir))
+		Ω(m3.Release()).Should(Succeed())
+	})
+
+	It("uses the locked instance exclusively", func() {
+		m1 := acquire("apt", SharingLocked)
+
+		acquired := acquireAsync("apt", SharingLocked)
+		Consistently(acquired, 100*time.Millisecond).ShouldNot(Receive())
+
+		Ω(m1.Release()).Should(Succeed())
+
+		var m2 *Mount
+		Eventually(acquired, time.Second).Should(Receive(&m2))
+		Ω(m2.DataDir).Should(Equal(m1.DataDir))
+		Ω(m2.Release()).Should(Succeed())
+	})
+
+	It("creates private instances up to the limit and waits for the release", func() {
+		MaxPrivateInstances = 2
+
+		m1 := acquire("npm", SharingPrivate)
+		m2 := acquire("npm", SharingPrivate)
+		Ω(m2.DataDir).ShouldNot(Equal(m1.DataDir))
+
+		acquired := acquireAsync("npm", SharingPrivate)
+		Consistently(acquired, 100*time.Millisecond).ShouldNot(Receive())
+
+		Ω(m1.Release()).Should(Succeed())
+
+		var m3 *Mount
+		Eventually(acquired, time.Second).Should(Receive(&m3))
+		Ω(m3.DataDir).Should(Equal(m1.DataDir))
+
+		Ω(m2.Release()).Should(Succeed())
+		Ω(m3.Release()).Should(Succeed())
+	})
+
+	It("removes instances that have not been used longer than max age and skips used instances", func() {
+		oldTime := time.Now().Add(-48 * time.Hour)
+
+		expired := acquire("expired", SharingShared)
+		writeInstance(expired, 10, oldTime)
+		Ω(expired.Release()).Should(Succeed())
+		Ω(os.Chtimes(filepath.Join(expired.instanceDir, "last_used"), oldTime, oldTime)).Should(Succeed())
+
+		used := acquire("used", SharingLocked)
+		writeInstance(used, 10, oldTime)
+
+		fresh := acquire("fresh", SharingShared)
+		writeInstance(fresh, 10, time.Now())
+		Ω(fresh.Release()).Should(Succeed())
+
+		Ω(Cleanup(CleanupOptions{MaxAge: 24 * time.Hour, DryRun: true})).Should(Succeed())
+		Ω(expired.DataDir).Should(BeADirectory())
+
+		Ω(Cleanup(CleanupOptions{MaxAge: 24 * time.Hour})).Should(Succeed())
+		Ω(filepath.Dir(filepath.Dir(expired.instanceDir))).ShouldNot(BeADirectory())
+		Ω(used.DataDir).Should(BeADirectory())
+		Ω(fresh.DataDir).Should(BeADirectory())
+
+		Ω(used.Release()).Should(Succeed())
+	})
+
+	It("removes least recently used instances until the total size fits max size", func() {
+		now := time.Now()
+
+		var mounts []*Mount
+		for ind, id := range []string{"oldest", "old", "new"} {
+			m := acquire(id, SharingShared)
+			Ω(m.Release()).Should(Succeed())
+			writeInstance(m, 100, now.Add(time.Duration(ind-3)*time.Hour))
+			mounts = append(mounts, m)
+		}
+
+		used := acquire("used", SharingPrivate)
+		writeInstance(used, 100, now.Add(-10*time.Hour))
+
+		Ω(Cleanup(CleanupOptions{MaxSize: 250})).Should(Succeed())
+		Ω(mounts[0].DataDir).ShouldNot(BeADirectory())
+		Ω(mounts[1].DataDir).ShouldNot(BeADirectory())
+		Ω(mounts[2].DataDir).Should(BeADirectory())
+		Ω(used.DataDir).Should(BeADirectory())
+
+		Ω(used.Release()).Should(Succeed())
+	})
+})
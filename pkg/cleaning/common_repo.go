@@ -1,6 +1,7 @@
 package cleaning
 
 import (
+	"sort"
 	"strings"
 
 	"github.com/flant/logboek"
@@ -10,7 +11,6 @@ import (
 )
 
 type CommonRepoOptions struct {
-	StagesStorage     string
 	ImagesRepoManager ImagesRepoManager
 	ImagesNames       []string
 	DryRun            bool
@@ -105,19 +105,21 @@ func multirepoRepoImages(options CommonRepoOptions) (map[string][]docker_registr
 	return repoImagesByImageName, nil
 }
 
-func repoImageStagesImages(options CommonRepoOptions) ([]docker_registry.RepoImage, error) {
-	return docker_registry.ImagesByWerfImageLabel(options.StagesStorage, "false")
-}
-
 func repoImagesRemove(images []docker_registry.RepoImage, options CommonRepoOptions) error {
-	isGCR, err := docker_registry.IsGCR(options.ImagesRepoManager.ImagesRepo())
-	if err != nil {
-		return err
-	}
+	// indexes are removed first to release their manifests (see repoImageRemove)
+	images = append([]docker_registry.RepoImage{}, images...)
+	sort.SliceStable(images, func(i, j int) bool {
+		return images[i].IndexDigest != "" && images[j].IndexDigest == ""
+	})
 
 	for _, image := range images {
-		if isGCR {
-			if err := GCRImageRemove(image, options); err != nil {
+		isDeleteByTag, err := docker_registry.IsDeleteByTag(image.Repository)
+		if err != nil {
+			return err
+		}
+
+		if isDeleteByTag {
+			if err := repoImageRemoveByTag(image, options); err != nil {
 				return err
 			}
 		} else {
@@ -130,22 +132,41 @@ func repoImagesRemove(images []docker_registry.RepoImage, options CommonRepoOpti
 	return nil
 }
 
-func GCRImageRemove(image docker_registry.RepoImage, options CommonRepoOptions) error {
+func repoImageRemoveByTag(image docker_registry.RepoImage, options CommonRepoOptions) error {
 	reference := strings.Join([]string{image.Repository, image.Tag}, ":")
 	if err := repoReferenceRemove(reference, options); err != nil {
 		return err
 	}
 
-	return nil
+	digest, err := image.ManifestDigest()
+	if err != nil {
+		return err
+	}
+
+	// the manifest and its signatures are kept while other tags refer to the manifest
+	if tag := docker_registry.TagReferencingManifest(image.Repository, digest, image.Tag); tag != "" {
+		return nil
+	}
+
+	return repoImageSignaturesRemove(image.Repository, digest, options)
 }
 
 func repoImageRemove(image docker_registry.RepoImage, options CommonRepoOptions) error {
-	digest, err := image.Digest()
+	digest, err := image.ManifestDigest()
 	if err != nil {
 		return err
 	}
 
-	reference := strings.Join([]string{image.Repository, digest.String()}, "@")
+	if image.IndexDigest == "" {
+		if indexDigest := docker_registry.IndexReferencingManifest(image.Repository, digest); indexDigest != "" {
+			logboek.LogInfoF("Skip %s@%s: referenced by index %s\n", image.Repository, digest, indexDigest)
+			logboek.LogInfoF("  tag: %s\n", image.Tag)
+			logboek.LogOptionalLn()
+			return nil
+		}
+	}
+
+	reference := strings.Join([]string{image.Repository, digest}, "@")
 	if err := repoReferenceRemove(reference, options); err != nil {
 		return err
 	}
@@ -153,6 +174,27 @@ func repoImageRemove(image docker_registry.RepoImage, options CommonRepoOptions)
 	logboek.LogInfoF("  tag: %s\n", image.Tag)
 	logboek.LogOptionalLn()
 
+	return repoImageSignaturesRemove(image.Repository, digest, options)
+}
+
+// repoImageSignaturesRemove removes the signatures of the removed manifest (see docker_registry.SignatureTag)
+func repoImageSignaturesRemove(repository, digest string, options CommonRepoOptions) error {
+	reference, err := docker_registry.ImageSignaturesReference(repository, digest)
+	if err != nil {
+		return err
+	} else if reference == "" {
+		return nil
+	}
+
+	if err := repoReferenceRemove(reference, options); err != nil {
+		return err
+	}
+
+	if strings.Contains(reference, "@") {
+		logboek.LogInfoF("  tag: %s\n", docker_registry.SignatureTag(digest))
+		logboek.LogOptionalLn()
+	}
+
 	return nil
 }
 
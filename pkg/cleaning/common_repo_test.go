@@ -0,0 +1,199 @@
+package cleaning
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/flant/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/werf/pkg/docker_registry"
+	"github.com/flant/werf/pkg/image"
+	"github.com/flant/werf/pkg/werf"
+)
+
+type testBlob struct {
+	mediaType types.MediaType
+	data      []byte
+	digest    string
+}
+
+func newTestBlob(mediaType types.MediaType, obj interface{}) *testBlob {
+	data, err := json.Marshal(obj)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	return &testBlob{mediaType: mediaType, data: data, digest: fmt.Sprintf("sha256:%x", sha256.Sum256(data))}
+}
+
+// testRegistry is the stand-in of the docker registry API for the single repository "app"
+type testRegistry struct {
+	blobs     map[string]*testBlob
+	manifests map[string]*testBlob // by tag
+
+	mutex sync.Mutex
+}
+
+// addImage adds the image with the labels by the tags and returns the manifest digest
+func (r *testRegistry) addImage(labels map[string]string, tags ...string) string {
+	config := newTestBlob(types.DockerConfigJSON, map[string]interface{}{
+		"architecture": "amd64",
+		"os":           "linux",
+		"config":       map[string]interface{}{"Labels": labels},
+		"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": []string{}},
+	})
+	r.blobs[config.digest] = config
+
+	m := newTestBlob(types.DockerManifestSchema2, map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     types.DockerManifestSchema2,
+		"config":        map[string]interface{}{"mediaType": config.mediaType, "size": len(config.data), "digest": config.digest},
+		"layers":        []interface{}{},
+	})
+	for _, tag := range tags {
+		r.manifests[tag] = m
+	}
+
+	return m.digest
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var b *testBlob
+	switch {
+	case req.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+		return
+	case req.URL.Path == "/v2/app/tags/list":
+		tags := []string{}
+		for tag := range r.manifests {
+			tags = append(tags, tag)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"name": "app", "tags": tags})
+		return
+	case strings.HasPrefix(req.URL.Path, "/v2/app/manifests/"):
+		reference := strings.TrimPrefix(req.URL.Path, "/v2/app/manifests/")
+		for tag, m := range r.manifests {
+			if tag != reference && m.digest != reference {
+				continue
+			}
+
+			if req.Method == http.MethodDelete {
+				delete(r.manifests, tag)
+			}
+			b = m
+		}
+
+		if req.Method == http.MethodDelete && b != nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+	case strings.HasPrefix(req.URL.Path, "/v2/app/blobs/"):
+		b = r.blobs[strings.TrimPrefix(req.URL.Path, "/v2/app/blobs/")]
+	}
+
+	if b == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": []interface{}{map[string]string{"code": "MANIFEST_UNKNOWN", "message": "manifest unknown"}}})
+		return
+	}
+
+	w.Header().Set("Content-Type", string(b.mediaType))
+	w.Header().Set("Docker-Content-Digest", b.digest)
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(b.data)))
+	if req.Method != http.MethodHead {
+		_, _ = w.Write(b.data)
+	}
+}
+
+var _ = Describe("repo images removal", func() {
+	var homeDir string
+	var registry *testRegistry
+	var server *httptest.Server
+	var repository string
+
+	werfImageLabels := map[string]string{image.WerfImageLabel: "true"}
+
+	repoImagesByTag := func() map[string]docker_registry.RepoImage {
+		repoImages, err := docker_registry.ImagesByWerfImageLabel(repository, "true")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		res := map[string]docker_registry.RepoImage{}
+		for _, repoImage := range repoImages {
+			res[repoImage.Tag] = repoImage
+		}
+
+		return res
+	}
+
+	BeforeEach(func() {
+		var err error
+		homeDir, err = ioutil.TempDir("", "werf-cleaning-test")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(werf.Init(homeDir, homeDir)).Should(Succeed())
+
+		registry = &testRegistry{blobs: map[string]*testBlob{}, manifests: map[string]*testBlob{}}
+		server = httptest.NewServer(registry)
+		repository = strings.TrimPrefix(server.URL, "http://") + "/app"
+	})
+
+	AfterEach(func() {
+		docker_registry.RepoImplementation = ""
+		server.Close()
+		Ω(os.RemoveAll(homeDir)).Should(Succeed())
+	})
+
+	It("removes the signatures of the image removed by the digest", func() {
+		digest := registry.addImage(werfImageLabels, "v1")
+		registry.addImage(map[string]string{}, docker_registry.SignatureTag(digest))
+		otherDigest := registry.addImage(map[string]string{image.WerfImageLabel: "true", "other": "true"}, "v2")
+		registry.addImage(map[string]string{"other": "true"}, docker_registry.SignatureTag(otherDigest))
+
+		Ω(repoImagesRemove([]docker_registry.RepoImage{repoImagesByTag()["v1"]}, CommonRepoOptions{})).Should(Succeed())
+		Ω(registry.manifests).Should(HaveLen(2))
+		Ω(registry.manifests).Should(HaveKey("v2"))
+		Ω(registry.manifests).Should(HaveKey(docker_registry.SignatureTag(otherDigest)))
+	})
+
+	It("keeps the signatures in the dry run mode", func() {
+		digest := registry.addImage(werfImageLabels, "v1")
+		registry.addImage(map[string]string{}, docker_registry.SignatureTag(digest))
+
+		Ω(repoImagesRemove([]docker_registry.RepoImage{repoImagesByTag()["v1"]}, CommonRepoOptions{DryRun: true})).Should(Succeed())
+		Ω(registry.manifests).Should(HaveLen(2))
+	})
+
+	It("removes the image without signatures", func() {
+		registry.addImage(werfImageLabels, "v1")
+
+		Ω(repoImagesRemove([]docker_registry.RepoImage{repoImagesByTag()["v1"]}, CommonRepoOptions{})).Should(Succeed())
+		Ω(registry.manifests).Should(BeEmpty())
+	})
+
+	It("removes the signatures with the last tag of the image removed by the tag", func() {
+		docker_registry.RepoImplementation = docker_registry.GcrImplementationName
+
+		digest := registry.addImage(werfImageLabels, "v1", "v2")
+		registry.addImage(map[string]string{}, docker_registry.SignatureTag(digest))
+		repoImages := repoImagesByTag()
+
+		Ω(repoImagesRemove([]docker_registry.RepoImage{repoImages["v1"]}, CommonRepoOptions{})).Should(Succeed())
+		Ω(registry.manifests).Should(HaveKey("v2"))
+		Ω(registry.manifests).Should(HaveKey(docker_registry.SignatureTag(digest)))
+
+		Ω(repoImagesRemove([]docker_registry.RepoImage{repoImages["v2"]}, CommonRepoOptions{})).Should(Succeed())
+		Ω(registry.manifests).Should(BeEmpty())
+	})
+})
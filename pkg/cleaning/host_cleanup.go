@@ -10,12 +10,20 @@ import (
 
 	"github.com/flant/logboek"
 	"github.com/flant/shluz"
+	"github.com/flant/werf/pkg/cache_mount"
+	"github.com/flant/werf/pkg/docker_registry"
+	"github.com/flant/werf/pkg/files_checksum_cache"
+	"github.com/flant/werf/pkg/git_data_cache"
 	"github.com/flant/werf/pkg/image"
 	"github.com/flant/werf/pkg/tmp_manager"
 )
 
 type HostCleanupOptions struct {
-	DryRun bool
+	CacheMountsMaxAge   time.Duration
+	CacheMountsMaxSize  int64
+	GitDataCacheMaxAge  time.Duration
+	GitDataCacheMaxSize int64
+	DryRun              bool
 }
 
 func HostCleanup(options HostCleanupOptions) error {
@@ -39,11 +47,55 @@ func HostCleanup(options HostCleanupOptions) error {
 			return nil
 		}
 
-		return shluz.WithLock("gc", shluz.LockOptions{}, func() error {
-			if err := tmp_manager.GC(commonOptions.DryRun); err != nil {
+		if err := shluz.WithLock("gc", shluz.LockOptions{}, func() error {
+			gcOptions := tmp_manager.GCOptions{
+				GitDataCache: git_data_cache.CleanupOptions{
+					MaxAge:  options.GitDataCacheMaxAge,
+					MaxSize: options.GitDataCacheMaxSize,
+				},
+				DryRun: commonOptions.DryRun,
+			}
+
+			if err := tmp_manager.GC(gcOptions); err != nil {
 				return fmt.Errorf("tmp files gc failed: %s", err)
 			}
 
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		if err := logboek.LogProcess("Running cleanup for docker registries images configs cache", logboek.LogProcessOptions{}, func() error {
+			if err := docker_registry.CleanupConfigsCache(docker_registry.DefaultConfigsCacheMaxAge, options.DryRun); err != nil {
+				return fmt.Errorf("images configs cache cleanup failed: %s", err)
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		if err := logboek.LogProcess("Running cleanup for files checksums cache", logboek.LogProcessOptions{}, func() error {
+			if err := files_checksum_cache.Cleanup(files_checksum_cache.DefaultMaxAge, options.DryRun); err != nil {
+				return fmt.Errorf("files checksums cache cleanup failed: %s", err)
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		return logboek.LogProcess("Running cleanup for cache mounts", logboek.LogProcessOptions{}, func() error {
+			cacheMountCleanupOptions := cache_mount.CleanupOptions{
+				MaxAge:  options.CacheMountsMaxAge,
+				MaxSize: options.CacheMountsMaxSize,
+				DryRun:  options.DryRun,
+			}
+
+			if err := cache_mount.Cleanup(cacheMountCleanupOptions); err != nil {
+				return fmt.Errorf("cache mounts cleanup failed: %s", err)
+			}
+
 			return nil
 		})
 	})
@@ -1,11 +1,16 @@
 package cleaning
 
 import (
+	"fmt"
+
 	"github.com/flant/logboek"
+
+	"github.com/flant/werf/pkg/stages_storage"
 )
 
 type StagesPurgeOptions struct {
 	ProjectName                   string
+	StagesStorage                 stages_storage.StagesStorage
 	DryRun                        bool
 	RmContainersThatUseWerfImages bool
 }
@@ -18,25 +23,28 @@ func StagesPurge(options StagesPurgeOptions) error {
 }
 
 func stagesPurge(options StagesPurgeOptions) error {
-	var commonProjectOptions CommonProjectOptions
-	commonProjectOptions.ProjectName = options.ProjectName
-	commonProjectOptions.CommonOptions = CommonOptions{
+	deleteStagesOptions := stages_storage.DeleteStagesOptions{
 		RmiForce:                      true,
 		RmForce:                       options.RmContainersThatUseWerfImages,
 		RmContainersThatUseWerfImages: options.RmContainersThatUseWerfImages,
 		DryRun:                        options.DryRun,
 	}
 
-	if err := projectStagesPurge(commonProjectOptions); err != nil {
-		return err
+	// distributed stages storage stages are also cached in the local docker daemon
+	storages := []stages_storage.StagesStorage{options.StagesStorage}
+	if options.StagesStorage.IsDistributed() {
+		storages = append([]stages_storage.StagesStorage{stages_storage.NewLocalStagesStorage()}, storages...)
 	}
 
-	return nil
-}
+	for _, storage := range storages {
+		stages, err := storage.GetStagesList(options.ProjectName)
+		if err != nil {
+			return fmt.Errorf("unable to get stages list from stages storage %s: %s", storage.String(), err)
+		}
 
-func projectStagesPurge(options CommonProjectOptions) error {
-	if err := werfImagesFlushByFilterSet(projectImageStageFilterSet(options), options.CommonOptions); err != nil {
-		return err
+		if err := storage.DeleteStages(deleteStagesOptions, stages...); err != nil {
+			return err
+		}
 	}
 
 	return nil
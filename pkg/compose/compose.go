@@ -0,0 +1,549 @@
+package compose
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	containerTypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+
+	"github.com/flant/logboek"
+
+	"github.com/flant/werf/pkg/docker"
+	"github.com/flant/werf/pkg/slug"
+	"github.com/flant/werf/pkg/util"
+)
+
+const (
+	ProjectLabel    = "werf-compose-project"
+	ServiceLabel    = "werf-compose-service"
+	ConfigHashLabel = "werf-compose-config-hash"
+
+	containerStopTimeout = 10 * time.Second
+)
+
+// Project manages containers, network and volumes of the compose file services of the werf project
+type Project struct {
+	Name       string
+	ProjectDir string
+	Config     *Config
+}
+
+func (p *Project) NetworkName() string {
+	return slug.DockerTag(fmt.Sprintf("werf-compose-%s", p.Name))
+}
+
+func (p *Project) ContainerName(serviceName string) string {
+	return slug.DockerTag(fmt.Sprintf("werf-compose-%s-%s", p.Name, serviceName))
+}
+
+func (p *Project) VolumeName(volumeName string) string {
+	return slug.DockerTag(fmt.Sprintf("werf-compose-%s-%s", p.Name, volumeName))
+}
+
+type UpOptions struct {
+	// Services to start with their dependencies, all services if empty
+	Services []string
+	// WerfImages maps werf.yaml image names to the docker images of their last stages
+	WerfImages map[string]string
+}
+
+// Up creates the project network and volumes, (re)creates and starts the containers of the services,
+// which are not running, which configuration is changed or which dependencies are recreated.
+// Containers of the services, which are not defined in the compose file anymore, are removed
+func (p *Project) Up(opts UpOptions) error {
+	servicesNames, err := p.Config.ServicesInOrder(opts.Services)
+	if err != nil {
+		return err
+	}
+
+	if err := p.removeOrphanContainers(); err != nil {
+		return err
+	}
+
+	if err := p.createNetwork(); err != nil {
+		return err
+	}
+
+	recreatedServices := map[string]bool{}
+	for _, serviceName := range servicesNames {
+		if err := logboek.LogProcess(fmt.Sprintf("Starting service %s", serviceName), logboek.LogProcessOptions{}, func() error {
+			force := false
+			for _, dependency := range p.Config.Services[serviceName].DependsOn {
+				if recreatedServices[dependency] {
+					force = true
+				}
+			}
+
+			recreated, err := p.upService(serviceName, opts.WerfImages, force)
+			recreatedServices[serviceName] = recreated
+
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *Project) removeOrphanContainers() error {
+	containers, err := p.containers(nil)
+	if err != nil {
+		return err
+	}
+
+	for _, container := range containers {
+		if _, ok := p.Config.Services[container.Labels[ServiceLabel]]; ok {
+			continue
+		}
+
+		logboek.LogInfoF("Removing orphan container %s of service %s\n", containerName(container), container.Labels[ServiceLabel])
+
+		if err := removeContainer(container.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *Project) createNetwork() error {
+	exist, err := docker.NetworkExist(p.NetworkName())
+	if err != nil {
+		return fmt.Errorf("unable to inspect network %s: %s", p.NetworkName(), err)
+	}
+
+	if exist {
+		return nil
+	}
+
+	logboek.LogInfoF("Creating network %s\n", p.NetworkName())
+
+	if _, err := docker.NetworkCreate(p.NetworkName(), types.NetworkCreate{
+		CheckDuplicate: true,
+		Labels:         map[string]string{ProjectLabel: p.Name},
+	}); err != nil {
+		return fmt.Errorf("unable to create network %s: %s", p.NetworkName(), err)
+	}
+
+	return nil
+}
+
+func (p *Project) createVolume(volumeName string) error {
+	name := p.VolumeName(volumeName)
+
+	exist, err := docker.VolumeExist(name)
+	if err != nil {
+		return fmt.Errorf("unable to inspect volume %s: %s", name, err)
+	}
+
+	if exist {
+		return nil
+	}
+
+	logboek.LogInfoF("Creating volume %s\n", name)
+
+	if err := docker.VolumeCreate(name, map[string]string{ProjectLabel: p.Name}); err != nil {
+		return fmt.Errorf("unable to create volume %s: %s", name, err)
+	}
+
+	return nil
+}
+
+// containerSpec is the resolved configuration of the service container, its hash is saved into the container label
+// to detect configuration changes
+type containerSpec struct {
+	Config     *containerTypes.Config
+	HostConfig *containerTypes.HostConfig
+}
+
+// upService creates and starts the service container and returns true if the container is (re)created.
+// Up-to-date running container is recreated anyway if force is set (e.g. one of the dependencies is recreated)
+func (p *Project) upService(serviceName string, werfImages map[string]string, force bool) (bool, error) {
+	service := p.Config.Services[serviceName]
+
+	imageName := service.Image
+	if service.WerfImage != nil {
+		var ok bool
+		imageName, ok = werfImages[*service.WerfImage]
+		if !ok {
+			return false, fmt.Errorf("service %s: image %s is not defined in werf.yaml", serviceName, *service.WerfImage)
+		}
+	} else if err := pullImageIfNotExist(imageName); err != nil {
+		return false, err
+	}
+
+	spec, err := p.serviceContainerSpec(serviceName, imageName)
+	if err != nil {
+		return false, err
+	}
+
+	specData, err := json.Marshal(spec)
+	if err != nil {
+		return false, err
+	}
+	configHash := fmt.Sprintf("%x", sha256.Sum256(specData))
+	spec.Config.Labels[ConfigHashLabel] = configHash
+
+	containerName := p.ContainerName(serviceName)
+	exist, err := docker.ContainerExist(containerName)
+	if err != nil {
+		return false, err
+	}
+
+	if exist {
+		inspect, err := docker.ContainerInspect(containerName)
+		if err != nil {
+			return false, err
+		}
+
+		if inspect.Config.Labels[ConfigHashLabel] == configHash && inspect.State.Running && !force {
+			logboek.LogInfoF("Container %s is up to date\n", containerName)
+			return false, nil
+		}
+
+		if err := removeContainer(containerName); err != nil {
+			return false, err
+		}
+	}
+
+	for _, volume := range service.Volumes {
+		source, _, _ := parseVolume(volume)
+		if isNamedVolume(source) {
+			if err := p.createVolume(source); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	logboek.LogInfoF("Creating container %s from image %s\n", containerName, imageName)
+
+	networkingConfig := &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			p.NetworkName(): {Aliases: []string{serviceName}},
+		},
+	}
+
+	if _, err := docker.ContainerCreate(containerName, spec.Config, spec.HostConfig, networkingConfig); err != nil {
+		return false, fmt.Errorf("unable to create container %s: %s", containerName, err)
+	}
+
+	if err := docker.ContainerStart(containerName); err != nil {
+		return false, fmt.Errorf("unable to start container %s: %s", containerName, err)
+	}
+
+	return true, nil
+}
+
+func (p *Project) serviceContainerSpec(serviceName, imageName string) (*containerSpec, error) {
+	service := p.Config.Services[serviceName]
+
+	exposedPorts, portBindings, err := nat.ParsePortSpecs(service.Ports)
+	if err != nil {
+		return nil, fmt.Errorf("service %s: bad ports: %s", serviceName, err)
+	}
+
+	config := &containerTypes.Config{
+		Image:        imageName,
+		Cmd:          []string(service.Command),
+		Entrypoint:   []string(service.Entrypoint),
+		Env:          service.Environment.list(),
+		WorkingDir:   service.WorkingDir,
+		User:         service.User,
+		ExposedPorts: exposedPorts,
+		Labels: map[string]string{
+			ProjectLabel: p.Name,
+			ServiceLabel: serviceName,
+		},
+	}
+
+	hostConfig := &containerTypes.HostConfig{
+		NetworkMode:  containerTypes.NetworkMode(p.NetworkName()),
+		PortBindings: portBindings,
+	}
+
+	for _, volume := range service.Volumes {
+		source, target, mode := parseVolume(volume)
+
+		switch {
+		case source == "":
+			if config.Volumes == nil {
+				config.Volumes = map[string]struct{}{}
+			}
+			config.Volumes[target] = struct{}{}
+			continue
+		case isNamedVolume(source):
+			source = p.VolumeName(source)
+		case strings.HasPrefix(source, "~"):
+			source = util.ExpandPath(source)
+		case !filepath.IsAbs(source):
+			source = filepath.Join(p.ProjectDir, source)
+		}
+
+		bind := fmt.Sprintf("%s:%s", source, target)
+		if mode != "" {
+			bind = fmt.Sprintf("%s:%s", bind, mode)
+		}
+		hostConfig.Binds = append(hostConfig.Binds, bind)
+	}
+
+	return &containerSpec{Config: config, HostConfig: hostConfig}, nil
+}
+
+func pullImageIfNotExist(imageName string) error {
+	exist, err := docker.ImageExist(imageName)
+	if err != nil {
+		return err
+	}
+
+	if exist {
+		return nil
+	}
+
+	return logboek.LogProcess(fmt.Sprintf("Pulling image %s", imageName), logboek.LogProcessOptions{}, func() error {
+		return docker.CliPullWithRetries(imageName)
+	})
+}
+
+type DownOptions struct {
+	RemoveVolumes bool
+}
+
+// Down stops and removes all containers and the network of the project, named volumes are removed optionally
+func (p *Project) Down(opts DownOptions) error {
+	containers, err := p.containers(nil)
+	if err != nil {
+		return err
+	}
+
+	for _, container := range containers {
+		logboek.LogInfoF("Removing container %s\n", containerName(container))
+
+		if err := removeContainer(container.ID); err != nil {
+			return err
+		}
+	}
+
+	exist, err := docker.NetworkExist(p.NetworkName())
+	if err != nil {
+		return fmt.Errorf("unable to inspect network %s: %s", p.NetworkName(), err)
+	}
+
+	if exist {
+		logboek.LogInfoF("Removing network %s\n", p.NetworkName())
+
+		if err := docker.NetworkRemove(p.NetworkName()); err != nil {
+			return fmt.Errorf("unable to remove network %s: %s", p.NetworkName(), err)
+		}
+	}
+
+	if opts.RemoveVolumes {
+		filterSet := filters.NewArgs()
+		filterSet.Add("label", fmt.Sprintf("%s=%s", ProjectLabel, p.Name))
+
+		volumes, err := docker.Volumes(filterSet)
+		if err != nil {
+			return err
+		}
+
+		for _, volume := range volumes {
+			logboek.LogInfoF("Removing volume %s\n", volume.Name)
+
+			if err := docker.VolumeRm(volume.Name, false); err != nil {
+				return fmt.Errorf("unable to remove volume %s: %s", volume.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func containerName(container types.Container) string {
+	if len(container.Names) != 0 {
+		return strings.TrimPrefix(container.Names[0], "/")
+	}
+
+	return container.ID
+}
+
+func removeContainer(ref string) error {
+	if err := docker.ContainerStop(ref, containerStopTimeout); err != nil {
+		return fmt.Errorf("unable to stop container %s: %s", ref, err)
+	}
+
+	if err := docker.ContainerRemove(ref, types.ContainerRemoveOptions{Force: true}); err != nil {
+		return fmt.Errorf("unable to remove container %s: %s", ref, err)
+	}
+
+	return nil
+}
+
+func (p *Project) containers(serviceNames []string) ([]types.Container, error) {
+	filterSet := filters.NewArgs()
+	filterSet.Add("label", fmt.Sprintf("%s=%s", ProjectLabel, p.Name))
+
+	containers, err := docker.Containers(types.ContainerListOptions{All: true, Filters: filterSet})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(serviceNames) == 0 {
+		return containers, nil
+	}
+
+	var res []types.Container
+	for _, container := range containers {
+		for _, serviceName := range serviceNames {
+			if container.Labels[ServiceLabel] == serviceName {
+				res = append(res, container)
+			}
+		}
+	}
+
+	return res, nil
+}
+
+type LogsOptions struct {
+	// Services to print logs, all services if empty
+	Services []string
+	Follow   bool
+	Tail     string
+}
+
+// Logs prints the logs of the services containers prefixed with the service name
+func (p *Project) Logs(out, errOut io.Writer, opts LogsOptions) error {
+	for _, serviceName := range opts.Services {
+		if _, ok := p.Config.Services[serviceName]; !ok {
+			return fmt.Errorf("service %s is not defined in the compose file", serviceName)
+		}
+	}
+
+	containers, err := p.containers(opts.Services)
+	if err != nil {
+		return err
+	}
+
+	if len(containers) == 0 {
+		return fmt.Errorf("no containers found: run werf compose up")
+	}
+
+	prefixWidth := 0
+	for _, container := range containers {
+		if len(container.Labels[ServiceLabel]) > prefixWidth {
+			prefixWidth = len(container.Labels[ServiceLabel])
+		}
+	}
+
+	outMutex := &sync.Mutex{}
+	errCh := make(chan error, len(containers))
+	wg := &sync.WaitGroup{}
+
+	for _, container := range containers {
+		wg.Add(1)
+		go func(container types.Container) {
+			defer wg.Done()
+
+			prefix := fmt.Sprintf("%-*s | ", prefixWidth, container.Labels[ServiceLabel])
+			errCh <- containerLogs(container.ID, newPrefixWriter(out, prefix, outMutex), newPrefixWriter(errOut, prefix, outMutex), opts)
+		}(container)
+	}
+
+	wg.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func containerLogs(containerID string, out, errOut *prefixWriter, opts LogsOptions) error {
+	rc, err := docker.ContainerLogs(containerID, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     opts.Follow,
+		Tail:       opts.Tail,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to get container %s logs: %s", containerID, err)
+	}
+	defer rc.Close()
+
+	// containers are created without tty, so the stream is multiplexed
+	if _, err := stdcopy.StdCopy(out, errOut, rc); err != nil {
+		return fmt.Errorf("unable to read container %s logs: %s", containerID, err)
+	}
+
+	out.Flush()
+	errOut.Flush()
+
+	return nil
+}
+
+// prefixWriter writes complete lines with the prefix, so the lines of the different containers are not mixed
+type prefixWriter struct {
+	out    io.Writer
+	prefix string
+	mutex  *sync.Mutex
+	buf    []byte
+}
+
+func newPrefixWriter(out io.Writer, prefix string, mutex *sync.Mutex) *prefixWriter {
+	return &prefixWriter{out: out, prefix: prefix, mutex: mutex}
+}
+
+func (w *prefixWriter) Write(data []byte) (int, error) {
+	w.buf = append(w.buf, data...)
+
+	for {
+		ind := bytes.IndexByte(w.buf, '\n')
+		if ind == -1 {
+			break
+		}
+
+		if err := w.writeLine(w.buf[:ind+1]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[ind+1:]
+	}
+
+	return len(data), nil
+}
+
+func (w *prefixWriter) Flush() {
+	if len(w.buf) != 0 {
+		_ = w.writeLine(append(w.buf, '\n'))
+		w.buf = nil
+	}
+}
+
+func (w *prefixWriter) writeLine(line []byte) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	bw := bufio.NewWriter(w.out)
+	if _, err := bw.WriteString(w.prefix); err != nil {
+		return err
+	}
+	if _, err := bw.Write(line); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,272 @@
+package compose
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/shlex"
+	"gopkg.in/yaml.v2"
+)
+
+const DefaultConfigFileName = "werf-compose.yaml"
+
+// Config is the docker-compose-like description of the services, which are running together locally
+type Config struct {
+	Services map[string]*Service `yaml:"services"`
+	Volumes  map[string]struct{} `yaml:"volumes,omitempty"`
+}
+
+// Service is either the external image (image) or the image from werf.yaml (werf_image)
+type Service struct {
+	Image       string       `yaml:"image,omitempty"`
+	WerfImage   *string      `yaml:"werf_image,omitempty"`
+	Command     stringOrList `yaml:"command,omitempty"`
+	Entrypoint  stringOrList `yaml:"entrypoint,omitempty"`
+	Environment environment  `yaml:"environment,omitempty"`
+	Ports       []string     `yaml:"ports,omitempty"`
+	Volumes     []string     `yaml:"volumes,omitempty"`
+	WorkingDir  string       `yaml:"working_dir,omitempty"`
+	User        string       `yaml:"user,omitempty"`
+	DependsOn   []string     `yaml:"depends_on,omitempty"`
+}
+
+func (s *Service) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Service
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	// werf_image: ~ is the nameless image as in werf.yaml
+	raw := map[string]interface{}{}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	if value, ok := raw["werf_image"]; ok && value == nil {
+		s.WerfImage = new(string)
+	}
+
+	return nil
+}
+
+// stringOrList is either the list of strings or the string, which is split by the shell words rules
+type stringOrList []string
+
+func (l *stringOrList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var str string
+	if err := unmarshal(&str); err == nil {
+		words, err := shlex.Split(str)
+		if err != nil {
+			return fmt.Errorf("unable to split %q into words: %s", str, err)
+		}
+		*l = words
+
+		return nil
+	}
+
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return fmt.Errorf("string or list of strings expected")
+	}
+	*l = list
+
+	return nil
+}
+
+// environment is either the map or the list of NAME=VALUE.
+// NAME without value is taken from the host environment and skipped if not set there
+type environment map[string]string
+
+func (e *environment) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	res := environment{}
+
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		for _, item := range list {
+			parts := strings.SplitN(item, "=", 2)
+			if len(parts) == 1 {
+				res.setFromHost(parts[0])
+			} else {
+				res[parts[0]] = parts[1]
+			}
+		}
+
+		*e = res
+		return nil
+	}
+
+	var m map[string]*string
+	if err := unmarshal(&m); err != nil {
+		return fmt.Errorf("map or list of NAME=VALUE expected")
+	}
+
+	for k, v := range m {
+		if v == nil {
+			res.setFromHost(k)
+		} else {
+			res[k] = *v
+		}
+	}
+	*e = res
+
+	return nil
+}
+
+func (e environment) setFromHost(name string) {
+	if value, ok := os.LookupEnv(name); ok {
+		e[name] = value
+	}
+}
+
+func (e environment) list() []string {
+	var res []string
+	for k, v := range e {
+		res = append(res, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+func ReadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read compose file %s: %s", path, err)
+	}
+
+	config, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("bad compose file %s: %s", path, err)
+	}
+
+	return config, nil
+}
+
+func parseConfig(data []byte) (*Config, error) {
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, err
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func (c *Config) validate() error {
+	if len(c.Services) == 0 {
+		return fmt.Errorf("no services defined")
+	}
+
+	for name, service := range c.Services {
+		if service == nil {
+			return fmt.Errorf("service %s: image or werf_image should be specified", name)
+		}
+
+		if service.Image == "" && service.WerfImage == nil {
+			return fmt.Errorf("service %s: image or werf_image should be specified", name)
+		} else if service.Image != "" && service.WerfImage != nil {
+			return fmt.Errorf("service %s: cannot use image and werf_image at the same time", name)
+		}
+
+		for _, dependency := range service.DependsOn {
+			if _, ok := c.Services[dependency]; !ok {
+				return fmt.Errorf("service %s: depends on unknown service %s", name, dependency)
+			}
+		}
+
+		for _, volume := range service.Volumes {
+			source, _, _ := parseVolume(volume)
+			if isNamedVolume(source) {
+				if _, ok := c.Volumes[source]; !ok {
+					return fmt.Errorf("service %s: named volume %s should be defined in the volumes section", name, source)
+				}
+			}
+		}
+	}
+
+	if _, err := c.ServicesInOrder(nil); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ServicesInOrder returns the specified services with their dependencies (all services if none specified),
+// dependencies go first
+func (c *Config) ServicesInOrder(serviceNames []string) ([]string, error) {
+	var names []string
+	if len(serviceNames) == 0 {
+		for name := range c.Services {
+			names = append(names, name)
+		}
+	} else {
+		names = append(names, serviceNames...)
+	}
+	sort.Strings(names)
+
+	var res []string
+	visited := map[string]bool{}
+	inProgress := map[string]bool{}
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		if visited[name] {
+			return nil
+		}
+
+		if inProgress[name] {
+			return fmt.Errorf("circular dependency of service %s", name)
+		}
+
+		service, ok := c.Services[name]
+		if !ok {
+			return fmt.Errorf("service %s is not defined in the compose file", name)
+		}
+
+		inProgress[name] = true
+		dependencies := append([]string{}, service.DependsOn...)
+		sort.Strings(dependencies)
+		for _, dependency := range dependencies {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		inProgress[name] = false
+
+		visited[name] = true
+		res = append(res, name)
+
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// parseVolume parses SOURCE:TARGET[:MODE] or TARGET of anonymous volume
+func parseVolume(volume string) (string, string, string) {
+	parts := strings.SplitN(volume, ":", 3)
+	switch len(parts) {
+	case 1:
+		return "", parts[0], ""
+	case 2:
+		return parts[0], parts[1], ""
+	default:
+		return parts[0], parts[1], parts[2]
+	}
+}
+
+func isNamedVolume(source string) bool {
+	return source != "" && !strings.HasPrefix(source, ".") && !strings.HasPrefix(source, "/") && !strings.HasPrefix(source, "~")
+}
@@ -0,0 +1,116 @@
+package compose
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("compose file", func() {
+	BeforeEach(func() {
+		Ω(os.Setenv("WERF_COMPOSE_TEST_DEBUG", "1")).Should(Succeed())
+		Ω(os.Unsetenv("WERF_COMPOSE_TEST_UNSET")).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		Ω(os.Unsetenv("WERF_COMPOSE_TEST_DEBUG")).Should(Succeed())
+	})
+
+	It("should parse services", func() {
+		config, err := parseConfig([]byte(`
+services:
+  db:
+    image: postgres:11
+    environment:
+      POSTGRES_PASSWORD: password
+    volumes:
+    - db-data:/var/lib/postgresql/data
+  backend:
+    werf_image: backend
+    command: /app/run.sh --debug
+    environment:
+    - DATABASE_URL=postgres://db
+    - WERF_COMPOSE_TEST_DEBUG
+    - WERF_COMPOSE_TEST_UNSET
+    depends_on:
+    - db
+  nameless:
+    werf_image: ~
+    entrypoint: ["/bin/sh", "-c"]
+volumes:
+  db-data:
+`))
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(config.Services["db"].Image).Should(Equal("postgres:11"))
+		Ω(config.Services["db"].WerfImage).Should(BeNil())
+		Ω(config.Services["db"].Environment.list()).Should(Equal([]string{"POSTGRES_PASSWORD=password"}))
+
+		Ω(*config.Services["backend"].WerfImage).Should(Equal("backend"))
+		Ω([]string(config.Services["backend"].Command)).Should(Equal([]string{"/app/run.sh", "--debug"}))
+		Ω(config.Services["backend"].Environment.list()).Should(Equal([]string{"DATABASE_URL=postgres://db", "WERF_COMPOSE_TEST_DEBUG=1"}))
+
+		Ω(*config.Services["nameless"].WerfImage).Should(Equal(""))
+		Ω([]string(config.Services["nameless"].Entrypoint)).Should(Equal([]string{"/bin/sh", "-c"}))
+	})
+
+	DescribeTable("command",
+		func(command string, expected []string) {
+			config, err := parseConfig([]byte(`services: {app: {image: alpine, command: ` + command + `}}`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω([]string(config.Services["app"].Command)).Should(Equal(expected))
+		},
+		Entry("string", `/app/run.sh --debug`, []string{"/app/run.sh", "--debug"}),
+		Entry("string with double quotes", `'sh -c "echo hi"'`, []string{"sh", "-c", "echo hi"}),
+		Entry("string with single quotes", `"sh -c 'echo hi && exit 1'"`, []string{"sh", "-c", "echo hi && exit 1"}),
+		Entry("string with escaped space", `'echo hello\ world'`, []string{"echo", "hello world"}),
+		Entry("list", `["sh", "-c", "echo hi"]`, []string{"sh", "-c", "echo hi"}),
+	)
+
+	DescribeTable("environment",
+		func(environment string, expected []string) {
+			config, err := parseConfig([]byte(`services: {app: {image: alpine, environment: ` + environment + `}}`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(config.Services["app"].Environment.list()).Should(Equal(expected))
+		},
+		Entry("list", `[A=1, B=]`, []string{"A=1", "B="}),
+		Entry("list with host variables", `[WERF_COMPOSE_TEST_DEBUG, WERF_COMPOSE_TEST_UNSET]`, []string{"WERF_COMPOSE_TEST_DEBUG=1"}),
+		Entry("map", `{A: "1", B: ""}`, []string{"A=1", "B="}),
+		Entry("map with host variables", `{WERF_COMPOSE_TEST_DEBUG: ~, WERF_COMPOSE_TEST_UNSET: ~}`, []string{"WERF_COMPOSE_TEST_DEBUG=1"}),
+	)
+
+	DescribeTable("should reject invalid configuration",
+		func(data, expectedError string) {
+			_, err := parseConfig([]byte(data))
+			Ω(err).Should(HaveOccurred())
+			Ω(err.Error()).Should(ContainSubstring(expectedError))
+		},
+		Entry("no services", `services: {}`, "no services defined"),
+		Entry("no image", `services: {app: {command: run}}`, "image or werf_image should be specified"),
+		Entry("both images", `services: {app: {image: alpine, werf_image: app}}`, "cannot use image and werf_image at the same time"),
+		Entry("unknown dependency", `services: {app: {image: alpine, depends_on: [db]}}`, "depends on unknown service db"),
+		Entry("undefined named volume", `services: {app: {image: alpine, volumes: ["data:/data"]}}`, "named volume data should be defined"),
+		Entry("circular dependency", `services: {a: {image: alpine, depends_on: [b]}, b: {image: alpine, depends_on: [a]}}`, "circular dependency"),
+		Entry("unterminated quote", `services: {app: {image: alpine, command: 'sh -c "echo'}}`, "unable to split"),
+		Entry("unknown directive", `services: {app: {image: alpine, restart: always}}`, "restart"),
+	)
+
+	DescribeTable("services order",
+		func(serviceNames, expectedOrder []string) {
+			config, err := parseConfig([]byte(`
+services:
+  db: {image: postgres}
+  queue: {image: rabbitmq}
+  backend: {image: backend, depends_on: [queue, db]}
+  frontend: {image: frontend, depends_on: [backend]}
+`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(config.ServicesInOrder(serviceNames)).Should(Equal(expectedOrder))
+		},
+		Entry("all services", []string(nil), []string{"db", "queue", "backend", "frontend"}),
+		Entry("service with dependencies", []string{"frontend"}, []string{"db", "queue", "backend", "frontend"}),
+		Entry("service without dependencies", []string{"queue"}, []string{"queue"}),
+	)
+})
@@ -0,0 +1,18 @@
+package config
+
+type DockerfileSecret struct {
+	Id  string
+	Src string
+
+	raw *rawDockerfileSecret
+}
+
+func (c *DockerfileSecret) validate() error {
+	if c.Id == "" {
+		return newDetailedConfigError("`id: ID` required for secret!", c.raw, c.raw.rawImageFromDockerfile.doc)
+	} else if c.Src == "" {
+		return newDetailedConfigError("`src: PATH` absolute or relative path required for secret!", c.raw, c.raw.rawImageFromDockerfile.doc)
+	}
+
+	return nil
+}
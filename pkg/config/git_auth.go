@@ -0,0 +1,29 @@
+package config
+
+type GitAuth struct {
+	Username         string
+	PasswordEnv      string
+	TokenEnv         string
+	CredentialHelper string
+	CaFile           string
+
+	raw *rawGitAuth
+}
+
+func (c *GitAuth) GetRaw() interface{} {
+	return c.raw
+}
+
+func (c *GitAuth) validate() error {
+	if !oneOrNone([]bool{c.PasswordEnv != "", c.TokenEnv != "", c.CredentialHelper != ""}) {
+		return newDetailedConfigError("specify only `passwordEnv: ENV_NAME`, `tokenEnv: ENV_NAME` or `credentialHelper: HELPER` for git auth!", c.raw, c.raw.rawGit.rawStapelImage.doc)
+	} else if c.PasswordEnv != "" && c.Username == "" {
+		return newDetailedConfigError("`username: USERNAME` is required with `passwordEnv: ENV_NAME`!", c.raw, c.raw.rawGit.rawStapelImage.doc)
+	} else if c.CredentialHelper != "" && c.Username != "" {
+		return newDetailedConfigError("`username: USERNAME` cannot be used with `credentialHelper: HELPER`: username is provided by credential helper!", c.raw, c.raw.rawGit.rawStapelImage.doc)
+	} else if c.PasswordEnv == "" && c.TokenEnv == "" && c.CredentialHelper == "" && c.Username != "" {
+		return newDetailedConfigError("`username: USERNAME` requires `passwordEnv: ENV_NAME` or `tokenEnv: ENV_NAME`!", c.raw, c.raw.rawGit.rawStapelImage.doc)
+	}
+
+	return nil
+}
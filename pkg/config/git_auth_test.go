@@ -0,0 +1,30 @@
+package config
+
+import (
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = DescribeTable("git auth validation",
+	func(gitAuth *GitAuth, expectedError string) {
+		gitAuth.raw = &rawGitAuth{rawGit: &rawGit{rawStapelImage: &rawStapelImage{doc: &doc{}}}}
+
+		err := gitAuth.validate()
+		if expectedError == "" {
+			Ω(err).ShouldNot(HaveOccurred())
+		} else {
+			Ω(err).Should(HaveOccurred())
+			Ω(err.Error()).Should(ContainSubstring(expectedError))
+		}
+	},
+	Entry("token", &GitAuth{TokenEnv: "TOKEN"}, ""),
+	Entry("token with username", &GitAuth{Username: "user", TokenEnv: "TOKEN"}, ""),
+	Entry("password with username", &GitAuth{Username: "user", PasswordEnv: "PASSWORD"}, ""),
+	Entry("credential helper", &GitAuth{CredentialHelper: "store"}, ""),
+	Entry("CA file only", &GitAuth{CaFile: "ca.pem"}, ""),
+	Entry("password without username", &GitAuth{PasswordEnv: "PASSWORD"}, "`username: USERNAME` is required"),
+	Entry("password and token", &GitAuth{Username: "user", PasswordEnv: "PASSWORD", TokenEnv: "TOKEN"}, "specify only"),
+	Entry("token and credential helper", &GitAuth{TokenEnv: "TOKEN", CredentialHelper: "store"}, "specify only"),
+	Entry("credential helper with username", &GitAuth{Username: "user", CredentialHelper: "store"}, "cannot be used with `credentialHelper: HELPER`"),
+	Entry("username only", &GitAuth{Username: "user"}, "requires `passwordEnv: ENV_NAME` or `tokenEnv: ENV_NAME`"),
+)
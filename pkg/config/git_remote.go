@@ -1,9 +1,16 @@
 package config
 
+import (
+	"strings"
+)
+
 type GitRemote struct {
 	*GitRemoteExport
-	Name string
-	Url  string
+	Name         string
+	Url          string
+	Shallow      bool
+	PartialClone bool
+	Auth         *GitAuth
 
 	raw *rawGit
 }
@@ -13,5 +20,9 @@ func (c *GitRemote) GetRaw() interface{} {
 }
 
 func (c *GitRemote) validate() error {
+	if c.Auth != nil && !strings.HasPrefix(c.Url, "https://") && !strings.HasPrefix(c.Url, "http://") {
+		return newDetailedConfigError("`auth` can be specified only for git repo with http or https url!", c.raw, c.raw.rawStapelImage.doc)
+	}
+
 	return nil
 }
@@ -1,5 +1,9 @@
 package config
 
+import (
+	"fmt"
+)
+
 type ImageFromDockerfile struct {
 	Name       string
 	Dockerfile string
@@ -7,10 +11,66 @@ type ImageFromDockerfile struct {
 	Target     string
 	Args       map[string]interface{}
 	AddHost    []string
+	Builder    string
+	Secrets    []*DockerfileSecret
+	SSH        bool
+
+	SplitStages string
 
 	raw *rawImageFromDockerfile
 }
 
+const (
+	DockerfileDockerBuilder   = "docker"
+	DockerfileBuildKitBuilder = "buildkit"
+
+	DockerfileSplitByInstruction = "instruction"
+	DockerfileSplitByStage       = "stage"
+)
+
 func (c *ImageFromDockerfile) GetName() string {
 	return c.Name
 }
+
+func (c *ImageFromDockerfile) IsBuildKit() bool {
+	return c.Builder == DockerfileBuildKitBuilder
+}
+
+// IsDockerBuilder is true when the legacy docker builder is specified explicitly,
+// the default builder (DOCKER_BUILDKIT or the docker daemon default) is used when the builder is not specified
+func (c *ImageFromDockerfile) IsDockerBuilder() bool {
+	return c.Builder == DockerfileDockerBuilder
+}
+
+func (c *ImageFromDockerfile) validate() error {
+	if c.Builder != "" && c.Builder != DockerfileDockerBuilder && c.Builder != DockerfileBuildKitBuilder {
+		return newDetailedConfigError(fmt.Sprintf("invalid `builder: %s`: expected `%s` or `%s`!", c.Builder, DockerfileDockerBuilder, DockerfileBuildKitBuilder), c.raw, c.raw.doc)
+	}
+
+	if !c.IsBuildKit() {
+		if len(c.Secrets) != 0 {
+			return newDetailedConfigError(fmt.Sprintf("`secrets` can be used only with `builder: %s`!", DockerfileBuildKitBuilder), c.raw, c.raw.doc)
+		}
+
+		if c.SSH {
+			return newDetailedConfigError(fmt.Sprintf("`ssh: true` can be used only with `builder: %s`!", DockerfileBuildKitBuilder), c.raw, c.raw.doc)
+		}
+	}
+
+	if c.SplitStages != "" && c.SplitStages != DockerfileSplitByInstruction && c.SplitStages != DockerfileSplitByStage {
+		return newDetailedConfigError(fmt.Sprintf("invalid `splitStages: %s`: expected `%s` or `%s`!", c.SplitStages, DockerfileSplitByInstruction, DockerfileSplitByStage), c.raw, c.raw.doc)
+	}
+
+	var secretsIds []string
+	for _, secret := range c.Secrets {
+		for _, id := range secretsIds {
+			if id == secret.Id {
+				return newDetailedConfigError(fmt.Sprintf("duplicate secret `id: %s`!", secret.Id), c.raw, c.raw.doc)
+			}
+		}
+
+		secretsIds = append(secretsIds, secret.Id)
+	}
+
+	return nil
+}
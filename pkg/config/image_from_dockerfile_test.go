@@ -0,0 +1,39 @@
+package config
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dockerfile image builder", func() {
+	DescribeTable("validation",
+		func(image *ImageFromDockerfile, expectedErr string) {
+			image.raw = &rawImageFromDockerfile{doc: &doc{}}
+
+			err := image.validate()
+			if expectedErr == "" {
+				Ω(err).ShouldNot(HaveOccurred())
+			} else {
+				Ω(err).Should(HaveOccurred())
+				Ω(err.Error()).Should(ContainSubstring(expectedErr))
+			}
+		},
+		Entry("default builder", &ImageFromDockerfile{}, ""),
+		Entry("docker builder", &ImageFromDockerfile{Builder: "docker"}, ""),
+		Entry("buildkit builder with secrets and ssh", &ImageFromDockerfile{Builder: "buildkit", Secrets: []*DockerfileSecret{{Id: "npmrc", Src: ".npmrc"}}, SSH: true}, ""),
+		Entry("unknown builder", &ImageFromDockerfile{Builder: "kaniko"}, "invalid `builder: kaniko`"),
+		Entry("secrets with the default builder", &ImageFromDockerfile{Secrets: []*DockerfileSecret{{Id: "npmrc", Src: ".npmrc"}}}, "`secrets` can be used only with `builder: buildkit`"),
+		Entry("secrets with docker builder", &ImageFromDockerfile{Builder: "docker", Secrets: []*DockerfileSecret{{Id: "npmrc", Src: ".npmrc"}}}, "`secrets` can be used only with `builder: buildkit`"),
+		Entry("ssh with docker builder", &ImageFromDockerfile{Builder: "docker", SSH: true}, "`ssh: true` can be used only with `builder: buildkit`"),
+		Entry("duplicate secrets", &ImageFromDockerfile{Builder: "buildkit", Secrets: []*DockerfileSecret{{Id: "npmrc", Src: ".npmrc"}, {Id: "npmrc", Src: "other"}}}, "duplicate secret `id: npmrc`"),
+	)
+
+	It("does not select the builder when it is not specified", func() {
+		image, err := (&rawImageFromDockerfile{doc: &doc{}}).toImageFromDockerfileDirective("app")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(image.Builder).Should(BeEmpty())
+		Ω(image.IsBuildKit()).Should(BeFalse())
+		Ω(image.IsDockerBuilder()).Should(BeFalse())
+	})
+})
@@ -5,9 +5,13 @@ import (
 )
 
 type Mount struct {
-	To   string
-	From string
-	Type string
+	To      string
+	From    string
+	Type    string
+	Id      string
+	Env     string
+	Sharing string
+	Stage   string
 
 	raw *rawMount
 }
@@ -19,8 +23,35 @@ func (c *Mount) validate() error {
 		if c.From == "" {
 			return newDetailedConfigError("`fromPath: PATH` absolute or relative path required for mount!", c.raw, c.raw.rawStapelImage.doc)
 		}
+	} else if c.Type == "secret" {
+		if c.Id == "" {
+			return newDetailedConfigError("`id: ID` required for secret mount!", c.raw, c.raw.rawStapelImage.doc)
+		} else if !oneOrNone([]bool{c.From != "", c.Env != ""}) || (c.From == "" && c.Env == "") {
+			return newDetailedConfigError("`fromPath: PATH` to the encrypted file or `env: ENV_NAME` required for secret mount!", c.raw, c.raw.rawStapelImage.doc)
+		} else if c.Stage != "beforeInstall" && c.Stage != "install" && c.Stage != "beforeSetup" && c.Stage != "setup" {
+			return newDetailedConfigError(fmt.Sprintf("invalid `stage: %s` for secret mount: expected `beforeInstall`, `install`, `beforeSetup` or `setup`!", c.Stage), c.raw, c.raw.rawStapelImage.doc)
+		}
+	} else if c.Type == "cache" {
+		if c.Id == "" {
+			return newDetailedConfigError("`id: ID` required for cache mount!", c.raw, c.raw.rawStapelImage.doc)
+		} else if c.Sharing != "shared" && c.Sharing != "locked" && c.Sharing != "private" {
+			return newDetailedConfigError(fmt.Sprintf("invalid `sharing: %s` for cache mount: expected `shared`, `locked` or `private`!", c.Sharing), c.raw, c.raw.rawStapelImage.doc)
+		}
 	} else if c.Type != "tmp_dir" && c.Type != "build_dir" {
-		return newDetailedConfigError(fmt.Sprintf("invalid `from: %s` for mount: expected `tmp_dir` or `build_dir`!", c.Type), c.raw, c.raw.rawStapelImage.doc)
+		return newDetailedConfigError(fmt.Sprintf("invalid `from: %s` for mount: expected `tmp_dir`, `build_dir`, `cache` or `secret`!", c.Type), c.raw, c.raw.rawStapelImage.doc)
 	}
+
+	if c.Type != "secret" && c.Type != "cache" && c.Id != "" {
+		return newDetailedConfigError("`id` can be used only for `from: secret` and `from: cache` mounts!", c.raw, c.raw.rawStapelImage.doc)
+	}
+
+	if c.Type != "secret" && c.Env != "" {
+		return newDetailedConfigError("`env` can be used only for `from: secret` mount!", c.raw, c.raw.rawStapelImage.doc)
+	}
+
+	if c.Type != "secret" && c.Stage != "" {
+		return newDetailedConfigError("`stage` can be used only for `from: secret` mount!", c.raw, c.raw.rawStapelImage.doc)
+	}
+
 	return nil
 }
@@ -0,0 +1,31 @@
+package config
+
+import (
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = DescribeTable("mount validation",
+	func(raw *rawMount, expectedErr string) {
+		raw.rawStapelImage = &rawStapelImage{doc: &doc{}}
+
+		_, err := raw.toDirective()
+		if expectedErr == "" {
+			Ω(err).ShouldNot(HaveOccurred())
+		} else {
+			Ω(err).Should(HaveOccurred())
+			Ω(err.Error()).Should(ContainSubstring(expectedErr))
+		}
+	},
+	Entry("secret from the encrypted file", &rawMount{From: "secret", Id: "npmrc", FromPath: ".werf/npmrc", Stage: "install", To: "/root/.npmrc"}, ""),
+	Entry("secret from the env", &rawMount{From: "secret", Id: "token", Env: "TOKEN", Stage: "setup", To: "/run/secrets/token"}, ""),
+	Entry("secret without id", &rawMount{From: "secret", Env: "TOKEN", Stage: "setup", To: "/run/secrets/token"}, "`id: ID` required for secret mount"),
+	Entry("secret without source", &rawMount{From: "secret", Id: "token", Stage: "setup", To: "/run/secrets/token"}, "`fromPath: PATH` to the encrypted file or `env: ENV_NAME` required"),
+	Entry("secret with both sources", &rawMount{From: "secret", Id: "token", FromPath: ".werf/token", Env: "TOKEN", Stage: "setup", To: "/run/secrets/token"}, "`fromPath: PATH` to the encrypted file or `env: ENV_NAME` required"),
+	Entry("secret without stage", &rawMount{From: "secret", Id: "token", Env: "TOKEN", To: "/run/secrets/token"}, "invalid `stage: ` for secret mount"),
+	Entry("secret for the non-user stage", &rawMount{From: "secret", Id: "token", Env: "TOKEN", Stage: "gitArchive", To: "/run/secrets/token"}, "invalid `stage: gitArchive` for secret mount"),
+	Entry("secret with relative mountpoint", &rawMount{From: "secret", Id: "token", Env: "TOKEN", Stage: "setup", To: "run/secrets/token"}, "`to: PATH` absolute path required"),
+	Entry("env for the non-secret mount", &rawMount{From: "tmp_dir", Env: "TOKEN", To: "/tmp"}, "`env` can be used only for `from: secret` mount"),
+	Entry("stage for the non-secret mount", &rawMount{From: "tmp_dir", Stage: "install", To: "/tmp"}, "`stage` can be used only for `from: secret` mount"),
+	Entry("id for the custom dir mount", &rawMount{FromPath: "/data", Id: "data", To: "/data"}, "`id` can be used only for `from: secret` and `from: cache` mounts"),
+)
@@ -32,7 +32,7 @@ func RenderWerfConfig(werfConfigPath string, imagesToProcess []string) error {
 	}
 
 	if len(imagesToProcess) == 0 {
-		werfConfigRenderContent, err := parseWerfConfigYaml(werfConfigPath)
+		werfConfigRenderContent, _, err := parseWerfConfigYaml(werfConfigPath)
 		if err != nil {
 			return fmt.Errorf("cannot parse config: %s", err)
 		}
@@ -62,7 +62,7 @@ func RenderWerfConfig(werfConfigPath string, imagesToProcess []string) error {
 }
 
 func GetWerfConfig(werfConfigPath string, logRenderedFilePath bool) (*WerfConfig, error) {
-	werfConfigRenderContent, err := parseWerfConfigYaml(werfConfigPath)
+	werfConfigRenderContent, templateFiles, err := parseWerfConfigYaml(werfConfigPath)
 	if err != nil {
 		return nil, fmt.Errorf("cannot parse config: %s", err)
 	}
@@ -117,6 +117,7 @@ func GetWerfConfig(werfConfigPath string, logRenderedFilePath bool) (*WerfConfig
 	if err != nil {
 		return nil, err
 	}
+	werfConfig.TemplateFiles = templateFiles
 
 	return werfConfig, nil
 }
@@ -202,10 +203,11 @@ func splitByDocs(werfConfigRenderContent string, werfConfigRenderPath string) ([
 	return docs, nil
 }
 
-func parseWerfConfigYaml(werfConfigPath string) (string, error) {
+// parseWerfConfigYaml renders werf.yaml and returns the files read by .Files.Get
+func parseWerfConfigYaml(werfConfigPath string) (string, []string, error) {
 	data, err := ioutil.ReadFile(werfConfigPath)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	tmpl := template.New("werfConfig")
@@ -215,37 +217,37 @@ func parseWerfConfigYaml(werfConfigPath string) (string, error) {
 	werfConfigsDir := filepath.Join(projectDir, ".werf")
 	werfConfigsTemplates, err := getWerfConfigsTemplates(werfConfigsDir)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	if len(werfConfigsTemplates) != 0 {
 		for _, templatePath := range werfConfigsTemplates {
 			templateName, err := filepath.Rel(werfConfigsDir, templatePath)
 			if err != nil {
-				return "", err
+				return "", nil, err
 			}
 
 			extraTemplate := tmpl.New(templateName)
 
 			var filePathData []byte
 			if filePathData, err = ioutil.ReadFile(templatePath); err != nil {
-				return "", err
+				return "", nil, err
 			}
 
 			if _, err := extraTemplate.Parse(string(filePathData)); err != nil {
-				return "", err
+				return "", nil, err
 			}
 		}
 	}
 
 	if _, err := tmpl.Parse(string(data)); err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	files := files{filepath.Dir(werfConfigPath)}
+	files := &files{HomePath: filepath.Dir(werfConfigPath)}
 	config, err := executeTemplate(tmpl, "werfConfig", map[string]interface{}{"Files": files})
 
-	return config, err
+	return config, files.usedPaths, err
 }
 
 func getWerfConfigsTemplates(path string) ([]string, error) {
@@ -300,9 +302,15 @@ func executeTemplate(tmpl *template.Template, name string, data interface{}) (st
 
 type files struct {
 	HomePath string
+
+	usedPaths []string
 }
 
-func (f files) Get(path string) string {
+func (f *files) Get(path string) string {
+	if !util.IsStringsContainValue(f.usedPaths, filepath.Clean(path)) {
+		f.usedPaths = append(f.usedPaths, filepath.Clean(path))
+	}
+
 	filePath := filepath.Join(f.HomePath, path)
 
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
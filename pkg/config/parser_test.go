@@ -0,0 +1,48 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("werf config rendering", func() {
+	var projectDir string
+
+	writeFile := func(path, content string) {
+		path = filepath.Join(projectDir, path)
+		Ω(os.MkdirAll(filepath.Dir(path), os.ModePerm)).Should(Succeed())
+		Ω(ioutil.WriteFile(path, []byte(content), 0644)).Should(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		projectDir, err = ioutil.TempDir("", "werf-config-test")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Ω(os.RemoveAll(projectDir)).Should(Succeed())
+	})
+
+	It("returns files read by .Files.Get in werf.yaml and templates", func() {
+		writeFile("config/version", "1.0.0")
+		writeFile("config/packages", "curl")
+		writeFile(".werf/packages.tmpl", `{{ define "packages" }}{{ .Files.Get "./config/packages" }}{{ end }}`)
+		writeFile("werf.yaml", `project: app
+configVersion: 1
+version: {{ .Files.Get "config/version" }}
+again: {{ .Files.Get "config/version" }}
+packages: {{ include "packages" . }}
+`)
+
+		content, templateFiles, err := parseWerfConfigYaml(filepath.Join(projectDir, "werf.yaml"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(content).Should(ContainSubstring("version: 1.0.0"))
+		Ω(content).Should(ContainSubstring("packages: curl"))
+		Ω(templateFiles).Should(Equal([]string{"config/version", "config/packages"}))
+	})
+})
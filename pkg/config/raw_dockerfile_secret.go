@@ -0,0 +1,41 @@
+package config
+
+type rawDockerfileSecret struct {
+	Id  string `yaml:"id,omitempty"`
+	Src string `yaml:"src,omitempty"`
+
+	rawImageFromDockerfile *rawImageFromDockerfile `yaml:"-"` // parent
+
+	UnsupportedAttributes map[string]interface{} `yaml:",inline"`
+}
+
+func (c *rawDockerfileSecret) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if parent, ok := parentStack.Peek().(*rawImageFromDockerfile); ok {
+		c.rawImageFromDockerfile = parent
+	}
+
+	type plain rawDockerfileSecret
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+
+	if err := checkOverflow(c.UnsupportedAttributes, c, c.rawImageFromDockerfile.doc); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *rawDockerfileSecret) toDirective() (secret *DockerfileSecret, err error) {
+	secret = &DockerfileSecret{}
+	secret.Id = c.Id
+	secret.Src = c.Src
+
+	secret.raw = c
+
+	if err := secret.validate(); err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
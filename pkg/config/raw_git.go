@@ -10,6 +10,9 @@ type rawGit struct {
 	Branch               string                `yaml:"branch,omitempty"`
 	Tag                  string                `yaml:"tag,omitempty"`
 	Commit               string                `yaml:"commit,omitempty"`
+	Shallow              bool                  `yaml:"shallow,omitempty"`
+	PartialClone         bool                  `yaml:"partialClone,omitempty"`
+	RawAuth              *rawGitAuth           `yaml:"auth,omitempty"`
 	RawStageDependencies *rawStageDependencies `yaml:"stageDependencies,omitempty"`
 
 	rawStapelImage *rawStapelImage `yaml:"-"` // parent
@@ -78,6 +81,14 @@ func (c *rawGit) validateGitLocalDirective(gitLocal *GitLocal) (err error) {
 		return newDetailedConfigError("specify `branch: BRANCH`, `tag: TAG` and `commit: COMMIT` only for remote git!", nil, c.rawStapelImage.doc)
 	}
 
+	if c.Shallow || c.PartialClone {
+		return newDetailedConfigError("specify `shallow: true` and `partialClone: true` only for remote git!", nil, c.rawStapelImage.doc)
+	}
+
+	if c.RawAuth != nil {
+		return newDetailedConfigError("specify `auth` only for remote git!", nil, c.rawStapelImage.doc)
+	}
+
 	if err := gitLocal.validate(); err != nil {
 		return err
 	}
@@ -131,6 +142,17 @@ func (c *rawGit) toGitRemoteDirective() (gitRemote *GitRemote, err error) {
 
 	gitRemote.Url = c.Url
 	gitRemote.Name = getRepositoryID(c.Url)
+	gitRemote.Shallow = c.Shallow
+	gitRemote.PartialClone = c.PartialClone
+
+	if c.RawAuth != nil {
+		if auth, err := c.RawAuth.toDirective(); err != nil {
+			return nil, err
+		} else {
+			gitRemote.Auth = auth
+		}
+	}
+
 	gitRemote.raw = c
 
 	if err := c.validateGitRemoteDirective(gitRemote); err != nil {
@@ -0,0 +1,54 @@
+package config
+
+type rawGitAuth struct {
+	Username         string `yaml:"username,omitempty"`
+	PasswordEnv      string `yaml:"passwordEnv,omitempty"`
+	TokenEnv         string `yaml:"tokenEnv,omitempty"`
+	CredentialHelper string `yaml:"credentialHelper,omitempty"`
+	CaFile           string `yaml:"caFile,omitempty"`
+
+	rawGit *rawGit `yaml:"-"` // parent
+
+	UnsupportedAttributes map[string]interface{} `yaml:",inline"`
+}
+
+func (c *rawGitAuth) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if parent, ok := parentStack.Peek().(*rawGit); ok {
+		c.rawGit = parent
+	}
+
+	type plain rawGitAuth
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+
+	if err := checkOverflow(c.UnsupportedAttributes, c, c.rawGit.rawStapelImage.doc); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *rawGitAuth) toDirective() (gitAuth *GitAuth, err error) {
+	gitAuth = &GitAuth{}
+	gitAuth.Username = c.Username
+	gitAuth.PasswordEnv = c.PasswordEnv
+	gitAuth.TokenEnv = c.TokenEnv
+	gitAuth.CredentialHelper = c.CredentialHelper
+	gitAuth.CaFile = c.CaFile
+	gitAuth.raw = c
+
+	if err := c.validateDirective(gitAuth); err != nil {
+		return nil, err
+	}
+
+	return gitAuth, nil
+}
+
+func (c *rawGitAuth) validateDirective(gitAuth *GitAuth) error {
+	if err := gitAuth.validate(); err != nil {
+		return err
+	}
+
+	return nil
+}
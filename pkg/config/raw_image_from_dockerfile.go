@@ -11,6 +11,11 @@ type rawImageFromDockerfile struct {
 	Target     string                 `yaml:"target,omitempty"`
 	Args       map[string]interface{} `yaml:"args,omitempty"`
 	AddHost    interface{}            `yaml:"addHost,omitempty"`
+	Builder    string                 `yaml:"builder,omitempty"`
+	Secrets    []*rawDockerfileSecret `yaml:"secrets,omitempty"`
+	SSH        bool                   `yaml:"ssh,omitempty"`
+
+	SplitStages string `yaml:"splitStages,omitempty"`
 
 	doc *doc `yaml:"-"` // parent
 
@@ -87,7 +92,24 @@ func (c *rawImageFromDockerfile) toImageFromDockerfileDirective(imageName string
 		image.AddHost = addHost
 	}
 
+	image.Builder = c.Builder
+
+	for _, rawSecret := range c.Secrets {
+		if secret, err := rawSecret.toDirective(); err != nil {
+			return nil, err
+		} else {
+			image.Secrets = append(image.Secrets, secret)
+		}
+	}
+
+	image.SSH = c.SSH
+	image.SplitStages = c.SplitStages
+
 	image.raw = c
 
+	if err := image.validate(); err != nil {
+		return nil, err
+	}
+
 	return image, nil
 }
@@ -6,6 +6,10 @@ type rawMount struct {
 	To       string `yaml:"to,omitempty"`
 	From     string `yaml:"from,omitempty"`
 	FromPath string `yaml:"fromPath,omitempty"`
+	Id       string `yaml:"id,omitempty"`
+	Env      string `yaml:"env,omitempty"`
+	Sharing  string `yaml:"sharing,omitempty"`
+	Stage    string `yaml:"stage,omitempty"`
 
 	rawStapelImage *rawStapelImage `yaml:"-"` // parent
 
@@ -33,6 +37,10 @@ func (c *rawMount) toDirective() (mount *Mount, err error) {
 	mount = &Mount{}
 	mount.To = c.To
 	mount.From = c.FromPath
+	mount.Id = c.Id
+	mount.Env = c.Env
+	mount.Sharing = c.Sharing
+	mount.Stage = c.Stage
 
 	if c.From == "" {
 		mount.Type = "custom_dir"
@@ -40,6 +48,10 @@ func (c *rawMount) toDirective() (mount *Mount, err error) {
 		mount.Type = c.From
 	}
 
+	if mount.Type == "cache" && mount.Sharing == "" {
+		mount.Sharing = "shared"
+	}
+
 	mount.raw = c
 
 	if err := c.validateDirective(mount); err != nil {
@@ -50,7 +62,11 @@ func (c *rawMount) toDirective() (mount *Mount, err error) {
 }
 
 func (c *rawMount) validateDirective(mount *Mount) (err error) {
-	if c.From != "" && c.FromPath != "" {
+	if c.Sharing != "" && c.From != "cache" {
+		return newDetailedConfigError("`sharing` can be used only for `from: cache` mount!", c, c.rawStapelImage.doc)
+	}
+
+	if c.From != "" && c.From != "secret" && c.FromPath != "" {
 		return newDetailedConfigError(fmt.Sprintf("cannot use `from: %s` and `fromPath: %s` at the same time for mount!", c.From, c.FromPath), c, c.rawStapelImage.doc)
 	}
 
@@ -11,6 +11,9 @@ type WerfConfig struct {
 	StapelImages         []*StapelImage
 	ImagesFromDockerfile []*ImageFromDockerfile
 	Artifacts            []*StapelImageArtifact
+
+	// TemplateFiles are the files read by .Files.Get in werf.yaml, paths are relative to the project dir
+	TemplateFiles []string
 }
 
 func (c *WerfConfig) HasImageOrArtifact(imageName string) bool {
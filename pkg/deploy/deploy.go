@@ -17,7 +17,9 @@ import (
 
 	"github.com/flant/werf/pkg/config"
 	"github.com/flant/werf/pkg/deploy/helm"
+	"github.com/flant/werf/pkg/deploy/secret"
 	"github.com/flant/werf/pkg/deploy/werf_chart"
+	"github.com/flant/werf/pkg/image_signing"
 	"github.com/flant/werf/pkg/tag_strategy"
 )
 
@@ -32,6 +34,7 @@ type DeployOptions struct {
 	UserExtraLabels      map[string]string
 	IgnoreSecretKey      bool
 	ThreeWayMergeMode    helm.ThreeWayMergeModeType
+	ImageVerifier        *image_signing.Verifier // images signatures are not verified if nil
 }
 
 type ImagesRepoManager interface {
@@ -41,6 +44,67 @@ type ImagesRepoManager interface {
 }
 
 func Deploy(projectDir string, imagesRepoManager ImagesRepoManager, release, namespace, tag string, tagStrategy tag_strategy.TagStrategy, werfConfig *config.WerfConfig, helmReleaseStorageNamespace, helmReleaseStorageType string, opts DeployOptions) error {
+	projectChartDir := filepath.Join(projectDir, werf_chart.ProjectHelmChartDirName)
+
+	return deployChart(werfConfig.Meta.Project, projectChartDir, release, namespace, helmReleaseStorageNamespace, helmReleaseStorageType, func() (secret.Manager, map[string]interface{}, error) {
+		images := GetImagesInfoGetters(werfConfig.StapelImages, werfConfig.ImagesFromDockerfile, imagesRepoManager, tag, false)
+
+		if opts.ImageVerifier != nil {
+			var err error
+			if images, err = verifyImages(images, imagesRepoManager, opts.ImageVerifier); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		m, err := GetSafeSecretManager(projectDir, opts.SecretValues, opts.IgnoreSecretKey)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		serviceValues, err := GetServiceValues(werfConfig.Meta.Project, imagesRepoManager, namespace, tag, tagStrategy, images, ServiceValuesOptions{Env: opts.Env})
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating service values: %s", err)
+		}
+
+		return m, serviceValues, nil
+	}, opts)
+}
+
+// verifyImages returns the images pinned to the verified digests, so the deployed images cannot be changed after the verification
+func verifyImages(images []ImageInfoGetter, imagesRepoManager ImagesRepoManager, verifier *image_signing.Verifier) ([]ImageInfoGetter, error) {
+	var res []ImageInfoGetter
+
+	err := logboek.LogProcess("Verifying images signatures", logboek.LogProcessOptions{}, func() error {
+		for _, image := range images {
+			digest, err := verifier.Verify(image.GetImageName())
+			if err != nil {
+				return fmt.Errorf("image %s verification failed: %s", image.GetImageName(), err)
+			}
+
+			verifiedImage := &VerifiedImageInfo{
+				ImageInfoGetter: image,
+				Repo:            imagesRepoManager.ImageRepo(image.GetName()),
+				Digest:          digest,
+			}
+			res = append(res, verifiedImage)
+
+			logboek.LogInfoF("%s: verified %s\n", image.GetImageName(), verifiedImage.GetImageName())
+		}
+
+		return nil
+	})
+
+	return res, err
+}
+
+// DeployChart deploys the prepared chart with the specified service values (e.g. the chart of the deployment bundle)
+func DeployChart(projectName, chartDir string, m secret.Manager, serviceValues map[string]interface{}, release, namespace, helmReleaseStorageNamespace, helmReleaseStorageType string, opts DeployOptions) error {
+	return deployChart(projectName, chartDir, release, namespace, helmReleaseStorageNamespace, helmReleaseStorageType, func() (secret.Manager, map[string]interface{}, error) {
+		return m, serviceValues, nil
+	}, opts)
+}
+
+func deployChart(projectName, chartDir, release, namespace, helmReleaseStorageNamespace, helmReleaseStorageType string, getSecretManagerAndServiceValues func() (secret.Manager, map[string]interface{}, error), opts DeployOptions) error {
 	var logBlockErr error
 	var werfChart *werf_chart.WerfChart
 
@@ -53,27 +117,18 @@ func Deploy(projectDir string, imagesRepoManager ImagesRepoManager, release, nam
 		logboek.LogF("Using helm release name: %s\n", release)
 		logboek.LogF("Using Kubernetes namespace: %s\n", namespace)
 
-		images := GetImagesInfoGetters(werfConfig.StapelImages, werfConfig.ImagesFromDockerfile, imagesRepoManager, tag, false)
-
-		m, err := GetSafeSecretManager(projectDir, opts.SecretValues, opts.IgnoreSecretKey)
+		m, serviceValues, err := getSecretManagerAndServiceValues()
 		if err != nil {
 			logBlockErr = err
 			return
 		}
 
-		serviceValues, err := GetServiceValues(werfConfig.Meta.Project, imagesRepoManager, namespace, tag, tagStrategy, images, ServiceValuesOptions{Env: opts.Env})
-		if err != nil {
-			logBlockErr = fmt.Errorf("error creating service values: %s", err)
-			return
-		}
-
 		serviceValuesRaw, _ := yaml.Marshal(serviceValues)
 		logboek.LogLn()
 		logboek.LogLn("Using service values:")
 		logboek.LogLn(logboek.FitText(string(serviceValuesRaw), logboek.FitTextOptions{ExtraIndentWidth: 2}))
 
-		projectChartDir := filepath.Join(projectDir, werf_chart.ProjectHelmChartDirName)
-		werfChart, err = PrepareWerfChart(werfConfig.Meta.Project, projectChartDir, opts.Env, m, opts.SecretValues, serviceValues)
+		werfChart, err = PrepareWerfChart(projectName, chartDir, opts.Env, m, opts.SecretValues, serviceValues)
 		if err != nil {
 			logBlockErr = err
 			return
@@ -1,6 +1,8 @@
 package deploy
 
 import (
+	"fmt"
+
 	"github.com/flant/logboek"
 	"github.com/flant/werf/pkg/docker_registry"
 )
@@ -77,3 +79,29 @@ func (d *ImageInfo) GetImageDigest() (string, error) {
 
 	return res, nil
 }
+
+// VerifiedImageInfo is the image pinned to the verified digest
+type VerifiedImageInfo struct {
+	ImageInfoGetter
+	Repo   string
+	Digest string
+}
+
+func (d *VerifiedImageInfo) GetImageName() string {
+	return fmt.Sprintf("%s@%s", d.Repo, d.Digest)
+}
+
+func (d *VerifiedImageInfo) GetImageId() (string, error) {
+	imageName := d.GetImageName()
+
+	res, err := docker_registry.ImageId(imageName)
+	if err != nil {
+		return "", fmt.Errorf("unable to get image %s id: %s", imageName, err)
+	}
+
+	return res, nil
+}
+
+func (d *VerifiedImageInfo) GetImageDigest() (string, error) {
+	return d.Digest, nil
+}
@@ -0,0 +1,202 @@
+package dev
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/flant/werf/pkg/true_git"
+	"github.com/flant/werf/pkg/util"
+)
+
+// alwaysWatchedFiles are watched even if ignored by .gitignore
+var alwaysWatchedFiles = []string{"werf.yaml"}
+
+type WatcherOptions struct {
+	// Interval is the period of the project files checks
+	Interval time.Duration
+	// Debounce is the period without new changes after which the changes are considered complete
+	Debounce time.Duration
+}
+
+// Watcher detects changes of the project files by periodic snapshots of files state,
+// files ignored by .gitignore are not watched in the git repo
+type Watcher struct {
+	projectDir string
+	opts       WatcherOptions
+
+	snapshot snapshot
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+	mode    os.FileMode
+}
+
+type snapshot map[string]fileState
+
+func NewWatcher(projectDir string, opts WatcherOptions) *Watcher {
+	return &Watcher{projectDir: projectDir, opts: opts}
+}
+
+// Init takes the initial snapshot, changes are detected relative to it
+func (w *Watcher) Init() error {
+	s, err := w.takeSnapshot()
+	if err != nil {
+		return err
+	}
+
+	w.snapshot = s
+
+	return nil
+}
+
+// WaitForChanges blocks until the project files are changed and are not changed anymore during the debounce period,
+// changed paths relative to the project dir are returned. The context error is returned if the context is done
+func (w *Watcher) WaitForChanges(ctx context.Context) ([]string, error) {
+	for {
+		if err := sleep(ctx, w.opts.Interval); err != nil {
+			return nil, err
+		}
+
+		current, err := w.takeSnapshot()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(diffSnapshots(w.snapshot, current)) == 0 {
+			continue
+		}
+
+		debounceCheckInterval := w.opts.Interval
+		if w.opts.Debounce < debounceCheckInterval {
+			debounceCheckInterval = w.opts.Debounce
+		}
+
+		lastChangeTime := time.Now()
+		for time.Since(lastChangeTime) < w.opts.Debounce {
+			if err := sleep(ctx, debounceCheckInterval); err != nil {
+				return nil, err
+			}
+
+			next, err := w.takeSnapshot()
+			if err != nil {
+				return nil, err
+			}
+
+			if len(diffSnapshots(current, next)) != 0 {
+				current = next
+				lastChangeTime = time.Now()
+			}
+		}
+
+		changedPaths := diffSnapshots(w.snapshot, current)
+		w.snapshot = current
+
+		// changes could be reverted during the debounce period
+		if len(changedPaths) != 0 {
+			return changedPaths, nil
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (w *Watcher) takeSnapshot() (snapshot, error) {
+	paths, err := w.listFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	s := snapshot{}
+	for _, path := range paths {
+		info, err := os.Lstat(filepath.Join(w.projectDir, path))
+		if os.IsNotExist(err) {
+			// deleted tracked file
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to stat %s: %s", path, err)
+		}
+
+		s[path] = fileState{size: info.Size(), modTime: info.ModTime(), mode: info.Mode()}
+	}
+
+	return s, nil
+}
+
+func (w *Watcher) listFiles() ([]string, error) {
+	var paths []string
+
+	if _, err := os.Stat(filepath.Join(w.projectDir, ".git")); err == nil {
+		paths, err = true_git.ListWorkTreeFiles(w.projectDir)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list project files: %s", err)
+		}
+	} else if os.IsNotExist(err) {
+		if err := filepath.Walk(w.projectDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				if info.Name() == ".git" {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			relPath, err := filepath.Rel(w.projectDir, path)
+			if err != nil {
+				return err
+			}
+
+			paths = append(paths, relPath)
+
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("unable to list project files: %s", err)
+		}
+	} else {
+		return nil, fmt.Errorf("unable to stat %s: %s", filepath.Join(w.projectDir, ".git"), err)
+	}
+
+	for _, path := range alwaysWatchedFiles {
+		paths = util.UniqAppendString(paths, path)
+	}
+
+	return paths, nil
+}
+
+func diffSnapshots(old, new snapshot) []string {
+	var paths []string
+
+	for path, oldState := range old {
+		if newState, ok := new[path]; !ok || newState != oldState {
+			paths = append(paths, path)
+		}
+	}
+
+	for path := range new {
+		if _, ok := old[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+
+	sort.Strings(paths)
+
+	return paths
+}
@@ -0,0 +1,70 @@
+package dev
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var watcherTestTime = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var _ = DescribeTable("snapshots diff",
+	func(old, new snapshot, expectedPaths []string) {
+		Ω(diffSnapshots(old, new)).Should(Equal(expectedPaths))
+	},
+	Entry("no changes",
+		snapshot{"a": {size: 1, modTime: watcherTestTime}},
+		snapshot{"a": {size: 1, modTime: watcherTestTime}},
+		[]string(nil)),
+	Entry("modified file",
+		snapshot{"a": {size: 1, modTime: watcherTestTime}, "b": {size: 1, modTime: watcherTestTime}},
+		snapshot{"a": {size: 1, modTime: watcherTestTime.Add(time.Second)}, "b": {size: 1, modTime: watcherTestTime}},
+		[]string{"a"}),
+	Entry("mode changed",
+		snapshot{"a": {size: 1, modTime: watcherTestTime, mode: 0644}},
+		snapshot{"a": {size: 1, modTime: watcherTestTime, mode: 0755}},
+		[]string{"a"}),
+	Entry("added and deleted files",
+		snapshot{"b": {size: 1, modTime: watcherTestTime}, "c": {size: 1, modTime: watcherTestTime}},
+		snapshot{"a": {size: 1, modTime: watcherTestTime}, "c": {size: 1, modTime: watcherTestTime}},
+		[]string{"a", "b"}),
+)
+
+var _ = Describe("watcher", func() {
+	var projectDir string
+	var watcher *Watcher
+
+	BeforeEach(func() {
+		var err error
+		projectDir, err = ioutil.TempDir("", "werf-dev-watcher-test")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(ioutil.WriteFile(filepath.Join(projectDir, "werf.yaml"), []byte("project: test\n"), 0644)).Should(Succeed())
+
+		watcher = NewWatcher(projectDir, WatcherOptions{Interval: 10 * time.Millisecond, Debounce: 30 * time.Millisecond})
+		Ω(watcher.Init()).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		Ω(os.RemoveAll(projectDir)).Should(Succeed())
+	})
+
+	It("returns changed paths", func() {
+		Ω(ioutil.WriteFile(filepath.Join(projectDir, "app.py"), []byte("print(1)\n"), 0644)).Should(Succeed())
+
+		Ω(watcher.WaitForChanges(context.Background())).Should(Equal([]string{"app.py"}))
+	})
+
+	It("returns the context error when the context is cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		_, err := watcher.WaitForChanges(ctx)
+		Ω(err).Should(Equal(context.Canceled))
+	})
+})
@@ -1,8 +1,14 @@
 package docker
 
 import (
+	"fmt"
+	"io"
+	"time"
+
 	"github.com/docker/cli/cli/command/container"
 	"github.com/docker/docker/api/types"
+	containerTypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	"golang.org/x/net/context"
 )
@@ -47,6 +53,52 @@ func ContainerRemove(ref string, options types.ContainerRemoveOptions) error {
 	return nil
 }
 
+func ContainerCreate(name string, config *containerTypes.Config, hostConfig *containerTypes.HostConfig, networkingConfig *network.NetworkingConfig) (string, error) {
+	ctx := context.Background()
+	response, err := apiClient.ContainerCreate(ctx, config, hostConfig, networkingConfig, name)
+	if err != nil {
+		return "", err
+	}
+
+	return response.ID, nil
+}
+
+func ContainerStart(ref string) error {
+	ctx := context.Background()
+	return apiClient.ContainerStart(ctx, ref, types.ContainerStartOptions{})
+}
+
+func ContainerStop(ref string, timeout time.Duration) error {
+	ctx := context.Background()
+	return apiClient.ContainerStop(ctx, ref, &timeout)
+}
+
+func ContainerLogs(ref string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+	ctx := context.Background()
+	return apiClient.ContainerLogs(ctx, ref, options)
+}
+
+func ContainerExport(ref string) (io.ReadCloser, error) {
+	ctx := context.Background()
+	return apiClient.ContainerExport(ctx, ref)
+}
+
+// ContainerWait waits for the container to stop and returns its exit code
+func ContainerWait(ref string) (int64, error) {
+	ctx := context.Background()
+	resultCh, errCh := apiClient.ContainerWait(ctx, ref, containerTypes.WaitConditionNotRunning)
+
+	select {
+	case result := <-resultCh:
+		if result.Error != nil {
+			return result.StatusCode, fmt.Errorf("%s", result.Error.Message)
+		}
+		return result.StatusCode, nil
+	case err := <-errCh:
+		return 0, err
+	}
+}
+
 func CliCreate(args ...string) error {
 	cmd := container.NewCreateCommand(cli)
 	cmd.SilenceErrors = true
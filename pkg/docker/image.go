@@ -1,9 +1,12 @@
 package docker
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/docker/cli/cli/command"
 	"github.com/docker/cli/cli/command/image"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
@@ -159,8 +162,78 @@ func CliRmi(args ...string) error {
 	return nil
 }
 
+// CliBuild runs docker build using the default builder: the builder selected by DOCKER_BUILDKIT or the default builder of the docker daemon
 func CliBuild(args ...string) error {
-	cmd := image.NewBuildCommand(cli)
+	if defaultBuildKitEnv == "" {
+		return cliBuild(cli, args...)
+	}
+
+	isBuildKit, err := strconv.ParseBool(defaultBuildKitEnv)
+	if err != nil {
+		return fmt.Errorf("DOCKER_BUILDKIT environment variable expects boolean value: %s", err)
+	}
+
+	builderVersion := types.BuilderV1
+	if isBuildKit {
+		builderVersion = types.BuilderBuildKit
+	}
+
+	return cliBuild(&builderCli{Cli: cli, builderVersion: builderVersion}, args...)
+}
+
+// CliBuildWithDockerBuilder runs docker build using the legacy builder of the docker daemon
+func CliBuildWithDockerBuilder(args ...string) error {
+	return cliBuild(&builderCli{Cli: cli, builderVersion: types.BuilderV1}, args...)
+}
+
+// CliBuildWithBuildKit runs docker build using BuildKit builder of the docker daemon
+func CliBuildWithBuildKit(args ...string) error {
+	return cliBuild(&builderCli{Cli: cli, builderVersion: types.BuilderBuildKit}, append([]string{"--progress=plain"}, args...)...)
+}
+
+// builderCli reports the builder of the build to docker cli build command instead of the default builder of the docker daemon,
+// so that concurrent builds can use different builders
+type builderCli struct {
+	command.Cli
+	builderVersion types.BuilderVersion
+}
+
+func (c *builderCli) ServerInfo() command.ServerInfo {
+	info := c.Cli.ServerInfo()
+	info.BuildkitVersion = c.builderVersion
+	return info
+}
+
+func cliBuild(c command.Cli, args ...string) error {
+	cmd := image.NewBuildCommand(c)
+	cmd.SilenceErrors = true
+	cmd.SilenceUsage = true
+	cmd.SetArgs(args)
+
+	err := cmd.Execute()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func CliSave(args ...string) error {
+	cmd := image.NewSaveCommand(cli)
+	cmd.SilenceErrors = true
+	cmd.SilenceUsage = true
+	cmd.SetArgs(args)
+
+	err := cmd.Execute()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func CliLoad(args ...string) error {
+	cmd := image.NewLoadCommand(cli)
 	cmd.SilenceErrors = true
 	cmd.SilenceUsage = true
 	cmd.SetArgs(args)
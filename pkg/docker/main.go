@@ -18,6 +18,9 @@ import (
 var (
 	cli       *command.DockerCli
 	apiClient *client.Client
+
+	// defaultBuildKitEnv is DOCKER_BUILDKIT of the werf process
+	defaultBuildKitEnv string
 )
 
 func Init(dockerConfigDir string) error {
@@ -29,6 +32,13 @@ func Init(dockerConfigDir string) error {
 		return fmt.Errorf("cannot set DOCKER_CONFIG to %s: %s", dockerConfigDir, err)
 	}
 
+	// DOCKER_BUILDKIT selects the builder of builds without the builder specified in werf.yaml only (see CliBuild),
+	// the variable is unset, so that it does not override the specified builder
+	defaultBuildKitEnv = os.Getenv("DOCKER_BUILDKIT")
+	if err := os.Unsetenv("DOCKER_BUILDKIT"); err != nil {
+		return fmt.Errorf("cannot unset DOCKER_BUILDKIT: %s", err)
+	}
+
 	if err := setDockerClient(); err != nil {
 		return err
 	}
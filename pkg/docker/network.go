@@ -0,0 +1,33 @@
+package docker
+
+import (
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"golang.org/x/net/context"
+)
+
+func NetworkExist(ref string) (bool, error) {
+	ctx := context.Background()
+	if _, err := apiClient.NetworkInspect(ctx, ref, types.NetworkInspectOptions{}); err != nil {
+		if client.IsErrNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func NetworkCreate(name string, options types.NetworkCreate) (string, error) {
+	ctx := context.Background()
+	response, err := apiClient.NetworkCreate(ctx, name, options)
+	if err != nil {
+		return "", err
+	}
+
+	return response.ID, nil
+}
+
+func NetworkRemove(ref string) error {
+	ctx := context.Background()
+	return apiClient.NetworkRemove(ctx, ref)
+}
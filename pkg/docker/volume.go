@@ -1,9 +1,40 @@
 package docker
 
 import (
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	volumeTypes "github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
 	"golang.org/x/net/context"
 )
 
+func Volumes(filterSet filters.Args) ([]*types.Volume, error) {
+	ctx := context.Background()
+	response, err := apiClient.VolumeList(ctx, filterSet)
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Volumes, nil
+}
+
+func VolumeExist(volumeName string) (bool, error) {
+	ctx := context.Background()
+	if _, err := apiClient.VolumeInspect(ctx, volumeName); err != nil {
+		if client.IsErrNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func VolumeCreate(volumeName string, labels map[string]string) error {
+	ctx := context.Background()
+	_, err := apiClient.VolumeCreate(ctx, volumeTypes.VolumeCreateBody{Name: volumeName, Labels: labels})
+	return err
+}
+
 func VolumeRm(volumeName string, force bool) error {
 	ctx := context.Background()
 	return apiClient.VolumeRemove(ctx, volumeName, force)
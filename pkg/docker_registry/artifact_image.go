@@ -0,0 +1,122 @@
+package docker_registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	v1 "github.com/flant/go-containerregistry/pkg/v1"
+	"github.com/flant/go-containerregistry/pkg/v1/partial"
+	"github.com/flant/go-containerregistry/pkg/v1/types"
+)
+
+// artifactImage is the OCI image with the uncompressed layers of the arbitrary media types (signatures, SBOM)
+type artifactImage struct {
+	rawManifest []byte
+	rawConfig   []byte
+	blobs       map[v1.Hash]*artifactImageBlob
+}
+
+type artifactImageLayer struct {
+	mediaType   types.MediaType
+	data        []byte
+	annotations map[string]string
+}
+
+func newArtifactImage(layers []*artifactImageLayer) (v1.Image, error) {
+	img := &artifactImage{blobs: map[v1.Hash]*artifactImageBlob{}}
+
+	manifest := &v1.Manifest{SchemaVersion: 2, MediaType: types.OCIManifestSchema1}
+	diffIds := []string{}
+	for _, layer := range layers {
+		desc, err := img.addBlob(layer.mediaType, layer.data)
+		if err != nil {
+			return nil, err
+		}
+
+		desc.Annotations = layer.annotations
+		manifest.Layers = append(manifest.Layers, desc)
+		diffIds = append(diffIds, desc.Digest.String())
+	}
+
+	rawConfig, err := json.Marshal(map[string]interface{}{
+		"architecture": "",
+		"os":           "",
+		"config":       map[string]interface{}{},
+		"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": diffIds},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	configDesc, err := img.addBlob(types.OCIConfigJSON, rawConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest.Config = configDesc
+	img.rawConfig = rawConfig
+
+	img.rawManifest, err = json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	return partial.CompressedToImage(img)
+}
+
+func (i *artifactImage) addBlob(mediaType types.MediaType, data []byte) (v1.Descriptor, error) {
+	digest, size, err := v1.SHA256(bytes.NewReader(data))
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	i.blobs[digest] = &artifactImageBlob{mediaType: mediaType, digest: digest, data: data}
+
+	return v1.Descriptor{MediaType: mediaType, Size: size, Digest: digest}, nil
+}
+
+func (i *artifactImage) RawConfigFile() ([]byte, error) {
+	return i.rawConfig, nil
+}
+
+func (i *artifactImage) MediaType() (types.MediaType, error) {
+	return types.OCIManifestSchema1, nil
+}
+
+func (i *artifactImage) RawManifest() ([]byte, error) {
+	return i.rawManifest, nil
+}
+
+func (i *artifactImage) LayerByDigest(digest v1.Hash) (partial.CompressedLayer, error) {
+	blob, hasBlob := i.blobs[digest]
+	if !hasBlob {
+		return nil, fmt.Errorf("unknown blob %s", digest)
+	}
+
+	return blob, nil
+}
+
+type artifactImageBlob struct {
+	mediaType types.MediaType
+	digest    v1.Hash
+	data      []byte
+}
+
+func (b *artifactImageBlob) Digest() (v1.Hash, error) {
+	return b.digest, nil
+}
+
+func (b *artifactImageBlob) Compressed() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(b.data)), nil
+}
+
+func (b *artifactImageBlob) Size() (int64, error) {
+	return int64(len(b.data)), nil
+}
+
+func (b *artifactImageBlob) MediaType() (types.MediaType, error) {
+	return b.mediaType, nil
+}
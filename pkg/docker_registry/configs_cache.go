@@ -0,0 +1,103 @@
+package docker_registry
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	v1 "github.com/flant/go-containerregistry/pkg/v1"
+	"github.com/flant/logboek"
+
+	"github.com/flant/werf/pkg/util"
+	"github.com/flant/werf/pkg/werf"
+)
+
+const (
+	ConfigsCacheVersion = "1"
+
+	DefaultConfigsCacheMaxAge = 14 * 24 * time.Hour
+)
+
+func GetConfigsCacheDir() string {
+	return filepath.Join(werf.GetLocalCacheDir(), "docker_registry", "configs", ConfigsCacheVersion)
+}
+
+// getCachedConfigFile returns the image config by the config digest, configs are content-addressed so the cached config never changes
+func getCachedConfigFile(digest string) (*v1.ConfigFile, error) {
+	path := configCachePath(digest)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %s", path, err)
+	}
+
+	configFile, err := v1.ParseConfigFile(bytes.NewReader(data))
+	if err != nil {
+		// broken config is ignored and will be rewritten
+		return nil, nil
+	}
+
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+
+	return configFile, nil
+}
+
+func putCachedConfigFile(digest string, rawConfig []byte) error {
+	if err := os.MkdirAll(GetConfigsCacheDir(), os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create dir %s: %s", GetConfigsCacheDir(), err)
+	}
+
+	path := configCachePath(digest)
+	tmpPath := fmt.Sprintf("%s.%s.tmp", path, util.GenerateConsistentRandomString(5))
+	if err := ioutil.WriteFile(tmpPath, rawConfig, 0644); err != nil {
+		return fmt.Errorf("unable to write %s: %s", tmpPath, err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("unable to rename %s to %s: %s", tmpPath, path, err)
+	}
+
+	return nil
+}
+
+func configCachePath(digest string) string {
+	return filepath.Join(GetConfigsCacheDir(), strings.Replace(digest, ":", "-", 1)+".json")
+}
+
+// CleanupConfigsCache removes cached images configs that have not been used longer than maxAge
+func CleanupConfigsCache(maxAge time.Duration, dryRun bool) error {
+	infos, err := ioutil.ReadDir(GetConfigsCacheDir())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to read dir %s: %s", GetConfigsCacheDir(), err)
+	}
+
+	now := time.Now()
+	for _, info := range infos {
+		if now.Sub(info.ModTime()) <= maxAge {
+			continue
+		}
+
+		path := filepath.Join(GetConfigsCacheDir(), info.Name())
+		logboek.LogInfoF("Removing cached image config %s\n", info.Name())
+
+		if dryRun {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to remove %s: %s", path, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package docker_registry
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/werf/pkg/werf"
+)
+
+var _ = Describe("configs cache", func() {
+	var homeDir string
+
+	rawConfig := []byte(`{"architecture": "amd64", "os": "linux", "config": {"Labels": {"name": "value"}}}`)
+
+	BeforeEach(func() {
+		var err error
+		homeDir, err = ioutil.TempDir("", "werf-configs-cache-test")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(werf.Init(homeDir, homeDir)).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		Ω(os.RemoveAll(homeDir)).Should(Succeed())
+	})
+
+	It("returns nil for the missing config", func() {
+		Ω(getCachedConfigFile(testDigest)).Should(BeNil())
+	})
+
+	It("returns the stored config", func() {
+		Ω(putCachedConfigFile(testDigest, rawConfig)).Should(Succeed())
+
+		configFile, err := getCachedConfigFile(testDigest)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(configFile.Config.Labels).Should(Equal(map[string]string{"name": "value"}))
+
+		infos, err := ioutil.ReadDir(GetConfigsCacheDir())
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(infos).Should(HaveLen(1))
+	})
+
+	It("ignores the broken config", func() {
+		Ω(putCachedConfigFile(testDigest, []byte("{broken"))).Should(Succeed())
+		Ω(getCachedConfigFile(testDigest)).Should(BeNil())
+	})
+
+	It("removes configs that have not been used longer than max age", func() {
+		oldDigest := "sha256:" + strings.Repeat("b", 64)
+		newDigest := "sha256:" + strings.Repeat("c", 64)
+
+		Ω(putCachedConfigFile(oldDigest, rawConfig)).Should(Succeed())
+		Ω(putCachedConfigFile(newDigest, rawConfig)).Should(Succeed())
+
+		oldTime := time.Now().Add(-2 * time.Hour)
+		Ω(os.Chtimes(configCachePath(oldDigest), oldTime, oldTime)).Should(Succeed())
+
+		Ω(CleanupConfigsCache(time.Hour, true)).Should(Succeed())
+		Ω(configCachePath(oldDigest)).Should(BeAnExistingFile())
+
+		Ω(CleanupConfigsCache(time.Hour, false)).Should(Succeed())
+		Ω(configCachePath(oldDigest)).ShouldNot(BeAnExistingFile())
+		Ω(configCachePath(newDigest)).Should(BeAnExistingFile())
+	})
+
+	It("refreshes the usage time of the read config", func() {
+		Ω(putCachedConfigFile(testDigest, rawConfig)).Should(Succeed())
+
+		oldTime := time.Now().Add(-2 * time.Hour)
+		Ω(os.Chtimes(configCachePath(testDigest), oldTime, oldTime)).Should(Succeed())
+
+		Ω(getCachedConfigFile(testDigest)).ShouldNot(BeNil())
+		Ω(CleanupConfigsCache(time.Hour, false)).Should(Succeed())
+		Ω(configCachePath(testDigest)).Should(BeAnExistingFile())
+	})
+})
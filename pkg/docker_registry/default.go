@@ -0,0 +1,104 @@
+package docker_registry
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flant/go-containerregistry/pkg/authn"
+	"github.com/flant/go-containerregistry/pkg/name"
+	"github.com/flant/go-containerregistry/pkg/v1/remote"
+)
+
+// defaultImplementation uses docker registry API only
+type defaultImplementation struct{}
+
+func (i *defaultImplementation) Name() string {
+	return DefaultImplementationName
+}
+
+func (i *defaultImplementation) IsDeleteByTag() bool {
+	return false
+}
+
+func (i *defaultImplementation) Tags(repository string) ([]string, error) {
+	tags, err := list(repository)
+	if err != nil {
+		if strings.Contains(err.Error(), "NAME_UNKNOWN") {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+func (i *defaultImplementation) DeleteImage(reference string) error {
+	r, err := name.ParseReference(reference, parseReferenceOptions()...)
+	if err != nil {
+		return fmt.Errorf("parsing reference %q: %v", reference, err)
+	}
+
+	if deleteErr := remote.Delete(r, remote.WithAuthFromKeychain(authn.DefaultKeychain), remote.WithTransport(getHttpTransport())); deleteErr != nil {
+		if strings.Contains(deleteErr.Error(), "UNAUTHORIZED") {
+			auth, authErr := authn.DefaultKeychain.Resolve(r.Context().Registry)
+			if authErr != nil {
+				return fmt.Errorf("getting creds for %q: %v", r, authErr)
+			}
+
+			if gitlabRegistryDeleteErr := GitlabRegistryDelete(r, auth, getHttpTransport()); gitlabRegistryDeleteErr != nil {
+				if strings.Contains(gitlabRegistryDeleteErr.Error(), "UNAUTHORIZED") {
+					return fmt.Errorf("deleting image %q: %v", r, deleteErr)
+				}
+				return fmt.Errorf("deleting image %q: %v", r, gitlabRegistryDeleteErr)
+			}
+		} else {
+			return fmt.Errorf("deleting image %q: %v", r, deleteErr)
+		}
+	}
+
+	return nil
+}
+
+func list(reference string) ([]string, error) {
+	repo, err := name.NewRepository(reference, newRepositoryOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("parsing repo %q: %v", reference, err)
+	}
+
+	tags, err := remote.List(repo, remote.WithAuthFromKeychain(authn.DefaultKeychain), remote.WithTransport(getHttpTransport()))
+	if err != nil {
+		return nil, fmt.Errorf("reading tags for %q: %v", repo, err)
+	}
+
+	return tags, nil
+}
+
+func deleteManifest(reference string) error {
+	r, err := name.ParseReference(reference, parseReferenceOptions()...)
+	if err != nil {
+		return fmt.Errorf("parsing reference %q: %v", reference, err)
+	}
+
+	if err := remote.Delete(r, remote.WithAuthFromKeychain(authn.DefaultKeychain), remote.WithTransport(getHttpTransport())); err != nil {
+		return fmt.Errorf("deleting image %q: %v", r, err)
+	}
+
+	return nil
+}
+
+// gcrImplementation removes images by tags, GCR does not allow to remove the tagged manifest by digest
+type gcrImplementation struct {
+	defaultImplementation
+}
+
+func (i *gcrImplementation) Name() string {
+	return GcrImplementationName
+}
+
+func (i *gcrImplementation) IsDeleteByTag() bool {
+	return true
+}
+
+func (i *gcrImplementation) DeleteImage(reference string) error {
+	return deleteManifest(reference)
+}
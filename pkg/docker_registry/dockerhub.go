@@ -0,0 +1,161 @@
+package docker_registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/flant/go-containerregistry/pkg/authn"
+	"github.com/flant/go-containerregistry/pkg/name"
+)
+
+const dockerHubApiUrl = "https://hub.docker.com"
+
+// dockerHubImplementation uses Docker Hub API: docker registry API does not allow to remove manifests
+type dockerHubImplementation struct {
+	keychain authn.Keychain
+	apiUrl   string
+
+	token      string
+	tokenMutex sync.Mutex
+}
+
+func newDockerHubImplementation() *dockerHubImplementation {
+	return &dockerHubImplementation{keychain: authn.DefaultKeychain, apiUrl: dockerHubApiUrl}
+}
+
+func (i *dockerHubImplementation) Name() string {
+	return DockerHubImplementationName
+}
+
+func (i *dockerHubImplementation) IsDeleteByTag() bool {
+	return true
+}
+
+func (i *dockerHubImplementation) Tags(repository string) ([]string, error) {
+	repo, err := name.NewRepository(repository, newRepositoryOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("parsing repo %q: %v", repository, err)
+	}
+
+	token, err := i.getToken(repo.Registry, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	nextUrl := fmt.Sprintf("%s/v2/repositories/%s/tags/?page_size=100", i.apiUrl, repo.RepositoryStr())
+	for nextUrl != "" {
+		req, err := http.NewRequest(http.MethodGet, nextUrl, nil)
+		if err != nil {
+			return nil, err
+		}
+		i.setAuthorization(req, token)
+
+		var page struct {
+			Next    string `json:"next"`
+			Results []struct {
+				Name string `json:"name"`
+			} `json:"results"`
+		}
+
+		if err := doApiRequest(req, &page); err != nil {
+			if isApiResponseErrorWithStatus(err, http.StatusNotFound) {
+				return []string{}, nil
+			}
+			return nil, fmt.Errorf("reading tags for %q: %v", repo, err)
+		}
+
+		for _, result := range page.Results {
+			tags = append(tags, result.Name)
+		}
+
+		nextUrl = page.Next
+	}
+
+	return tags, nil
+}
+
+func (i *dockerHubImplementation) DeleteImage(reference string) error {
+	ref, err := name.ParseReference(reference, parseReferenceOptions()...)
+	if err != nil {
+		return fmt.Errorf("parsing reference %q: %v", reference, err)
+	}
+
+	tag, ok := ref.(name.Tag)
+	if !ok {
+		return fmt.Errorf("deleting image %q: Docker Hub supports removing images by tag only", ref)
+	}
+
+	token, err := i.getToken(ref.Context().Registry, true)
+	if err != nil {
+		return err
+	}
+
+	u := fmt.Sprintf("%s/v2/repositories/%s/tags/%s/", i.apiUrl, ref.Context().RepositoryStr(), tag.TagStr())
+	req, err := http.NewRequest(http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+	i.setAuthorization(req, token)
+
+	if err := doApiRequest(req, nil); err != nil {
+		return fmt.Errorf("deleting image %q: %v", ref, err)
+	}
+
+	return nil
+}
+
+func (i *dockerHubImplementation) setAuthorization(req *http.Request, token string) {
+	if token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("JWT %s", token))
+	}
+}
+
+// getToken logs in Docker Hub API with docker credentials of the registry,
+// empty token is returned for anonymous access if the credentials are not required
+func (i *dockerHubImplementation) getToken(registry name.Registry, isRequired bool) (string, error) {
+	i.tokenMutex.Lock()
+	defer i.tokenMutex.Unlock()
+
+	if i.token != "" {
+		return i.token, nil
+	}
+
+	username, password, err := basicCredentials(i.keychain, registry)
+	if err != nil {
+		return "", err
+	}
+
+	if username == "" {
+		if isRequired {
+			return "", fmt.Errorf("Docker Hub credentials are required: use docker login")
+		}
+		return "", nil
+	}
+
+	body, err := json.Marshal(map[string]string{"username": username, "password": password})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/v2/users/login/", i.apiUrl), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var resp struct {
+		Token string `json:"token"`
+	}
+
+	if err := doApiRequest(req, &resp); err != nil {
+		return "", fmt.Errorf("logging in Docker Hub: %v", err)
+	}
+
+	i.token = resp.Token
+
+	return i.token, nil
+}
@@ -0,0 +1,339 @@
+package docker_registry
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flant/go-containerregistry/pkg/name"
+)
+
+const (
+	ecrApiUrlFormat  = "https://api.ecr.%s.amazonaws.com%s"
+	ecrTargetPrefix  = "AmazonEC2ContainerRegistry_V20150921"
+	ecrListPageSize  = 1000
+	awsSigningMethod = "AWS4-HMAC-SHA256"
+)
+
+var ecrRegistryRegexp = regexp.MustCompile(`^(\d+)\.dkr\.ecr\.([a-z0-9-]+)\.amazonaws\.com(\.cn)?$`)
+
+// ecrImplementation uses Amazon ECR API: docker registry API does not allow to remove manifests.
+// Requests are signed with static AWS credentials (see getAwsCredentials)
+type ecrImplementation struct {
+	apiUrl string // api url by the registry region if empty
+	now    func() time.Time
+}
+
+type ecrImageId struct {
+	ImageDigest string `json:"imageDigest,omitempty"`
+	ImageTag    string `json:"imageTag,omitempty"`
+}
+
+func newEcrImplementation() *ecrImplementation {
+	return &ecrImplementation{now: time.Now}
+}
+
+func (i *ecrImplementation) Name() string {
+	return EcrImplementationName
+}
+
+func (i *ecrImplementation) IsDeleteByTag() bool {
+	return false
+}
+
+func (i *ecrImplementation) Tags(repository string) ([]string, error) {
+	repo, err := name.NewRepository(repository, newRepositoryOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("parsing repo %q: %v", repository, err)
+	}
+
+	tags := []string{}
+	var nextToken string
+	for {
+		request := map[string]interface{}{
+			"repositoryName": repo.RepositoryStr(),
+			"filter":         map[string]string{"tagStatus": "TAGGED"},
+			"maxResults":     ecrListPageSize,
+		}
+
+		if nextToken != "" {
+			request["nextToken"] = nextToken
+		}
+
+		var resp struct {
+			ImageIds  []*ecrImageId `json:"imageIds"`
+			NextToken string        `json:"nextToken"`
+		}
+
+		if err := i.doRequest(repo.Registry, "ListImages", request, &resp); err != nil {
+			if isEcrApiError(err, "RepositoryNotFoundException") {
+				return []string{}, nil
+			}
+			return nil, fmt.Errorf("reading tags for %q: %v", repo, err)
+		}
+
+		for _, imageId := range resp.ImageIds {
+			tags = append(tags, imageId.ImageTag)
+		}
+
+		if resp.NextToken == "" {
+			return tags, nil
+		}
+		nextToken = resp.NextToken
+	}
+}
+
+func (i *ecrImplementation) DeleteImage(reference string) error {
+	ref, err := name.ParseReference(reference, parseReferenceOptions()...)
+	if err != nil {
+		return fmt.Errorf("parsing reference %q: %v", reference, err)
+	}
+
+	imageId := &ecrImageId{}
+	switch r := ref.(type) {
+	case name.Digest:
+		imageId.ImageDigest = r.DigestStr()
+	case name.Tag:
+		imageId.ImageTag = r.TagStr()
+	}
+
+	request := map[string]interface{}{
+		"repositoryName": ref.Context().RepositoryStr(),
+		"imageIds":       []*ecrImageId{imageId},
+	}
+
+	var resp struct {
+		Failures []struct {
+			FailureCode   string `json:"failureCode"`
+			FailureReason string `json:"failureReason"`
+		} `json:"failures"`
+	}
+
+	if err := i.doRequest(ref.Context().Registry, "BatchDeleteImage", request, &resp); err != nil {
+		return fmt.Errorf("deleting image %q: %v", ref, err)
+	}
+
+	for _, failure := range resp.Failures {
+		if failure.FailureCode == "ImageNotFound" {
+			continue
+		}
+
+		return fmt.Errorf("deleting image %q: %s: %s", ref, failure.FailureCode, failure.FailureReason)
+	}
+
+	return nil
+}
+
+func (i *ecrImplementation) doRequest(registry name.Registry, action string, request map[string]interface{}, result interface{}) error {
+	registryId, region, defaultApiUrl, err := parseEcrRegistry(registry.RegistryStr())
+	if err != nil {
+		return err
+	}
+
+	credentials, err := getAwsCredentials()
+	if err != nil {
+		return err
+	}
+
+	request["registryId"] = registryId
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	apiUrl := i.apiUrl
+	if apiUrl == "" {
+		apiUrl = defaultApiUrl
+	}
+
+	req, err := http.NewRequest(http.MethodPost, apiUrl+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", fmt.Sprintf("%s.%s", ecrTargetPrefix, action))
+	if credentials.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.sessionToken)
+	}
+
+	signAwsRequest(req, body, region, "ecr", credentials.accessKeyId, credentials.secretAccessKey, i.now())
+
+	return doApiRequest(req, result)
+}
+
+// parseEcrRegistry returns the registry id, the region and the API url of the region partition:
+// China regions registries and API endpoints are in the amazonaws.com.cn domain
+func parseEcrRegistry(registry string) (string, string, string, error) {
+	matches := ecrRegistryRegexp.FindStringSubmatch(registry)
+	if matches == nil {
+		return "", "", "", fmt.Errorf("bad ECR registry %q: expected ACCOUNT.dkr.ecr.REGION.amazonaws.com[.cn]", registry)
+	}
+	registryId, region, domainSuffix := matches[1], matches[2], matches[3]
+
+	return registryId, region, fmt.Sprintf(ecrApiUrlFormat, region, domainSuffix), nil
+}
+
+type awsCredentials struct {
+	accessKeyId     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// getAwsCredentials returns static AWS credentials from the environment (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN)
+// or from the profile of the shared credentials file (AWS_PROFILE and AWS_SHARED_CREDENTIALS_FILE).
+// Other sources of the AWS SDK credential chain (instance and container roles, SSO, credential_process) are not supported
+func getAwsCredentials() (*awsCredentials, error) {
+	if accessKeyId, secretAccessKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); accessKeyId != "" && secretAccessKey != "" {
+		return &awsCredentials{accessKeyId: accessKeyId, secretAccessKey: secretAccessKey, sessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	credentialsFile := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if credentialsFile == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get user home dir: %s", err)
+		}
+
+		credentialsFile = filepath.Join(homeDir, ".aws", "credentials")
+	}
+
+	noCredentialsErr := fmt.Errorf(
+		"ECR API requires static AWS credentials: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables or aws_access_key_id and aws_secret_access_key of the profile %q in the shared credentials file %s should be specified (instance and container roles, SSO and credential_process are not supported)",
+		profile, credentialsFile,
+	)
+
+	data, err := ioutil.ReadFile(credentialsFile)
+	if os.IsNotExist(err) {
+		return nil, noCredentialsErr
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read AWS shared credentials file %s: %s", credentialsFile, err)
+	}
+
+	values := awsSharedCredentialsProfile(string(data), profile)
+	if values["aws_access_key_id"] == "" || values["aws_secret_access_key"] == "" {
+		return nil, noCredentialsErr
+	}
+
+	return &awsCredentials{
+		accessKeyId:     values["aws_access_key_id"],
+		secretAccessKey: values["aws_secret_access_key"],
+		sessionToken:    values["aws_session_token"],
+	}, nil
+}
+
+// awsSharedCredentialsProfile returns key-value pairs of the profile section of the ini-formatted shared credentials file
+func awsSharedCredentialsProfile(data, profile string) map[string]string {
+	values := map[string]string{}
+
+	var isProfileSection bool
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			isProfileSection = strings.TrimSpace(line[1:len(line)-1]) == profile
+			continue
+		}
+
+		if !isProfileSection {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		values[strings.ToLower(strings.TrimSpace(parts[0]))] = strings.TrimSpace(parts[1])
+	}
+
+	return values
+}
+
+func isEcrApiError(err error, errorType string) bool {
+	apiErr, ok := err.(*apiResponseError)
+	return ok && apiErr.StatusCode == http.StatusBadRequest && strings.Contains(apiErr.Body, errorType)
+}
+
+// signAwsRequest adds AWS Signature Version 4 to the request with all set headers signed
+func signAwsRequest(req *http.Request, body []byte, region, service, accessKeyId, secretAccessKey string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for key, values := range req.Header {
+		headers[strings.ToLower(key)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	var signedHeaders []string
+	for key := range headers {
+		signedHeaders = append(signedHeaders, key)
+	}
+	sort.Strings(signedHeaders)
+
+	var canonicalHeaders strings.Builder
+	for _, key := range signedHeaders {
+		canonicalHeaders.WriteString(fmt.Sprintf("%s:%s\n", key, headers[key]))
+	}
+
+	canonicalUri := req.URL.EscapedPath()
+	if canonicalUri == "" {
+		canonicalUri = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalUri,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		sha256Hex(body),
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{awsSigningMethod, amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	signingKey := hmacSha256([]byte("AWS4"+secretAccessKey), date)
+	for _, part := range []string{region, service, "aws4_request"} {
+		signingKey = hmacSha256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSha256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		awsSigningMethod, accessKeyId, scope, strings.Join(signedHeaders, ";"), signature,
+	))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
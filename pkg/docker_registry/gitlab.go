@@ -0,0 +1,82 @@
+package docker_registry
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/flant/go-containerregistry/pkg/authn"
+	"github.com/flant/go-containerregistry/pkg/name"
+	"github.com/flant/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// gitlabImplementation removes manifests with the token for all actions on the repository (see GitlabRegistryDelete)
+type gitlabImplementation struct {
+	defaultImplementation
+	keychain authn.Keychain
+}
+
+func newGitlabImplementation() *gitlabImplementation {
+	return &gitlabImplementation{keychain: authn.DefaultKeychain}
+}
+
+func (i *gitlabImplementation) Name() string {
+	return GitlabImplementationName
+}
+
+func (i *gitlabImplementation) DeleteImage(reference string) error {
+	r, err := name.ParseReference(reference, parseReferenceOptions()...)
+	if err != nil {
+		return fmt.Errorf("parsing reference %q: %v", reference, err)
+	}
+
+	auth, err := i.keychain.Resolve(r.Context().Registry)
+	if err != nil {
+		return fmt.Errorf("getting creds for %q: %v", r, err)
+	}
+
+	if err := GitlabRegistryDelete(r, auth, getHttpTransport()); err != nil {
+		return fmt.Errorf("deleting image %q: %v", r, err)
+	}
+
+	return nil
+}
+
+// TODO https://gitlab.com/gitlab-org/gitlab-ce/issues/48968
+func GitlabRegistryDelete(ref name.Reference, auth authn.Authenticator, t http.RoundTripper) error {
+	scopes := []string{ref.Scope("*")}
+	tr, err := transport.New(ref.Context().Registry, auth, t, scopes)
+	if err != nil {
+		return err
+	}
+	c := &http.Client{Transport: tr}
+
+	u := url.URL{
+		Scheme: ref.Context().Registry.Scheme(),
+		Host:   ref.Context().RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/manifests/%s", ref.Context().RepositoryStr(), ref.Identifier()),
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted:
+		return nil
+	default:
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("unrecognized status code during DELETE: %v; %v", resp.Status, string(b))
+	}
+}
@@ -0,0 +1,168 @@
+package docker_registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/flant/go-containerregistry/pkg/authn"
+	"github.com/flant/go-containerregistry/pkg/name"
+)
+
+const harborArtifactsPageSize = 100
+
+// harborImplementation uses Harbor API v2.0: docker registry API does not allow to remove manifests,
+// artifacts list contains images labels
+type harborImplementation struct {
+	keychain authn.Keychain
+}
+
+type harborArtifact struct {
+	Digest string `json:"digest"`
+	Tags   []struct {
+		Name string `json:"name"`
+	} `json:"tags"`
+	ExtraAttrs struct {
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"config"`
+	} `json:"extra_attrs"`
+}
+
+func newHarborImplementation() *harborImplementation {
+	return &harborImplementation{keychain: authn.DefaultKeychain}
+}
+
+func (i *harborImplementation) Name() string {
+	return HarborImplementationName
+}
+
+func (i *harborImplementation) IsDeleteByTag() bool {
+	return false
+}
+
+func (i *harborImplementation) Tags(repository string) ([]string, error) {
+	artifacts, err := i.artifacts(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []string{}
+	for _, artifact := range artifacts {
+		for _, tag := range artifact.Tags {
+			tags = append(tags, tag.Name)
+		}
+	}
+
+	return tags, nil
+}
+
+func (i *harborImplementation) TagsLabels(repository string) (map[string]map[string]string, error) {
+	artifacts, err := i.artifacts(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	labelsByTag := map[string]map[string]string{}
+	for _, artifact := range artifacts {
+		for _, tag := range artifact.Tags {
+			labelsByTag[tag.Name] = artifact.ExtraAttrs.Config.Labels
+		}
+	}
+
+	return labelsByTag, nil
+}
+
+func (i *harborImplementation) DeleteImage(reference string) error {
+	ref, err := name.ParseReference(reference, parseReferenceOptions()...)
+	if err != nil {
+		return fmt.Errorf("parsing reference %q: %v", reference, err)
+	}
+
+	repositoryUrl, err := i.repositoryUrl(ref.Context())
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/artifacts/%s", repositoryUrl, ref.Identifier()), nil)
+	if err != nil {
+		return err
+	}
+
+	if err := i.setAuthorization(req, ref.Context().Registry); err != nil {
+		return err
+	}
+
+	if err := doApiRequest(req, nil); err != nil {
+		return fmt.Errorf("deleting image %q: %v", ref, err)
+	}
+
+	return nil
+}
+
+func (i *harborImplementation) artifacts(repository string) ([]*harborArtifact, error) {
+	repo, err := name.NewRepository(repository, newRepositoryOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("parsing repo %q: %v", repository, err)
+	}
+
+	repositoryUrl, err := i.repositoryUrl(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var artifacts []*harborArtifact
+	for page := 1; ; page++ {
+		u := fmt.Sprintf("%s/artifacts?with_tag=true&page=%d&page_size=%d", repositoryUrl, page, harborArtifactsPageSize)
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := i.setAuthorization(req, repo.Registry); err != nil {
+			return nil, err
+		}
+
+		var pageArtifacts []*harborArtifact
+		if err := doApiRequest(req, &pageArtifacts); err != nil {
+			if isApiResponseErrorWithStatus(err, http.StatusNotFound) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("reading tags for %q: %v", repo, err)
+		}
+
+		artifacts = append(artifacts, pageArtifacts...)
+
+		if len(pageArtifacts) < harborArtifactsPageSize {
+			return artifacts, nil
+		}
+	}
+}
+
+// repositoryUrl returns API url of the repository REGISTRY/PROJECT/REPOSITORY,
+// the repository name with slashes should be encoded twice
+func (i *harborImplementation) repositoryUrl(repo name.Repository) (string, error) {
+	parts := strings.SplitN(repo.RepositoryStr(), "/", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("bad Harbor repository %q: expected REGISTRY/PROJECT/REPOSITORY", repo)
+	}
+
+	return fmt.Sprintf(
+		"%s://%s/api/v2.0/projects/%s/repositories/%s",
+		repo.Registry.Scheme(), repo.RegistryStr(), url.PathEscape(parts[0]), url.PathEscape(url.PathEscape(parts[1])),
+	), nil
+}
+
+func (i *harborImplementation) setAuthorization(req *http.Request, registry name.Registry) error {
+	username, password, err := basicCredentials(i.keychain, registry)
+	if err != nil {
+		return err
+	}
+
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	return nil
+}
@@ -0,0 +1,249 @@
+package docker_registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/flant/go-containerregistry/pkg/authn"
+	"github.com/flant/go-containerregistry/pkg/name"
+)
+
+const (
+	DefaultImplementationName   = "default"
+	DockerHubImplementationName = "dockerhub"
+	EcrImplementationName       = "ecr"
+	GcrImplementationName       = "gcr"
+	GitlabImplementationName    = "gitlab"
+	HarborImplementationName    = "harbor"
+	QuayImplementationName      = "quay"
+)
+
+var (
+	ImplementationNames = []string{
+		DefaultImplementationName,
+		DockerHubImplementationName,
+		EcrImplementationName,
+		GcrImplementationName,
+		GitlabImplementationName,
+		HarborImplementationName,
+		QuayImplementationName,
+	}
+
+	// the first matched implementation is used, GCR is detected by GCRUrlPatterns.
+	// Harbor is self-hosted under arbitrary hostnames and is selected by RepoImplementation only
+	implementationsUrlPatterns = []struct {
+		name     string
+		patterns []string
+	}{
+		{EcrImplementationName, []string{`^\d+\.dkr\.ecr\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`}},
+		{DockerHubImplementationName, []string{`^index\.docker\.io$`, `^registry-1\.docker\.io$`, `^docker\.io$`}},
+		{QuayImplementationName, []string{`^quay\.io$`}},
+		{GitlabImplementationName, []string{`^registry\.gitlab\.com$`}},
+	}
+
+	implementations      = map[string]RegistryImplementation{}
+	implementationsMutex sync.Mutex
+)
+
+// RegistryImplementation covers registry operations that are not supported by the docker registry API uniformly
+type RegistryImplementation interface {
+	Name() string
+	Tags(repository string) ([]string, error)
+	// DeleteImage removes the image by the reference with the digest or with the tag if IsDeleteByTag
+	DeleteImage(reference string) error
+	IsDeleteByTag() bool
+}
+
+// tagsLabelsGetter is implemented by registries which API returns images labels
+// cheaper than reading manifest and config of each tag
+type tagsLabelsGetter interface {
+	TagsLabels(repository string) (map[string]map[string]string, error)
+}
+
+func IsImplementationSupported(implementationName string) bool {
+	for _, supportedName := range ImplementationNames {
+		if supportedName == implementationName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsDeleteByTag reports that the image of the repository should be removed by the reference with the tag, not with the digest
+func IsDeleteByTag(repository string) (bool, error) {
+	implementation, err := implementationByRepository(repository)
+	if err != nil {
+		return false, err
+	}
+
+	return implementation.IsDeleteByTag(), nil
+}
+
+func implementationByRepository(repository string) (RegistryImplementation, error) {
+	implementationName := RepoImplementation
+	if implementationName == "" {
+		var err error
+		implementationName, err = detectImplementation(repository)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	implementationsMutex.Lock()
+	defer implementationsMutex.Unlock()
+
+	implementation, hasImplementation := implementations[implementationName]
+	if !hasImplementation {
+		implementation = newImplementation(implementationName)
+		implementations[implementationName] = implementation
+	}
+
+	return implementation, nil
+}
+
+func implementationByReference(reference string) (RegistryImplementation, error) {
+	ref, err := name.ParseReference(reference, parseReferenceOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("parsing reference %q: %v", reference, err)
+	}
+
+	return implementationByRepository(ref.Context().Name())
+}
+
+func newImplementation(implementationName string) RegistryImplementation {
+	switch implementationName {
+	case DockerHubImplementationName:
+		return newDockerHubImplementation()
+	case EcrImplementationName:
+		return newEcrImplementation()
+	case GcrImplementationName:
+		return &gcrImplementation{}
+	case GitlabImplementationName:
+		return newGitlabImplementation()
+	case HarborImplementationName:
+		return newHarborImplementation()
+	case QuayImplementationName:
+		return newQuayImplementation()
+	default:
+		return &defaultImplementation{}
+	}
+}
+
+func detectImplementation(repository string) (string, error) {
+	isGCR, err := IsGCR(repository)
+	if err != nil {
+		return "", err
+	}
+
+	if isGCR {
+		return GcrImplementationName, nil
+	}
+
+	repo, err := name.NewRepository(repository, newRepositoryOptions()...)
+	if err != nil {
+		return "", fmt.Errorf("parsing repo %q: %v", repository, err)
+	}
+
+	for _, implementationUrlPatterns := range implementationsUrlPatterns {
+		for _, pattern := range implementationUrlPatterns.patterns {
+			matched, err := regexp.MatchString(pattern, repo.RegistryStr())
+			if err != nil {
+				return "", err
+			}
+
+			if matched {
+				return implementationUrlPatterns.name, nil
+			}
+		}
+	}
+
+	return DefaultImplementationName, nil
+}
+
+// basicCredentials returns the username and the password for the registry from the docker config,
+// empty values are returned if there are no basic credentials
+func basicCredentials(keychain authn.Keychain, registry name.Registry) (string, string, error) {
+	auth, err := keychain.Resolve(registry)
+	if err != nil {
+		return "", "", fmt.Errorf("getting creds for %q: %v", registry, err)
+	}
+
+	authorization, err := auth.Authorization()
+	if err != nil {
+		return "", "", fmt.Errorf("getting creds for %q: %v", registry, err)
+	}
+
+	if !strings.HasPrefix(authorization, "Basic ") {
+		return "", "", nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(authorization, "Basic "))
+	if err != nil {
+		return "", "", fmt.Errorf("decoding creds for %q: %v", registry, err)
+	}
+
+	parts := strings.SplitN(string(data), ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("bad creds for %q", registry)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+type apiResponseError struct {
+	Method     string
+	Url        string
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (err *apiResponseError) Error() string {
+	return fmt.Sprintf("%s %s: unexpected status %s: %s", err.Method, err.Url, err.Status, err.Body)
+}
+
+func isApiResponseErrorWithStatus(err error, statusCode int) bool {
+	apiErr, ok := err.(*apiResponseError)
+	return ok && apiErr.StatusCode == statusCode
+}
+
+// doApiRequest sends the request to the registry vendor API and decodes the JSON response into the result (if not nil)
+func doApiRequest(req *http.Request, result interface{}) error {
+	client := &http.Client{Transport: getHttpTransport()}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading %s %s response: %v", req.Method, req.URL, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &apiResponseError{
+			Method:     req.Method,
+			Url:        req.URL.String(),
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       strings.TrimSpace(string(body)),
+		}
+	}
+
+	if result != nil && len(body) != 0 {
+		if err := json.Unmarshal(body, result); err != nil {
+			return fmt.Errorf("parsing %s %s response: %v", req.Method, req.URL, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,514 @@
+package docker_registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/flant/go-containerregistry/pkg/authn"
+	"github.com/flant/go-containerregistry/pkg/name"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var testDigest = "sha256:" + strings.Repeat("a", 64)
+
+type staticKeychain struct {
+	username string
+	password string
+}
+
+func (k staticKeychain) Resolve(name.Registry) (authn.Authenticator, error) {
+	return &authn.Basic{Username: k.username, Password: k.password}, nil
+}
+
+// newStandIn runs the local HTTP stand-in of the registry API, requests are recorded as "METHOD PATH"
+func newStandIn(handler func(w http.ResponseWriter, r *http.Request)) (*httptest.Server, *[]string) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer GinkgoRecover()
+
+		requests = append(requests, fmt.Sprintf("%s %s", r.Method, r.URL.EscapedPath()))
+		handler(w, r)
+	}))
+
+	return server, &requests
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(obj)
+}
+
+var _ = DescribeTable("implementation detection", func(repository, expectedImplementationName string) {
+	Ω(detectImplementation(repository)).Should(Equal(expectedImplementationName))
+},
+	Entry("docker hub short name", "user/app", DockerHubImplementationName),
+	Entry("docker hub", "index.docker.io/user/app", DockerHubImplementationName),
+	Entry("gcr", "eu.gcr.io/project/app", GcrImplementationName),
+	Entry("ecr", "123456789012.dkr.ecr.eu-central-1.amazonaws.com/app", EcrImplementationName),
+	Entry("ecr china", "123456789012.dkr.ecr.cn-north-1.amazonaws.com.cn/app", EcrImplementationName),
+	Entry("quay", "quay.io/ns/app", QuayImplementationName),
+	Entry("gitlab", "registry.gitlab.com/group/app", GitlabImplementationName),
+	Entry("harbor is not detected by the hostname", "harbor.example.com/project/app", DefaultImplementationName),
+	Entry("other", "registry.example.com/app", DefaultImplementationName),
+)
+
+var _ = Describe("implementation selection", func() {
+	AfterEach(func() {
+		RepoImplementation = ""
+	})
+
+	It("uses the implementation set explicitly", func() {
+		Ω(Init(Options{RepoImplementation: HarborImplementationName})).Should(Succeed())
+
+		implementation, err := implementationByRepository("registry.example.com/project/app")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(implementation.Name()).Should(Equal(HarborImplementationName))
+	})
+})
+
+var _ = Describe("harbor implementation", func() {
+	var server *httptest.Server
+	var requests *[]string
+	var implementation *harborImplementation
+	var repository string
+
+	BeforeEach(func() {
+		server, requests = newStandIn(func(w http.ResponseWriter, r *http.Request) {
+			if username, password, _ := r.BasicAuth(); username != "user" || password != "password" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			switch fmt.Sprintf("%s %s", r.Method, r.URL.EscapedPath()) {
+			case "GET /api/v2.0/projects/project/repositories/group%252Fapp/artifacts":
+				Ω(r.URL.Query().Get("page")).Should(Equal("1"))
+				writeJSON(w, http.StatusOK, []interface{}{
+					map[string]interface{}{
+						"digest":      testDigest,
+						"tags":        []interface{}{map[string]string{"name": "a1"}, map[string]string{"name": "a2"}},
+						"extra_attrs": map[string]interface{}{"config": map[string]interface{}{"Labels": map[string]string{"werf-image": "true"}}},
+					},
+					map[string]interface{}{
+						"digest":      "sha256:" + strings.Repeat("b", 64),
+						"tags":        []interface{}{map[string]string{"name": "b"}},
+						"extra_attrs": map[string]interface{}{"config": map[string]interface{}{"Labels": map[string]string{"werf-image": "false"}}},
+					},
+				})
+			case "DELETE /api/v2.0/projects/project/repositories/group%252Fapp/artifacts/" + testDigest:
+				w.WriteHeader(http.StatusOK)
+			default:
+				writeJSON(w, http.StatusNotFound, map[string]interface{}{"errors": []interface{}{}})
+			}
+		})
+
+		implementation = &harborImplementation{keychain: staticKeychain{"user", "password"}}
+		repository = strings.TrimPrefix(server.URL, "http://") + "/project/group/app"
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("lists tags", func() {
+		Ω(implementation.Tags(repository)).Should(ConsistOf("a1", "a2", "b"))
+	})
+
+	It("returns labels of tags", func() {
+		Ω(implementation.TagsLabels(repository)).Should(Equal(map[string]map[string]string{
+			"a1": {"werf-image": "true"},
+			"a2": {"werf-image": "true"},
+			"b":  {"werf-image": "false"},
+		}))
+	})
+
+	It("returns no tags for unknown repository", func() {
+		Ω(implementation.Tags(strings.TrimPrefix(server.URL, "http://") + "/project/unknown")).Should(BeEmpty())
+	})
+
+	It("deletes artifact by digest", func() {
+		Ω(implementation.DeleteImage(repository + "@" + testDigest)).Should(Succeed())
+		Ω(*requests).Should(ContainElement("DELETE /api/v2.0/projects/project/repositories/group%252Fapp/artifacts/" + testDigest))
+	})
+})
+
+var _ = Describe("quay implementation", func() {
+	var server *httptest.Server
+	var requests *[]string
+	var implementation *quayImplementation
+	var repository string
+
+	BeforeEach(func() {
+		server, requests = newStandIn(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			bDigest := "sha256:" + strings.Repeat("b", 64)
+
+			switch fmt.Sprintf("%s %s", r.Method, r.URL.Path) {
+			case "GET /api/v1/repository/ns/app/tag/":
+				if r.URL.Query().Get("page") == "1" {
+					writeJSON(w, http.StatusOK, map[string]interface{}{
+						"tags":           []interface{}{map[string]string{"name": "a1", "manifest_digest": testDigest}},
+						"has_additional": true,
+					})
+				} else {
+					writeJSON(w, http.StatusOK, map[string]interface{}{
+						"tags": []interface{}{
+							map[string]string{"name": "a2", "manifest_digest": testDigest},
+							map[string]string{"name": "b", "manifest_digest": bDigest},
+						},
+						"has_additional": false,
+					})
+				}
+			case "GET /api/v1/repository/ns/app/manifest/" + testDigest + "/labels":
+				writeJSON(w, http.StatusOK, map[string]interface{}{"labels": []interface{}{map[string]string{"key": "werf-image", "value": "true"}}})
+			case "GET /api/v1/repository/ns/app/manifest/" + bDigest + "/labels":
+				writeJSON(w, http.StatusOK, map[string]interface{}{"labels": []interface{}{}})
+			case "DELETE /api/v1/repository/ns/app/tag/a1":
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		})
+
+		implementation = &quayImplementation{keychain: staticKeychain{quayOAuthTokenUsername, "token"}}
+		repository = strings.TrimPrefix(server.URL, "http://") + "/ns/app"
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("lists tags of all pages", func() {
+		Ω(implementation.Tags(repository)).Should(Equal([]string{"a1", "a2", "b"}))
+	})
+
+	It("returns labels of tags requesting each manifest once", func() {
+		Ω(implementation.TagsLabels(repository)).Should(Equal(map[string]map[string]string{
+			"a1": {"werf-image": "true"},
+			"a2": {"werf-image": "true"},
+			"b":  {},
+		}))
+
+		var labelsRequestsCount int
+		for _, request := range *requests {
+			if strings.HasSuffix(request, "/labels") {
+				labelsRequestsCount++
+			}
+		}
+		Ω(labelsRequestsCount).Should(Equal(2))
+	})
+
+	It("deletes tag", func() {
+		Ω(implementation.DeleteImage(repository + ":a1")).Should(Succeed())
+		Ω(*requests).Should(ContainElement("DELETE /api/v1/repository/ns/app/tag/a1"))
+	})
+
+	It("does not delete by digest", func() {
+		Ω(implementation.DeleteImage(repository + "@" + testDigest)).Should(MatchError(ContainSubstring("by tag only")))
+	})
+
+	It("requires OAuth access token to delete", func() {
+		implementation.keychain = staticKeychain{"robot", "password"}
+		Ω(implementation.DeleteImage(repository + ":a1")).Should(MatchError(ContainSubstring("OAuth access token")))
+	})
+})
+
+var _ = Describe("docker hub implementation", func() {
+	var server *httptest.Server
+	var requests *[]string
+	var implementation *dockerHubImplementation
+
+	BeforeEach(func() {
+		server, requests = newStandIn(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/v2/users/login/" {
+				var creds map[string]string
+				Ω(json.NewDecoder(r.Body).Decode(&creds)).Should(Succeed())
+				Ω(creds).Should(Equal(map[string]string{"username": "user", "password": "password"}))
+				writeJSON(w, http.StatusOK, map[string]string{"token": "jwt"})
+				return
+			}
+
+			if r.Header.Get("Authorization") != "JWT jwt" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			switch fmt.Sprintf("%s %s", r.Method, r.URL.Path) {
+			case "GET /v2/repositories/user/app/tags/":
+				if r.URL.Query().Get("page") == "" {
+					writeJSON(w, http.StatusOK, map[string]interface{}{
+						"next":    fmt.Sprintf("%s/v2/repositories/user/app/tags/?page=2&page_size=100", server.URL),
+						"results": []interface{}{map[string]string{"name": "a1"}},
+					})
+				} else {
+					writeJSON(w, http.StatusOK, map[string]interface{}{
+						"next":    nil,
+						"results": []interface{}{map[string]string{"name": "a2"}},
+					})
+				}
+			case "DELETE /v2/repositories/user/app/tags/a1/":
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		})
+
+		implementation = &dockerHubImplementation{keychain: staticKeychain{"user", "password"}, apiUrl: server.URL}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("lists tags of all pages", func() {
+		Ω(implementation.Tags("user/app")).Should(Equal([]string{"a1", "a2"}))
+	})
+
+	It("returns no tags for unknown repository", func() {
+		Ω(implementation.Tags("user/unknown")).Should(BeEmpty())
+	})
+
+	It("deletes tag logging in once", func() {
+		Ω(implementation.DeleteImage("user/app:a1")).Should(Succeed())
+		Ω(implementation.Tags("user/app")).Should(HaveLen(2))
+
+		Ω((*requests)[0:2]).Should(Equal([]string{"POST /v2/users/login/", "DELETE /v2/repositories/user/app/tags/a1/"}))
+		Ω(*requests).Should(HaveLen(4))
+	})
+
+	It("does not delete by digest", func() {
+		Ω(implementation.DeleteImage("user/app@" + testDigest)).Should(MatchError(ContainSubstring("by tag only")))
+	})
+})
+
+var _ = Describe("ecr implementation", func() {
+	var server *httptest.Server
+	var implementation *ecrImplementation
+	var requestsBodies []map[string]interface{}
+	var deleteFailures []interface{}
+	var oldAccessKeyId, oldSecretAccessKey string
+
+	repository := "123456789012.dkr.ecr.eu-central-1.amazonaws.com/app"
+
+	BeforeEach(func() {
+		oldAccessKeyId, oldSecretAccessKey = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+		Ω(os.Setenv("AWS_ACCESS_KEY_ID", "AKID")).Should(Succeed())
+		Ω(os.Setenv("AWS_SECRET_ACCESS_KEY", "SECRET")).Should(Succeed())
+
+		requestsBodies = nil
+		deleteFailures = []interface{}{}
+
+		server, _ = newStandIn(func(w http.ResponseWriter, r *http.Request) {
+			Ω(r.Header.Get("Authorization")).Should(HavePrefix(
+				"AWS4-HMAC-SHA256 Credential=AKID/20200102/eu-central-1/ecr/aws4_request, SignedHeaders=content-type;host;x-amz-date;x-amz-target, Signature=",
+			))
+
+			var body map[string]interface{}
+			Ω(json.NewDecoder(r.Body).Decode(&body)).Should(Succeed())
+			requestsBodies = append(requestsBodies, body)
+
+			Ω(body["registryId"]).Should(Equal("123456789012"))
+
+			if body["repositoryName"] != "app" {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"__type": "RepositoryNotFoundException"})
+				return
+			}
+
+			switch r.Header.Get("X-Amz-Target") {
+			case "AmazonEC2ContainerRegistry_V20150921.ListImages":
+				if body["nextToken"] == nil {
+					writeJSON(w, http.StatusOK, map[string]interface{}{
+						"imageIds":  []interface{}{map[string]string{"imageDigest": testDigest, "imageTag": "a1"}},
+						"nextToken": "next",
+					})
+				} else {
+					writeJSON(w, http.StatusOK, map[string]interface{}{
+						"imageIds": []interface{}{map[string]string{"imageDigest": testDigest, "imageTag": "a2"}},
+					})
+				}
+			case "AmazonEC2ContainerRegistry_V20150921.BatchDeleteImage":
+				writeJSON(w, http.StatusOK, map[string]interface{}{"imageIds": body["imageIds"], "failures": deleteFailures})
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		})
+
+		implementation = &ecrImplementation{
+			apiUrl: server.URL,
+			now:    func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) },
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Ω(os.Setenv("AWS_ACCESS_KEY_ID", oldAccessKeyId)).Should(Succeed())
+		Ω(os.Setenv("AWS_SECRET_ACCESS_KEY", oldSecretAccessKey)).Should(Succeed())
+	})
+
+	It("lists tags of all pages", func() {
+		Ω(implementation.Tags(repository)).Should(Equal([]string{"a1", "a2"}))
+		Ω(requestsBodies[0]["filter"]).Should(Equal(map[string]interface{}{"tagStatus": "TAGGED"}))
+	})
+
+	It("returns no tags for unknown repository", func() {
+		Ω(implementation.Tags("123456789012.dkr.ecr.eu-central-1.amazonaws.com/unknown")).Should(BeEmpty())
+	})
+
+	It("deletes image by digest", func() {
+		Ω(implementation.DeleteImage(repository + "@" + testDigest)).Should(Succeed())
+		Ω(requestsBodies[0]["imageIds"]).Should(Equal([]interface{}{map[string]interface{}{"imageDigest": testDigest}}))
+	})
+
+	It("returns delete failures", func() {
+		deleteFailures = []interface{}{map[string]interface{}{"failureCode": "ImageReferencedByManifestList", "failureReason": "referenced"}}
+		Ω(implementation.DeleteImage(repository + "@" + testDigest)).Should(MatchError(ContainSubstring("ImageReferencedByManifestList")))
+	})
+})
+
+var _ = DescribeTable("ecr registry parsing", func(registry, expectedRegion, expectedApiUrl string) {
+	registryId, region, apiUrl, err := parseEcrRegistry(registry)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(registryId).Should(Equal("123456789012"))
+	Ω(region).Should(Equal(expectedRegion))
+	Ω(apiUrl).Should(Equal(expectedApiUrl))
+},
+	Entry("eu-central-1", "123456789012.dkr.ecr.eu-central-1.amazonaws.com", "eu-central-1", "https://api.ecr.eu-central-1.amazonaws.com"),
+	Entry("cn-north-1", "123456789012.dkr.ecr.cn-north-1.amazonaws.com.cn", "cn-north-1", "https://api.ecr.cn-north-1.amazonaws.com.cn"),
+)
+
+var _ = Describe("aws credentials", func() {
+	envNames := []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE", "AWS_SHARED_CREDENTIALS_FILE"}
+	oldEnv := map[string]string{}
+	var credentialsFile string
+
+	BeforeEach(func() {
+		for _, envName := range envNames {
+			oldEnv[envName] = os.Getenv(envName)
+			Ω(os.Unsetenv(envName)).Should(Succeed())
+		}
+
+		tmpDir, err := ioutil.TempDir("", "werf-aws-credentials")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		credentialsFile = filepath.Join(tmpDir, "credentials")
+		Ω(ioutil.WriteFile(credentialsFile, []byte(`# comment
+[default]
+aws_access_key_id = DEFAULTKEY
+aws_secret_access_key = DEFAULTSECRET
+
+[ci]
+aws_access_key_id=CIKEY
+aws_secret_access_key=CISECRET
+aws_session_token=CITOKEN
+
+[partial]
+aws_access_key_id = PARTIALKEY
+`), 0600)).Should(Succeed())
+		Ω(os.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		Ω(os.RemoveAll(filepath.Dir(credentialsFile))).Should(Succeed())
+
+		for _, envName := range envNames {
+			Ω(os.Setenv(envName, oldEnv[envName])).Should(Succeed())
+		}
+	})
+
+	It("prefers credentials from the environment", func() {
+		Ω(os.Setenv("AWS_ACCESS_KEY_ID", "ENVKEY")).Should(Succeed())
+		Ω(os.Setenv("AWS_SECRET_ACCESS_KEY", "ENVSECRET")).Should(Succeed())
+		Ω(os.Setenv("AWS_SESSION_TOKEN", "ENVTOKEN")).Should(Succeed())
+
+		Ω(getAwsCredentials()).Should(Equal(&awsCredentials{accessKeyId: "ENVKEY", secretAccessKey: "ENVSECRET", sessionToken: "ENVTOKEN"}))
+	})
+
+	DescribeTable("reads the profile of the shared credentials file",
+		func(profile string, expected *awsCredentials) {
+			Ω(os.Setenv("AWS_PROFILE", profile)).Should(Succeed())
+			Ω(getAwsCredentials()).Should(Equal(expected))
+		},
+		Entry("default profile", "", &awsCredentials{accessKeyId: "DEFAULTKEY", secretAccessKey: "DEFAULTSECRET"}),
+		Entry("named profile", "ci", &awsCredentials{accessKeyId: "CIKEY", secretAccessKey: "CISECRET", sessionToken: "CITOKEN"}),
+	)
+
+	DescribeTable("fails with the description of supported credentials",
+		func(profile, credentialsFileName string) {
+			Ω(os.Setenv("AWS_PROFILE", profile)).Should(Succeed())
+			Ω(os.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(filepath.Dir(credentialsFile), credentialsFileName))).Should(Succeed())
+
+			_, err := getAwsCredentials()
+			Ω(err).Should(HaveOccurred())
+			Ω(err.Error()).Should(ContainSubstring("ECR API requires static AWS credentials"))
+			Ω(err.Error()).Should(ContainSubstring(fmt.Sprintf("profile %q", profile)))
+			Ω(err.Error()).Should(ContainSubstring("are not supported"))
+		},
+		Entry("unknown profile", "unknown", "credentials"),
+		Entry("profile without secret", "partial", "credentials"),
+		Entry("no shared credentials file", "default", "missing"),
+	)
+})
+
+var _ = Describe("aws request signing", func() {
+	It("matches the AWS Signature Version 4 example", func() {
+		req, err := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+		signAwsRequest(req, nil, "us-east-1", "iam", "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+		Ω(req.Header.Get("Authorization")).Should(Equal("AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"))
+	})
+})
+
+var _ = Describe("gitlab implementation", func() {
+	var server *httptest.Server
+	var requests *[]string
+	var implementation *gitlabImplementation
+	var repository string
+
+	BeforeEach(func() {
+		server, requests = newStandIn(func(w http.ResponseWriter, r *http.Request) {
+			switch fmt.Sprintf("%s %s", r.Method, r.URL.Path) {
+			case "GET /v2/":
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/jwt/auth",service="container_registry"`, server.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+			case "GET /jwt/auth":
+				username, password, _ := r.BasicAuth()
+				Ω([]string{username, password}).Should(Equal([]string{"user", "password"}))
+				Ω(r.URL.Query().Get("scope")).Should(Equal("repository:group/app:*"))
+				writeJSON(w, http.StatusOK, map[string]string{"token": "token"})
+			case "DELETE /v2/group/app/manifests/" + testDigest:
+				Ω(r.Header.Get("Authorization")).Should(Equal("Bearer token"))
+				w.WriteHeader(http.StatusAccepted)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		})
+
+		implementation = &gitlabImplementation{keychain: staticKeychain{"user", "password"}}
+		repository = strings.TrimPrefix(server.URL, "http://") + "/group/app"
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("deletes manifest with the token for all actions", func() {
+		Ω(implementation.DeleteImage(repository + "@" + testDigest)).Should(Succeed())
+		Ω(*requests).Should(ContainElement("DELETE /v2/group/app/manifests/" + testDigest))
+	})
+})
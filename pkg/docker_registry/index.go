@@ -0,0 +1,148 @@
+package docker_registry
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/flant/go-containerregistry/pkg/authn"
+	"github.com/flant/go-containerregistry/pkg/name"
+	v1 "github.com/flant/go-containerregistry/pkg/v1"
+	"github.com/flant/go-containerregistry/pkg/v1/remote"
+	"github.com/flant/go-containerregistry/pkg/v1/types"
+)
+
+var (
+	// platform selects the image of the index (manifest list), linux/amd64 if nil
+	platform *v1.Platform
+
+	// scannedIndexes are the digests of the manifests of the indexes by the repository and the index digest.
+	// Indexes are recorded by the tags scanning and forgotten by ImageDelete
+	scannedIndexes      = map[string]map[string][]string{}
+	scannedIndexesMutex sync.Mutex
+)
+
+// remoteManifest is the manifest of the reference: the image or the index (manifest list) with the image for the platform
+type remoteManifest struct {
+	image  v1.Image
+	digest string
+
+	isIndex               bool
+	indexManifestsDigests []string
+}
+
+func getRemoteManifest(reference string, transport http.RoundTripper) (*remoteManifest, name.Reference, error) {
+	ref, err := name.ParseReference(reference, parseReferenceOptions()...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing reference %q: %v", reference, err)
+	}
+
+	desc, err := remote.Get(ref, remoteOptions(transport)...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading image %q: %v", ref, err)
+	}
+
+	res := &remoteManifest{digest: desc.Digest.String()}
+
+	if isIndexMediaType(desc.MediaType) {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading index %q: %v", ref, err)
+		}
+
+		indexManifest, err := index.IndexManifest()
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading index %q: %v", ref, err)
+		}
+
+		res.isIndex = true
+		for _, manifestDesc := range indexManifest.Manifests {
+			res.indexManifestsDigests = append(res.indexManifestsDigests, manifestDesc.Digest.String())
+		}
+	}
+
+	res.image, err = desc.Image()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading image %q: %v", ref, err)
+	}
+
+	return res, ref, nil
+}
+
+func isIndexMediaType(mediaType types.MediaType) bool {
+	return mediaType == types.OCIImageIndex || mediaType == types.DockerManifestList
+}
+
+func remoteOptions(transport http.RoundTripper) []remote.Option {
+	options := []remote.Option{remote.WithAuthFromKeychain(authn.DefaultKeychain), remote.WithTransport(transport)}
+	if platform != nil {
+		options = append(options, remote.WithPlatform(*platform))
+	}
+
+	return options
+}
+
+// parsePlatform parses OS/ARCH[/VARIANT]
+func parsePlatform(value string) (*v1.Platform, error) {
+	parts := strings.Split(value, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("bad platform %q: expected OS/ARCH[/VARIANT]", value)
+	}
+
+	p := &v1.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+
+	return p, nil
+}
+
+func isNoPlatformImageError(err error) bool {
+	return strings.Contains(err.Error(), "no child with platform")
+}
+
+func recordScannedIndex(repository, indexDigest string, manifestsDigests []string) {
+	scannedIndexesMutex.Lock()
+	defer scannedIndexesMutex.Unlock()
+
+	key := repositoryKey(repository)
+	if _, hasKey := scannedIndexes[key]; !hasKey {
+		scannedIndexes[key] = map[string][]string{}
+	}
+
+	scannedIndexes[key][indexDigest] = manifestsDigests
+}
+
+func forgetScannedIndex(repository, indexDigest string) {
+	scannedIndexesMutex.Lock()
+	defer scannedIndexesMutex.Unlock()
+
+	delete(scannedIndexes[repositoryKey(repository)], indexDigest)
+}
+
+// IndexReferencingManifest returns the digest of the scanned index of the repository which includes the manifest,
+// empty string is returned if the manifest is not referenced by the scanned indexes (see ImagesByWerfImageLabel)
+func IndexReferencingManifest(repository, manifestDigest string) string {
+	scannedIndexesMutex.Lock()
+	defer scannedIndexesMutex.Unlock()
+
+	for indexDigest, manifestsDigests := range scannedIndexes[repositoryKey(repository)] {
+		for _, digest := range manifestsDigests {
+			if digest == manifestDigest {
+				return indexDigest
+			}
+		}
+	}
+
+	return ""
+}
+
+func repositoryKey(repository string) string {
+	repo, err := name.NewRepository(repository, newRepositoryOptions()...)
+	if err != nil {
+		return repository
+	}
+
+	return repo.Name()
+}
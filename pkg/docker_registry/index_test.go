@@ -0,0 +1,190 @@
+package docker_registry
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	v1 "github.com/flant/go-containerregistry/pkg/v1"
+	"github.com/flant/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+type testBlob struct {
+	mediaType types.MediaType
+	data      []byte
+	digest    string
+}
+
+func newTestBlob(mediaType types.MediaType, obj interface{}) *testBlob {
+	data, err := json.Marshal(obj)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	return &testBlob{mediaType: mediaType, data: data, digest: fmt.Sprintf("sha256:%x", sha256.Sum256(data))}
+}
+
+func (b *testBlob) descriptor() map[string]interface{} {
+	return map[string]interface{}{"mediaType": b.mediaType, "size": len(b.data), "digest": b.digest}
+}
+
+var _ = DescribeTable("platform parsing", func(value string, expectedPlatform *v1.Platform) {
+	p, err := parsePlatform(value)
+	if expectedPlatform == nil {
+		Ω(err).Should(HaveOccurred())
+	} else {
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(p).Should(Equal(expectedPlatform))
+	}
+},
+	Entry("os and arch", "linux/arm64", &v1.Platform{OS: "linux", Architecture: "arm64"}),
+	Entry("os, arch and variant", "linux/arm/v7", &v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}),
+	Entry("no arch", "linux", nil),
+	Entry("empty arch", "linux/", nil),
+	Entry("extra part", "linux/arm/v7/x", nil),
+)
+
+var _ = Describe("index (manifest list)", func() {
+	var server *httptest.Server
+	var repository string
+	var amd64Manifest, arm64Manifest, index *testBlob
+
+	BeforeEach(func() {
+		blobs := map[string]*testBlob{}
+		newManifest := func(arch string) *testBlob {
+			config := newTestBlob(types.DockerConfigJSON, map[string]interface{}{
+				"architecture": arch,
+				"os":           "linux",
+				"config":       map[string]interface{}{"Labels": map[string]string{"arch": arch}},
+				"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": []string{}},
+			})
+			blobs[config.digest] = config
+
+			return newTestBlob(types.DockerManifestSchema2, map[string]interface{}{
+				"schemaVersion": 2,
+				"mediaType":     types.DockerManifestSchema2,
+				"config":        config.descriptor(),
+				"layers":        []interface{}{},
+			})
+		}
+
+		amd64Manifest = newManifest("amd64")
+		arm64Manifest = newManifest("arm64")
+
+		amd64Descriptor := amd64Manifest.descriptor()
+		amd64Descriptor["platform"] = map[string]string{"os": "linux", "architecture": "amd64"}
+		arm64Descriptor := arm64Manifest.descriptor()
+		arm64Descriptor["platform"] = map[string]string{"os": "linux", "architecture": "arm64"}
+		index = newTestBlob(types.DockerManifestList, map[string]interface{}{
+			"schemaVersion": 2,
+			"mediaType":     types.DockerManifestList,
+			"manifests":     []interface{}{amd64Descriptor, arm64Descriptor},
+		})
+
+		manifests := map[string]*testBlob{
+			"multi":              index,
+			"single":             amd64Manifest,
+			index.digest:         index,
+			amd64Manifest.digest: amd64Manifest,
+			arm64Manifest.digest: arm64Manifest,
+		}
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var b *testBlob
+			switch {
+			case r.URL.Path == "/v2/":
+				w.WriteHeader(http.StatusOK)
+				return
+			case strings.HasPrefix(r.URL.Path, "/v2/app/manifests/"):
+				b = manifests[strings.TrimPrefix(r.URL.Path, "/v2/app/manifests/")]
+			case strings.HasPrefix(r.URL.Path, "/v2/app/blobs/"):
+				b = blobs[strings.TrimPrefix(r.URL.Path, "/v2/app/blobs/")]
+			}
+
+			if b == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			w.Header().Set("Content-Type", string(b.mediaType))
+			w.Header().Set("Docker-Content-Digest", b.digest)
+			_, _ = w.Write(b.data)
+		}))
+
+		repository = strings.TrimPrefix(server.URL, "http://") + "/app"
+	})
+
+	AfterEach(func() {
+		server.Close()
+		platform = nil
+	})
+
+	manifestArch := func(m *remoteManifest) string {
+		configFile, err := m.image.ConfigFile()
+		Ω(err).ShouldNot(HaveOccurred())
+		return configFile.Config.Labels["arch"]
+	}
+
+	It("reads the index digest and the image for linux/amd64 by default", func() {
+		m, _, err := getRemoteManifest(repository+":multi", http.DefaultTransport)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(m.isIndex).Should(BeTrue())
+		Ω(m.digest).Should(Equal(index.digest))
+		Ω(m.indexManifestsDigests).Should(Equal([]string{amd64Manifest.digest, arm64Manifest.digest}))
+		Ω(manifestArch(m)).Should(Equal("amd64"))
+
+		digest, err := m.image.Digest()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(digest.String()).Should(Equal(amd64Manifest.digest))
+	})
+
+	It("reads the image for the selected platform", func() {
+		platform = &v1.Platform{OS: "linux", Architecture: "arm64"}
+
+		m, _, err := getRemoteManifest(repository+":multi", http.DefaultTransport)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(manifestArch(m)).Should(Equal("arm64"))
+	})
+
+	It("returns the error if the index has no image for the platform", func() {
+		platform = &v1.Platform{OS: "windows", Architecture: "amd64"}
+
+		_, _, err := getRemoteManifest(repository+":multi", http.DefaultTransport)
+		Ω(err).Should(HaveOccurred())
+		Ω(isNoPlatformImageError(err)).Should(BeTrue())
+	})
+
+	It("reads the image manifest", func() {
+		m, _, err := getRemoteManifest(repository+":single", http.DefaultTransport)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(m.isIndex).Should(BeFalse())
+		Ω(m.digest).Should(Equal(amd64Manifest.digest))
+		Ω(manifestArch(m)).Should(Equal("amd64"))
+	})
+
+	It("returns the index digest of the repo image", func() {
+		repoImage, err := GetRepoImage(repository, "multi")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(repoImage.ManifestDigest()).Should(Equal(index.digest))
+
+		repoImage, err = GetRepoImage(repository, "single")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(repoImage.ManifestDigest()).Should(Equal(amd64Manifest.digest))
+	})
+
+	It("returns the scanned index referencing the manifest until the index is forgotten", func() {
+		recordScannedIndex(repository, index.digest, []string{amd64Manifest.digest, arm64Manifest.digest})
+
+		Ω(IndexReferencingManifest(repository, arm64Manifest.digest)).Should(Equal(index.digest))
+		Ω(IndexReferencingManifest(repository, testDigest)).Should(BeEmpty())
+
+		forgetScannedIndex(repository, index.digest)
+		Ω(IndexReferencingManifest(repository, arm64Manifest.digest)).Should(BeEmpty())
+	})
+})
@@ -3,17 +3,14 @@ package docker_registry
 import (
 	"crypto/tls"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"regexp"
+	"sort"
 	"strings"
 
-	"github.com/flant/go-containerregistry/pkg/authn"
 	"github.com/flant/go-containerregistry/pkg/name"
 	v1 "github.com/flant/go-containerregistry/pkg/v1"
-	"github.com/flant/go-containerregistry/pkg/v1/remote"
-	"github.com/flant/go-containerregistry/pkg/v1/remote/transport"
 	"github.com/flant/logboek"
 
 	imagePkg "github.com/flant/werf/pkg/image"
@@ -22,23 +19,61 @@ import (
 var (
 	InsecureRegistry      = false
 	SkipTlsVerifyRegistry = false
+	RepoImplementation    = ""
+	Platform              = ""
 	GCRUrlPatterns        = []string{"^container\\.cloud\\.google\\.com", "^gcr\\.io", "^.*\\.gcr\\.io"}
 )
 
+// RepoImage is the image of the repository tag, the image for the platform if the tag is an index (manifest list)
 type RepoImage struct {
 	Repository string
 	Tag        string
 	v1.Image
+
+	// IndexDigest is set if the tag is an index
+	IndexDigest string
+}
+
+// ManifestDigest returns the digest of the tag manifest: the digest of the index if the tag is an index
+func (i RepoImage) ManifestDigest() (string, error) {
+	if i.IndexDigest != "" {
+		return i.IndexDigest, nil
+	}
+
+	digest, err := i.Image.Digest()
+	if err != nil {
+		return "", err
+	}
+
+	return digest.String(), nil
 }
 
 type Options struct {
 	InsecureRegistry      bool
 	SkipTlsVerifyRegistry bool
+	RepoImplementation    string // detected by the registry hostname if empty
+	Platform              string // OS/ARCH[/VARIANT] to select the image of the index (manifest list), linux/amd64 if empty
 }
 
 func Init(opts Options) error {
+	if opts.RepoImplementation != "" && !IsImplementationSupported(opts.RepoImplementation) {
+		return fmt.Errorf("unsupported repo implementation %q: expected one of %s", opts.RepoImplementation, strings.Join(ImplementationNames, ", "))
+	}
+
+	platform = nil
+	if opts.Platform != "" {
+		p, err := parsePlatform(opts.Platform)
+		if err != nil {
+			return err
+		}
+
+		platform = p
+	}
+
 	InsecureRegistry = opts.InsecureRegistry
 	SkipTlsVerifyRegistry = opts.SkipTlsVerifyRegistry
+	RepoImplementation = opts.RepoImplementation
+	Platform = opts.Platform
 	return nil
 }
 
@@ -62,41 +97,41 @@ func IsGCR(reference string) (bool, error) {
 	return false, nil
 }
 
+// ImagesByWerfImageLabel returns images of the repository with the specified werf image label value.
+// Tags are scanned concurrently, images configs are taken from the local cache when possible (see scanTags).
+// If the registry API returns labels, tags with the other label value are not scanned
 func ImagesByWerfImageLabel(reference, labelValue string) ([]RepoImage, error) {
 	var repoImages []RepoImage
 
-	tags, err := Tags(reference)
+	tags, err := tagsByWerfImageLabel(reference, labelValue)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, tag := range tags {
-		tagReference := strings.Join([]string{reference, tag}, ":")
-		v1Image, _, err := image(tagReference)
-		if err != nil {
-			if strings.Contains(err.Error(), "MANIFEST_UNKNOWN") {
-				logboek.LogErrorF("WARNING: Broken tag %s was skipped: %s\n", tagReference, err)
-				continue
-			}
+	if len(tags) == 0 {
+		return nil, nil
+	}
 
-			if strings.Contains(err.Error(), "BLOB_UNKNOWN") {
-				logboek.LogErrorF("WARNING: Broken tag %s was skipped: %s\n", tagReference, err)
-				continue
-			}
-			return nil, err
-		}
+	var scannedImages []*scannedImage
+	if err := logboek.LogProcess(fmt.Sprintf("Scanning %d tags of %s", len(tags), reference), logboek.LogProcessOptions{}, func() error {
+		scannedImages, err = scanTags(reference, tags)
+		return err
+	}); err != nil {
+		return nil, err
+	}
 
-		configFile, err := v1Image.ConfigFile()
-		if err != nil {
-			return nil, err
+	for _, scanned := range scannedImages {
+		if scanned == nil {
+			continue
 		}
 
-		for k, v := range configFile.Config.Labels {
+		for k, v := range scanned.configFile.Config.Labels {
 			if k == imagePkg.WerfImageLabel && v == labelValue {
 				repoImage := RepoImage{
-					Repository: reference,
-					Tag:        tag,
-					Image:      v1Image,
+					Repository:  reference,
+					Tag:         scanned.tag,
+					Image:       scanned.image,
+					IndexDigest: scanned.indexDigest,
 				}
 
 				repoImages = append(repoImages, repoImage)
@@ -108,30 +143,55 @@ func ImagesByWerfImageLabel(reference, labelValue string) ([]RepoImage, error) {
 	return repoImages, nil
 }
 
-func Tags(reference string) ([]string, error) {
-	tags, err := list(reference)
+func tagsByWerfImageLabel(reference, labelValue string) ([]string, error) {
+	implementation, err := implementationByRepository(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	getter, ok := implementation.(tagsLabelsGetter)
+	if !ok {
+		return implementation.Tags(reference)
+	}
+
+	labelsByTag, err := getter.TagsLabels(reference)
 	if err != nil {
-		if strings.Contains(err.Error(), "NAME_UNKNOWN") {
-			return []string{}, nil
-		}
 		return nil, err
 	}
 
+	// tags without the label are scanned: indexes (manifest lists) have no labels
+	var tags []string
+	for tag, labels := range labelsByTag {
+		if value, hasLabel := labels[imagePkg.WerfImageLabel]; !hasLabel || value == labelValue {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+
 	return tags, nil
 }
 
-func list(reference string) ([]string, error) {
-	repo, err := name.NewRepository(reference, newRepositoryOptions()...)
+func GetRepoImage(repository, tag string) (*RepoImage, error) {
+	m, _, err := manifest(strings.Join([]string{repository, tag}, ":"))
 	if err != nil {
-		return nil, fmt.Errorf("parsing repo %q: %v", reference, err)
+		return nil, err
 	}
 
-	tags, err := remote.List(repo, remote.WithAuthFromKeychain(authn.DefaultKeychain), remote.WithTransport(getHttpTransport()))
+	repoImage := &RepoImage{Repository: repository, Tag: tag, Image: m.image}
+	if m.isIndex {
+		repoImage.IndexDigest = m.digest
+	}
+
+	return repoImage, nil
+}
+
+func Tags(reference string) ([]string, error) {
+	implementation, err := implementationByRepository(reference)
 	if err != nil {
-		return nil, fmt.Errorf("reading tags for %q: %v", repo, err)
+		return nil, err
 	}
 
-	return tags, nil
+	return implementation.Tags(reference)
 }
 
 func ImageId(reference string) (string, error) {
@@ -172,103 +232,48 @@ func ImageConfigFile(reference string) (v1.ConfigFile, error) {
 }
 
 func ImageDelete(reference string) error {
-	r, err := name.ParseReference(reference, parseReferenceOptions()...)
-	if err != nil {
-		return fmt.Errorf("parsing reference %q: %v", reference, err)
-	}
-
-	if deleteErr := remote.Delete(r, remote.WithAuthFromKeychain(authn.DefaultKeychain), remote.WithTransport(getHttpTransport())); deleteErr != nil {
-		if strings.Contains(deleteErr.Error(), "UNAUTHORIZED") {
-			auth, authErr := authn.DefaultKeychain.Resolve(r.Context().Registry)
-			if authErr != nil {
-				return fmt.Errorf("getting creds for %q: %v", r, authErr)
-			}
-
-			if gitlabRegistryDeleteErr := GitlabRegistryDelete(r, auth, getHttpTransport()); gitlabRegistryDeleteErr != nil {
-				if strings.Contains(gitlabRegistryDeleteErr.Error(), "UNAUTHORIZED") {
-					return fmt.Errorf("deleting image %q: %v", r, deleteErr)
-				}
-				return fmt.Errorf("deleting image %q: %v", r, gitlabRegistryDeleteErr)
-			}
-		} else {
-			return fmt.Errorf("deleting image %q: %v", r, deleteErr)
-		}
-	}
-
-	return nil
-}
-
-// TODO https://gitlab.com/gitlab-org/gitlab-ce/issues/48968
-func GitlabRegistryDelete(ref name.Reference, auth authn.Authenticator, t http.RoundTripper) error {
-	scopes := []string{ref.Scope("*")}
-	tr, err := transport.New(ref.Context().Registry, auth, t, scopes)
+	implementation, err := implementationByReference(reference)
 	if err != nil {
 		return err
 	}
-	c := &http.Client{Transport: tr}
 
-	u := url.URL{
-		Scheme: ref.Context().Registry.Scheme(),
-		Host:   ref.Context().RegistryStr(),
-		Path:   fmt.Sprintf("/v2/%s/manifests/%s", ref.Context().RepositoryStr(), ref.Identifier()),
-	}
-
-	req, err := http.NewRequest(http.MethodDelete, u.String(), nil)
-	if err != nil {
+	if err := implementation.DeleteImage(reference); err != nil {
 		return err
 	}
 
-	resp, err := c.Do(req)
-	if err != nil {
-		return err
+	if ref, err := name.NewDigest(reference, parseReferenceOptions()...); err == nil {
+		forgetScannedIndex(ref.Context().Name(), ref.DigestStr())
 	}
-	defer resp.Body.Close()
 
-	switch resp.StatusCode {
-	case http.StatusOK, http.StatusAccepted:
-		return nil
-	default:
-		b, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
-		return fmt.Errorf("unrecognized status code during DELETE: %v; %v", resp.Status, string(b))
+	if ref, err := name.ParseReference(reference, parseReferenceOptions()...); err == nil {
+		forgetScannedTags(ref)
 	}
+
+	return nil
 }
 
+// ImageDigest returns the digest of the reference manifest: the digest of the index if the reference is an index (manifest list)
 func ImageDigest(reference string) (string, error) {
-	i, _, err := image(reference)
+	m, _, err := manifest(reference)
 	if err != nil {
 		return "", err
 	}
 
-	digest, err := i.Digest()
-	if err != nil {
-		return "", err
-	}
-
-	return digest.String(), nil
+	return m.digest, nil
 }
 
+// image returns the image of the reference, the image for the platform if the reference is an index (manifest list)
 func image(reference string) (v1.Image, name.Reference, error) {
-	ref, err := name.ParseReference(reference, parseReferenceOptions()...)
+	m, ref, err := manifest(reference)
 	if err != nil {
-		return nil, nil, fmt.Errorf("parsing reference %q: %v", reference, err)
+		return nil, nil, err
 	}
 
-	// FIXME: Hack for the go-containerregistry library,
-	// FIXME: that uses default transport without options to change transport to custom.
-	// FIXME: Needed for the insecure https registry to work.
-	oldDefaultTransport := http.DefaultTransport
-	http.DefaultTransport = getHttpTransport()
-	img, err := remote.Image(ref, remote.WithAuthFromKeychain(authn.DefaultKeychain))
-	http.DefaultTransport = oldDefaultTransport
-
-	if err != nil {
-		return nil, nil, fmt.Errorf("reading image %q: %v", ref, err)
-	}
+	return m.image, ref, nil
+}
 
-	return img, ref, nil
+func manifest(reference string) (*remoteManifest, name.Reference, error) {
+	return getRemoteManifest(reference, getHttpTransport())
 }
 
 func newRepositoryOptions() []name.Option {
@@ -306,5 +311,5 @@ func getHttpTransport() (transport http.RoundTripper) {
 		transport = newTransport
 	}
 
-	return
+	return newRetryTransport(transport)
 }
@@ -0,0 +1,202 @@
+package docker_registry
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/flant/go-containerregistry/pkg/authn"
+	"github.com/flant/go-containerregistry/pkg/name"
+)
+
+const (
+	quayTagsPageSize = 100
+
+	// quayOAuthTokenUsername is the docker login username to authenticate with OAuth access token,
+	// the same token is used for Quay API
+	quayOAuthTokenUsername = "$oauthtoken"
+)
+
+// quayImplementation uses Quay API: docker registry API does not allow to remove manifests,
+// manifest labels are read without downloading configs
+type quayImplementation struct {
+	keychain authn.Keychain
+}
+
+type quayTag struct {
+	Name           string `json:"name"`
+	ManifestDigest string `json:"manifest_digest"`
+}
+
+func newQuayImplementation() *quayImplementation {
+	return &quayImplementation{keychain: authn.DefaultKeychain}
+}
+
+func (i *quayImplementation) Name() string {
+	return QuayImplementationName
+}
+
+func (i *quayImplementation) IsDeleteByTag() bool {
+	return true
+}
+
+func (i *quayImplementation) Tags(repository string) ([]string, error) {
+	quayTags, err := i.tags(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []string{}
+	for _, tag := range quayTags {
+		tags = append(tags, tag.Name)
+	}
+
+	return tags, nil
+}
+
+func (i *quayImplementation) TagsLabels(repository string) (map[string]map[string]string, error) {
+	repo, err := name.NewRepository(repository, newRepositoryOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("parsing repo %q: %v", repository, err)
+	}
+
+	quayTags, err := i.tags(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	labelsByDigest := map[string]map[string]string{}
+	labelsByTag := map[string]map[string]string{}
+	for _, tag := range quayTags {
+		labels, hasLabels := labelsByDigest[tag.ManifestDigest]
+		if !hasLabels {
+			labels, err = i.manifestLabels(repo, tag.ManifestDigest)
+			if err != nil {
+				return nil, err
+			}
+
+			labelsByDigest[tag.ManifestDigest] = labels
+		}
+
+		labelsByTag[tag.Name] = labels
+	}
+
+	return labelsByTag, nil
+}
+
+func (i *quayImplementation) DeleteImage(reference string) error {
+	ref, err := name.ParseReference(reference, parseReferenceOptions()...)
+	if err != nil {
+		return fmt.Errorf("parsing reference %q: %v", reference, err)
+	}
+
+	tag, ok := ref.(name.Tag)
+	if !ok {
+		return fmt.Errorf("deleting image %q: Quay supports removing images by tag only", ref)
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/tag/%s", i.repositoryUrl(ref.Context()), tag.TagStr()), nil)
+	if err != nil {
+		return err
+	}
+
+	if err := i.setAuthorization(req, ref.Context().Registry, true); err != nil {
+		return err
+	}
+
+	if err := doApiRequest(req, nil); err != nil {
+		return fmt.Errorf("deleting image %q: %v", ref, err)
+	}
+
+	return nil
+}
+
+func (i *quayImplementation) tags(repository string) ([]*quayTag, error) {
+	repo, err := name.NewRepository(repository, newRepositoryOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("parsing repo %q: %v", repository, err)
+	}
+
+	var tags []*quayTag
+	for page := 1; ; page++ {
+		u := fmt.Sprintf("%s/tag/?onlyActiveTags=true&limit=%d&page=%d", i.repositoryUrl(repo), quayTagsPageSize, page)
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := i.setAuthorization(req, repo.Registry, false); err != nil {
+			return nil, err
+		}
+
+		var resp struct {
+			Tags          []*quayTag `json:"tags"`
+			HasAdditional bool       `json:"has_additional"`
+		}
+
+		if err := doApiRequest(req, &resp); err != nil {
+			if isApiResponseErrorWithStatus(err, http.StatusNotFound) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("reading tags for %q: %v", repo, err)
+		}
+
+		tags = append(tags, resp.Tags...)
+
+		if !resp.HasAdditional {
+			return tags, nil
+		}
+	}
+}
+
+func (i *quayImplementation) manifestLabels(repo name.Repository, digest string) (map[string]string, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/manifest/%s/labels", i.repositoryUrl(repo), digest), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := i.setAuthorization(req, repo.Registry, false); err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Labels []struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		} `json:"labels"`
+	}
+
+	if err := doApiRequest(req, &resp); err != nil {
+		return nil, fmt.Errorf("reading labels of %s@%s: %v", repo, digest, err)
+	}
+
+	labels := map[string]string{}
+	for _, label := range resp.Labels {
+		labels[label.Key] = label.Value
+	}
+
+	return labels, nil
+}
+
+func (i *quayImplementation) repositoryUrl(repo name.Repository) string {
+	return fmt.Sprintf("%s://%s/api/v1/repository/%s", repo.Registry.Scheme(), repo.RegistryStr(), repo.RepositoryStr())
+}
+
+// setAuthorization uses OAuth access token from docker credentials (username $oauthtoken),
+// the token is not required to read public repositories
+func (i *quayImplementation) setAuthorization(req *http.Request, registry name.Registry, isRequired bool) error {
+	username, password, err := basicCredentials(i.keychain, registry)
+	if err != nil {
+		return err
+	}
+
+	if username != quayOAuthTokenUsername {
+		if isRequired {
+			return fmt.Errorf("Quay API requires OAuth access token: use docker login with username %s and the token as password", quayOAuthTokenUsername)
+		}
+		return nil
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", password))
+
+	return nil
+}
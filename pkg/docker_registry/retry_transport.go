@@ -0,0 +1,86 @@
+package docker_registry
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	requestMaxRetries     = 5
+	requestInitialBackoff = time.Second
+	requestMaxBackoff     = 30 * time.Second
+)
+
+// retriedRequestsCount is reported by the scanning progress
+var retriedRequestsCount int64
+
+// retryTransport retries idempotent requests throttled by the registry (429 Too Many Requests) or failed with 5xx
+// with exponential backoff, Retry-After header is respected
+type retryTransport struct {
+	inner          http.RoundTripper
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+func newRetryTransport(inner http.RoundTripper) http.RoundTripper {
+	return &retryTransport{inner: inner, initialBackoff: requestInitialBackoff, maxBackoff: requestMaxBackoff}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return t.inner.RoundTrip(req)
+	}
+
+	backoff := t.initialBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := t.inner.RoundTrip(req)
+		if err != nil || attempt == requestMaxRetries || !isRetryableStatusCode(resp.StatusCode) {
+			return resp, err
+		}
+
+		delay := t.retryAfter(resp, backoff)
+
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		atomic.AddInt64(&retriedRequestsCount, 1)
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+
+		backoff *= 2
+		if backoff > t.maxBackoff {
+			backoff = t.maxBackoff
+		}
+	}
+}
+
+func isRetryableStatusCode(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryAfter returns the delay from Retry-After header (in seconds) limited by the max backoff or the current backoff
+func (t *retryTransport) retryAfter(resp *http.Response, backoff time.Duration) time.Duration {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		delay := time.Duration(seconds) * time.Second
+		if delay > t.maxBackoff {
+			return t.maxBackoff
+		}
+
+		return delay
+	}
+
+	return backoff
+}
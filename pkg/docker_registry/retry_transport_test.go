@@ -0,0 +1,133 @@
+package docker_registry
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// statusesRoundTripper responds with the statuses in order (the last one is repeated) and records the requests times
+type statusesRoundTripper struct {
+	statuses   []int
+	retryAfter string
+	times      []time.Time
+}
+
+func (t *statusesRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	statusCode := t.statuses[len(t.statuses)-1]
+	if len(t.times) < len(t.statuses) {
+		statusCode = t.statuses[len(t.times)]
+	}
+
+	t.times = append(t.times, time.Now())
+
+	resp := &http.Response{StatusCode: statusCode, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader("body")), Request: req}
+	if t.retryAfter != "" {
+		resp.Header.Set("Retry-After", t.retryAfter)
+	}
+
+	return resp, nil
+}
+
+var _ = Describe("retry transport", func() {
+	newTransport := func(inner http.RoundTripper) *retryTransport {
+		return &retryTransport{inner: inner, initialBackoff: 10 * time.Millisecond, maxBackoff: 40 * time.Millisecond}
+	}
+
+	doRequest := func(t http.RoundTripper, method string) *http.Response {
+		req, err := http.NewRequest(method, "https://registry.example.com/v2/app/manifests/latest", nil)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		resp, err := t.RoundTrip(req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		return resp
+	}
+
+	DescribeTable("retries throttled and failed idempotent requests",
+		func(method string, statusCode int) {
+			inner := &statusesRoundTripper{statuses: []int{statusCode, statusCode, http.StatusOK}}
+			retriedBefore := atomic.LoadInt64(&retriedRequestsCount)
+
+			Ω(doRequest(newTransport(inner), method).StatusCode).Should(Equal(http.StatusOK))
+			Ω(inner.times).Should(HaveLen(3))
+			Ω(atomic.LoadInt64(&retriedRequestsCount) - retriedBefore).Should(Equal(int64(2)))
+		},
+		Entry("GET 429", http.MethodGet, http.StatusTooManyRequests),
+		Entry("GET 502", http.MethodGet, http.StatusBadGateway),
+		Entry("HEAD 503", http.MethodHead, http.StatusServiceUnavailable),
+	)
+
+	DescribeTable("does not retry",
+		func(method string, statusCode int) {
+			inner := &statusesRoundTripper{statuses: []int{statusCode, http.StatusOK}}
+
+			Ω(doRequest(newTransport(inner), method).StatusCode).Should(Equal(statusCode))
+			Ω(inner.times).Should(HaveLen(1))
+		},
+		Entry("POST", http.MethodPost, http.StatusServiceUnavailable),
+		Entry("PUT", http.MethodPut, http.StatusTooManyRequests),
+		Entry("DELETE", http.MethodDelete, http.StatusInternalServerError),
+		Entry("not found", http.MethodGet, http.StatusNotFound),
+		Entry("unauthorized", http.MethodGet, http.StatusUnauthorized),
+	)
+
+	It("gives up after max retries and returns the last response", func() {
+		inner := &statusesRoundTripper{statuses: []int{http.StatusServiceUnavailable}}
+
+		resp := doRequest(newTransport(inner), http.MethodGet)
+		Ω(resp.StatusCode).Should(Equal(http.StatusServiceUnavailable))
+		Ω(ioutil.ReadAll(resp.Body)).Should(Equal([]byte("body")))
+		Ω(inner.times).Should(HaveLen(requestMaxRetries + 1))
+	})
+
+	It("doubles the backoff up to the max backoff", func() {
+		inner := &statusesRoundTripper{statuses: []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusOK}}
+
+		Ω(doRequest(newTransport(inner), http.MethodGet).StatusCode).Should(Equal(http.StatusOK))
+		Ω(inner.times).Should(HaveLen(5))
+
+		for ind, expectedDelay := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 40 * time.Millisecond} {
+			Ω(inner.times[ind+1].Sub(inner.times[ind])).Should(BeNumerically(">=", expectedDelay))
+		}
+	})
+
+	It("stops waiting when the request is cancelled", func() {
+		inner := &statusesRoundTripper{statuses: []int{http.StatusServiceUnavailable}}
+		t := &retryTransport{inner: inner, initialBackoff: time.Hour, maxBackoff: time.Hour}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		req, err := http.NewRequest(http.MethodGet, "https://registry.example.com/v2/", nil)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		_, err = t.RoundTrip(req.WithContext(ctx))
+		Ω(err).Should(Equal(context.DeadlineExceeded))
+		Ω(inner.times).Should(HaveLen(1))
+	})
+
+	DescribeTable("respects Retry-After header",
+		func(retryAfter string, expectedDelay time.Duration) {
+			t := &retryTransport{initialBackoff: time.Second, maxBackoff: 30 * time.Second}
+			resp := &http.Response{Header: http.Header{}}
+			if retryAfter != "" {
+				resp.Header.Set("Retry-After", retryAfter)
+			}
+
+			Ω(t.retryAfter(resp, 4*time.Second)).Should(Equal(expectedDelay))
+		},
+		Entry("no header", "", 4*time.Second),
+		Entry("seconds", "2", 2*time.Second),
+		Entry("seconds over the max backoff", "120", 30*time.Second),
+		Entry("zero", "0", 4*time.Second),
+		Entry("http date", "Wed, 21 Oct 2015 07:28:00 GMT", 4*time.Second),
+	)
+})
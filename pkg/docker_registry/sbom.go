@@ -0,0 +1,38 @@
+package docker_registry
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flant/go-containerregistry/pkg/name"
+	"github.com/flant/go-containerregistry/pkg/v1/remote"
+	"github.com/flant/go-containerregistry/pkg/v1/types"
+)
+
+// SBOM is attached by the cosign convention: the image REPOSITORY:sha256-HEX.sbom with the single layer of the SBOM document
+const SBOMTagSuffix = ".sbom"
+
+// SBOMTag returns the tag of the SBOM of the manifest digest
+func SBOMTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + SBOMTagSuffix
+}
+
+// PushImageSBOM replaces the SBOM document of the manifest digest of the repository
+func PushImageSBOM(repository, digest, mediaType string, data []byte) error {
+	reference := strings.Join([]string{repository, SBOMTag(digest)}, ":")
+	ref, err := name.ParseReference(reference, parseReferenceOptions()...)
+	if err != nil {
+		return fmt.Errorf("parsing reference %q: %v", reference, err)
+	}
+
+	img, err := newArtifactImage([]*artifactImageLayer{{mediaType: types.MediaType(mediaType), data: data}})
+	if err != nil {
+		return err
+	}
+
+	if err := remote.Write(ref, img, remoteOptions(getHttpTransport())...); err != nil {
+		return fmt.Errorf("writing SBOM %q: %v", ref, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+package docker_registry
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	v1 "github.com/flant/go-containerregistry/pkg/v1"
+	"github.com/flant/logboek"
+)
+
+const (
+	scanWorkersCount   = 10
+	scanProgressPeriod = 5 * time.Second
+)
+
+type scannedImage struct {
+	tag         string
+	image       v1.Image
+	configFile  *v1.ConfigFile
+	indexDigest string
+}
+
+// cachedConfigImage serves the config from the local cache, other data is read from the registry
+type cachedConfigImage struct {
+	v1.Image
+	configFile *v1.ConfigFile
+}
+
+func (i *cachedConfigImage) ConfigFile() (*v1.ConfigFile, error) {
+	return i.configFile, nil
+}
+
+// scanTags reads manifests and configs of the tags by the bounded pool of workers.
+// The result keeps the order of the tags, broken tags and indexes without the image for the platform are skipped with the warning (nil result).
+// Configs are content-addressed: the config is fetched only if its digest from the manifest is not cached yet.
+// Scanned indexes are recorded to protect their manifests from removal (see IndexReferencingManifest)
+func scanTags(reference string, tags []string) ([]*scannedImage, error) {
+	results := make([]*scannedImage, len(tags))
+
+	var scannedCount, cachedCount int64
+	var firstErr error
+	var firstErrOnce sync.Once
+	var isFailed int32
+
+	var warnings []string
+	var warningsMutex sync.Mutex
+
+	jobs := make(chan int)
+	done := make(chan struct{})
+
+	retriedRequestsCountBefore := atomic.LoadInt64(&retriedRequestsCount)
+
+	// the transport is shared by the workers of the scan to reuse connections
+	transport := getHttpTransport()
+
+	var wg sync.WaitGroup
+	for i := 0; i < scanWorkersCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for ind := range jobs {
+				if atomic.LoadInt32(&isFailed) == 1 {
+					continue
+				}
+
+				res, isCached, err := scanTag(reference, tags[ind], transport)
+				if err != nil {
+					if isBrokenImageError(err) || isNoPlatformImageError(err) {
+						warningsMutex.Lock()
+						warnings = append(warnings, fmt.Sprintf("WARNING: Broken tag %s:%s was skipped: %s\n", reference, tags[ind], err))
+						warningsMutex.Unlock()
+					} else {
+						firstErrOnce.Do(func() {
+							firstErr = err
+							atomic.StoreInt32(&isFailed, 1)
+						})
+					}
+				}
+
+				results[ind] = res
+				if isCached {
+					atomic.AddInt64(&cachedCount, 1)
+				}
+				atomic.AddInt64(&scannedCount, 1)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+
+		for ind := range tags {
+			if atomic.LoadInt32(&isFailed) == 1 {
+				return
+			}
+
+			jobs <- ind
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// logboek is used only by this goroutine
+	ticker := time.NewTicker(scanProgressPeriod)
+	defer ticker.Stop()
+
+progressLoop:
+	for {
+		select {
+		case <-ticker.C:
+			logScanProgress(len(tags), atomic.LoadInt64(&scannedCount), atomic.LoadInt64(&cachedCount), atomic.LoadInt64(&retriedRequestsCount)-retriedRequestsCountBefore)
+		case <-done:
+			break progressLoop
+		}
+	}
+
+	for _, warning := range warnings {
+		logboek.LogErrorF("%s", warning)
+	}
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	logScanProgress(len(tags), scannedCount, cachedCount, atomic.LoadInt64(&retriedRequestsCount)-retriedRequestsCountBefore)
+
+	return results, nil
+}
+
+func logScanProgress(tagsCount int, scannedCount, cachedCount, retriedCount int64) {
+	msg := fmt.Sprintf("Scanned %d/%d tags (configs from cache: %d", scannedCount, tagsCount, cachedCount)
+	if retriedCount != 0 {
+		msg += fmt.Sprintf(", retried requests: %d", retriedCount)
+	}
+	msg += ")"
+
+	logboek.LogInfoF("%s\n", msg)
+}
+
+func scanTag(reference, tag string, transport http.RoundTripper) (*scannedImage, bool, error) {
+	tagReference := strings.Join([]string{reference, tag}, ":")
+
+	m, _, err := getRemoteManifest(tagReference, transport)
+	if err != nil {
+		return nil, false, err
+	}
+
+	recordScannedTag(reference, tag, m.digest)
+
+	var indexDigest string
+	if m.isIndex {
+		indexDigest = m.digest
+		recordScannedIndex(reference, m.digest, m.indexManifestsDigests)
+	}
+
+	v1Image := m.image
+	manifest, err := v1Image.Manifest()
+	if err != nil {
+		return nil, false, fmt.Errorf("reading manifest of %q: %v", tagReference, err)
+	}
+
+	configDigest := manifest.Config.Digest.String()
+
+	configFile, err := getCachedConfigFile(configDigest)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if configFile != nil {
+		return &scannedImage{tag: tag, image: &cachedConfigImage{Image: v1Image, configFile: configFile}, configFile: configFile, indexDigest: indexDigest}, true, nil
+	}
+
+	rawConfig, err := v1Image.RawConfigFile()
+	if err != nil {
+		return nil, false, fmt.Errorf("reading config of %q: %v", tagReference, err)
+	}
+
+	configFile, err = v1.ParseConfigFile(bytes.NewReader(rawConfig))
+	if err != nil {
+		return nil, false, fmt.Errorf("parsing config of %q: %v", tagReference, err)
+	}
+
+	if err := putCachedConfigFile(configDigest, rawConfig); err != nil {
+		return nil, false, err
+	}
+
+	return &scannedImage{tag: tag, image: &cachedConfigImage{Image: v1Image, configFile: configFile}, configFile: configFile, indexDigest: indexDigest}, false, nil
+}
+
+func isBrokenImageError(err error) bool {
+	return strings.Contains(err.Error(), "MANIFEST_UNKNOWN") || strings.Contains(err.Error(), "BLOB_UNKNOWN")
+}
@@ -0,0 +1,149 @@
+package docker_registry
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/flant/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/werf/pkg/werf"
+)
+
+var _ = Describe("tags scanning", func() {
+	var server *httptest.Server
+	var repository string
+	var tags []string
+	var homeDir string
+
+	var blobsRequests []string
+	var mutex sync.Mutex
+
+	BeforeEach(func() {
+		var err error
+		homeDir, err = ioutil.TempDir("", "werf-scan-test")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(werf.Init(homeDir, homeDir)).Should(Succeed())
+
+		blobsRequests = nil
+
+		blobs := map[string]*testBlob{}
+		manifests := map[string]*testBlob{}
+
+		tags = nil
+		for i := 0; i < 25; i++ {
+			tag := fmt.Sprintf("tag-%02d", i)
+			tags = append(tags, tag)
+
+			config := newTestBlob(types.DockerConfigJSON, map[string]interface{}{
+				"architecture": "amd64",
+				"os":           "linux",
+				"config":       map[string]interface{}{"Labels": map[string]string{"tag": tag}},
+				"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": []string{}},
+			})
+			blobs[config.digest] = config
+
+			manifests[tag] = newTestBlob(types.DockerManifestSchema2, map[string]interface{}{
+				"schemaVersion": 2,
+				"mediaType":     types.DockerManifestSchema2,
+				"config":        config.descriptor(),
+				"layers":        []interface{}{},
+			})
+		}
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var b *testBlob
+			switch {
+			case r.URL.Path == "/v2/":
+				w.WriteHeader(http.StatusOK)
+				return
+			case r.URL.Path == "/v2/app/manifests/broken":
+				writeJSON(w, http.StatusNotFound, map[string]interface{}{"errors": []interface{}{map[string]string{"code": "MANIFEST_UNKNOWN", "message": "manifest unknown"}}})
+				return
+			case r.URL.Path == "/v2/app/manifests/denied":
+				writeJSON(w, http.StatusForbidden, map[string]interface{}{"errors": []interface{}{map[string]string{"code": "DENIED", "message": "access denied"}}})
+				return
+			case strings.HasPrefix(r.URL.Path, "/v2/app/manifests/"):
+				b = manifests[strings.TrimPrefix(r.URL.Path, "/v2/app/manifests/")]
+			case strings.HasPrefix(r.URL.Path, "/v2/app/blobs/"):
+				mutex.Lock()
+				blobsRequests = append(blobsRequests, r.URL.Path)
+				mutex.Unlock()
+
+				b = blobs[strings.TrimPrefix(r.URL.Path, "/v2/app/blobs/")]
+			}
+
+			if b == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			w.Header().Set("Content-Type", string(b.mediaType))
+			w.Header().Set("Docker-Content-Digest", b.digest)
+			_, _ = w.Write(b.data)
+		}))
+
+		repository = strings.TrimPrefix(server.URL, "http://") + "/app"
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Ω(os.RemoveAll(homeDir)).Should(Succeed())
+	})
+
+	scannedTags := func(results []*scannedImage) []string {
+		var res []string
+		for _, result := range results {
+			if result == nil {
+				res = append(res, "")
+				continue
+			}
+
+			Ω(result.configFile.Config.Labels["tag"]).Should(Equal(result.tag))
+			res = append(res, result.tag)
+		}
+
+		return res
+	}
+
+	It("keeps the order of the tags", func() {
+		results, err := scanTags(repository, tags)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(scannedTags(results)).Should(Equal(tags))
+	})
+
+	It("skips broken tags", func() {
+		results, err := scanTags(repository, []string{tags[0], "broken", tags[1]})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(scannedTags(results)).Should(Equal([]string{tags[0], "", tags[1]}))
+	})
+
+	It("fails on the registry error", func() {
+		_, err := scanTags(repository, append([]string{"denied"}, tags...))
+		Ω(err).Should(HaveOccurred())
+		Ω(err.Error()).Should(ContainSubstring("DENIED"))
+	})
+
+	It("reads configs from the cache by the second scan", func() {
+		results, err := scanTags(repository, tags)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(blobsRequests).Should(HaveLen(len(tags)))
+
+		blobsRequests = nil
+
+		cachedResults, err := scanTags(repository, tags)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(blobsRequests).Should(BeEmpty())
+		Ω(scannedTags(cachedResults)).Should(Equal(scannedTags(results)))
+
+		configFile, err := cachedResults[0].image.ConfigFile()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(configFile.Config.Labels["tag"]).Should(Equal(tags[0]))
+	})
+})
@@ -0,0 +1,208 @@
+package docker_registry
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/flant/go-containerregistry/pkg/name"
+	v1 "github.com/flant/go-containerregistry/pkg/v1"
+	"github.com/flant/go-containerregistry/pkg/v1/remote"
+	"github.com/flant/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// Signatures are stored by the cosign convention: the image REPOSITORY:sha256-HEX.sig
+// with the layer per signature, the layer contains the signed payload and the signature annotation
+const (
+	SignatureTagSuffix        = ".sig"
+	SignaturePayloadMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	SignatureAnnotation       = "dev.cosignproject.cosign/signature"
+)
+
+var (
+	// scannedTags are the manifests digests by the repository and the tag: the signatures are kept while the manifest is tagged.
+	// Tags are recorded by the tags scanning and forgotten by ImageDelete
+	scannedTags      = map[string]map[string]string{}
+	scannedTagsMutex sync.Mutex
+)
+
+type ImageSignature struct {
+	Payload   []byte
+	Signature string // base64 encoded
+}
+
+// SignatureTag returns the tag of the signatures of the manifest digest
+func SignatureTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + SignatureTagSuffix
+}
+
+// ImageSignatures returns the signatures of the manifest digest of the repository, nil if there are no signatures
+func ImageSignatures(repository, digest string) ([]*ImageSignature, error) {
+	reference := strings.Join([]string{repository, SignatureTag(digest)}, ":")
+	ref, err := name.ParseReference(reference, parseReferenceOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("parsing reference %q: %v", reference, err)
+	}
+
+	img, err := remote.Image(ref, remoteOptions(getHttpTransport())...)
+	if err != nil {
+		if isManifestUnknownError(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading signatures %q: %v", ref, err)
+	}
+
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("reading signatures %q: %v", ref, err)
+	}
+
+	var signatures []*ImageSignature
+	for _, desc := range manifest.Layers {
+		if desc.MediaType != SignaturePayloadMediaType {
+			continue
+		}
+
+		layer, err := img.LayerByDigest(desc.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("reading signature %s@%s: %v", ref, desc.Digest, err)
+		}
+
+		payload, err := readLayerBlob(layer)
+		if err != nil {
+			return nil, fmt.Errorf("reading signature %s@%s: %v", ref, desc.Digest, err)
+		}
+
+		signatures = append(signatures, &ImageSignature{Payload: payload, Signature: desc.Annotations[SignatureAnnotation]})
+	}
+
+	return signatures, nil
+}
+
+// PushImageSignatures replaces the signatures of the manifest digest of the repository
+func PushImageSignatures(repository, digest string, signatures []*ImageSignature) error {
+	reference := strings.Join([]string{repository, SignatureTag(digest)}, ":")
+	ref, err := name.ParseReference(reference, parseReferenceOptions()...)
+	if err != nil {
+		return fmt.Errorf("parsing reference %q: %v", reference, err)
+	}
+
+	var layers []*artifactImageLayer
+	for _, signature := range signatures {
+		layers = append(layers, &artifactImageLayer{
+			mediaType:   SignaturePayloadMediaType,
+			data:        signature.Payload,
+			annotations: map[string]string{SignatureAnnotation: signature.Signature},
+		})
+	}
+
+	img, err := newArtifactImage(layers)
+	if err != nil {
+		return err
+	}
+
+	if err := remote.Write(ref, img, remoteOptions(getHttpTransport())...); err != nil {
+		return fmt.Errorf("writing signatures %q: %v", ref, err)
+	}
+
+	return nil
+}
+
+// ImageSignaturesReference returns the reference to remove the signatures of the manifest digest of the repository by ImageDelete:
+// the reference with the digest or with the tag if IsDeleteByTag. Empty string is returned if there are no signatures
+func ImageSignaturesReference(repository, digest string) (string, error) {
+	reference := strings.Join([]string{repository, SignatureTag(digest)}, ":")
+	ref, err := name.ParseReference(reference, parseReferenceOptions()...)
+	if err != nil {
+		return "", fmt.Errorf("parsing reference %q: %v", reference, err)
+	}
+
+	desc, err := remote.Get(ref, remoteOptions(getHttpTransport())...)
+	if err != nil {
+		if isManifestUnknownError(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("reading signatures %q: %v", ref, err)
+	}
+
+	isDeleteByTag, err := IsDeleteByTag(repository)
+	if err != nil {
+		return "", err
+	}
+
+	if isDeleteByTag {
+		return reference, nil
+	}
+
+	return strings.Join([]string{repository, desc.Digest.String()}, "@"), nil
+}
+
+// TagReferencingManifest returns the scanned tag of the repository, except the specified one, which refers to the manifest,
+// empty string is returned if there is no such tag (see ImagesByWerfImageLabel)
+func TagReferencingManifest(repository, manifestDigest, exceptTag string) string {
+	scannedTagsMutex.Lock()
+	defer scannedTagsMutex.Unlock()
+
+	for tag, digest := range scannedTags[repositoryKey(repository)] {
+		if tag != exceptTag && digest == manifestDigest {
+			return tag
+		}
+	}
+
+	return ""
+}
+
+func recordScannedTag(repository, tag, manifestDigest string) {
+	scannedTagsMutex.Lock()
+	defer scannedTagsMutex.Unlock()
+
+	key := repositoryKey(repository)
+	if _, hasKey := scannedTags[key]; !hasKey {
+		scannedTags[key] = map[string]string{}
+	}
+
+	scannedTags[key][tag] = manifestDigest
+}
+
+// forgetScannedTags forgets the tag of the reference or all tags of the manifest if the reference is the digest
+func forgetScannedTags(ref name.Reference) {
+	scannedTagsMutex.Lock()
+	defer scannedTagsMutex.Unlock()
+
+	tags := scannedTags[repositoryKey(ref.Context().Name())]
+	for tag, digest := range tags {
+		switch r := ref.(type) {
+		case name.Tag:
+			if tag == r.TagStr() {
+				delete(tags, tag)
+			}
+		case name.Digest:
+			if digest == r.DigestStr() {
+				delete(tags, tag)
+			}
+		}
+	}
+}
+
+func readLayerBlob(layer v1.Layer) ([]byte, error) {
+	rc, err := layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return ioutil.ReadAll(rc)
+}
+
+func isManifestUnknownError(err error) bool {
+	if transportErr, ok := err.(*transport.Error); ok {
+		for _, d := range transportErr.Errors {
+			if d.Code == transport.ManifestUnknownErrorCode || d.Code == transport.NameUnknownErrorCode {
+				return true
+			}
+		}
+	}
+
+	return strings.Contains(err.Error(), "unsupported status code 404")
+}
@@ -0,0 +1,301 @@
+package files_checksum_cache
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flant/logboek"
+	"github.com/flant/shluz"
+
+	"github.com/flant/werf/pkg/util"
+	"github.com/flant/werf/pkg/werf"
+)
+
+const CacheVersion = "1"
+
+// DefaultMaxAge is the age of the unused cache of the dir after which the cache is removed by host cleanup
+const DefaultMaxAge = 14 * 24 * time.Hour
+
+// file modified less than racyPeriod before the checksum calculation can be changed
+// without modification time change, so its checksum is not cached
+const racyPeriod = 2 * time.Second
+
+func GetCacheDir() string {
+	return filepath.Join(werf.GetLocalCacheDir(), "files_checksums", CacheVersion)
+}
+
+// Cache stores checksums of files contents between werf runs.
+// The cached checksum is used while the file size, modification time and inode are not changed
+type Cache struct {
+	dir     string
+	records map[string]*record
+
+	isLoaded  bool
+	isChanged bool
+	mutex     sync.Mutex
+}
+
+type record struct {
+	Size     int64  `json:"size"`
+	ModTime  int64  `json:"modTime"`
+	Inode    uint64 `json:"inode"`
+	Checksum string `json:"checksum"`
+}
+
+// NewCache creates the cache of files in the dir, records are loaded on the first use
+func NewCache(dir string) *Cache {
+	return &Cache{dir: dir, records: map[string]*record{}}
+}
+
+// FileChecksum returns sha256 checksum of the file content, the content is read only if there is no valid cached checksum
+func (c *Cache) FileChecksum(path string, info os.FileInfo) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.load(); err != nil {
+		return "", err
+	}
+
+	inode := fileInode(info)
+	if r, hasKey := c.records[path]; hasKey {
+		if r.Size == info.Size() && r.ModTime == info.ModTime().UnixNano() && r.Inode == inode {
+			return r.Checksum, nil
+		}
+	}
+
+	checksum, err := calculateFileChecksum(path)
+	if err != nil {
+		return "", err
+	}
+
+	if time.Since(info.ModTime()) > racyPeriod {
+		c.records[path] = &record{
+			Size:     info.Size(),
+			ModTime:  info.ModTime().UnixNano(),
+			Inode:    inode,
+			Checksum: checksum,
+		}
+		c.isChanged = true
+	} else if _, hasKey := c.records[path]; hasKey {
+		delete(c.records, path)
+		c.isChanged = true
+	}
+
+	return checksum, nil
+}
+
+// Save writes changed records to the cache file
+func (c *Cache) Save() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.isChanged {
+		return nil
+	}
+
+	data, err := json.Marshal(c.records)
+	if err != nil {
+		return fmt.Errorf("unable to marshal files checksums: %s", err)
+	}
+
+	if err := os.MkdirAll(GetCacheDir(), os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create dir %s: %s", GetCacheDir(), err)
+	}
+
+	return shluz.WithLock(c.lockName(), shluz.LockOptions{}, func() error {
+		if err := writeCacheFile(c.cachePath(), data); err != nil {
+			return err
+		}
+
+		c.isChanged = false
+
+		return nil
+	})
+}
+
+func (c *Cache) load() error {
+	if c.isLoaded {
+		return nil
+	}
+
+	c.isLoaded = true
+
+	return shluz.WithLock(c.lockName(), shluz.LockOptions{ReadOnly: true}, func() error {
+		data, err := ioutil.ReadFile(c.cachePath())
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return fmt.Errorf("unable to read %s: %s", c.cachePath(), err)
+		}
+
+		// the modification time of the cache file is the last usage time for the cleanup
+		now := time.Now()
+		_ = os.Chtimes(c.cachePath(), now, now)
+
+		// broken cache is ignored and will be rewritten
+		_ = json.Unmarshal(data, &c.records)
+		if c.records == nil {
+			c.records = map[string]*record{}
+		}
+
+		return nil
+	})
+}
+
+func (c *Cache) cachePath() string {
+	return cachePath(util.Sha256Hash(c.dir))
+}
+
+func (c *Cache) lockName() string {
+	return lockName(util.Sha256Hash(c.dir))
+}
+
+// Cleanup removes caches of dirs that have not been used longer than maxAge
+// and records of removed files from the other caches
+func Cleanup(maxAge time.Duration, dryRun bool) error {
+	infos, err := ioutil.ReadDir(GetCacheDir())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to read dir %s: %s", GetCacheDir(), err)
+	}
+
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+
+		if !strings.HasSuffix(info.Name(), ".json") {
+			// tmp file of the interrupted save
+			if time.Since(info.ModTime()) > maxAge && !dryRun {
+				_ = os.Remove(filepath.Join(GetCacheDir(), info.Name()))
+			}
+
+			continue
+		}
+
+		dirHash := strings.TrimSuffix(info.Name(), ".json")
+		if err := shluz.WithLock(lockName(dirHash), shluz.LockOptions{}, func() error {
+			return cleanupCacheFile(cachePath(dirHash), maxAge, dryRun)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func cleanupCacheFile(path string, maxAge time.Duration, dryRun bool) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to stat %s: %s", path, err)
+	}
+
+	if time.Since(info.ModTime()) > maxAge {
+		logboek.LogInfoF("Removing files checksums cache %s\n", filepath.Base(path))
+
+		if dryRun {
+			return nil
+		}
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to remove %s: %s", path, err)
+		}
+
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %s", path, err)
+	}
+
+	records := map[string]*record{}
+	if err := json.Unmarshal(data, &records); err != nil {
+		// broken cache will be rewritten by the next save
+		return nil
+	}
+
+	var removedFilesCount int
+	for filePath := range records {
+		if !filepath.IsAbs(filePath) {
+			continue
+		}
+
+		if _, err := os.Lstat(filePath); os.IsNotExist(err) {
+			delete(records, filePath)
+			removedFilesCount++
+		}
+	}
+
+	if removedFilesCount == 0 {
+		return nil
+	}
+
+	logboek.LogInfoF("Removing %d records of removed files from files checksums cache %s\n", removedFilesCount, filepath.Base(path))
+
+	if dryRun {
+		return nil
+	}
+
+	data, err = json.Marshal(records)
+	if err != nil {
+		return fmt.Errorf("unable to marshal files checksums: %s", err)
+	}
+
+	// the usage time of the cache is kept
+	if err := writeCacheFile(path, data); err != nil {
+		return err
+	}
+
+	return os.Chtimes(path, info.ModTime(), info.ModTime())
+}
+
+func writeCacheFile(path string, data []byte) error {
+	tmpPath := fmt.Sprintf("%s.%s", path, util.GenerateConsistentRandomString(5))
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("unable to write %s: %s", tmpPath, err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("unable to rename %s to %s: %s", tmpPath, path, err)
+	}
+
+	return nil
+}
+
+func cachePath(dirHash string) string {
+	return filepath.Join(GetCacheDir(), fmt.Sprintf("%s.json", dirHash))
+}
+
+func lockName(dirHash string) string {
+	return fmt.Sprintf("files_checksum_cache.%s", dirHash)
+}
+
+func calculateFileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to open file %s: %s", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("unable to read file %s: %s", path, err)
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
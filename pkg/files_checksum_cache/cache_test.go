@@ -0,0 +1,131 @@
+package files_checksum_cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/flant/shluz"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/werf/pkg/werf"
+)
+
+var _ = Describe("files checksum cache", func() {
+	var homeDir, contextDir string
+
+	oldTime := time.Now().Add(-2 * time.Hour)
+
+	writeFile := func(name, content string) (string, os.FileInfo) {
+		path := filepath.Join(contextDir, name)
+		Ω(ioutil.WriteFile(path, []byte(content), 0644)).Should(Succeed())
+		Ω(os.Chtimes(path, oldTime, oldTime)).Should(Succeed())
+
+		info, err := os.Stat(path)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		return path, info
+	}
+
+	saveCache := func(dir string, paths ...string) *Cache {
+		c := NewCache(dir)
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			_, err = c.FileChecksum(path, info)
+			Ω(err).ShouldNot(HaveOccurred())
+		}
+		Ω(c.Save()).Should(Succeed())
+
+		return c
+	}
+
+	loadRecords := func(c *Cache) map[string]*record {
+		loaded := NewCache(c.dir)
+		Ω(loaded.load()).Should(Succeed())
+		return loaded.records
+	}
+
+	BeforeEach(func() {
+		var err error
+		homeDir, err = ioutil.TempDir("", "werf-files-checksum-cache-test")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(werf.Init(homeDir, homeDir)).Should(Succeed())
+		Ω(shluz.Init(filepath.Join(homeDir, "locks"))).Should(Succeed())
+
+		contextDir = filepath.Join(homeDir, "context")
+		Ω(os.MkdirAll(contextDir, os.ModePerm)).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		Ω(os.RemoveAll(homeDir)).Should(Succeed())
+	})
+
+	It("returns the cached checksum while the file is not changed", func() {
+		path, info := writeFile("file", "content")
+		c := saveCache(contextDir, path)
+
+		checksum, err := NewCache(contextDir).FileChecksum(path, info)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(checksum).Should(Equal(loadRecords(c)[path].Checksum))
+
+		// the content is not read for the valid record
+		loaded := NewCache(contextDir)
+		Ω(loaded.load()).Should(Succeed())
+		loaded.records[path].Checksum = "cached"
+		Ω(loaded.FileChecksum(path, info)).Should(Equal("cached"))
+
+		_, info = writeFile("file", "changed content")
+		Ω(loaded.FileChecksum(path, info)).ShouldNot(Equal("cached"))
+	})
+
+	It("does not cache the checksum of the recently modified file", func() {
+		path := filepath.Join(contextDir, "file")
+		Ω(ioutil.WriteFile(path, []byte("content"), 0644)).Should(Succeed())
+
+		c := saveCache(contextDir, path)
+		Ω(loadRecords(c)).ShouldNot(HaveKey(path))
+	})
+
+	It("removes caches that have not been used longer than max age", func() {
+		path, _ := writeFile("file", "content")
+		oldCache := saveCache(contextDir, path)
+		newCache := saveCache(filepath.Join(homeDir, "other"), path)
+		Ω(os.Chtimes(oldCache.cachePath(), oldTime, oldTime)).Should(Succeed())
+
+		Ω(Cleanup(time.Hour, true)).Should(Succeed())
+		Ω(oldCache.cachePath()).Should(BeAnExistingFile())
+
+		Ω(Cleanup(time.Hour, false)).Should(Succeed())
+		Ω(oldCache.cachePath()).ShouldNot(BeAnExistingFile())
+		Ω(newCache.cachePath()).Should(BeAnExistingFile())
+	})
+
+	It("refreshes the usage time of the loaded cache", func() {
+		path, _ := writeFile("file", "content")
+		c := saveCache(contextDir, path)
+		Ω(os.Chtimes(c.cachePath(), oldTime, oldTime)).Should(Succeed())
+
+		Ω(loadRecords(c)).Should(HaveKey(path))
+		Ω(Cleanup(time.Hour, false)).Should(Succeed())
+		Ω(c.cachePath()).Should(BeAnExistingFile())
+	})
+
+	It("removes records of removed files", func() {
+		keptPath, _ := writeFile("kept", "content")
+		removedPath, _ := writeFile("removed", "content")
+		c := saveCache(contextDir, keptPath, removedPath)
+		Ω(os.Remove(removedPath)).Should(Succeed())
+
+		Ω(Cleanup(time.Hour, true)).Should(Succeed())
+		Ω(loadRecords(c)).Should(HaveKey(removedPath))
+
+		Ω(Cleanup(time.Hour, false)).Should(Succeed())
+		records := loadRecords(c)
+		Ω(records).Should(HaveKey(keptPath))
+		Ω(records).ShouldNot(HaveKey(removedPath))
+	})
+})
@@ -0,0 +1,10 @@
+// +build windows
+
+package files_checksum_cache
+
+import "os"
+
+// inode is not available on windows: size and modification time are used
+func fileInode(_ os.FileInfo) uint64 {
+	return 0
+}
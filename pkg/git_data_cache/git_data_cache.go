@@ -0,0 +1,375 @@
+package git_data_cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+
+	"github.com/flant/logboek"
+	"github.com/flant/shluz"
+
+	"github.com/flant/werf/pkg/util"
+	"github.com/flant/werf/pkg/werf"
+)
+
+const CacheVersion = "1"
+
+const (
+	ArchivesKind  = "archives"
+	PatchesKind   = "patches"
+	ChecksumsKind = "checksums"
+
+	DefaultMaxAge  = 14 * 24 * time.Hour
+	DefaultMaxSize = 5 * units.GiB
+
+	// CleanupPeriod is the max period between cleanups: the size of the cache is not checked between cleanups
+	CleanupPeriod = 24 * time.Hour
+
+	descriptorExt = ".json"
+	dataExt       = ".data"
+
+	lastCleanupFileName = "last_cleanup"
+)
+
+var kinds = []string{ArchivesKind, PatchesKind, ChecksumsKind}
+
+func GetCacheDir() string {
+	return filepath.Join(werf.GetLocalCacheDir(), "git_data", CacheVersion)
+}
+
+// Key identifies the git data of the repo by the options: commits and filters.
+// Git data of the commit never changes, so the entry is valid until removed by the cleanup
+func Key(repoId string, opts interface{}) string {
+	data, err := json.Marshal(opts)
+	if err != nil {
+		panic(fmt.Sprintf("unable to marshal object %#v: %s", opts, err))
+	}
+
+	return util.Sha256Hash(repoId, string(data))
+}
+
+// WithEntryLock runs f exclusively for the entry: concurrent processes do not create the same entry twice
+// and the cleanup does not remove the entry in use
+func WithEntryLock(kind, key string, f func() error) error {
+	return shluz.WithLock(entryLockName(kind, key), shluz.LockOptions{}, f)
+}
+
+// Get loads the descriptor of the entry, returns false if there is no entry
+func Get(kind, key string, desc interface{}) (bool, error) {
+	descriptorPath := entryPath(kind, key, descriptorExt)
+
+	data, err := ioutil.ReadFile(descriptorPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("unable to read %s: %s", descriptorPath, err)
+	}
+
+	// broken entry is ignored and will be rewritten
+	if err := json.Unmarshal(data, desc); err != nil {
+		return false, nil
+	}
+
+	if err := touch(descriptorPath); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetFile loads the descriptor of the entry and links (or copies) the data file of the entry to the dstPath,
+// returns false if there is no entry
+func GetFile(kind, key, dstPath string, desc interface{}) (bool, error) {
+	if _, err := os.Stat(entryPath(kind, key, dataExt)); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("unable to access %s: %s", entryPath(kind, key, dataExt), err)
+	}
+
+	if found, err := Get(kind, key, desc); err != nil || !found {
+		return found, err
+	}
+
+	if err := linkOrCopyFile(entryPath(kind, key, dataExt), dstPath); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Put saves the descriptor of the entry
+func Put(kind, key string, desc interface{}) error {
+	data, err := json.Marshal(desc)
+	if err != nil {
+		return fmt.Errorf("unable to marshal %#v: %s", desc, err)
+	}
+
+	return writeFileAtomically(entryPath(kind, key, descriptorExt), func(path string) error {
+		return ioutil.WriteFile(path, data, 0644)
+	})
+}
+
+// PutFile saves the data file (linked or copied from the srcPath) and the descriptor of the entry
+func PutFile(kind, key, srcPath string, desc interface{}) error {
+	if err := writeFileAtomically(entryPath(kind, key, dataExt), func(path string) error {
+		return linkOrCopyFile(srcPath, path)
+	}); err != nil {
+		return err
+	}
+
+	// descriptor goes last: the entry without descriptor is not complete
+	return Put(kind, key, desc)
+}
+
+func entryPath(kind, key, ext string) string {
+	return filepath.Join(GetCacheDir(), kind, key+ext)
+}
+
+func entryLockName(kind, key string) string {
+	return fmt.Sprintf("git_data_cache.%s.%s", kind, key)
+}
+
+func writeFileAtomically(path string, write func(path string) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create dir %s: %s", filepath.Dir(path), err)
+	}
+
+	tmpPath := fmt.Sprintf("%s.%s.tmp", path, util.GenerateConsistentRandomString(5))
+	if err := write(tmpPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("unable to write %s: %s", tmpPath, err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("unable to rename %s to %s: %s", tmpPath, path, err)
+	}
+
+	return nil
+}
+
+// linkOrCopyFile creates the hardlink, the file is copied if the cache and the destination are on different devices
+func linkOrCopyFile(srcPath, dstPath string) error {
+	if err := os.Link(srcPath, dstPath); err == nil {
+		return nil
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %s", srcPath, err)
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %s", dstPath, err)
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return fmt.Errorf("unable to copy %s to %s: %s", srcPath, dstPath, err)
+	}
+
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("unable to close %s: %s", dstPath, err)
+	}
+
+	return nil
+}
+
+func touch(path string) error {
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		return fmt.Errorf("unable to change times of %s: %s", path, err)
+	}
+
+	return nil
+}
+
+type Entry struct {
+	Kind     string
+	Key      string
+	LastUsed time.Time
+	Size     int64
+}
+
+// List returns entries of the host cache, the last usage time is the modification time of the descriptor
+func List() ([]*Entry, error) {
+	var entries []*Entry
+	for _, kind := range kinds {
+		kindDir := filepath.Join(GetCacheDir(), kind)
+
+		infos, err := ioutil.ReadDir(kindDir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to read dir %s: %s", kindDir, err)
+		}
+
+		entriesByKey := map[string]*Entry{}
+		for _, info := range infos {
+			name := info.Name()
+
+			var key string
+			switch {
+			case strings.HasSuffix(name, ".tmp"):
+				continue
+			case strings.HasSuffix(name, descriptorExt):
+				key = strings.TrimSuffix(name, descriptorExt)
+			case strings.HasSuffix(name, dataExt):
+				key = strings.TrimSuffix(name, dataExt)
+			default:
+				continue
+			}
+
+			entry, hasKey := entriesByKey[key]
+			if !hasKey {
+				entry = &Entry{Kind: kind, Key: key}
+				entriesByKey[key] = entry
+				entries = append(entries, entry)
+			}
+
+			entry.Size += info.Size()
+			if strings.HasSuffix(name, descriptorExt) || entry.LastUsed.IsZero() {
+				entry.LastUsed = info.ModTime()
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+// Size returns the total size of the host cache
+func Size() (int64, error) {
+	entries, err := List()
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, entry := range entries {
+		size += entry.Size
+	}
+
+	return size, nil
+}
+
+// ShouldCleanup returns true if the cache has not been cleaned up for the CleanupPeriod.
+// The check does not walk the cache, so it can be done on each werf invocation
+func ShouldCleanup() (bool, error) {
+	if _, err := os.Stat(GetCacheDir()); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("unable to access %s: %s", GetCacheDir(), err)
+	}
+
+	lastCleanupPath := filepath.Join(GetCacheDir(), lastCleanupFileName)
+	info, err := os.Stat(lastCleanupPath)
+	if os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("unable to access %s: %s", lastCleanupPath, err)
+	}
+
+	return time.Since(info.ModTime()) > CleanupPeriod, nil
+}
+
+type CleanupOptions struct {
+	MaxAge  time.Duration // 0 — no limit
+	MaxSize int64         // 0 — no limit
+	DryRun  bool
+}
+
+// Cleanup removes entries that have not been used longer than MaxAge,
+// then removes least recently used entries until the total size fits MaxSize.
+// Entries used by running builds are skipped
+func Cleanup(options CleanupOptions) error {
+	entries, err := List()
+	if err != nil {
+		return err
+	}
+
+	var totalSize int64
+	for _, entry := range entries {
+		totalSize += entry.Size
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.Before(entries[j].LastUsed)
+	})
+
+	now := time.Now()
+	for _, entry := range entries {
+		isExpired := options.MaxAge != 0 && now.Sub(entry.LastUsed) > options.MaxAge
+		isOverflowed := options.MaxSize != 0 && totalSize > options.MaxSize
+		if !isExpired && !isOverflowed {
+			continue
+		}
+
+		isRemoved, err := removeEntry(entry, options.DryRun)
+		if err != nil {
+			return err
+		}
+
+		if isRemoved {
+			totalSize -= entry.Size
+		}
+	}
+
+	if options.DryRun {
+		return nil
+	}
+
+	return writeLastCleanup()
+}
+
+func writeLastCleanup() error {
+	if err := os.MkdirAll(GetCacheDir(), os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create dir %s: %s", GetCacheDir(), err)
+	}
+
+	lastCleanupPath := filepath.Join(GetCacheDir(), lastCleanupFileName)
+	if err := ioutil.WriteFile(lastCleanupPath, []byte(time.Now().UTC().Format(time.RFC3339)), 0644); err != nil {
+		return fmt.Errorf("unable to write %s: %s", lastCleanupPath, err)
+	}
+
+	return nil
+}
+
+func removeEntry(entry *Entry, dryRun bool) (bool, error) {
+	lockName := entryLockName(entry.Kind, entry.Key)
+	isLocked, err := shluz.TryLock(lockName, shluz.TryLockOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to lock %s: %s", lockName, err)
+	}
+
+	if !isLocked {
+		logboek.LogInfoF("Ignore git data cache %s entry %s used by another process\n", entry.Kind, entry.Key)
+		return false, nil
+	}
+	defer shluz.Unlock(lockName)
+
+	logboek.LogInfoF("Removing git data cache %s entry %s (%s, last used %s ago)\n", entry.Kind, entry.Key, units.BytesSize(float64(entry.Size)), time.Since(entry.LastUsed).Round(time.Second))
+
+	if dryRun {
+		return true, nil
+	}
+
+	// descriptor goes first: the entry without descriptor is not used
+	for _, ext := range []string{descriptorExt, dataExt} {
+		path := entryPath(entry.Kind, entry.Key, ext)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return false, fmt.Errorf("unable to remove %s: %s", path, err)
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,198 @@
+package git_data_cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/flant/shluz"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/werf/pkg/werf"
+)
+
+type testDescriptor struct {
+	Commit string `json:"commit"`
+}
+
+var _ = Describe("git data cache", func() {
+	var homeDir string
+
+	putFile := func(kind, key, content string) {
+		srcPath := filepath.Join(homeDir, "src")
+		Ω(ioutil.WriteFile(srcPath, []byte(content), 0644)).Should(Succeed())
+		Ω(PutFile(kind, key, srcPath, &testDescriptor{Commit: key})).Should(Succeed())
+		Ω(os.Remove(srcPath)).Should(Succeed())
+	}
+
+	setLastUsed := func(kind, key string, lastUsed time.Time) {
+		Ω(os.Chtimes(entryPath(kind, key, descriptorExt), lastUsed, lastUsed)).Should(Succeed())
+	}
+
+	entriesKeys := func() []string {
+		entries, err := List()
+		Ω(err).ShouldNot(HaveOccurred())
+
+		var keys []string
+		for _, entry := range entries {
+			keys = append(keys, entry.Key)
+		}
+
+		return keys
+	}
+
+	BeforeEach(func() {
+		var err error
+		homeDir, err = ioutil.TempDir("", "werf-git-data-cache-test")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(werf.Init(homeDir, homeDir)).Should(Succeed())
+		Ω(shluz.Init(filepath.Join(homeDir, "locks"))).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		Ω(os.RemoveAll(homeDir)).Should(Succeed())
+	})
+
+	Context("entries", func() {
+		It("returns the stored descriptor", func() {
+			desc := &testDescriptor{}
+			Ω(Get(ChecksumsKind, "key", desc)).Should(BeFalse())
+
+			Ω(Put(ChecksumsKind, "key", &testDescriptor{Commit: "commit"})).Should(Succeed())
+			Ω(Get(ChecksumsKind, "key", desc)).Should(BeTrue())
+			Ω(desc.Commit).Should(Equal("commit"))
+		})
+
+		It("ignores the broken descriptor", func() {
+			Ω(os.MkdirAll(filepath.Join(GetCacheDir(), ChecksumsKind), os.ModePerm)).Should(Succeed())
+			Ω(ioutil.WriteFile(entryPath(ChecksumsKind, "key", descriptorExt), []byte("{broken"), 0644)).Should(Succeed())
+			Ω(Get(ChecksumsKind, "key", &testDescriptor{})).Should(BeFalse())
+		})
+
+		It("returns the stored file", func() {
+			dstPath := filepath.Join(homeDir, "dst")
+			Ω(GetFile(ArchivesKind, "key", dstPath, &testDescriptor{})).Should(BeFalse())
+
+			putFile(ArchivesKind, "key", "archive")
+
+			desc := &testDescriptor{}
+			Ω(GetFile(ArchivesKind, "key", dstPath, desc)).Should(BeTrue())
+			Ω(desc.Commit).Should(Equal("key"))
+			Ω(ioutil.ReadFile(dstPath)).Should(Equal([]byte("archive")))
+		})
+
+		It("does not return the file without descriptor", func() {
+			putFile(ArchivesKind, "key", "archive")
+			Ω(os.Remove(entryPath(ArchivesKind, "key", descriptorExt))).Should(Succeed())
+			Ω(GetFile(ArchivesKind, "key", filepath.Join(homeDir, "dst"), &testDescriptor{})).Should(BeFalse())
+		})
+
+		It("refreshes the usage time of the read entry", func() {
+			putFile(ArchivesKind, "key", "archive")
+			setLastUsed(ArchivesKind, "key", time.Now().Add(-time.Hour))
+
+			Ω(Get(ArchivesKind, "key", &testDescriptor{})).Should(BeTrue())
+
+			entries, err := List()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(entries).Should(HaveLen(1))
+			Ω(time.Since(entries[0].LastUsed)).Should(BeNumerically("<", time.Minute))
+		})
+	})
+
+	Context("List and Size", func() {
+		It("returns nothing for the missing cache", func() {
+			Ω(List()).Should(BeEmpty())
+			Ω(Size()).Should(Equal(int64(0)))
+		})
+
+		It("returns entries of all kinds with sizes of the data and the descriptor", func() {
+			putFile(ArchivesKind, "archive", strings.Repeat("a", 100))
+			putFile(PatchesKind, "patch", strings.Repeat("p", 50))
+			Ω(Put(ChecksumsKind, "checksum", &testDescriptor{Commit: "checksum"})).Should(Succeed())
+
+			// files of the incomplete writing and unknown files are not entries
+			Ω(ioutil.WriteFile(entryPath(ArchivesKind, "archive", dataExt)+".abcde.tmp", []byte("tmp"), 0644)).Should(Succeed())
+			Ω(ioutil.WriteFile(filepath.Join(GetCacheDir(), ArchivesKind, "unknown"), []byte("unknown"), 0644)).Should(Succeed())
+
+			entries, err := List()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(entries).Should(HaveLen(3))
+
+			descriptorSize := int64(len(`{"commit":"archive"}`))
+			sizes := map[string]int64{}
+			var totalSize int64
+			for _, entry := range entries {
+				sizes[entry.Kind+"/"+entry.Key] = entry.Size
+				totalSize += entry.Size
+			}
+
+			Ω(sizes).Should(Equal(map[string]int64{
+				ArchivesKind + "/archive":   100 + descriptorSize,
+				PatchesKind + "/patch":      50 + int64(len(`{"commit":"patch"}`)),
+				ChecksumsKind + "/checksum": int64(len(`{"commit":"checksum"}`)),
+			}))
+			Ω(Size()).Should(Equal(totalSize))
+		})
+	})
+
+	Context("Cleanup", func() {
+		BeforeEach(func() {
+			now := time.Now()
+			for ind, key := range []string{"old", "middle", "new"} {
+				putFile(ArchivesKind, key, strings.Repeat("a", 100))
+				setLastUsed(ArchivesKind, key, now.Add(-time.Duration(3-ind)*time.Hour))
+			}
+		})
+
+		It("removes entries that have not been used longer than max age", func() {
+			Ω(Cleanup(CleanupOptions{MaxAge: 90 * time.Minute})).Should(Succeed())
+			Ω(entriesKeys()).Should(ConsistOf("new"))
+			Ω(entryPath(ArchivesKind, "old", dataExt)).ShouldNot(BeAnExistingFile())
+		})
+
+		It("removes least recently used entries until the cache fits max size", func() {
+			entrySize := int64(100 + len(`{"commit":"middle"}`))
+
+			Ω(Cleanup(CleanupOptions{MaxSize: 2 * entrySize})).Should(Succeed())
+			Ω(entriesKeys()).Should(ConsistOf("middle", "new"))
+
+			Ω(Cleanup(CleanupOptions{MaxSize: entrySize})).Should(Succeed())
+			Ω(entriesKeys()).Should(ConsistOf("new"))
+		})
+
+		It("does not remove entries in the dry run mode", func() {
+			Ω(Cleanup(CleanupOptions{MaxAge: time.Minute, MaxSize: 1, DryRun: true})).Should(Succeed())
+			Ω(entriesKeys()).Should(ConsistOf("old", "middle", "new"))
+		})
+	})
+
+	Context("ShouldCleanup", func() {
+		It("returns false for the missing cache", func() {
+			Ω(ShouldCleanup()).Should(BeFalse())
+		})
+
+		It("returns true until the first cleanup", func() {
+			putFile(ArchivesKind, "key", "archive")
+			Ω(ShouldCleanup()).Should(BeTrue())
+
+			Ω(Cleanup(CleanupOptions{DryRun: true})).Should(Succeed())
+			Ω(ShouldCleanup()).Should(BeTrue())
+
+			Ω(Cleanup(CleanupOptions{})).Should(Succeed())
+			Ω(ShouldCleanup()).Should(BeFalse())
+			Ω(entriesKeys()).Should(ConsistOf("key"))
+		})
+
+		It("returns true when the last cleanup is older than the cleanup period", func() {
+			Ω(Cleanup(CleanupOptions{})).Should(Succeed())
+
+			lastCleanup := time.Now().Add(-CleanupPeriod - time.Hour)
+			Ω(os.Chtimes(filepath.Join(GetCacheDir(), lastCleanupFileName), lastCleanup, lastCleanup)).Should(Succeed())
+			Ω(ShouldCleanup()).Should(BeTrue())
+		})
+	})
+})
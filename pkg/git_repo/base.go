@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -161,7 +162,7 @@ func (repo *Base) GetName() string {
 	return repo.Name
 }
 
-func (repo *Base) createPatch(repoPath, gitDir, workTreeCacheDir string, opts PatchOptions) (Patch, error) {
+func (repo *Base) createPatch(repoPath, gitDir, workTreeCacheDir string, isPartialClone bool, opts PatchOptions) (Patch, error) {
 	repository, err := git.PlainOpen(repoPath)
 	if err != nil {
 		return nil, fmt.Errorf("cannot open repo `%s`: %s", repoPath, err)
@@ -172,7 +173,7 @@ func (repo *Base) createPatch(repoPath, gitDir, workTreeCacheDir string, opts Pa
 		return nil, fmt.Errorf("bad `from` commit hash `%s`: %s", opts.FromCommit, err)
 	}
 
-	_, err = repository.CommitObject(fromHash)
+	fromCommit, err := repository.CommitObject(fromHash)
 	if err != nil {
 		return nil, fmt.Errorf("bad `from` commit `%s`: %s", opts.FromCommit, err)
 	}
@@ -192,6 +193,18 @@ func (repo *Base) createPatch(repoPath, gitDir, workTreeCacheDir string, opts Pa
 		return nil, err
 	}
 
+	hasLFS, err := HasLFSInCommit(toCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasLFS {
+		hasLFS, err = HasLFSInCommit(fromCommit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	patch := NewTmpPatchFile()
 
 	fileHandler, err := os.OpenFile(patch.GetFilePath(), os.O_RDWR|os.O_CREATE, 0755)
@@ -209,6 +222,8 @@ func (repo *Base) createPatch(repoPath, gitDir, workTreeCacheDir string, opts Pa
 		},
 		WithEntireFileContext: opts.WithEntireFileContext,
 		WithBinary:            opts.WithBinary,
+		LFS:                   hasLFS,
+		LimitToBasePath:       isPartialClone,
 	}
 
 	var desc *true_git.PatchDescriptor
@@ -243,7 +258,8 @@ func HasSubmodulesInCommit(commit *object.Commit) (bool, error) {
 	return true, nil
 }
 
-func (repo *Base) createArchive(repoPath, gitDir, workTreeCacheDir string, opts ArchiveOptions) (Archive, error) {
+// createArchive creates the archive of the commit, httpAccess of the remote repo is used to fetch LFS objects (nil for the local repo)
+func (repo *Base) createArchive(repoPath, gitDir, workTreeCacheDir string, httpAccess *remoteHTTPAccess, opts ArchiveOptions) (Archive, error) {
 	repository, err := git.PlainOpen(repoPath)
 	if err != nil {
 		return nil, fmt.Errorf("cannot open repo `%s`: %s", repoPath, err)
@@ -280,6 +296,29 @@ func (repo *Base) createArchive(repoPath, gitDir, workTreeCacheDir string, opts
 		},
 	}
 
+	hasLFS, err := HasLFSInCommit(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	if hasLFS {
+		pointers, err := lfsPointersInCommit(commit, archiveOpts.PathFilter)
+		if err != nil {
+			return nil, fmt.Errorf("error getting LFS pointers of commit `%s`: %s", opts.Commit, err)
+		}
+
+		storage, err := repo.newLFSStorage(repoPath, gitDir, commit, httpAccess)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := storage.Fetch(pointers); err != nil {
+			return nil, err
+		}
+
+		archiveOpts.GetLFSObjectPath = storage.ObjectPath
+	}
+
 	var desc *true_git.ArchiveDescriptor
 	if hasSubmodules {
 		desc, err = true_git.ArchiveWithSubmodules(fileHandler, gitDir, workTreeCacheDir, archiveOpts)
@@ -425,6 +464,11 @@ func (repo *Base) checksum(repoPath, gitDir, workTreeCacheDir string, opts Check
 		return nil, err
 	}
 
+	hasLFS, err := HasLFSInCommit(commit)
+	if err != nil {
+		return nil, err
+	}
+
 	checksum := &ChecksumDescriptor{
 		NoMatchPaths: make([]string, 0),
 		Hash:         sha256.New(),
@@ -496,7 +540,26 @@ func (repo *Base) checksum(repoPath, gitDir, workTreeCacheDir string, opts Check
 				logboek.LogF("Added file %s mode %o to resulting checksum\n", fullPath, stat.Mode())
 			}
 
-			if stat.Mode().IsRegular() {
+			var lfsPointer *true_git.LFSPointer
+			if hasLFS && stat.Mode().IsRegular() && stat.Size() <= true_git.LFSPointerMaxSize {
+				data, err := ioutil.ReadFile(fullPath)
+				if err != nil {
+					return fmt.Errorf("unable to read file `%s`: %s", fullPath, err)
+				}
+
+				lfsPointer = true_git.ParseLFSPointer(data)
+			}
+
+			if lfsPointer != nil {
+				_, err = checksum.Hash.Write([]byte(lfsPointer.Oid))
+				if err != nil {
+					return fmt.Errorf("error calculating checksum of LFS file `%s`: %s", fullPath, err)
+				}
+
+				if debugChecksum() {
+					logboek.LogF("Added LFS file '%s' to resulting checksum with LFS object id: %s\n", fullPath, lfsPointer.Oid)
+				}
+			} else if stat.Mode().IsRegular() {
 				f, err := os.Open(fullPath)
 				if err != nil {
 					return fmt.Errorf("unable to open file `%s`: %s", fullPath, err)
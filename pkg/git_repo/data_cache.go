@@ -0,0 +1,130 @@
+package git_repo
+
+import (
+	"github.com/flant/logboek"
+
+	"github.com/flant/werf/pkg/git_data_cache"
+	"github.com/flant/werf/pkg/true_git"
+)
+
+// cachedChecksum is the checksum loaded from the git data cache
+type cachedChecksum struct {
+	Checksum     string   `json:"checksum"`
+	NoMatchPaths []string `json:"noMatchPaths"`
+}
+
+func (c *cachedChecksum) String() string {
+	return c.Checksum
+}
+
+func (c *cachedChecksum) GetNoMatchPaths() []string {
+	return c.NoMatchPaths
+}
+
+// getOrCreateCachedPatch returns the copy of the patch from the host git data cache or creates and caches the patch
+func getOrCreateCachedPatch(repoId string, opts PatchOptions, create func() (Patch, error)) (Patch, error) {
+	key := git_data_cache.Key(repoId, opts)
+
+	var res Patch
+	err := git_data_cache.WithEntryLock(git_data_cache.PatchesKind, key, func() error {
+		patch := NewTmpPatchFile()
+		patch.Descriptor = &true_git.PatchDescriptor{}
+
+		if found, err := git_data_cache.GetFile(git_data_cache.PatchesKind, key, patch.GetFilePath(), patch.Descriptor); err != nil {
+			return err
+		} else if found {
+			logboek.LogInfoF("Using patch %s from git data cache\n", key)
+			res = patch
+			return nil
+		}
+
+		newPatch, err := create()
+		if err != nil {
+			return err
+		}
+		res = newPatch
+
+		if patchFile, ok := newPatch.(*PatchFile); ok {
+			return git_data_cache.PutFile(git_data_cache.PatchesKind, key, patchFile.GetFilePath(), patchFile.Descriptor)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// getOrCreateCachedArchive returns the copy of the archive from the host git data cache or creates and caches the archive
+func getOrCreateCachedArchive(repoId string, opts ArchiveOptions, create func() (Archive, error)) (Archive, error) {
+	key := git_data_cache.Key(repoId, opts)
+
+	var res Archive
+	err := git_data_cache.WithEntryLock(git_data_cache.ArchivesKind, key, func() error {
+		archive := NewTmpArchiveFile()
+		archive.Descriptor = &true_git.ArchiveDescriptor{}
+
+		if found, err := git_data_cache.GetFile(git_data_cache.ArchivesKind, key, archive.GetFilePath(), archive.Descriptor); err != nil {
+			return err
+		} else if found {
+			logboek.LogInfoF("Using archive %s from git data cache\n", key)
+			res = archive
+			return nil
+		}
+
+		newArchive, err := create()
+		if err != nil {
+			return err
+		}
+		res = newArchive
+
+		if archiveFile, ok := newArchive.(*ArchiveFile); ok {
+			return git_data_cache.PutFile(git_data_cache.ArchivesKind, key, archiveFile.GetFilePath(), archiveFile.Descriptor)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// getOrCreateCachedChecksum returns the checksum from the host git data cache or calculates and caches the checksum
+func getOrCreateCachedChecksum(repoId string, opts ChecksumOptions, create func() (Checksum, error)) (Checksum, error) {
+	key := git_data_cache.Key(repoId, opts)
+
+	var res Checksum
+	err := git_data_cache.WithEntryLock(git_data_cache.ChecksumsKind, key, func() error {
+		checksum := &cachedChecksum{}
+
+		if found, err := git_data_cache.Get(git_data_cache.ChecksumsKind, key, checksum); err != nil {
+			return err
+		} else if found {
+			res = checksum
+			return nil
+		}
+
+		newChecksum, err := create()
+		if err != nil {
+			return err
+		}
+		res = newChecksum
+
+		return git_data_cache.Put(git_data_cache.ChecksumsKind, key, &cachedChecksum{
+			Checksum:     newChecksum.String(),
+			NoMatchPaths: newChecksum.GetNoMatchPaths(),
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
@@ -50,6 +50,8 @@ type GitRepo interface {
 	LatestBranchCommit(branch string) (string, error)
 	TagCommit(tag string) (string, error)
 	IsCommitExists(commit string) (bool, error)
+	// FetchCommit fetches the commit if it is missing in the shallow clone
+	FetchCommit(commit string) error
 	FindCommitIdByMessage(regex string) (string, error)
 
 	CreatePatch(PatchOptions) (Patch, error)
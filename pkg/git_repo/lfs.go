@@ -0,0 +1,577 @@
+package git_repo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+
+	"github.com/flant/logboek"
+
+	"github.com/flant/werf/pkg/true_git"
+)
+
+const (
+	lfsMediaType      = "application/vnd.git-lfs+json"
+	lfsBatchChunkSize = 100
+)
+
+func GetLFSObjectsCacheDir() string {
+	return filepath.Join(GetGitRepoCacheDir(), "lfs", "objects")
+}
+
+// HasLFSInCommit checks whether LFS filter is used in any .gitattributes of the commit tree
+func HasLFSInCommit(commit *object.Commit) (bool, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return false, err
+	}
+
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return false, err
+		}
+
+		if !entry.Mode.IsFile() || filepath.Base(filepath.FromSlash(name)) != ".gitattributes" {
+			continue
+		}
+
+		file, err := tree.TreeEntryFile(&entry)
+		if err != nil {
+			return false, fmt.Errorf("unable to get file %s: %s", name, err)
+		}
+
+		content, err := file.Contents()
+		if err != nil {
+			return false, fmt.Errorf("unable to read file %s: %s", name, err)
+		}
+
+		if isLFSFilterInGitAttributes(content) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func isLFSFilterInGitAttributes(content string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		for _, attr := range fields[1:] {
+			if attr == "filter=lfs" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func lfsPointersInCommit(commit *object.Commit, pathFilter true_git.PathFilter) ([]*true_git.LFSPointer, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	var pointers []*true_git.LFSPointer
+	oids := map[string]bool{}
+
+	// files are filtered before reading: blobs out of the path filter may be absent in partial clone
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if !entry.Mode.IsFile() || entry.Mode == filemode.Symlink {
+			continue
+		}
+
+		if !pathFilter.IsFilePathValid(filepath.FromSlash(name)) {
+			continue
+		}
+
+		file, err := tree.TreeEntryFile(&entry)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get file %s: %s", name, err)
+		}
+
+		if file.Size > true_git.LFSPointerMaxSize {
+			continue
+		}
+
+		content, err := file.Contents()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read file %s: %s", name, err)
+		}
+
+		pointer := true_git.ParseLFSPointer([]byte(content))
+		if pointer != nil && !oids[pointer.Oid] {
+			oids[pointer.Oid] = true
+			pointers = append(pointers, pointer)
+		}
+	}
+
+	return pointers, nil
+}
+
+// lfsStorage looks up LFS objects in the repo LFS storage and werf LFS objects cache,
+// missing objects are downloaded into the cache by LFS batch API
+type lfsStorage struct {
+	objectsDirs []string
+	cacheDir    string
+
+	// endpoint is LFS server url without userinfo, so that it can be logged
+	endpoint    string
+	header      map[string]string
+	credentials *remoteCredentials
+	// sshEndpoint is the ssh remote, which LFS server url and authorization header are requested by git-lfs-authenticate
+	sshEndpoint *transport.Endpoint
+	client      *http.Client
+}
+
+func (repo *Base) newLFSStorage(repoPath, gitDir string, commit *object.Commit, httpAccess *remoteHTTPAccess) (*lfsStorage, error) {
+	storage := &lfsStorage{
+		objectsDirs: []string{filepath.Join(gitDir, "lfs", "objects")},
+		cacheDir:    GetLFSObjectsCacheDir(),
+	}
+
+	endpoint, err := lfsEndpointFromCommit(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	if endpoint == "" {
+		endpoint, err = lfsEndpointFromConfig(filepath.Join(gitDir, "config"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if endpoint == "" {
+		remoteUrl, err := repo.remoteOriginUrl(repoPath)
+		if err != nil {
+			return nil, err
+		}
+
+		if remoteUrl != "" {
+			ep, err := transport.NewEndpoint(remoteUrl)
+			if err != nil {
+				return nil, fmt.Errorf("bad remote url %s: %s", redactUrl(remoteUrl), err)
+			}
+
+			switch ep.Protocol {
+			case "file":
+				storage.objectsDirs = append(storage.objectsDirs,
+					filepath.Join(ep.Path, ".git", "lfs", "objects"),
+					filepath.Join(ep.Path, "lfs", "objects"),
+				)
+			case "ssh":
+				storage.sshEndpoint = ep
+				endpoint = lfsEndpointFromRemote(remoteUrl, ep)
+			default:
+				endpoint = lfsEndpointFromRemote(remoteUrl, ep)
+			}
+		}
+	}
+
+	if err := storage.setEndpoint(endpoint, httpAccess); err != nil {
+		return nil, err
+	}
+	storage.objectsDirs = append(storage.objectsDirs, storage.cacheDir)
+
+	return storage, nil
+}
+
+// setEndpoint sets LFS server url and the http client of the storage.
+// Credentials of the url userinfo are used, otherwise credentials of the remote are used for LFS server on the remote host
+func (s *lfsStorage) setEndpoint(endpoint string, httpAccess *remoteHTTPAccess) error {
+	var httpTransport http.RoundTripper
+	if httpAccess != nil {
+		httpTransport = httpAccess.transport
+	} else {
+		t, err := newHTTPTransport("")
+		if err != nil {
+			return err
+		}
+		httpTransport = t
+	}
+	s.client = &http.Client{Transport: httpTransport}
+
+	if endpoint == "" {
+		return nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("bad LFS server url %s: %s", redactUrl(endpoint), err)
+	}
+
+	if u.User != nil {
+		password, _ := u.User.Password()
+		s.credentials = &remoteCredentials{username: u.User.Username(), password: password}
+		u.User = nil
+	} else if httpAccess != nil && httpAccess.credentials != nil {
+		if remoteUrl, err := url.Parse(httpAccess.url); err == nil && remoteUrl.Scheme == u.Scheme && remoteUrl.Host == u.Host {
+			s.credentials = httpAccess.credentials
+		}
+	}
+
+	s.endpoint = u.String()
+
+	return nil
+}
+
+// lfsEndpointFromCommit reads lfs.url from .lfsconfig of the commit
+func lfsEndpointFromCommit(commit *object.Commit) (string, error) {
+	file, err := commit.File(".lfsconfig")
+	if err == object.ErrFileNotFound {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	content, err := file.Contents()
+	if err != nil {
+		return "", err
+	}
+
+	cfg, err := ini.Load([]byte(content))
+	if err != nil {
+		return "", fmt.Errorf("bad .lfsconfig: %s", err)
+	}
+
+	return cfg.Section("lfs").Key("url").String(), nil
+}
+
+func lfsEndpointFromConfig(configPath string) (string, error) {
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		return "", nil
+	}
+
+	cfg, err := ini.Load(configPath)
+	if err != nil {
+		return "", fmt.Errorf("cannot load repo config %s: %s", configPath, err)
+	}
+
+	return cfg.Section("lfs").Key("url").String(), nil
+}
+
+// lfsEndpointFromRemote returns the default LFS server url of the remote, ssh remotes are served over https
+func lfsEndpointFromRemote(remoteUrl string, ep *transport.Endpoint) string {
+	var repoUrl string
+	switch ep.Protocol {
+	case "http", "https":
+		repoUrl = strings.TrimRight(remoteUrl, "/")
+	default:
+		repoUrl = fmt.Sprintf("https://%s/%s", ep.Host, strings.Trim(ep.Path, "/"))
+	}
+
+	if !strings.HasSuffix(repoUrl, ".git") {
+		repoUrl += ".git"
+	}
+
+	return repoUrl + "/info/lfs"
+}
+
+func lfsObjectPath(objectsDir, oid string) string {
+	return filepath.Join(objectsDir, oid[0:2], oid[2:4], oid)
+}
+
+func (s *lfsStorage) ObjectPath(pointer *true_git.LFSPointer) (string, error) {
+	for _, objectsDir := range s.objectsDirs {
+		path := lfsObjectPath(objectsDir, pointer.Oid)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !os.IsNotExist(err) {
+			return "", fmt.Errorf("error accessing %s: %s", path, err)
+		}
+	}
+
+	return "", fmt.Errorf("LFS object %s not found", pointer.Oid)
+}
+
+func (s *lfsStorage) Fetch(pointers []*true_git.LFSPointer) error {
+	var missingPointers []*true_git.LFSPointer
+	for _, pointer := range pointers {
+		if _, err := s.ObjectPath(pointer); err != nil {
+			missingPointers = append(missingPointers, pointer)
+		}
+	}
+
+	if len(missingPointers) == 0 {
+		return nil
+	}
+
+	if s.sshEndpoint != nil {
+		if err := s.sshAuthenticate(); err != nil {
+			logboek.LogInfoF("Unable to authenticate to LFS server over ssh, using %s without authorization: %s\n", s.endpoint, err)
+		}
+		s.sshEndpoint = nil
+	}
+
+	if s.endpoint == "" {
+		return fmt.Errorf("unable to fetch %d LFS objects: LFS server url cannot be determined, lfs.url should be specified in .lfsconfig", len(missingPointers))
+	}
+
+	return logboek.LogProcess(fmt.Sprintf("Fetching %d LFS objects from %s", len(missingPointers), s.endpoint), logboek.LogProcessOptions{}, func() error {
+		for len(missingPointers) > 0 {
+			chunkSize := lfsBatchChunkSize
+			if len(missingPointers) < chunkSize {
+				chunkSize = len(missingPointers)
+			}
+
+			if err := s.fetchBatch(missingPointers[:chunkSize]); err != nil {
+				return err
+			}
+
+			missingPointers = missingPointers[chunkSize:]
+		}
+
+		return nil
+	})
+}
+
+type lfsBatchRequest struct {
+	Operation string            `json:"operation"`
+	Transfers []string          `json:"transfers"`
+	Objects   []*lfsBatchObject `json:"objects"`
+}
+
+type lfsBatchResponse struct {
+	Objects []*lfsBatchObject `json:"objects"`
+}
+
+type lfsBatchObject struct {
+	Oid     string                     `json:"oid"`
+	Size    int64                      `json:"size"`
+	Actions map[string]*lfsBatchAction `json:"actions,omitempty"`
+	Error   *lfsBatchError             `json:"error,omitempty"`
+}
+
+type lfsBatchAction struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header,omitempty"`
+}
+
+type lfsBatchError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (s *lfsStorage) fetchBatch(pointers []*true_git.LFSPointer) error {
+	batchRequest := &lfsBatchRequest{Operation: "download", Transfers: []string{"basic"}}
+	for _, pointer := range pointers {
+		batchRequest.Objects = append(batchRequest.Objects, &lfsBatchObject{Oid: pointer.Oid, Size: pointer.Size})
+	}
+
+	data, err := json.Marshal(batchRequest)
+	if err != nil {
+		return err
+	}
+
+	batchUrl := strings.TrimRight(s.endpoint, "/") + "/objects/batch"
+	req, err := http.NewRequest("POST", batchUrl, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("bad LFS batch url %s: %s", batchUrl, err)
+	}
+	req.Header.Set("Accept", lfsMediaType)
+	req.Header.Set("Content-Type", lfsMediaType)
+	for key, value := range s.header {
+		req.Header.Set(key, value)
+	}
+	if req.Header.Get("Authorization") == "" && s.credentials != nil {
+		req.SetBasicAuth(s.credentials.username, s.credentials.password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("LFS batch request %s failed: %s", batchUrl, redactUrlError(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("LFS batch request %s failed: %s: %s", batchUrl, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	batchResponse := &lfsBatchResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(batchResponse); err != nil {
+		return fmt.Errorf("unable to decode LFS batch response %s: %s", batchUrl, err)
+	}
+
+	for _, obj := range batchResponse.Objects {
+		if obj.Error != nil {
+			return fmt.Errorf("unable to fetch LFS object %s: %d %s", obj.Oid, obj.Error.Code, obj.Error.Message)
+		}
+
+		action, hasKey := obj.Actions["download"]
+		if !hasKey {
+			// object is not required to be downloaded by LFS server, it should be available locally
+			continue
+		}
+
+		logboek.LogInfoF("Downloading LFS object %s (%d bytes)\n", obj.Oid, obj.Size)
+
+		if err := s.downloadObject(obj, action); err != nil {
+			return fmt.Errorf("unable to download LFS object %s: %s", obj.Oid, err)
+		}
+	}
+
+	return nil
+}
+
+// downloadObject downloads the object by the action of LFS server, the action contains the required authorization header
+func (s *lfsStorage) downloadObject(obj *lfsBatchObject, action *lfsBatchAction) error {
+	req, err := http.NewRequest("GET", action.Href, nil)
+	if err != nil {
+		return fmt.Errorf("bad url %s: %s", redactUrl(action.Href), err)
+	}
+
+	for key, value := range action.Header {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("GET %s failed: %s", redactUrl(action.Href), redactUrlError(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", redactUrl(action.Href), resp.Status)
+	}
+
+	path := lfsObjectPath(s.cacheDir, obj.Oid)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create dir %s: %s", filepath.Dir(path), err)
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), obj.Oid+".tmp")
+	if err != nil {
+		return fmt.Errorf("unable to create tmp file: %s", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmpFile, hash), resp.Body)
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %s", tmpFile.Name(), err)
+	}
+
+	if oid := fmt.Sprintf("%x", hash.Sum(nil)); oid != obj.Oid || size != obj.Size {
+		return fmt.Errorf("downloaded object is corrupted: got oid %s and size %d", oid, size)
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), path)
+}
+
+type lfsSSHAuthenticateResponse struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header"`
+}
+
+// sshAuthenticate requests LFS server url and authorization header by git-lfs-authenticate command of the ssh remote,
+// ssh command can be overridden by GIT_SSH_COMMAND as for git
+func (s *lfsStorage) sshAuthenticate() error {
+	var sshArgs []string
+	if s.sshEndpoint.Port > 0 {
+		sshArgs = append(sshArgs, "-p", strconv.Itoa(s.sshEndpoint.Port))
+	}
+
+	host := s.sshEndpoint.Host
+	if s.sshEndpoint.User != "" {
+		host = fmt.Sprintf("%s@%s", s.sshEndpoint.User, host)
+	}
+	sshArgs = append(sshArgs, host, fmt.Sprintf("git-lfs-authenticate %s download", strings.TrimPrefix(s.sshEndpoint.Path, "/")))
+
+	var cmd *exec.Cmd
+	if sshCommand := os.Getenv("GIT_SSH_COMMAND"); sshCommand != "" {
+		cmd = exec.Command("sh", append([]string{"-c", sshCommand + ` "$@"`, "ssh"}, sshArgs...)...)
+	} else {
+		cmd = exec.Command("ssh", sshArgs...)
+	}
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git-lfs-authenticate failed: %s\n%s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	response := &lfsSSHAuthenticateResponse{}
+	if err := json.Unmarshal(stdout.Bytes(), response); err != nil {
+		return fmt.Errorf("unable to decode git-lfs-authenticate response: %s", err)
+	}
+
+	if response.Href == "" {
+		return fmt.Errorf("git-lfs-authenticate returned no LFS server url")
+	}
+
+	u, err := url.Parse(response.Href)
+	if err != nil {
+		return fmt.Errorf("bad LFS server url %s: %s", redactUrl(response.Href), err)
+	}
+	u.User = nil
+
+	s.endpoint = u.String()
+	s.header = response.Header
+	s.credentials = nil
+
+	return nil
+}
+
+// redactUrl removes userinfo and query from the url, so that credentials and signatures are not logged
+func redactUrl(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "<bad url>"
+	}
+
+	u.User = nil
+	u.RawQuery = ""
+
+	return u.String()
+}
+
+func redactUrlError(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		return fmt.Errorf("%s", urlErr.Err)
+	}
+
+	return err
+}
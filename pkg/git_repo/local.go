@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
+	"sync"
 
+	"github.com/flant/werf/pkg/true_git"
 	"github.com/flant/werf/pkg/util"
 
 	"github.com/flant/logboek"
@@ -17,6 +19,14 @@ type Local struct {
 	Base
 	Path   string
 	GitDir string
+
+	// Dev enables building of the working tree: HeadCommit returns the commit of staged and unstaged changes of tracked files
+	Dev bool
+	// DevIncludeUntracked adds untracked files not ignored by .gitignore to the working tree commit
+	DevIncludeUntracked bool
+
+	devHeadCommit      string
+	devHeadCommitMutex sync.Mutex
 }
 
 func (repo *Local) FindCommitIdByMessage(regex string) (string, error) {
@@ -40,7 +50,50 @@ func (repo *Local) HeadCommit() (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("cannot get repo `%s` head ref: %s", repo.Path, err)
 	}
-	return fmt.Sprintf("%s", ref.Hash()), nil
+
+	headCommit := fmt.Sprintf("%s", ref.Hash())
+	if !repo.Dev {
+		return headCommit, nil
+	}
+
+	return repo.getDevHeadCommit(headCommit)
+}
+
+// getDevHeadCommit creates the working tree commit once: working tree changes made during the build are ignored
+func (repo *Local) getDevHeadCommit(headCommit string) (string, error) {
+	repo.devHeadCommitMutex.Lock()
+	defer repo.devHeadCommitMutex.Unlock()
+
+	if repo.devHeadCommit != "" {
+		return repo.devHeadCommit, nil
+	}
+
+	commit, err := true_git.CreateWorkTreeCommit(repo.Path, repo.GitDir, headCommit, true_git.WorkTreeCommitOptions{IncludeUntracked: repo.DevIncludeUntracked})
+	if err != nil {
+		return "", fmt.Errorf("cannot create working tree commit of repo `%s`: %s", repo.Path, err)
+	}
+
+	if commit != headCommit {
+		logboek.LogInfoF("Using commit %s of working tree changes of repo `%s`\n", commit, repo.Path)
+	}
+
+	repo.devHeadCommit = commit
+
+	return commit, nil
+}
+
+// HasDevChanges returns true if the repo is built in dev mode and the working tree has changes
+func (repo *Local) HasDevChanges() (bool, error) {
+	if !repo.Dev {
+		return false, nil
+	}
+
+	devHeadCommit, err := repo.HeadCommit()
+	if err != nil {
+		return false, err
+	}
+
+	return devHeadCommit != repo.GetHeadCommit(), nil
 }
 
 func (repo *Local) HeadBranchName() (string, error) {
@@ -48,21 +101,32 @@ func (repo *Local) HeadBranchName() (string, error) {
 }
 
 func (repo *Local) CreatePatch(opts PatchOptions) (Patch, error) {
-	return repo.createPatch(repo.Path, repo.GitDir, repo.getRepoWorkTreeCacheDir(), opts)
+	return getOrCreateCachedPatch(repo.getDataCacheRepoId(), opts, func() (Patch, error) {
+		return repo.createPatch(repo.Path, repo.GitDir, repo.getRepoWorkTreeCacheDir(), false, opts)
+	})
 }
 
 func (repo *Local) CreateArchive(opts ArchiveOptions) (Archive, error) {
-	return repo.createArchive(repo.Path, repo.GitDir, repo.getRepoWorkTreeCacheDir(), opts)
+	return getOrCreateCachedArchive(repo.getDataCacheRepoId(), opts, func() (Archive, error) {
+		return repo.createArchive(repo.Path, repo.GitDir, repo.getRepoWorkTreeCacheDir(), nil, opts)
+	})
 }
 
 func (repo *Local) Checksum(opts ChecksumOptions) (Checksum, error) {
-	return repo.checksum(repo.Path, repo.GitDir, repo.getRepoWorkTreeCacheDir(), opts)
+	return getOrCreateCachedChecksum(repo.getDataCacheRepoId(), opts, func() (Checksum, error) {
+		return repo.checksum(repo.Path, repo.GitDir, repo.getRepoWorkTreeCacheDir(), opts)
+	})
 }
 
 func (repo *Local) IsCommitExists(commit string) (bool, error) {
 	return repo.isCommitExists(repo.Path, repo.GitDir, commit)
 }
 
+// FetchCommit does nothing: the local repo is not fetched by werf
+func (repo *Local) FetchCommit(_ string) error {
+	return nil
+}
+
 func (repo *Local) TagsList() ([]string, error) {
 	return repo.tagsList(repo.Path)
 }
@@ -71,6 +135,16 @@ func (repo *Local) RemoteBranchesList() ([]string, error) {
 	return repo.remoteBranchesList(repo.Path)
 }
 
+// getDataCacheRepoId identifies the local repo by the absolute path in the git data cache
+func (repo *Local) getDataCacheRepoId() string {
+	absPath, err := filepath.Abs(repo.Path)
+	if err != nil {
+		panic(err) // stupid interface of filepath.Abs
+	}
+
+	return "local:" + filepath.Clean(absPath)
+}
+
 func (repo *Local) getRepoWorkTreeCacheDir() string {
 	absPath, err := filepath.Abs(repo.Path)
 	if err != nil {
@@ -0,0 +1,216 @@
+package git_repo
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/ini.v1"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"github.com/flant/logboek"
+
+	"github.com/flant/werf/pkg/true_git"
+	"github.com/flant/werf/pkg/util"
+)
+
+const (
+	partialCloneFilter         = "blob:none"
+	partialCloneFetchChunkSize = 1000
+)
+
+// partialCloneServiceFiles are read by werf from the commit regardless of git mapping paths
+var partialCloneServiceFiles = []string{".gitmodules", ".gitattributes", ".lfsconfig"}
+
+// AddSparseCheckoutPaths adds git mapping paths to the partial clone work tree
+func (repo *Remote) AddSparseCheckoutPaths(basePath string, includePaths []string) {
+	basePath = filepath.ToSlash(basePath)
+
+	if len(includePaths) == 0 {
+		repo.addSparseCheckoutPattern(sparseCheckoutPattern(basePath))
+		return
+	}
+
+	for _, includePath := range includePaths {
+		repo.addSparseCheckoutPattern(sparseCheckoutPattern(path.Join(basePath, filepath.ToSlash(includePath))))
+	}
+}
+
+func sparseCheckoutPattern(repoPath string) string {
+	repoPath = strings.Trim(repoPath, "/")
+	if repoPath == "" {
+		return "/*"
+	}
+
+	return "/" + repoPath
+}
+
+func (repo *Remote) addSparseCheckoutPattern(pattern string) {
+	repo.sparseCheckoutPatterns = util.UniqAppendString(repo.sparseCheckoutPatterns, pattern)
+	sort.Strings(repo.sparseCheckoutPatterns)
+}
+
+func (repo *Remote) sparseCheckoutPatternsHash() string {
+	return util.Sha256Hash(repo.sparseCheckoutPatterns...)
+}
+
+// setupSparseCheckout limits work tree of the partial clone to git mappings paths, so that
+// blobs out of git mappings are not fetched on work tree switching
+func (repo *Remote) setupSparseCheckout() error {
+	return repo.withRemoteRepoLock(func() error {
+		clonePath := repo.GetClonePath()
+
+		sparseCheckoutPath := filepath.Join(clonePath, "info", "sparse-checkout")
+		data := []byte(strings.Join(repo.sparseCheckoutPatterns, "\n") + "\n")
+
+		if currentData, err := ioutil.ReadFile(sparseCheckoutPath); err == nil && string(currentData) == string(data) {
+			return nil
+		} else if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to read %s: %s", sparseCheckoutPath, err)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(sparseCheckoutPath), os.ModePerm); err != nil {
+			return fmt.Errorf("unable to create dir %s: %s", filepath.Dir(sparseCheckoutPath), err)
+		}
+
+		if err := ioutil.WriteFile(sparseCheckoutPath, data, 0644); err != nil {
+			return fmt.Errorf("unable to write %s: %s", sparseCheckoutPath, err)
+		}
+
+		// index keeps skip-worktree bits of the previous patterns
+		indexPath := filepath.Join(clonePath, "index")
+		if err := os.RemoveAll(indexPath); err != nil {
+			return fmt.Errorf("unable to remove %s: %s", indexPath, err)
+		}
+
+		cfgPath := filepath.Join(clonePath, "config")
+		cfg, err := ini.Load(cfgPath)
+		if err != nil {
+			return fmt.Errorf("cannot load repo `%s` config: %s", repo.String(), err)
+		}
+
+		cfg.Section("core").Key("sparseCheckout").SetValue("true")
+		if err := cfg.SaveTo(cfgPath); err != nil {
+			return fmt.Errorf("cannot save repo `%s` config: %s", repo.String(), err)
+		}
+
+		return nil
+	})
+}
+
+// setupPartialClone configures the remote of the clone as promisor, so that git fetches missing objects on demand
+func setupPartialClone(clonePath, remoteName string) error {
+	cfgPath := filepath.Join(clonePath, "config")
+	cfg, err := ini.Load(cfgPath)
+	if err != nil {
+		return fmt.Errorf("cannot load config %s: %s", cfgPath, err)
+	}
+
+	cfg.Section("core").Key("repositoryformatversion").SetValue("1")
+	cfg.Section("extensions").Key("partialClone").SetValue(remoteName)
+	remoteSection := cfg.Section(fmt.Sprintf("remote \"%s\"", remoteName))
+	remoteSection.Key("promisor").SetValue("true")
+	remoteSection.Key("partialclonefilter").SetValue(partialCloneFilter)
+
+	if err := cfg.SaveTo(cfgPath); err != nil {
+		return fmt.Errorf("cannot save config %s: %s", cfgPath, err)
+	}
+
+	return nil
+}
+
+// fetchMissingBlobs fetches blobs of the commit files matched by filter in one batch instead of
+// git lazy fetching of the each blob
+func (repo *Remote) fetchMissingBlobs(commit string, filterOpts FilterOptions) error {
+	if !repo.PartialClone || repo.IsDryRun {
+		return nil
+	}
+
+	repository, err := git.PlainOpen(repo.GetClonePath())
+	if err != nil {
+		return fmt.Errorf("cannot open repo `%s`: %s", repo.GetClonePath(), err)
+	}
+
+	commitHash, err := newHash(commit)
+	if err != nil {
+		return fmt.Errorf("bad commit hash `%s`: %s", commit, err)
+	}
+
+	commitObj, err := repository.CommitObject(commitHash)
+	if err != nil {
+		return fmt.Errorf("bad commit `%s`: %s", commit, err)
+	}
+
+	tree, err := commitObj.Tree()
+	if err != nil {
+		return fmt.Errorf("cannot get commit `%s` tree: %s", commit, err)
+	}
+
+	pathFilter := true_git.PathFilter{
+		BasePath:     filterOpts.BasePath,
+		IncludePaths: filterOpts.IncludePaths,
+		ExcludePaths: filterOpts.ExcludePaths,
+	}
+
+	var missingBlobs []string
+
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("cannot traverse commit `%s` tree: %s", commit, err)
+		}
+
+		if !entry.Mode.IsFile() {
+			continue
+		}
+
+		if !util.IsStringsContainValue(partialCloneServiceFiles, name) && !pathFilter.IsFilePathValid(filepath.FromSlash(name)) {
+			continue
+		}
+
+		if _, err := repository.Storer.EncodedObject(plumbing.BlobObject, entry.Hash); err == plumbing.ErrObjectNotFound {
+			missingBlobs = append(missingBlobs, entry.Hash.String())
+		} else if err != nil {
+			return fmt.Errorf("cannot get blob %s of file %s: %s", entry.Hash, name, err)
+		}
+	}
+
+	if len(missingBlobs) == 0 {
+		return nil
+	}
+
+	return logboek.LogProcess(fmt.Sprintf("Fetching %d files of commit %s", len(missingBlobs), commit), logboek.LogProcessOptions{}, func() error {
+		return repo.withRemoteRepoLock(func() error {
+			for len(missingBlobs) > 0 {
+				chunkSize := partialCloneFetchChunkSize
+				if len(missingBlobs) < chunkSize {
+					chunkSize = len(missingBlobs)
+				}
+
+				if err := true_git.Fetch(repo.GetClonePath(), "origin", true_git.FetchOptions{
+					Filter:   partialCloneFilter,
+					NoTags:   true,
+					Refspecs: missingBlobs[:chunkSize],
+				}); err != nil {
+					return fmt.Errorf("cannot fetch files of commit `%s`: %s", commit, err)
+				}
+
+				missingBlobs = missingBlobs[chunkSize:]
+			}
+
+			return nil
+		})
+	})
+}
@@ -2,14 +2,19 @@ package git_repo
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/flant/werf/pkg/slug"
+	"github.com/flant/werf/pkg/true_git"
+	"github.com/flant/werf/pkg/util"
 
 	"gopkg.in/ini.v1"
 	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/storer"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
@@ -18,14 +23,45 @@ import (
 	"github.com/flant/shluz"
 )
 
+// shallowDeepenInitialStep is the initial number of commits to deepen the shallow repo history,
+// the step is doubled until the missing commit is found
+const shallowDeepenInitialStep = 16
+
 type Remote struct {
 	Base
 	Url      string
 	IsDryRun bool
+
+	// Shallow enables fetching of the only commits used by git mappings, missing commits are fetched on demand
+	Shallow bool
+	// PartialClone enables fetching of the only files of git mappings paths
+	PartialClone bool
+	// Auth is the authentication of the repo accessed over http or https
+	Auth *RemoteAuth
+
+	httpAccess             *remoteHTTPAccess
+	sparseCheckoutPatterns []string
+
+	// shallowRefspecs are fetched refspecs of the shallow repo, which are used by concurrently built images
+	shallowRefspecs      []string
+	shallowRefspecsMutex sync.Mutex
 }
 
 func (repo *Remote) GetClonePath() string {
-	return filepath.Join(GetGitRepoCacheDir(), "remote", slug.Slug(repo.Url))
+	return filepath.Join(GetGitRepoCacheDir(), "remote", slug.Slug(repo.Url)+repo.cloneModeSuffix())
+}
+
+// cloneModeSuffix separates shallow and partial clones of the repo from the full one
+func (repo *Remote) cloneModeSuffix() string {
+	var suffix string
+	if repo.Shallow {
+		suffix += "-shallow"
+	}
+	if repo.PartialClone {
+		suffix += "-partial"
+	}
+
+	return suffix
 }
 
 func (repo *Remote) RemoteOriginUrl() (string, error) {
@@ -33,6 +69,11 @@ func (repo *Remote) RemoteOriginUrl() (string, error) {
 }
 
 func (repo *Remote) FindCommitIdByMessage(regex string) (string, error) {
+	// history of the shallow repo depends on fetched commits, so the search result would not be stable
+	if repo.Shallow {
+		return "", nil
+	}
+
 	head, err := repo.HeadCommit()
 	if err != nil {
 		return "", fmt.Errorf("error getting head commit: %s", err)
@@ -45,11 +86,16 @@ func (repo *Remote) IsEmpty() (bool, error) {
 }
 
 func (repo *Remote) CloneAndFetch() error {
+	if err := repo.setupAuth(); err != nil {
+		return err
+	}
+
 	isCloned, err := repo.Clone()
 	if err != nil {
 		return err
 	}
-	if isCloned {
+	// shallow and partial clones are created empty
+	if isCloned && !repo.Shallow && !repo.PartialClone {
 		return nil
 	}
 
@@ -106,13 +152,25 @@ func (repo *Remote) Clone() (bool, error) {
 		}
 		// Ensure cleanup on failure
 		defer os.RemoveAll(tmpPath)
-
-		_, err = git.PlainClone(tmpPath, true, &git.CloneOptions{
-			URL:               repo.Url,
-			RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
-		})
-		if err != nil {
-			return err
+		defer true_git.SetRepoEnv(tmpPath, nil)
+
+		if repo.Shallow || repo.PartialClone {
+			if err := repo.initClone(tmpPath); err != nil {
+				return err
+			}
+		} else {
+			_, err = git.PlainClone(tmpPath, true, &git.CloneOptions{
+				URL:               repo.Url,
+				Auth:              repo.goGitAuth(),
+				RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+			})
+			if err != nil {
+				return err
+			}
+
+			if err := repo.setupCloneAuth(tmpPath); err != nil {
+				return err
+			}
 		}
 
 		if err := os.Rename(tmpPath, repo.GetClonePath()); err != nil {
@@ -123,6 +181,40 @@ func (repo *Remote) Clone() (bool, error) {
 	})
 }
 
+// initClone creates the empty clone for shallow or partial fetching, commits are fetched by Fetch and ShallowFetch
+func (repo *Remote) initClone(clonePath string) error {
+	rawRepo, err := git.PlainInit(clonePath, true)
+	if err != nil {
+		return fmt.Errorf("cannot init repo: %s", err)
+	}
+
+	if _, err := rawRepo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{repo.Url}}); err != nil {
+		return fmt.Errorf("cannot create remote: %s", err)
+	}
+
+	if err := repo.setupCloneAuth(clonePath); err != nil {
+		return err
+	}
+
+	if repo.PartialClone {
+		if err := setupPartialClone(clonePath, "origin"); err != nil {
+			return err
+		}
+	}
+
+	headBranch, err := true_git.GetRemoteHeadBranch(clonePath, "origin")
+	if err != nil {
+		return fmt.Errorf("cannot get head branch of %s: %s", repo.Url, err)
+	}
+
+	headRef := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(headBranch))
+	if err := rawRepo.Storer.SetReference(headRef); err != nil {
+		return fmt.Errorf("cannot set head branch %s: %s", headBranch, err)
+	}
+
+	return nil
+}
+
 func (repo *Remote) Fetch() error {
 	if repo.IsDryRun {
 		return nil
@@ -152,9 +244,17 @@ func (repo *Remote) Fetch() error {
 			return fmt.Errorf("cannot open repo: %s", err)
 		}
 
+		if err := repo.setupCloneAuth(repo.GetClonePath()); err != nil {
+			return err
+		}
+
+		if repo.Shallow || repo.PartialClone {
+			return repo.fetchWithGit(remoteName)
+		}
+
 		logboek.LogInfoF("Fetch remote %s of %s\n", remoteName, repo.Url)
 
-		err = rawRepo.Fetch(&git.FetchOptions{RemoteName: remoteName, Force: true, Tags: git.AllTags})
+		err = rawRepo.Fetch(&git.FetchOptions{RemoteName: remoteName, Force: true, Tags: git.AllTags, Auth: repo.goGitAuth()})
 		if err != nil && err != git.NoErrAlreadyUpToDate {
 			return fmt.Errorf("cannot fetch remote `%s` of repo `%s`: %s", remoteName, repo.String(), err)
 		}
@@ -163,6 +263,205 @@ func (repo *Remote) Fetch() error {
 	})
 }
 
+func (repo *Remote) fetchWithGit(remoteName string) error {
+	fetchOptions := true_git.FetchOptions{
+		Refspecs: []string{
+			fmt.Sprintf("+refs/heads/*:refs/remotes/%s/*", remoteName),
+			"+refs/tags/*:refs/tags/*",
+		},
+	}
+
+	if repo.PartialClone {
+		fetchOptions.Filter = partialCloneFilter
+	}
+
+	if repo.Shallow {
+		branch, err := repo.HeadBranchName()
+		if err != nil {
+			return fmt.Errorf("cannot detect head branch name of repo `%s`: %s", repo.String(), err)
+		}
+
+		fetchOptions.Depth = 1
+		fetchOptions.NoTags = true
+		fetchOptions.Refspecs = []string{repo.shallowBranchRefspec(branch)}
+		repo.addShallowRefspec(repo.shallowBranchRefspec(branch))
+
+		logboek.LogInfoF("Shallow fetch branch %s of %s\n", branch, repo.Url)
+	} else {
+		logboek.LogInfoF("Fetch remote %s of %s\n", remoteName, repo.Url)
+	}
+
+	if err := true_git.Fetch(repo.GetClonePath(), remoteName, fetchOptions); err != nil {
+		return fmt.Errorf("cannot fetch remote `%s` of repo `%s`: %s", remoteName, repo.String(), err)
+	}
+
+	return nil
+}
+
+func (repo *Remote) shallowBranchRefspec(branch string) string {
+	return fmt.Sprintf("+refs/heads/%[1]s:refs/remotes/origin/%[1]s", branch)
+}
+
+func (repo *Remote) shallowTagRefspec(tag string) string {
+	return fmt.Sprintf("+refs/tags/%[1]s:refs/tags/%[1]s", tag)
+}
+
+// ShallowFetch fetches the last commit of the branch or tag or the commit itself into the shallow repo
+func (repo *Remote) ShallowFetch(branch, tag, commit string) error {
+	if repo.IsDryRun || !repo.Shallow {
+		return nil
+	}
+
+	var refspec, desc string
+	switch {
+	case commit != "":
+		if exists, err := repo.isCommitExists(repo.GetClonePath(), repo.GetClonePath(), commit); err != nil {
+			return err
+		} else if exists {
+			return nil
+		}
+
+		return repo.withRemoteRepoLock(func() error {
+			logboek.LogInfoF("Shallow fetch commit %s of %s\n", commit, repo.Url)
+			return repo.fetchCommit(commit)
+		})
+	case tag != "":
+		refspec, desc = repo.shallowTagRefspec(tag), fmt.Sprintf("tag %s", tag)
+	case branch != "":
+		refspec, desc = repo.shallowBranchRefspec(branch), fmt.Sprintf("branch %s", branch)
+	default:
+		// head branch is fetched by Fetch
+		return nil
+	}
+
+	if repo.hasShallowRefspec(refspec) {
+		return nil
+	}
+
+	return repo.withRemoteRepoLock(func() error {
+		logboek.LogInfoF("Shallow fetch %s of %s\n", desc, repo.Url)
+
+		fetchOptions := true_git.FetchOptions{Depth: 1, NoTags: true, Refspecs: []string{refspec}}
+		if repo.PartialClone {
+			fetchOptions.Filter = partialCloneFilter
+		}
+
+		if err := true_git.Fetch(repo.GetClonePath(), "origin", fetchOptions); err != nil {
+			return fmt.Errorf("cannot fetch %s of repo `%s`: %s", desc, repo.String(), err)
+		}
+
+		repo.addShallowRefspec(refspec)
+
+		return nil
+	})
+}
+
+func (repo *Remote) addShallowRefspec(refspec string) {
+	repo.shallowRefspecsMutex.Lock()
+	defer repo.shallowRefspecsMutex.Unlock()
+
+	repo.shallowRefspecs = util.UniqAppendString(repo.shallowRefspecs, refspec)
+}
+
+func (repo *Remote) hasShallowRefspec(refspec string) bool {
+	repo.shallowRefspecsMutex.Lock()
+	defer repo.shallowRefspecsMutex.Unlock()
+
+	return util.IsStringsContainValue(repo.shallowRefspecs, refspec)
+}
+
+func (repo *Remote) getShallowRefspecs() []string {
+	repo.shallowRefspecsMutex.Lock()
+	defer repo.shallowRefspecsMutex.Unlock()
+
+	return append([]string{}, repo.shallowRefspecs...)
+}
+
+// fetchCommit fetches the commit by id, the commit is kept by werf ref to prevent it from git gc
+func (repo *Remote) fetchCommit(commit string) error {
+	fetchOptions := true_git.FetchOptions{
+		Depth:    1,
+		NoTags:   true,
+		Refspecs: []string{fmt.Sprintf("+%[1]s:refs/werf/commits/%[1]s", commit)},
+	}
+	if repo.PartialClone {
+		fetchOptions.Filter = partialCloneFilter
+	}
+
+	if err := true_git.Fetch(repo.GetClonePath(), "origin", fetchOptions); err != nil {
+		return fmt.Errorf("cannot fetch commit `%s` of repo `%s`: %s", commit, repo.String(), err)
+	}
+
+	return nil
+}
+
+// FetchCommit fetches the commit missing in the shallow repo. The commit is fetched directly if the remote allows it,
+// otherwise the history of fetched refs is deepened until the commit is found or the whole history is fetched.
+// The commit, which does not exist in the remote, is not an error: use IsCommitExists to check the commit afterwards
+func (repo *Remote) FetchCommit(commit string) error {
+	clonePath := repo.GetClonePath()
+
+	if exists, err := repo.isCommitExists(clonePath, clonePath, commit); err != nil {
+		return err
+	} else if exists || !repo.Shallow || repo.IsDryRun {
+		return nil
+	}
+
+	return repo.withRemoteRepoLock(func() error {
+		if exists, err := repo.isCommitExists(clonePath, clonePath, commit); err != nil {
+			return err
+		} else if exists {
+			return nil
+		}
+
+		logboek.LogInfoF("Fetch missing commit %s of %s\n", commit, repo.Url)
+
+		if err := repo.fetchCommit(commit); err == nil {
+			return nil
+		} else {
+			logboek.LogInfoF("Unable to fetch commit directly: %s\n", err)
+		}
+
+		shallowFilePath := filepath.Join(clonePath, "shallow")
+		for deepen := shallowDeepenInitialStep; ; deepen *= 2 {
+			shallowBefore, err := ioutil.ReadFile(shallowFilePath)
+			if os.IsNotExist(err) {
+				// the whole history is fetched
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("unable to read %s: %s", shallowFilePath, err)
+			}
+
+			logboek.LogInfoF("Deepen history of %s by %d commits\n", repo.Url, deepen)
+
+			fetchOptions := true_git.FetchOptions{Deepen: deepen, NoTags: true, Refspecs: repo.getShallowRefspecs()}
+			if repo.PartialClone {
+				fetchOptions.Filter = partialCloneFilter
+			}
+
+			if err := true_git.Fetch(clonePath, "origin", fetchOptions); err != nil {
+				return fmt.Errorf("cannot deepen history of repo `%s`: %s", repo.String(), err)
+			}
+
+			if exists, err := repo.isCommitExists(clonePath, clonePath, commit); err != nil {
+				return err
+			} else if exists {
+				return nil
+			}
+
+			shallowAfter, err := ioutil.ReadFile(shallowFilePath)
+			if os.IsNotExist(err) {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("unable to read %s: %s", shallowFilePath, err)
+			} else if string(shallowAfter) == string(shallowBefore) {
+				// history of fetched refs cannot be deepened anymore
+				return nil
+			}
+		}
+	})
+}
+
 func (repo *Remote) HeadCommit() (string, error) {
 	repoPath := repo.GetClonePath()
 
@@ -266,40 +565,86 @@ func (repo *Remote) TagCommit(tag string) (string, error) {
 }
 
 func (repo *Remote) CreatePatch(opts PatchOptions) (Patch, error) {
-	workTreeDir, err := repo.getWorkTreeDir()
-	if err != nil {
-		return nil, err
-	}
-	return repo.createPatch(repo.GetClonePath(), repo.GetClonePath(), workTreeDir, opts)
+	return getOrCreateCachedPatch(repo.getDataCacheRepoId(), opts, func() (Patch, error) {
+		for _, commit := range []string{opts.FromCommit, opts.ToCommit} {
+			if err := repo.FetchCommit(commit); err != nil {
+				return nil, err
+			}
+
+			// files out of the base path are not needed: diff is limited to the base path
+			if err := repo.fetchMissingBlobs(commit, FilterOptions{BasePath: opts.BasePath}); err != nil {
+				return nil, err
+			}
+		}
+
+		workTreeDir, err := repo.getWorkTreeDir()
+		if err != nil {
+			return nil, err
+		}
+		return repo.createPatch(repo.GetClonePath(), repo.GetClonePath(), workTreeDir, repo.PartialClone, opts)
+	})
 }
 
 func (repo *Remote) CreateArchive(opts ArchiveOptions) (Archive, error) {
-	workTreeDir, err := repo.getWorkTreeDir()
-	if err != nil {
-		return nil, err
-	}
-	return repo.createArchive(repo.GetClonePath(), repo.GetClonePath(), workTreeDir, opts)
+	return getOrCreateCachedArchive(repo.getDataCacheRepoId(), opts, func() (Archive, error) {
+		if err := repo.fetchMissingBlobs(opts.Commit, repo.partialCloneFilterOptions(opts.FilterOptions)); err != nil {
+			return nil, err
+		}
+
+		workTreeDir, err := repo.getWorkTreeDir()
+		if err != nil {
+			return nil, err
+		}
+		return repo.createArchive(repo.GetClonePath(), repo.GetClonePath(), workTreeDir, repo.httpAccess, opts)
+	})
 }
 
 func (repo *Remote) Checksum(opts ChecksumOptions) (Checksum, error) {
-	workTreeDir, err := repo.getWorkTreeDir()
-	if err != nil {
-		return nil, err
-	}
-	return repo.checksum(repo.GetClonePath(), repo.GetClonePath(), workTreeDir, opts)
+	return getOrCreateCachedChecksum(repo.getDataCacheRepoId(), opts, func() (Checksum, error) {
+		if err := repo.fetchMissingBlobs(opts.Commit, repo.partialCloneFilterOptions(opts.FilterOptions)); err != nil {
+			return nil, err
+		}
+
+		workTreeDir, err := repo.getWorkTreeDir()
+		if err != nil {
+			return nil, err
+		}
+		return repo.checksum(repo.GetClonePath(), repo.GetClonePath(), workTreeDir, opts)
+	})
+}
+
+// getDataCacheRepoId identifies the remote repo by the url in the git data cache: projects mapping the same repo share the data
+func (repo *Remote) getDataCacheRepoId() string {
+	return "remote:" + repo.Url
 }
 
 func (repo *Remote) IsCommitExists(commit string) (bool, error) {
 	return repo.isCommitExists(repo.GetClonePath(), repo.GetClonePath(), commit)
 }
 
+// partialCloneFilterOptions ignores exclude paths: excluded files are checked out into sparse work tree anyway
+func (repo *Remote) partialCloneFilterOptions(opts FilterOptions) FilterOptions {
+	return FilterOptions{BasePath: opts.BasePath, IncludePaths: opts.IncludePaths}
+}
+
 func (repo *Remote) getWorkTreeDir() (string, error) {
 	ep, err := transport.NewEndpoint(repo.Url)
 	if err != nil {
 		return "", fmt.Errorf("bad endpoint url `%s`: %s", repo.Url, err)
 	}
 
-	return filepath.Join(GetWorkTreeCacheDir(), "remote", ep.Host, ep.Path), nil
+	workTreeDir := filepath.Join(GetWorkTreeCacheDir(), "remote", ep.Host, ep.Path+repo.cloneModeSuffix())
+
+	if repo.PartialClone && !repo.IsDryRun {
+		if err := repo.setupSparseCheckout(); err != nil {
+			return "", err
+		}
+
+		// work tree depends on sparse checkout patterns
+		workTreeDir = filepath.Join(workTreeDir, "sparse", repo.sparseCheckoutPatternsHash())
+	}
+
+	return workTreeDir, nil
 }
 
 func (repo *Remote) withRemoteRepoLock(f func() error) error {
@@ -0,0 +1,239 @@
+package git_repo
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/client"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+
+	"github.com/flant/werf/pkg/true_git"
+	"github.com/flant/werf/pkg/util"
+)
+
+// RemoteAuth is the authentication of the remote repo accessed over http or https
+type RemoteAuth struct {
+	// Username and Password are used for basic authentication, access token is used as password
+	Username string
+	Password string
+	// CredentialHelper is the git credential helper to get username and password from
+	CredentialHelper string
+	// CAFile is the CA bundle to verify certificate of the remote
+	CAFile string
+}
+
+type remoteCredentials struct {
+	username string
+	password string
+}
+
+// remoteHTTPAccess is the http transport and credentials of the remote, which are used by go-git and to access LFS server
+type remoteHTTPAccess struct {
+	url         string
+	transport   http.RoundTripper
+	credentials *remoteCredentials
+}
+
+const (
+	httpDialTimeout           = 30 * time.Second
+	httpTLSHandshakeTimeout   = 30 * time.Second
+	httpResponseHeaderTimeout = 5 * time.Minute
+	httpIdleConnTimeout       = 90 * time.Second
+)
+
+var (
+	goGitTransports          = map[string]http.RoundTripper{}
+	goGitTransportsMutex     sync.Mutex
+	installGoGitProtocolOnce sync.Once
+)
+
+// setupAuth resolves the transport and credentials of the repo once, credentials are used by go-git directly
+// and passed to git cli through env of the clone commands only
+func (repo *Remote) setupAuth() error {
+	if repo.IsDryRun || repo.httpAccess != nil {
+		return nil
+	}
+
+	var caFile string
+	if repo.Auth != nil {
+		caFile = repo.Auth.CAFile
+	}
+
+	httpTransport, err := newHTTPTransport(caFile)
+	if err != nil {
+		return fmt.Errorf("cannot setup transport of repo `%s`: %s", repo.String(), err)
+	}
+
+	access := &remoteHTTPAccess{url: repo.Url, transport: httpTransport}
+
+	if repo.Auth != nil {
+		username, password := repo.Auth.Username, repo.Auth.Password
+		if repo.Auth.CredentialHelper != "" {
+			username, password, err = true_git.CredentialFill(repo.Url, repo.Auth.CredentialHelper)
+			if err != nil {
+				return fmt.Errorf("cannot get credentials of repo `%s`: %s", repo.String(), err)
+			}
+		}
+
+		if password != "" {
+			access.credentials = &remoteCredentials{username: username, password: password}
+		}
+	}
+
+	repo.httpAccess = access
+	registerGoGitTransport(repo.Url, httpTransport)
+
+	// git lazily fetches missing objects of the partial clone by any command, so that
+	// credentials should be available for all git commands of the clone
+	true_git.SetRepoEnv(repo.GetClonePath(), repo.credentialsEnv())
+
+	return nil
+}
+
+func (repo *Remote) credentialsEnvName(suffix string) string {
+	return fmt.Sprintf("WERF_GIT_%s_%s", strings.ToUpper(util.Sha256Hash(repo.Url)[:12]), suffix)
+}
+
+func (repo *Remote) credentialsEnv() []string {
+	if repo.httpAccess == nil || repo.httpAccess.credentials == nil {
+		return nil
+	}
+
+	return []string{
+		fmt.Sprintf("%s=%s", repo.credentialsEnvName("USERNAME"), repo.httpAccess.credentials.username),
+		fmt.Sprintf("%s=%s", repo.credentialsEnvName("PASSWORD"), repo.httpAccess.credentials.password),
+	}
+}
+
+// setupCloneAuth configures git cli credentials and CA bundle of the clone,
+// configuration contains env var names only: credentials are never written to disk
+func (repo *Remote) setupCloneAuth(clonePath string) error {
+	true_git.SetRepoEnv(clonePath, repo.credentialsEnv())
+
+	if repo.httpAccess != nil && repo.httpAccess.credentials != nil {
+		if err := true_git.SetCredentialsFromEnv(clonePath, repo.credentialsEnvName("USERNAME"), repo.credentialsEnvName("PASSWORD")); err != nil {
+			return fmt.Errorf("cannot configure credentials of repo `%s`: %s", repo.String(), err)
+		}
+	} else if err := true_git.UnsetCredentials(clonePath); err != nil {
+		return fmt.Errorf("cannot configure credentials of repo `%s`: %s", repo.String(), err)
+	}
+
+	var caFile string
+	if repo.Auth != nil {
+		caFile = repo.Auth.CAFile
+	}
+
+	if err := true_git.SetCAFile(clonePath, caFile); err != nil {
+		return fmt.Errorf("cannot configure CA bundle of repo `%s`: %s", repo.String(), err)
+	}
+
+	return nil
+}
+
+func (repo *Remote) goGitAuth() transport.AuthMethod {
+	if repo.httpAccess == nil || repo.httpAccess.credentials == nil {
+		return nil
+	}
+
+	return &githttp.BasicAuth{Username: repo.httpAccess.credentials.username, Password: repo.httpAccess.credentials.password}
+}
+
+// newHTTPTransport returns the transport with timeouts, the CA bundle is added to the system pool if specified
+func newHTTPTransport(caFile string) (*http.Transport, error) {
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   httpDialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   httpTLSHandshakeTimeout,
+		ResponseHeaderTimeout: httpResponseHeaderTimeout,
+		IdleConnTimeout:       httpIdleConnTimeout,
+		MaxIdleConns:          100,
+	}
+
+	if caFile == "" {
+		return t, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA bundle %s: %s", caFile, err)
+	}
+
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", caFile)
+	}
+
+	t.TLSClientConfig = &tls.Config{RootCAs: pool}
+
+	return t, nil
+}
+
+// registerGoGitTransport routes go-git requests of the remote to the transport of the remote.
+// go-git protocols are global, so that the installed client selects the transport by the request url
+func registerGoGitTransport(remoteUrl string, t http.RoundTripper) {
+	u, err := url.Parse(remoteUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return
+	}
+
+	goGitTransportsMutex.Lock()
+	goGitTransports[remoteTransportKey(u)] = t
+	goGitTransportsMutex.Unlock()
+
+	installGoGitProtocolOnce.Do(func() {
+		defaultTransport, _ := newHTTPTransport("")
+		httpClient := &http.Client{Transport: &remotesTransport{defaultTransport: defaultTransport}}
+		client.InstallProtocol("http", githttp.NewClient(httpClient))
+		client.InstallProtocol("https", githttp.NewClient(httpClient))
+	})
+}
+
+func remoteTransportKey(u *url.URL) string {
+	return fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, strings.TrimSuffix(u.Path, "/"))
+}
+
+// remotesTransport sends the request with the transport of the remote, which url is the prefix of the request url
+type remotesTransport struct {
+	defaultTransport http.RoundTripper
+}
+
+func (t *remotesTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.transportFor(req.URL).RoundTrip(req)
+}
+
+func (t *remotesTransport) transportFor(u *url.URL) http.RoundTripper {
+	key := remoteTransportKey(u)
+
+	goGitTransportsMutex.Lock()
+	defer goGitTransportsMutex.Unlock()
+
+	var res http.RoundTripper
+	var resKey string
+	for remoteKey, remoteTransport := range goGitTransports {
+		if (key == remoteKey || strings.HasPrefix(key, remoteKey+"/")) && len(remoteKey) > len(resKey) {
+			res, resKey = remoteTransport, remoteKey
+		}
+	}
+
+	if res == nil {
+		return t.defaultTransport
+	}
+
+	return res
+}
@@ -0,0 +1,42 @@
+package git_repo
+
+import (
+	"net/http"
+	"net/url"
+
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = DescribeTable("go-git transport of the remote",
+	func(requestUrl string, expectedTransport string) {
+		transports := map[string]*http.Transport{"default": {}, "repo": {}, "subgroup repo": {}}
+
+		goGitTransportsMutex.Lock()
+		savedTransports := goGitTransports
+		goGitTransports = map[string]http.RoundTripper{}
+		goGitTransportsMutex.Unlock()
+
+		defer func() {
+			goGitTransportsMutex.Lock()
+			goGitTransports = savedTransports
+			goGitTransportsMutex.Unlock()
+		}()
+
+		registerGoGitTransport("https://git.example.com/group/repo.git", transports["repo"])
+		registerGoGitTransport("https://git.example.com/group/repo.git/sub/", transports["subgroup repo"])
+		registerGoGitTransport("git@git.example.com:group/other.git", &http.Transport{})
+
+		u, err := url.Parse(requestUrl)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		t := &remotesTransport{defaultTransport: transports["default"]}
+		Ω(t.transportFor(u)).Should(BeIdenticalTo(transports[expectedTransport]))
+	},
+	Entry("info refs of the repo", "https://git.example.com/group/repo.git/info/refs?service=git-upload-pack", "repo"),
+	Entry("upload pack of the repo", "https://git.example.com/group/repo.git/git-upload-pack", "repo"),
+	Entry("longest matching remote", "https://git.example.com/group/repo.git/sub/info/refs", "subgroup repo"),
+	Entry("repo with the same prefix", "https://git.example.com/group/repo.git2/info/refs", "default"),
+	Entry("other scheme", "http://git.example.com/group/repo.git/info/refs", "default"),
+	Entry("other host", "https://git.example.org/group/repo.git/info/refs", "default"),
+)
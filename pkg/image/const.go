@@ -9,6 +9,10 @@ const (
 	WerfImageTagLabel     = "werf-image-tag"
 	WerfDockerImageName   = "werf-docker-image-name"
 
+	WerfStageImageNameLabel    = "werf-stage-image-name"
+	WerfStageNameLabel         = "werf-stage-name"
+	WerfStageDependenciesLabel = "werf-stage-dependencies"
+
 	WerfMountTmpDirLabel          = "werf-mount-type-tmp-dir"
 	WerfMountBuildDirLabel        = "werf-mount-type-build-dir"
 	WerfMountCustomDirLabelPrefix = "werf-mount-type-custom-dir-"
@@ -17,6 +21,8 @@ const (
 
 	WerfTagStrategyLabel = "werf-tag-strategy"
 
+	WerfDevModeLabel = "werf-dev-mode"
+
 	BuildCacheVersion = "1"
 
 	StageContainerNamePrefix = "werf.build."
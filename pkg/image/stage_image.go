@@ -31,6 +31,11 @@ func (i *StageImage) Inspect() *types.ImageInspect {
 	return i.inspect
 }
 
+// SetInspect sets the inspect of the image which does not exist locally, e.g. the stage in the stages storage
+func (i *StageImage) SetInspect(inspect *types.ImageInspect) {
+	i.inspect = inspect
+}
+
 func (i *StageImage) Labels() map[string]string {
 	if i.inspect != nil {
 		return i.inspect.Config.Labels
@@ -0,0 +1,303 @@
+package image_signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/werf/pkg/docker_registry"
+)
+
+var (
+	manifestPathRegexp = regexp.MustCompile(`^/v2/(.+)/manifests/([^/]+)$`)
+	blobPathRegexp     = regexp.MustCompile(`^/v2/(.+)/blobs/(sha256:[a-f0-9]+)$`)
+	uploadPathRegexp   = regexp.MustCompile(`^/v2/(.+)/blobs/uploads/([^/]*)$`)
+)
+
+type registryManifest struct {
+	mediaType string
+	data      []byte
+}
+
+// registryStandIn is the in-memory docker registry API with manifests and blobs push and pull
+type registryStandIn struct {
+	mutex     sync.Mutex
+	manifests map[string]*registryManifest
+	blobs     map[string][]byte
+	uploads   map[string][]byte
+}
+
+func newRegistryStandIn() *httptest.Server {
+	r := &registryStandIn{manifests: map[string]*registryManifest{}, blobs: map[string][]byte{}, uploads: map[string][]byte{}}
+	return httptest.NewServer(r)
+}
+
+func (r *registryStandIn) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	path := req.URL.Path
+	if path == "/v2/" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if m := uploadPathRegexp.FindStringSubmatch(path); m != nil {
+		r.serveUpload(w, req, m[1], m[2])
+	} else if m := blobPathRegexp.FindStringSubmatch(path); m != nil {
+		r.serveBlob(w, req, m[1]+"@"+m[2])
+	} else if m := manifestPathRegexp.FindStringSubmatch(path); m != nil {
+		r.serveManifest(w, req, m[1], m[2])
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (r *registryStandIn) serveUpload(w http.ResponseWriter, req *http.Request, repository, id string) {
+	location := fmt.Sprintf("/v2/%s/blobs/uploads/%s", repository, id)
+
+	switch req.Method {
+	case http.MethodPost:
+		id = fmt.Sprintf("upload-%d", len(r.uploads))
+		r.uploads[id] = nil
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repository, id))
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPatch:
+		data, _ := ioutil.ReadAll(req.Body)
+		r.uploads[id] = append(r.uploads[id], data...)
+		w.Header().Set("Location", location)
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		data, _ := ioutil.ReadAll(req.Body)
+		data = append(r.uploads[id], data...)
+		digest := req.URL.Query().Get("digest")
+		if digest != fmt.Sprintf("sha256:%x", sha256.Sum256(data)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		r.blobs[repository+"@"+digest] = data
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *registryStandIn) serveBlob(w http.ResponseWriter, req *http.Request, key string) {
+	data, hasBlob := r.blobs[key]
+	if !hasBlob {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if req.Method == http.MethodGet {
+		_, _ = w.Write(data)
+	}
+}
+
+func (r *registryStandIn) serveManifest(w http.ResponseWriter, req *http.Request, repository, reference string) {
+	switch req.Method {
+	case http.MethodPut:
+		data, _ := ioutil.ReadAll(req.Body)
+		r.putManifest(repository, reference, req.Header.Get("Content-Type"), data)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet, http.MethodHead:
+		m, hasManifest := r.manifests[repository+":"+reference]
+		if !hasManifest {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"errors": []interface{}{map[string]string{"code": "MANIFEST_UNKNOWN", "message": "manifest unknown"}},
+			})
+			return
+		}
+
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Docker-Content-Digest", fmt.Sprintf("sha256:%x", sha256.Sum256(m.data)))
+		if req.Method == http.MethodGet {
+			_, _ = w.Write(m.data)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *registryStandIn) putManifest(repository, tag, mediaType string, data []byte) string {
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	m := &registryManifest{mediaType: mediaType, data: data}
+	r.manifests[repository+":"+tag] = m
+	r.manifests[repository+":"+digest] = m
+
+	return digest
+}
+
+func generateKey() *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Ω(err).ShouldNot(HaveOccurred())
+	return key
+}
+
+var _ = Describe("keys", func() {
+	var key *ecdsa.PrivateKey
+
+	BeforeEach(func() {
+		key = generateKey()
+	})
+
+	It("parses EC private key", func() {
+		der, err := x509.MarshalECPrivateKey(key)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		Ω(IsPemData(data)).Should(BeTrue())
+		Ω(ParsePrivateKey(data)).Should(Equal(key))
+	})
+
+	It("parses PKCS #8 private key", func() {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))).Should(Equal(key))
+	})
+
+	It("does not parse password encrypted cosign private key", func() {
+		_, err := ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: encryptedCosignPrivateKeyPemType, Bytes: []byte("data")}))
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("does not parse data encrypted with the werf secret key", func() {
+		Ω(IsPemData([]byte("1000a1b2c3"))).Should(BeFalse())
+	})
+
+	It("parses public key", func() {
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))).Should(Equal(&key.PublicKey))
+	})
+})
+
+var _ = Describe("signing and verification", func() {
+	var server *httptest.Server
+	var registry *registryStandIn
+	var repository, reference, digest string
+	var key *ecdsa.PrivateKey
+
+	BeforeEach(func() {
+		server = newRegistryStandIn()
+		registry = server.Config.Handler.(*registryStandIn)
+		repository = strings.TrimPrefix(server.URL, "http://") + "/group/app"
+		reference = repository + ":v1"
+
+		manifest, err := json.Marshal(map[string]interface{}{
+			"schemaVersion": 2,
+			"mediaType":     "application/vnd.docker.distribution.manifest.v2+json",
+			"config":        map[string]interface{}{"mediaType": "application/vnd.docker.container.image.v1+json", "size": 2, "digest": fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("{}")))},
+			"layers":        []interface{}{},
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		digest = registry.putManifest("group/app", "v1", "application/vnd.docker.distribution.manifest.v2+json", manifest)
+		key = generateKey()
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("stores the signature by the cosign convention", func() {
+		Ω(NewSigner(key).Sign(repository, digest)).Should(BeTrue())
+
+		signatures, err := docker_registry.ImageSignatures(repository, digest)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(signatures).Should(HaveLen(1))
+
+		p, err := parsePayload(signatures[0].Payload)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(p.Critical.Identity.DockerReference).Should(Equal(repository))
+		Ω(p.Critical.Image.DockerManifestDigest).Should(Equal(digest))
+
+		m := registry.manifests["group/app:"+strings.Replace(digest, ":", "-", 1)+".sig"]
+		Ω(m).ShouldNot(BeNil())
+		Ω(m.mediaType).Should(Equal("application/vnd.oci.image.manifest.v1+json"))
+		Ω(string(m.data)).Should(ContainSubstring(`"dev.cosignproject.cosign/signature"`))
+	})
+
+	It("verifies the signed image", func() {
+		Ω(NewSigner(key).Sign(repository, digest)).Should(BeTrue())
+		Ω(NewVerifier([]*ecdsa.PublicKey{&generateKey().PublicKey, &key.PublicKey}).Verify(reference)).Should(Equal(digest))
+	})
+
+	It("does not sign the image with the same key twice", func() {
+		Ω(NewSigner(key).Sign(repository, digest)).Should(BeTrue())
+		Ω(NewSigner(key).Sign(repository, digest)).Should(BeFalse())
+
+		otherKey := generateKey()
+		Ω(NewSigner(otherKey).Sign(repository, digest)).Should(BeTrue())
+
+		Ω(docker_registry.ImageSignatures(repository, digest)).Should(HaveLen(2))
+		Ω(NewVerifier([]*ecdsa.PublicKey{&otherKey.PublicKey}).Verify(reference)).Should(Equal(digest))
+	})
+
+	It("refuses the unsigned image", func() {
+		_, err := NewVerifier([]*ecdsa.PublicKey{&key.PublicKey}).Verify(reference)
+		Ω(err).Should(HaveOccurred())
+		Ω(err.Error()).Should(ContainSubstring("is not signed"))
+	})
+
+	It("refuses the image signed with the other key", func() {
+		Ω(NewSigner(generateKey()).Sign(repository, digest)).Should(BeTrue())
+
+		_, err := NewVerifier([]*ecdsa.PublicKey{&key.PublicKey}).Verify(reference)
+		Ω(err).Should(HaveOccurred())
+		Ω(err.Error()).Should(ContainSubstring("do not match"))
+	})
+
+	DescribeTable("refuses the signature of the other image",
+		func(signedRepository func() string, signedDigest func() string) {
+			payload, err := newPayload(signedRepository(), signedDigest())
+			Ω(err).ShouldNot(HaveOccurred())
+			signature, err := sign(key, payload)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(docker_registry.PushImageSignatures(repository, digest, []*docker_registry.ImageSignature{{Payload: payload, Signature: signature}})).Should(Succeed())
+
+			_, err = NewVerifier([]*ecdsa.PublicKey{&key.PublicKey}).Verify(reference)
+			Ω(err).Should(HaveOccurred())
+			Ω(err.Error()).Should(ContainSubstring("do not match"))
+		},
+		Entry("other digest",
+			func() string { return repository },
+			func() string { return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("other"))) },
+		),
+		Entry("other repository",
+			func() string { return strings.TrimPrefix(server.URL, "http://") + "/group/other" },
+			func() string { return digest },
+		),
+	)
+
+	It("accepts the signature of the same repository specified differently", func() {
+		payload, err := newPayload("index.docker.io/library/alpine", digest)
+		Ω(err).ShouldNot(HaveOccurred())
+		signature, err := sign(key, payload)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(isSignatureValid(&docker_registry.ImageSignature{Payload: payload, Signature: signature}, "alpine", digest, &key.PublicKey)).Should(BeTrue())
+		Ω(isSignatureValid(&docker_registry.ImageSignature{Payload: payload, Signature: signature}, "alpine/alpine", digest, &key.PublicKey)).Should(BeFalse())
+	})
+})
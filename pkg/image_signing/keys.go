@@ -0,0 +1,69 @@
+package image_signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+const encryptedCosignPrivateKeyPemType = "ENCRYPTED COSIGN PRIVATE KEY"
+
+// IsPemData returns true if the data contains PEM block (e.g. the key file is not encrypted with the werf secret key)
+func IsPemData(data []byte) bool {
+	block, _ := pem.Decode(data)
+	return block != nil
+}
+
+// ParsePrivateKey parses ECDSA private key in PEM format: EC PRIVATE KEY (openssl ecparam -genkey) or PRIVATE KEY (PKCS #8)
+func ParsePrivateKey(data []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("PEM block not found")
+	}
+
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		ecdsaKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key %T: only ECDSA keys are supported", key)
+		}
+
+		return ecdsaKey, nil
+	case encryptedCosignPrivateKeyPemType:
+		return nil, fmt.Errorf("password encrypted cosign keys are not supported: use unencrypted key in PEM format, the key file can be encrypted with the werf secret key")
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q: expected EC PRIVATE KEY or PRIVATE KEY", block.Type)
+	}
+}
+
+// ParsePublicKey parses ECDSA public key in PEM format (PUBLIC KEY), e.g. cosign.pub
+func ParsePublicKey(data []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("PEM block not found")
+	}
+
+	if block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("unsupported PEM block %q: expected PUBLIC KEY", block.Type)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	ecdsaKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported public key %T: only ECDSA keys are supported", key)
+	}
+
+	return ecdsaKey, nil
+}
@@ -0,0 +1,87 @@
+package image_signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+const payloadType = "cosign container image signature"
+
+// payload is the cosign simple signing payload of the manifest digest
+type payload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+func newPayload(repository, digest string) ([]byte, error) {
+	p := &payload{}
+	p.Critical.Identity.DockerReference = repository
+	p.Critical.Image.DockerManifestDigest = digest
+	p.Critical.Type = payloadType
+
+	return json.Marshal(p)
+}
+
+func parsePayload(data []byte) (*payload, error) {
+	p := &payload{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+
+	if p.Critical.Type != payloadType {
+		return nil, fmt.Errorf("unsupported payload type %q", p.Critical.Type)
+	}
+
+	return p, nil
+}
+
+// sign returns the base64 encoded ASN.1 ECDSA signature of the payload sha256 digest
+func sign(key *ecdsa.PrivateKey, data []byte) (string, error) {
+	hash := sha256.Sum256(data)
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		return "", err
+	}
+
+	signature, err := asn1.Marshal(ecdsaSignature{R: r, S: s})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+func verify(key *ecdsa.PublicKey, data []byte, signature string) bool {
+	rawSignature, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	sig := ecdsaSignature{}
+	if rest, err := asn1.Unmarshal(rawSignature, &sig); err != nil || len(rest) != 0 || sig.R == nil || sig.S == nil {
+		return false
+	}
+
+	hash := sha256.Sum256(data)
+
+	return ecdsa.Verify(key, hash[:], sig.R, sig.S)
+}
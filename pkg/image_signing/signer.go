@@ -0,0 +1,74 @@
+package image_signing
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+
+	"github.com/flant/go-containerregistry/pkg/name"
+
+	"github.com/flant/werf/pkg/docker_registry"
+)
+
+type Signer struct {
+	key *ecdsa.PrivateKey
+}
+
+func NewSigner(key *ecdsa.PrivateKey) *Signer {
+	return &Signer{key: key}
+}
+
+// Sign adds the signature of the manifest digest to the signatures of the repository (see docker_registry.SignatureTag).
+// The digest already signed with the key is not signed again, false is returned in that case
+func (s *Signer) Sign(repository, digest string) (bool, error) {
+	signatures, err := docker_registry.ImageSignatures(repository, digest)
+	if err != nil {
+		return false, err
+	}
+
+	for _, signature := range signatures {
+		if isSignatureValid(signature, repository, digest, &s.key.PublicKey) {
+			return false, nil
+		}
+	}
+
+	payload, err := newPayload(repository, digest)
+	if err != nil {
+		return false, err
+	}
+
+	signature, err := sign(s.key, payload)
+	if err != nil {
+		return false, fmt.Errorf("signing %s@%s: %s", repository, digest, err)
+	}
+
+	signatures = append(signatures, &docker_registry.ImageSignature{Payload: payload, Signature: signature})
+	if err := docker_registry.PushImageSignatures(repository, digest, signatures); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// isSignatureValid checks the signature of the payload, which identifies the repository and the manifest digest
+func isSignatureValid(signature *docker_registry.ImageSignature, repository, digest string, key *ecdsa.PublicKey) bool {
+	p, err := parsePayload(signature.Payload)
+	if err != nil || p.Critical.Image.DockerManifestDigest != digest {
+		return false
+	}
+
+	if normalizeRepository(p.Critical.Identity.DockerReference) != normalizeRepository(repository) {
+		return false
+	}
+
+	return verify(key, signature.Payload, signature.Signature)
+}
+
+// normalizeRepository returns the full repository name (e.g. index.docker.io/library/alpine for alpine)
+func normalizeRepository(repository string) string {
+	repo, err := name.NewRepository(repository, name.WeakValidation)
+	if err != nil {
+		return repository
+	}
+
+	return repo.Name()
+}
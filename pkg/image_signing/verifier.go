@@ -0,0 +1,53 @@
+package image_signing
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+
+	"github.com/flant/go-containerregistry/pkg/name"
+
+	"github.com/flant/werf/pkg/docker_registry"
+)
+
+type Verifier struct {
+	keys []*ecdsa.PublicKey
+}
+
+func NewVerifier(keys []*ecdsa.PublicKey) *Verifier {
+	return &Verifier{keys: keys}
+}
+
+// Verify checks that the manifest of the reference has the signature of one of the keys issued for the reference repository
+// and returns the verified digest, the digest of the index is checked if the reference is an index (manifest list)
+func (v *Verifier) Verify(reference string) (string, error) {
+	ref, err := name.ParseReference(reference, name.WeakValidation)
+	if err != nil {
+		return "", fmt.Errorf("parsing reference %q: %s", reference, err)
+	}
+
+	repository := ref.Context().Name()
+
+	digest, err := docker_registry.ImageDigest(reference)
+	if err != nil {
+		return "", fmt.Errorf("unable to get image %s digest: %s", reference, err)
+	}
+
+	signatures, err := docker_registry.ImageSignatures(repository, digest)
+	if err != nil {
+		return "", err
+	}
+
+	if len(signatures) == 0 {
+		return "", fmt.Errorf("image %s@%s is not signed", repository, digest)
+	}
+
+	for _, signature := range signatures {
+		for _, key := range v.keys {
+			if isSignatureValid(signature, repository, digest, key) {
+				return digest, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("image %s@%s signatures do not match the specified keys", repository, digest)
+}
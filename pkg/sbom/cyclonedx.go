@@ -0,0 +1,186 @@
+package sbom
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	cycloneDXSpecVersion = "1.4"
+	cycloneDXImageRef    = "image"
+)
+
+type cycloneDXDocument struct {
+	BOMFormat    string                 `json:"bomFormat"`
+	SpecVersion  string                 `json:"specVersion"`
+	SerialNumber string                 `json:"serialNumber"`
+	Version      int                    `json:"version"`
+	Metadata     cycloneDXMetadata      `json:"metadata"`
+	Components   []*cycloneDXComponent  `json:"components"`
+	Dependencies []*cycloneDXDependency `json:"dependencies"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string              `json:"timestamp"`
+	Tools     []*cycloneDXTool    `json:"tools"`
+	Component *cycloneDXComponent `json:"component"`
+}
+
+type cycloneDXTool struct {
+	Vendor  string `json:"vendor"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type cycloneDXComponent struct {
+	Type               string                        `json:"type"`
+	BOMRef             string                        `json:"bom-ref"`
+	Name               string                        `json:"name"`
+	Version            string                        `json:"version,omitempty"`
+	Description        string                        `json:"description,omitempty"`
+	Purl               string                        `json:"purl,omitempty"`
+	Licenses           []*cycloneDXLicenseChoice     `json:"licenses,omitempty"`
+	ExternalReferences []*cycloneDXExternalReference `json:"externalReferences,omitempty"`
+	Properties         []*cycloneDXProperty          `json:"properties,omitempty"`
+}
+
+type cycloneDXLicenseChoice struct {
+	License    *cycloneDXLicense `json:"license,omitempty"`
+	Expression string            `json:"expression,omitempty"`
+}
+
+type cycloneDXLicense struct {
+	Name string `json:"name"`
+}
+
+type cycloneDXExternalReference struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type cycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cycloneDXDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+func marshalCycloneDX(d *Document) ([]byte, error) {
+	image := &cycloneDXComponent{
+		Type:        "container",
+		BOMRef:      cycloneDXImageRef,
+		Name:        d.name(),
+		Version:     d.version(),
+		Description: d.Reference,
+		Purl:        d.imagePurl(),
+	}
+
+	doc := &cycloneDXDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  cycloneDXSpecVersion,
+		SerialNumber: cycloneDXSerialNumber(d),
+		Version:      1,
+		Metadata: cycloneDXMetadata{
+			Timestamp: d.Created.UTC().Format(time.RFC3339),
+			Tools:     []*cycloneDXTool{{Vendor: "Flant", Name: "werf", Version: d.ToolVersion}},
+			Component: image,
+		},
+		Components: []*cycloneDXComponent{},
+	}
+
+	imageDependency := &cycloneDXDependency{Ref: cycloneDXImageRef, DependsOn: []string{}}
+	add := func(component *cycloneDXComponent) {
+		doc.Components = append(doc.Components, component)
+		imageDependency.DependsOn = append(imageDependency.DependsOn, component.BOMRef)
+	}
+
+	if d.OperatingSystem != nil && d.OperatingSystem.ID != "" {
+		add(&cycloneDXComponent{
+			Type:        "operating-system",
+			BOMRef:      "operating-system",
+			Name:        d.OperatingSystem.ID,
+			Version:     d.OperatingSystem.VersionID,
+			Description: d.OperatingSystem.PrettyName,
+		})
+	}
+
+	if d.Provenance.BaseImage != "" {
+		add(&cycloneDXComponent{
+			Type:       "container",
+			BOMRef:     "base-image",
+			Name:       d.Provenance.BaseImage,
+			Version:    d.Provenance.BaseImageID,
+			Properties: []*cycloneDXProperty{{Name: "werf:source", Value: "from"}},
+		})
+	}
+
+	for ind, gm := range d.Provenance.GitMappings {
+		component := &cycloneDXComponent{
+			Type:    "application",
+			BOMRef:  fmt.Sprintf("git-mapping-%d", ind),
+			Name:    gm.Name,
+			Version: gm.Commit,
+			Properties: []*cycloneDXProperty{
+				{Name: "werf:source", Value: "git"},
+				{Name: "werf:git:add", Value: gm.Add},
+				{Name: "werf:git:to", Value: gm.To},
+			},
+		}
+		if gm.URL != "" {
+			component.ExternalReferences = []*cycloneDXExternalReference{{Type: "vcs", URL: gm.URL}}
+		}
+		add(component)
+	}
+
+	for ind, i := range d.Provenance.Imports {
+		add(&cycloneDXComponent{
+			Type:    "container",
+			BOMRef:  fmt.Sprintf("import-%d", ind),
+			Name:    i.ImageName,
+			Version: i.Signature,
+			Properties: []*cycloneDXProperty{
+				{Name: "werf:source", Value: "import"},
+				{Name: "werf:import:" + i.kind(), Value: i.ImageName},
+				{Name: "werf:import:add", Value: i.Add},
+				{Name: "werf:import:to", Value: i.To},
+			},
+		})
+	}
+
+	for ind, p := range d.Packages {
+		component := &cycloneDXComponent{
+			Type:       "library",
+			BOMRef:     fmt.Sprintf("package-%d", ind),
+			Name:       p.Name,
+			Version:    p.Version,
+			Purl:       p.Purl,
+			Properties: []*cycloneDXProperty{{Name: "werf:package:location", Value: p.Location}},
+		}
+		// alpine packages licenses are SPDX license expressions, other package databases licenses are free-form
+		if p.Type == "apk" && p.License != "" {
+			component.Licenses = []*cycloneDXLicenseChoice{{Expression: p.License}}
+		} else if p.License != "" {
+			component.Licenses = []*cycloneDXLicenseChoice{{License: &cycloneDXLicense{Name: p.License}}}
+		}
+		add(component)
+	}
+
+	doc.Dependencies = []*cycloneDXDependency{imageDependency}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// cycloneDXSerialNumber returns the RFC 4122 name-based urn:uuid of the document
+func cycloneDXSerialNumber(d *Document) string {
+	hash := sha256.Sum256([]byte(d.Reference + d.version() + d.Created.String()))
+	uuid := hash[:16]
+	uuid[6] = (uuid[6] & 0x0f) | 0x50
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+}
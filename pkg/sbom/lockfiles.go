@@ -0,0 +1,261 @@
+package sbom
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+var (
+	gemfileLockSpecRegexp = regexp.MustCompile(`^    ([^ ()]+) \(([^)]+)\)$`)
+	requirementsTxtRegexp = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]*)(\[[^\]]*\])?\s*==\s*([^\s;#]+)`)
+	tomlStringFieldRegexp = regexp.MustCompile(`^(name|version)\s*=\s*"([^"]*)"`)
+	yarnLockVersionRegexp = regexp.MustCompile(`^\s+version:?\s+"?([^"\s]+)"?`)
+)
+
+// parsePackageLockJson parses npm package-lock.json of the lockfileVersion 1 (dependencies) and 2, 3 (packages)
+func parsePackageLockJson(filePath string, data []byte) ([]*Package, error) {
+	type dependency struct {
+		Version      string                 `json:"version"`
+		Dependencies map[string]*dependency `json:"dependencies"`
+	}
+
+	lock := struct {
+		Packages map[string]struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+			Link    bool   `json:"link"`
+		} `json:"packages"`
+		Dependencies map[string]*dependency `json:"dependencies"`
+	}{}
+
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, err
+	}
+
+	packages := newPackagesSet("npm", filePath)
+
+	if len(lock.Packages) != 0 {
+		for key, p := range lock.Packages {
+			// the root package is the application itself
+			if key == "" || p.Link {
+				continue
+			}
+
+			name := p.Name
+			if ind := strings.LastIndex(key, "node_modules/"); name == "" && ind >= 0 {
+				name = key[ind+len("node_modules/"):]
+			}
+
+			packages.add(name, p.Version)
+		}
+
+		return packages.list(), nil
+	}
+
+	var addDependencies func(dependencies map[string]*dependency)
+	addDependencies = func(dependencies map[string]*dependency) {
+		for name, d := range dependencies {
+			packages.add(name, d.Version)
+			addDependencies(d.Dependencies)
+		}
+	}
+	addDependencies(lock.Dependencies)
+
+	return packages.list(), nil
+}
+
+// parseYarnLock parses the yarn v1 and berry lockfiles: the entry descriptors line followed by the indented fields
+func parseYarnLock(filePath string, data []byte) ([]*Package, error) {
+	packages := newPackagesSet("npm", filePath)
+
+	var name string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if !strings.HasPrefix(line, " ") {
+			name = ""
+			if strings.HasPrefix(line, "__metadata") {
+				continue
+			}
+
+			descriptor := strings.Trim(strings.SplitN(strings.TrimSuffix(line, ":"), ",", 2)[0], `" `)
+			if ind := strings.LastIndex(descriptor, "@"); ind > 0 {
+				name = descriptor[:ind]
+			}
+			continue
+		}
+
+		if m := yarnLockVersionRegexp.FindStringSubmatch(line); name != "" && m != nil {
+			packages.add(name, m[1])
+			name = ""
+		}
+	}
+
+	return packages.list(), nil
+}
+
+// parseGemfileLock parses the specs of the GEM section
+func parseGemfileLock(filePath string, data []byte) ([]*Package, error) {
+	packages := newPackagesSet("gem", filePath)
+
+	var isGemSection bool
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line != "" && !strings.HasPrefix(line, " ") {
+			isGemSection = line == "GEM"
+			continue
+		}
+
+		if m := gemfileLockSpecRegexp.FindStringSubmatch(line); isGemSection && m != nil {
+			packages.add(m[1], m[2])
+		}
+	}
+
+	return packages.list(), nil
+}
+
+func parseComposerLock(filePath string, data []byte) ([]*Package, error) {
+	type composerPackage struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+
+	lock := struct {
+		Packages    []composerPackage `json:"packages"`
+		PackagesDev []composerPackage `json:"packages-dev"`
+	}{}
+
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, err
+	}
+
+	packages := newPackagesSet("composer", filePath)
+	for _, p := range append(lock.Packages, lock.PackagesDev...) {
+		packages.add(p.Name, p.Version)
+	}
+
+	return packages.list(), nil
+}
+
+func parseCargoLock(filePath string, data []byte) ([]*Package, error) {
+	return parseTomlPackagesArray("cargo", filePath, data), nil
+}
+
+func parsePoetryLock(filePath string, data []byte) ([]*Package, error) {
+	return parseTomlPackagesArray("pypi", filePath, data), nil
+}
+
+// parseTomlPackagesArray parses name and version of the [[package]] tables
+func parseTomlPackagesArray(packageType, filePath string, data []byte) []*Package {
+	packages := newPackagesSet(packageType, filePath)
+
+	var isPackageTable bool
+	var name, version string
+	flush := func() {
+		if isPackageTable {
+			packages.add(name, version)
+		}
+		name, version = "", ""
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			flush()
+			isPackageTable = line == "[[package]]"
+			continue
+		}
+
+		if m := tomlStringFieldRegexp.FindStringSubmatch(line); m != nil {
+			if m[1] == "name" {
+				name = m[2]
+			} else {
+				version = m[2]
+			}
+		}
+	}
+	flush()
+
+	return packages.list()
+}
+
+// parseGoSum parses the modules checksums, the go.mod only checksums of the not downloaded modules are skipped
+func parseGoSum(filePath string, data []byte) ([]*Package, error) {
+	packages := newPackagesSet("golang", filePath)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || strings.HasSuffix(fields[1], "/go.mod") {
+			continue
+		}
+
+		packages.add(fields[0], fields[1])
+	}
+
+	return packages.list(), nil
+}
+
+func parsePipfileLock(filePath string, data []byte) ([]*Package, error) {
+	type pipfilePackage struct {
+		Version string `json:"version"`
+	}
+
+	lock := struct {
+		Default map[string]pipfilePackage `json:"default"`
+		Develop map[string]pipfilePackage `json:"develop"`
+	}{}
+
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, err
+	}
+
+	packages := newPackagesSet("pypi", filePath)
+	for _, section := range []map[string]pipfilePackage{lock.Default, lock.Develop} {
+		for name, p := range section {
+			packages.add(name, strings.TrimPrefix(p.Version, "=="))
+		}
+	}
+
+	return packages.list(), nil
+}
+
+// parseRequirementsTxt parses the pinned requirements (name==version), other requirements are not locked
+func parseRequirementsTxt(filePath string, data []byte) ([]*Package, error) {
+	packages := newPackagesSet("pypi", filePath)
+	for _, line := range strings.Split(string(data), "\n") {
+		if m := requirementsTxtRegexp.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			packages.add(m[1], m[3])
+		}
+	}
+
+	return packages.list(), nil
+}
+
+// packagesSet collects the unique packages of the lockfile in order of appearance
+type packagesSet struct {
+	packageType string
+	location    string
+	packages    []*Package
+	keys        map[string]bool
+}
+
+func newPackagesSet(packageType, location string) *packagesSet {
+	return &packagesSet{packageType: packageType, location: location, keys: map[string]bool{}}
+}
+
+func (s *packagesSet) add(name, version string) {
+	key := name + "@" + version
+	if name == "" || s.keys[key] {
+		return
+	}
+
+	s.keys[key] = true
+	s.packages = append(s.packages, &Package{Name: name, Version: version, Type: s.packageType, Location: s.location})
+}
+
+func (s *packagesSet) list() []*Package {
+	return s.packages
+}
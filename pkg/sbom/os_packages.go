@@ -0,0 +1,185 @@
+package sbom
+
+import (
+	"path"
+	"strings"
+)
+
+// RpmQueryCommand prints the installed rpm packages in the format of parseRpmQueryOutput
+var RpmQueryCommand = []string{"rpm", "--query", "--all", "--queryformat", `%{NAME}\t%{EPOCH}\t%{VERSION}-%{RELEASE}\t%{ARCH}\t%{LICENSE}\n`}
+
+// isDpkgStatusDFile checks the per package status files of the distroless images
+func isDpkgStatusDFile(filePath string) bool {
+	return path.Dir(filePath) == "/var/lib/dpkg/status.d" && !strings.HasSuffix(filePath, ".md5sums")
+}
+
+// parseDpkgStatus parses the debian control paragraphs of the installed packages
+func parseDpkgStatus(filePath string, data []byte) ([]*Package, error) {
+	var packages []*Package
+	for _, paragraph := range parseControlParagraphs(data) {
+		if paragraph["Package"] == "" {
+			continue
+		}
+
+		// the status is omitted in the status.d files
+		if status := paragraph["Status"]; status != "" && !strings.HasSuffix(status, " installed") {
+			continue
+		}
+
+		packages = append(packages, &Package{
+			Name:     paragraph["Package"],
+			Version:  paragraph["Version"],
+			Type:     "deb",
+			Location: filePath,
+			arch:     paragraph["Architecture"],
+		})
+	}
+
+	return packages, nil
+}
+
+func parseControlParagraphs(data []byte) []map[string]string {
+	var paragraphs []map[string]string
+
+	paragraph := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		if strings.TrimSpace(line) == "" {
+			if len(paragraph) != 0 {
+				paragraphs = append(paragraphs, paragraph)
+				paragraph = map[string]string{}
+			}
+			continue
+		}
+
+		// multiline field values are not used
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		paragraph[parts[0]] = strings.TrimSpace(parts[1])
+	}
+
+	if len(paragraph) != 0 {
+		paragraphs = append(paragraphs, paragraph)
+	}
+
+	return paragraphs
+}
+
+// parseApkInstalled parses the alpine package database: blocks of the single letter fields
+func parseApkInstalled(filePath string, data []byte) ([]*Package, error) {
+	var packages []*Package
+
+	var p *Package
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			p = nil
+			continue
+		}
+
+		if len(line) < 2 || line[1] != ':' {
+			continue
+		}
+
+		if p == nil {
+			p = &Package{Type: "apk", Location: filePath}
+			packages = append(packages, p)
+		}
+
+		value := strings.TrimSpace(line[2:])
+		switch line[0] {
+		case 'P':
+			p.Name = value
+		case 'V':
+			p.Version = value
+		case 'A':
+			p.arch = value
+		case 'L':
+			p.License = value
+		}
+	}
+
+	return filterNamedPackages(packages), nil
+}
+
+func parseRpmQueryOutput(output []byte) []*Package {
+	var packages []*Package
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "\t")
+		if len(fields) != 5 || fields[0] == "gpg-pubkey" {
+			continue
+		}
+
+		version := fields[2]
+		if epoch := fields[1]; epoch != "" && epoch != "(none)" {
+			version = epoch + ":" + version
+		}
+
+		p := &Package{Name: fields[0], Version: version, Type: "rpm", Location: "/var/lib/rpm", arch: fields[3]}
+		if fields[4] != "(none)" {
+			p.License = fields[4]
+		}
+
+		packages = append(packages, p)
+	}
+
+	return packages
+}
+
+func filterNamedPackages(packages []*Package) []*Package {
+	var result []*Package
+	for _, p := range packages {
+		if p.Name != "" {
+			result = append(result, p)
+		}
+	}
+
+	return result
+}
+
+func packagePurl(p *Package, osID, osVersionID string) string {
+	var distro string
+	if osID != "" && osVersionID != "" {
+		distro = osID + "-" + osVersionID
+	}
+
+	switch p.Type {
+	case "deb":
+		return purl(p.Type, defaultString(osID, "debian"), p.Name, p.Version, map[string]string{"arch": p.arch, "distro": distro})
+	case "apk":
+		return purl(p.Type, defaultString(osID, "alpine"), p.Name, p.Version, map[string]string{"arch": p.arch, "distro": distro})
+	case "rpm":
+		return purl(p.Type, osID, p.Name, p.Version, map[string]string{"arch": p.arch, "distro": distro})
+	case "npm", "composer", "golang":
+		namespace, name := splitPackageNamespace(p.Name)
+		return purl(p.Type, namespace, name, p.Version, nil)
+	case "pypi":
+		return purl(p.Type, "", strings.Replace(strings.ToLower(p.Name), "_", "-", -1), p.Version, nil)
+	default:
+		return purl(p.Type, "", p.Name, p.Version, nil)
+	}
+}
+
+// splitPackageNamespace splits @scope/name, vendor/name and module/path/name
+func splitPackageNamespace(name string) (string, string) {
+	if ind := strings.LastIndex(name, "/"); ind > 0 {
+		return name[:ind], name[ind+1:]
+	}
+
+	return "", name
+}
+
+func defaultString(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+
+	return value
+}
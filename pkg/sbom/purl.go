@@ -0,0 +1,48 @@
+package sbom
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// purl returns the package URL (https://github.com/package-url/purl-spec)
+func purl(purlType, namespace, name, version string, qualifiers map[string]string) string {
+	result := "pkg:" + purlType + "/"
+	if namespace != "" {
+		var segments []string
+		for _, segment := range strings.Split(namespace, "/") {
+			segments = append(segments, purlEscape(segment))
+		}
+		result += strings.Join(segments, "/") + "/"
+	}
+
+	result += purlEscape(name)
+	if version != "" {
+		result += "@" + purlEscape(version)
+	}
+
+	var keys []string
+	for key, value := range qualifiers {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		// slashes of repository_url are not encoded
+		pairs = append(pairs, key+"="+strings.Replace(purlEscape(qualifiers[key]), "%2F", "/", -1))
+	}
+
+	if len(pairs) != 0 {
+		result += "?" + strings.Join(pairs, "&")
+	}
+
+	return result
+}
+
+func purlEscape(value string) string {
+	return strings.Replace(url.PathEscape(value), "@", "%40", -1)
+}
@@ -0,0 +1,150 @@
+package sbom
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Format string
+
+const (
+	SPDX      Format = "spdx"
+	CycloneDX Format = "cyclonedx"
+)
+
+func ParseFormat(value string) (Format, error) {
+	switch Format(value) {
+	case SPDX, CycloneDX:
+		return Format(value), nil
+	default:
+		return "", fmt.Errorf("unsupported SBOM format %q: expected %s or %s", value, SPDX, CycloneDX)
+	}
+}
+
+// MediaType is the media type of the SBOM document attached to the image in the registry
+func (f Format) MediaType() string {
+	switch f {
+	case CycloneDX:
+		return "application/vnd.cyclonedx+json"
+	default:
+		return "application/spdx+json"
+	}
+}
+
+// Document is the software bill of materials of the image: werf provenance data and the package inventory of the image filesystem
+type Document struct {
+	// ImageName is the werf image name from werf.yaml, empty for nameless image
+	ImageName string
+	// Reference is the stage image name or the published image name
+	Reference string
+	ImageID   string
+	// Digest is the manifest digest of the published image
+	Digest string
+
+	Created     time.Time
+	ToolVersion string
+
+	OperatingSystem *OperatingSystem
+	Provenance      Provenance
+	Packages        []*Package
+}
+
+type OperatingSystem struct {
+	ID         string
+	VersionID  string
+	PrettyName string
+}
+
+// Provenance is what werf knows about the image sources at build time
+type Provenance struct {
+	// BaseImage is the from image (or the image name of fromImage and fromImageArtifact directives)
+	BaseImage   string
+	BaseImageID string
+	GitMappings []*GitMappingSource
+	Imports     []*ImportSource
+}
+
+type GitMappingSource struct {
+	Name   string
+	URL    string
+	Commit string
+	Add    string
+	To     string
+}
+
+type ImportSource struct {
+	ImageName string
+	// IsArtifact is set when the files are imported from the artifact
+	IsArtifact bool
+	// Signature is the signature of the last stage of the imported image or artifact
+	Signature string
+	Add       string
+	To        string
+}
+
+type Package struct {
+	Name    string
+	Version string
+	// Type is the purl type of the package: deb, apk, rpm, npm, gem, composer, cargo, golang or pypi
+	Type    string
+	Purl    string
+	License string
+	// Location is the database or the lockfile path in the image filesystem
+	Location string
+
+	arch string
+}
+
+func (d *Document) name() string {
+	if d.ImageName == "" {
+		return "werf-image"
+	}
+
+	return d.ImageName
+}
+
+// version is the digest of the published image or the id of the stage image
+func (d *Document) version() string {
+	if d.Digest != "" {
+		return d.Digest
+	}
+
+	return d.ImageID
+}
+
+// imagePurl returns the package URL of the published image
+func (d *Document) imagePurl() string {
+	if d.Digest == "" {
+		return ""
+	}
+
+	repository := d.Reference
+	if ind := strings.LastIndex(repository, ":"); ind > strings.LastIndex(repository, "/") {
+		repository = repository[:ind]
+	}
+
+	_, name := splitPackageNamespace(repository)
+
+	return purl("oci", "", name, d.Digest, map[string]string{"repository_url": repository})
+}
+
+func (i *ImportSource) kind() string {
+	if i.IsArtifact {
+		return "artifact"
+	}
+
+	return "image"
+}
+
+// Marshal returns the JSON document in the specified format
+func Marshal(d *Document, format Format) ([]byte, error) {
+	switch format {
+	case SPDX:
+		return marshalSPDX(d)
+	case CycloneDX:
+		return marshalCycloneDX(d)
+	default:
+		return nil, fmt.Errorf("unsupported SBOM format %q", format)
+	}
+}
@@ -0,0 +1,266 @@
+package sbom
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+type packageEntry struct {
+	Name, Version, Purl string
+}
+
+func newFilesystem(files map[string]string, dirs ...string) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+
+	for _, dir := range dirs {
+		Ω(tw.WriteHeader(&tar.Header{Name: dir + "/", Typeflag: tar.TypeDir, Mode: 0755})).Should(Succeed())
+	}
+
+	for name, content := range files {
+		Ω(tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))})).Should(Succeed())
+		_, err := tw.Write([]byte(content))
+		Ω(err).ShouldNot(HaveOccurred())
+	}
+
+	Ω(tw.Close()).Should(Succeed())
+
+	return buf
+}
+
+func packageEntries(packages []*Package) []packageEntry {
+	var entries []packageEntry
+	for _, p := range packages {
+		entries = append(entries, packageEntry{Name: p.Name, Version: p.Version, Purl: p.Purl})
+	}
+
+	return entries
+}
+
+const debianOsRelease = `PRETTY_NAME="Debian GNU/Linux 10 (buster)"
+ID=debian
+VERSION_ID="10"
+`
+
+const dpkgStatus = `Package: libc6
+Status: install ok installed
+Architecture: amd64
+Version: 2.28-10
+Description: GNU C Library
+ multiline description
+
+Package: removed
+Status: deinstall ok config-files
+Version: 1.0
+
+Package: tzdata
+Status: install ok installed
+Architecture: all
+Version: 2020a-0+deb10u1
+`
+
+const apkInstalled = `C:Q1abc=
+P:musl
+V:1.1.24-r2
+A:x86_64
+L:MIT
+
+P:busybox
+V:1.31.1-r9
+A:x86_64
+L:GPL-2.0-only
+`
+
+var _ = Describe("ScanFilesystem", func() {
+	It("scans dpkg status and os-release", func() {
+		result, err := ScanFilesystem(newFilesystem(map[string]string{
+			"etc/os-release":       debianOsRelease,
+			"var/lib/dpkg/status":  dpkgStatus,
+			"usr/share/doc/README": "not scanned",
+		}))
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(result.OperatingSystem).Should(Equal(&OperatingSystem{ID: "debian", VersionID: "10", PrettyName: "Debian GNU/Linux 10 (buster)"}))
+		Ω(result.HasRpmDatabase).Should(BeFalse())
+		Ω(packageEntries(result.Packages)).Should(Equal([]packageEntry{
+			{Name: "libc6", Version: "2.28-10", Purl: "pkg:deb/debian/libc6@2.28-10?arch=amd64&distro=debian-10"},
+			{Name: "tzdata", Version: "2020a-0+deb10u1", Purl: "pkg:deb/debian/tzdata@2020a-0+deb10u1?arch=all&distro=debian-10"},
+		}))
+		Ω(result.Packages[0].Location).Should(Equal("/var/lib/dpkg/status"))
+	})
+
+	It("scans distroless dpkg status.d", func() {
+		result, err := ScanFilesystem(newFilesystem(map[string]string{
+			"var/lib/dpkg/status.d/base":         "Package: base-files\nVersion: 10.3\nArchitecture: amd64\n",
+			"var/lib/dpkg/status.d/base.md5sums": "d41d8cd98f00b204e9800998ecf8427e  etc/debian_version\n",
+		}))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(packageEntries(result.Packages)).Should(Equal([]packageEntry{
+			{Name: "base-files", Version: "10.3", Purl: "pkg:deb/debian/base-files@10.3?arch=amd64"},
+		}))
+	})
+
+	It("scans apk database", func() {
+		result, err := ScanFilesystem(newFilesystem(map[string]string{
+			"etc/os-release":            "ID=alpine\nVERSION_ID=3.11.6\n",
+			"lib/apk/db/installed":      apkInstalled,
+			"app/node_modules/x/go.sum": "github.com/skipped/module v1.0.0 h1:abc=\n",
+		}))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(packageEntries(result.Packages)).Should(Equal([]packageEntry{
+			{Name: "busybox", Version: "1.31.1-r9", Purl: "pkg:apk/alpine/busybox@1.31.1-r9?arch=x86_64&distro=alpine-3.11.6"},
+			{Name: "musl", Version: "1.1.24-r2", Purl: "pkg:apk/alpine/musl@1.1.24-r2?arch=x86_64&distro=alpine-3.11.6"},
+		}))
+		Ω(result.Packages[1].License).Should(Equal("MIT"))
+	})
+
+	It("detects rpm database and adds rpm query output", func() {
+		result, err := ScanFilesystem(newFilesystem(map[string]string{
+			"etc/os-release": "ID=\"centos\"\nVERSION_ID=\"8\"\n",
+		}, "var", "var/lib", "var/lib/rpm"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(result.HasRpmDatabase).Should(BeTrue())
+
+		result.AddRpmQueryOutput([]byte("bash\t(none)\t4.4.19-10.el8\tx86_64\tGPLv3+\ngpg-pubkey\t(none)\t8483c65d-5ccc5b19\t(none)\tpubkey\nopenssl-libs\t1\t1.1.1c-15.el8\tx86_64\tOpenSSL\n"))
+		Ω(packageEntries(result.Packages)).Should(Equal([]packageEntry{
+			{Name: "bash", Version: "4.4.19-10.el8", Purl: "pkg:rpm/centos/bash@4.4.19-10.el8?arch=x86_64&distro=centos-8"},
+			{Name: "openssl-libs", Version: "1:1.1.1c-15.el8", Purl: "pkg:rpm/centos/openssl-libs@1:1.1.1c-15.el8?arch=x86_64&distro=centos-8"},
+		}))
+	})
+})
+
+var _ = DescribeTable("lockfiles",
+	func(filePath, content string, expected []packageEntry) {
+		result, err := ScanFilesystem(newFilesystem(map[string]string{filePath: content}))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(packageEntries(result.Packages)).Should(Equal(expected))
+	},
+	Entry("package-lock.json v1", "app/package-lock.json",
+		`{"lockfileVersion": 1, "dependencies": {"@babel/core": {"version": "7.9.0", "dependencies": {"semver": {"version": "5.7.1"}}}, "semver": {"version": "7.3.2"}}}`,
+		[]packageEntry{
+			{Name: "@babel/core", Version: "7.9.0", Purl: "pkg:npm/%40babel/core@7.9.0"},
+			{Name: "semver", Version: "5.7.1", Purl: "pkg:npm/semver@5.7.1"},
+			{Name: "semver", Version: "7.3.2", Purl: "pkg:npm/semver@7.3.2"},
+		}),
+	Entry("package-lock.json v2", "app/package-lock.json",
+		`{"lockfileVersion": 2, "packages": {"": {"name": "app", "version": "1.0.0"}, "node_modules/lodash": {"version": "4.17.15"}, "node_modules/a/node_modules/@scope/b": {"version": "1.0.0"}, "node_modules/local": {"link": true}}}`,
+		[]packageEntry{
+			{Name: "@scope/b", Version: "1.0.0", Purl: "pkg:npm/%40scope/b@1.0.0"},
+			{Name: "lodash", Version: "4.17.15", Purl: "pkg:npm/lodash@4.17.15"},
+		}),
+	Entry("yarn.lock v1", "app/yarn.lock",
+		"# yarn lockfile v1\n\n\"@babel/code-frame@^7.0.0\", \"@babel/code-frame@^7.8.3\":\n  version \"7.8.3\"\n  resolved \"https://registry.yarnpkg.com/@babel/code-frame/-/code-frame-7.8.3.tgz\"\n\nlodash@^4.17.15:\n  version \"4.17.15\"\n",
+		[]packageEntry{
+			{Name: "@babel/code-frame", Version: "7.8.3", Purl: "pkg:npm/%40babel/code-frame@7.8.3"},
+			{Name: "lodash", Version: "4.17.15", Purl: "pkg:npm/lodash@4.17.15"},
+		}),
+	Entry("yarn.lock berry", "app/yarn.lock",
+		"__metadata:\n  version: 4\n\n\"lodash@npm:^4.17.15\":\n  version: 4.17.21\n  resolution: \"lodash@npm:4.17.21\"\n",
+		[]packageEntry{
+			{Name: "lodash", Version: "4.17.21", Purl: "pkg:npm/lodash@4.17.21"},
+		}),
+	Entry("Gemfile.lock", "app/Gemfile.lock",
+		"GEM\n  remote: https://rubygems.org/\n  specs:\n    rack (2.2.2)\n    rack-test (1.1.0)\n      rack (>= 1.0, < 3)\n\nPLATFORMS\n  ruby\n\nDEPENDENCIES\n  rack-test\n",
+		[]packageEntry{
+			{Name: "rack", Version: "2.2.2", Purl: "pkg:gem/rack@2.2.2"},
+			{Name: "rack-test", Version: "1.1.0", Purl: "pkg:gem/rack-test@1.1.0"},
+		}),
+	Entry("composer.lock", "app/composer.lock",
+		`{"packages": [{"name": "monolog/monolog", "version": "2.0.2"}], "packages-dev": [{"name": "phpunit/phpunit", "version": "9.1.1"}]}`,
+		[]packageEntry{
+			{Name: "monolog/monolog", Version: "2.0.2", Purl: "pkg:composer/monolog/monolog@2.0.2"},
+			{Name: "phpunit/phpunit", Version: "9.1.1", Purl: "pkg:composer/phpunit/phpunit@9.1.1"},
+		}),
+	Entry("Cargo.lock", "app/Cargo.lock",
+		"[[package]]\nname = \"libc\"\nversion = \"0.2.69\"\nsource = \"registry+https://github.com/rust-lang/crates.io-index\"\n\n[metadata]\nname = \"not-a-package\"\n",
+		[]packageEntry{
+			{Name: "libc", Version: "0.2.69", Purl: "pkg:cargo/libc@0.2.69"},
+		}),
+	Entry("go.sum", "app/go.sum",
+		"github.com/pkg/errors v0.9.1 h1:FEBLx1zS214owpjy7qsBeixbURkuhQAwrK5UwLGTwt4=\ngithub.com/pkg/errors v0.9.1/go.mod h1:bwawxfHBFNV+L2hUp1rHADufV3IMtnDRdf1r5NINEl0=\ngolang.org/x/sys v0.0.0-20200323222414-85ca7c5b95cd/go.mod h1:h1NjWce9XRLGQEsW7wpKNCjG9DtNlClVuFLEZdDNbEs=\n",
+		[]packageEntry{
+			{Name: "github.com/pkg/errors", Version: "v0.9.1", Purl: "pkg:golang/github.com/pkg/errors@v0.9.1"},
+		}),
+	Entry("Pipfile.lock", "app/Pipfile.lock",
+		`{"default": {"flask": {"version": "==1.1.2"}}, "develop": {"pytest": {"version": "==5.4.1"}}}`,
+		[]packageEntry{
+			{Name: "flask", Version: "1.1.2", Purl: "pkg:pypi/flask@1.1.2"},
+			{Name: "pytest", Version: "5.4.1", Purl: "pkg:pypi/pytest@5.4.1"},
+		}),
+	Entry("poetry.lock", "app/poetry.lock",
+		"[[package]]\nname = \"Jinja2\"\nversion = \"2.11.2\"\n\n[package.dependencies]\nMarkupSafe = \">=0.23\"\n",
+		[]packageEntry{
+			{Name: "Jinja2", Version: "2.11.2", Purl: "pkg:pypi/jinja2@2.11.2"},
+		}),
+	Entry("requirements.txt", "app/requirements.txt",
+		"# comment\nDjango==3.0.5\nrequests[security] == 2.23.0 ; python_version > '3'\nsix>=1.0\n",
+		[]packageEntry{
+			{Name: "Django", Version: "3.0.5", Purl: "pkg:pypi/django@3.0.5"},
+			{Name: "requests", Version: "2.23.0", Purl: "pkg:pypi/requests@2.23.0"},
+		}),
+)
+
+var _ = Describe("Marshal", func() {
+	var document *Document
+
+	BeforeEach(func() {
+		document = &Document{
+			ImageName:       "backend",
+			Reference:       "registry.example.com/group/backend:v1",
+			ImageID:         "sha256:1111",
+			Digest:          "sha256:2222",
+			Created:         time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC),
+			ToolVersion:     "v1.1.0",
+			OperatingSystem: &OperatingSystem{ID: "alpine", VersionID: "3.11.6"},
+			Provenance: Provenance{
+				BaseImage:   "alpine:3.11",
+				GitMappings: []*GitMappingSource{{Name: "own", URL: "https://github.com/example/backend.git", Commit: "abcdef", Add: "/", To: "/app"}},
+				Imports:     []*ImportSource{{ImageName: "assets", IsArtifact: true, Signature: "3333", Add: "/dist", To: "/app/public"}},
+			},
+			Packages: []*Package{{Name: "musl", Version: "1.1.24-r2", Type: "apk", Purl: "pkg:apk/alpine/musl@1.1.24-r2", License: "MIT", Location: "/lib/apk/db/installed"}},
+		}
+	})
+
+	It("marshals SPDX document", func() {
+		data, err := Marshal(document, SPDX)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		doc := &spdxDocument{}
+		Ω(json.Unmarshal(data, doc)).Should(Succeed())
+		Ω(doc.SPDXVersion).Should(Equal("SPDX-2.2"))
+		Ω(doc.CreationInfo.Creators).Should(Equal([]string{"Tool: werf-v1.1.0"}))
+		Ω(doc.Packages).Should(HaveLen(6))
+		Ω(doc.Packages[0].VersionInfo).Should(Equal("sha256:2222"))
+		Ω(doc.Packages[0].ExternalRefs[0].ReferenceLocator).Should(Equal("pkg:oci/backend@sha256:2222?repository_url=registry.example.com/group/backend"))
+		Ω(doc.Packages[3].DownloadLocation).Should(Equal("git+https://github.com/example/backend.git@abcdef"))
+		Ω(doc.Packages[4].SourceInfo).Should(Equal("artifact import /dist to /app/public"))
+		Ω(doc.Packages[5].LicenseDeclared).Should(Equal("MIT"))
+		Ω(doc.Relationships).Should(ContainElement(&spdxRelationship{SPDXElementID: "SPDXRef-Image", RelationshipType: "DESCENDANT_OF", RelatedSPDXElement: "SPDXRef-BaseImage"}))
+	})
+
+	It("marshals CycloneDX document", func() {
+		data, err := Marshal(document, CycloneDX)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		doc := &cycloneDXDocument{}
+		Ω(json.Unmarshal(data, doc)).Should(Succeed())
+		Ω(doc.BOMFormat).Should(Equal("CycloneDX"))
+		Ω(doc.SerialNumber).Should(MatchRegexp(`^urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`))
+		Ω(doc.Metadata.Component.Purl).Should(Equal("pkg:oci/backend@sha256:2222?repository_url=registry.example.com/group/backend"))
+		Ω(doc.Components).Should(HaveLen(5))
+		Ω(doc.Components[2].ExternalReferences[0].URL).Should(Equal("https://github.com/example/backend.git"))
+		Ω(doc.Components[4].Licenses[0].Expression).Should(Equal("MIT"))
+		Ω(doc.Dependencies[0].DependsOn).Should(Equal([]string{"operating-system", "base-image", "git-mapping-0", "import-0", "package-0"}))
+	})
+
+	It("returns the error for unsupported format", func() {
+		_, err := ParseFormat("syft")
+		Ω(err).Should(HaveOccurred())
+	})
+})
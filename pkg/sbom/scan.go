@@ -0,0 +1,182 @@
+package sbom
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+)
+
+// maxScannedFileSize limits the size of the package databases and lockfiles read into memory
+const maxScannedFileSize = 64 * 1024 * 1024
+
+// RpmDatabaseDirs are the rpm database locations, rpm packages cannot be read from the database files directly
+// and should be queried with RpmQueryCommand inside the image container
+var RpmDatabaseDirs = []string{"/var/lib/rpm", "/usr/lib/sysimage/rpm"}
+
+// ScanResult is the package inventory of the image filesystem
+type ScanResult struct {
+	OperatingSystem *OperatingSystem
+	Packages        []*Package
+	HasRpmDatabase  bool
+}
+
+type fileParser func(filePath string, data []byte) ([]*Package, error)
+
+// osPackagesParsers parse the package databases by the absolute path
+var osPackagesParsers = map[string]fileParser{
+	"/var/lib/dpkg/status":  parseDpkgStatus,
+	"/lib/apk/db/installed": parseApkInstalled,
+}
+
+// lockfilesParsers parse the language lockfiles by the base name
+var lockfilesParsers = map[string]fileParser{
+	"package-lock.json":   parsePackageLockJson,
+	"npm-shrinkwrap.json": parsePackageLockJson,
+	"yarn.lock":           parseYarnLock,
+	"Gemfile.lock":        parseGemfileLock,
+	"composer.lock":       parseComposerLock,
+	"Cargo.lock":          parseCargoLock,
+	"go.sum":              parseGoSum,
+	"Pipfile.lock":        parsePipfileLock,
+	"poetry.lock":         parsePoetryLock,
+	"requirements.txt":    parseRequirementsTxt,
+}
+
+// ScanFilesystem reads the image filesystem tar stream (docker export) and returns the packages
+// of the os package databases (dpkg, apk) and the language lockfiles
+func ScanFilesystem(r io.Reader) (*ScanResult, error) {
+	result := &ScanResult{}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to read image filesystem: %s", err)
+		}
+
+		filePath := path.Clean("/" + header.Name)
+
+		if header.Typeflag == tar.TypeDir {
+			for _, dir := range RpmDatabaseDirs {
+				if filePath == dir {
+					result.HasRpmDatabase = true
+				}
+			}
+			continue
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		parser := getFileParser(filePath)
+		if parser == nil && filePath != "/etc/os-release" && filePath != "/usr/lib/os-release" {
+			continue
+		}
+
+		if header.Size > maxScannedFileSize {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %s", filePath, err)
+		}
+
+		if parser == nil {
+			// /etc/os-release takes precedence over /usr/lib/os-release
+			if result.OperatingSystem == nil || filePath == "/etc/os-release" {
+				result.OperatingSystem = parseOsRelease(data)
+			}
+			continue
+		}
+
+		packages, err := parser(filePath, data)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %s", filePath, err)
+		}
+
+		result.Packages = append(result.Packages, packages...)
+	}
+
+	result.SetPurls()
+
+	return result, nil
+}
+
+// AddRpmQueryOutput adds the rpm packages from the RpmQueryCommand output
+func (r *ScanResult) AddRpmQueryOutput(output []byte) {
+	r.Packages = append(r.Packages, parseRpmQueryOutput(output)...)
+	r.SetPurls()
+}
+
+// SetPurls sets the package URLs, the os packages are namespaced by the operating system id
+func (r *ScanResult) SetPurls() {
+	var osID, osVersionID string
+	if r.OperatingSystem != nil {
+		osID, osVersionID = r.OperatingSystem.ID, r.OperatingSystem.VersionID
+	}
+
+	for _, p := range r.Packages {
+		p.Purl = packagePurl(p, osID, osVersionID)
+	}
+
+	sort.SliceStable(r.Packages, func(i, j int) bool {
+		a, b := r.Packages[i], r.Packages[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		} else if a.Name != b.Name {
+			return a.Name < b.Name
+		} else if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		return a.Location < b.Location
+	})
+}
+
+func getFileParser(filePath string) fileParser {
+	if isDpkgStatusDFile(filePath) {
+		return parseDpkgStatus
+	}
+
+	if parser := osPackagesParsers[filePath]; parser != nil {
+		return parser
+	}
+
+	// dependencies of the installed packages are not the lockfiles of the application
+	for _, dir := range []string{"/node_modules/", "/vendor/", "/proc/", "/sys/"} {
+		if strings.Contains(filePath, dir) {
+			return nil
+		}
+	}
+
+	return lockfilesParsers[path.Base(filePath)]
+}
+
+func parseOsRelease(data []byte) *OperatingSystem {
+	os := &OperatingSystem{}
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		value := strings.Trim(parts[1], `"'`)
+		switch parts[0] {
+		case "ID":
+			os.ID = value
+		case "VERSION_ID":
+			os.VersionID = value
+		case "PRETTY_NAME":
+			os.PrettyName = value
+		}
+	}
+
+	return os
+}
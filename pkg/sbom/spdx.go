@@ -0,0 +1,145 @@
+package sbom
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	spdxVersion       = "SPDX-2.2"
+	spdxNoAssertion   = "NOASSERTION"
+	spdxDocumentID    = "SPDXRef-DOCUMENT"
+	spdxImageID       = "SPDXRef-Image"
+	spdxNamespaceBase = "https://werf.io/spdx"
+)
+
+type spdxDocument struct {
+	SPDXVersion       string              `json:"spdxVersion"`
+	DataLicense       string              `json:"dataLicense"`
+	SPDXID            string              `json:"SPDXID"`
+	Name              string              `json:"name"`
+	DocumentNamespace string              `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo    `json:"creationInfo"`
+	Packages          []*spdxPackage      `json:"packages"`
+	Relationships     []*spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID           string             `json:"SPDXID"`
+	Name             string             `json:"name"`
+	VersionInfo      string             `json:"versionInfo,omitempty"`
+	DownloadLocation string             `json:"downloadLocation"`
+	FilesAnalyzed    bool               `json:"filesAnalyzed"`
+	LicenseConcluded string             `json:"licenseConcluded"`
+	LicenseDeclared  string             `json:"licenseDeclared"`
+	CopyrightText    string             `json:"copyrightText"`
+	LicenseComments  string             `json:"licenseComments,omitempty"`
+	SourceInfo       string             `json:"sourceInfo,omitempty"`
+	Comment          string             `json:"comment,omitempty"`
+	ExternalRefs     []*spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+func newSPDXPackage(id, name, version string) *spdxPackage {
+	return &spdxPackage{
+		SPDXID:           id,
+		Name:             name,
+		VersionInfo:      version,
+		DownloadLocation: spdxNoAssertion,
+		LicenseConcluded: spdxNoAssertion,
+		LicenseDeclared:  spdxNoAssertion,
+		CopyrightText:    spdxNoAssertion,
+	}
+}
+
+func marshalSPDX(d *Document) ([]byte, error) {
+	doc := &spdxDocument{
+		SPDXVersion:       spdxVersion,
+		DataLicense:       "CC0-1.0",
+		SPDXID:            spdxDocumentID,
+		Name:              d.name(),
+		DocumentNamespace: fmt.Sprintf("%s/%s-%x", spdxNamespaceBase, d.name(), sha256.Sum256([]byte(d.Reference+d.version()+d.Created.String()))),
+		CreationInfo: spdxCreationInfo{
+			Created:  d.Created.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: werf-" + d.ToolVersion},
+		},
+	}
+
+	relate := func(id, relationshipType, relatedID string) {
+		doc.Relationships = append(doc.Relationships, &spdxRelationship{SPDXElementID: id, RelationshipType: relationshipType, RelatedSPDXElement: relatedID})
+	}
+
+	image := newSPDXPackage(spdxImageID, d.name(), d.version())
+	image.Comment = d.Reference
+	if imagePurl := d.imagePurl(); imagePurl != "" {
+		image.ExternalRefs = append(image.ExternalRefs, &spdxExternalRef{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: imagePurl})
+	}
+	doc.Packages = append(doc.Packages, image)
+	relate(spdxDocumentID, "DESCRIBES", spdxImageID)
+
+	if d.OperatingSystem != nil && d.OperatingSystem.ID != "" {
+		os := newSPDXPackage("SPDXRef-OperatingSystem", d.OperatingSystem.ID, d.OperatingSystem.VersionID)
+		os.Comment = d.OperatingSystem.PrettyName
+		doc.Packages = append(doc.Packages, os)
+		relate(spdxImageID, "CONTAINS", os.SPDXID)
+	}
+
+	if d.Provenance.BaseImage != "" {
+		base := newSPDXPackage("SPDXRef-BaseImage", d.Provenance.BaseImage, d.Provenance.BaseImageID)
+		doc.Packages = append(doc.Packages, base)
+		relate(spdxImageID, "DESCENDANT_OF", base.SPDXID)
+	}
+
+	for ind, gm := range d.Provenance.GitMappings {
+		git := newSPDXPackage(fmt.Sprintf("SPDXRef-GitMapping-%d", ind), gm.Name, gm.Commit)
+		if gm.URL != "" {
+			git.DownloadLocation = fmt.Sprintf("git+%s@%s", gm.URL, gm.Commit)
+		}
+		git.SourceInfo = fmt.Sprintf("git mapping %s to %s", gm.Add, gm.To)
+		doc.Packages = append(doc.Packages, git)
+		relate(spdxImageID, "GENERATED_FROM", git.SPDXID)
+	}
+
+	for ind, i := range d.Provenance.Imports {
+		imported := newSPDXPackage(fmt.Sprintf("SPDXRef-Import-%d", ind), i.ImageName, i.Signature)
+		imported.SourceInfo = fmt.Sprintf("%s import %s to %s", i.kind(), i.Add, i.To)
+		doc.Packages = append(doc.Packages, imported)
+		relate(spdxImageID, "CONTAINS", imported.SPDXID)
+	}
+
+	for ind, p := range d.Packages {
+		pkg := newSPDXPackage(fmt.Sprintf("SPDXRef-Package-%s-%d", p.Type, ind), p.Name, p.Version)
+		// alpine packages licenses are SPDX license expressions, other package databases licenses are free-form
+		if p.Type == "apk" && p.License != "" {
+			pkg.LicenseDeclared = p.License
+		} else if p.License != "" {
+			pkg.LicenseComments = p.License
+		}
+		pkg.SourceInfo = "found in " + p.Location
+		if p.Purl != "" {
+			pkg.ExternalRefs = append(pkg.ExternalRefs, &spdxExternalRef{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: p.Purl})
+		}
+		doc.Packages = append(doc.Packages, pkg)
+		relate(spdxImageID, "CONTAINS", pkg.SPDXID)
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
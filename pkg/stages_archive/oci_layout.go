@@ -0,0 +1,392 @@
+package stages_archive
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	dockerManifestFileName = "manifest.json"
+	ociLayoutFileName      = "oci-layout"
+	ociIndexFileName       = "index.json"
+
+	ociImageLayoutVersion = "1.0.0"
+
+	ociIndexMediaType    = "application/vnd.oci.image.index.v1+json"
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ociConfigMediaType   = "application/vnd.oci.image.config.v1+json"
+	ociLayerMediaType    = "application/vnd.oci.image.layer.v1.tar"
+
+	ociRefNameAnnotation          = "org.opencontainers.image.ref.name"
+	containerdImageNameAnnotation = "io.containerd.image.name"
+)
+
+var errStopWalk = errors.New("stop walk")
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+// dockerArchiveToOCILayout converts `docker save` tarball into OCI image layout tarball.
+// Paths of configs and layers are taken from manifest.json: docker places layers either into <id>/layer.tar or into blobs/sha256/<digest>.
+// Docker saves uncompressed layers, so the layer digest is the layer diff id from the image config
+func dockerArchiveToOCILayout(dockerArchivePath, ociArchivePath string) error {
+	manifest, err := readDockerArchiveManifest(dockerArchivePath)
+	if err != nil {
+		return err
+	}
+
+	configsPaths := map[string]bool{}
+	for _, entry := range manifest {
+		configsPaths[path.Clean(entry.Config)] = true
+	}
+
+	configs := map[string][]byte{}
+	filesSizes := map[string]int64{}
+	links := map[string]string{}
+
+	if err := walkArchive(dockerArchivePath, func(header *tar.Header, r io.Reader) error {
+		name := path.Clean(header.Name)
+
+		switch header.Typeflag {
+		case tar.TypeSymlink:
+			// the layer of the previous image is referenced by the link
+			links[name] = path.Join(path.Dir(name), header.Linkname)
+		case tar.TypeReg, tar.TypeRegA:
+			if configsPaths[name] {
+				data, err := ioutil.ReadAll(r)
+				if err != nil {
+					return err
+				}
+				configs[name] = data
+			}
+
+			filesSizes[name] = header.Size
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	blobs := map[string][]byte{}
+	var blobsDigests []string
+	addBlob := func(digest string, data []byte) {
+		if _, hasKey := blobs[digest]; !hasKey {
+			blobs[digest] = data
+			blobsDigests = append(blobsDigests, digest)
+		}
+	}
+
+	layersDigests := map[string]string{}
+	index := ociIndex{SchemaVersion: 2}
+
+	for _, entry := range manifest {
+		configData, hasKey := configs[path.Clean(entry.Config)]
+		if !hasKey {
+			return fmt.Errorf("bad docker archive: config %s not found", entry.Config)
+		}
+
+		var config struct {
+			RootFS struct {
+				DiffIDs []string `json:"diff_ids"`
+			} `json:"rootfs"`
+		}
+		if err := json.Unmarshal(configData, &config); err != nil {
+			return fmt.Errorf("unable to decode config %s: %s", entry.Config, err)
+		}
+
+		if len(config.RootFS.DiffIDs) != len(entry.Layers) {
+			return fmt.Errorf("bad docker archive: config %s diff ids do not match layers", entry.Config)
+		}
+
+		m := ociManifest{
+			SchemaVersion: 2,
+			MediaType:     ociManifestMediaType,
+			Config:        ociDescriptor{MediaType: ociConfigMediaType, Digest: sha256Digest(configData), Size: int64(len(configData))},
+		}
+
+		for ind, layerPath := range entry.Layers {
+			layerPath = path.Clean(layerPath)
+			if target, hasKey := links[layerPath]; hasKey {
+				layerPath = target
+			}
+
+			size, hasKey := filesSizes[layerPath]
+			if !hasKey {
+				return fmt.Errorf("bad docker archive: layer %s not found", layerPath)
+			}
+
+			digest := config.RootFS.DiffIDs[ind]
+			layersDigests[layerPath] = digest
+			m.Layers = append(m.Layers, ociDescriptor{MediaType: ociLayerMediaType, Digest: digest, Size: size})
+		}
+
+		manifestData, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		manifestDigest := sha256Digest(manifestData)
+
+		addBlob(m.Config.Digest, configData)
+		addBlob(manifestDigest, manifestData)
+
+		for _, repoTag := range entry.RepoTags {
+			index.Manifests = append(index.Manifests, ociDescriptor{
+				MediaType: ociManifestMediaType,
+				Digest:    manifestDigest,
+				Size:      int64(len(manifestData)),
+				Annotations: map[string]string{
+					containerdImageNameAnnotation: repoTag,
+					ociRefNameAnnotation:          referenceTag(repoTag),
+				},
+			})
+		}
+	}
+
+	indexData, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(ociArchivePath)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %s", ociArchivePath, err)
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+
+	if err := writeArchiveFile(tw, ociLayoutFileName, []byte(fmt.Sprintf(`{"imageLayoutVersion":"%s"}`, ociImageLayoutVersion))); err != nil {
+		return err
+	}
+
+	for _, digest := range blobsDigests {
+		if err := writeArchiveFile(tw, blobPath(digest), blobs[digest]); err != nil {
+			return err
+		}
+	}
+
+	if err := writeArchiveFile(tw, ociIndexFileName, indexData); err != nil {
+		return err
+	}
+
+	writtenLayers := map[string]bool{}
+	if err := walkArchive(dockerArchivePath, func(header *tar.Header, r io.Reader) error {
+		digest, hasKey := layersDigests[path.Clean(header.Name)]
+		if !hasKey || writtenLayers[digest] {
+			return nil
+		}
+		writtenLayers[digest] = true
+
+		if err := tw.WriteHeader(&tar.Header{Name: blobPath(digest), Mode: 0644, Size: header.Size, ModTime: header.ModTime, Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+
+		_, err := io.Copy(tw, r)
+		return err
+	}); err != nil {
+		return fmt.Errorf("unable to write %s: %s", ociArchivePath, err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("unable to write %s: %s", ociArchivePath, err)
+	}
+
+	return f.Close()
+}
+
+// ociLayoutToDockerArchive converts OCI image layout tarball into the tarball, which can be loaded by `docker load`:
+// the original entries are copied as is and manifest.json referencing blobs is added
+func ociLayoutToDockerArchive(ociArchivePath, dockerArchivePath string) error {
+	var index *ociIndex
+	if err := walkArchive(ociArchivePath, func(header *tar.Header, r io.Reader) error {
+		if path.Clean(header.Name) != ociIndexFileName {
+			return nil
+		}
+
+		if err := json.NewDecoder(r).Decode(&index); err != nil {
+			return fmt.Errorf("unable to decode %s: %s", ociIndexFileName, err)
+		}
+
+		return errStopWalk
+	}); err != nil {
+		return err
+	}
+
+	if index == nil {
+		return fmt.Errorf("bad OCI image layout: %s not found", ociIndexFileName)
+	}
+
+	manifestsRepoTags := map[string][]string{}
+	var manifestsDigests []string
+	for _, desc := range index.Manifests {
+		if desc.MediaType == ociIndexMediaType {
+			return fmt.Errorf("nested image index %s is not supported", desc.Digest)
+		}
+
+		repoTag := desc.Annotations[containerdImageNameAnnotation]
+		if repoTag == "" {
+			repoTag = desc.Annotations[ociRefNameAnnotation]
+		}
+
+		if !strings.Contains(repoTag, ":") {
+			return fmt.Errorf("unable to determine image name of manifest %s: %s or %s annotation with full image name required", desc.Digest, containerdImageNameAnnotation, ociRefNameAnnotation)
+		}
+
+		if _, hasKey := manifestsRepoTags[desc.Digest]; !hasKey {
+			manifestsDigests = append(manifestsDigests, desc.Digest)
+		}
+		manifestsRepoTags[desc.Digest] = append(manifestsRepoTags[desc.Digest], repoTag)
+	}
+
+	manifests := map[string]*ociManifest{}
+	if err := walkArchive(ociArchivePath, func(header *tar.Header, r io.Reader) error {
+		for _, digest := range manifestsDigests {
+			if path.Clean(header.Name) != blobPath(digest) {
+				continue
+			}
+
+			m := &ociManifest{}
+			if err := json.NewDecoder(r).Decode(m); err != nil {
+				return fmt.Errorf("unable to decode manifest %s: %s", digest, err)
+			}
+			manifests[digest] = m
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	var dockerManifest []*dockerManifestEntry
+	for _, digest := range manifestsDigests {
+		m, hasKey := manifests[digest]
+		if !hasKey {
+			return fmt.Errorf("bad OCI image layout: manifest %s not found", digest)
+		}
+
+		entry := &dockerManifestEntry{Config: blobPath(m.Config.Digest), RepoTags: manifestsRepoTags[digest]}
+		for _, layer := range m.Layers {
+			entry.Layers = append(entry.Layers, blobPath(layer.Digest))
+		}
+
+		dockerManifest = append(dockerManifest, entry)
+	}
+
+	dockerManifestData, err := json.Marshal(dockerManifest)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(dockerArchivePath)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %s", dockerArchivePath, err)
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+
+	if err := walkArchive(ociArchivePath, func(header *tar.Header, r io.Reader) error {
+		if path.Clean(header.Name) == dockerManifestFileName {
+			return nil
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		_, err := io.Copy(tw, r)
+		return err
+	}); err != nil {
+		return fmt.Errorf("unable to write %s: %s", dockerArchivePath, err)
+	}
+
+	if err := writeArchiveFile(tw, dockerManifestFileName, dockerManifestData); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("unable to write %s: %s", dockerArchivePath, err)
+	}
+
+	return f.Close()
+}
+
+func walkArchive(archivePath string, f func(header *tar.Header, r io.Reader) error) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %s", archivePath, err)
+	}
+	defer file.Close()
+
+	tr := tar.NewReader(file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("unable to read archive %s: %s", archivePath, err)
+		}
+
+		if err := f(header, tr); err == errStopWalk {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func writeArchiveFile(tw *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("unable to write %s: %s", name, err)
+	}
+
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("unable to write %s: %s", name, err)
+	}
+
+	return nil
+}
+
+func blobPath(digest string) string {
+	return path.Join("blobs", strings.Replace(digest, ":", "/", 1))
+}
+
+func sha256Digest(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+// referenceTag returns the tag part of the image reference
+func referenceTag(reference string) string {
+	if ind := strings.LastIndex(reference, ":"); ind != -1 && !strings.Contains(reference[ind:], "/") {
+		return reference[ind+1:]
+	}
+
+	return "latest"
+}
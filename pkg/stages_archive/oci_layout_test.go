@@ -0,0 +1,225 @@
+package stages_archive
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// archiveEntry is the regular file or, when linkname is set, the symlink of the test tarball
+type archiveEntry struct {
+	name     string
+	data     []byte
+	linkname string
+}
+
+func writeTestArchive(archivePath string, entries []archiveEntry) {
+	f, err := os.Create(archivePath)
+	Ω(err).ShouldNot(HaveOccurred())
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	for _, entry := range entries {
+		if entry.linkname != "" {
+			Ω(tw.WriteHeader(&tar.Header{Name: entry.name, Linkname: entry.linkname, Typeflag: tar.TypeSymlink})).Should(Succeed())
+			continue
+		}
+
+		Ω(writeArchiveFile(tw, entry.name, entry.data)).Should(Succeed())
+	}
+
+	Ω(tw.Close()).Should(Succeed())
+}
+
+// readTestArchive returns the content of regular files and the number of occurrences of each entry
+func readTestArchive(archivePath string) (map[string][]byte, map[string]int) {
+	files := map[string][]byte{}
+	counts := map[string]int{}
+
+	Ω(walkArchive(archivePath, func(header *tar.Header, r io.Reader) error {
+		name := path.Clean(header.Name)
+		counts[name]++
+
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		files[name] = data
+
+		return nil
+	})).Should(Succeed())
+
+	return files, counts
+}
+
+func testConfig(name string, layers ...[]byte) []byte {
+	diffIDs := []string{}
+	for _, layer := range layers {
+		diffIDs = append(diffIDs, sha256Digest(layer))
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"architecture": "amd64",
+		"os":           "linux",
+		"config":       map[string]interface{}{"Labels": map[string]string{"name": name}},
+		"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": diffIDs},
+	})
+
+	return data
+}
+
+func digestHex(data []byte) string {
+	return strings.TrimPrefix(sha256Digest(data), "sha256:")
+}
+
+var _ = Describe("OCI image layout", func() {
+	var tmpDir string
+
+	baseLayer := []byte("base layer")
+	firstLayer := []byte("first layer")
+	secondLayer := []byte("second layer")
+
+	firstRepoTag := "werf-stages-storage/app:first"
+	secondRepoTag := "werf-stages-storage/app:second"
+
+	firstConfig := testConfig("first", baseLayer, firstLayer)
+	secondConfig := testConfig("second", baseLayer, secondLayer)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "werf-stages-archive-test")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Ω(os.RemoveAll(tmpDir)).Should(Succeed())
+	})
+
+	// legacyDockerArchive is the layout of `docker save` before docker 25: layers are placed into <id>/layer.tar and the shared layer is the symlink
+	legacyDockerArchive := func() []archiveEntry {
+		manifest, err := json.Marshal([]*dockerManifestEntry{
+			{Config: digestHex(firstConfig) + ".json", RepoTags: []string{firstRepoTag}, Layers: []string{"base/layer.tar", "first/layer.tar"}},
+			{Config: digestHex(secondConfig) + ".json", RepoTags: []string{secondRepoTag}, Layers: []string{"shared/layer.tar", "second/layer.tar"}},
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		return []archiveEntry{
+			{name: "base/layer.tar", data: baseLayer},
+			{name: "first/layer.tar", data: firstLayer},
+			{name: "shared/layer.tar", linkname: "../base/layer.tar"},
+			{name: "second/layer.tar", data: secondLayer},
+			{name: digestHex(firstConfig) + ".json", data: firstConfig},
+			{name: digestHex(secondConfig) + ".json", data: secondConfig},
+			{name: dockerManifestFileName, data: manifest},
+		}
+	}
+
+	// blobsDockerArchive is the layout of `docker save` since docker 25: layers and configs are placed into blobs/sha256/<digest>
+	blobsDockerArchive := func() []archiveEntry {
+		manifest, err := json.Marshal([]*dockerManifestEntry{
+			{Config: blobPath(sha256Digest(firstConfig)), RepoTags: []string{firstRepoTag}, Layers: []string{blobPath(sha256Digest(baseLayer)), blobPath(sha256Digest(firstLayer))}},
+			{Config: blobPath(sha256Digest(secondConfig)), RepoTags: []string{secondRepoTag}, Layers: []string{blobPath(sha256Digest(baseLayer)), blobPath(sha256Digest(secondLayer))}},
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		var entries []archiveEntry
+		for _, data := range [][]byte{baseLayer, firstLayer, secondLayer, firstConfig, secondConfig} {
+			entries = append(entries, archiveEntry{name: blobPath(sha256Digest(data)), data: data})
+		}
+
+		return append(entries, archiveEntry{name: dockerManifestFileName, data: manifest})
+	}
+
+	DescribeTable("converts docker archive to OCI image layout and back",
+		func(dockerArchive func() []archiveEntry) {
+			dockerArchivePath := filepath.Join(tmpDir, "docker.tar")
+			ociArchivePath := filepath.Join(tmpDir, "oci.tar")
+			loadArchivePath := filepath.Join(tmpDir, "load.tar")
+
+			writeTestArchive(dockerArchivePath, dockerArchive())
+			Ω(dockerArchiveToOCILayout(dockerArchivePath, ociArchivePath)).Should(Succeed())
+
+			files, counts := readTestArchive(ociArchivePath)
+			Ω(files).Should(HaveKey(ociLayoutFileName))
+
+			for _, layer := range [][]byte{baseLayer, firstLayer, secondLayer} {
+				layerBlobPath := blobPath(sha256Digest(layer))
+				Ω(counts[layerBlobPath]).Should(Equal(1), "layer %s should be written once", layerBlobPath)
+				Ω(files[layerBlobPath]).Should(Equal(layer))
+			}
+
+			var index ociIndex
+			Ω(json.Unmarshal(files[ociIndexFileName], &index)).Should(Succeed())
+			Ω(index.Manifests).Should(HaveLen(2))
+
+			expected := map[string][][]byte{firstRepoTag: {baseLayer, firstLayer}, secondRepoTag: {baseLayer, secondLayer}}
+			for _, desc := range index.Manifests {
+				repoTag := desc.Annotations[containerdImageNameAnnotation]
+				Ω(expected).Should(HaveKey(repoTag))
+				Ω(desc.Annotations[ociRefNameAnnotation]).Should(Equal(referenceTag(repoTag)))
+
+				manifestData := files[blobPath(desc.Digest)]
+				Ω(sha256Digest(manifestData)).Should(Equal(desc.Digest))
+
+				var m ociManifest
+				Ω(json.Unmarshal(manifestData, &m)).Should(Succeed())
+				Ω(files).Should(HaveKey(blobPath(m.Config.Digest)))
+				Ω(m.Layers).Should(HaveLen(len(expected[repoTag])))
+
+				for ind, layer := range expected[repoTag] {
+					Ω(m.Layers[ind].Digest).Should(Equal(sha256Digest(layer)), "layer digest should be the diff id")
+					Ω(m.Layers[ind].Size).Should(Equal(int64(len(layer))))
+				}
+			}
+
+			Ω(ociLayoutToDockerArchive(ociArchivePath, loadArchivePath)).Should(Succeed())
+
+			loadFiles, _ := readTestArchive(loadArchivePath)
+			loadManifest, err := readDockerArchiveManifest(loadArchivePath)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(loadManifest).Should(HaveLen(2))
+
+			for _, entry := range loadManifest {
+				Ω(entry.RepoTags).Should(HaveLen(1))
+				repoTag := entry.RepoTags[0]
+				Ω(expected).Should(HaveKey(repoTag))
+
+				Ω(loadFiles).Should(HaveKey(entry.Config))
+				Ω(entry.Layers).Should(HaveLen(len(expected[repoTag])))
+				for ind, layer := range expected[repoTag] {
+					Ω(loadFiles[entry.Layers[ind]]).Should(Equal(layer), fmt.Sprintf("layer %d of %s", ind, repoTag))
+				}
+			}
+		},
+		Entry("layers in <id>/layer.tar", legacyDockerArchive),
+		Entry("layers in blobs/sha256", blobsDockerArchive),
+	)
+
+	It("fails when the layer from manifest.json is missing", func() {
+		manifest, err := json.Marshal([]*dockerManifestEntry{
+			{Config: "config.json", RepoTags: []string{firstRepoTag}, Layers: []string{"base/layer.tar", "first/layer.tar"}},
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		dockerArchivePath := filepath.Join(tmpDir, "docker.tar")
+		writeTestArchive(dockerArchivePath, []archiveEntry{
+			{name: "base/layer.tar", data: baseLayer},
+			{name: "config.json", data: firstConfig},
+			{name: dockerManifestFileName, data: manifest},
+		})
+
+		err = dockerArchiveToOCILayout(dockerArchivePath, filepath.Join(tmpDir, "oci.tar"))
+		Ω(err).Should(HaveOccurred())
+		Ω(err.Error()).Should(ContainSubstring("layer first/layer.tar not found"))
+	})
+})
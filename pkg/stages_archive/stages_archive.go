@@ -0,0 +1,216 @@
+package stages_archive
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/flant/logboek"
+
+	"github.com/flant/werf/pkg/docker"
+	"github.com/flant/werf/pkg/image"
+	"github.com/flant/werf/pkg/stages_storage"
+	"github.com/flant/werf/pkg/util"
+	"github.com/flant/werf/pkg/werf"
+)
+
+const (
+	DockerFormat = "docker"
+	OCIFormat    = "oci"
+)
+
+// dockerManifestEntry is the manifest.json entry of the `docker save` tarball
+type dockerManifestEntry struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// Save writes stages images into one tarball: `docker save` tarball or OCI image layout tarball.
+// Layers shared by several stages are written once in both formats
+func Save(imagesNames []string, archivePath, format string) error {
+	if format != DockerFormat && format != OCIFormat {
+		return fmt.Errorf("unsupported archive format %q: expected %s or %s", format, DockerFormat, OCIFormat)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(archivePath), os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create dir %s: %s", filepath.Dir(archivePath), err)
+	}
+
+	tmpArchivePath := fmt.Sprintf("%s.%s", archivePath, util.GenerateConsistentRandomString(5))
+	defer os.Remove(tmpArchivePath)
+
+	switch format {
+	case DockerFormat:
+		if err := docker.CliSave(append([]string{"--output", tmpArchivePath}, imagesNames...)...); err != nil {
+			return fmt.Errorf("unable to save stages: %s", err)
+		}
+	case OCIFormat:
+		dockerArchivePath, err := tmpFilePath("werf-stages-archive-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(dockerArchivePath)
+
+		if err := docker.CliSave(append([]string{"--output", dockerArchivePath}, imagesNames...)...); err != nil {
+			return fmt.Errorf("unable to save stages: %s", err)
+		}
+
+		if err := logboek.LogProcessInline("Converting docker archive to OCI image layout", logboek.LogProcessInlineOptions{}, func() error {
+			return dockerArchiveToOCILayout(dockerArchivePath, tmpArchivePath)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(tmpArchivePath, archivePath)
+}
+
+// Load loads stages images from `docker save` or OCI image layout tarball into the docker daemon
+// and returns loaded stages images names. All images in the tarball should be werf stages images
+func Load(archivePath string) ([]string, error) {
+	isOCILayout, err := isOCILayoutArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	dockerArchivePath := archivePath
+	if isOCILayout {
+		dockerArchivePath, err = tmpFilePath("werf-stages-archive-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(dockerArchivePath)
+
+		if err := logboek.LogProcessInline("Converting OCI image layout to docker archive", logboek.LogProcessInlineOptions{}, func() error {
+			return ociLayoutToDockerArchive(archivePath, dockerArchivePath)
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	manifest, err := readDockerArchiveManifest(dockerArchivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var imagesNames []string
+	for _, entry := range manifest {
+		for _, repoTag := range entry.RepoTags {
+			if !strings.HasPrefix(repoTag, image.LocalImageStageImageNamePrefix) {
+				return nil, fmt.Errorf("archive %s contains image %s, which is not werf stage", archivePath, repoTag)
+			}
+
+			imagesNames = append(imagesNames, repoTag)
+		}
+	}
+
+	if err := docker.CliLoad("--quiet", "--input", dockerArchivePath); err != nil {
+		return nil, fmt.Errorf("unable to load stages from %s: %s", archivePath, err)
+	}
+
+	return imagesNames, nil
+}
+
+func isOCILayoutArchive(archivePath string) (bool, error) {
+	var isOCILayout bool
+	err := walkArchive(archivePath, func(header *tar.Header, _ io.Reader) error {
+		switch path.Clean(header.Name) {
+		case ociLayoutFileName:
+			isOCILayout = true
+			return errStopWalk
+		case dockerManifestFileName:
+			return errStopWalk
+		}
+
+		return nil
+	})
+
+	return isOCILayout, err
+}
+
+func readDockerArchiveManifest(archivePath string) ([]*dockerManifestEntry, error) {
+	var manifest []*dockerManifestEntry
+	var isFound bool
+
+	err := walkArchive(archivePath, func(header *tar.Header, r io.Reader) error {
+		if path.Clean(header.Name) != dockerManifestFileName {
+			return nil
+		}
+
+		isFound = true
+		if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+			return fmt.Errorf("unable to decode %s: %s", dockerManifestFileName, err)
+		}
+
+		return errStopWalk
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !isFound {
+		return nil, fmt.Errorf("bad archive %s: %s not found", archivePath, dockerManifestFileName)
+	}
+
+	return manifest, nil
+}
+
+func tmpFilePath(prefix string) (string, error) {
+	f, err := ioutil.TempFile(werf.GetTmpDir(), prefix)
+	if err != nil {
+		return "", fmt.Errorf("unable to create tmp file: %s", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+// Import loads stages from the tarball and stores them into the stages storage.
+// Loaded stages are already in the local stages storage, distributed stages storage requires storing
+func Import(archivePath string, stagesStorage stages_storage.StagesStorage) error {
+	var imagesNames []string
+	if err := logboek.LogProcess(fmt.Sprintf("Loading stages from %s", archivePath), logboek.LogProcessOptions{}, func() error {
+		var err error
+		imagesNames, err = Load(archivePath)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	for _, imageName := range imagesNames {
+		logboek.LogInfoF("%s\n", imageName)
+	}
+
+	if !stagesStorage.IsDistributed() {
+		return nil
+	}
+
+	for _, imageName := range imagesNames {
+		parts := strings.SplitN(strings.TrimPrefix(imageName, image.LocalImageStageImageNamePrefix), ":", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("bad stage image name %s", imageName)
+		}
+		projectName, signature := parts[0], parts[1]
+
+		img := image.NewStageImage(nil, imageName)
+		if err := img.SyncDockerState(); err != nil {
+			return err
+		}
+
+		if err := stagesStorage.StoreStage(projectName, signature, img); err != nil {
+			return fmt.Errorf("unable to store stage %s into stages storage %s: %s", imageName, stagesStorage.String(), err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,217 @@
+package stages_storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/flant/logboek"
+
+	"github.com/flant/werf/pkg/image"
+	"github.com/flant/werf/pkg/util"
+)
+
+// ArchiveStagesStorage keeps stages in the directory as `docker save` tarballs,
+// so the directory can be persisted between builds by the CI cache:
+//
+//	DIR/PROJECT/SIGNATURE.tar  — docker save of the stage image
+//	DIR/PROJECT/SIGNATURE.json — stage description
+type ArchiveStagesStorage struct {
+	Dir string
+
+	dockerClient dockerClient
+}
+
+func NewArchiveStagesStorage(dir string) *ArchiveStagesStorage {
+	return &ArchiveStagesStorage{Dir: util.ExpandPath(dir), dockerClient: defaultDockerClient{}}
+}
+
+func (storage *ArchiveStagesStorage) String() string {
+	return fmt.Sprintf("%s%s", ArchiveStagesStorageAddress, storage.Dir)
+}
+
+func (storage *ArchiveStagesStorage) IsDistributed() bool {
+	return true
+}
+
+func (storage *ArchiveStagesStorage) ConstructStageImageName(projectName, signature string) string {
+	return localStageImageName(projectName, signature)
+}
+
+func (storage *ArchiveStagesStorage) GetStageBySignature(projectName, signature string) (*StageDescription, error) {
+	if exist, err := util.FileExists(storage.stageArchivePath(projectName, signature)); err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, nil
+	}
+
+	return storage.readStageDescription(projectName, signature)
+}
+
+func (storage *ArchiveStagesStorage) FetchStage(projectName, signature string, _ *image.StageImage) error {
+	archivePath := storage.stageArchivePath(projectName, signature)
+
+	logProcessMsg := fmt.Sprintf("Loading stage %s from stages storage", archivePath)
+	if err := logboek.LogProcess(logProcessMsg, logboek.LogProcessOptions{}, func() error {
+		return storage.dockerClient.CliLoad("--quiet", "--input", archivePath)
+	}); err != nil {
+		return fmt.Errorf("unable to load stage %s from stages storage: %s", archivePath, err)
+	}
+
+	return nil
+}
+
+func (storage *ArchiveStagesStorage) StoreStage(projectName, signature string, img *image.StageImage) error {
+	archivePath := storage.stageArchivePath(projectName, signature)
+
+	if exist, err := util.FileExists(archivePath); err != nil {
+		return err
+	} else if exist {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(archivePath), os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create dir %s: %s", filepath.Dir(archivePath), err)
+	}
+
+	successInfoSectionFunc := func() {
+		_ = logboek.WithIndent(func() error {
+			logboek.LogInfoF("stages-storage: %s\n", storage.String())
+			logboek.LogInfoF("       archive: %s\n", archivePath)
+
+			return nil
+		})
+	}
+
+	logProcessOptions := logboek.LogProcessOptions{SuccessInfoSectionFunc: successInfoSectionFunc, ColorizeMsgFunc: logboek.ColorizeHighlight}
+	return logboek.LogProcess("Storing stage into stages storage", logProcessOptions, func() error {
+		inspect, err := img.MustGetInspect()
+		if err != nil {
+			return err
+		}
+
+		created, err := time.Parse(time.RFC3339Nano, inspect.Created)
+		if err != nil {
+			return fmt.Errorf("unable to parse image %s creation time %q: %s", img.Name(), inspect.Created, err)
+		}
+
+		stage := &StageDescription{
+			Signature: signature,
+			ImageName: img.Name(),
+			ImageId:   inspect.ID,
+			ParentId:  inspect.Parent,
+			Created:   created,
+		}
+		if inspect.Config != nil {
+			stage.Labels = inspect.Config.Labels
+		}
+
+		tmpArchivePath := fmt.Sprintf("%s.%s", archivePath, util.GenerateConsistentRandomString(5))
+		if err := storage.dockerClient.CliSave("--output", tmpArchivePath, img.Name()); err != nil {
+			_ = os.Remove(tmpArchivePath)
+			return fmt.Errorf("unable to save stage %s: %s", img.Name(), err)
+		}
+
+		if err := storage.writeStageDescription(projectName, stage); err != nil {
+			_ = os.Remove(tmpArchivePath)
+			return err
+		}
+
+		return os.Rename(tmpArchivePath, archivePath)
+	})
+}
+
+func (storage *ArchiveStagesStorage) GetStagesList(projectName string) ([]*StageDescription, error) {
+	projectDir := filepath.Join(storage.Dir, projectName)
+
+	if exist, err := util.DirExists(projectDir); err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, nil
+	}
+
+	files, err := ioutil.ReadDir(projectDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read dir %s: %s", projectDir, err)
+	}
+
+	var stages []*StageDescription
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".tar" {
+			continue
+		}
+
+		stage, err := storage.readStageDescription(projectName, strings.TrimSuffix(f.Name(), ".tar"))
+		if err != nil {
+			return nil, err
+		}
+
+		stages = append(stages, stage)
+	}
+
+	return stages, nil
+}
+
+func (storage *ArchiveStagesStorage) DeleteStages(options DeleteStagesOptions, stages ...*StageDescription) error {
+	for _, stage := range stages {
+		projectName := stage.Labels[image.WerfLabel]
+		archivePath := storage.stageArchivePath(projectName, stage.Signature)
+
+		logboek.LogLn(archivePath)
+
+		if options.DryRun {
+			continue
+		}
+
+		for _, path := range []string{archivePath, storage.stageDescriptionPath(projectName, stage.Signature)} {
+			if err := os.RemoveAll(path); err != nil {
+				return fmt.Errorf("unable to remove %s: %s", path, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (storage *ArchiveStagesStorage) stageArchivePath(projectName, signature string) string {
+	return filepath.Join(storage.Dir, projectName, fmt.Sprintf("%s.tar", signature))
+}
+
+func (storage *ArchiveStagesStorage) stageDescriptionPath(projectName, signature string) string {
+	return filepath.Join(storage.Dir, projectName, fmt.Sprintf("%s.json", signature))
+}
+
+func (storage *ArchiveStagesStorage) readStageDescription(projectName, signature string) (*StageDescription, error) {
+	path := storage.stageDescriptionPath(projectName, signature)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read stage description %s: %s", path, err)
+	}
+
+	stage := &StageDescription{}
+	if err := json.Unmarshal(data, stage); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal stage description %s: %s", path, err)
+	}
+
+	return stage, nil
+}
+
+func (storage *ArchiveStagesStorage) writeStageDescription(projectName string, stage *StageDescription) error {
+	path := storage.stageDescriptionPath(projectName, stage.Signature)
+
+	data, err := json.Marshal(stage)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("unable to write stage description %s: %s", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package stages_storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/werf/pkg/image"
+)
+
+var _ = Describe("archive stages storage", func() {
+	var dir string
+	var dockerClient *fakeDockerClient
+	var storage *ArchiveStagesStorage
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "werf-archive-stages-storage-test")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		dockerClient = newFakeDockerClient()
+		storage = &ArchiveStagesStorage{Dir: dir, dockerClient: dockerClient}
+	})
+
+	AfterEach(func() {
+		Ω(os.RemoveAll(dir)).Should(Succeed())
+	})
+
+	It("stores, lists, fetches and deletes the stage", func() {
+		imageName := storage.ConstructStageImageName("project", "sig")
+		dockerClient.addImage(imageName, "sha256:1", map[string]string{image.WerfLabel: "project"})
+
+		img := image.NewStageImage(nil, imageName)
+		img.SetInspect(dockerClient.images[imageName])
+
+		stage, err := storage.GetStageBySignature("project", "sig")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(stage).Should(BeNil())
+
+		Ω(storage.StoreStage("project", "sig", img)).Should(Succeed())
+		Ω(filepath.Join(dir, "project", "sig.tar")).Should(BeAnExistingFile())
+		Ω(filepath.Join(dir, "project", "sig.json")).Should(BeAnExistingFile())
+
+		stage, err = storage.GetStageBySignature("project", "sig")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(stage).ShouldNot(BeNil())
+		Ω(stage.Signature).Should(Equal("sig"))
+		Ω(stage.ImageName).Should(Equal(imageName))
+		Ω(stage.ImageId).Should(Equal("sha256:1"))
+		Ω(stage.ParentId).Should(Equal("sha256:parent"))
+		Ω(stage.Labels).Should(Equal(map[string]string{image.WerfLabel: "project"}))
+
+		stages, err := storage.GetStagesList("project")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(stages).Should(Equal([]*StageDescription{stage}))
+
+		stages, err = storage.GetStagesList("other")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(stages).Should(BeEmpty())
+
+		delete(dockerClient.images, imageName)
+		Ω(storage.FetchStage("project", "sig", img)).Should(Succeed())
+		Ω(dockerClient.images).Should(HaveKey(imageName))
+		Ω(dockerClient.images[imageName].ID).Should(Equal("sha256:1"))
+
+		Ω(storage.DeleteStages(DeleteStagesOptions{DryRun: true}, stage)).Should(Succeed())
+		Ω(filepath.Join(dir, "project", "sig.tar")).Should(BeAnExistingFile())
+
+		Ω(storage.DeleteStages(DeleteStagesOptions{}, stage)).Should(Succeed())
+		Ω(filepath.Join(dir, "project", "sig.tar")).ShouldNot(BeAnExistingFile())
+		Ω(filepath.Join(dir, "project", "sig.json")).ShouldNot(BeAnExistingFile())
+
+		stages, err = storage.GetStagesList("project")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(stages).Should(BeEmpty())
+	})
+
+	It("does not overwrite the stored stage", func() {
+		imageName := storage.ConstructStageImageName("project", "sig")
+		dockerClient.addImage(imageName, "sha256:1", map[string]string{image.WerfLabel: "project"})
+
+		img := image.NewStageImage(nil, imageName)
+		img.SetInspect(dockerClient.images[imageName])
+		Ω(storage.StoreStage("project", "sig", img)).Should(Succeed())
+
+		dockerClient.addImage(imageName, "sha256:2", map[string]string{image.WerfLabel: "project"})
+		img.SetInspect(dockerClient.images[imageName])
+		Ω(storage.StoreStage("project", "sig", img)).Should(Succeed())
+
+		stage, err := storage.GetStageBySignature("project", "sig")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(stage.ImageId).Should(Equal("sha256:1"))
+	})
+})
@@ -0,0 +1,48 @@
+package stages_storage
+
+import (
+	"github.com/docker/docker/api/types"
+
+	"github.com/flant/werf/pkg/docker"
+)
+
+// dockerClient is the part of the docker package used by the local and archive stages storages
+type dockerClient interface {
+	ImageInspect(ref string) (*types.ImageInspect, error)
+	Images(options types.ImageListOptions) ([]types.ImageSummary, error)
+	Containers(options types.ContainerListOptions) ([]types.Container, error)
+	ContainerRemove(ref string, options types.ContainerRemoveOptions) error
+	CliRmi(args ...string) error
+	CliSave(args ...string) error
+	CliLoad(args ...string) error
+}
+
+type defaultDockerClient struct{}
+
+func (c defaultDockerClient) ImageInspect(ref string) (*types.ImageInspect, error) {
+	return docker.ImageInspect(ref)
+}
+
+func (c defaultDockerClient) Images(options types.ImageListOptions) ([]types.ImageSummary, error) {
+	return docker.Images(options)
+}
+
+func (c defaultDockerClient) Containers(options types.ContainerListOptions) ([]types.Container, error) {
+	return docker.Containers(options)
+}
+
+func (c defaultDockerClient) ContainerRemove(ref string, options types.ContainerRemoveOptions) error {
+	return docker.ContainerRemove(ref, options)
+}
+
+func (c defaultDockerClient) CliRmi(args ...string) error {
+	return docker.CliRmi(args...)
+}
+
+func (c defaultDockerClient) CliSave(args ...string) error {
+	return docker.CliSave(args...)
+}
+
+func (c defaultDockerClient) CliLoad(args ...string) error {
+	return docker.CliLoad(args...)
+}
@@ -0,0 +1,200 @@
+package stages_storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+
+	"github.com/flant/logboek"
+
+	"github.com/flant/werf/pkg/image"
+)
+
+type LocalStagesStorage struct {
+	dockerClient dockerClient
+}
+
+func NewLocalStagesStorage() *LocalStagesStorage {
+	return &LocalStagesStorage{dockerClient: defaultDockerClient{}}
+}
+
+func (storage *LocalStagesStorage) String() string {
+	return LocalStagesStorageAddress
+}
+
+func (storage *LocalStagesStorage) IsDistributed() bool {
+	return false
+}
+
+func (storage *LocalStagesStorage) ConstructStageImageName(projectName, signature string) string {
+	return localStageImageName(projectName, signature)
+}
+
+func (storage *LocalStagesStorage) GetStageBySignature(projectName, signature string) (*StageDescription, error) {
+	imageName := localStageImageName(projectName, signature)
+
+	inspect, err := storage.dockerClient.ImageInspect(imageName)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("unable to inspect image %s: %s", imageName, err)
+	}
+
+	created, err := time.Parse(time.RFC3339Nano, inspect.Created)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse image %s creation time %q: %s", imageName, inspect.Created, err)
+	}
+
+	var labels map[string]string
+	if inspect.Config != nil {
+		labels = inspect.Config.Labels
+	}
+
+	return &StageDescription{
+		Signature: signature,
+		ImageName: imageName,
+		ImageId:   inspect.ID,
+		ParentId:  inspect.Parent,
+		Labels:    labels,
+		Created:   created,
+	}, nil
+}
+
+// FetchStage does nothing: local stages storage is the docker daemon itself
+func (storage *LocalStagesStorage) FetchStage(_, _ string, _ *image.StageImage) error {
+	return nil
+}
+
+// StoreStage does nothing: built stage image is already tagged in the docker daemon
+func (storage *LocalStagesStorage) StoreStage(_, _ string, _ *image.StageImage) error {
+	return nil
+}
+
+func (storage *LocalStagesStorage) GetStagesList(projectName string) ([]*StageDescription, error) {
+	filterSet := filters.NewArgs()
+	filterSet.Add("label", image.WerfLabel)
+	filterSet.Add("label", fmt.Sprintf("%s=%s", image.WerfLabel, projectName))
+	filterSet.Add("label", fmt.Sprintf("%s=%s", image.WerfCacheVersionLabel, image.BuildCacheVersion))
+	filterSet.Add("reference", fmt.Sprintf(image.LocalImageStageImageNameFormat, projectName))
+
+	images, err := storage.dockerClient.Images(types.ImageListOptions{Filters: filterSet})
+	if err != nil {
+		return nil, err
+	}
+
+	stageImageNamePrefix := fmt.Sprintf(image.LocalImageStageImageNameFormat, projectName) + ":"
+
+	var stages []*StageDescription
+	for _, img := range images {
+		for _, repoTag := range img.RepoTags {
+			if !strings.HasPrefix(repoTag, stageImageNamePrefix) {
+				continue
+			}
+
+			stages = append(stages, &StageDescription{
+				Signature: stageSignatureByLocalImageName(projectName, repoTag),
+				ImageName: repoTag,
+				ImageId:   img.ID,
+				ParentId:  img.ParentID,
+				Labels:    img.Labels,
+				Created:   time.Unix(img.Created, 0),
+			})
+		}
+	}
+
+	return stages, nil
+}
+
+func (storage *LocalStagesStorage) DeleteStages(options DeleteStagesOptions, stages ...*StageDescription) error {
+	stages, err := storage.processUsedStages(options, stages)
+	if err != nil {
+		return err
+	}
+
+	var imageReferences []string
+	for _, stage := range stages {
+		imageReferences = append(imageReferences, stage.ImageName)
+	}
+
+	if len(imageReferences) == 0 {
+		return nil
+	}
+
+	if options.DryRun {
+		logboek.LogLn(strings.Join(imageReferences, "\n"))
+		logboek.LogOptionalLn()
+		return nil
+	}
+
+	var args []string
+	if options.RmiForce {
+		args = append(args, "--force")
+	}
+	args = append(args, imageReferences...)
+
+	return storage.dockerClient.CliRmi(args...)
+}
+
+func (storage *LocalStagesStorage) processUsedStages(options DeleteStagesOptions, stages []*StageDescription) ([]*StageDescription, error) {
+	if len(stages) == 0 {
+		return stages, nil
+	}
+
+	filterSet := filters.NewArgs()
+	for _, stage := range stages {
+		filterSet.Add("ancestor", stage.ImageId)
+	}
+
+	containers, err := storage.dockerClient.Containers(types.ContainerListOptions{All: true, Quiet: true, Filters: filterSet})
+	if err != nil {
+		return nil, err
+	}
+
+	var stagesToExclude []*StageDescription
+	for _, container := range containers {
+		for _, stage := range stages {
+			if stage.ImageId != container.ImageID {
+				continue
+			}
+
+			containerName := container.ID
+			if len(container.Names) != 0 {
+				containerName = container.Names[0]
+			}
+
+			if options.SkipUsedImages {
+				logboek.LogInfoF("Skip image %s (used by container %s)\n", stage.ImageName, containerName)
+				stagesToExclude = append(stagesToExclude, stage)
+			} else if options.RmContainersThatUseWerfImages {
+				if options.DryRun {
+					logboek.LogLn(containerName)
+					logboek.LogOptionalLn()
+				} else if err := storage.dockerClient.ContainerRemove(container.ID, types.ContainerRemoveOptions{Force: options.RmForce}); err != nil {
+					return nil, err
+				}
+			} else {
+				return nil, fmt.Errorf("cannot remove image %s used by container %s\n%s", stage.ImageName, containerName, "Use --force option to remove all containers that are based on deleting werf docker images")
+			}
+		}
+	}
+
+	var res []*StageDescription
+Stages:
+	for _, stage := range stages {
+		for _, stageToExclude := range stagesToExclude {
+			if stage == stageToExclude {
+				continue Stages
+			}
+		}
+
+		res = append(res, stage)
+	}
+
+	return res, nil
+}
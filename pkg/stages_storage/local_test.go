@@ -0,0 +1,238 @@
+package stages_storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/werf/pkg/image"
+)
+
+// fakeDockerClient keeps images and containers in memory, saved images are written to the archives as inspect JSON
+type fakeDockerClient struct {
+	images     map[string]*types.ImageInspect
+	containers []types.Container
+
+	removedContainers []string
+	rmiArgs           [][]string
+}
+
+func newFakeDockerClient() *fakeDockerClient {
+	return &fakeDockerClient{images: map[string]*types.ImageInspect{}}
+}
+
+func (c *fakeDockerClient) addImage(name, id string, labels map[string]string) {
+	c.images[name] = &types.ImageInspect{
+		ID:       id,
+		Parent:   "sha256:parent",
+		RepoTags: []string{name},
+		Created:  time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339Nano),
+		Config:   &container.Config{Labels: labels},
+	}
+}
+
+func (c *fakeDockerClient) ImageInspect(ref string) (*types.ImageInspect, error) {
+	inspect, hasImage := c.images[ref]
+	if !hasImage {
+		return nil, errdefs.NotFound(fmt.Errorf("no such image: %s", ref))
+	}
+
+	return inspect, nil
+}
+
+func (c *fakeDockerClient) Images(options types.ImageListOptions) ([]types.ImageSummary, error) {
+	var res []types.ImageSummary
+
+Images:
+	for name, inspect := range c.images {
+		for _, label := range options.Filters.Get("label") {
+			parts := strings.SplitN(label, "=", 2)
+			value, hasLabel := inspect.Config.Labels[parts[0]]
+			if !hasLabel || (len(parts) == 2 && value != parts[1]) {
+				continue Images
+			}
+		}
+
+		created, err := time.Parse(time.RFC3339Nano, inspect.Created)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, types.ImageSummary{
+			ID:       inspect.ID,
+			ParentID: inspect.Parent,
+			RepoTags: []string{name},
+			Labels:   inspect.Config.Labels,
+			Created:  created.Unix(),
+		})
+	}
+
+	return res, nil
+}
+
+func (c *fakeDockerClient) Containers(options types.ContainerListOptions) ([]types.Container, error) {
+	var res []types.Container
+	for _, cont := range c.containers {
+		for _, ancestor := range options.Filters.Get("ancestor") {
+			if cont.ImageID == ancestor {
+				res = append(res, cont)
+				break
+			}
+		}
+	}
+
+	return res, nil
+}
+
+func (c *fakeDockerClient) ContainerRemove(ref string, _ types.ContainerRemoveOptions) error {
+	c.removedContainers = append(c.removedContainers, ref)
+	return nil
+}
+
+func (c *fakeDockerClient) CliRmi(args ...string) error {
+	c.rmiArgs = append(c.rmiArgs, args)
+
+	for _, arg := range args {
+		delete(c.images, arg)
+	}
+
+	return nil
+}
+
+// CliSave handles `docker save --output PATH IMAGE`
+func (c *fakeDockerClient) CliSave(args ...string) error {
+	Ω(args).Should(HaveLen(3))
+	Ω(args[0]).Should(Equal("--output"))
+
+	inspect, err := c.ImageInspect(args[2])
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(inspect)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(args[1], data, 0644)
+}
+
+// CliLoad handles `docker load --quiet --input PATH`
+func (c *fakeDockerClient) CliLoad(args ...string) error {
+	Ω(args).Should(Equal([]string{"--quiet", "--input", args[2]}))
+
+	data, err := ioutil.ReadFile(args[2])
+	if err != nil {
+		return err
+	}
+
+	inspect := &types.ImageInspect{}
+	if err := json.Unmarshal(data, inspect); err != nil {
+		return err
+	}
+
+	for _, repoTag := range inspect.RepoTags {
+		c.images[repoTag] = inspect
+	}
+
+	return nil
+}
+
+var _ = Describe("local stages storage", func() {
+	var dockerClient *fakeDockerClient
+	var storage *LocalStagesStorage
+
+	stageLabels := func(projectName string) map[string]string {
+		return map[string]string{image.WerfLabel: projectName, image.WerfCacheVersionLabel: image.BuildCacheVersion}
+	}
+
+	BeforeEach(func() {
+		dockerClient = newFakeDockerClient()
+		storage = &LocalStagesStorage{dockerClient: dockerClient}
+
+		dockerClient.addImage(localStageImageName("project", "sig-1"), "sha256:1", stageLabels("project"))
+		dockerClient.addImage(localStageImageName("project", "sig-2"), "sha256:2", stageLabels("project"))
+		dockerClient.addImage(localStageImageName("other", "sig-3"), "sha256:3", stageLabels("other"))
+	})
+
+	It("gets the stage by the signature", func() {
+		stage, err := storage.GetStageBySignature("project", "sig-1")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(stage).Should(Equal(&StageDescription{
+			Signature: "sig-1",
+			ImageName: localStageImageName("project", "sig-1"),
+			ImageId:   "sha256:1",
+			ParentId:  "sha256:parent",
+			Labels:    stageLabels("project"),
+			Created:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		}))
+
+		stage, err = storage.GetStageBySignature("project", "sig-3")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(stage).Should(BeNil())
+	})
+
+	It("lists the stages of the project", func() {
+		stages, err := storage.GetStagesList("project")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		var signatures []string
+		for _, stage := range stages {
+			signatures = append(signatures, stage.Signature)
+		}
+		Ω(signatures).Should(ConsistOf("sig-1", "sig-2"))
+	})
+
+	It("removes the stages images", func() {
+		stages, err := storage.GetStagesList("project")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(storage.DeleteStages(DeleteStagesOptions{RmiForce: true}, stages...)).Should(Succeed())
+		Ω(dockerClient.rmiArgs).Should(HaveLen(1))
+		Ω(dockerClient.rmiArgs[0][0]).Should(Equal("--force"))
+		Ω(dockerClient.rmiArgs[0][1:]).Should(ConsistOf(localStageImageName("project", "sig-1"), localStageImageName("project", "sig-2")))
+
+		stages, err = storage.GetStagesList("project")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(stages).Should(BeEmpty())
+	})
+
+	Context("when the stage image is used by the container", func() {
+		var stages []*StageDescription
+
+		BeforeEach(func() {
+			dockerClient.containers = []types.Container{{ID: "container-id", Names: []string{"/app"}, ImageID: "sha256:1"}}
+
+			stage, err := storage.GetStageBySignature("project", "sig-1")
+			Ω(err).ShouldNot(HaveOccurred())
+			stages = []*StageDescription{stage}
+		})
+
+		It("fails", func() {
+			err := storage.DeleteStages(DeleteStagesOptions{}, stages...)
+			Ω(err).Should(HaveOccurred())
+			Ω(err.Error()).Should(ContainSubstring("used by container /app"))
+			Ω(dockerClient.rmiArgs).Should(BeEmpty())
+		})
+
+		It("skips the image", func() {
+			Ω(storage.DeleteStages(DeleteStagesOptions{SkipUsedImages: true}, stages...)).Should(Succeed())
+			Ω(dockerClient.rmiArgs).Should(BeEmpty())
+			Ω(dockerClient.removedContainers).Should(BeEmpty())
+		})
+
+		It("removes the container and the image", func() {
+			Ω(storage.DeleteStages(DeleteStagesOptions{RmContainersThatUseWerfImages: true}, stages...)).Should(Succeed())
+			Ω(dockerClient.removedContainers).Should(Equal([]string{"container-id"}))
+			Ω(dockerClient.rmiArgs).Should(Equal([][]string{{localStageImageName("project", "sig-1")}}))
+		})
+	})
+})
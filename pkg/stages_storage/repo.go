@@ -0,0 +1,218 @@
+package stages_storage
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/flant/logboek"
+
+	"github.com/flant/werf/pkg/docker_registry"
+	"github.com/flant/werf/pkg/image"
+	"github.com/flant/werf/pkg/util"
+)
+
+type RepoStagesStorage struct {
+	Repository string
+
+	// tags are cached tags of the repository, which are used by concurrently built images
+	tags      []string
+	tagsMutex sync.Mutex
+}
+
+func NewRepoStagesStorage(repository string) *RepoStagesStorage {
+	return &RepoStagesStorage{Repository: repository}
+}
+
+func (storage *RepoStagesStorage) String() string {
+	return storage.Repository
+}
+
+func (storage *RepoStagesStorage) IsDistributed() bool {
+	return true
+}
+
+// ConstructStageImageName returns the name of the local docker image: the stages are built and used locally
+// and are synchronized with the repo by the FetchStage and StoreStage methods
+func (storage *RepoStagesStorage) ConstructStageImageName(projectName, signature string) string {
+	return localStageImageName(projectName, signature)
+}
+
+func (storage *RepoStagesStorage) GetStageBySignature(projectName, signature string) (*StageDescription, error) {
+	if exist, err := storage.isStageExist(signature); err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, nil
+	}
+
+	repoImage, err := docker_registry.GetRepoImage(storage.Repository, storage.stageTag(signature))
+	if err != nil {
+		return nil, err
+	}
+
+	stage, err := storage.stageDescriptionByRepoImage(*repoImage)
+	if err != nil {
+		return nil, err
+	}
+
+	if stage.Labels[image.WerfLabel] != projectName {
+		return nil, nil
+	}
+
+	return stage, nil
+}
+
+func (storage *RepoStagesStorage) FetchStage(_, signature string, img *image.StageImage) error {
+	stageImageName := storage.stageImageName(signature)
+
+	logProcessMsg := fmt.Sprintf("Fetching stage %s from stages storage", stageImageName)
+	if err := logboek.LogProcess(logProcessMsg, logboek.LogProcessOptions{}, func() error {
+		return img.Import(stageImageName)
+	}); err != nil {
+		return fmt.Errorf("unable to fetch stage %s from stages storage: %s", stageImageName, err)
+	}
+
+	return nil
+}
+
+func (storage *RepoStagesStorage) StoreStage(_, signature string, img *image.StageImage) error {
+	if exist, err := storage.isStageExist(signature); err != nil {
+		return err
+	} else if exist {
+		return nil
+	}
+
+	stageImageName := storage.stageImageName(signature)
+
+	successInfoSectionFunc := func() {
+		_ = logboek.WithIndent(func() error {
+			logboek.LogInfoF("stages-storage: %s\n", storage.Repository)
+			logboek.LogInfoF("         image: %s\n", stageImageName)
+
+			return nil
+		})
+	}
+
+	logProcessOptions := logboek.LogProcessOptions{SuccessInfoSectionFunc: successInfoSectionFunc, ColorizeMsgFunc: logboek.ColorizeHighlight}
+	if err := logboek.LogProcess("Storing stage into stages storage", logProcessOptions, func() error {
+		return img.Export(stageImageName)
+	}); err != nil {
+		return fmt.Errorf("unable to store stage %s into stages storage: %s", stageImageName, err)
+	}
+
+	storage.tagsMutex.Lock()
+	storage.tags = append(storage.tags, storage.stageTag(signature))
+	storage.tagsMutex.Unlock()
+
+	return nil
+}
+
+func (storage *RepoStagesStorage) GetStagesList(projectName string) ([]*StageDescription, error) {
+	repoImages, err := docker_registry.ImagesByWerfImageLabel(storage.Repository, "false")
+	if err != nil {
+		return nil, err
+	}
+
+	var stages []*StageDescription
+	for _, repoImage := range repoImages {
+		if !strings.HasPrefix(repoImage.Tag, storage.stageTag("")) {
+			continue
+		}
+
+		stage, err := storage.stageDescriptionByRepoImage(repoImage)
+		if err != nil {
+			return nil, err
+		}
+
+		if stage.Labels[image.WerfLabel] != projectName {
+			continue
+		}
+
+		stages = append(stages, stage)
+	}
+
+	return stages, nil
+}
+
+func (storage *RepoStagesStorage) DeleteStages(options DeleteStagesOptions, stages ...*StageDescription) error {
+	isDeleteByTag, err := docker_registry.IsDeleteByTag(storage.Repository)
+	if err != nil {
+		return err
+	}
+
+	for _, stage := range stages {
+		reference := stage.ImageName
+		if !isDeleteByTag {
+			digest, err := docker_registry.ImageDigest(stage.ImageName)
+			if err != nil {
+				return err
+			}
+
+			reference = strings.Join([]string{storage.Repository, digest}, "@")
+		}
+
+		logboek.LogLn(reference)
+
+		if !options.DryRun {
+			if err := docker_registry.ImageDelete(reference); err != nil {
+				return err
+			}
+
+			storage.tagsMutex.Lock()
+			storage.tags = util.ExcludeFromStringArray(storage.tags, storage.stageTag(stage.Signature))
+			storage.tagsMutex.Unlock()
+		}
+
+		if !isDeleteByTag {
+			logboek.LogInfoF("  tag: %s\n", storage.stageTag(stage.Signature))
+			logboek.LogOptionalLn()
+		}
+	}
+
+	return nil
+}
+
+func (storage *RepoStagesStorage) isStageExist(signature string) (bool, error) {
+	storage.tagsMutex.Lock()
+	defer storage.tagsMutex.Unlock()
+
+	if storage.tags == nil {
+		tags, err := docker_registry.Tags(storage.Repository)
+		if err != nil {
+			return false, fmt.Errorf("error fetching stages storage %s tags: %s", storage.Repository, err)
+		}
+
+		storage.tags = append([]string{}, tags...)
+	}
+
+	return util.IsStringsContainValue(storage.tags, storage.stageTag(signature)), nil
+}
+
+func (storage *RepoStagesStorage) stageTag(signature string) string {
+	return fmt.Sprintf(image.RepoImageStageTagFormat, signature)
+}
+
+func (storage *RepoStagesStorage) stageImageName(signature string) string {
+	return strings.Join([]string{storage.Repository, storage.stageTag(signature)}, ":")
+}
+
+func (storage *RepoStagesStorage) stageDescriptionByRepoImage(repoImage docker_registry.RepoImage) (*StageDescription, error) {
+	manifest, err := repoImage.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	configFile, err := repoImage.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	return &StageDescription{
+		Signature: strings.TrimPrefix(repoImage.Tag, storage.stageTag("")),
+		ImageName: strings.Join([]string{repoImage.Repository, repoImage.Tag}, ":"),
+		ImageId:   manifest.Config.Digest.String(),
+		ParentId:  configFile.ContainerConfig.Image,
+		Labels:    configFile.Config.Labels,
+		Created:   configFile.Created.Time,
+	}, nil
+}
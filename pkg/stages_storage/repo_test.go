@@ -0,0 +1,217 @@
+package stages_storage
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flant/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/flant/werf/pkg/image"
+	"github.com/flant/werf/pkg/werf"
+)
+
+type testBlob struct {
+	mediaType types.MediaType
+	data      []byte
+	digest    string
+}
+
+func newTestBlob(mediaType types.MediaType, obj interface{}) *testBlob {
+	data, err := json.Marshal(obj)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	return &testBlob{mediaType: mediaType, data: data, digest: fmt.Sprintf("sha256:%x", sha256.Sum256(data))}
+}
+
+// testRegistry is the stand-in of the docker registry API for the single repository "app"
+type testRegistry struct {
+	blobs     map[string]*testBlob
+	manifests map[string]*testBlob // by tag
+	deleted   []string
+
+	mutex sync.Mutex
+}
+
+func (r *testRegistry) addImage(tag string, labels map[string]string) *testBlob {
+	config := newTestBlob(types.DockerConfigJSON, map[string]interface{}{
+		"architecture":     "amd64",
+		"os":               "linux",
+		"author":           tag,
+		"created":          time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		"config":           map[string]interface{}{"Labels": labels},
+		"container_config": map[string]interface{}{"Image": "sha256:parent"},
+		"rootfs":           map[string]interface{}{"type": "layers", "diff_ids": []string{}},
+	})
+	r.blobs[config.digest] = config
+
+	r.manifests[tag] = newTestBlob(types.DockerManifestSchema2, map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     types.DockerManifestSchema2,
+		"config":        map[string]interface{}{"mediaType": config.mediaType, "size": len(config.data), "digest": config.digest},
+		"layers":        []interface{}{},
+	})
+
+	return config
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var b *testBlob
+	switch {
+	case req.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+		return
+	case req.URL.Path == "/v2/app/tags/list":
+		var tags []string
+		for tag := range r.manifests {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"name": "app", "tags": tags})
+		return
+	case strings.HasPrefix(req.URL.Path, "/v2/app/manifests/"):
+		reference := strings.TrimPrefix(req.URL.Path, "/v2/app/manifests/")
+		for tag, m := range r.manifests {
+			if tag == reference || m.digest == reference {
+				if req.Method == http.MethodDelete {
+					delete(r.manifests, tag)
+					r.deleted = append(r.deleted, reference)
+					continue
+				}
+
+				b = m
+			}
+		}
+
+		if req.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+	case strings.HasPrefix(req.URL.Path, "/v2/app/blobs/"):
+		b = r.blobs[strings.TrimPrefix(req.URL.Path, "/v2/app/blobs/")]
+	}
+
+	if b == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", string(b.mediaType))
+	w.Header().Set("Docker-Content-Digest", b.digest)
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(b.data)))
+	if req.Method != http.MethodHead {
+		_, _ = w.Write(b.data)
+	}
+}
+
+var _ = Describe("repo stages storage", func() {
+	var homeDir string
+	var registry *testRegistry
+	var server *httptest.Server
+	var repository string
+	var storage *RepoStagesStorage
+
+	stageLabels := func(projectName string) map[string]string {
+		return map[string]string{image.WerfLabel: projectName, image.WerfImageLabel: "false"}
+	}
+
+	BeforeEach(func() {
+		var err error
+		homeDir, err = ioutil.TempDir("", "werf-repo-stages-storage-test")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(werf.Init(homeDir, homeDir)).Should(Succeed())
+
+		registry = &testRegistry{blobs: map[string]*testBlob{}, manifests: map[string]*testBlob{}}
+		server = httptest.NewServer(registry)
+
+		repository = strings.TrimPrefix(server.URL, "http://") + "/app"
+		storage = NewRepoStagesStorage(repository)
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Ω(os.RemoveAll(homeDir)).Should(Succeed())
+	})
+
+	It("gets the stage by the signature", func() {
+		config := registry.addImage(storage.stageTag("sig-1"), stageLabels("project"))
+		registry.addImage(storage.stageTag("sig-2"), stageLabels("other"))
+
+		stage, err := storage.GetStageBySignature("project", "sig-1")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(stage).Should(Equal(&StageDescription{
+			Signature: "sig-1",
+			ImageName: storage.stageImageName("sig-1"),
+			ImageId:   config.digest,
+			ParentId:  "sha256:parent",
+			Labels:    stageLabels("project"),
+			Created:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		}))
+
+		stage, err = storage.GetStageBySignature("project", "sig-2")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(stage).Should(BeNil())
+
+		stage, err = storage.GetStageBySignature("project", "sig-3")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(stage).Should(BeNil())
+	})
+
+	It("does not store the existing stage", func() {
+		registry.addImage(storage.stageTag("sig"), stageLabels("project"))
+
+		Ω(storage.StoreStage("project", "sig", image.NewStageImage(nil, storage.ConstructStageImageName("project", "sig")))).Should(Succeed())
+	})
+
+	It("lists the stages of the project", func() {
+		registry.addImage(storage.stageTag("sig-1"), stageLabels("project"))
+		registry.addImage(storage.stageTag("sig-2"), stageLabels("project"))
+		registry.addImage(storage.stageTag("sig-3"), stageLabels("other"))
+		registry.addImage("latest", map[string]string{image.WerfLabel: "project", image.WerfImageLabel: "true"})
+
+		stages, err := storage.GetStagesList("project")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		var signatures []string
+		for _, stage := range stages {
+			signatures = append(signatures, stage.Signature)
+		}
+		Ω(signatures).Should(ConsistOf("sig-1", "sig-2"))
+	})
+
+	It("deletes the stages by the digest", func() {
+		registry.addImage(storage.stageTag("sig-1"), stageLabels("project"))
+		registry.addImage(storage.stageTag("sig-2"), stageLabels("project"))
+		manifestDigest := registry.manifests[storage.stageTag("sig-1")].digest
+
+		stage, err := storage.GetStageBySignature("project", "sig-1")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(storage.DeleteStages(DeleteStagesOptions{DryRun: true}, stage)).Should(Succeed())
+		Ω(registry.deleted).Should(BeEmpty())
+
+		Ω(storage.DeleteStages(DeleteStagesOptions{}, stage)).Should(Succeed())
+		Ω(registry.deleted).Should(Equal([]string{manifestDigest}))
+		Ω(registry.manifests).ShouldNot(HaveKey(storage.stageTag("sig-1")))
+		Ω(registry.manifests).Should(HaveKey(storage.stageTag("sig-2")))
+
+		stage, err = storage.GetStageBySignature("project", "sig-1")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(stage).Should(BeNil())
+	})
+})
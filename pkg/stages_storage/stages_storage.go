@@ -0,0 +1,75 @@
+package stages_storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/flant/go-containerregistry/pkg/name"
+
+	"github.com/flant/werf/pkg/image"
+)
+
+const (
+	LocalStagesStorageAddress   = ":local"
+	ArchiveStagesStorageAddress = "archive:"
+)
+
+type StagesStorage interface {
+	String() string
+	IsDistributed() bool
+
+	ConstructStageImageName(projectName, signature string) string
+
+	GetStageBySignature(projectName, signature string) (*StageDescription, error)
+	FetchStage(projectName, signature string, img *image.StageImage) error
+	StoreStage(projectName, signature string, img *image.StageImage) error
+
+	GetStagesList(projectName string) ([]*StageDescription, error)
+	DeleteStages(options DeleteStagesOptions, stages ...*StageDescription) error
+}
+
+type StageDescription struct {
+	Signature string
+	ImageName string
+	ImageId   string
+	ParentId  string
+	Labels    map[string]string
+	Created   time.Time
+}
+
+type DeleteStagesOptions struct {
+	DryRun                        bool
+	RmForce                       bool
+	RmiForce                      bool
+	SkipUsedImages                bool
+	RmContainersThatUseWerfImages bool
+}
+
+func NewStagesStorage(address string) (StagesStorage, error) {
+	switch {
+	case address == LocalStagesStorageAddress:
+		return NewLocalStagesStorage(), nil
+	case strings.HasPrefix(address, ArchiveStagesStorageAddress):
+		dir := strings.TrimPrefix(address, ArchiveStagesStorageAddress)
+		if dir == "" {
+			return nil, fmt.Errorf("archive stages storage directory should be specified: %sPATH", ArchiveStagesStorageAddress)
+		}
+
+		return NewArchiveStagesStorage(dir), nil
+	default:
+		if _, err := name.NewRepository(address, name.WeakValidation); err != nil {
+			return nil, err
+		}
+
+		return NewRepoStagesStorage(address), nil
+	}
+}
+
+func localStageImageName(projectName, signature string) string {
+	return fmt.Sprintf(image.LocalImageStageImageFormat, projectName, signature)
+}
+
+func stageSignatureByLocalImageName(projectName, imageName string) string {
+	return strings.TrimPrefix(imageName, fmt.Sprintf(image.LocalImageStageImageNameFormat, projectName)+":")
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/flant/logboek"
 
 	"github.com/flant/shluz"
+	"github.com/flant/werf/pkg/git_data_cache"
 	"github.com/flant/werf/pkg/util"
 	"github.com/flant/werf/pkg/werf"
 )
@@ -22,7 +23,9 @@ var (
 
 func runGC() error {
 	return shluz.WithLock("gc", shluz.LockOptions{}, func() error {
-		return GC(false)
+		return GC(GCOptions{
+			GitDataCache: git_data_cache.CleanupOptions{MaxAge: git_data_cache.DefaultMaxAge, MaxSize: git_data_cache.DefaultMaxSize},
+		})
 	})
 }
 
@@ -76,11 +79,30 @@ func checkShouldRunGC() (bool, error) {
 		}
 	}
 
-	return false, nil
+	// the size of git data cache is checked by the cleanup, walking the cache on each invocation is too expensive
+	return git_data_cache.ShouldCleanup()
 }
 
-func GC(dryRun bool) error {
-	return logboek.LogProcess("Running GC for tmp data", logboek.LogProcessOptions{}, func() error { return gc(dryRun) })
+type GCOptions struct {
+	GitDataCache git_data_cache.CleanupOptions
+	DryRun       bool
+}
+
+func GC(options GCOptions) error {
+	if err := logboek.LogProcess("Running GC for tmp data", logboek.LogProcessOptions{}, func() error { return gc(options.DryRun) }); err != nil {
+		return err
+	}
+
+	return logboek.LogProcess("Running GC for git data cache", logboek.LogProcessOptions{}, func() error {
+		gitDataCacheOptions := options.GitDataCache
+		gitDataCacheOptions.DryRun = options.DryRun
+
+		if err := git_data_cache.Cleanup(gitDataCacheOptions); err != nil {
+			return fmt.Errorf("git data cache cleanup failed: %s", err)
+		}
+
+		return nil
+	})
 }
 
 func gc(dryRun bool) error {
@@ -24,6 +24,10 @@ import (
 type ArchiveOptions struct {
 	Commit     string
 	PathFilter PathFilter
+
+	// GetLFSObjectPath returns the path of the fetched LFS object.
+	// LFS pointer files are replaced with LFS objects content if specified
+	GetLFSObjectPath func(pointer *LFSPointer) (string, error)
 }
 
 type ArchiveDescriptor struct {
@@ -198,11 +202,31 @@ func writeArchive(out io.Writer, gitDir, workTreeCacheDir string, withSubmodules
 			return nil
 		}
 
+		contentPath, contentSize := absPath, info.Size()
+		if opts.GetLFSObjectPath != nil && info.Size() <= LFSPointerMaxSize {
+			data, err := ioutil.ReadFile(absPath)
+			if err != nil {
+				return fmt.Errorf("cannot read file %s: %s", absPath, err)
+			}
+
+			if pointer := ParseLFSPointer(data); pointer != nil {
+				contentPath, err = opts.GetLFSObjectPath(pointer)
+				if err != nil {
+					return fmt.Errorf("unable to get LFS object %s of file %s: %s", pointer.Oid, relPath, err)
+				}
+				contentSize = pointer.Size
+
+				if debugArchive() {
+					fmt.Printf("Smudged LFS pointer %s with object %s\n", relPath, contentPath)
+				}
+			}
+		}
+
 		err = tw.WriteHeader(&tar.Header{
 			Format:     tar.FormatGNU,
 			Name:       tarEntryName,
 			Mode:       int64(fileModeFromGit),
-			Size:       info.Size(),
+			Size:       contentSize,
 			ModTime:    info.ModTime(),
 			AccessTime: info.ModTime(),
 			ChangeTime: info.ModTime(),
@@ -211,9 +235,9 @@ func writeArchive(out io.Writer, gitDir, workTreeCacheDir string, withSubmodules
 			return fmt.Errorf("unable to write tar header for file %s: %s", tarEntryName, err)
 		}
 
-		file, err := os.Open(absPath)
+		file, err := os.Open(contentPath)
 		if err != nil {
-			return fmt.Errorf("unable to open file %s: %s", absPath, err)
+			return fmt.Errorf("unable to open file %s: %s", contentPath, err)
 		}
 
 		_, err = io.Copy(tw, file)
@@ -223,7 +247,7 @@ func writeArchive(out io.Writer, gitDir, workTreeCacheDir string, withSubmodules
 
 		err = file.Close()
 		if err != nil {
-			return fmt.Errorf("error closing file %s: %s", absPath, err)
+			return fmt.Errorf("error closing file %s: %s", contentPath, err)
 		}
 
 		if debugArchive() {
@@ -261,6 +285,7 @@ func gitWorkTreeFilesModes(repoDir, workTreeDir string, withSubmodules bool) (ma
 	outBuf := bytes.NewBuffer([]byte{})
 	errBuf := bytes.NewBuffer([]byte{})
 	cmd := exec.Command(execArgs[0], execArgs[1:]...)
+	cmd.Env = commandEnv(repoDir)
 	cmd.Stdout = outBuf
 	cmd.Stderr = errBuf
 
@@ -280,6 +305,7 @@ func gitWorkTreeFilesModes(repoDir, workTreeDir string, withSubmodules bool) (ma
 		outBuf := bytes.NewBuffer([]byte{})
 		errBuf := bytes.NewBuffer([]byte{})
 		cmd := exec.Command(execArgs[0], execArgs[1:]...)
+		cmd.Env = commandEnv(repoDir)
 		cmd.Stdout = outBuf
 		cmd.Stderr = errBuf
 		cmd.Dir = workTreeDir
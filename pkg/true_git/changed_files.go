@@ -0,0 +1,32 @@
+package true_git
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ChangedFilesSince lists files changed in HEAD since the merge base of HEAD and the ref (as git diff REF...HEAD does),
+// paths are relative to the work tree root
+func ChangedFilesSince(workTreeDir, ref string) ([]string, error) {
+	cmd := exec.Command("git", "diff", "--name-only", "-z", "--no-renames", fmt.Sprintf("%s...HEAD", ref), "--")
+	cmd.Dir = workTreeDir
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git diff %s...HEAD failed (the ref should exist and have the merge base with HEAD, shallow clone may require fetching of more history): %s\n%s", ref, err, stderr.String())
+	}
+
+	var files []string
+	for _, file := range strings.Split(stdout.String(), "\x00") {
+		if file != "" {
+			files = append(files, filepath.FromSlash(file))
+		}
+	}
+
+	return files, nil
+}
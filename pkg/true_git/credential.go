@@ -0,0 +1,144 @@
+package true_git
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// CredentialFill gets username and password for the url from the git credential helper.
+// Only helper specified is used: helpers of the user git config are not consulted
+func CredentialFill(remoteUrl, helper string) (string, string, error) {
+	u, err := url.Parse(remoteUrl)
+	if err != nil {
+		return "", "", fmt.Errorf("bad url: %s", err)
+	}
+
+	cmd := exec.Command("git", "-c", "credential.helper=", "-c", fmt.Sprintf("credential.helper=%s", helper), "credential", "fill")
+	cmd.Env = remoteCommandEnv("")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("protocol=%s\nhost=%s\n\n", u.Scheme, u.Host))
+
+	// output contains password, only stderr is shown on error
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return "", "", fmt.Errorf("git credential fill failed: %s\n%s", err, stderr.String())
+	}
+
+	var username, password string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		switch parts[0] {
+		case "username":
+			username = parts[1]
+		case "password":
+			password = parts[1]
+		}
+	}
+
+	if password == "" {
+		return "", "", fmt.Errorf("git credential helper %s returned no password for %s://%s", helper, u.Scheme, u.Host)
+	}
+
+	return username, password, nil
+}
+
+// SetCredentialsFromEnv configures the repo to take credentials of remotes from the specified env vars.
+// Helpers of the user git config are disabled for the repo, so that credentials are not stored anywhere
+func SetCredentialsFromEnv(repoDir, usernameEnv, passwordEnv string) error {
+	helper := fmt.Sprintf("!f() { test \"$1\" = get || exit 0; echo \"username=$%s\"; echo \"password=$%s\"; }; f", usernameEnv, passwordEnv)
+
+	// empty value resets the list of helpers
+	if err := setConfigValue(repoDir, "credential.helper", "", "--replace-all"); err != nil {
+		return err
+	}
+
+	return setConfigValue(repoDir, "credential.helper", helper, "--add")
+}
+
+// UnsetCredentials removes credential helpers configured by SetCredentialsFromEnv
+func UnsetCredentials(repoDir string) error {
+	return unsetConfigValue(repoDir, "credential.helper")
+}
+
+// SetCAFile sets CA bundle to verify certificates of the repo remotes, empty caFile unsets it
+func SetCAFile(repoDir, caFile string) error {
+	if caFile == "" {
+		return unsetConfigValue(repoDir, "http.sslCAInfo")
+	}
+
+	return setConfigValue(repoDir, "http.sslCAInfo", caFile, "--replace-all")
+}
+
+func setConfigValue(repoDir, key, value, mode string) error {
+	cmd := exec.Command("git", "--git-dir", repoDir, "config", mode, key, value)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git config %s failed: %s\n%s", key, err, output)
+	}
+
+	return nil
+}
+
+func unsetConfigValue(repoDir, key string) error {
+	cmd := exec.Command("git", "--git-dir", repoDir, "config", "--unset-all", key)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		// exit code 5 means the key is not set
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 5 {
+			return nil
+		}
+
+		return fmt.Errorf("git config --unset-all %s failed: %s\n%s", key, err, output)
+	}
+
+	return nil
+}
+
+var (
+	reposEnv      = map[string][]string{}
+	reposEnvMutex sync.Mutex
+)
+
+// SetRepoEnv sets extra env of the git commands of the repo, e.g. credentials referenced by SetCredentialsFromEnv.
+// Values are passed to the commands of the repo only and never set in the env of werf process
+func SetRepoEnv(repoDir string, env []string) {
+	reposEnvMutex.Lock()
+	defer reposEnvMutex.Unlock()
+
+	if len(env) == 0 {
+		delete(reposEnv, filepath.Clean(repoDir))
+	} else {
+		reposEnv[filepath.Clean(repoDir)] = env
+	}
+}
+
+// commandEnv returns env of the git command of the repo, empty repoDir means the command is not related to any repo
+func commandEnv(repoDir string) []string {
+	env := os.Environ()
+	if repoDir == "" {
+		return env
+	}
+
+	reposEnvMutex.Lock()
+	defer reposEnvMutex.Unlock()
+
+	return append(env, reposEnv[filepath.Clean(repoDir)]...)
+}
+
+// remoteCommandEnv disables git terminal prompts: credentials are never asked interactively
+func remoteCommandEnv(repoDir string) []string {
+	return append(workTreeCommandEnv(repoDir), "GIT_TERMINAL_PROMPT=0")
+}
@@ -0,0 +1,72 @@
+package true_git
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("credentials", func() {
+	DescribeTable("credential fill",
+		func(remoteUrl, helper, expectedUsername, expectedPassword, expectedError string) {
+			username, password, err := CredentialFill(remoteUrl, helper)
+			if expectedError != "" {
+				Ω(err).Should(HaveOccurred())
+				Ω(err.Error()).Should(ContainSubstring(expectedError))
+				return
+			}
+
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(username).Should(Equal(expectedUsername))
+			Ω(password).Should(Equal(expectedPassword))
+		},
+		Entry("helper command",
+			"https://git.example.com/group/repo.git",
+			`!f() { test "$1" = get && echo username=user && echo password=secret; }; f`,
+			"user", "secret", "",
+		),
+		Entry("helper gets protocol and host of the url",
+			"https://git.example.com:8443/group/repo.git",
+			`!f() { input=$(cat); echo username=$(echo "$input" | grep host=); echo password=$(echo "$input" | grep protocol=); }; f`,
+			"host=git.example.com:8443", "protocol=https", "",
+		),
+		Entry("password with equal sign",
+			"https://git.example.com/group/repo.git",
+			`!f() { echo username=user; echo password=a=b; }; f`,
+			"user", "a=b", "",
+		),
+		Entry("no password is not prompted",
+			"https://git.example.com/group/repo.git",
+			`!f() { echo username=user; }; f`,
+			"", "", "terminal prompts disabled",
+		),
+		Entry("failed helper",
+			"https://git.example.com/group/repo.git",
+			`!f() { echo broken >&2; exit 1; }; f`,
+			"", "", "git credential fill failed",
+		),
+		Entry("bad url",
+			"https://git.example.com:port/group/repo.git",
+			`!f() { echo password=secret; }; f`,
+			"", "", "bad url",
+		),
+	)
+
+	It("passes repo env to the repo git commands only", func() {
+		Ω(os.Unsetenv("WERF_TEST_REPO_PASSWORD")).Should(Succeed())
+
+		SetRepoEnv("/repos/a/", []string{"WERF_TEST_REPO_PASSWORD=secret"})
+		defer SetRepoEnv("/repos/a", nil)
+
+		Ω(commandEnv("/repos/a")).Should(ContainElement("WERF_TEST_REPO_PASSWORD=secret"))
+		Ω(remoteCommandEnv("/repos/a")).Should(ContainElement("WERF_TEST_REPO_PASSWORD=secret"))
+		Ω(commandEnv("/repos/b")).ShouldNot(ContainElement("WERF_TEST_REPO_PASSWORD=secret"))
+		Ω(commandEnv("")).ShouldNot(ContainElement("WERF_TEST_REPO_PASSWORD=secret"))
+		Ω(os.Getenv("WERF_TEST_REPO_PASSWORD")).Should(BeEmpty())
+
+		SetRepoEnv("/repos/a", nil)
+		Ω(commandEnv("/repos/a")).ShouldNot(ContainElement("WERF_TEST_REPO_PASSWORD=secret"))
+	})
+})
@@ -35,6 +35,7 @@ const (
 
 type diffParser struct {
 	PathFilter PathFilter
+	LFS        bool
 
 	Out                 io.Writer
 	OutLines            uint
@@ -163,7 +164,7 @@ func (p *diffParser) handleDiffLine(line string) error {
 		if strings.HasPrefix(line, "Submodule ") {
 			return p.handleSubmoduleLine(line)
 		}
-		return p.writeOutLine(line)
+		return p.handleDiffBodyLine(line)
 
 	case newFileDiff:
 		if strings.HasPrefix(line, "+++ ") {
@@ -181,7 +182,7 @@ func (p *diffParser) handleDiffLine(line string) error {
 		if strings.HasPrefix(line, "Submodule ") {
 			return p.handleSubmoduleLine(line)
 		}
-		return p.writeOutLine(line)
+		return p.handleDiffBodyLine(line)
 
 	case deleteFileDiff:
 		if strings.HasPrefix(line, "--- ") {
@@ -199,7 +200,7 @@ func (p *diffParser) handleDiffLine(line string) error {
 		if strings.HasPrefix(line, "Submodule ") {
 			return p.handleSubmoduleLine(line)
 		}
-		return p.writeOutLine(line)
+		return p.handleDiffBodyLine(line)
 
 	case diffBody:
 		if strings.HasPrefix(line, "diff --git ") {
@@ -208,7 +209,7 @@ func (p *diffParser) handleDiffLine(line string) error {
 		if strings.HasPrefix(line, "Submodule ") {
 			return p.handleSubmoduleLine(line)
 		}
-		return p.writeOutLine(line)
+		return p.handleDiffBodyLine(line)
 	}
 
 	return nil
@@ -336,6 +337,16 @@ func (p *diffParser) handleDeleteFilePath(line string) error {
 	return p.writeOutLine(newLine)
 }
 
+func (p *diffParser) handleDiffBodyLine(line string) error {
+	if p.LFS && isLFSPointerDiffLine(line) {
+		for _, path := range p.LastSeenPaths {
+			p.BinaryPaths = appendUnique(p.BinaryPaths, path)
+		}
+	}
+
+	return p.writeOutLine(line)
+}
+
 func (p *diffParser) handleBinaryBeginHeader(line string) error {
 	for _, path := range p.LastSeenPaths {
 		p.BinaryPaths = appendUnique(p.BinaryPaths, path)
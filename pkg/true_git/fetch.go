@@ -0,0 +1,80 @@
+package true_git
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+type FetchOptions struct {
+	// Depth limits fetching to the specified number of commits from the tip of each refspec
+	Depth int
+	// Deepen deepens the history of the shallow repo by the specified number of commits
+	Deepen int
+	// Filter is the partial clone filter spec, e.g. blob:none
+	Filter string
+	NoTags bool
+
+	Refspecs []string
+}
+
+// Fetch runs 'git fetch' of the remote into the repo
+func Fetch(repoDir, remote string, opts FetchOptions) error {
+	gitArgs := []string{"--git-dir", repoDir, "fetch", "--force"}
+
+	if opts.Depth > 0 || opts.Deepen > 0 {
+		if err := checkShallowConstraint(); err != nil {
+			return err
+		}
+	}
+	if opts.Depth > 0 {
+		gitArgs = append(gitArgs, fmt.Sprintf("--depth=%d", opts.Depth))
+	}
+	if opts.Deepen > 0 {
+		gitArgs = append(gitArgs, fmt.Sprintf("--deepen=%d", opts.Deepen))
+	}
+	if opts.Filter != "" {
+		if err := checkPartialCloneConstraint(); err != nil {
+			return err
+		}
+		gitArgs = append(gitArgs, fmt.Sprintf("--filter=%s", opts.Filter))
+	}
+	if opts.NoTags {
+		gitArgs = append(gitArgs, "--no-tags")
+	}
+
+	gitArgs = append(gitArgs, remote)
+	gitArgs = append(gitArgs, opts.Refspecs...)
+
+	cmd := exec.Command("git", gitArgs...)
+	cmd.Env = remoteCommandEnv(repoDir)
+	output := setCommandRecordingLiveOutput(cmd)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git fetch failed: %s\n%s", err, output.String())
+	}
+
+	return nil
+}
+
+// GetRemoteHeadBranch returns the branch HEAD of the remote points to
+func GetRemoteHeadBranch(repoDir, remote string) (string, error) {
+	cmd := exec.Command("git", "--git-dir", repoDir, "ls-remote", "--symref", remote, "HEAD")
+	cmd.Env = remoteCommandEnv(repoDir)
+
+	output := &bytes.Buffer{}
+	cmd.Stdout = output
+	cmd.Stderr = errStream
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git ls-remote failed: %s", err)
+	}
+
+	for _, line := range strings.Split(output.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "ref:" && fields[2] == "HEAD" && strings.HasPrefix(fields[1], "refs/heads/") {
+			return strings.TrimPrefix(fields[1], "refs/heads/"), nil
+		}
+	}
+
+	return "", fmt.Errorf("remote %s HEAD does not point to a branch", remote)
+}
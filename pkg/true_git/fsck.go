@@ -35,6 +35,7 @@ func Fsck(repoDir string, opts FsckOptions) (FsckResult, error) {
 	}
 
 	cmd := exec.Command("git", gitArgs...)
+	cmd.Env = commandEnv(repoDir)
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
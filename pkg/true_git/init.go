@@ -13,8 +13,10 @@ import (
 )
 
 const (
-	MinGitVersionConstraintValue               = "1.9"
-	MinGitVersionWithSubmodulesConstraintValue = "2.14"
+	MinGitVersionConstraintValue                 = "1.9"
+	MinGitVersionWithSubmodulesConstraintValue   = "2.14"
+	MinGitVersionWithShallowConstraintValue      = "2.11"
+	MinGitVersionWithPartialCloneConstraintValue = "2.20"
 )
 
 var (
@@ -27,6 +29,8 @@ var (
 	minGitVersionErrorMsg       = fmt.Sprintf("Git version >= %s required", MinGitVersionConstraintValue)
 	forbiddenGitVersionErrorMsg = fmt.Sprintf("Forbidden git versions: %s", strings.Join(ForbiddenGitVersionsConstraintValues, ", "))
 	submodulesVersionErrorMsg   = fmt.Sprintf("To use git submodules install git >= %s", MinGitVersionWithSubmodulesConstraintValue)
+	shallowVersionErrorMsg      = fmt.Sprintf("To use shallow fetch install git >= %s", MinGitVersionWithShallowConstraintValue)
+	partialCloneVersionErrorMsg = fmt.Sprintf("To use partial clone install git >= %s", MinGitVersionWithPartialCloneConstraintValue)
 
 	outStream, errStream io.Writer
 )
@@ -140,14 +144,26 @@ func checkVersionConstraints() error {
 }
 
 func checkSubmoduleConstraint() error {
-	constraint, err := semver.NewConstraint(fmt.Sprintf(">= %s", MinGitVersionWithSubmodulesConstraintValue))
+	return checkMinVersionConstraint(MinGitVersionWithSubmodulesConstraintValue, submodulesVersionErrorMsg)
+}
+
+func checkShallowConstraint() error {
+	return checkMinVersionConstraint(MinGitVersionWithShallowConstraintValue, shallowVersionErrorMsg)
+}
+
+func checkPartialCloneConstraint() error {
+	return checkMinVersionConstraint(MinGitVersionWithPartialCloneConstraintValue, partialCloneVersionErrorMsg)
+}
+
+func checkMinVersionConstraint(minVersion, versionErrorMsg string) error {
+	constraint, err := semver.NewConstraint(fmt.Sprintf(">= %s", minVersion))
 	if err != nil {
 		panic(err)
 	}
 
 	if !constraint.Check(gitVersion) {
 		errMsg := strings.Join([]string{
-			strings.ToLower(submodulesVersionErrorMsg),
+			strings.ToLower(versionErrorMsg),
 			fmt.Sprintf("Your git version is %s", gitVersion.String()),
 		}, ".\n")
 
@@ -0,0 +1,75 @@
+package true_git
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	// LFSPointerMaxSize is the max size of the LFS pointer file, bigger files are not checked
+	LFSPointerMaxSize = 1024
+
+	lfsPointerVersionLine = "version https://git-lfs.github.com/spec/v1"
+	lfsOidPrefix          = "sha256:"
+)
+
+type LFSPointer struct {
+	Oid  string
+	Size int64
+}
+
+// ParseLFSPointer returns nil if data is not the LFS pointer file content
+func ParseLFSPointer(data []byte) *LFSPointer {
+	if len(data) > LFSPointerMaxSize {
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) < 3 || lines[0] != lfsPointerVersionLine {
+		return nil
+	}
+
+	pointer := &LFSPointer{Size: -1}
+	for _, line := range lines[1:] {
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			return nil
+		}
+
+		switch parts[0] {
+		case "oid":
+			if !strings.HasPrefix(parts[1], lfsOidPrefix) {
+				return nil
+			}
+
+			oid := strings.TrimPrefix(parts[1], lfsOidPrefix)
+			if len(oid) != 64 || strings.Trim(oid, "0123456789abcdef") != "" {
+				return nil
+			}
+
+			pointer.Oid = oid
+		case "size":
+			size, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil || size < 0 {
+				return nil
+			}
+
+			pointer.Size = size
+		}
+	}
+
+	if pointer.Oid == "" || pointer.Size == -1 {
+		return nil
+	}
+
+	return pointer
+}
+
+// isLFSPointerDiffLine detects the LFS pointer file in the diff by the first pointer line
+func isLFSPointerDiffLine(line string) bool {
+	if len(line) == 0 || !strings.ContainsRune("+- ", rune(line[0])) {
+		return false
+	}
+
+	return line[1:] == lfsPointerVersionLine
+}
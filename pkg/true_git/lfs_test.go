@@ -0,0 +1,20 @@
+package true_git
+
+import (
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+const lfsTestOid = "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393"
+
+var _ = DescribeTable("LFS pointer parsing",
+	func(data string, expectedPointer *LFSPointer) {
+		Ω(ParseLFSPointer([]byte(data))).Should(Equal(expectedPointer))
+	},
+	Entry("pointer", "version https://git-lfs.github.com/spec/v1\noid sha256:"+lfsTestOid+"\nsize 12345\n", &LFSPointer{Oid: lfsTestOid, Size: 12345}),
+	Entry("pointer with extra keys", "version https://git-lfs.github.com/spec/v1\next-0-foo sha256:"+lfsTestOid+"\noid sha256:"+lfsTestOid+"\nsize 0\n", &LFSPointer{Oid: lfsTestOid, Size: 0}),
+	Entry("pointer without size", "version https://git-lfs.github.com/spec/v1\noid sha256:"+lfsTestOid+"\n", (*LFSPointer)(nil)),
+	Entry("pointer with bad oid", "version https://git-lfs.github.com/spec/v1\noid sha256:abc\nsize 1\n", (*LFSPointer)(nil)),
+	Entry("pointer with unknown version", "version https://hawser.github.com/spec/v1\noid sha256:"+lfsTestOid+"\nsize 1\n", (*LFSPointer)(nil)),
+	Entry("ordinary file", "version https://git-lfs.github.com/spec/v1\n", (*LFSPointer)(nil)),
+)
@@ -16,6 +16,12 @@ type PatchOptions struct {
 
 	WithEntireFileContext bool
 	WithBinary            bool
+
+	// LFS pointers changes are treated as binary: the content of LFS objects cannot be patched
+	LFS bool
+
+	// LimitToBasePath limits git diff to the base path of the path filter: blobs of other files may be absent in the partial clone
+	LimitToBasePath bool
 }
 
 type PatchDescriptor struct {
@@ -85,6 +91,13 @@ func writePatch(out io.Writer, gitDir, workTreeCacheDir string, withSubmodules b
 		diffOpts = append(diffOpts, "--binary")
 	}
 
+	// Files out of the base path are skipped by parser anyway, base path is the literal path, not the glob
+	var pathspecArgs []string
+	if opts.LimitToBasePath && !withSubmodules && opts.PathFilter.BasePath != "" {
+		commonGitOpts = append(commonGitOpts, "--literal-pathspecs")
+		pathspecArgs = append(pathspecArgs, "--", filepath.ToSlash(opts.PathFilter.BasePath))
+	}
+
 	var cmd *exec.Cmd
 
 	if withSubmodules {
@@ -105,16 +118,19 @@ func writePatch(out io.Writer, gitDir, workTreeCacheDir string, withSubmodules b
 		cmd = exec.Command("git", gitArgs...)
 
 		cmd.Dir = workTreeDir // required for `git diff` with submodules
+		cmd.Env = commandEnv(gitDir)
 	} else {
 		gitArgs := append(commonGitOpts, "diff")
 		gitArgs = append(gitArgs, diffOpts...)
 		gitArgs = append(gitArgs, opts.FromCommit, opts.ToCommit)
+		gitArgs = append(gitArgs, pathspecArgs...)
 
 		if debugPatch() {
 			fmt.Printf("# git %s\n", strings.Join(gitArgs, " "))
 		}
 
 		cmd = exec.Command("git", gitArgs...)
+		cmd.Env = commandEnv(gitDir)
 	}
 
 	stdoutPipe, err := cmd.StdoutPipe()
@@ -182,6 +198,7 @@ func writePatch(out io.Writer, gitDir, workTreeCacheDir string, withSubmodules b
 	}
 
 	p := makeDiffParser(out, opts.PathFilter)
+	p.LFS = opts.LFS
 
 WaitForData:
 	for {
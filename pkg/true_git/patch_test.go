@@ -0,0 +1,77 @@
+package true_git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("patch", func() {
+	var repoDir string
+	var fromCommit, toCommit string
+
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=werf", "-c", "user.email=werf@example.com"}, args...)...)
+		cmd.Dir = repoDir
+		output, err := cmd.CombinedOutput()
+		Ω(err).ShouldNot(HaveOccurred(), string(output))
+		return strings.TrimSpace(string(output))
+	}
+
+	writeFiles := func(content string, paths ...string) {
+		for _, path := range paths {
+			Ω(os.MkdirAll(filepath.Join(repoDir, filepath.Dir(path)), os.ModePerm)).Should(Succeed())
+			Ω(ioutil.WriteFile(filepath.Join(repoDir, path), []byte(content), 0644)).Should(Succeed())
+		}
+	}
+
+	paths := []string{"app[1]/file", "app1/file", "other/file"}
+
+	BeforeEach(func() {
+		var err error
+		repoDir, err = ioutil.TempDir("", "werf-patch-test")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		git("init", "-q")
+		writeFiles("a\n", paths...)
+		git("add", "-A")
+		git("commit", "-q", "-m", "first")
+		fromCommit = git("rev-parse", "HEAD")
+
+		writeFiles("b\n", paths...)
+		git("add", "-A")
+		git("commit", "-q", "-m", "second")
+		toCommit = git("rev-parse", "HEAD")
+	})
+
+	AfterEach(func() {
+		Ω(os.RemoveAll(repoDir)).Should(Succeed())
+	})
+
+	DescribeTable("contains the files of the literal base path only",
+		func(limitToBasePath bool) {
+			out := &bytes.Buffer{}
+			desc, err := Patch(out, filepath.Join(repoDir, ".git"), PatchOptions{
+				FromCommit:      fromCommit,
+				ToCommit:        toCommit,
+				PathFilter:      PathFilter{BasePath: "app[1]"},
+				LimitToBasePath: limitToBasePath,
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(desc.Paths).Should(HaveLen(1))
+			Ω(out.String()).Should(ContainSubstring("-a\n+b\n"))
+			Ω(out.String()).ShouldNot(ContainSubstring("app1"))
+			Ω(out.String()).ShouldNot(ContainSubstring("other"))
+		},
+		Entry("full diff filtered by parser", false),
+		Entry("diff limited to the base path", true),
+	)
+})
@@ -17,6 +17,7 @@ func syncSubmodules(repoDir, workTreeDir string) error {
 		)
 
 		cmd.Dir = workTreeDir // required for `git submodule` to work
+		cmd.Env = commandEnv(repoDir)
 
 		output := setCommandRecordingLiveOutput(cmd)
 
@@ -42,6 +43,7 @@ func updateSubmodules(repoDir, workTreeDir string) error {
 		)
 
 		cmd.Dir = workTreeDir // required for `git submodule` to work
+		cmd.Env = workTreeCommandEnv(repoDir)
 
 		output := setCommandRecordingLiveOutput(cmd)
 
@@ -177,6 +177,7 @@ func switchWorkTree(repoDir, workTreeDir string, commit string, withSubmodules b
 		"git", "-c", "core.autocrlf=false", "--git-dir", repoDir, "--work-tree", workTreeDir,
 		"reset", "--hard", commit,
 	)
+	cmd.Env = workTreeCommandEnv(repoDir)
 	output = setCommandRecordingLiveOutput(cmd)
 	if debugWorktreeSwitch() {
 		fmt.Printf("[DEBUG WORKTREE SWITCH] %s\n", strings.Join(append([]string{cmd.Path}, cmd.Args[1:]...), " "))
@@ -190,6 +191,7 @@ func switchWorkTree(repoDir, workTreeDir string, commit string, withSubmodules b
 		"git", "--git-dir", repoDir, "--work-tree", workTreeDir,
 		"clean", "-d", "-f", "-f", "-x",
 	)
+	cmd.Env = commandEnv(repoDir)
 	output = setCommandRecordingLiveOutput(cmd)
 	if debugWorktreeSwitch() {
 		fmt.Printf("[DEBUG WORKTREE SWITCH] %s\n", strings.Join(append([]string{cmd.Path}, cmd.Args[1:]...), " "))
@@ -218,6 +220,7 @@ func switchWorkTree(repoDir, workTreeDir string, commit string, withSubmodules b
 			"git", "-c", "core.autocrlf=false", "reset", "--hard",
 		)
 		cmd.Dir = workTreeDir // required for `git submodule` to work
+		cmd.Env = workTreeCommandEnv(repoDir)
 		output = setCommandRecordingLiveOutput(cmd)
 		if debugWorktreeSwitch() {
 			fmt.Printf("[DEBUG WORKTREE SWITCH] %s\n", strings.Join(append([]string{cmd.Path}, cmd.Args[1:]...), " "))
@@ -233,6 +236,7 @@ func switchWorkTree(repoDir, workTreeDir string, commit string, withSubmodules b
 			"git", "clean", "-d", "-f", "-f", "-x",
 		)
 		cmd.Dir = workTreeDir // required for `git submodule` to work
+		cmd.Env = commandEnv(repoDir)
 		output = setCommandRecordingLiveOutput(cmd)
 		if debugWorktreeSwitch() {
 			fmt.Printf("[DEBUG WORKTREE SWITCH] %s\n", strings.Join(append([]string{cmd.Path}, cmd.Args[1:]...), " "))
@@ -246,10 +250,17 @@ func switchWorkTree(repoDir, workTreeDir string, commit string, withSubmodules b
 	return nil
 }
 
+// workTreeCommandEnv disables git-lfs smudge filter: work tree always contains LFS pointers,
+// LFS objects are fetched and smudged by werf on archive creation
+func workTreeCommandEnv(repoDir string) []string {
+	return append(commandEnv(repoDir), "GIT_LFS_SKIP_SMUDGE=1")
+}
+
 func GetRealRepoDir(repoDir string) (string, error) {
 	gitArgs := []string{"--git-dir", repoDir, "rev-parse", "--git-dir"}
 
 	cmd := exec.Command("git", gitArgs...)
+	cmd.Env = commandEnv(repoDir)
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
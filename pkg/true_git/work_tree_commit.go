@@ -0,0 +1,153 @@
+package true_git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const workTreeCommitMessage = "werf dev mode: working tree changes"
+
+type WorkTreeCommitOptions struct {
+	// IncludeUntracked adds untracked files not ignored by .gitignore
+	IncludeUntracked bool
+}
+
+// CreateWorkTreeCommit creates the commit of the working tree state on top of the head commit.
+// Index and refs of the repo are not changed: the commit is created using the copy of the index.
+// The same working tree state always results in the same commit, the head commit is returned if there are no changes
+func CreateWorkTreeCommit(workTreeDir, gitDir, headCommit string, opts WorkTreeCommitOptions) (string, error) {
+	indexPath, err := runGitCommand(workTreeDir, gitDir, nil, "rev-parse", "--git-path", "index")
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(indexPath) {
+		indexPath = filepath.Join(workTreeDir, indexPath)
+	}
+
+	tmpIndexFile, err := ioutil.TempFile("", "werf-dev-index-")
+	if err != nil {
+		return "", fmt.Errorf("unable to create tmp index file: %s", err)
+	}
+	tmpIndexPath := tmpIndexFile.Name()
+	defer os.Remove(tmpIndexPath)
+
+	isIndexCopied, err := copyIndex(indexPath, tmpIndexFile)
+	if err != nil {
+		tmpIndexFile.Close()
+		return "", err
+	}
+	if err := tmpIndexFile.Close(); err != nil {
+		return "", fmt.Errorf("unable to close tmp index file: %s", err)
+	}
+	if !isIndexCopied {
+		// git creates new index, but does not accept the empty file
+		if err := os.Remove(tmpIndexPath); err != nil {
+			return "", fmt.Errorf("unable to remove tmp index file: %s", err)
+		}
+	}
+
+	env := []string{fmt.Sprintf("GIT_INDEX_FILE=%s", tmpIndexPath)}
+
+	addArgs := []string{"add", "--update"}
+	if opts.IncludeUntracked {
+		addArgs = []string{"add", "--all"}
+	}
+	if _, err := runGitCommand(workTreeDir, gitDir, env, addArgs...); err != nil {
+		return "", err
+	}
+
+	tree, err := runGitCommand(workTreeDir, gitDir, env, "write-tree")
+	if err != nil {
+		return "", err
+	}
+
+	headTree, err := runGitCommand(workTreeDir, gitDir, nil, "rev-parse", fmt.Sprintf("%s^{tree}", headCommit))
+	if err != nil {
+		return "", err
+	}
+
+	if tree == headTree {
+		return headCommit, nil
+	}
+
+	// author and date are fixed to make commit id depend on the working tree state only
+	headDate, err := runGitCommand(workTreeDir, gitDir, nil, "log", "-1", "--format=%ct %cz", headCommit)
+	if err != nil {
+		return "", err
+	}
+
+	env = append(env,
+		"GIT_AUTHOR_NAME=werf", "GIT_AUTHOR_EMAIL=werf@localhost", fmt.Sprintf("GIT_AUTHOR_DATE=%s", headDate),
+		"GIT_COMMITTER_NAME=werf", "GIT_COMMITTER_EMAIL=werf@localhost", fmt.Sprintf("GIT_COMMITTER_DATE=%s", headDate),
+	)
+
+	commit, err := runGitCommand(workTreeDir, gitDir, env, "commit-tree", tree, "-p", headCommit, "-m", workTreeCommitMessage)
+	if err != nil {
+		return "", err
+	}
+
+	return commit, nil
+}
+
+func copyIndex(indexPath string, dst io.Writer) (bool, error) {
+	src, err := os.Open(indexPath)
+	if os.IsNotExist(err) {
+		// nothing is staged yet
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("unable to open index %s: %s", indexPath, err)
+	}
+	defer src.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return false, fmt.Errorf("unable to copy index %s: %s", indexPath, err)
+	}
+
+	return true, nil
+}
+
+func runGitCommand(workTreeDir, gitDir string, env []string, args ...string) (string, error) {
+	gitArgs := append([]string{"--git-dir", gitDir, "--work-tree", workTreeDir}, args...)
+
+	cmd := exec.Command("git", gitArgs...)
+	cmd.Dir = workTreeDir
+	cmd.Env = append(commandEnv(gitDir), env...)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s failed: %s\n%s", args[0], err, stderr.String())
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// ListWorkTreeFiles lists tracked files and untracked files not ignored by .gitignore of the dir,
+// paths are relative to the dir
+func ListWorkTreeFiles(dir string) ([]string, error) {
+	cmd := exec.Command("git", "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	cmd.Dir = dir
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git ls-files failed: %s\n%s", err, stderr.String())
+	}
+
+	var files []string
+	for _, file := range strings.Split(stdout.String(), "\x00") {
+		if file != "" {
+			files = append(files, filepath.FromSlash(file))
+		}
+	}
+
+	return files, nil
+}
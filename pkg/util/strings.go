@@ -42,3 +42,14 @@ func IsStringsContainValue(arr []string, value string) bool {
 	}
 	return false
 }
+
+func ExcludeFromStringArray(arr []string, values ...string) []string {
+	var res []string
+	for _, v := range arr {
+		if !IsStringsContainValue(values, v) {
+			res = append(res, v)
+		}
+	}
+
+	return res
+}